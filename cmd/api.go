@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/config"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service"
+	"debtster-export/internal/transport/auth"
+	"debtster-export/internal/transport/files"
+	httpmw "debtster-export/internal/transport/http"
+	"debtster-export/internal/transport/rest"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiServer is the HTTP/WS front: the REST API, /files, /ws and the optional
+// HTTP->HTTPS redirect.
+type apiServer struct {
+	srv        *http.Server
+	redirect   *http.Server
+	tokenUsage *auth.TokenUsage
+	// errs receives the result of the main listener once it stops
+	errs chan error
+}
+
+// startAPI builds the routers on c and starts listening; it exits on
+// configuration errors.
+func startAPI(ctx context.Context, c *core) *apiServer {
+	cfg := c.cfg
+	a := &apiServer{errs: make(chan error, 1)}
+
+	tokenRepo := repository.NewPersonalAccessTokenRepository(c.repoDB)
+	auth.SetDebug(cfg.AuthDebug)
+	// last use of personal access tokens, written in batches
+	if cfg.TokenUsageFlushSec > 0 {
+		a.tokenUsage = auth.NewTokenUsage(tokenRepo, time.Duration(cfg.TokenUsageFlushSec)*time.Second)
+		go a.tokenUsage.Run(ctx)
+	}
+	// other services authenticate with an X-Api-Key instead of a user token
+	apiKeys := initAPIKeys(cfg.APIKeys)
+	// the same-origin SPA may authenticate by its Laravel session cookie
+	sessions := initSessions(cfg, c.redis)
+	sanctumMiddleware := auth.APIKeyMiddleware(apiKeys, auth.SessionMiddleware(sessions, auth.SanctumMiddleware(tokenRepo, a.tokenUsage)))
+	// credentials are looked up in the database of the tenant the request
+	// names, but the tenant of the request is the one they are bound to
+	tenantHint := auth.TenantHint(c.tenants, cfg.TenantHeader)
+	tenantMiddleware := auth.TenantMiddleware(c.tenants, cfg.TenantHeader)
+	authMiddleware := func(next http.Handler) http.Handler {
+		return tenantHint(sanctumMiddleware(tenantMiddleware(next)))
+	}
+
+	columnMasks, err := service.ParseColumnMasks(cfg.ColumnMasks)
+	if err != nil {
+		log.Fatalf("EXPORT_COLUMN_MASKS: %v", err)
+	}
+
+	handler := rest.NewHandler(c.debtSvc, c.userSvc, c.actionSvc, c.paymentSvc, c.exportSvc)
+	handler.SetRateLimiters(
+		clients.NewRateLimiter(c.redis, "ip", cfg.RateLimit.IPPerMinute, cfg.RateLimit.IPBurst),
+		clients.NewRateLimiter(c.redis, "user", cfg.RateLimit.UserPerMinute, cfg.RateLimit.UserBurst),
+	)
+	handler.SetQuotaChecker(clients.NewStorageQuota(c.storageClient,
+		int64(cfg.StorageQuotaUserMB)<<20, int64(cfg.StorageQuotaTenantMB)<<20))
+	if c.diskWatch != nil {
+		handler.SetDiskSpaceChecker(c.diskWatch)
+	}
+	handler.SetNotificationSettings(c.notificationSettings, c.notifyChannels...)
+	// a batch lives in the memory of the instance running its exports, which
+	// an api instance hands to the workers one by one
+	if cfg.Mode != config.ModeAPI {
+		handler.SetExportBatches(c.batches)
+	}
+	handler.SetExportWatcher(c.watch)
+	handler.SetTemplateAdmin(c.templates)
+	handler.SetColumnMasks(columnMasks)
+	handler.SetWSConnections(c.wsHub)
+	handler.SetWSUpgrader(c.wsHub)
+	handler.SetFileUploader(c.storage)
+	uploadRepo := repository.NewUploadRepository(c.repoDB)
+	handler.SetUploadRegistry(uploadRepo)
+	handler.SetArchiveRestorer(c.storage)
+	handler.SetExportAdmin(c.exportSvc)
+	handler.SetMaintenanceSwitch(service.NewMaintenanceSwitch(c.redis))
+	handler.SetForwardedURLs(cfg.ForwardedURLs && cfg.ExternalURL == "")
+	router := handler.InitRouterWithAuth(authMiddleware)
+
+	// create a public root router and mount protected (auth) router underneath so
+	// /files and /health remain public while other routes remain protected
+	root := chi.NewRouter()
+
+	// public: serve generated files; a token is optional and only identifies the downloader
+	filesHandler := files.NewHandler(c.storage, c.exportSvc, c.tenants, cfg.FilesAllowedExtensions)
+	filesHandler.SetUploadOwners(uploadRepo)
+	root.With(tenantHint, auth.APIKeyMiddleware(apiKeys, auth.SessionMiddleware(sessions, auth.OptionalSanctumMiddleware(tokenRepo, a.tokenUsage)))).Get("/files/*", filesHandler.Download)
+
+	// protected websocket endpoint; browsers may offer the token as a subprotocol
+	wsAuth := auth.APIKeyMiddleware(apiKeys, auth.SessionMiddleware(sessions, auth.WSAuth(tokenRepo, a.tokenUsage)))
+	root.With(tenantHint, wsAuth, tenantMiddleware).Get("/ws", handler.ConnectWS)
+
+	// mount protected router on root
+	root.Mount("/", router)
+
+	// behind a reverse proxy under a subpath the routes answer with and without BASE_PATH
+	corsHandler := withCORS(withBasePath(root, cfg.BasePath), cfg.CORSAllowedOrigins, cfg.SessionAuth, cfg.TenantHeader)
+
+	// client addresses and forwarded schemes/hosts are believed from the configured proxies only
+	proxies, err := httpmw.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
+	tlsConfig, err := buildTLSConfig(cfg.TLS)
+	if err != nil {
+		log.Fatalf("tls config error: %v", err)
+	}
+
+	a.srv = &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      proxies.RealIP(corsHandler),
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// Run HTTP server in goroutine so we can listen for shutdown signals
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("HTTPS server listening on :%s\n", cfg.Port)
+			// the certificate is in tlsConfig already
+			err = a.srv.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("HTTP server listening on :%s\n", cfg.Port)
+			err = a.srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			a.errs <- err
+			return
+		}
+		a.errs <- nil
+	}()
+
+	// plain HTTP listener that only redirects to HTTPS
+	if tlsConfig != nil && cfg.TLS.RedirectAddr != "" {
+		a.redirect = &http.Server{
+			Addr:              cfg.TLS.RedirectAddr,
+			Handler:           httpsRedirect(cfg.Port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("HTTP->HTTPS redirect listening on %s\n", cfg.TLS.RedirectAddr)
+			if err := a.redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("redirect server error: %v", err)
+			}
+		}()
+	}
+	return a
+}
+
+// shutdown stops accepting requests, lets the ongoing ones finish until ctx is
+// done and writes the pending token usage.
+func (a *apiServer) shutdown(ctx context.Context) {
+	if err := a.srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server Shutdown error: %v", err)
+	}
+	if err := a.tokenUsage.Flush(ctx); err != nil {
+		log.Printf("token usage: %v", err)
+	}
+	if a.redirect != nil {
+		_ = a.redirect.Shutdown(ctx)
+	}
+}
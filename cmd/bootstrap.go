@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/config"
+	"debtster-export/internal/domain"
+	"debtster-export/internal/janitor"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service"
+	"debtster-export/internal/tenant"
+	"debtster-export/internal/transport/auth"
+	"debtster-export/internal/transport/debug"
+	"debtster-export/internal/transport/websocket"
+	"debtster-export/pkg/database/postgres"
+)
+
+// core is what every run mode shares: databases, Redis, export storage, the
+// export services with their job runner and queue, notifications and the
+// janitor. The API front (startAPI) and the queue consumer (startWorker) are
+// built on top of it.
+type core struct {
+	cfg config.AppConfig
+
+	db        *sql.DB
+	tenantDBs map[string]*sql.DB
+	repoDB    *repository.DB
+	tenants   *tenant.Registry
+	redis     *clients.RedisClient
+
+	storageClient   *clients.StorageClient
+	fallbackStorage *clients.StorageClient
+	storage         *clients.FailoverStorage
+	diskWatch       *clients.DiskWatchdog
+	templates       *clients.TemplateStorage
+	// retention of generated files; reloadable, see reload
+	fileRetention         atomic.Int64
+	undownloadedRetention atomic.Int64
+
+	// wsHub holds the WebSocket connections; nil on workers, which have none
+	wsHub                *websocket.Hub
+	notificationSettings *clients.CachedNotificationSettings
+	messengerNotifier    *clients.MessengerNotifier
+	emailNotifier        *clients.EmailNotifier
+	// channels users may pick in /me/notifications
+	notifyChannels []string
+	outbox         *service.Outbox
+	batches        *service.BatchNotifier
+	watch          *service.ExportWatch
+
+	userRepo   *repository.UserRepository
+	debtSvc    *service.DebtService
+	userSvc    *service.UserService
+	actionSvc  *service.ActionService
+	paymentSvc *service.PaymentService
+	exportSvc  *service.ExportService
+
+	jobRunner   *service.JobRunner
+	jobQueue    *service.JobQueue
+	maintenance *janitor.Janitor
+}
+
+// newCore wires the shared components on the opened databases; it exits on
+// configuration errors.
+func newCore(ctx context.Context, cfg config.AppConfig, db *sql.DB, tenantDBs map[string]*sql.DB, tenants *tenant.Registry) *core {
+	c := &core{cfg: cfg, db: db, tenantDBs: tenantDBs, tenants: tenants}
+
+	c.repoDB = repository.NewDB(db, tenantDBs)
+	c.repoDB.SetQueryLog(queryLog(cfg))
+
+	c.redis = mustInitRedis(cfg.Redis)
+
+	c.initStorage(ctx)
+	c.initNotifications(ctx)
+	c.initServices()
+	c.initJobs()
+	c.maintenance = janitor.New()
+	return c
+}
+
+// initStorage opens the local export storage; with a fallback dir, saves fail
+// over to it while the export dir keeps failing.
+func (c *core) initStorage(ctx context.Context) {
+	cfg := c.cfg
+	c.storageClient = initLocalStorage(cfg, cfg.ExportDir)
+	if cfg.StorageFailover.FallbackDir != "" {
+		c.fallbackStorage = initLocalStorage(cfg, cfg.StorageFailover.FallbackDir)
+	}
+	c.storage = clients.NewFailoverStorage(c.storageClient, c.fallbackStorage,
+		cfg.StorageFailover.Threshold, time.Duration(cfg.StorageFailover.CooldownSec)*time.Second)
+	// writes interrupted by a crash; younger ones may be in progress on another instance
+	if n, err := c.storage.RemoveTempFiles(time.Duration(cfg.JanitorTempFileAgeMin) * time.Minute); err != nil {
+		log.Printf("storage: sweep temp files: %v", err)
+	} else if n > 0 {
+		log.Printf("storage: removed %d unfinished writes", n)
+	}
+
+	c.fileRetention.Store(int64(time.Duration(cfg.FileRetentionHours) * time.Hour))
+	c.undownloadedRetention.Store(int64(time.Duration(cfg.FileRetentionUndownloadedHours) * time.Hour))
+
+	// low on disk space: downloaded files go earlier, undownloaded ones keep the
+	// regular retention, and new exports are refused until space is back
+	if cfg.DiskMinFreeMB > 0 {
+		c.diskWatch = clients.NewDiskWatchdog(c.storageClient, uint64(cfg.DiskMinFreeMB)<<20, func() (int, error) {
+			return c.storageClient.CleanupOlderThan(time.Duration(cfg.DiskLowRetentionHours)*time.Hour, time.Duration(c.fileRetention.Load()))
+		})
+		if _, err := c.diskWatch.Check(ctx); err != nil {
+			log.Printf("disk watchdog: %v", err)
+		}
+	}
+
+	templates, err := clients.NewTemplateStorage(cfg.TemplatesDir)
+	if err != nil {
+		log.Fatalf("templates storage init error: %v", err)
+	}
+	c.templates = templates
+}
+
+// initNotifications wires the channels export events go over. With the job
+// queue an export rarely runs where its user is connected, so WebSocket
+// events are relayed between instances through Redis.
+func (c *core) initNotifications(ctx context.Context) {
+	cfg := c.cfg
+	if cfg.Mode != config.ModeWorker {
+		c.wsHub = websocket.NewHubWithConfig(websocket.HubConfig{
+			PongWait:            time.Duration(cfg.WebSocket.PongWaitSec) * time.Second,
+			PingPeriod:          time.Duration(cfg.WebSocket.PingPeriodSec) * time.Second,
+			WriteWait:           time.Duration(cfg.WebSocket.WriteWaitSec) * time.Second,
+			SendBuffer:          cfg.WebSocket.SendBuffer,
+			BroadcastBuffer:     cfg.WebSocket.BroadcastBuffer,
+			ReadBufferSize:      cfg.WebSocket.ReadBufferBytes,
+			WriteBufferSize:     cfg.WebSocket.WriteBufferBytes,
+			ReconnectJitter:     time.Duration(cfg.WebSocket.ReconnectJitterSec) * time.Second,
+			DefaultEventVersion: cfg.WebSocket.EventVersion,
+		})
+		go c.wsHub.Run(ctx)
+	}
+	wsClient := clients.NewWebSocketClient(c.wsHub)
+	if cfg.ExportQueue {
+		relay := clients.NewWSRelay(c.redis)
+		wsClient.SetRelay(relay)
+		if c.wsHub != nil {
+			go relay.Run(ctx, c.wsHub)
+		}
+	}
+
+	c.userRepo = repository.NewUserRepository(c.repoDB)
+
+	// export events go over the channels each user picked in /me/notifications
+	c.notificationSettings = clients.NewCachedNotificationSettings(
+		repository.NewNotificationSettingsRepository(c.repoDB), notificationSettingsTTL)
+	c.messengerNotifier, c.notifyChannels = initMessengers(cfg, c.notificationSettings)
+	// completion events reach every channel at least once: the outbox stores
+	// them and the janitor retries what a channel failed to take
+	outboxTargets := []service.OutboxTarget{
+		{Name: domain.NotifyChannelWebSocket, Notifier: clients.NewPreferenceNotifier(domain.NotifyChannelWebSocket, wsClient, c.notificationSettings)},
+		{Name: domain.NotifyChannelMessenger, Notifier: clients.NewPreferenceNotifier(domain.NotifyChannelMessenger, c.messengerNotifier, c.notificationSettings)},
+	}
+	if cfg.SMTP.Addr != "" {
+		c.emailNotifier = clients.NewEmailNotifier(clients.SMTPConfig{
+			Addr:     cfg.SMTP.Addr,
+			From:     cfg.SMTP.From,
+			User:     cfg.SMTP.User,
+			Password: cfg.SMTP.Password,
+		}, c.userRepo)
+		outboxTargets = append(outboxTargets, service.OutboxTarget{
+			Name:     domain.NotifyChannelEmail,
+			Notifier: clients.NewPreferenceNotifier(domain.NotifyChannelEmail, c.emailNotifier, c.notificationSettings),
+		})
+		c.notifyChannels = append(c.notifyChannels, domain.NotifyChannelEmail)
+	}
+	c.outbox = service.NewOutbox(repository.NewOutboxRepository(c.repoDB), c.redis, outboxTargets...)
+
+	// exports started by POST /export/batch report to their batch instead
+	c.batches = service.NewBatchNotifier(c.outbox, c.redis)
+	// and wake long polls of GET /export/{id}
+	c.watch = service.NewExportWatch(c.batches)
+}
+
+// initServices creates the export services and the export admin service.
+func (c *core) initServices() {
+	cfg := c.cfg
+	actionRepo := repository.NewActionRepository(c.repoDB)
+
+	c.debtSvc = service.NewDebtService(repository.NewDebtRepository(c.repoDB), c.redis, c.storage, c.watch)
+	c.userSvc = service.NewUserService(c.userRepo, c.redis, c.storage, c.watch)
+	c.actionSvc = service.NewActionService(actionRepo, c.redis, c.storage, c.watch, initRecordingPresigner(cfg.Telephony))
+	c.paymentSvc = service.NewPaymentService(repository.NewPaymentRepository(c.repoDB), c.redis, c.storage, c.watch)
+	retryPolicy := service.RetryPolicy{
+		Attempts:  cfg.ExportRetry.Attempts,
+		BaseDelay: time.Duration(cfg.ExportRetry.BaseDelayMs) * time.Millisecond,
+		MaxDelay:  time.Duration(cfg.ExportRetry.MaxDelayMs) * time.Millisecond,
+	}
+	c.debtSvc.SetRetryPolicy(retryPolicy)
+	c.userSvc.SetRetryPolicy(retryPolicy)
+	c.actionSvc.SetRetryPolicy(retryPolicy)
+	c.actionSvc.SetActionTypes(actionRepo)
+	c.paymentSvc.SetRetryPolicy(retryPolicy)
+
+	filterNames := repository.NewNameRepository(c.repoDB)
+	c.debtSvc.SetFilterNames(filterNames)
+	c.actionSvc.SetFilterNames(filterNames)
+	c.paymentSvc.SetFilterNames(filterNames)
+
+	for exportType, tpl := range cfg.FilenameTemplates {
+		if err := service.ValidateFilenameTemplate(tpl); err != nil {
+			log.Fatalf("filename template for %s: %v", exportType, err)
+		}
+	}
+	c.debtSvc.SetFilenameTemplate(cfg.FilenameTemplates["debts"])
+	c.userSvc.SetFilenameTemplate(cfg.FilenameTemplates["users"])
+	c.actionSvc.SetFilenameTemplate(cfg.FilenameTemplates["actions"])
+	c.paymentSvc.SetFilenameTemplate(cfg.FilenameTemplates["payments"])
+
+	linkTemplates := service.LinkTemplates{Debt: cfg.CRMDebtURLTemplate}
+	if err := service.ValidateLinkTemplates(linkTemplates); err != nil {
+		log.Fatalf("CRM_DEBT_URL_TEMPLATE: %v", err)
+	}
+	c.debtSvc.SetLinkTemplates(linkTemplates)
+	c.actionSvc.SetLinkTemplates(linkTemplates)
+	c.paymentSvc.SetLinkTemplates(linkTemplates)
+
+	c.debtSvc.SetTemplateStore(c.templates)
+	c.userSvc.SetTemplateStore(c.templates)
+	c.actionSvc.SetTemplateStore(c.templates)
+	c.paymentSvc.SetTemplateStore(c.templates)
+
+	deliverers := initDeliverers(cfg)
+	c.debtSvc.SetDeliverers(deliverers)
+	c.userSvc.SetDeliverers(deliverers)
+	c.actionSvc.SetDeliverers(deliverers)
+	c.paymentSvc.SetDeliverers(deliverers)
+
+	c.debtSvc.SetOutbox(c.outbox)
+	c.userSvc.SetOutbox(c.outbox)
+	c.actionSvc.SetOutbox(c.outbox)
+	c.paymentSvc.SetOutbox(c.outbox)
+	progressInterval := time.Duration(cfg.ExportProgressIntervalSec) * time.Second
+	c.debtSvc.SetProgressInterval(progressInterval)
+	c.userSvc.SetProgressInterval(progressInterval)
+	c.actionSvc.SetProgressInterval(progressInterval)
+	c.paymentSvc.SetProgressInterval(progressInterval)
+
+	c.exportSvc = service.NewExportService(c.redis, cfg.ExportPrefix)
+	c.exportSvc.RegisterRetrier("debts", c.debtSvc)
+	c.exportSvc.RegisterRetrier("debts_aging", c.debtSvc)
+	c.exportSvc.RegisterRetrier("users", c.userSvc)
+	c.exportSvc.RegisterRetrier("actions", c.actionSvc)
+	c.exportSvc.RegisterRetrier("actions_daily", c.actionSvc)
+	c.exportSvc.RegisterRetrier("payments", c.paymentSvc)
+	c.exportSvc.SetExportFiles(c.storage)
+}
+
+// initJobs sets up the worker pool, whose watchdog fails exports that stopped
+// reporting progress, and the job queue.
+func (c *core) initJobs() {
+	cfg := c.cfg
+	c.jobRunner = service.NewJobRunner(cfg.ExportWorkers)
+	c.jobRunner.SetLanes(cfg.ExportLanes)
+	c.jobRunner.SetStallTimeout(time.Duration(cfg.ExportStallTimeoutMin) * time.Minute)
+	c.debtSvc.SetJobRunner(c.jobRunner)
+	c.userSvc.SetJobRunner(c.jobRunner)
+	c.actionSvc.SetJobRunner(c.jobRunner)
+	c.paymentSvc.SetJobRunner(c.jobRunner)
+	c.exportSvc.SetJobRunner(c.jobRunner)
+
+	// exports go through a queue in Redis that the worker instances consume
+	if cfg.ExportQueue {
+		c.jobQueue = service.NewJobQueue(clients.NewRedisQueue(c.redis, "exports"), c.redis, c.jobRunner)
+		c.jobQueue.SetLease(time.Duration(cfg.ExportQueueLeaseSec) * time.Second)
+		c.jobQueue.SetMaxAttempts(cfg.ExportQueueMaxAttempts)
+		c.jobQueue.SetTenants(c.tenants.Get)
+		c.debtSvc.SetJobQueue(c.jobQueue)
+		c.userSvc.SetJobQueue(c.jobQueue)
+		c.actionSvc.SetJobQueue(c.jobQueue)
+		c.paymentSvc.SetJobQueue(c.jobQueue)
+		c.exportSvc.SetJobQueue(c.jobQueue)
+	}
+}
+
+// reload re-reads the configuration and applies the runtime-tunable part of
+// it; everything else still requires a restart.
+func (c *core) reload() error {
+	newCfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	c.jobRunner.SetWorkers(newCfg.ExportWorkers)
+	c.jobRunner.SetLanes(newCfg.ExportLanes)
+	c.jobRunner.SetStallTimeout(time.Duration(newCfg.ExportStallTimeoutMin) * time.Minute)
+	c.repoDB.SetQueryLog(queryLog(newCfg))
+	c.fileRetention.Store(int64(time.Duration(newCfg.FileRetentionHours) * time.Hour))
+	c.undownloadedRetention.Store(int64(time.Duration(newCfg.FileRetentionUndownloadedHours) * time.Hour))
+	auth.SetDebug(newCfg.AuthDebug)
+	for name, interval := range janitorIntervals(newCfg) {
+		c.maintenance.SetInterval(name, interval)
+	}
+	log.Printf("settings reloaded: workers=%d lanes=%v stall_timeout=%dm file_retention=%dh undownloaded_retention=%dh auth_debug=%t",
+		newCfg.ExportWorkers, newCfg.ExportLanes, newCfg.ExportStallTimeoutMin, newCfg.FileRetentionHours, newCfg.FileRetentionUndownloadedHours, newCfg.AuthDebug)
+	return nil
+}
+
+// startMaintenance registers the janitor tasks and runs them until ctx is done.
+func (c *core) startMaintenance(ctx context.Context) {
+	cfg := c.cfg
+
+	// export records live in per-tenant redis namespaces
+	exportContexts := func() []context.Context {
+		if !c.tenants.Enabled() {
+			return []context.Context{ctx}
+		}
+		var ctxs []context.Context
+		for _, t := range c.tenants.All() {
+			ctxs = append(ctxs, tenant.WithTenant(ctx, t))
+		}
+		return ctxs
+	}
+	forEachTenant := func(fn func(ctx context.Context) (int, error)) janitor.TaskFunc {
+		return func(context.Context) (int, error) {
+			total := 0
+			var errs []error
+			for _, tctx := range exportContexts() {
+				n, err := fn(tctx)
+				total += n
+				errs = append(errs, err)
+			}
+			return total, errors.Join(errs...)
+		}
+	}
+
+	intervals := janitorIntervals(cfg)
+	c.maintenance.AddShared("files", intervals["files"], func(context.Context) (int, error) {
+		return c.storage.CleanupOlderThan(time.Duration(c.fileRetention.Load()), time.Duration(c.undownloadedRetention.Load()))
+	})
+	c.maintenance.Add("temp_files", intervals["temp_files"], func(context.Context) (int, error) {
+		return c.storage.RemoveTempFiles(time.Duration(cfg.JanitorTempFileAgeMin) * time.Minute)
+	})
+	c.maintenance.Add("stalled_jobs", intervals["stalled_jobs"], func(context.Context) (int, error) {
+		return c.jobRunner.ReapStalled(), nil
+	})
+	c.maintenance.AddShared("stale_exports", intervals["stale_exports"], forEachTenant(func(ctx context.Context) (int, error) {
+		return c.exportSvc.ExpireStaleExports(ctx, c.jobRunner.StallTimeout())
+	}))
+	c.maintenance.AddShared("export_index", intervals["export_index"], forEachTenant(c.exportSvc.PruneExportSet))
+	c.maintenance.AddShared("outbox", intervals["outbox"], forEachTenant(c.outbox.Dispatch))
+	if c.diskWatch != nil {
+		c.maintenance.Add("disk", intervals["disk"], c.diskWatch.Check)
+	}
+	if c.fallbackStorage != nil {
+		c.maintenance.Add("storage_probe", intervals["storage_probe"], c.storage.Probe)
+	}
+	if c.jobQueue != nil {
+		c.maintenance.AddShared("job_queue", intervals["job_queue"], c.jobQueue.Recover)
+	}
+	// tasks over shared state run on one instance elected through Redis
+	if cfg.JanitorLeaderTTLSec > 0 {
+		election := clients.NewLeaderElection(c.redis, "janitor", time.Duration(cfg.JanitorLeaderTTLSec)*time.Second)
+		c.maintenance.SetLeader(election)
+		go election.Run(ctx)
+	}
+	go c.maintenance.Run(ctx)
+}
+
+// startWorker makes the instance claim queued exports until ctx is done;
+// without the queue exports run where they are started and there is nothing
+// to claim.
+func (c *core) startWorker(ctx context.Context) {
+	if c.jobQueue == nil {
+		return
+	}
+	log.Printf("claiming exports from the job queue (%d workers)", c.cfg.ExportWorkers)
+	go c.jobQueue.Run(ctx)
+}
+
+// startDebug serves diagnostics (pprof, in-flight exports, storage usage) on a
+// separate port, never on the public one; nil when DEBUG_ADDR is not set.
+func (c *core) startDebug(ctx context.Context) *http.Server {
+	cfg := c.cfg
+	if cfg.DebugAddr == "" {
+		return nil
+	}
+
+	// storage usage and maintenance counters for scrapers of /debug/vars
+	expvar.Publish("janitor", expvar.Func(func() any { return c.maintenance.Stats() }))
+	expvar.Publish("db_queries", expvar.Func(func() any { return c.repoDB.QueryStats() }))
+	expvar.Publish("redis", expvar.Func(func() any { return c.redis.Stats() }))
+	if c.jobQueue != nil {
+		expvar.Publish("job_queue", expvar.Func(func() any {
+			stats, err := c.jobQueue.Stats(ctx)
+			if err != nil {
+				return err.Error()
+			}
+			return stats
+		}))
+	}
+	expvar.Publish("storage_usage", expvar.Func(func() any {
+		usage, err := c.storageClient.Usage()
+		if err != nil {
+			return err.Error()
+		}
+		return usage
+	}))
+	expvar.Publish("storage_disk", expvar.Func(func() any {
+		if c.diskWatch == nil {
+			return nil
+		}
+		return c.diskWatch.Stats()
+	}))
+	expvar.Publish("storage_health", expvar.Func(func() any { return c.storage.Health() }))
+	expvar.Publish("storage_dedup", expvar.Func(func() any {
+		stats, err := c.storageClient.DedupStats()
+		if err != nil {
+			return err.Error()
+		}
+		return stats
+	}))
+
+	srv := &http.Server{
+		Addr:    cfg.DebugAddr,
+		Handler: debug.NewRouter(c.jobRunner, c.storageClient, c.reload, cfg.DebugToken),
+	}
+	go func() {
+		log.Printf("debug server listening on %s\n", cfg.DebugAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("debug server error: %v", err)
+		}
+	}()
+	return srv
+}
+
+// waitNotifications lets the last messenger and email notifications go out.
+func (c *core) waitNotifications(ctx context.Context) {
+	c.messengerNotifier.Wait(ctx)
+	if c.emailNotifier != nil {
+		c.emailNotifier.Wait(ctx)
+	}
+}
+
+// close frees the database pools and the Redis connection.
+func (c *core) close() {
+	postgres.Close(c.db)
+	for _, tdb := range c.tenantDBs {
+		postgres.Close(tdb)
+	}
+	c.redis.Close()
+}
@@ -1,219 +1,133 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
 	"debtster-export/internal/clients"
 	"debtster-export/internal/config"
+	"debtster-export/internal/migrations"
+	"debtster-export/internal/reporting"
 	"debtster-export/internal/repository"
+	"debtster-export/internal/secrets"
 	"debtster-export/internal/service"
+	"debtster-export/internal/tenant"
 	"debtster-export/internal/transport/auth"
 	"debtster-export/internal/transport/rest"
-	"debtster-export/internal/transport/websocket"
 	"debtster-export/pkg/database/postgres"
 
-	"github.com/go-chi/chi/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	mode := flag.String("mode", "", "what the instance runs: api (HTTP/WS front), worker (queued exports) or all; overrides APP_MODE")
+	flag.Parse()
+
 	if err := godotenv.Load(); err != nil {
 		log.Println("no .env file found, using system env or defaults")
 	}
+	if *mode != "" {
+		// through the environment, so reloads keep the mode
+		_ = os.Setenv("APP_MODE", *mode)
+	}
 
 	// top-level context which we can cancel on shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	cfg := config.Load()
-
-	db := mustInitPostgres(cfg.Postgres)
-	defer postgres.Close(db)
-
-	redisClient := mustInitRedis(cfg.Redis)
-	defer redisClient.Close()
-
-	// Init local export storage
-	storageClient, err := clients.NewLocalStorage(cfg.ExportDir, cfg.FilesPublicPrefix, cfg.ExternalURL)
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("storage init error: %v", err)
+		log.Fatal(err)
+	}
+	if len(cfg.DefaultsUsed) > 0 {
+		log.Printf("config (%s): using defaults for %s", cfg.Env, strings.Join(cfg.DefaultsUsed, ", "))
 	}
 
-	wsHub := websocket.NewHub()
-	go wsHub.Run(ctx)
-	wsClient := clients.NewWebSocketClient(wsHub)
-
-	debtRepo := repository.NewDebtRepository(db)
-	userRepo := repository.NewUserRepository(db)
-	actionRepo := repository.NewActionRepository(db)
-	paymentRepo := repository.NewPaymentRepository(db)
-	tokenRepo := repository.NewPersonalAccessTokenRepository(db)
-
-	debtSvc := service.NewDebtService(debtRepo, redisClient, storageClient, wsClient)
-	userSvc := service.NewUserService(userRepo, redisClient, storageClient, wsClient)
-	actionSvc := service.NewActionService(actionRepo, redisClient, storageClient, wsClient)
-	paymentSvc := service.NewPaymentService(paymentRepo, redisClient, storageClient, wsClient)
-	exportSvc := service.NewExportService(redisClient, cfg.ExportPrefix)
-
-	sanctumMiddleware := auth.SanctumMiddleware(tokenRepo)
-
-	handler := rest.NewHandler(debtSvc, userSvc, actionSvc, paymentSvc, exportSvc)
-	router := handler.InitRouterWithAuth(sanctumMiddleware)
-
-	// create a public root router and mount protected (auth) router underneath so
-	// /files and /health remain public while other routes remain protected
-	root := chi.NewRouter()
-
-	// public: serve generated files
-	root.Get("/files/{file}", func(w http.ResponseWriter, r *http.Request) {
-		file := chi.URLParam(r, "file")
-		// sanitize and open file from storage directory
-		path := filepath.Join(storageClient.BaseDir, file)
-		// check file exists
-		if _, err := os.Stat(path); err != nil {
-			if os.IsNotExist(err) {
-				http.NotFound(w, r)
-				return
-			}
-			http.Error(w, "failed to access file", http.StatusInternalServerError)
-			return
+	if cfg.SentryDSN != "" {
+		reporter, err := reporting.NewSentryReporter(cfg.SentryDSN, cfg.SentryEnvironment, "")
+		if err != nil {
+			log.Printf("error reporting disabled: %v", err)
+		} else {
+			reporting.Set(reporter)
+			defer reporting.Flush(2 * time.Second)
 		}
+	}
 
-		// prefer original filename in Content-Disposition (strip random prefix)
-		orig := file
-		if idx := strings.IndexByte(file, '_'); idx >= 0 {
-			orig = file[idx+1:]
+	if cfg.Vault.Enabled() {
+		vault := secrets.NewVaultClient(cfg.Vault.Addr, cfg.Vault.Token, cfg.Vault.Mount)
+		if err := vault.ApplyTo(ctx, &cfg); err != nil {
+			log.Fatalf("vault error: %v", err)
 		}
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", orig))
+		go vault.KeepTokenAlive(ctx, time.Duration(cfg.Vault.RenewIntervalMin)*time.Minute)
+		log.Printf("credentials loaded from vault %s", cfg.Vault.Addr)
+	}
 
-		http.ServeFile(w, r, path)
-	})
+	db := mustInitPostgres(cfg.Postgres, "")
+	tenants := tenant.NewRegistry(cfg.Tenants)
+	tenantDBs := mustInitTenantPostgres(cfg.Postgres, tenants)
 
-	// protected websocket endpoint
-	router.Get("/ws", func(w http.ResponseWriter, r *http.Request) {
-		userID, err := auth.GetUserID(r.Context())
-		if err != nil {
-			token := r.URL.Query().Get("token")
-			if token != "" {
-				pat, err2 := tokenRepo.FindTokenByPlainToken(r.Context(), token)
-				if err2 != nil {
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
-				}
-				if pat.ExpiresAt != nil && pat.ExpiresAt.Before(time.Now()) {
-					http.Error(w, "Token expired", http.StatusUnauthorized)
-					return
-				}
-				userID = pat.UserID
-			} else {
-				// fallback for tests: allow ?user_id=1
-				userIDStr := r.URL.Query().Get("user_id")
-				if userIDStr == "" {
-					http.Error(w, "user_id required", http.StatusBadRequest)
-					return
-				}
-				parsed, err2 := strconv.ParseInt(userIDStr, 10, 64)
-				if err2 != nil {
-					http.Error(w, "invalid user_id", http.StatusBadRequest)
-					return
-				}
-				userID = parsed
-			}
+	// `debtster-export migrate [up|down|status]` manages service-owned tables and exits
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		command := "up"
+		if len(args) > 1 {
+			command = args[1]
 		}
-
-		log.Printf("WS connected: user_id=%d", userID)
-		wsHub.HandleWebSocket(w, r, userID)
-	})
-
-	// expose endpoint for saving/uploading files (protected)
-	router.Post("/files/upload", func(w http.ResponseWriter, r *http.Request) {
-		// expect multipart form
-		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB
-			http.Error(w, "invalid form", http.StatusBadRequest)
-			return
+		err := runMigrations(ctx, command, db, tenantDBs)
+		postgres.Close(db)
+		for _, tdb := range tenantDBs {
+			postgres.Close(tdb)
 		}
-		file, header, err := r.FormFile("file")
 		if err != nil {
-			http.Error(w, "file required", http.StatusBadRequest)
-			return
+			log.Fatalf("migrate %s: %v", command, err)
 		}
-		defer file.Close()
-
-		buf := new(bytes.Buffer)
-		if _, err := buf.ReadFrom(file); err != nil {
-			http.Error(w, "failed to read file", http.StatusInternalServerError)
-			return
-		}
-
-		saved, err := storageClient.Save(r.Context(), header.Filename, buf.Bytes())
-		if err != nil {
-			http.Error(w, "failed to save file", http.StatusInternalServerError)
-			return
+		return
+	}
+	if cfg.AutoMigrate {
+		if err := runMigrations(ctx, "up", db, tenantDBs); err != nil {
+			log.Fatalf("auto-migrate: %v", err)
 		}
+	}
 
-		url := storageClient.GetURL(saved)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		_, _ = w.Write([]byte(fmt.Sprintf(`{"url":"%s","file":"%s"}`, url, saved)))
-	})
-
-	// mount protected router on root
-	root.Mount("/", router)
-
-	corsHandler := withCORS(root)
+	log.Printf("starting in %s mode", cfg.Mode)
+	app := newCore(ctx, cfg, db, tenantDBs, tenants)
 
-	srv := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      corsHandler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	var api *apiServer
+	if cfg.Mode != config.ModeWorker {
+		api = startAPI(ctx, app)
 	}
+	// claims queued exports until shutdown starts
+	queueCtx, stopQueue := context.WithCancel(ctx)
+	defer stopQueue()
+	if cfg.Mode != config.ModeAPI {
+		app.startWorker(queueCtx)
+	}
+	debugSrv := app.startDebug(ctx)
+	app.startMaintenance(ctx)
 
-	// Run HTTP server in goroutine so we can listen for shutdown signals
-	srvErr := make(chan error, 1)
-	go func() {
-		log.Printf("HTTP server listening on :%s\n", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			srvErr <- err
-			return
-		}
-		srvErr <- nil
-	}()
-
-	// start background cleaner that deletes files older than 12 hours
-	// run checks every 6 hours
-	go func() {
-		ticker := time.NewTicker(6 * time.Hour)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				if err := storageClient.CleanupOlderThan(12 * time.Hour); err != nil {
-					log.Printf("storage cleanup error: %v", err)
-				}
-			}
-		}
-	}()
+	// SIGHUP reloads runtime-tunable settings without restarting
+	reloadOnHangup(ctx, app.reload)
 
 	// Listen for OS shutdown signals
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	// a worker has no listener; a nil channel never fires
+	var srvErr chan error
+	if api != nil {
+		srvErr = api.errs
+	}
+
 	select {
 	case err := <-srvErr:
 		if err != nil {
@@ -226,29 +140,83 @@ func main() {
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer shutdownCancel()
 
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-			log.Printf("HTTP server Shutdown error: %v", err)
+		if api != nil {
+			api.shutdown(shutdownCtx)
+		}
+		if debugSrv != nil {
+			_ = debugSrv.Shutdown(shutdownCtx)
 		}
 
+		// Interrupt running exports while Redis is still available to record it
+		stopQueue()
+		app.jobRunner.Shutdown(shutdownCtx)
+		// and let the last messenger and email notifications go out
+		app.waitNotifications(shutdownCtx)
+
 		// Cancel top-level context so background services (websocket hub) stop
 		cancel()
 
 		// Close database & redis explicitly to free resources promptly
-		postgres.Close(db)
-		redisClient.Close()
+		app.close()
+
+		reporting.Flush(2 * time.Second)
 
 		log.Println("Shutdown complete")
 	}
 }
 
-func mustInitPostgres(cfg config.PostgresConfig) *sql.DB {
+// reloadOnHangup calls reload on every SIGHUP until ctx is done; a failed
+// reload is logged and keeps the current settings.
+func reloadOnHangup(ctx context.Context, reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := reload(); err != nil {
+					log.Printf("settings reload failed, keeping current settings: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// queryLog is the repository query logging of cfg.
+func queryLog(cfg config.AppConfig) repository.QueryLog {
+	return repository.QueryLog{
+		All:  cfg.Postgres.QueryLog,
+		Slow: time.Duration(cfg.Postgres.SlowQueryMs) * time.Millisecond,
+	}
+}
+
+// janitorIntervals maps maintenance task names to their configured schedules.
+func janitorIntervals(cfg config.AppConfig) map[string]time.Duration {
+	return map[string]time.Duration{
+		"files":         time.Duration(cfg.FileCleanupIntervalHours) * time.Hour,
+		"temp_files":    time.Duration(cfg.JanitorTempFilesIntervalMin) * time.Minute,
+		"stalled_jobs":  time.Duration(cfg.JanitorStalledJobsIntervalSec) * time.Second,
+		"stale_exports": time.Duration(cfg.JanitorStaleExportsIntervalMin) * time.Minute,
+		"export_index":  time.Duration(cfg.JanitorExportIndexIntervalMin) * time.Minute,
+		"outbox":        time.Duration(cfg.JanitorOutboxIntervalSec) * time.Second,
+		"disk":          time.Duration(cfg.JanitorDiskIntervalSec) * time.Second,
+		"job_queue":     time.Duration(cfg.JanitorQueueIntervalSec) * time.Second,
+		"storage_probe": time.Duration(cfg.StorageFailover.ProbeIntervalSec) * time.Second,
+	}
+}
+
+func mustInitPostgres(cfg config.PostgresConfig, searchPath string) *sql.DB {
 	db, err := postgres.NewPostgresConnection(postgres.ConnectionInfo{
-		Host:     cfg.Host,
-		Port:     cfg.Port,
-		Username: cfg.User,
-		DBName:   cfg.DBName,
-		SSLMode:  cfg.SSLMode,
-		Password: cfg.Password,
+		Host:       cfg.Host,
+		Port:       cfg.Port,
+		Username:   cfg.User,
+		DBName:     cfg.DBName,
+		SSLMode:    cfg.SSLMode,
+		Password:   cfg.Password,
+		SearchPath: searchPath,
 	})
 	if err != nil {
 		log.Fatalf("postgres init error: %v", err)
@@ -256,6 +224,41 @@ func mustInitPostgres(cfg config.PostgresConfig) *sql.DB {
 	return db
 }
 
+// runMigrations runs command against the default database and every tenant schema.
+func runMigrations(ctx context.Context, command string, db *sql.DB, tenantDBs map[string]*sql.DB) error {
+	var run func(context.Context, *sql.DB) error
+	switch command {
+	case "up":
+		run = migrations.Up
+	case "down":
+		run = migrations.Down
+	case "status":
+		run = migrations.Status
+	default:
+		return fmt.Errorf("unknown command %q (want up, down or status)", command)
+	}
+
+	if err := run(ctx, db); err != nil {
+		return err
+	}
+	for id, tdb := range tenantDBs {
+		log.Printf("migrate %s: tenant %s", command, id)
+		if err := run(ctx, tdb); err != nil {
+			return fmt.Errorf("tenant %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// mustInitTenantPostgres opens one pool per tenant, pinned to the tenant schema.
+func mustInitTenantPostgres(cfg config.PostgresConfig, tenants *tenant.Registry) map[string]*sql.DB {
+	dbs := map[string]*sql.DB{}
+	for _, t := range tenants.All() {
+		dbs[t.ID] = mustInitPostgres(cfg, t.Schema)
+	}
+	return dbs
+}
+
 func mustInitRedis(cfg config.RedisConfig) *clients.RedisClient {
 	client, err := clients.NewRedisClient(clients.RedisConfig{
 		Addr:        cfg.Addr,
@@ -274,16 +277,273 @@ func mustInitRedis(cfg config.RedisConfig) *clients.RedisClient {
 
 // S3 removed — local storage used instead.
 
-func withCORS(next http.Handler) http.Handler {
+// initRecordingPresigner returns nil when telephony storage is not configured,
+// so call recordings are exported exactly as stored in action payloads.
+func initRecordingPresigner(cfg config.S3Config) service.RecordingPresigner {
+	if cfg.Bucket == "" {
+		return nil
+	}
+	client, err := clients.NewS3Client(clients.S3Config{
+		Endpoint:   cfg.Endpoint,
+		AccessKey:  cfg.AccessKey,
+		SecretKey:  cfg.SecretKey,
+		Bucket:     cfg.Bucket,
+		Region:     cfg.Region,
+		UseSSL:     cfg.UseSSL,
+		PresignTTL: time.Duration(cfg.PresignTTL) * time.Hour,
+	})
+	if err != nil {
+		log.Fatalf("telephony storage init error: %v", err)
+	}
+	return client
+}
+
+// initLocalStorage opens the storage in dir with the configured dedup,
+// compression and archive.
+func initLocalStorage(cfg config.AppConfig, dir string) *clients.StorageClient {
+	storage, err := clients.NewLocalStorage(dir, cfg.FilesPublicPrefix, cfg.ExternalURL)
+	if err != nil {
+		log.Fatalf("storage init error: %v", err)
+	}
+	storage.BasePath = cfg.BasePath
+	if cfg.StorageDedup {
+		if err := storage.EnableDedup(); err != nil {
+			log.Fatalf("storage dedup: %v", err)
+		}
+	}
+	if cfg.StorageCompression != "" {
+		if err := storage.EnableCompression(cfg.StorageCompression); err != nil {
+			log.Fatalf("storage compression: %v", err)
+		}
+	}
+	if cfg.FilesURLSigningKey != "" {
+		ttl := time.Duration(cfg.FilesURLTTLHours) * time.Hour
+		if err := storage.EnableSignedURLs([]byte(cfg.FilesURLSigningKey), ttl); err != nil {
+			log.Fatalf("storage signed urls: %v", err)
+		}
+	}
+	if archive := initArchive(cfg.Archive); archive != nil {
+		storage.SetArchive(archive)
+	}
+	return storage
+}
+
+// initArchive returns nil when no archive bucket is configured, so expired
+// files are just deleted.
+func initArchive(cfg config.ArchiveConfig) *clients.ColdArchive {
+	if cfg.S3.Bucket == "" {
+		return nil
+	}
+	client, err := clients.NewS3Client(clients.S3Config{
+		Endpoint:  cfg.S3.Endpoint,
+		AccessKey: cfg.S3.AccessKey,
+		SecretKey: cfg.S3.SecretKey,
+		Bucket:    cfg.S3.Bucket,
+		Region:    cfg.S3.Region,
+		UseSSL:    cfg.S3.UseSSL,
+	})
+	if err != nil {
+		log.Fatalf("archive storage init error: %v", err)
+	}
+	return clients.NewColdArchive(client, cfg.Prefix, cfg.StorageClass, cfg.RestoreDays)
+}
+
+// notificationSettingsTTL is how long notification settings are cached; other
+// instances see a change within it.
+const notificationSettingsTTL = 30 * time.Second
+
+// initMessengers creates the messenger notifier with the messengers enabled in
+// the configuration and returns their names for the preferences API.
+func initMessengers(cfg config.AppConfig, settings clients.NotificationSettingsSource) (*clients.MessengerNotifier, []string) {
+	var (
+		telegram   *clients.TelegramClient
+		slack      *clients.SlackClient
+		messengers []string
+	)
+	if cfg.TelegramBotToken != "" {
+		telegram = clients.NewTelegramClient(cfg.TelegramBotToken, cfg.TelegramAPIURL)
+		messengers = append(messengers, rest.MessengerTelegram)
+	}
+	if cfg.SlackNotifications {
+		slack = clients.NewSlackClient()
+		messengers = append(messengers, rest.MessengerSlack)
+	}
+	return clients.NewMessengerNotifier(settings, telegram, slack), messengers
+}
+
+// initAPIKeys converts the configured service-to-service keys; their hashes
+// are validated by config.Load.
+func initAPIKeys(keys []config.APIKeyConfig) []auth.APIKey {
+	out := make([]auth.APIKey, 0, len(keys))
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		hash, _ := hex.DecodeString(k.Hash)
+		out = append(out, auth.APIKey{Name: k.Name, Hash: hash, UserID: k.UserID, Abilities: k.Abilities})
+		names = append(names, k.Name)
+	}
+	if len(names) > 0 {
+		log.Printf("api keys: %s", strings.Join(names, ", "))
+	}
+	return out
+}
+
+// initSessions enables session cookie auth when SESSION_AUTH is set.
+func initSessions(cfg config.AppConfig, redisClient *clients.RedisClient) *auth.LaravelSessions {
+	if !cfg.SessionAuth {
+		return nil
+	}
+	prefix := cfg.SessionKeyPrefix
+	if prefix == "" {
+		prefix = cfg.ExportPrefix
+	}
+	sessions, err := auth.NewLaravelSessions(redisClient, cfg.LaravelAppKey, cfg.SessionCookie, prefix)
+	if err != nil {
+		log.Fatalf("LARAVEL_APP_KEY: %v", err)
+	}
+	sessions.SetTenantAttribute(cfg.SessionTenantKey)
+	log.Printf("session auth: cookie %s", cfg.SessionCookie)
+	return sessions
+}
+
+// initDeliverers enables the delivery types that have at least one profile
+// configured.
+func initDeliverers(cfg config.AppConfig) service.Deliverers {
+	deliverers := service.Deliverers{}
+
+	if len(cfg.SFTPProfiles) > 0 {
+		profiles := make([]clients.SFTPProfile, 0, len(cfg.SFTPProfiles))
+		for _, p := range cfg.SFTPProfiles {
+			profiles = append(profiles, clients.SFTPProfile{
+				Name:           p.Name,
+				Host:           p.Host,
+				Port:           p.Port,
+				User:           p.User,
+				Password:       p.Password,
+				KeyFile:        p.KeyFile,
+				KnownHostsFile: p.KnownHostsFile,
+				Dir:            p.Dir,
+				Timeout:        time.Duration(p.TimeoutSec) * time.Second,
+			})
+		}
+		client, err := clients.NewSFTPClient(profiles)
+		if err != nil {
+			log.Fatalf("sftp delivery init error: %v", err)
+		}
+		log.Printf("sftp delivery profiles: %s", strings.Join(client.Profiles(), ", "))
+		deliverers[service.DeliveryTypeSFTP] = client
+	}
+
+	if len(cfg.ClickHouseProfiles) > 0 {
+		profiles := make([]clients.ClickHouseProfile, 0, len(cfg.ClickHouseProfiles))
+		for _, p := range cfg.ClickHouseProfiles {
+			profiles = append(profiles, clients.ClickHouseProfile{
+				Name:      p.Name,
+				URL:       p.URL,
+				User:      p.User,
+				Password:  p.Password,
+				Database:  p.Database,
+				Table:     p.Table,
+				BatchSize: p.BatchSize,
+				Timeout:   time.Duration(p.TimeoutSec) * time.Second,
+			})
+		}
+		client, err := clients.NewClickHouseClient(profiles)
+		if err != nil {
+			log.Fatalf("clickhouse delivery init error: %v", err)
+		}
+		log.Printf("clickhouse delivery profiles: %s", strings.Join(client.Profiles(), ", "))
+		deliverers[service.DeliveryTypeClickHouse] = client
+	}
+
+	if len(cfg.BigQueryProfiles) > 0 {
+		profiles := make([]clients.BigQueryProfile, 0, len(cfg.BigQueryProfiles))
+		for _, p := range cfg.BigQueryProfiles {
+			profiles = append(profiles, clients.BigQueryProfile{
+				Name:            p.Name,
+				Project:         p.Project,
+				Dataset:         p.Dataset,
+				Table:           p.Table,
+				CredentialsFile: p.CredentialsFile,
+				BatchSize:       p.BatchSize,
+				Timeout:         time.Duration(p.TimeoutSec) * time.Second,
+			})
+		}
+		client, err := clients.NewBigQueryClient(profiles)
+		if err != nil {
+			log.Fatalf("bigquery delivery init error: %v", err)
+		}
+		log.Printf("bigquery delivery profiles: %s", strings.Join(client.Profiles(), ", "))
+		deliverers[service.DeliveryTypeBigQuery] = client
+	}
+
+	if len(cfg.KafkaProfiles) > 0 {
+		profiles := make([]clients.KafkaProfile, 0, len(cfg.KafkaProfiles))
+		for _, p := range cfg.KafkaProfiles {
+			profiles = append(profiles, clients.KafkaProfile{
+				Name:          p.Name,
+				Brokers:       p.Brokers,
+				User:          p.User,
+				Password:      p.Password,
+				SASLMechanism: p.SASLMechanism,
+				TLS:           p.TLS,
+				Topic:         p.Topic,
+				Mode:          p.Mode,
+				ChunkRows:     p.ChunkRows,
+				KeyField:      p.KeyField,
+				Timeout:       time.Duration(p.TimeoutSec) * time.Second,
+			})
+		}
+		client, err := clients.NewKafkaClient(profiles)
+		if err != nil {
+			log.Fatalf("kafka delivery init error: %v", err)
+		}
+		log.Printf("kafka delivery profiles: %s", strings.Join(client.Profiles(), ", "))
+		deliverers[service.DeliveryTypeKafka] = client
+	}
+
+	if len(deliverers) == 0 {
+		return nil
+	}
+	return deliverers
+}
+
+// withBasePath serves requests under basePath as if they came to the root, for
+// reverse proxies that pass the prefix on; requests without it (probes, proxies
+// stripping the prefix) are served as they are.
+func withBasePath(next http.Handler, basePath string) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	stripped := http.StripPrefix(basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath || strings.HasPrefix(r.URL.Path, basePath+"/") {
+			stripped.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withCORS answers cross-origin requests. With allowedOrigins set only those
+// origins get CORS headers, with credentials. Without it any origin is
+// reflected, but once cookies authenticate (sessionAuth) a reflected origin
+// gets no credentials: otherwise any page the browser sends the session
+// cookie with could read exports and files.
+func withCORS(next http.Handler, allowedOrigins []string, sessionAuth bool, extraHeaders ...string) http.Handler {
+	allowHeaders := strings.Join(append([]string{"Content-Type", "Authorization", "X-Requested-With"}, extraHeaders...), ", ")
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		if origin != "" {
+		listed := slices.Contains(allowedOrigins, origin)
+		if origin != "" && (listed || len(allowedOrigins) == 0) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin")
 
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+			if listed || !sessionAuth {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
 		}
 
 		if r.Method == http.MethodOptions {
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"debtster-export/internal/janitor"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service"
+)
+
+// newReloadCore returns the part of core that reload touches.
+func newReloadCore() *core {
+	c := &core{
+		jobRunner:   service.NewJobRunner(4),
+		repoDB:      repository.NewDB(nil, nil),
+		maintenance: janitor.New(),
+	}
+	c.jobRunner.SetStallTimeout(10 * time.Minute)
+	c.fileRetention.Store(int64(12 * time.Hour))
+	c.maintenance.Add("files", 6*time.Hour, func(context.Context) (int, error) { return 0, nil })
+	return c
+}
+
+func TestCoreReload(t *testing.T) {
+	c := newReloadCore()
+	defer c.jobRunner.Shutdown(context.Background())
+
+	t.Setenv("EXPORT_WORKERS", "8")
+	t.Setenv("EXPORT_LANES", "payments:2")
+	t.Setenv("EXPORT_STALL_TIMEOUT_MIN", "30")
+	t.Setenv("FILES_RETENTION_HOURS", "24")
+	t.Setenv("FILES_CLEANUP_INTERVAL_HOURS", "3")
+	if err := c.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, _ := c.jobRunner.Workers(); size != 8 {
+		t.Fatalf("workers = %d", size)
+	}
+	if lanes := c.jobRunner.Lanes(); len(lanes) != 1 || lanes[0].Size != 2 {
+		t.Fatalf("lanes = %+v", lanes)
+	}
+	if d := c.jobRunner.StallTimeout(); d != 30*time.Minute {
+		t.Fatalf("stall timeout = %s", d)
+	}
+	if d := time.Duration(c.fileRetention.Load()); d != 24*time.Hour {
+		t.Fatalf("file retention = %s", d)
+	}
+	if got := c.maintenance.Stats()[0].Interval; got != "3h0m0s" {
+		t.Fatalf("files cleanup interval = %s", got)
+	}
+
+	// невалидная конфигурация не применяется даже частично
+	t.Setenv("EXPORT_WORKERS", "2")
+	t.Setenv("FILES_RETENTION_HOURS", "0")
+	if err := c.reload(); err == nil {
+		t.Fatal("invalid configuration is applied")
+	}
+	if size, _ := c.jobRunner.Workers(); size != 8 {
+		t.Fatalf("workers = %d after a failed reload", size)
+	}
+	if d := time.Duration(c.fileRetention.Load()); d != 24*time.Hour {
+		t.Fatalf("file retention = %s after a failed reload", d)
+	}
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := make(chan struct{}, 2)
+	fail := errors.New("invalid configuration")
+	calls := 0
+	reloadOnHangup(ctx, func() error {
+		calls++
+		reloads <- struct{}{}
+		if calls == 1 {
+			return fail
+		}
+		return nil
+	})
+
+	// неудачная перезагрузка не останавливает обработку следующих сигналов
+	for range 2 {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			t.Fatal("SIGHUP did not reload the settings")
+		}
+	}
+}
+
+func TestRunMigrationsUnknownCommand(t *testing.T) {
+	// до базы дело не доходит: опечатка не должна запускать up
+	err := runMigrations(context.Background(), "redo", nil, nil)
+	if err == nil || err.Error() != `unknown command "redo" (want up, down or status)` {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name        string
+		allowed     []string
+		sessionAuth bool
+		origin      string
+		wantOrigin  bool
+		wantCreds   bool
+	}{
+		{"any origin without sessions", nil, false, "https://app.example.com", true, true},
+		// с cookie-сессией чужая страница не должна читать ответы с куками
+		{"any origin with sessions", nil, true, "https://evil.example.com", true, false},
+		{"listed origin", []string{"https://crm.example.com"}, true, "https://crm.example.com", true, true},
+		{"unlisted origin", []string{"https://crm.example.com"}, false, "https://evil.example.com", false, false},
+		{"same origin", []string{"https://crm.example.com"}, true, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withCORS(next, tt.allowed, tt.sessionAuth, "X-Tenant")
+			req := httptest.NewRequest(http.MethodOptions, "/files/report.xlsx", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("preflight status = %d", rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); (got == tt.origin && got != "") != tt.wantOrigin {
+				t.Fatalf("Access-Control-Allow-Origin = %q", got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.wantCreds {
+				t.Fatalf("credentials allowed = %t", got)
+			}
+			if tt.wantOrigin && !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "DELETE") {
+				t.Fatalf("methods = %q", rec.Header().Get("Access-Control-Allow-Methods"))
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"debtster-export/internal/config"
+)
+
+// writeTestCert writes a self-signed certificate for localhost and its key to
+// dir and returns their paths.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	otherCert, _ := writeTestCert(t, t.TempDir())
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates here"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg, err := buildTLSConfig(config.TLSConfig{}); cfg != nil || err != nil {
+		t.Fatalf("not configured: %v, %v", cfg, err)
+	}
+
+	errorCases := []struct {
+		name string
+		cfg  config.TLSConfig
+	}{
+		{"no key", config.TLSConfig{CertFile: certFile}},
+		{"missing cert", config.TLSConfig{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile}},
+		{"missing key", config.TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.pem")}},
+		{"key of another cert", config.TLSConfig{CertFile: otherCert, KeyFile: keyFile}},
+		{"missing client ca", config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: filepath.Join(dir, "missing.pem")}},
+		{"client ca without certs", config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: empty}},
+		{"unknown client auth", config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile, ClientAuth: "always"}},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if cfg, err := buildTLSConfig(tt.cfg); err == nil {
+				t.Fatalf("expected an error, got %+v", cfg)
+			}
+		})
+	}
+
+	cfg, err := buildTLSConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.Certificates) != 1 || cfg.ClientAuth != tls.NoClientCert {
+		t.Fatalf("config = %+v", cfg)
+	}
+
+	for auth, want := range map[string]tls.ClientAuthType{"": tls.VerifyClientCertIfGiven, "optional": tls.VerifyClientCertIfGiven, "require": tls.RequireAndVerifyClientCert} {
+		cfg, err := buildTLSConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile, ClientAuth: auth})
+		if err != nil || cfg.ClientAuth != want || cfg.ClientCAs == nil {
+			t.Fatalf("client auth %q: %+v, %v", auth, cfg, err)
+		}
+	}
+}
+
+func TestBuildTLSConfigMinVersion(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	cfg, err := buildTLSConfig(config.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	pemData, _ := os.ReadFile(certFile)
+	roots.AppendCertsFromPEM(pemData)
+	get := func(minVersion, maxVersion uint16) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: roots, ServerName: "localhost", MinVersion: minVersion, MaxVersion: maxVersion,
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(tls.VersionTLS12, tls.VersionTLS12); err != nil {
+		t.Fatalf("TLS 1.2: %v", err)
+	}
+	if err := get(tls.VersionTLS13, tls.VersionTLS13); err != nil {
+		t.Fatalf("TLS 1.3: %v", err)
+	}
+	// клиента не новее TLS 1.1 сервер не принимает
+	if err := get(tls.VersionTLS10, tls.VersionTLS11); err == nil {
+		t.Fatal("TLS 1.1 handshake succeeded")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		port, host, target, want string
+	}{
+		{"8443", "exports.example.com", "/export/debts?format=csv&x=1", "https://exports.example.com:8443/export/debts?format=csv&x=1"},
+		{"8443", "exports.example.com:80", "/files/a%20b.xlsx", "https://exports.example.com:8443/files/a%20b.xlsx"},
+		{"443", "exports.example.com:80", "/api/v1/exports?page=2", "https://exports.example.com/api/v1/exports?page=2"},
+		{"443", "[::1]:80", "/health", "https://[::1]/health"},
+		{"8443", "[::1]:80", "/", "https://[::1]:8443/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://"+tt.host+tt.target, nil)
+		rec := httptest.NewRecorder()
+		httpsRedirect(tt.port).ServeHTTP(rec, req)
+
+		// 308 сохраняет метод и тело запроса
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s%s: status %d", tt.host, tt.target, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s%s: Location = %q, want %q", tt.host, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	// ErrNotArchived is returned by Restore for files the archive does not hold.
+	ErrNotArchived = errors.New("file is not archived")
+	// ErrRestorePending is returned by Restore while a cold object is being
+	// brought back; the restore has to be repeated later.
+	ErrRestorePending = errors.New("archived file is being restored from cold storage")
+)
+
+// coldStorageClasses need a restore request before the object can be read.
+var coldStorageClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+}
+
+// ArchiveMeta is kept with an archived file so its metadata survives a restore.
+type ArchiveMeta struct {
+	Original string
+	OwnerID  int64
+	Encoding string
+}
+
+// ColdArchive keeps expired export files in an S3 bucket, usually in a cheap
+// storage class; how long they stay there is up to the bucket lifecycle rules.
+type ColdArchive struct {
+	s3           *S3Client
+	prefix       string
+	storageClass string
+	restoreDays  int
+}
+
+// NewColdArchive stores objects under prefix with storageClass ("" — bucket
+// default); objects of Glacier classes are restored for restoreDays.
+func NewColdArchive(s3 *S3Client, prefix, storageClass string, restoreDays int) *ColdArchive {
+	if restoreDays <= 0 {
+		restoreDays = 1
+	}
+	return &ColdArchive{
+		s3:           s3,
+		prefix:       strings.Trim(prefix, "/"),
+		storageClass: strings.ToUpper(storageClass),
+		restoreDays:  restoreDays,
+	}
+}
+
+func (a *ColdArchive) key(file string) string {
+	if a.prefix == "" {
+		return file
+	}
+	return a.prefix + "/" + file
+}
+
+// Put uploads the local file at localPath as file.
+func (a *ColdArchive) Put(ctx context.Context, file, localPath string, meta ArchiveMeta) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = a.s3.raw.PutObject(ctx, a.s3.bucket, a.key(file), f, info.Size(), minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		StorageClass: a.storageClass,
+		UserMetadata: map[string]string{
+			// header values must be ASCII
+			"Original": url.PathEscape(meta.Original),
+			"Owner":    strconv.FormatInt(meta.OwnerID, 10),
+			"Encoding": meta.Encoding,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %q: %w", file, err)
+	}
+	return nil
+}
+
+// Get opens an archived file. Objects in a Glacier class get a restore
+// request first and ErrRestorePending until it completes.
+func (a *ColdArchive) Get(ctx context.Context, file string) (io.ReadCloser, ArchiveMeta, error) {
+	key := a.key(file)
+	info, err := a.s3.raw.StatObject(ctx, a.s3.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ArchiveMeta{}, ErrNotArchived
+		}
+		return nil, ArchiveMeta{}, fmt.Errorf("failed to stat archived %q: %w", file, err)
+	}
+
+	if coldStorageClasses[info.StorageClass] && (info.Restore == nil || info.Restore.OngoingRestore) {
+		if info.Restore == nil {
+			req := minio.RestoreRequest{}
+			req.SetDays(a.restoreDays)
+			req.SetGlacierJobParameters(minio.GlacierJobParameters{Tier: minio.TierStandard})
+			if err := a.s3.raw.RestoreObject(ctx, a.s3.bucket, key, "", req); err != nil &&
+				minio.ToErrorResponse(err).Code != "RestoreAlreadyInProgress" {
+				return nil, ArchiveMeta{}, fmt.Errorf("failed to request restore of %q: %w", file, err)
+			}
+		}
+		return nil, ArchiveMeta{}, ErrRestorePending
+	}
+
+	meta := ArchiveMeta{Encoding: info.UserMetadata["Encoding"]}
+	if original, err := url.PathUnescape(info.UserMetadata["Original"]); err == nil && original != "" {
+		meta.Original = original
+	} else {
+		meta.Original = path.Base(file)
+	}
+	meta.OwnerID, _ = strconv.ParseInt(info.UserMetadata["Owner"], 10, 64)
+
+	obj, err := a.s3.raw.GetObject(ctx, a.s3.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ArchiveMeta{}, fmt.Errorf("failed to read archived %q: %w", file, err)
+	}
+	return obj, meta, nil
+}
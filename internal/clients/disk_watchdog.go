@@ -0,0 +1,100 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"debtster-export/internal/reporting"
+)
+
+// ErrDiskFull is returned by Save when the storage filesystem has no space left.
+var ErrDiskFull = errors.New("not enough disk space in the export storage")
+
+// DiskStats is the last disk space reading of a DiskWatchdog.
+type DiskStats struct {
+	FreeBytes    uint64 `json:"free_bytes"`
+	TotalBytes   uint64 `json:"total_bytes"`
+	MinFreeBytes uint64 `json:"min_free_bytes"`
+	Low          bool   `json:"low"`
+}
+
+// DiskWatchdog watches the free space of the storage filesystem. Below the
+// threshold it runs an early cleanup, raises an alert once and reports the
+// storage as low until the space is back, so new exports can be refused
+// before they fail half-way through writing.
+type DiskWatchdog struct {
+	storage *StorageClient
+	minFree uint64
+	cleanup func() (int, error)
+	stat    func(dir string) (free, total uint64, err error)
+
+	free  atomic.Uint64
+	total atomic.Uint64
+	low   atomic.Bool
+}
+
+// NewDiskWatchdog keeps minFree bytes free; cleanup removes what can go
+// earlier than the regular retention and may be nil.
+func NewDiskWatchdog(storage *StorageClient, minFree uint64, cleanup func() (int, error)) *DiskWatchdog {
+	return &DiskWatchdog{storage: storage, minFree: minFree, cleanup: cleanup, stat: diskSpace}
+}
+
+// Check reads the free space and reacts to it; it is a janitor task and
+// returns the number of files the early cleanup removed.
+func (w *DiskWatchdog) Check(ctx context.Context) (int, error) {
+	free, err := w.measure()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	if free < w.minFree && w.cleanup != nil {
+		n, err := w.cleanup()
+		removed = n
+		if err != nil {
+			log.Printf("[DISK] early cleanup: %v", err)
+		}
+		if free, err = w.measure(); err != nil {
+			return removed, err
+		}
+	}
+
+	low := free < w.minFree
+	if was := w.low.Swap(low); low && !was {
+		err := fmt.Errorf("%w: %d MB free in %s, %d MB required; new exports are refused",
+			ErrDiskFull, free>>20, w.storage.BaseDir, w.minFree>>20)
+		log.Printf("[DISK] %v", err)
+		reporting.CaptureError(ctx, err, map[string]string{"component": "storage"})
+	} else if !low && was {
+		log.Printf("[DISK] %d MB free in %s, accepting exports again", free>>20, w.storage.BaseDir)
+	}
+	return removed, nil
+}
+
+func (w *DiskWatchdog) measure() (uint64, error) {
+	free, total, err := w.stat(w.storage.BaseDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read free space of %q: %w", w.storage.BaseDir, err)
+	}
+	w.free.Store(free)
+	w.total.Store(total)
+	return free, nil
+}
+
+// Low reports whether the last check found less free space than required.
+func (w *DiskWatchdog) Low() bool {
+	return w.low.Load()
+}
+
+// Stats returns the last reading.
+func (w *DiskWatchdog) Stats() DiskStats {
+	return DiskStats{
+		FreeBytes:    w.free.Load(),
+		TotalBytes:   w.total.Load(),
+		MinFreeBytes: w.minFree,
+		Low:          w.low.Load(),
+	}
+}
@@ -0,0 +1,51 @@
+package clients
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiskWatchdog(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	free := uint64(100)
+	cleanups := 0
+	w := NewDiskWatchdog(storage, 50, func() (int, error) {
+		cleanups++
+		free += 10 // уборка освобождает немного места
+		return 3, nil
+	})
+	w.stat = func(string) (uint64, uint64, error) { return free, 1000, nil }
+
+	if n, err := w.Check(context.Background()); err != nil || n != 0 || w.Low() || cleanups != 0 {
+		t.Fatalf("enough space: n=%d err=%v low=%v cleanups=%d", n, err, w.Low(), cleanups)
+	}
+
+	// мало места: уборка не помогла — новые выгрузки запрещены
+	free = 20
+	if n, err := w.Check(context.Background()); err != nil || n != 3 || !w.Low() || cleanups != 1 {
+		t.Fatalf("low space: n=%d err=%v low=%v cleanups=%d", n, err, w.Low(), cleanups)
+	}
+	if st := w.Stats(); st.FreeBytes != 30 || st.TotalBytes != 1000 || !st.Low {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	// уборка вернула место
+	free = 45
+	if _, err := w.Check(context.Background()); err != nil || w.Low() {
+		t.Fatalf("after cleanup: err=%v low=%v", err, w.Low())
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	if !linkCountSupported {
+		t.Skip("no statfs")
+	}
+	free, total, err := diskSpace(t.TempDir())
+	if err != nil || total == 0 || free > total {
+		t.Fatalf("free=%d total=%d err=%v", free, total, err)
+	}
+}
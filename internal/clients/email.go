@@ -0,0 +1,108 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/requestid"
+)
+
+type SMTPConfig struct {
+	// Addr is host:port of the SMTP server
+	Addr     string
+	From     string
+	User     string
+	Password string
+}
+
+// UserEmailSource returns the address export emails are sent to.
+// Implemented by *repository.UserRepository.
+type UserEmailSource interface {
+	Email(ctx context.Context, userID int64) (string, error)
+}
+
+// EmailNotifier emails finished and failed exports to the user's address.
+// Like MessengerNotifier, it sends in the background.
+type EmailNotifier struct {
+	cfg      SMTPConfig
+	emails   UserEmailSource
+	inFlight background
+}
+
+func NewEmailNotifier(cfg SMTPConfig, emails UserEmailSource) *EmailNotifier {
+	return &EmailNotifier{cfg: cfg, emails: emails}
+}
+
+// NotifyExportProgress is not emailed.
+func (n *EmailNotifier) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	return nil
+}
+
+func (n *EmailNotifier) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	n.send(ctx, userID, exportID, "Экспорт готов: "+filename, fmt.Sprintf("Файл %s готов к скачиванию:\r\n%s\r\n", filename, url))
+	return nil
+}
+
+func (n *EmailNotifier) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	id := strings.TrimPrefix(exportID, "exports:")
+	n.send(ctx, userID, exportID, "Экспорт завершился с ошибкой", fmt.Sprintf("Экспорт %s завершился с ошибкой:\r\n%s\r\n", id, errMsg))
+	return nil
+}
+
+func (n *EmailNotifier) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	summary := batchSummary(results)
+	subject, _, _ := strings.Cut(summary, "\n")
+	n.send(ctx, userID, batchID, subject, strings.ReplaceAll(summary, "\n", "\r\n")+"\r\n")
+	return nil
+}
+
+// Wait blocks until the emails being sent are done or ctx expires.
+func (n *EmailNotifier) Wait(ctx context.Context) {
+	n.inFlight.wait(ctx)
+}
+
+func (n *EmailNotifier) send(ctx context.Context, userID int64, exportID, subject, body string) {
+	n.inFlight.run(ctx, func(ctx context.Context) {
+		to, err := n.emails.Email(ctx, userID)
+		if err != nil || to == "" {
+			requestid.Logf(ctx, "[NOTIFY] export %s: no email of user %d: %v", exportID, userID, err)
+			return
+		}
+		if err := n.sendMail(to, subject, body); err != nil {
+			requestid.Logf(ctx, "[NOTIFY] export %s: email: %v", exportID, err)
+		}
+	})
+}
+
+func (n *EmailNotifier) sendMail(to, subject, body string) error {
+	// addresses come from the database; refuse anything that could inject headers
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid address %q", to)
+	}
+
+	var auth smtp.Auth
+	if n.cfg.User != "" {
+		host, _, _ := net.SplitHostPort(n.cfg.Addr)
+		auth = smtp.PlainAuth("", n.cfg.User, n.cfg.Password, host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + n.cfg.From,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(n.cfg.Addr, auth, n.cfg.From, []string{to}, []byte(msg))
+}
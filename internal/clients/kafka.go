@@ -0,0 +1,212 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// Kafka message layouts of a profile.
+const (
+	// KafkaModeRow sends one JSON message per row.
+	KafkaModeRow = "row"
+	// KafkaModeNDJSON sends chunks of rows as newline-delimited JSON messages.
+	KafkaModeNDJSON = "ndjson"
+)
+
+// SASL mechanisms of a profile with a user.
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLScramSHA256 = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// kafkaRecordsPerProduce bounds the messages buffered by one produce call.
+const kafkaRecordsPerProduce = 500
+
+// KafkaProfile is a Kafka topic export rows are published to, talking to the
+// brokers directly.
+type KafkaProfile struct {
+	Name string
+	// Brokers are the bootstrap host:port addresses of the cluster.
+	Brokers []string
+	// User and Password authenticate with SASL when User is set.
+	User     string
+	Password string
+	// SASLMechanism is KafkaSASLPlain (default), KafkaSASLScramSHA256 or
+	// KafkaSASLScramSHA512.
+	SASLMechanism string
+	// TLS connects to the brokers over TLS verified with the system roots.
+	TLS   bool
+	Topic string
+	// Mode is KafkaModeRow (default) or KafkaModeNDJSON.
+	Mode string
+	// ChunkRows is the number of rows of an NDJSON message; defaults to 1000.
+	ChunkRows int
+	// KeyField names the row field used as the message key, so the messages of
+	// one entity land in one partition; by default the key is the export id
+	// and the position of the row or chunk.
+	KeyField string
+	Timeout  time.Duration
+}
+
+type kafkaTarget struct {
+	client    *kgo.Client
+	topic     string
+	mode      string
+	chunkRows int
+	keyField  string
+	timeout   time.Duration
+}
+
+// KafkaClient publishes export rows to the configured Kafka profiles.
+type KafkaClient struct {
+	targets map[string]kafkaTarget
+}
+
+// NewKafkaClient checks every profile, so a broken profile fails at startup
+// instead of on the first export. Brokers are not dialed until the first
+// export.
+func NewKafkaClient(profiles []KafkaProfile) (*KafkaClient, error) {
+	c := &KafkaClient{targets: map[string]kafkaTarget{}}
+	for _, p := range profiles {
+		if len(p.Brokers) == 0 || p.Topic == "" {
+			return nil, fmt.Errorf("kafka profile %q: brokers and topic are required", p.Name)
+		}
+		mode := p.Mode
+		if mode == "" {
+			mode = KafkaModeRow
+		}
+		if mode != KafkaModeRow && mode != KafkaModeNDJSON {
+			return nil, fmt.Errorf("kafka profile %q: mode must be %s or %s", p.Name, KafkaModeRow, KafkaModeNDJSON)
+		}
+		timeout := timeoutOr(p.Timeout)
+		opts := []kgo.Opt{
+			kgo.SeedBrokers(p.Brokers...),
+			kgo.DefaultProduceTopic(p.Topic),
+			kgo.RecordDeliveryTimeout(timeout),
+		}
+		if p.User != "" {
+			mechanism, err := kafkaSASL(p)
+			if err != nil {
+				return nil, fmt.Errorf("kafka profile %q: %w", p.Name, err)
+			}
+			opts = append(opts, kgo.SASL(mechanism))
+		}
+		if p.TLS {
+			opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+		}
+		client, err := kgo.NewClient(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("kafka profile %q: %w", p.Name, err)
+		}
+		c.targets[p.Name] = kafkaTarget{
+			client:    client,
+			topic:     p.Topic,
+			mode:      mode,
+			chunkRows: batchSize(p.ChunkRows),
+			keyField:  p.KeyField,
+			timeout:   timeout,
+		}
+	}
+	return c, nil
+}
+
+func kafkaSASL(p KafkaProfile) (sasl.Mechanism, error) {
+	switch strings.ToUpper(p.SASLMechanism) {
+	case "", KafkaSASLPlain:
+		return plain.Auth{User: p.User, Pass: p.Password}.AsMechanism(), nil
+	case KafkaSASLScramSHA256:
+		return scram.Auth{User: p.User, Pass: p.Password}.AsSha256Mechanism(), nil
+	case KafkaSASLScramSHA512:
+		return scram.Auth{User: p.User, Pass: p.Password}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("sasl mechanism must be %s, %s or %s", KafkaSASLPlain, KafkaSASLScramSHA256, KafkaSASLScramSHA512)
+}
+
+// HasProfile reports whether publishing to profile is configured.
+func (c *KafkaClient) HasProfile(profile string) bool {
+	_, ok := c.targets[profile]
+	return ok
+}
+
+// Profiles returns the configured profile names, sorted.
+func (c *KafkaClient) Profiles() []string {
+	return sortedKeys(c.targets)
+}
+
+// Insert publishes rows to the topic of profile and returns the topic name.
+// Delivery is at least once: a retried export publishes the messages that
+// already made it again, with the same keys.
+func (c *KafkaClient) Insert(ctx context.Context, profile, token string, rows []map[string]any) (string, error) {
+	target, ok := c.targets[profile]
+	if !ok {
+		return "", fmt.Errorf("unknown kafka profile %q", profile)
+	}
+
+	records, err := target.records(token, rows)
+	if err != nil {
+		return "", err
+	}
+	for start := 0; start < len(records); start += kafkaRecordsPerProduce {
+		end := min(start+kafkaRecordsPerProduce, len(records))
+		if err := target.produce(ctx, records[start:end]); err != nil {
+			return "", fmt.Errorf("produce messages %d-%d: %w", start+1, end, err)
+		}
+	}
+	return target.topic, nil
+}
+
+// records lays rows out as messages of the profile mode.
+func (t kafkaTarget) records(token string, rows []map[string]any) ([]*kgo.Record, error) {
+	var out []*kgo.Record
+	if t.mode == KafkaModeRow {
+		out = make([]*kgo.Record, 0, len(rows))
+		for i, row := range rows {
+			value, err := json.Marshal(row)
+			if err != nil {
+				return nil, fmt.Errorf("encode row: %w", err)
+			}
+			out = append(out, &kgo.Record{Key: t.key(token, i, row), Value: value})
+		}
+		return out, nil
+	}
+
+	for start, chunk := 0, 0; start < len(rows); start, chunk = start+t.chunkRows, chunk+1 {
+		var value bytes.Buffer
+		enc := json.NewEncoder(&value)
+		for _, row := range rows[start:min(start+t.chunkRows, len(rows))] {
+			if err := enc.Encode(row); err != nil {
+				return nil, fmt.Errorf("encode row: %w", err)
+			}
+		}
+		out = append(out, &kgo.Record{Key: []byte(fmt.Sprintf("%s:%d", token, chunk)), Value: value.Bytes()})
+	}
+	return out, nil
+}
+
+func (t kafkaTarget) key(token string, i int, row map[string]any) []byte {
+	if t.keyField != "" {
+		if v, ok := row[t.keyField]; ok && v != nil {
+			return []byte(fmt.Sprint(v))
+		}
+	}
+	return []byte(fmt.Sprintf("%s:%d", token, i))
+}
+
+// produce waits until the brokers acknowledge every record; the client
+// retries on its own until the profile timeout.
+func (t kafkaTarget) produce(ctx context.Context, records []*kgo.Record) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.client.ProduceSync(ctx, records...).FirstErr()
+}
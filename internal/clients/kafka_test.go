@@ -0,0 +1,88 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKafkaTarget_Records(t *testing.T) {
+	rows := []map[string]any{{"number": "D-1", "amount": 10.5}, {"number": "D-2"}, {"number": nil}}
+
+	// сообщение на строку, ключ — поле строки или позиция строки в экспорте
+	got, err := kafkaTarget{mode: KafkaModeRow, keyField: "number"}.records("exports:1", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("%d messages", len(got))
+	}
+	if string(got[0].Key) != "D-1" || string(got[0].Value) != `{"amount":10.5,"number":"D-1"}` || string(got[2].Key) != "exports:1:2" {
+		t.Fatalf("row messages: %s=%s, %s", got[0].Key, got[0].Value, got[2].Key)
+	}
+
+	// NDJSON пачками по две строки
+	got, err = kafkaTarget{mode: KafkaModeNDJSON, chunkRows: 2}.records("exports:1", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || string(got[1].Key) != "exports:1:1" {
+		t.Fatalf("chunk messages: %d", len(got))
+	}
+	if lines := strings.Split(strings.TrimSpace(string(got[0].Value)), "\n"); len(lines) != 2 || lines[1] != `{"number":"D-2"}` {
+		t.Fatalf("first chunk: %q", got[0].Value)
+	}
+}
+
+func TestNewKafkaClient(t *testing.T) {
+	c, err := NewKafkaClient([]KafkaProfile{
+		{Name: "events", Brokers: []string{"kafka-1:9092"}, Topic: "export.rows"},
+		{Name: "secure", Brokers: []string{"kafka-1:9093"}, Topic: "export.rows", User: "export", Password: "secret", SASLMechanism: KafkaSASLScramSHA512, TLS: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Profiles(); len(got) != 2 || got[0] != "events" || !c.HasProfile("secure") {
+		t.Fatalf("profiles = %v", got)
+	}
+
+	for _, p := range []KafkaProfile{
+		{Name: "proxy", Topic: "t"},
+		{Name: "mode", Brokers: []string{"kafka-1:9092"}, Topic: "t", Mode: "avro"},
+		{Name: "sasl", Brokers: []string{"kafka-1:9092"}, Topic: "t", User: "export", SASLMechanism: "GSSAPI"},
+	} {
+		if _, err := NewKafkaClient([]KafkaProfile{p}); err == nil || !strings.Contains(err.Error(), `"`+p.Name+`"`) {
+			t.Errorf("profile %s: err = %v", p.Name, err)
+		}
+	}
+}
+
+func TestKafkaClient_InsertUnreachable(t *testing.T) {
+	// порт, который никто не слушает
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	c, err := NewKafkaClient([]KafkaProfile{{Name: "events", Brokers: []string{addr}, Topic: "export.rows", Timeout: time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// недоступный брокер — ошибка по таймауту профиля, а не зависание экспорта
+	start := time.Now()
+	_, err = c.Insert(context.Background(), "events", "exports:1", []map[string]any{{"number": "D-1"}})
+	if err == nil || !strings.Contains(err.Error(), "produce messages 1-1") {
+		t.Fatalf("err = %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("insert took %s", d)
+	}
+	if _, err := c.Insert(context.Background(), "missing", "exports:1", nil); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,175 @@
+package clients
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"debtster-export/internal/tenant"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// extendLockScript prolongs the lock at KEYS[1] to ARGV[2] ms while it is
+// still held with the token ARGV[1].
+var extendLockScript = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript deletes the lock at KEYS[1] only when it is held with the
+// token ARGV[1], so a lock taken over after expiry is never released.
+var releaseLockScript = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Lock is a lock held in Redis by this instance, see RedisClient.TryLock. It
+// is prolonged in the background until released or lost.
+type Lock struct {
+	redis *RedisClient
+	key   string
+	token string
+	ttl   time.Duration
+
+	stop     chan struct{}
+	lost     chan struct{}
+	stopOnce sync.Once
+	done     sync.WaitGroup
+}
+
+// TryLock takes the cluster-wide lock name for ttl; it returns nil without an
+// error when another instance holds it. Locks are shared by all tenants.
+//
+// The lock is prolonged every ttl/3 while held, so a holder that dies frees it
+// within ttl and a live holder keeps it for as long as it works.
+func (c *RedisClient) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	key := c.withPrefix(tenant.Without(ctx), "lock:"+name)
+	token := lockToken()
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ok, err := c.raw.SetNX(ctx, key, token, ttl).Result()
+	if err := c.done("setnx", err); err != nil || !ok {
+		return nil, err
+	}
+
+	l := &Lock{redis: c, key: key, token: token, ttl: ttl, stop: make(chan struct{}), lost: make(chan struct{})}
+	l.done.Add(1)
+	go l.keepAlive()
+	return l, nil
+}
+
+// Lost is closed once the lock could not be prolonged and may be held by
+// another instance.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops prolonging the lock and frees it if it is still held.
+func (l *Lock) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	l.done.Wait()
+
+	ctx, cancel := l.redis.withTimeout(ctx)
+	defer cancel()
+	return l.redis.done("eval", releaseLockScript.Run(ctx, l.redis.raw, []string{l.key}, l.token).Err())
+}
+
+func (l *Lock) keepAlive() {
+	defer l.done.Done()
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	held := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := l.redis.withTimeout(context.Background())
+		n, err := extendLockScript.Run(ctx, l.redis.raw, []string{l.key}, l.token, l.ttl.Milliseconds()).Int64()
+		cancel()
+		switch {
+		case l.redis.done("eval", err) == nil && n == 1:
+			held = time.Now()
+			continue
+		case err == nil, time.Since(held) >= l.ttl:
+			// taken over, or expired while Redis was unreachable
+			log.Printf("[REDIS] lock %s lost", l.key)
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// lockToken identifies the holder of a lock: host name plus a random suffix,
+// unique per lock.
+func lockToken() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return host + ":" + hex.EncodeToString(b)
+}
+
+// LeaderElection keeps one instance of the cluster the leader for a name:
+// instances campaign for a lock and the holder leads until it stops or loses
+// the lock. The work only the leader should do checks IsLeader.
+type LeaderElection struct {
+	redis  *RedisClient
+	name   string
+	ttl    time.Duration
+	leader atomic.Bool
+}
+
+// NewLeaderElection elects a leader for name; a dead leader is replaced
+// within ttl.
+func NewLeaderElection(redis *RedisClient, name string, ttl time.Duration) *LeaderElection {
+	return &LeaderElection{redis: redis, name: name, ttl: ttl}
+}
+
+// IsLeader reports whether this instance leads.
+func (e *LeaderElection) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run campaigns every ttl/3 until ctx is done, then steps down so another
+// instance takes over at once.
+func (e *LeaderElection) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		lock, err := e.redis.TryLock(ctx, "leader:"+e.name, e.ttl)
+		if err != nil {
+			log.Printf("[REDIS] leader election %s: %v", e.name, err)
+		}
+		if lock != nil {
+			e.leader.Store(true)
+			log.Printf("[REDIS] elected the %s leader", e.name)
+			select {
+			case <-ctx.Done():
+			case <-lock.Lost():
+			}
+			e.leader.Store(false)
+			_ = lock.Release(context.WithoutCancel(ctx))
+			log.Printf("[REDIS] no longer the %s leader", e.name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
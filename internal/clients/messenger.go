@@ -0,0 +1,290 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/requestid"
+)
+
+// messengerTimeout bounds one notification, so a slow messenger API never
+// piles up goroutines.
+const messengerTimeout = 30 * time.Second
+
+// TelegramClient sends messages on behalf of the service bot.
+type TelegramClient struct {
+	apiURL string
+	token  string
+	http   *http.Client
+}
+
+// NewTelegramClient creates a client of the bot with token; apiURL defaults to
+// the public Bot API.
+func NewTelegramClient(token, apiURL string) *TelegramClient {
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	return &TelegramClient{
+		apiURL: strings.TrimRight(apiURL, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: messengerTimeout},
+	}
+}
+
+// Send posts text to chatID; the user must have started a chat with the bot.
+func (c *TelegramClient) Send(ctx context.Context, chatID, text string) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	var reply struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	status, err := postJSON(ctx, c.http, c.apiURL+"/bot"+c.token+"/sendMessage", body, &reply)
+	if err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+	if !reply.OK {
+		return fmt.Errorf("telegram: status %d: %s", status, reply.Description)
+	}
+	return nil
+}
+
+// SlackClient posts messages to Slack incoming webhooks.
+type SlackClient struct {
+	http *http.Client
+}
+
+func NewSlackClient() *SlackClient {
+	return &SlackClient{http: &http.Client{Timeout: messengerTimeout}}
+}
+
+// Send posts text to the incoming webhook of the user's channel.
+func (c *SlackClient) Send(ctx context.Context, webhookURL, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	status, err := postJSON(ctx, c.http, webhookURL, body, nil)
+	if err != nil {
+		return fmt.Errorf("slack: %w", err)
+	}
+	if status/100 != 2 {
+		return fmt.Errorf("slack: status %d", status)
+	}
+	return nil
+}
+
+// postJSON sends body and decodes a JSON reply into out (when not nil). The
+// URL carries secrets (bot token, webhook path), so it is kept out of errors.
+func postJSON(ctx context.Context, client *http.Client, target string, body []byte, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.New("invalid url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("status %d: bad reply: %w", resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// NotificationSettingsSource returns the messengers a user has opted into.
+// Implemented by *repository.NotificationSettingsRepository.
+type NotificationSettingsSource interface {
+	Get(ctx context.Context, userID int64) (domain.NotificationSettings, error)
+}
+
+// MessengerNotifier sends finished and failed exports to the Telegram chat and
+// Slack webhook the user configured. Messages are sent in the background, so
+// a slow messenger never delays the export.
+type MessengerNotifier struct {
+	settings NotificationSettingsSource
+	telegram *TelegramClient
+	slack    *SlackClient
+	inFlight background
+}
+
+// NewMessengerNotifier creates the notifier; a nil client disables that messenger.
+func NewMessengerNotifier(settings NotificationSettingsSource, telegram *TelegramClient, slack *SlackClient) *MessengerNotifier {
+	return &MessengerNotifier{settings: settings, telegram: telegram, slack: slack}
+}
+
+// NotifyExportProgress is not sent to messengers.
+func (m *MessengerNotifier) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	return nil
+}
+
+func (m *MessengerNotifier) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	m.send(ctx, userID, exportID, fmt.Sprintf("Экспорт готов: %s\n%s", filename, url))
+	return nil
+}
+
+func (m *MessengerNotifier) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	m.send(ctx, userID, exportID, fmt.Sprintf("Экспорт %s завершился с ошибкой: %s", strings.TrimPrefix(exportID, "exports:"), errMsg))
+	return nil
+}
+
+func (m *MessengerNotifier) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	m.send(ctx, userID, batchID, batchSummary(results))
+	return nil
+}
+
+func (m *MessengerNotifier) send(ctx context.Context, userID int64, exportID, text string) {
+	m.inFlight.run(ctx, func(ctx context.Context) {
+		settings, err := m.settings.Get(ctx, userID)
+		if err != nil {
+			requestid.Logf(ctx, "[NOTIFY] export %s: load settings of user %d: %v", exportID, userID, err)
+			return
+		}
+		if m.telegram != nil && settings.TelegramChatID != "" {
+			if err := m.telegram.Send(ctx, settings.TelegramChatID, text); err != nil {
+				requestid.Logf(ctx, "[NOTIFY] export %s: %v", exportID, err)
+			}
+		}
+		if m.slack != nil && settings.SlackWebhookURL != "" {
+			if err := m.slack.Send(ctx, settings.SlackWebhookURL, text); err != nil {
+				requestid.Logf(ctx, "[NOTIFY] export %s: %v", exportID, err)
+			}
+		}
+	})
+}
+
+// Wait blocks until the messages being sent are done or ctx expires, for
+// graceful shutdown.
+func (m *MessengerNotifier) Wait(ctx context.Context) {
+	m.inFlight.wait(ctx)
+}
+
+// background runs notifications off the export goroutine, each bounded by
+// messengerTimeout, and lets shutdown wait for them.
+type background struct {
+	wg sync.WaitGroup
+}
+
+func (b *background) run(ctx context.Context, fn func(ctx context.Context)) {
+	// the job context may be cancelled right after the export finishes
+	ctx = context.WithoutCancel(ctx)
+	b.wg.Go(func() {
+		ctx, cancel := context.WithTimeout(ctx, messengerTimeout)
+		defer cancel()
+		fn(ctx)
+	})
+}
+
+func (b *background) wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// ExportNotifier receives export events; implemented by WebSocketClient and MessengerNotifier.
+type ExportNotifier interface {
+	NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error
+	NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error
+	NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error
+	NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error
+}
+
+// MultiNotifier delivers every event to all notifiers; a failing one does not
+// stop the others.
+type MultiNotifier []ExportNotifier
+
+func (m MultiNotifier) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.NotifyExportProgress(ctx, userID, exportID, progress, stage))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.NotifyExportComplete(ctx, userID, exportID, url, filename))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.NotifyExportFailed(ctx, userID, exportID, errMsg))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.NotifyBatchComplete(ctx, userID, batchID, results))
+	}
+	return errors.Join(errs...)
+}
+
+// batchSummary is the message text of a finished batch: a header and the file
+// link or error of every export.
+func batchSummary(results []domain.ExportBatchResult) string {
+	failed := 0
+	lines := make([]string, 0, len(results)+1)
+	lines = append(lines, "")
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+			lines = append(lines, fmt.Sprintf("%s — ошибка: %s", r.Type, r.Error))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", r.Filename, r.FileURL))
+	}
+	lines[0] = fmt.Sprintf("Пакет экспортов готов: %d из %d", len(results)-failed, len(results))
+	return strings.Join(lines, "\n")
+}
+
+// batchEvent is the event a finished batch counts as: failed only when no
+// export of it succeeded.
+func batchEvent(results []domain.ExportBatchResult) string {
+	for _, r := range results {
+		if r.Error == "" {
+			return domain.NotifyEventComplete
+		}
+	}
+	return domain.NotifyEventFailed
+}
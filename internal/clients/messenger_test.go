@@ -0,0 +1,75 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"debtster-export/internal/domain"
+)
+
+type staticSettings map[int64]domain.NotificationSettings
+
+func (s staticSettings) Get(_ context.Context, userID int64) (domain.NotificationSettings, error) {
+	return s[userID], nil
+}
+
+func TestMessengerNotifier_NotifyExportComplete(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls = map[string]map[string]any{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		calls[r.URL.Path] = body
+		mu.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/bot") {
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer server.Close()
+
+	settings := staticSettings{
+		7: {UserID: 7, TelegramChatID: "12345", SlackWebhookURL: server.URL + "/services/T/B/X"},
+		// пользователь без мессенджеров
+		8: {UserID: 8},
+	}
+	n := NewMessengerNotifier(settings, NewTelegramClient("secret", server.URL), NewSlackClient())
+
+	_ = n.NotifyExportComplete(context.Background(), 7, "exports:1", "https://example.com/files/a_debts.xlsx", "debts.xlsx")
+	_ = n.NotifyExportComplete(context.Background(), 8, "exports:2", "https://example.com/files/b_debts.xlsx", "debts.xlsx")
+	n.Wait(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 {
+		t.Fatalf("want one telegram and one slack message, got %v", calls)
+	}
+	tg := calls["/botsecret/sendMessage"]
+	if tg["chat_id"] != "12345" || !strings.Contains(tg["text"].(string), "https://example.com/files/a_debts.xlsx") {
+		t.Fatalf("telegram message: %v", tg)
+	}
+	slack := calls["/services/T/B/X"]
+	if !strings.Contains(slack["text"].(string), "debts.xlsx") {
+		t.Fatalf("slack message: %v", slack)
+	}
+}
+
+func TestTelegramClient_ErrorHidesToken(t *testing.T) {
+	c := NewTelegramClient("secret-token", "http://127.0.0.1:1")
+
+	err := c.Send(context.Background(), "1", "hi")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Fatalf("error leaks the bot token: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/requestid"
+	"debtster-export/internal/tenant"
+)
+
+// maxCachedSettings bounds the settings cache; it is simply reset when full.
+const maxCachedSettings = 10_000
+
+// NotificationSettingsStore reads and saves per-user notification settings.
+// Implemented by *repository.NotificationSettingsRepository.
+type NotificationSettingsStore interface {
+	NotificationSettingsSource
+	Save(ctx context.Context, s domain.NotificationSettings) error
+}
+
+type settingsKey struct {
+	tenant string
+	userID int64
+}
+
+type cachedSettings struct {
+	settings domain.NotificationSettings
+	expires  time.Time
+}
+
+// CachedNotificationSettings keeps settings in memory for ttl, so progress
+// events do not hit the database. Saves through it take effect at once on this
+// instance and within ttl on the others.
+type CachedNotificationSettings struct {
+	store NotificationSettingsStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[settingsKey]cachedSettings
+}
+
+func NewCachedNotificationSettings(store NotificationSettingsStore, ttl time.Duration) *CachedNotificationSettings {
+	return &CachedNotificationSettings{store: store, ttl: ttl, entries: map[settingsKey]cachedSettings{}}
+}
+
+func (c *CachedNotificationSettings) Get(ctx context.Context, userID int64) (domain.NotificationSettings, error) {
+	key := settingsCacheKey(ctx, userID)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.settings, nil
+	}
+
+	settings, err := c.store.Get(ctx, userID)
+	if err != nil {
+		return settings, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedSettings {
+		c.entries = map[settingsKey]cachedSettings{}
+	}
+	c.entries[key] = cachedSettings{settings: settings, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return settings, nil
+}
+
+func (c *CachedNotificationSettings) Save(ctx context.Context, s domain.NotificationSettings) error {
+	if err := c.store.Save(ctx, s); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.entries, settingsCacheKey(ctx, s.UserID))
+	c.mu.Unlock()
+	return nil
+}
+
+func settingsCacheKey(ctx context.Context, userID int64) settingsKey {
+	key := settingsKey{userID: userID}
+	if t, ok := tenant.FromContext(ctx); ok {
+		key.tenant = t.ID
+	}
+	return key
+}
+
+// PreferenceNotifier passes to next only the events the user wants over
+// channel. When the settings cannot be loaded the defaults apply.
+type PreferenceNotifier struct {
+	channel  string
+	next     ExportNotifier
+	settings NotificationSettingsSource
+}
+
+func NewPreferenceNotifier(channel string, next ExportNotifier, settings NotificationSettingsSource) *PreferenceNotifier {
+	return &PreferenceNotifier{channel: channel, next: next, settings: settings}
+}
+
+func (p *PreferenceNotifier) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	if !p.wants(ctx, userID, domain.NotifyEventProgress) {
+		return nil
+	}
+	return p.next.NotifyExportProgress(ctx, userID, exportID, progress, stage)
+}
+
+func (p *PreferenceNotifier) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	if !p.wants(ctx, userID, domain.NotifyEventComplete) {
+		return nil
+	}
+	return p.next.NotifyExportComplete(ctx, userID, exportID, url, filename)
+}
+
+func (p *PreferenceNotifier) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	if !p.wants(ctx, userID, domain.NotifyEventFailed) {
+		return nil
+	}
+	return p.next.NotifyExportFailed(ctx, userID, exportID, errMsg)
+}
+
+func (p *PreferenceNotifier) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	if !p.wants(ctx, userID, batchEvent(results)) {
+		return nil
+	}
+	return p.next.NotifyBatchComplete(ctx, userID, batchID, results)
+}
+
+func (p *PreferenceNotifier) wants(ctx context.Context, userID int64, event string) bool {
+	settings, err := p.settings.Get(ctx, userID)
+	if err != nil {
+		requestid.Logf(ctx, "[NOTIFY] load settings of user %d: %v", userID, err)
+		settings = domain.NotificationSettings{UserID: userID}
+	}
+	return settings.Wants(p.channel, event)
+}
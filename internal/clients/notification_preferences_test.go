@@ -0,0 +1,106 @@
+package clients
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+)
+
+type recordingNotifier struct {
+	events []string
+}
+
+func (r *recordingNotifier) NotifyExportProgress(context.Context, int64, string, float64, string) error {
+	r.events = append(r.events, domain.NotifyEventProgress)
+	return nil
+}
+
+func (r *recordingNotifier) NotifyExportComplete(context.Context, int64, string, string, string) error {
+	r.events = append(r.events, domain.NotifyEventComplete)
+	return nil
+}
+
+func (r *recordingNotifier) NotifyExportFailed(context.Context, int64, string, string) error {
+	r.events = append(r.events, domain.NotifyEventFailed)
+	return nil
+}
+
+func (r *recordingNotifier) NotifyBatchComplete(_ context.Context, _ int64, _ string, results []domain.ExportBatchResult) error {
+	r.events = append(r.events, "batch_"+batchEvent(results))
+	return nil
+}
+
+func notifyAll(n ExportNotifier, userID int64) {
+	ctx := context.Background()
+	_ = n.NotifyExportProgress(ctx, userID, "exports:1", 50, "")
+	_ = n.NotifyExportComplete(ctx, userID, "exports:1", "/files/a.xlsx", "a.xlsx")
+	_ = n.NotifyExportFailed(ctx, userID, "exports:1", "boom")
+}
+
+func TestPreferenceNotifier(t *testing.T) {
+	settings := staticSettings{
+		// только итог экспорта и только в websocket
+		1: {UserID: 1, Events: []string{domain.NotifyEventComplete, domain.NotifyEventFailed}, Channels: []string{domain.NotifyChannelWebSocket}},
+		// всё выключено
+		2: {UserID: 2, Events: []string{}, Channels: []string{domain.NotifyChannelWebSocket}},
+	}
+
+	tests := []struct {
+		name    string
+		channel string
+		userID  int64
+		want    int
+	}{
+		{"defaults", domain.NotifyChannelWebSocket, 3, 3},
+		{"email is opt-in", domain.NotifyChannelEmail, 3, 0},
+		{"selected events", domain.NotifyChannelWebSocket, 1, 2},
+		{"channel not selected", domain.NotifyChannelMessenger, 1, 0},
+		{"no events", domain.NotifyChannelWebSocket, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recordingNotifier{}
+			notifyAll(NewPreferenceNotifier(tt.channel, rec, settings), tt.userID)
+			if len(rec.events) != tt.want {
+				t.Fatalf("got events %v, want %d", rec.events, tt.want)
+			}
+		})
+	}
+}
+
+type countingStore struct {
+	staticSettings
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, userID int64) (domain.NotificationSettings, error) {
+	s.gets++
+	return s.staticSettings.Get(ctx, userID)
+}
+
+func (s *countingStore) Save(_ context.Context, v domain.NotificationSettings) error {
+	s.staticSettings[v.UserID] = v
+	return nil
+}
+
+func TestCachedNotificationSettings(t *testing.T) {
+	store := &countingStore{staticSettings: staticSettings{1: {UserID: 1}}}
+	c := NewCachedNotificationSettings(store, time.Minute)
+	ctx := context.Background()
+
+	_, _ = c.Get(ctx, 1)
+	_, _ = c.Get(ctx, 1)
+	if store.gets != 1 {
+		t.Fatalf("settings must be cached, got %d loads", store.gets)
+	}
+
+	if err := c.Save(ctx, domain.NotificationSettings{UserID: 1, Events: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := c.Get(ctx, 1)
+	if got.Events == nil || store.gets != 2 {
+		t.Fatalf("save must invalidate the cache: %+v after %d loads", got, store.gets)
+	}
+}
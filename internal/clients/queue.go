@@ -0,0 +1,235 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"debtster-export/internal/tenant"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// claimScript moves the first due job of the pending set KEYS[1] to the active
+// set KEYS[2] with the lease deadline ARGV[2] and counts the attempt in
+// KEYS[4]. ARGV[1] is now (ms). Returns {id, payload, attempts} or nil.
+var claimScript = goredis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #ids == 0 then
+	return false
+end
+local id = ids[1]
+redis.call('ZREM', KEYS[1], id)
+redis.call('ZADD', KEYS[2], ARGV[2], id)
+local attempts = redis.call('HINCRBY', KEYS[4], id, 1)
+return {id, redis.call('HGET', KEYS[3], id) or '', attempts}
+`)
+
+// extendScript prolongs the lease of the active job ARGV[1] to ARGV[3] if it
+// is still held under claim ARGV[2] (its attempt count in KEYS[2]).
+var extendScript = goredis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) and redis.call('HGET', KEYS[2], ARGV[1]) == ARGV[2] then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// completeScript forgets the active job ARGV[1] if it is still held under
+// claim ARGV[2].
+var completeScript = goredis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('HGET', KEYS[4], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+return 1
+`)
+
+// reclaimScript takes the job ARGV[1] back from the active set KEYS[1] if its
+// lease is over at ARGV[2] (ms). With fewer than ARGV[3] attempts (KEYS[4]) it
+// is moved to the pending set KEYS[2], due attempts*ARGV[4] ms later; otherwise
+// it stays active under a new claim (its attempt counted) until ARGV[5] ms
+// later, for the caller to give up on. Returns {payload, attempts, requeued}
+// or nil.
+var reclaimScript = goredis.NewScript(`
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+local now = tonumber(ARGV[2])
+if not deadline or tonumber(deadline) > now then
+	return false
+end
+local payload = redis.call('HGET', KEYS[3], ARGV[1]) or ''
+local attempts = tonumber(redis.call('HGET', KEYS[4], ARGV[1]) or '0')
+if attempts < tonumber(ARGV[3]) then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('ZADD', KEYS[2], now + attempts * tonumber(ARGV[4]), ARGV[1])
+	return {payload, attempts, 1}
+end
+redis.call('HINCRBY', KEYS[4], ARGV[1], 1)
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[5]), ARGV[1])
+return {payload, attempts, 0}
+`)
+
+// removeScript drops the pending job ARGV[1].
+var removeScript = goredis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// RedisQueue is a durable job queue in Redis shared by all instances and
+// tenants. A job is pending (due or scheduled for later) until an instance
+// claims it; a claimed job is active under a lease the holder prolongs, and
+// a job whose lease ran out is reclaimed: made pending again or given up. A
+// claim is identified by the attempt count it returned, so a holder that lost
+// its lease can neither prolong nor complete the claim of the next one.
+type RedisQueue struct {
+	redis *RedisClient
+	name  string
+}
+
+// NewRedisQueue opens the queue name.
+func NewRedisQueue(redis *RedisClient, name string) *RedisQueue {
+	return &RedisQueue{redis: redis, name: name}
+}
+
+func (q *RedisQueue) key(part string) string {
+	return q.redis.withPrefix(tenant.Without(context.Background()), "queue:"+q.name+":"+part)
+}
+
+// Push adds the job id, due at runAt. Pushing a known id replaces its payload
+// and schedule and keeps its attempt count.
+func (q *RedisQueue) Push(ctx context.Context, id, payload string, runAt time.Time) error {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	_, err := q.redis.raw.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(ctx, q.key("jobs"), id, payload)
+		pipe.ZAdd(ctx, q.key("pending"), goredis.Z{Score: float64(runAt.UnixMilli()), Member: id})
+		return nil
+	})
+	return q.redis.done("multi", err)
+}
+
+// Claim takes the first due job for lease; id is empty when none is due.
+// attempts counts the claims of the job including this one.
+func (q *RedisQueue) Claim(ctx context.Context, lease time.Duration) (id, payload string, attempts int, err error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	now := time.Now()
+	res, err := claimScript.Run(ctx, q.redis.raw,
+		[]string{q.key("pending"), q.key("active"), q.key("jobs"), q.key("attempts")},
+		now.UnixMilli(), now.Add(lease).UnixMilli(),
+	).Slice()
+	if errors.Is(err, goredis.Nil) {
+		return "", "", 0, nil
+	}
+	if err := q.redis.done("eval", err); err != nil {
+		return "", "", 0, err
+	}
+	id, _ = res[0].(string)
+	payload, _ = res[1].(string)
+	n, _ := res[2].(int64)
+	return id, payload, int(n), nil
+}
+
+// Extend prolongs the lease of the claim attempt of an active job; false
+// means the claim is over (completed, acknowledged or reclaimed).
+func (q *RedisQueue) Extend(ctx context.Context, id string, attempt int, lease time.Duration) (bool, error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	n, err := extendScript.Run(ctx, q.redis.raw, []string{q.key("active"), q.key("attempts")},
+		id, attempt, time.Now().Add(lease).UnixMilli()).Int64()
+	return n == 1, q.redis.done("eval", err)
+}
+
+// Complete forgets the job id its holder finished; false means the claim
+// attempt is over and the job was left to its current holder or schedule.
+func (q *RedisQueue) Complete(ctx context.Context, id string, attempt int) (bool, error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	n, err := completeScript.Run(ctx, q.redis.raw,
+		[]string{q.key("active"), q.key("pending"), q.key("jobs"), q.key("attempts")}, id, attempt).Int64()
+	return n == 1, q.redis.done("eval", err)
+}
+
+// Ack forgets the job id whatever its state: it is given up or cannot run.
+func (q *RedisQueue) Ack(ctx context.Context, id string) error {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	_, err := q.redis.raw.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.ZRem(ctx, q.key("active"), id)
+		pipe.ZRem(ctx, q.key("pending"), id)
+		pipe.HDel(ctx, q.key("jobs"), id)
+		pipe.HDel(ctx, q.key("attempts"), id)
+		return nil
+	})
+	return q.redis.done("multi", err)
+}
+
+// Remove drops a pending job; false means it is not pending (claimed already
+// or unknown).
+func (q *RedisQueue) Remove(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	n, err := removeScript.Run(ctx, q.redis.raw, []string{q.key("pending"), q.key("jobs"), q.key("attempts")}, id).Int64()
+	return n == 1, q.redis.done("eval", err)
+}
+
+// Expired lists the active jobs whose lease ran out: their holder died or
+// lost Redis.
+func (q *RedisQueue) Expired(ctx context.Context) ([]string, error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	ids, err := q.redis.raw.ZRangeByScore(ctx, q.key("active"), &goredis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	return ids, q.redis.done("zrangebyscore", err)
+}
+
+// Reclaim takes an expired job back from its lost holder in one step, so a
+// caller dying halfway cannot lose it. While the job has attempts left (fewer
+// than maxAttempts) it is pending again, due after attempts*backoff, with its
+// attempt count kept. Otherwise requeued is false and the job stays active
+// under a claim of its own for hold: the caller gives up on it and acknowledges
+// it, or, should it die first, the next reclaim does. ok is false when the
+// lease was prolonged or another instance reclaimed the job first.
+func (q *RedisQueue) Reclaim(ctx context.Context, id string, maxAttempts int, backoff, hold time.Duration) (payload string, attempts int, requeued, ok bool, err error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	res, err := reclaimScript.Run(ctx, q.redis.raw,
+		[]string{q.key("active"), q.key("pending"), q.key("jobs"), q.key("attempts")},
+		id, time.Now().UnixMilli(), maxAttempts, backoff.Milliseconds(), hold.Milliseconds(),
+	).Slice()
+	if errors.Is(err, goredis.Nil) {
+		return "", 0, false, false, nil
+	}
+	if err := q.redis.done("eval", err); err != nil {
+		return "", 0, false, false, err
+	}
+	payload, _ = res[0].(string)
+	n, _ := res[1].(int64)
+	back, _ := res[2].(int64)
+	return payload, int(n), back == 1, true, nil
+}
+
+// Stats counts the due, scheduled and active jobs.
+func (q *RedisQueue) Stats(ctx context.Context) (pending, scheduled, active int64, err error) {
+	ctx, cancel := q.redis.withTimeout(ctx)
+	defer cancel()
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := q.redis.raw.Pipeline()
+	due := pipe.ZCount(ctx, q.key("pending"), "-inf", now)
+	later := pipe.ZCount(ctx, q.key("pending"), "("+now, "+inf")
+	running := pipe.ZCard(ctx, q.key("active"))
+	_, err = pipe.Exec(ctx)
+	if err := q.redis.done("pipeline", err); err != nil {
+		return 0, 0, 0, err
+	}
+	return due.Val(), later.Val(), running.Val(), nil
+}
@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills the bucket stored at KEYS[1] and takes one token.
+// ARGV: refill rate (tokens per ms), burst, now (ms). Returns {allowed, wait_ms}.
+var tokenBucketScript = goredis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+
+return {allowed, wait}
+`)
+
+// RateLimiter is a token bucket per key kept in Redis, so the limit is shared
+// by all instances of the service.
+type RateLimiter struct {
+	redis *RedisClient
+	name  string
+	// perMinute tokens are added per minute, up to burst
+	perMinute int
+	burst     int
+}
+
+// NewRateLimiter returns nil when perMinute <= 0, i.e. the limit is disabled.
+func NewRateLimiter(redis *RedisClient, name string, perMinute, burst int) *RateLimiter {
+	if redis == nil || perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{redis: redis, name: name, perMinute: perMinute, burst: burst}
+}
+
+// Allow takes a token for key; when none is left it returns how long to wait.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	if l == nil {
+		return true, 0, nil
+	}
+
+	rate := float64(l.perMinute) / float64(time.Minute.Milliseconds())
+	res, err := tokenBucketScript.Run(
+		ctx,
+		l.redis.raw,
+		[]string{l.redis.withPrefix(ctx, fmt.Sprintf("ratelimit:%s:%s", l.name, key))},
+		rate, l.burst, time.Now().UnixMilli(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply: %v", res)
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
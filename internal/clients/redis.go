@@ -2,10 +2,17 @@ package clients
 
 import (
 	"context"
+	"errors"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"debtster-export/internal/tenant"
 	"debtster-export/pkg/cache/redis"
+
+	goredis "github.com/redis/go-redis/v9"
 )
 
 type RedisConfig struct {
@@ -22,6 +29,23 @@ type RedisConfig struct {
 type RedisClient struct {
 	raw    *redis.Client
 	prefix string
+	// timeout bounds a command whose context has no deadline of its own
+	timeout time.Duration
+	stats   redisStats
+}
+
+// RedisStats counts the commands of a RedisClient since start. A missing key
+// is not an error.
+type RedisStats struct {
+	Commands int64            `json:"commands"`
+	Errors   int64            `json:"errors"`
+	Timeouts int64            `json:"timeouts"`
+	ByError  map[string]int64 `json:"errors_by_command,omitempty"`
+}
+
+type redisStats struct {
+	mu sync.Mutex
+	RedisStats
 }
 
 func NewRedisClient(cfg RedisConfig) (*RedisClient, error) {
@@ -47,8 +71,9 @@ func NewRedisClient(cfg RedisConfig) (*RedisClient, error) {
 	}
 
 	return &RedisClient{
-		raw:    rdb,
-		prefix: prefix,
+		raw:     rdb,
+		prefix:  prefix,
+		timeout: cfg.Timeout,
 	}, nil
 }
 
@@ -59,22 +84,166 @@ func (c *RedisClient) Close() {
 	redis.Close(c.raw)
 }
 
-func (c *RedisClient) withPrefix(key string) string {
+// Stats returns the command counters since start.
+func (c *RedisClient) Stats() RedisStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	out := c.stats.RedisStats
+	out.ByError = make(map[string]int64, len(c.stats.ByError))
+	for cmd, n := range c.stats.ByError {
+		out.ByError[cmd] = n
+	}
+	return out
+}
+
+// withPrefix namespaces key with the global prefix and, when the context
+// carries a tenant, the tenant id so that tenants never share keys.
+func (c *RedisClient) withPrefix(ctx context.Context, key string) string {
+	if t, ok := tenant.FromContext(ctx); ok {
+		return c.prefix + t.ID + ":" + key
+	}
 	return c.prefix + key
 }
 
+// withTimeout gives a command without a deadline the configured timeout, so
+// a hung connection cannot block an export or a request forever.
+func (c *RedisClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// done counts a finished command and returns its error.
+func (c *RedisClient) done(cmd string, err error) error {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	c.stats.Commands++
+	if err == nil || errors.Is(err, goredis.Nil) {
+		return err
+	}
+	c.stats.Errors++
+	if c.stats.ByError == nil {
+		c.stats.ByError = map[string]int64{}
+	}
+	c.stats.ByError[cmd]++
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		c.stats.Timeouts++
+	}
+	return err
+}
+
 func (c *RedisClient) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
-	return c.raw.Set(ctx, c.withPrefix(key), value, ttl).Err()
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.done("set", c.raw.Set(ctx, c.withPrefix(ctx, key), value, ttl).Err())
+}
+
+func (c *RedisClient) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ok, err := c.raw.SetNX(ctx, c.withPrefix(ctx, key), value, ttl).Result()
+	return ok, c.done("setnx", err)
 }
 
 func (c *RedisClient) Get(ctx context.Context, key string) (string, error) {
-	return c.raw.Get(ctx, c.withPrefix(key)).Result()
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	value, err := c.raw.Get(ctx, c.withPrefix(ctx, key)).Result()
+	return value, c.done("get", err)
 }
 
 func (c *RedisClient) SAdd(ctx context.Context, key string, members ...any) error {
-	return c.raw.SAdd(ctx, c.withPrefix(key), members...).Err()
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.done("sadd", c.raw.SAdd(ctx, c.withPrefix(ctx, key), members...).Err())
 }
 
 func (c *RedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
-	return c.raw.SMembers(ctx, c.withPrefix(key)).Result()
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	members, err := c.raw.SMembers(ctx, c.withPrefix(ctx, key)).Result()
+	return members, c.done("smembers", err)
+}
+
+func (c *RedisClient) SRem(ctx context.Context, key string, members ...any) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.done("srem", c.raw.SRem(ctx, c.withPrefix(ctx, key), members...).Err())
+}
+
+func (c *RedisClient) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	n, err := c.raw.Exists(ctx, c.withPrefix(ctx, key)).Result()
+	return n > 0, c.done("exists", err)
+}
+
+func (c *RedisClient) Del(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.withPrefix(ctx, key)
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.done("del", c.raw.Del(ctx, prefixed...).Err())
+}
+
+// Expire sets the ttl of key; it reports false when the key does not exist.
+func (c *RedisClient) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ok, err := c.raw.Expire(ctx, c.withPrefix(ctx, key), ttl).Result()
+	return ok, c.done("expire", err)
+}
+
+// TTL returns the time key has left; it is negative for a key without expiry
+// (-1) or a missing key (-2), as Redis reports them.
+func (c *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	ttl, err := c.raw.TTL(ctx, c.withPrefix(ctx, key)).Result()
+	return ttl, c.done("ttl", err)
+}
+
+// Scan returns the keys matching the glob pattern, without the prefix. It
+// walks the keyspace with SCAN in batches, so Redis is not blocked the way
+// KEYS blocks it; the timeout applies to every batch.
+func (c *RedisClient) Scan(ctx context.Context, pattern string) ([]string, error) {
+	prefix := c.withPrefix(ctx, "")
+	var (
+		keys   []string
+		cursor uint64
+	)
+	for {
+		batchCtx, cancel := c.withTimeout(ctx)
+		batch, next, err := c.raw.Scan(batchCtx, cursor, prefix+pattern, 500).Result()
+		cancel()
+		if err := c.done("scan", err); err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, prefix))
+		}
+		if cursor = next; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// SaveStatus sets values, each for ttl, and adds member to the set setKey in
+// one MULTI/EXEC round trip instead of one per command; readers never see a
+// record without its set entry.
+func (c *RedisClient) SaveStatus(ctx context.Context, values map[string]any, ttl time.Duration, setKey string, member any) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	_, err := c.raw.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, c.withPrefix(ctx, key), value, ttl)
+		}
+		pipe.SAdd(ctx, c.withPrefix(ctx, setKey), member)
+		return nil
+	})
+	return c.done("multi", err)
 }
@@ -0,0 +1,44 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func TestRedisClient_Stats(t *testing.T) {
+	c := &RedisClient{timeout: time.Second}
+
+	_ = c.done("get", nil)
+	_ = c.done("get", goredis.Nil)
+	_ = c.done("set", errors.New("READONLY You can't write against a read only replica"))
+	_ = c.done("set", context.DeadlineExceeded)
+
+	stats := c.Stats()
+	if stats.Commands != 4 || stats.Errors != 2 || stats.Timeouts != 1 || stats.ByError["set"] != 2 || stats.ByError["get"] != 0 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
+
+func TestRedisClient_WithTimeout(t *testing.T) {
+	c := &RedisClient{timeout: time.Second}
+
+	// команда без дедлайна получает таймаут клиента
+	ctx, cancel := c.withTimeout(context.Background())
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Second {
+		t.Fatalf("deadline %v, %v", deadline, ok)
+	}
+
+	// свой дедлайн вызывающего не продлевается
+	own, ownCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer ownCancel()
+	ctx, cancel = c.withTimeout(own)
+	defer cancel()
+	if ctx != own {
+		t.Fatal("the caller deadline was replaced")
+	}
+}
@@ -0,0 +1,61 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+
+	// PresignTTL is the lifetime of URLs returned by PresignGet.
+	PresignTTL time.Duration
+}
+
+// S3Client is a thin wrapper around an S3-compatible bucket.
+type S3Client struct {
+	raw        *minio.Client
+	bucket     string
+	presignTTL time.Duration
+}
+
+func NewS3Client(cfg S3Config) (*S3Client, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+
+	raw, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init s3 client: %w", err)
+	}
+
+	ttl := cfg.PresignTTL
+	if ttl <= 0 {
+		ttl = 48 * time.Hour
+	}
+
+	return &S3Client{raw: raw, bucket: cfg.Bucket, presignTTL: ttl}, nil
+}
+
+// PresignGet returns a time-limited download URL for the given object key.
+func (c *S3Client) PresignGet(ctx context.Context, key string) (string, error) {
+	u, err := c.raw.PresignedGetObject(ctx, c.bucket, key, c.presignTTL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %q: %w", key, err)
+	}
+	return u.String(), nil
+}
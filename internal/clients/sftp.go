@@ -0,0 +1,208 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPProfile is a counterparty SFTP server files are delivered to. Credentials
+// stay on the server side; requests refer to a profile by name only.
+type SFTPProfile struct {
+	Name     string
+	Host     string
+	Port     int
+	User     string
+	Password string
+	// KeyFile is a private key used instead of (or in addition to) the password.
+	KeyFile string
+	// KnownHostsFile pins the server host key; connections to unknown keys are refused.
+	KnownHostsFile string
+	// Dir is the remote directory files are written to; created when missing.
+	Dir     string
+	Timeout time.Duration
+}
+
+type sftpTarget struct {
+	addr string
+	dir  string
+	ssh  *ssh.ClientConfig
+}
+
+// SFTPClient uploads export files to the configured SFTP profiles.
+type SFTPClient struct {
+	targets map[string]sftpTarget
+}
+
+// NewSFTPClient checks every profile and loads its keys, so a broken profile
+// fails at startup instead of on the first delivery.
+func NewSFTPClient(profiles []SFTPProfile) (*SFTPClient, error) {
+	c := &SFTPClient{targets: map[string]sftpTarget{}}
+	for _, p := range profiles {
+		target, err := newSFTPTarget(p)
+		if err != nil {
+			return nil, fmt.Errorf("sftp profile %q: %w", p.Name, err)
+		}
+		c.targets[p.Name] = target
+	}
+	return c, nil
+}
+
+func newSFTPTarget(p SFTPProfile) (sftpTarget, error) {
+	if p.Host == "" || p.User == "" {
+		return sftpTarget{}, errors.New("host and user are required")
+	}
+	if p.KnownHostsFile == "" {
+		return sftpTarget{}, errors.New("known hosts file is required")
+	}
+	hostKeys, err := knownhosts.New(p.KnownHostsFile)
+	if err != nil {
+		return sftpTarget{}, fmt.Errorf("read known hosts: %w", err)
+	}
+
+	var methods []ssh.AuthMethod
+	if p.KeyFile != "" {
+		key, err := os.ReadFile(p.KeyFile)
+		if err != nil {
+			return sftpTarget{}, fmt.Errorf("read key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return sftpTarget{}, fmt.Errorf("parse key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if p.Password != "" {
+		methods = append(methods, ssh.Password(p.Password))
+	}
+	if len(methods) == 0 {
+		return sftpTarget{}, errors.New("password or key file is required")
+	}
+
+	port := p.Port
+	if port <= 0 {
+		port = 22
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	dir := p.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	return sftpTarget{
+		addr: net.JoinHostPort(p.Host, strconv.Itoa(port)),
+		dir:  dir,
+		ssh: &ssh.ClientConfig{
+			User:            p.User,
+			Auth:            methods,
+			HostKeyCallback: hostKeys,
+			Timeout:         timeout,
+		},
+	}, nil
+}
+
+// HasProfile reports whether deliveries to profile are configured.
+func (c *SFTPClient) HasProfile(profile string) bool {
+	_, ok := c.targets[profile]
+	return ok
+}
+
+// Profiles returns the configured profile names, sorted.
+func (c *SFTPClient) Profiles() []string {
+	out := make([]string, 0, len(c.targets))
+	for name := range c.targets {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Deliver uploads data as fileName into the profile directory and returns the
+// remote path. The file is written under a temporary name and renamed at the
+// end, so the counterparty never picks up a partial file.
+func (c *SFTPClient) Deliver(ctx context.Context, profile, fileName string, data []byte) (string, error) {
+	target, ok := c.targets[profile]
+	if !ok {
+		return "", fmt.Errorf("unknown sftp profile %q", profile)
+	}
+
+	dialer := net.Dialer{Timeout: target.ssh.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target.addr)
+	if err != nil {
+		return "", fmt.Errorf("connect %s: %w", target.addr, err)
+	}
+	// closing the connection aborts a transfer in progress on cancellation
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, target.addr, target.ssh)
+	if err != nil {
+		_ = conn.Close()
+		return "", fmt.Errorf("ssh handshake with %s: %w", target.addr, err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	defer sshClient.Close()
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return "", fmt.Errorf("start sftp session: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.MkdirAll(target.dir); err != nil {
+		return "", fmt.Errorf("create remote dir %q: %w", target.dir, err)
+	}
+
+	remote := path.Join(target.dir, path.Base(fileName))
+	tmp := remote + ".part"
+	if err := writeRemote(client, tmp, data); err != nil {
+		_ = client.Remove(tmp)
+		return "", ctxErr(ctx, err)
+	}
+	if err := client.PosixRename(tmp, remote); err != nil {
+		// servers without the posix-rename extension refuse to overwrite
+		_ = client.Remove(remote)
+		if err := client.Rename(tmp, remote); err != nil {
+			_ = client.Remove(tmp)
+			return "", ctxErr(ctx, fmt.Errorf("rename %q: %w", tmp, err))
+		}
+	}
+	return remote, nil
+}
+
+func writeRemote(client *sftp.Client, name string, data []byte) error {
+	f, err := client.Create(name)
+	if err != nil {
+		return fmt.Errorf("create %q: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write %q: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %q: %w", name, err)
+	}
+	return nil
+}
+
+// ctxErr prefers the cancellation cause over the "use of closed connection"
+// error the aborted transfer reports.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
@@ -1,20 +1,78 @@
 package clients
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"debtster-export/internal/tenant"
 )
 
+// metaSuffix marks the sidecar file holding the metadata of a saved file.
+const metaSuffix = ".meta.json"
+
+// tempDirName is the directory under the storage root that unfinished writes
+// go to; it is never served and on the same filesystem, so renames are atomic.
+const tempDirName = ".tmp"
+
+// tempSuffix ends the names of unfinished writes.
+const tempSuffix = ".tmp"
+
+// legacyPrefix matches the random prefix of files saved before the sidecar existed.
+var legacyPrefix = regexp.MustCompile(`^[0-9a-f]{16}_`)
+
+// fileMeta is stored next to every saved file as "<file>.meta.json".
+type fileMeta struct {
+	// Original — file name as passed to Save, used for Content-Disposition
+	Original string `json:"original"`
+
+	Downloads        int        `json:"downloads,omitempty"`
+	LastDownloadedAt *time.Time `json:"last_downloaded_at,omitempty"`
+	LastDownloadedBy *int64     `json:"last_downloaded_by,omitempty"`
+
+	// OwnerID — user the file is accounted to; SingleUse files are served
+	// to the owner only and deleted after the first download
+	OwnerID   int64 `json:"owner_id,omitempty"`
+	SingleUse bool  `json:"single_use,omitempty"`
+
+	// Encoding — Content-Encoding the file is stored in
+	Encoding string `json:"encoding,omitempty"`
+
+	// Signed files are served through links issued by SignURL only
+	Signed bool `json:"signed,omitempty"`
+}
+
 type StorageClient struct {
 	BaseDir      string // absolute or relative directory to store files
 	PublicPrefix string // URL prefix where files are served, e.g. "/files"
 	BaseURL      string // optional absolute base URL (scheme+host[:port]) used to build file URLs
+	BasePath     string // path the app is served under behind a reverse proxy, e.g. "/export"; "" — the root
+
+	metaMu sync.Mutex // serializes read-modify-write of metadata sidecars
+
+	archive   Archive    // see SetArchive
+	encoding  string     // see EnableCompression
+	dedup     bool       // see EnableDedup
+	blobMu    sync.Mutex // serializes linking to blobs with their removal
+	dedupHits atomic.Int64
+	signKey   []byte        // see EnableSignedURLs
+	urlTTL    time.Duration // default lifetime of signed links
 }
 
 // NewLocalStorage creates a storage client; baseDir will be created if missing.
@@ -26,7 +84,7 @@ func NewLocalStorage(baseDir, publicPrefix, baseURL string) (*StorageClient, err
 		publicPrefix = "/files"
 	}
 
-	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Join(baseDir, tempDirName), 0o755); err != nil {
 		return nil, fmt.Errorf("failed to ensure storage dir %q: %w", baseDir, err)
 	}
 
@@ -34,7 +92,18 @@ func NewLocalStorage(baseDir, publicPrefix, baseURL string) (*StorageClient, err
 }
 
 // Save writes data to baseDir with a unique filename (preserving provided fileName suffix) and returns the filename.
+// When ctx carries a tenant the file goes to the tenant's subdirectory and the returned name is "<tenant>/<file>".
 func (s *StorageClient) Save(ctx context.Context, fileName string, data []byte) (string, error) {
+	return s.SaveStream(ctx, fileName, bytes.NewReader(data))
+}
+
+// SaveStream is Save for content read from r, which is copied to disk as it
+// is read, so large files never have to be held in memory.
+func (s *StorageClient) SaveStream(ctx context.Context, fileName string, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	// sanitize provided filename to avoid path traversal
 	fileName = filepath.Base(fileName)
 
@@ -46,65 +115,413 @@ func (s *StorageClient) Save(ctx context.Context, fileName string, data []byte)
 	unique := hex.EncodeToString(randBytes)
 	final := fmt.Sprintf("%s_%s", unique, fileName)
 
-	path := filepath.Join(s.BaseDir, final)
-	// write file atomically
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	dir := s.BaseDir
+	if t, ok := tenant.FromContext(ctx); ok {
+		dir = filepath.Join(s.BaseDir, t.ID)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", fmt.Errorf("failed to ensure tenant dir %q: %w", dir, err)
+		}
+		final = t.ID + "/" + final
 	}
-	if err := os.Rename(tmp, path); err != nil {
-		_ = os.Remove(tmp)
-		return "", fmt.Errorf("failed to finalize file: %w", err)
+	path := filepath.Join(dir, filepath.Base(final))
+
+	encoding := s.encodingFor(fileName)
+
+	// metadata goes first so a published file always has its original name
+	meta, err := json.Marshal(fileMeta{Original: fileName, Encoding: encoding})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode file metadata: %w", err)
+	}
+	if err := writeAtomic(context.Background(), s.tempDir(), path+metaSuffix, meta); err != nil {
+		return "", fmt.Errorf("failed to write file metadata: %w", diskFull(err))
+	}
+
+	// don't publish the file if the export was cancelled while writing
+	if err := s.writeFile(ctx, path, encodeTo(encoding, ctxReader{ctx, r})); err != nil {
+		_ = os.Remove(path + metaSuffix)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("failed to write file: %w", diskFull(err))
 	}
 
 	return final, nil
 }
 
-// GetURL returns public URL for a saved file. If BaseURL is configured, it builds an absolute URL
-// (BaseURL + PublicPrefix + / + filename). Otherwise it returns a relative path (PublicPrefix/filename).
+// writeFile publishes the content write produces at path.
+func (s *StorageClient) writeFile(ctx context.Context, path string, write func(io.Writer) error) error {
+	if s.dedup {
+		return s.writeDeduped(ctx, path, write)
+	}
+	return writeAtomicFrom(ctx, s.tempDir(), path, write)
+}
+
+// ctxReader stops a long copy once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// diskFull marks out-of-space write errors with ErrDiskFull.
+func diskFull(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w (%v)", ErrDiskFull, err)
+	}
+	return err
+}
+
+func (s *StorageClient) tempDir() string {
+	return filepath.Join(s.BaseDir, tempDirName)
+}
+
+// writeAtomic writes data to path through a temp file in tmpDir. The data is
+// fsynced before the rename and the directory after it, so after a crash path
+// holds either its old or its complete new content, never a partial one. The
+// file is not published when ctx is done by the time it is written.
+func writeAtomic(ctx context.Context, tmpDir, path string, data []byte) error {
+	return writeAtomicFrom(ctx, tmpDir, path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeAtomicFrom is writeAtomic for content produced by write.
+func writeAtomicFrom(ctx context.Context, tmpDir, path string, write func(io.Writer) error) error {
+	tmp, err := writeTemp(tmpDir, filepath.Base(path), write)
+	if err != nil {
+		return err
+	}
+	return publishTemp(ctx, tmp, path)
+}
+
+// writeTemp writes a temp file for target in tmpDir and fsyncs it.
+func writeTemp(tmpDir, target string, write func(io.Writer) error) (string, error) {
+	pattern := target + ".*" + tempSuffix
+	f, err := os.CreateTemp(tmpDir, pattern)
+	if os.IsNotExist(err) {
+		// the directory was removed under us
+		if err = os.MkdirAll(tmpDir, 0o755); err == nil {
+			f, err = os.CreateTemp(tmpDir, pattern)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// publishTemp renames a written temp file to path unless ctx is done.
+func publishTemp(ctx context.Context, tmp, path string) error {
+	err := ctx.Err()
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename into dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// IsTempFile reports whether name is an unfinished write, which must not be
+// served: a file in the temp directory or a ".tmp" left next to its target by
+// older versions.
+func IsTempFile(name string) bool {
+	name = filepath.ToSlash(name)
+	return strings.HasSuffix(name, tempSuffix) || slices.Contains(strings.Split(name, "/"), tempDirName)
+}
+
+// IsMetaFile reports whether name is a metadata sidecar, which must not be served.
+func IsMetaFile(name string) bool {
+	return strings.HasSuffix(name, metaSuffix)
+}
+
+// Path returns the local path of a stored file (as returned by Save).
+func (s *StorageClient) Path(file string) string {
+	return filepath.Join(s.BaseDir, filepath.FromSlash(file))
+}
+
+// ErrOutsideStorage is returned by Resolve for names that lead out of the storage root.
+var ErrOutsideStorage = errors.New("path is outside of the storage")
+
+// Resolve returns the local path of a stored file with symlinks resolved and
+// makes sure it stays inside the storage root; a missing file yields an
+// fs.ErrNotExist error.
+func (s *StorageClient) Resolve(file string) (string, error) {
+	root, err := filepath.EvalSymlinks(s.BaseDir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(s.Path(file))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", ErrOutsideStorage
+	}
+	return path, nil
+}
+
+func readMeta(path string) (fileMeta, bool) {
+	raw, err := os.ReadFile(path + metaSuffix)
+	if err != nil {
+		return fileMeta{}, false
+	}
+	var meta fileMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return fileMeta{}, false
+	}
+	return meta, true
+}
+
+// RecordDownload counts a successful download of file; userID is nil for
+// anonymous downloads. Files without metadata are not tracked.
+func (s *StorageClient) RecordDownload(file string, userID *int64, at time.Time) error {
+	_, err := s.updateMeta(file, func(m *fileMeta) {
+		m.Downloads++
+		m.LastDownloadedAt = &at
+		m.LastDownloadedBy = userID
+	})
+	return err
+}
+
+// updateMeta applies fn to the metadata of file and writes it back.
+func (s *StorageClient) updateMeta(file string, fn func(*fileMeta)) (bool, error) {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+
+	path := s.Path(file)
+	meta, ok := readMeta(path)
+	if !ok {
+		return false, nil
+	}
+	fn(&meta)
+
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return true, err
+	}
+	return true, writeAtomic(context.Background(), s.tempDir(), path+metaSuffix, raw)
+}
+
+// SetOwner records the user a saved file belongs to, used for storage quotas.
+// A singleUse file is served to its owner only and deleted by the caller after
+// the first download.
+func (s *StorageClient) SetOwner(file string, ownerID int64, singleUse bool) error {
+	ok, err := s.updateMeta(file, func(m *fileMeta) {
+		m.OwnerID = ownerID
+		m.SingleUse = singleUse
+	})
+	if err == nil && !ok {
+		err = fmt.Errorf("no metadata for %q", file)
+	}
+	return err
+}
+
+// SingleUseOwner returns the owner of a single-use file.
+func (s *StorageClient) SingleUseOwner(file string) (int64, bool) {
+	meta, ok := readMeta(s.Path(file))
+	if !ok || !meta.SingleUse {
+		return 0, false
+	}
+	return meta.OwnerID, true
+}
+
+// Delete removes a stored file together with its metadata.
+func (s *StorageClient) Delete(file string) error {
+	path := s.Path(file)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path + metaSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// OriginalName returns the name passed to Save for a stored file (file is the
+// value Save returned). Files saved before metadata was stored fall back to
+// stripping the exact random prefix format.
+func (s *StorageClient) OriginalName(file string) string {
+	if meta, ok := readMeta(s.Path(file)); ok && meta.Original != "" {
+		return meta.Original
+	}
+	return legacyPrefix.ReplaceAllString(filepath.Base(filepath.FromSlash(file)), "")
+}
+
+// GetURL returns public URL for a saved file: BasePath + PublicPrefix + / + filename. If BaseURL is
+// configured, the URL is absolute (BaseURL + BasePath + PublicPrefix + / + filename), otherwise it is a
+// relative path that AbsoluteURL can complete for a request.
 func (s *StorageClient) GetURL(fileName string) string {
 	// ensure prefix has leading slash and no trailing slash
 	prefix := s.PublicPrefix
 	if prefix == "" {
 		prefix = "/files"
 	}
+	if prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	base := strings.TrimSuffix(s.BaseURL, "/")
+	return fmt.Sprintf("%s%s%s/%s", base, strings.TrimSuffix(s.BasePath, "/"), prefix, fileName)
+}
+
+// AbsoluteURL completes a relative link returned by GetURL or SignURL with
+// the scheme and host the client of r addressed: behind a reverse proxy the
+// ones in X-Forwarded-Proto and X-Forwarded-Host, otherwise those of r itself.
+// Absolute links (BaseURL is set) are returned as they are.
+func AbsoluteURL(r *http.Request, link string) string {
+	if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") {
+		return link
+	}
+	return RequestOrigin(r) + link
+}
+
+// RequestOrigin returns "scheme://host" of the URL the client of r used. Of
+// forwarded headers listing several proxies the first, nearest to the
+// client, value counts; unusable values are ignored.
+func RequestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.ToLower(firstForwarded(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	host := r.Host
+	if fwd := firstForwarded(r.Header.Get("X-Forwarded-Host")); fwd != "" && !strings.ContainsAny(fwd, "/\\@?# ") {
+		host = fwd
+	}
+	return scheme + "://" + host
+}
+
+// firstForwarded returns the first value of a comma-separated forwarded header.
+func firstForwarded(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// CleanupOlderThan deletes files older than d in base dir and returns how many
+// were removed. Files that were saved with metadata and never downloaded are
+// kept until undownloaded instead, when it is longer. With an archive set,
+// expired files are moved there instead of just deleted. Sidecars are removed
+// together with their files, blobs once no file links to them; unfinished
+// writes are left to RemoveTempFiles.
+func (s *StorageClient) CleanupOlderThan(d, undownloaded time.Duration) (int, error) {
+	now := time.Now()
+	removed := 0
+	archiveFailed := 0
+	var archiveErr error
+	err := filepath.WalkDir(s.BaseDir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() && de.Name() == blobDirName {
+			return fs.SkipDir
+		}
+		if de.IsDir() || IsTempFile(path) {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
 
-	// remove trailing slash from BaseURL if present
-	if s.BaseURL != "" {
-		base := s.BaseURL
-		if base[len(base)-1] == '/' {
-			base = base[:len(base)-1]
+		if IsMetaFile(path) {
+			// orphaned sidecar whose file is already gone
+			if _, err := os.Stat(strings.TrimSuffix(path, metaSuffix)); os.IsNotExist(err) && now.Sub(info.ModTime()) > max(d, undownloaded) {
+				_ = os.Remove(path)
+			}
+			return nil
+		}
+
+		retention := d
+		meta, ok := readMeta(path)
+		if ok && meta.Downloads == 0 && undownloaded > d {
+			retention = undownloaded
 		}
-		// ensure prefix begins with /
-		if prefix[0] != '/' {
-			prefix = "/" + prefix
+		if now.Sub(info.ModTime()) > retention {
+			if s.archive != nil {
+				// a file that could not be archived is kept for the next run
+				if err := s.archiveFile(path, meta); err != nil {
+					archiveFailed++
+					archiveErr = err
+					return nil
+				}
+			}
+			if os.Remove(path) == nil { // best-effort
+				removed++
+			}
+			_ = os.Remove(path + metaSuffix)
 		}
-		return fmt.Sprintf("%s%s/%s", base, prefix, fileName)
+		return nil
+	})
+	if err == nil && s.dedup {
+		// blobs of the files removed above
+		err = s.pruneBlobs()
 	}
-
-	if prefix[0] != '/' {
-		prefix = "/" + prefix
+	if err == nil && archiveFailed > 0 {
+		err = fmt.Errorf("failed to archive %d expired files, kept: %w", archiveFailed, archiveErr)
 	}
-	return fmt.Sprintf("%s/%s", prefix, fileName)
+	return removed, err
 }
 
-// CleanupOlderThan deletes files older than given duration in base dir.
-func (s *StorageClient) CleanupOlderThan(d time.Duration) error {
+// RemoveTempFiles deletes unfinished writes older than d, left behind by a
+// crash, and returns how many were removed. Younger ones may belong to writes
+// still in progress on an instance sharing the directory.
+func (s *StorageClient) RemoveTempFiles(d time.Duration) (int, error) {
 	now := time.Now()
-	return filepath.WalkDir(s.BaseDir, func(path string, de fs.DirEntry, err error) error {
+	removed := 0
+	err := filepath.WalkDir(s.BaseDir, func(path string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if de.IsDir() {
+		if de.IsDir() || !IsTempFile(path) {
 			return nil
 		}
 		info, err := de.Info()
 		if err != nil {
 			return nil
 		}
-		if now.Sub(info.ModTime()) > d {
-			_ = os.Remove(path) // best-effort
+		if now.Sub(info.ModTime()) > d && os.Remove(path) == nil {
+			removed++
 		}
 		return nil
 	})
+	return removed, err
 }
@@ -0,0 +1,95 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveTimeout bounds the upload of one expired file.
+const archiveTimeout = 10 * time.Minute
+
+// Archive keeps expired files; implemented by *ColdArchive.
+type Archive interface {
+	Put(ctx context.Context, file, localPath string, meta ArchiveMeta) error
+	Get(ctx context.Context, file string) (io.ReadCloser, ArchiveMeta, error)
+}
+
+// SetArchive makes CleanupOlderThan move expired files to archive and
+// enables Restore.
+func (s *StorageClient) SetArchive(archive Archive) {
+	s.archive = archive
+}
+
+// storedName reports whether file is a name Save could have returned.
+func storedName(file string) bool {
+	if !filepath.IsLocal(filepath.FromSlash(file)) || IsMetaFile(file) || IsTempFile(file) {
+		return false
+	}
+	for _, seg := range strings.Split(file, "/") {
+		if strings.HasPrefix(seg, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// archiveFile uploads the stored file at path with its metadata.
+func (s *StorageClient) archiveFile(path string, meta fileMeta) error {
+	rel, err := filepath.Rel(s.BaseDir, path)
+	if err != nil {
+		return err
+	}
+	if meta.Original == "" {
+		meta.Original = legacyPrefix.ReplaceAllString(filepath.Base(path), "")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+	defer cancel()
+	return s.archive.Put(ctx, filepath.ToSlash(rel), path, ArchiveMeta{
+		Original: meta.Original,
+		OwnerID:  meta.OwnerID,
+		Encoding: meta.Encoding,
+	})
+}
+
+// Restore brings an archived file back under its old name, so its links work
+// again until the retention passes once more. A file still in storage is left
+// as is. Returns ErrNotArchived for unknown files and ErrRestorePending while
+// cold storage is bringing the file back.
+func (s *StorageClient) Restore(ctx context.Context, file string) error {
+	if s.archive == nil || !storedName(file) {
+		return ErrNotArchived
+	}
+	path := s.Path(file)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	r, meta, err := s.archive.Get(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to ensure dir for %q: %w", file, err)
+	}
+	raw, err := json.Marshal(fileMeta{Original: meta.Original, OwnerID: meta.OwnerID, Encoding: meta.Encoding})
+	if err != nil {
+		return fmt.Errorf("failed to encode file metadata: %w", err)
+	}
+	if err := writeAtomic(ctx, s.tempDir(), path+metaSuffix, raw); err != nil {
+		return fmt.Errorf("failed to write file metadata: %w", err)
+	}
+	if err := s.writeFile(ctx, path, encodeTo("", ctxReader{ctx, r})); err != nil {
+		_ = os.Remove(path + metaSuffix)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
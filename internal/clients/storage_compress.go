@@ -0,0 +1,98 @@
+package clients
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings stored files can be compressed with; the names are the HTTP
+// Content-Encoding tokens.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// compressibleExtensions are the text outputs worth compressing; XLSX and ZIP
+// are compressed already.
+var compressibleExtensions = map[string]bool{
+	".csv":    true,
+	".ndjson": true,
+}
+
+// EnableCompression makes Save compress CSV and NDJSON files with encoding.
+// The encoding is recorded in the metadata, so files saved before keep being
+// served as they are.
+func (s *StorageClient) EnableCompression(encoding string) error {
+	switch encoding {
+	case EncodingGzip, EncodingZstd:
+		s.encoding = encoding
+		return nil
+	default:
+		return fmt.Errorf("unsupported storage encoding %q", encoding)
+	}
+}
+
+// encodingFor returns the encoding fileName is stored with, "" — as is.
+func (s *StorageClient) encodingFor(fileName string) string {
+	if !compressibleExtensions[strings.ToLower(filepath.Ext(fileName))] {
+		return ""
+	}
+	return s.encoding
+}
+
+// encodeTo returns a func writing the content of r encoded with encoding.
+func encodeTo(encoding string, r io.Reader) func(io.Writer) error {
+	return func(w io.Writer) error {
+		var enc io.WriteCloser
+		switch encoding {
+		case "":
+			_, err := io.Copy(w, r)
+			return err
+		case EncodingGzip:
+			enc = gzip.NewWriter(w)
+		case EncodingZstd:
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				return err
+			}
+			enc = zw
+		default:
+			return fmt.Errorf("unsupported storage encoding %q", encoding)
+		}
+		if _, err := io.Copy(enc, r); err != nil {
+			_ = enc.Close()
+			return err
+		}
+		return enc.Close()
+	}
+}
+
+// Encoding returns the Content-Encoding a stored file is kept in, "" when it
+// is stored as is.
+func (s *StorageClient) Encoding(file string) string {
+	meta, _ := readMeta(s.Path(file))
+	return meta.Encoding
+}
+
+// NewDecoder returns a reader of the decoded content of r stored with encoding.
+func NewDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "":
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage encoding %q", encoding)
+	}
+}
@@ -0,0 +1,158 @@
+package clients
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// blobDirName holds the content-addressed blobs stored files are hard links
+// to when deduplication is on; hidden, so never served.
+const blobDirName = ".blobs"
+
+// blobGrace keeps unreferenced blobs this long, so a blob another instance
+// has just written is not removed before it links its file to it.
+const blobGrace = time.Minute
+
+// DedupStats describes the content-addressed part of the storage.
+type DedupStats struct {
+	Enabled bool `json:"enabled"`
+	// Blobs — distinct contents on disk; References — stored files linked to them
+	Blobs      int   `json:"blobs"`
+	References int   `json:"references"`
+	Bytes      int64 `json:"bytes"`
+	// SavedBytes — disk space the references would take without sharing
+	SavedBytes int64 `json:"saved_bytes"`
+	// Hits — saves since start that reused an existing blob
+	Hits int64 `json:"hits"`
+}
+
+// EnableDedup makes Save store every content once under its SHA-256 and link
+// the returned files to it, so identical exports share their disk space. A
+// blob lives as long as a file links to it; the link count is the reference
+// count, so deleting and cleaning up files works as before.
+func (s *StorageClient) EnableDedup() error {
+	if !linkCountSupported {
+		return errors.New("deduplication needs hard link counts, not available on this platform")
+	}
+	if err := os.MkdirAll(s.blobDir(), 0o755); err != nil {
+		return err
+	}
+	s.dedup = true
+	return nil
+}
+
+func (s *StorageClient) blobDir() string {
+	return filepath.Join(s.BaseDir, blobDirName)
+}
+
+// writeDeduped publishes the content write produces at path as a link to its
+// blob; the content becomes the blob when it is new.
+func (s *StorageClient) writeDeduped(ctx context.Context, path string, write func(io.Writer) error) error {
+	h := sha256.New()
+	tmp, err := writeTemp(s.tempDir(), filepath.Base(path), func(w io.Writer) error {
+		return write(io.MultiWriter(w, h))
+	})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // gone after a rename to the blob
+	name := hex.EncodeToString(h.Sum(nil))
+	blob := filepath.Join(s.blobDir(), name[:2], name)
+
+	// serializes links with pruneBlobs of this instance
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err = os.Link(blob, path)
+	if err == nil {
+		s.dedupHits.Add(1)
+		// linked files share the modification time cleanup goes by; the
+		// new reference has to live its full retention
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		return syncDir(filepath.Dir(path))
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return err
+	}
+	if err := publishTemp(ctx, tmp, blob); err != nil {
+		return err
+	}
+	if err := os.Link(blob, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// pruneBlobs removes blobs no stored file links to any more.
+func (s *StorageClient) pruneBlobs() error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	now := time.Now()
+	err := filepath.WalkDir(s.blobDir(), func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		if n, ok := linkCount(info); ok && n <= 1 && now.Sub(info.ModTime()) > blobGrace {
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DedupStats walks the blobs and reports how much sharing saves.
+func (s *StorageClient) DedupStats() (DedupStats, error) {
+	stats := DedupStats{Enabled: s.dedup, Hits: s.dedupHits.Load()}
+	if !s.dedup {
+		return stats, nil
+	}
+	err := filepath.WalkDir(s.blobDir(), func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		n, _ := linkCount(info)
+		if n <= 1 {
+			return nil
+		}
+		refs := int(n - 1)
+		stats.Blobs++
+		stats.References += refs
+		stats.Bytes += info.Size()
+		stats.SavedBytes += info.Size() * int64(refs-1)
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,244 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backends a FailoverStorage file can be held by, as recorded in export statuses.
+const (
+	BackendPrimary  = "primary"
+	BackendFallback = "fallback"
+)
+
+// StorageHealth is the state of the primary backend of a FailoverStorage.
+type StorageHealth struct {
+	PrimaryUp bool       `json:"primary_up"`
+	Failures  int        `json:"failures"`
+	DownUntil *time.Time `json:"down_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	// FallbackSaves — files saved to the fallback since start
+	FallbackSaves int64 `json:"fallback_saves"`
+}
+
+// FailoverStorage saves to the primary storage and, once the primary failed
+// threshold times in a row, to the fallback: the failing save completes
+// there, and later ones go there directly until cooldown has passed or a
+// health probe succeeds. Files are served from whichever backend holds them
+// under the same name and URL. Without a fallback it is just the primary.
+type FailoverStorage struct {
+	*StorageClient // primary
+
+	fallback  *StorageClient
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+	lastErr   string
+
+	fallbackSaves atomic.Int64
+}
+
+func NewFailoverStorage(primary, fallback *StorageClient, threshold int, cooldown time.Duration) *FailoverStorage {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &FailoverStorage{StorageClient: primary, fallback: fallback, threshold: threshold, cooldown: cooldown}
+}
+
+// primaryUp reports whether saves should try the primary.
+func (s *FailoverStorage) primaryUp() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().After(s.downUntil)
+}
+
+// recordResult counts a primary failure (err != nil) or success and reports
+// whether the primary is now considered down.
+func (s *FailoverStorage) recordResult(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failures = 0
+		s.downUntil = time.Time{}
+		return false
+	}
+	s.failures++
+	s.lastErr = err.Error()
+	if s.failures >= s.threshold {
+		s.downUntil = time.Now().Add(s.cooldown)
+		return true
+	}
+	return false
+}
+
+// Save saves data to the primary, or to the fallback when the primary is
+// down or has just failed for the threshold time.
+func (s *FailoverStorage) Save(ctx context.Context, fileName string, data []byte) (string, error) {
+	if s.fallback == nil {
+		return s.StorageClient.Save(ctx, fileName, data)
+	}
+	if s.primaryUp() {
+		saved, err := s.StorageClient.Save(ctx, fileName, data)
+		if err == nil {
+			s.recordResult(nil)
+			return saved, nil
+		}
+		if ctx.Err() != nil || !s.recordResult(err) {
+			return "", err
+		}
+	}
+	return s.saveFallback(ctx, fileName, data)
+}
+
+func (s *FailoverStorage) saveFallback(ctx context.Context, fileName string, data []byte) (string, error) {
+	saved, err := s.fallback.Save(ctx, fileName, data)
+	if err == nil {
+		s.fallbackSaves.Add(1)
+	}
+	return saved, err
+}
+
+// SaveStream is Save for content read from r. A consumed stream cannot be
+// replayed, so a failing primary save is not retried on the fallback; it
+// still counts towards the failover.
+func (s *FailoverStorage) SaveStream(ctx context.Context, fileName string, r io.Reader) (string, error) {
+	if s.fallback == nil {
+		return s.StorageClient.SaveStream(ctx, fileName, r)
+	}
+	if !s.primaryUp() {
+		saved, err := s.fallback.SaveStream(ctx, fileName, r)
+		if err == nil {
+			s.fallbackSaves.Add(1)
+		}
+		return saved, err
+	}
+	saved, err := s.StorageClient.SaveStream(ctx, fileName, r)
+	if ctx.Err() == nil {
+		s.recordResult(err)
+	}
+	return saved, err
+}
+
+// Probe writes and removes a small file on the primary; a janitor task that
+// brings the primary back as soon as it works again. It returns 1 when the
+// primary is down.
+func (s *FailoverStorage) Probe(ctx context.Context) (int, error) {
+	probe := filepath.Join(s.StorageClient.tempDir(), "probe")
+	err := writeAtomic(ctx, s.StorageClient.tempDir(), probe, []byte("ok"))
+	if err == nil {
+		err = os.Remove(probe)
+	}
+	s.recordResult(err)
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// Health returns the state of the primary.
+func (s *FailoverStorage) Health() StorageHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := StorageHealth{
+		PrimaryUp:     time.Now().After(s.downUntil),
+		Failures:      s.failures,
+		LastError:     s.lastErr,
+		FallbackSaves: s.fallbackSaves.Load(),
+	}
+	if !h.PrimaryUp {
+		until := s.downUntil
+		h.DownUntil = &until
+	}
+	return h
+}
+
+// holder returns the backend holding file; the primary when neither does.
+func (s *FailoverStorage) holder(file string) *StorageClient {
+	if s.fallback == nil {
+		return s.StorageClient
+	}
+	if _, err := os.Lstat(s.StorageClient.Path(file)); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Lstat(s.fallback.Path(file)); err == nil {
+			return s.fallback
+		}
+	}
+	return s.StorageClient
+}
+
+// Backend returns BackendPrimary or BackendFallback for a saved file, "" when
+// there is no fallback.
+func (s *FailoverStorage) Backend(file string) string {
+	if s.fallback == nil {
+		return ""
+	}
+	if s.holder(file) == s.fallback {
+		return BackendFallback
+	}
+	return BackendPrimary
+}
+
+func (s *FailoverStorage) Resolve(file string) (string, error) {
+	return s.holder(file).Resolve(file)
+}
+
+func (s *FailoverStorage) SetOwner(file string, ownerID int64, singleUse bool) error {
+	return s.holder(file).SetOwner(file, ownerID, singleUse)
+}
+
+func (s *FailoverStorage) SingleUseOwner(file string) (int64, bool) {
+	return s.holder(file).SingleUseOwner(file)
+}
+
+func (s *FailoverStorage) OriginalName(file string) string {
+	return s.holder(file).OriginalName(file)
+}
+
+func (s *FailoverStorage) Encoding(file string) string {
+	return s.holder(file).Encoding(file)
+}
+
+func (s *FailoverStorage) SignURL(file string, ttl time.Duration) (string, time.Time, error) {
+	return s.holder(file).SignURL(file, ttl)
+}
+
+func (s *FailoverStorage) VerifyURL(file string, query url.Values, now time.Time) error {
+	return s.holder(file).VerifyURL(file, query, now)
+}
+
+func (s *FailoverStorage) RecordDownload(file string, userID *int64, at time.Time) error {
+	return s.holder(file).RecordDownload(file, userID, at)
+}
+
+func (s *FailoverStorage) Delete(file string) error {
+	return s.holder(file).Delete(file)
+}
+
+// CleanupOlderThan cleans up both backends.
+func (s *FailoverStorage) CleanupOlderThan(d, undownloaded time.Duration) (int, error) {
+	n, err := s.StorageClient.CleanupOlderThan(d, undownloaded)
+	if s.fallback == nil {
+		return n, err
+	}
+	m, ferr := s.fallback.CleanupOlderThan(d, undownloaded)
+	return n + m, errors.Join(err, ferr)
+}
+
+// RemoveTempFiles sweeps both backends.
+func (s *FailoverStorage) RemoveTempFiles(d time.Duration) (int, error) {
+	n, err := s.StorageClient.RemoveTempFiles(d)
+	if s.fallback == nil {
+		return n, err
+	}
+	m, ferr := s.fallback.RemoveTempFiles(d)
+	return n + m, errors.Join(err, ferr)
+}
@@ -0,0 +1,90 @@
+package clients
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// breakStorage makes every write of s fail: its temp dir becomes a file.
+func breakStorage(t *testing.T, s *StorageClient) {
+	t.Helper()
+	if err := os.RemoveAll(s.tempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.tempDir(), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func repairStorage(t *testing.T, s *StorageClient) {
+	t.Helper()
+	if err := os.Remove(s.tempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(s.tempDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailoverStorage(t *testing.T) {
+	root := t.TempDir()
+	primary, _ := NewLocalStorage(filepath.Join(root, "primary"), "/files", "")
+	fallback, _ := NewLocalStorage(filepath.Join(root, "fallback"), "/files", "")
+	s := NewFailoverStorage(primary, fallback, 2, time.Hour)
+	ctx := context.Background()
+
+	saved, err := s.Save(ctx, "a.xlsx", []byte("a"))
+	if err != nil || s.Backend(saved) != BackendPrimary {
+		t.Fatalf("healthy save: %v, backend %q", err, s.Backend(saved))
+	}
+
+	breakStorage(t, primary)
+	// первая ошибка возвращается — её повторит политика ретраев
+	if _, err := s.Save(ctx, "b.xlsx", []byte("b")); err == nil {
+		t.Fatal("first failure hidden")
+	}
+	// вторая подряд — выгрузка завершается в резервное хранилище
+	saved, err = s.Save(ctx, "b.xlsx", []byte("b"))
+	if err != nil || s.Backend(saved) != BackendFallback {
+		t.Fatalf("failover save: %v, backend %q", err, s.Backend(saved))
+	}
+	if h := s.Health(); h.PrimaryUp || h.FallbackSaves != 1 || h.LastError == "" {
+		t.Fatalf("health = %+v", h)
+	}
+
+	// файл резервного хранилища обслуживается под тем же именем
+	if err := s.SetOwner(saved, 7, true); err != nil {
+		t.Fatalf("set owner: %v", err)
+	}
+	if owner, ok := s.SingleUseOwner(saved); !ok || owner != 7 {
+		t.Fatalf("owner = %d, %v", owner, ok)
+	}
+	if local, err := s.Resolve(saved); err != nil || filepath.Dir(local) != mustEval(t, fallback.BaseDir) {
+		t.Fatalf("resolve = %q, %v", local, err)
+	}
+
+	// проба возвращает основное хранилище, как только оно снова пишет
+	if n, err := s.Probe(ctx); err == nil || n != 1 {
+		t.Fatalf("probe of broken primary = %d, %v", n, err)
+	}
+	repairStorage(t, primary)
+	if n, err := s.Probe(ctx); err != nil || n != 0 || !s.Health().PrimaryUp {
+		t.Fatalf("probe of repaired primary = %d, %v", n, err)
+	}
+	saved, _ = s.Save(ctx, "c.xlsx", []byte("c"))
+	if s.Backend(saved) != BackendPrimary {
+		t.Fatalf("backend after recovery = %q", s.Backend(saved))
+	}
+}
+
+func mustEval(t *testing.T, dir string) string {
+	t.Helper()
+	abs, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package clients
+
+import (
+	"errors"
+	"io/fs"
+)
+
+const linkCountSupported = false
+
+func linkCount(fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+func diskSpace(string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
@@ -2,13 +2,20 @@ package clients
 
 import (
 	"context"
+	"errors"
 	"io"
+	"mime"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"debtster-export/internal/tenant"
 )
 
 func TestGetURL_AbsoluteAndRelative(t *testing.T) {
@@ -32,6 +39,44 @@ func TestGetURL_AbsoluteAndRelative(t *testing.T) {
 	}
 }
 
+func TestGetURL_BasePathBehindProxy(t *testing.T) {
+	c, _ := NewLocalStorage(t.TempDir(), "/files", "https://example.com/")
+	c.BasePath = "/export"
+	if got := c.GetURL("a.xlsx"); got != "https://example.com/export/files/a.xlsx" {
+		t.Fatalf("absolute url: %s", got)
+	}
+	// абсолютная ссылка не меняется
+	r := httptest.NewRequest(http.MethodGet, "/export/1", nil)
+	if got := AbsoluteURL(r, c.GetURL("a.xlsx")); got != "https://example.com/export/files/a.xlsx" {
+		t.Fatalf("absolute url rewritten: %s", got)
+	}
+
+	c.BaseURL = ""
+	link := c.GetURL("a.xlsx")
+	if link != "/export/files/a.xlsx" {
+		t.Fatalf("relative url: %s", link)
+	}
+
+	// без прокси — хост запроса
+	if got := AbsoluteURL(r, link); got != "http://example.com/export/files/a.xlsx" {
+		t.Fatalf("direct request: %s", got)
+	}
+
+	// за прокси — схема и хост, к которым обратился клиент (первое значение списка)
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+	r.Header.Set("X-Forwarded-Host", "public.example.org, internal:8060")
+	if got := AbsoluteURL(r, link); got != "https://public.example.org/export/files/a.xlsx" {
+		t.Fatalf("proxied request: %s", got)
+	}
+
+	// негодные значения игнорируются
+	r.Header.Set("X-Forwarded-Proto", "javascript")
+	r.Header.Set("X-Forwarded-Host", "evil.com/x?")
+	if got := AbsoluteURL(r, link); got != "http://example.com/export/files/a.xlsx" {
+		t.Fatalf("bad forwarded headers: %s", got)
+	}
+}
+
 func TestSaveAndServeFileHandler(t *testing.T) {
 	tmpDir := t.TempDir()
 	c, err := NewLocalStorage(tmpDir, "/files", "")
@@ -53,10 +98,9 @@ func TestSaveAndServeFileHandler(t *testing.T) {
 			http.NotFound(w, r)
 			return
 		}
-		if idx := strings.IndexByte(file, '_'); idx >= 0 {
-			file = file[idx+1:]
-		}
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+file+"\"")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": c.OriginalName(file),
+		}))
 		http.ServeFile(w, r, path)
 	})
 
@@ -84,3 +128,579 @@ func TestSaveAndServeFileHandler(t *testing.T) {
 		t.Fatalf("content mismatch: %s", string(body))
 	}
 }
+
+func TestSave_TenantSubdir(t *testing.T) {
+	tmpDir := t.TempDir()
+	c, err := NewLocalStorage(tmpDir, "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	ctx := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme", Schema: "acme"})
+	saved, err := c.Save(ctx, "debts.xlsx", []byte("data"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	if !strings.HasPrefix(saved, "acme/") {
+		t.Fatalf("expected tenant-prefixed name, got %s", saved)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "acme", strings.TrimPrefix(saved, "acme/"))); err != nil {
+		t.Fatalf("expected file in tenant dir: %v", err)
+	}
+	if got := c.GetURL(saved); got != "/files/"+saved {
+		t.Fatalf("unexpected url %s", got)
+	}
+}
+
+func TestOriginalName(t *testing.T) {
+	tmpDir := t.TempDir()
+	c, err := NewLocalStorage(tmpDir, "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	ctx := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme", Schema: "acme"})
+	for _, name := range []string{"debts_20250101_120000.xlsx", "Отчёт_май.xlsx"} {
+		saved, err := c.Save(ctx, name, []byte("data"))
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		if got := c.OriginalName(saved); got != name {
+			t.Fatalf("expected %q, got %q", name, got)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, filepath.FromSlash(saved)) + metaSuffix); err != nil {
+			t.Fatalf("expected metadata sidecar: %v", err)
+		}
+	}
+
+	// файлы, сохранённые до появления метаданных
+	legacy := "0123456789abcdef_payments_20250101_120000.xlsx"
+	if err := os.WriteFile(filepath.Join(tmpDir, legacy), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.OriginalName(legacy); got != "payments_20250101_120000.xlsx" {
+		t.Fatalf("unexpected legacy name %q", got)
+	}
+	if got := c.OriginalName("custom_name.xlsx"); got != "custom_name.xlsx" {
+		t.Fatalf("name without random prefix must be kept, got %q", got)
+	}
+	if !IsMetaFile(legacy+metaSuffix) || IsMetaFile(legacy) {
+		t.Fatal("IsMetaFile mismatch")
+	}
+}
+
+func TestRecordDownloadAndCleanup(t *testing.T) {
+	tmpDir := t.TempDir()
+	c, err := NewLocalStorage(tmpDir, "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	downloaded, err := c.Save(context.Background(), "downloaded.xlsx", []byte("data"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	fresh, err := c.Save(context.Background(), "fresh.xlsx", []byte("data"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	userID := int64(7)
+	at := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+	for range 2 {
+		if err := c.RecordDownload(downloaded, &userID, at); err != nil {
+			t.Fatalf("record download: %v", err)
+		}
+	}
+	meta, ok := readMeta(c.Path(downloaded))
+	if !ok || meta.Downloads != 2 || meta.LastDownloadedBy == nil || *meta.LastDownloadedBy != 7 || !meta.LastDownloadedAt.Equal(at) {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+
+	old := time.Now().Add(-3 * time.Hour)
+	for _, f := range []string{downloaded, fresh} {
+		if err := os.Chtimes(c.Path(f), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if removed, err := c.CleanupOlderThan(time.Hour, 24*time.Hour); err != nil || removed != 1 {
+		t.Fatalf("cleanup: removed %d, %v", removed, err)
+	}
+	if _, err := os.Stat(c.Path(downloaded)); !os.IsNotExist(err) {
+		t.Fatalf("downloaded file must be removed, stat err: %v", err)
+	}
+	if _, err := os.Stat(c.Path(downloaded) + metaSuffix); !os.IsNotExist(err) {
+		t.Fatalf("sidecar must be removed with its file, stat err: %v", err)
+	}
+	if _, err := os.Stat(c.Path(fresh)); err != nil {
+		t.Fatalf("never downloaded file must be kept: %v", err)
+	}
+}
+
+func TestSingleUse(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	saved, err := c.Save(context.Background(), "secret.xlsx", []byte("data"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, ok := c.SingleUseOwner(saved); ok {
+		t.Fatal("regular file reported as single-use")
+	}
+
+	if err := c.SetOwner(saved, 7, true); err != nil {
+		t.Fatalf("set owner: %v", err)
+	}
+	if owner, ok := c.SingleUseOwner(saved); !ok || owner != 7 {
+		t.Fatalf("expected owner 7, got %d (%v)", owner, ok)
+	}
+	if got := c.OriginalName(saved); got != "secret.xlsx" {
+		t.Fatalf("original name lost: %q", got)
+	}
+
+	if err := c.Delete(saved); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	for _, p := range []string{c.Path(saved), c.Path(saved) + metaSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s must be removed, stat err: %v", p, err)
+		}
+	}
+
+	if err := c.SetOwner("missing.xlsx", 7, false); err == nil {
+		t.Fatal("expected error for file without metadata")
+	}
+}
+
+func TestUsageAndQuota(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	acme := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme", Schema: "acme"})
+	save := func(ctx context.Context, owner int64, size int) {
+		t.Helper()
+		saved, err := c.Save(ctx, "debts.xlsx", make([]byte, size))
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		if owner != 0 {
+			if err := c.SetOwner(saved, owner, false); err != nil {
+				t.Fatalf("set owner: %v", err)
+			}
+		}
+	}
+	save(context.Background(), 7, 100)
+	save(context.Background(), 7, 50)
+	save(context.Background(), 0, 10)
+	save(acme, 7, 300)
+	save(acme, 8, 200)
+
+	usage, err := c.Usage()
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	want := []StorageUsage{
+		{UserID: 0, Files: 1, Bytes: 10},
+		{UserID: 7, Files: 2, Bytes: 150},
+		{Tenant: "acme", UserID: 7, Files: 1, Bytes: 300},
+		{Tenant: "acme", UserID: 8, Files: 1, Bytes: 200},
+	}
+	if len(usage) != len(want) {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	for i := range want {
+		if usage[i] != want[i] {
+			t.Fatalf("usage[%d] = %+v, want %+v", i, usage[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		name                   string
+		ctx                    context.Context
+		userID                 int64
+		userQuota, tenantQuota int64
+		wantExceeded           bool
+	}{
+		{"disabled", context.Background(), 7, 0, 0, false},
+		{"user under quota", context.Background(), 7, 151, 0, false},
+		{"user at quota", context.Background(), 7, 150, 0, true},
+		{"other tenant is not counted", acme, 8, 201, 0, false},
+		{"tenant quota", acme, 8, 0, 500, true},
+		{"root is not a tenant", context.Background(), 8, 0, 500, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exceeded, err := NewStorageQuota(c, tt.userQuota, tt.tenantQuota).QuotaExceeded(tt.ctx, tt.userID)
+			if err != nil {
+				t.Fatalf("quota: %v", err)
+			}
+			if exceeded != tt.wantExceeded {
+				t.Fatalf("expected exceeded=%v", tt.wantExceeded)
+			}
+		})
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	saved, err := c.Save(context.Background(), "debts.xlsx", []byte("data"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	abandoned := c.Path("0123456789abcdef_users.xlsx.tmp")
+	inProgress := c.Path("fedcba9876543210_users.xlsx.tmp")
+	for _, p := range []string{abandoned, inProgress} {
+		if err := os.WriteFile(p, []byte("partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, p := range []string{abandoned, c.Path(saved)} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if removed, err := c.RemoveTempFiles(time.Hour); err != nil || removed != 1 {
+		t.Fatalf("remove temp files: removed %d, %v", removed, err)
+	}
+	if _, err := os.Stat(abandoned); !os.IsNotExist(err) {
+		t.Fatalf("abandoned temp file must be removed, stat err: %v", err)
+	}
+	for _, p := range []string{inProgress, c.Path(saved)} {
+		if _, err := os.Stat(p); err != nil {
+			t.Fatalf("%s must be kept: %v", p, err)
+		}
+	}
+}
+
+func TestSave_WritesThroughTempDir(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	saved, err := c.Save(context.Background(), "debts.xlsx", []byte("data"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if data, err := os.ReadFile(c.Path(saved)); err != nil || string(data) != "data" {
+		t.Fatalf("saved file = %q, %v", data, err)
+	}
+	if entries, _ := os.ReadDir(c.tempDir()); len(entries) != 0 {
+		t.Fatalf("temp dir must be empty after save, got %d entries", len(entries))
+	}
+
+	// a cancelled save publishes neither the file nor its metadata
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Save(ctx, "users.xlsx", []byte("data")); err == nil {
+		t.Fatal("save with a cancelled context must fail")
+	}
+	matches, _ := filepath.Glob(filepath.Join(c.BaseDir, "*users.xlsx*"))
+	if len(matches) != 0 {
+		t.Fatalf("cancelled save left %v", matches)
+	}
+
+	// a write interrupted by a crash is swept once old enough
+	orphan := filepath.Join(c.tempDir(), "debts.xlsx.123.tmp")
+	if err := os.WriteFile(orphan, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	_ = os.Chtimes(orphan, old, old)
+	if removed, err := c.RemoveTempFiles(time.Hour); err != nil || removed != 1 {
+		t.Fatalf("remove temp files: removed %d, %v", removed, err)
+	}
+}
+
+func TestIsTempFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"abc_debts.xlsx":             false,
+		"abc_debts.xlsx.tmp":         true,
+		".tmp/abc_debts.xlsx.1.tmp":  true,
+		".tmp/abc_debts.xlsx":        true,
+		"tenant/.tmp/x.xlsx":         true,
+		"tenant/abc_debts.tmp.xlsx":  false,
+		"tenant/abc_debts.xlsx.meta": false,
+	} {
+		if got := IsTempFile(name); got != want {
+			t.Errorf("IsTempFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSave_Dedup(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	if err := c.EnableDedup(); err != nil {
+		t.Skipf("dedup unsupported: %v", err)
+	}
+
+	ctx := context.Background()
+	a, _ := c.Save(ctx, "debts.xlsx", []byte("same"))
+	b, _ := c.Save(ctx, "debts.xlsx", []byte("same"))
+	other, _ := c.Save(ctx, "users.xlsx", []byte("other"))
+
+	stats, err := c.DedupStats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Blobs != 2 || stats.References != 3 || stats.SavedBytes != 4 || stats.Hits != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if got, _ := os.ReadFile(c.Path(b)); string(got) != "same" {
+		t.Fatalf("content of %s = %q", b, got)
+	}
+	// blobs are not stored files
+	usage, _ := c.Usage()
+	if len(usage) != 1 || usage[0].Files != 3 {
+		t.Fatalf("usage = %+v", usage)
+	}
+
+	// a blob lives while a file links to it
+	_ = c.Delete(a)
+	_ = c.Delete(other)
+	old := time.Now().Add(-2 * blobGrace)
+	_ = filepath.WalkDir(c.blobDir(), func(path string, _ os.DirEntry, _ error) error {
+		return os.Chtimes(path, old, old)
+	})
+	if _, err := c.CleanupOlderThan(time.Hour, 0); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	stats, _ = c.DedupStats()
+	if stats.Blobs != 1 || stats.References != 1 {
+		t.Fatalf("stats after cleanup = %+v", stats)
+	}
+	if _, err := os.Stat(c.Path(b)); err != nil {
+		t.Fatalf("%s removed: %v", b, err)
+	}
+	var blobs int
+	_ = filepath.WalkDir(c.blobDir(), func(_ string, de os.DirEntry, _ error) error {
+		if !de.IsDir() {
+			blobs++
+		}
+		return nil
+	})
+	if blobs != 1 {
+		t.Fatalf("%d blobs on disk", blobs)
+	}
+}
+
+func TestSave_Compression(t *testing.T) {
+	for _, enc := range []string{EncodingGzip, EncodingZstd} {
+		c, err := NewLocalStorage(t.TempDir(), "/files", "")
+		if err != nil {
+			t.Fatalf("storage init: %v", err)
+		}
+		if err := c.EnableCompression(enc); err != nil {
+			t.Fatalf("enable %s: %v", enc, err)
+		}
+		content := strings.Repeat("id;name\n1;Иванов\n", 1000)
+
+		csv, _ := c.Save(context.Background(), "debts.csv", []byte(content))
+		xlsx, _ := c.Save(context.Background(), "debts.xlsx", []byte(content))
+		if c.Encoding(csv) != enc || c.Encoding(xlsx) != "" {
+			t.Fatalf("%s: encodings %q, %q", enc, c.Encoding(csv), c.Encoding(xlsx))
+		}
+
+		stored, _ := os.ReadFile(c.Path(csv))
+		if len(stored) >= len(content) {
+			t.Fatalf("%s: stored %d bytes of %d", enc, len(stored), len(content))
+		}
+		f, _ := os.Open(c.Path(csv))
+		dec, err := NewDecoder(c.Encoding(csv), f)
+		if err != nil {
+			t.Fatalf("%s: decoder: %v", enc, err)
+		}
+		got, err := io.ReadAll(dec)
+		dec.Close()
+		f.Close()
+		if err != nil || string(got) != content {
+			t.Fatalf("%s: decoded %d bytes, err %v", enc, len(got), err)
+		}
+	}
+
+	c, _ := NewLocalStorage(t.TempDir(), "/files", "")
+	if err := c.EnableCompression("brotli"); err == nil {
+		t.Fatal("brotli accepted")
+	}
+}
+
+type memArchive struct {
+	files   map[string][]byte
+	meta    map[string]ArchiveMeta
+	pending bool
+	fail    bool
+}
+
+func (a *memArchive) Put(_ context.Context, file, localPath string, meta ArchiveMeta) error {
+	if a.fail {
+		return io.ErrUnexpectedEOF
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	a.files[file], a.meta[file] = data, meta
+	return nil
+}
+
+func (a *memArchive) Get(_ context.Context, file string) (io.ReadCloser, ArchiveMeta, error) {
+	data, ok := a.files[file]
+	if !ok {
+		return nil, ArchiveMeta{}, ErrNotArchived
+	}
+	if a.pending {
+		return nil, ArchiveMeta{}, ErrRestorePending
+	}
+	return io.NopCloser(strings.NewReader(string(data))), a.meta[file], nil
+}
+
+func TestCleanup_ArchivesAndRestores(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	archive := &memArchive{files: map[string][]byte{}, meta: map[string]ArchiveMeta{}, fail: true}
+	c.SetArchive(archive)
+
+	ctx := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme"})
+	saved, _ := c.Save(ctx, "Долги.xlsx", []byte("xlsx"))
+	_ = c.SetOwner(saved, 7, false)
+	old := time.Now().Add(-2 * time.Hour)
+	_ = os.Chtimes(c.Path(saved), old, old)
+
+	// не загрузился в архив — остаётся на диске до следующего запуска
+	if n, err := c.CleanupOlderThan(time.Hour, 0); err == nil || n != 0 {
+		t.Fatalf("cleanup with failing archive = %d, %v", n, err)
+	}
+	if _, err := os.Stat(c.Path(saved)); err != nil {
+		t.Fatalf("file removed without archiving: %v", err)
+	}
+
+	archive.fail = false
+	if n, err := c.CleanupOlderThan(time.Hour, 0); err != nil || n != 1 {
+		t.Fatalf("cleanup = %d, %v", n, err)
+	}
+	if meta := archive.meta[saved]; string(archive.files[saved]) != "xlsx" || meta.Original != "Долги.xlsx" || meta.OwnerID != 7 {
+		t.Fatalf("archived %q with %+v", archive.files[saved], meta)
+	}
+
+	archive.pending = true
+	if err := c.Restore(context.Background(), saved); err != ErrRestorePending {
+		t.Fatalf("restore while pending: %v", err)
+	}
+	archive.pending = false
+	if err := c.Restore(context.Background(), saved); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if got, _ := os.ReadFile(c.Path(saved)); string(got) != "xlsx" || c.OriginalName(saved) != "Долги.xlsx" {
+		t.Fatalf("restored %q as %q", got, c.OriginalName(saved))
+	}
+	for _, name := range []string{"missing.xlsx", "../x.xlsx", ".blobs/x", saved + ".meta.json"} {
+		if err := c.Restore(context.Background(), name); err != ErrNotArchived {
+			t.Errorf("restore %q: %v", name, err)
+		}
+	}
+}
+
+// cancelAfter cancels the export once the first chunk has been read.
+type cancelAfter struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p[:min(len(p), 4)])
+	c.cancel()
+	return n, err
+}
+
+func TestSaveStream(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	saved, err := c.SaveStream(context.Background(), "big.csv", strings.NewReader("a;b\n1;2\n"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if got, _ := os.ReadFile(c.Path(saved)); string(got) != "a;b\n1;2\n" {
+		t.Fatalf("content = %q", got)
+	}
+
+	// отменённая на середине запись не публикуется и не оставляет временных файлов
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := c.SaveStream(ctx, "cancelled.csv", &cancelAfter{r: strings.NewReader("a;b\n1;2\n"), cancel: cancel}); err != context.Canceled {
+		t.Fatalf("cancelled save: %v", err)
+	}
+	entries, _ := os.ReadDir(c.BaseDir)
+	tmp, _ := os.ReadDir(c.tempDir())
+	if len(entries) != 3 || len(tmp) != 0 { // .tmp, файл и его метаданные
+		t.Fatalf("left %d entries, %d temp files", len(entries), len(tmp))
+	}
+}
+
+func TestSignURL(t *testing.T) {
+	c, err := NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("failed create storage: %v", err)
+	}
+	signed, _ := c.Save(context.Background(), "debts.xlsx", []byte("xlsx"))
+	plain, _ := c.Save(context.Background(), "upload.xlsx", []byte("xlsx"))
+
+	// без ключа ссылки не истекают
+	link, expires, err := c.SignURL(signed, time.Hour)
+	if err != nil || link != c.GetURL(signed) || !expires.IsZero() {
+		t.Fatalf("unsigned link: %q %v %v", link, expires, err)
+	}
+
+	if err := c.EnableSignedURLs([]byte("secret"), 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	link, expires, err = c.SignURL(signed, time.Hour)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if until := time.Until(expires); until < 59*time.Minute || until > time.Hour {
+		t.Fatalf("link expires in %s, want an hour", until)
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Path != "/files/"+signed {
+		t.Fatalf("bad link %q", link)
+	}
+	query := u.Query()
+
+	if err := c.VerifyURL(signed, query, time.Now()); err != nil {
+		t.Fatalf("valid link rejected: %v", err)
+	}
+	if err := c.VerifyURL(signed, query, expires.Add(time.Second)); !errors.Is(err, ErrURLExpired) {
+		t.Fatalf("expected ErrURLExpired, got %v", err)
+	}
+	if err := c.VerifyURL(signed, url.Values{}, time.Now()); !errors.Is(err, ErrURLSignature) {
+		t.Fatalf("expected ErrURLSignature without a signature, got %v", err)
+	}
+	forged := url.Values{"expires": {strconv.FormatInt(expires.Add(time.Hour).Unix(), 10)}, "signature": query["signature"]}
+	if err := c.VerifyURL(signed, forged, time.Now()); !errors.Is(err, ErrURLSignature) {
+		t.Fatalf("expected ErrURLSignature for an extended expiry, got %v", err)
+	}
+	// файлы, для которых ссылку не подписывали, отдаются как раньше
+	if err := c.VerifyURL(plain, url.Values{}, time.Now()); err != nil {
+		t.Fatalf("plain file rejected: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package clients
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+const linkCountSupported = true
+
+// linkCount returns the number of hard links to the file of info.
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
+
+// diskSpace returns the space available to the process and the size of the
+// filesystem holding dir.
+func diskSpace(dir string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,142 @@
+package clients
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"debtster-export/internal/tenant"
+)
+
+// StorageUsage is the disk space taken by the files of one user of one tenant.
+// UserID 0 collects files without an owner (uploads, files saved before owners were recorded).
+type StorageUsage struct {
+	Tenant string `json:"tenant,omitempty"`
+	UserID int64  `json:"user_id"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type usageKey struct {
+	tenant string
+	userID int64
+}
+
+// Usage sums stored files per tenant and owner. It walks the storage, so the
+// numbers are always exact and shared by all instances using the same directory.
+func (s *StorageClient) Usage() ([]StorageUsage, error) {
+	byKey := map[usageKey]*StorageUsage{}
+	err := filepath.WalkDir(s.BaseDir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() && de.Name() == blobDirName {
+			return fs.SkipDir
+		}
+		if de.IsDir() || !isStoredFile(path) {
+			return nil
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
+
+		var tenantID string
+		if rel, err := filepath.Rel(s.BaseDir, path); err == nil {
+			if dir := filepath.Dir(rel); dir != "." {
+				tenantID = filepath.ToSlash(dir)
+			}
+		}
+		meta, _ := readMeta(path)
+
+		k := usageKey{tenant: tenantID, userID: meta.OwnerID}
+		u, ok := byKey[k]
+		if !ok {
+			u = &StorageUsage{Tenant: tenantID, UserID: meta.OwnerID}
+			byKey[k] = u
+		}
+		u.Files++
+		u.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]StorageUsage, 0, len(byKey))
+	for _, u := range byKey {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tenant != out[j].Tenant {
+			return out[i].Tenant < out[j].Tenant
+		}
+		return out[i].UserID < out[j].UserID
+	})
+	return out, nil
+}
+
+// usedBytes returns the bytes stored by userID and by the whole tenant from
+// ctx (the storage root in single-tenant mode). Only the tenant directory is read.
+func (s *StorageClient) usedBytes(ctx context.Context, userID int64) (user, total int64, err error) {
+	dir := s.BaseDir
+	if t, ok := tenant.FromContext(ctx); ok {
+		dir = filepath.Join(s.BaseDir, t.ID)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	for _, de := range entries {
+		path := filepath.Join(dir, de.Name())
+		if de.IsDir() || !isStoredFile(path) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		if meta, ok := readMeta(path); ok && meta.OwnerID == userID {
+			user += info.Size()
+		}
+	}
+	return user, total, nil
+}
+
+// isStoredFile skips metadata sidecars and unfinished writes.
+func isStoredFile(path string) bool {
+	return !IsMetaFile(path) && !IsTempFile(path)
+}
+
+// StorageQuota limits the disk space a user and a tenant may hold; a zero
+// limit disables that check.
+type StorageQuota struct {
+	storage     *StorageClient
+	userBytes   int64
+	tenantBytes int64
+}
+
+func NewStorageQuota(storage *StorageClient, userBytes, tenantBytes int64) *StorageQuota {
+	return &StorageQuota{storage: storage, userBytes: userBytes, tenantBytes: tenantBytes}
+}
+
+// QuotaExceeded reports whether userID (or the tenant from ctx) already uses
+// its whole quota, so a new export must be rejected.
+func (q *StorageQuota) QuotaExceeded(ctx context.Context, userID int64) (bool, error) {
+	if q.userBytes <= 0 && q.tenantBytes <= 0 {
+		return false, nil
+	}
+
+	user, total, err := q.storage.usedBytes(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return (q.userBytes > 0 && user >= q.userBytes) || (q.tenantBytes > 0 && total >= q.tenantBytes), nil
+}
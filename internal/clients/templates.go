@@ -0,0 +1,140 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/tenant"
+)
+
+// templateExt is the extension of stored templates; their metadata sits next
+// to them like for exported files.
+const templateExt = ".xlsx"
+
+type templateMeta struct {
+	UploadedAt time.Time `json:"uploaded_at"`
+	UploadedBy int64     `json:"uploaded_by"`
+}
+
+// TemplateStorage keeps admin-uploaded XLSX templates as "<name>.xlsx" files in
+// their own directory, so the retention cleanup of exports never touches them.
+// Tenants get a subdirectory like in StorageClient.
+type TemplateStorage struct {
+	BaseDir string
+
+	mu sync.Mutex // serializes replacing a template and its metadata
+}
+
+// NewTemplateStorage creates a template storage; baseDir will be created if missing.
+func NewTemplateStorage(baseDir string) (*TemplateStorage, error) {
+	if baseDir == "" {
+		baseDir = "./templates"
+	}
+	if err := os.MkdirAll(filepath.Join(baseDir, tempDirName), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to ensure templates dir %q: %w", baseDir, err)
+	}
+	return &TemplateStorage{BaseDir: baseDir}, nil
+}
+
+func (s *TemplateStorage) dir(ctx context.Context) string {
+	if t, ok := tenant.FromContext(ctx); ok {
+		return filepath.Join(s.BaseDir, t.ID)
+	}
+	return s.BaseDir
+}
+
+// path returns the file of a template; names are validated by the caller, the
+// base name guards against traversal anyway.
+func (s *TemplateStorage) path(ctx context.Context, name string) string {
+	return filepath.Join(s.dir(ctx), filepath.Base(name)+templateExt)
+}
+
+// Put stores or replaces the template name.
+func (s *TemplateStorage) Put(ctx context.Context, name string, data []byte, uploadedBy int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir(ctx), 0o755); err != nil {
+		return fmt.Errorf("failed to ensure templates dir: %w", err)
+	}
+	path := s.path(ctx, name)
+
+	meta, err := json.Marshal(templateMeta{UploadedAt: time.Now().UTC(), UploadedBy: uploadedBy})
+	if err != nil {
+		return fmt.Errorf("failed to encode template metadata: %w", err)
+	}
+	if err := writeAtomic(ctx, filepath.Join(s.BaseDir, tempDirName), path, data); err != nil {
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	if err := writeAtomic(context.Background(), filepath.Join(s.BaseDir, tempDirName), path+metaSuffix, meta); err != nil {
+		return fmt.Errorf("failed to write template metadata: %w", err)
+	}
+	return nil
+}
+
+// Get returns the content of the template name.
+func (s *TemplateStorage) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(ctx, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %q", domain.ErrTemplateNotFound, name)
+	}
+	return data, err
+}
+
+// List returns the stored templates sorted by name.
+func (s *TemplateStorage) List(ctx context.Context) ([]domain.ExportTemplate, error) {
+	entries, err := os.ReadDir(s.dir(ctx))
+	if os.IsNotExist(err) {
+		return []domain.ExportTemplate{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []domain.ExportTemplate{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), templateExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		t := domain.ExportTemplate{Name: strings.TrimSuffix(e.Name(), templateExt), Size: info.Size(), UploadedAt: info.ModTime().UTC()}
+		if raw, err := os.ReadFile(filepath.Join(s.dir(ctx), e.Name()) + metaSuffix); err == nil {
+			var meta templateMeta
+			if json.Unmarshal(raw, &meta) == nil {
+				t.UploadedAt, t.UploadedBy = meta.UploadedAt, meta.UploadedBy
+			}
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Delete removes the template name.
+func (s *TemplateStorage) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(ctx, name)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %q", domain.ErrTemplateNotFound, name)
+		}
+		return err
+	}
+	if err := os.Remove(path + metaSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/tenant"
+)
+
+func TestTemplateStorage_PutListDelete(t *testing.T) {
+	s, err := NewTemplateStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "bank", []byte("xlsx"), 7); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	data, err := s.Get(ctx, "bank")
+	if err != nil || string(data) != "xlsx" {
+		t.Fatalf("get: %q, %v", data, err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	// метаданные рядом с шаблоном не попадают в список
+	if len(list) != 1 || list[0].Name != "bank" || list[0].Size != 4 || list[0].UploadedBy != 7 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	// шаблоны тенанта хранятся отдельно
+	tctx := tenant.WithTenant(ctx, tenant.Tenant{ID: "acme"})
+	if _, err := s.Get(tctx, "bank"); !errors.Is(err, domain.ErrTemplateNotFound) {
+		t.Fatalf("expected not found for other tenant, got %v", err)
+	}
+
+	if err := s.Delete(ctx, "bank"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := s.Delete(ctx, "bank"); !errors.Is(err, domain.ErrTemplateNotFound) {
+		t.Fatalf("expected not found on second delete, got %v", err)
+	}
+	if list, _ := s.List(ctx); len(list) != 0 {
+		t.Fatalf("expected empty list, got %+v", list)
+	}
+}
@@ -0,0 +1,455 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultWarehouseBatch is the number of rows sent in one insert request.
+const defaultWarehouseBatch = 1000
+
+// ClickHouseProfile is a ClickHouse table export rows are inserted into over
+// the HTTP interface.
+type ClickHouseProfile struct {
+	Name string
+	// URL of the HTTP interface, e.g. https://clickhouse.local:8443.
+	URL      string
+	User     string
+	Password string
+	Database string
+	Table    string
+	// BatchSize is the number of rows per INSERT; defaults to 1000.
+	BatchSize int
+	Timeout   time.Duration
+}
+
+type clickHouseTarget struct {
+	endpoint  string
+	user      string
+	password  string
+	table     string
+	batchSize int
+	timeout   time.Duration
+}
+
+// ClickHouseClient inserts export rows into the configured ClickHouse profiles.
+type ClickHouseClient struct {
+	targets map[string]clickHouseTarget
+	http    *http.Client
+}
+
+// NewClickHouseClient checks every profile, so a broken profile fails at
+// startup instead of on the first export.
+func NewClickHouseClient(profiles []ClickHouseProfile) (*ClickHouseClient, error) {
+	c := &ClickHouseClient{targets: map[string]clickHouseTarget{}, http: &http.Client{}}
+	for _, p := range profiles {
+		if p.URL == "" || p.Table == "" {
+			return nil, fmt.Errorf("clickhouse profile %q: url and table are required", p.Name)
+		}
+		if _, err := url.ParseRequestURI(p.URL); err != nil {
+			return nil, fmt.Errorf("clickhouse profile %q: invalid url: %w", p.Name, err)
+		}
+		table := quoteClickHouseIdent(p.Table)
+		if p.Database != "" {
+			table = quoteClickHouseIdent(p.Database) + "." + table
+		}
+		c.targets[p.Name] = clickHouseTarget{
+			endpoint:  strings.TrimRight(p.URL, "/") + "/",
+			user:      p.User,
+			password:  p.Password,
+			table:     table,
+			batchSize: batchSize(p.BatchSize),
+			timeout:   timeoutOr(p.Timeout),
+		}
+	}
+	return c, nil
+}
+
+// HasProfile reports whether inserts into profile are configured.
+func (c *ClickHouseClient) HasProfile(profile string) bool {
+	_, ok := c.targets[profile]
+	return ok
+}
+
+// Profiles returns the configured profile names, sorted.
+func (c *ClickHouseClient) Profiles() []string {
+	return sortedKeys(c.targets)
+}
+
+// Insert writes rows in batches of JSONEachRow and returns the table name.
+// Every batch carries a deduplication token derived from token, so a retried
+// export does not insert the batches that already made it twice.
+func (c *ClickHouseClient) Insert(ctx context.Context, profile, token string, rows []map[string]any) (string, error) {
+	target, ok := c.targets[profile]
+	if !ok {
+		return "", fmt.Errorf("unknown clickhouse profile %q", profile)
+	}
+
+	for start, batch := 0, 0; start < len(rows); start, batch = start+target.batchSize, batch+1 {
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, row := range rows[start:min(start+target.batchSize, len(rows))] {
+			if err := enc.Encode(row); err != nil {
+				return "", fmt.Errorf("encode row: %w", err)
+			}
+		}
+
+		query := url.Values{}
+		query.Set("query", "INSERT INTO "+target.table+" FORMAT JSONEachRow")
+		query.Set("insert_deduplication_token", fmt.Sprintf("%s-%d", token, batch))
+		if err := c.post(ctx, target, target.endpoint+"?"+query.Encode(), &body); err != nil {
+			return "", fmt.Errorf("insert rows %d-%d: %w", start+1, start+min(target.batchSize, len(rows)-start), err)
+		}
+	}
+	return target.table, nil
+}
+
+func (c *ClickHouseClient) post(ctx context.Context, target clickHouseTarget, endpoint string, body io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, target.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if target.user != "" {
+		req.Header.Set("X-ClickHouse-User", target.user)
+		req.Header.Set("X-ClickHouse-Key", target.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return unwrapURLError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func quoteClickHouseIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// BigQueryProfile is a BigQuery table export rows are streamed into with the
+// tabledata.insertAll API, authenticated as a service account.
+type BigQueryProfile struct {
+	Name    string
+	Project string
+	Dataset string
+	Table   string
+	// CredentialsFile is the JSON key of the service account.
+	CredentialsFile string
+	// BatchSize is the number of rows per request; defaults to 1000.
+	BatchSize int
+	Timeout   time.Duration
+}
+
+type bigQueryTarget struct {
+	table     string
+	endpoint  string
+	batchSize int
+	timeout   time.Duration
+	token     *serviceAccountToken
+}
+
+// BigQueryClient streams export rows into the configured BigQuery profiles.
+type BigQueryClient struct {
+	targets map[string]bigQueryTarget
+	http    *http.Client
+}
+
+const (
+	bigQueryAPI   = "https://bigquery.googleapis.com/bigquery/v2"
+	bigQueryScope = "https://www.googleapis.com/auth/bigquery.insertdata"
+)
+
+// NewBigQueryClient checks every profile and loads its service account key.
+func NewBigQueryClient(profiles []BigQueryProfile) (*BigQueryClient, error) {
+	c := &BigQueryClient{targets: map[string]bigQueryTarget{}, http: &http.Client{}}
+	for _, p := range profiles {
+		target, err := newBigQueryTarget(p, bigQueryAPI)
+		if err != nil {
+			return nil, fmt.Errorf("bigquery profile %q: %w", p.Name, err)
+		}
+		c.targets[p.Name] = target
+	}
+	return c, nil
+}
+
+func newBigQueryTarget(p BigQueryProfile, api string) (bigQueryTarget, error) {
+	if p.Project == "" || p.Dataset == "" || p.Table == "" {
+		return bigQueryTarget{}, errors.New("project, dataset and table are required")
+	}
+	if p.CredentialsFile == "" {
+		return bigQueryTarget{}, errors.New("credentials file is required")
+	}
+	token, err := loadServiceAccount(p.CredentialsFile)
+	if err != nil {
+		return bigQueryTarget{}, err
+	}
+	return bigQueryTarget{
+		table: p.Project + "." + p.Dataset + "." + p.Table,
+		endpoint: fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll", api,
+			url.PathEscape(p.Project), url.PathEscape(p.Dataset), url.PathEscape(p.Table)),
+		batchSize: batchSize(p.BatchSize),
+		timeout:   timeoutOr(p.Timeout),
+		token:     token,
+	}, nil
+}
+
+// HasProfile reports whether inserts into profile are configured.
+func (c *BigQueryClient) HasProfile(profile string) bool {
+	_, ok := c.targets[profile]
+	return ok
+}
+
+// Profiles returns the configured profile names, sorted.
+func (c *BigQueryClient) Profiles() []string {
+	return sortedKeys(c.targets)
+}
+
+// Insert streams rows in batches and returns the table name. Rows carry
+// insert IDs derived from token, which BigQuery uses to drop the rows of a
+// retried export it has already received.
+func (c *BigQueryClient) Insert(ctx context.Context, profile, token string, rows []map[string]any) (string, error) {
+	target, ok := c.targets[profile]
+	if !ok {
+		return "", fmt.Errorf("unknown bigquery profile %q", profile)
+	}
+
+	type insertRow struct {
+		InsertID string         `json:"insertId"`
+		JSON     map[string]any `json:"json"`
+	}
+	for start := 0; start < len(rows); start += target.batchSize {
+		end := min(start+target.batchSize, len(rows))
+		batch := make([]insertRow, 0, end-start)
+		for i, row := range rows[start:end] {
+			batch = append(batch, insertRow{InsertID: fmt.Sprintf("%s-%d", token, start+i), JSON: row})
+		}
+		body, err := json.Marshal(map[string]any{"rows": batch})
+		if err != nil {
+			return "", fmt.Errorf("encode rows: %w", err)
+		}
+		if err := c.insertAll(ctx, target, body); err != nil {
+			return "", fmt.Errorf("insert rows %d-%d: %w", start+1, end, err)
+		}
+	}
+	return target.table, nil
+}
+
+func (c *BigQueryClient) insertAll(ctx context.Context, target bigQueryTarget, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, target.timeout)
+	defer cancel()
+
+	accessToken, err := target.token.get(ctx, c.http)
+	if err != nil {
+		return fmt.Errorf("authenticate: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return unwrapURLError(err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply); err != nil && err != io.EOF {
+		return fmt.Errorf("status %d: bad reply: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		if reply.Error != nil {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, reply.Error.Message)
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	// the rows of a batch are accepted or rejected together
+	for _, e := range reply.InsertErrors {
+		for _, detail := range e.Errors {
+			if detail.Reason != "stopped" {
+				return fmt.Errorf("row %d: %s: %s", e.Index, detail.Reason, detail.Message)
+			}
+		}
+	}
+	if len(reply.InsertErrors) > 0 {
+		return errors.New("rows rejected")
+	}
+	return nil
+}
+
+// serviceAccountToken exchanges a signed JWT of a service account for access
+// tokens and caches them until shortly before they expire.
+type serviceAccountToken struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURL string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func loadServiceAccount(file string) (*serviceAccountToken, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+	var creds struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return nil, fmt.Errorf("parse credentials: %w", err)
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if creds.ClientEmail == "" || block == nil {
+		return nil, errors.New("credentials: client_email and private_key are required")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &serviceAccountToken{email: creds.ClientEmail, key: key, tokenURL: creds.TokenURI}, nil
+}
+
+func (t *serviceAccountToken) get(ctx context.Context, client *http.Client) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expires) > time.Minute {
+		return t.token, nil
+	}
+
+	assertion, err := t.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", unwrapURLError(err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply); err != nil {
+		return "", fmt.Errorf("status %d: bad reply: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode/100 != 2 || reply.AccessToken == "" {
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, reply.Error)
+	}
+	t.token = reply.AccessToken
+	t.expires = time.Now().Add(time.Duration(reply.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// assertion is the RS256-signed JWT the token endpoint accepts.
+func (t *serviceAccountToken) assertion(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   t.email,
+		"scope": bigQueryScope,
+		"aud":   t.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("sign assertion: %w", err)
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+func batchSize(n int) int {
+	if n <= 0 {
+		return defaultWarehouseBatch
+	}
+	return n
+}
+
+func timeoutOr(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 60 * time.Second
+	}
+	return d
+}
+
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for name := range m {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,159 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClickHouseClient_Insert(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		queries []string
+		tokens  []string
+		rows    []map[string]any
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-ClickHouse-User") != "bi" || r.Header.Get("X-ClickHouse-Key") != "secret" {
+			http.Error(w, "Authentication failed", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		queries = append(queries, r.URL.Query().Get("query"))
+		tokens = append(tokens, r.URL.Query().Get("insert_deduplication_token"))
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rows = append(rows, row)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClickHouseClient([]ClickHouseProfile{{
+		Name: "bi", URL: server.URL, User: "bi", Password: "secret",
+		Database: "exports", Table: "debts", BatchSize: 2,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasProfile("bi") || c.HasProfile("other") {
+		t.Fatalf("profiles: %v", c.Profiles())
+	}
+
+	table, err := c.Insert(context.Background(), "bi", "exports:1", []map[string]any{
+		{"number": "D-1", "amount": 10.5}, {"number": "D-2", "amount": nil}, {"number": "D-3", "amount": 1.0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table != "`exports`.`debts`" {
+		t.Fatalf("table = %q", table)
+	}
+
+	// три строки пачками по две, у каждой пачки свой токен дедупликации
+	if len(queries) != 2 || queries[0] != "INSERT INTO `exports`.`debts` FORMAT JSONEachRow" {
+		t.Fatalf("queries: %q", queries)
+	}
+	if tokens[0] != "exports:1-0" || tokens[1] != "exports:1-1" {
+		t.Fatalf("tokens: %q", tokens)
+	}
+	if len(rows) != 3 || rows[2]["number"] != "D-3" || rows[1]["amount"] != nil {
+		t.Fatalf("rows: %v", rows)
+	}
+
+	bad, _ := NewClickHouseClient([]ClickHouseProfile{{Name: "bi", URL: server.URL, Table: "debts"}})
+	if _, err := bad.Insert(context.Background(), "bi", "exports:2", []map[string]any{{"number": "D-1"}}); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("expected the server error, got %v", err)
+	}
+}
+
+func TestBigQueryClient_Insert(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		tokenCalls int
+		inserts    []map[string]any
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenCalls++
+		_ = r.ParseForm()
+		if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.PostForm.Get("assertion"), ".") != 2 {
+			http.Error(w, `{"error_description":"bad assertion"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"at-1","expires_in":3600}`))
+	})
+	mux.HandleFunc("/projects/acme/datasets/exports/tables/debts/insertAll", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"unauthorized"}}`))
+			return
+		}
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		inserts = append(inserts, body)
+		_, _ = w.Write([]byte(`{"kind":"bigquery#tableDataInsertAllResponse"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	creds, _ := json.Marshal(map[string]string{
+		"client_email": "export@acme.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	credsFile := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(credsFile, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := newBigQueryTarget(BigQueryProfile{
+		Name: "bi", Project: "acme", Dataset: "exports", Table: "debts", CredentialsFile: credsFile, BatchSize: 2,
+	}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &BigQueryClient{targets: map[string]bigQueryTarget{"bi": target}, http: server.Client()}
+
+	table, err := c.Insert(context.Background(), "bi", "exports:1", []map[string]any{
+		{"number": "D-1"}, {"number": "D-2"}, {"number": "D-3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table != "acme.exports.debts" {
+		t.Fatalf("table = %q", table)
+	}
+	// токен получен один раз и переиспользуется между пачками
+	if tokenCalls != 1 || len(inserts) != 2 {
+		t.Fatalf("token calls %d, inserts %d", tokenCalls, len(inserts))
+	}
+	last := inserts[1]["rows"].([]any)[0].(map[string]any)
+	if last["insertId"] != "exports:1-2" || last["json"].(map[string]any)["number"] != "D-3" {
+		t.Fatalf("last row: %v", last)
+	}
+}
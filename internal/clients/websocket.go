@@ -3,12 +3,17 @@ package clients
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"debtster-export/internal/domain"
+	exportprogress "debtster-export/internal/progress"
+	"debtster-export/internal/requestid"
 	ws "debtster-export/internal/transport/websocket"
 )
 
 type WebSocketClient struct {
-	hub *ws.Hub
+	hub   *ws.Hub
+	relay *WSRelay
 }
 
 func NewWebSocketClient(hub *ws.Hub) *WebSocketClient {
@@ -17,6 +22,20 @@ func NewWebSocketClient(hub *ws.Hub) *WebSocketClient {
 	}
 }
 
+// SetRelay sends the events through r to the connections of all instances
+// instead of to the local hub, which gets them back from the relay.
+func (c *WebSocketClient) SetRelay(r *WSRelay) {
+	c.relay = r
+}
+
+func (c *WebSocketClient) broadcast(userID int64, message *ws.Message) {
+	if c.relay != nil {
+		c.relay.Broadcast(userID, message)
+		return
+	}
+	c.hub.Broadcast(userID, message)
+}
+
 func (c *WebSocketClient) NotifyExportProgress(
 	ctx context.Context,
 	userID int64,
@@ -24,26 +43,29 @@ func (c *WebSocketClient) NotifyExportProgress(
 	progress float64,
 	stage string,
 ) error {
-	if c.hub == nil {
+	if c.hub == nil && c.relay == nil {
 		return nil
 	}
 
-	channel := fmt.Sprintf("notify_user_of_progress_export#%d", userID)
-	data := map[string]interface{}{
-		"id":       exportID,
-		"progress": progress,
+	data := ws.ExportProgressData{
+		ID:       exportID,
+		Progress: progress,
+		Stage:    stage,
 	}
-	if stage != "" {
-		data["stage"] = stage
+	if eta, ok := exportprogress.ETAFromContext(ctx); ok {
+		seconds := int64(eta / time.Second)
+		data.ETASeconds = &seconds
 	}
 
+	channel := fmt.Sprintf("notify_user_of_progress_export#%d", userID)
 	message := &ws.Message{
-		Type:    "export_progress",
-		Channel: channel,
-		Data:    data,
+		Type:      ws.TypeExportProgress,
+		Channel:   channel,
+		Data:      data,
+		RequestID: requestid.FromContext(ctx),
 	}
 
-	c.hub.Broadcast(userID, message)
+	c.broadcast(userID, message)
 	return nil
 }
 
@@ -54,43 +76,68 @@ func (c *WebSocketClient) NotifyExportComplete(
 	url string,
 	filename string,
 ) error {
-	if c.hub == nil {
+	if c.hub == nil && c.relay == nil {
 		return nil
 	}
 
 	channel := fmt.Sprintf("notify_user_when_export_complete#%d", userID)
 	message := &ws.Message{
-		Type:    "export_complete",
+		Type:    ws.TypeExportComplete,
 		Channel: channel,
-		Data: map[string]interface{}{
-			"id":       exportID,
-			"url":      url,
-			"filename": filename,
-			"user_id":  userID,
+		Data: ws.ExportCompleteData{
+			ID:       exportID,
+			URL:      url,
+			Filename: filename,
+			UserID:   userID,
 		},
+		RequestID: requestid.FromContext(ctx),
 	}
 
-	c.hub.Broadcast(userID, message)
+	c.broadcast(userID, message)
 	return nil
 }
 
 // NotifyExportFailed notifies a user that an export failed with the provided error message.
 func (c *WebSocketClient) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
-	if c.hub == nil {
+	if c.hub == nil && c.relay == nil {
 		return nil
 	}
 
 	channel := fmt.Sprintf("notify_user_when_export_failed#%d", userID)
 	message := &ws.Message{
-		Type:    "export_failed",
+		Type:    ws.TypeExportFailed,
 		Channel: channel,
-		Data: map[string]interface{}{
-			"id":      exportID,
-			"message": errMsg,
-			"user_id": userID,
+		Data: ws.ExportFailedData{
+			ID:      exportID,
+			Message: errMsg,
+			UserID:  userID,
 		},
+		RequestID: requestid.FromContext(ctx),
 	}
 
-	c.hub.Broadcast(userID, message)
+	c.broadcast(userID, message)
+	return nil
+}
+
+// NotifyBatchComplete notifies a user that every export of a batch is finished,
+// with the file or error of each one.
+func (c *WebSocketClient) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	if c.hub == nil && c.relay == nil {
+		return nil
+	}
+
+	channel := fmt.Sprintf("notify_user_when_export_complete#%d", userID)
+	message := &ws.Message{
+		Type:    ws.TypeExportBatchComplete,
+		Channel: channel,
+		Data: ws.ExportBatchCompleteData{
+			ID:      batchID,
+			Exports: results,
+			UserID:  userID,
+		},
+		RequestID: requestid.FromContext(ctx),
+	}
+
+	c.broadcast(userID, message)
 	return nil
 }
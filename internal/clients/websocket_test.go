@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"debtster-export/internal/requestid"
 	ws "debtster-export/internal/transport/websocket"
 
 	"github.com/gorilla/websocket"
@@ -287,3 +288,64 @@ func TestWebSocketClient_MultipleProgressUpdates(t *testing.T) {
 		}
 	}
 }
+
+func TestWebSocketClient_RequestID(t *testing.T) {
+	hub := ws.NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.HandleWebSocket(w, r, 1)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?user_id=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	client := NewWebSocketClient(hub)
+	reqCtx := requestid.WithID(context.Background(), "req-1")
+
+	// каждое событие несёт id запроса, запустившего выгрузку
+	notify := []func(context.Context) error{
+		func(ctx context.Context) error { return client.NotifyExportProgress(ctx, 1, "export-123", 50, "") },
+		func(ctx context.Context) error {
+			return client.NotifyExportComplete(ctx, 1, "export-123", "https://example.com/file.xlsx", "file.xlsx")
+		},
+		func(ctx context.Context) error {
+			return client.NotifyExportFailed(ctx, 1, "export-123", "upload failed")
+		},
+	}
+	for _, send := range notify {
+		if err := send(reqCtx); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var received ws.Message
+		if err := conn.ReadJSON(&received); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		if received.RequestID != "req-1" {
+			t.Errorf("%s: request_id = %q", received.Type, received.RequestID)
+		}
+	}
+
+	// без id запроса поля нет вовсе
+	if err := client.NotifyExportFailed(context.Background(), 1, "export-123", "upload failed"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var raw map[string]any
+	if err := conn.ReadJSON(&raw); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if _, ok := raw["request_id"]; ok {
+		t.Errorf("request_id without a request: %v", raw)
+	}
+}
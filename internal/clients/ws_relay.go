@@ -0,0 +1,80 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"debtster-export/internal/tenant"
+	ws "debtster-export/internal/transport/websocket"
+)
+
+// WSRelay carries WebSocket events between instances over Redis pub/sub: the
+// instance running an export publishes its events, and every instance holding
+// connections delivers them to its own. With a job queue the export and the
+// connection of its user are rarely on the same instance.
+//
+// Pub/sub is at most once: an event published while an instance is
+// reconnecting to Redis does not reach its connections. Completion events are
+// kept by the outbox, progress events are superseded by the next one.
+type WSRelay struct {
+	redis   *RedisClient
+	channel string
+}
+
+// relayedMessage is a ws.Message on the wire; the data is passed through as
+// it was encoded by the publisher.
+type relayedMessage struct {
+	UserID    int64           `json:"user_id"`
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+// NewWSRelay relays over the Redis channel "ws_events", shared by all tenants:
+// users are told apart by their id alone, as in the hub.
+func NewWSRelay(redis *RedisClient) *WSRelay {
+	return &WSRelay{redis: redis, channel: redis.withPrefix(tenant.Without(context.Background()), "ws_events")}
+}
+
+// Broadcast publishes message to the connections of userID on all instances.
+func (r *WSRelay) Broadcast(userID int64, message *ws.Message) {
+	message.UserID = userID
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("[WS] relay: encode %s: %v", message.Type, err)
+		return
+	}
+
+	ctx, cancel := r.redis.withTimeout(context.Background())
+	defer cancel()
+	if err := r.redis.done("publish", r.redis.raw.Publish(ctx, r.channel, payload).Err()); err != nil {
+		log.Printf("[WS] relay: publish %s for user %d: %v", message.Type, userID, err)
+	}
+}
+
+// Run delivers the relayed events to the connections of hub until ctx is
+// done; the subscription is re-established by the client after a disconnect.
+func (r *WSRelay) Run(ctx context.Context, hub *ws.Hub) {
+	sub := r.redis.raw.Subscribe(ctx, r.channel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var m relayedMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.Printf("[WS] relay: decode: %v", err)
+				continue
+			}
+			hub.Broadcast(m.UserID, &ws.Message{Type: m.Type, Channel: m.Channel, Data: m.Data, RequestID: m.RequestID})
+		}
+	}
+}
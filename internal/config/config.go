@@ -1,9 +1,17 @@
 package config
 
 import (
-	"log"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type PostgresConfig struct {
@@ -13,6 +21,10 @@ type PostgresConfig struct {
 	Password string
 	DBName   string
 	SSLMode  string
+	// QueryLog logs every repository query with its duration
+	QueryLog bool
+	// SlowQueryMs — queries at least this slow are logged as warnings; 0 disables it
+	SlowQueryMs int
 }
 
 type RedisConfig struct {
@@ -25,65 +37,1008 @@ type RedisConfig struct {
 	Prefix      string
 }
 
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	// PresignTTL — lifetime of presigned URLs in hours
+	PresignTTL int
+}
+
+// ArchiveConfig — cold storage expired export files are moved to instead of
+// being deleted; off when S3.Bucket is empty
+type ArchiveConfig struct {
+	S3     S3Config
+	Prefix string
+	// StorageClass of archived objects ("GLACIER", "STANDARD_IA", ...); "" — bucket default
+	StorageClass string
+	// RestoreDays — how long a copy restored from a Glacier class stays readable
+	RestoreDays int
+}
+
+// StorageFailoverConfig — second export directory saves fail over to while
+// ExportDir keeps failing; off when FallbackDir is empty
+type StorageFailoverConfig struct {
+	FallbackDir string
+	// Threshold — failed saves in a row after which the fallback is used
+	Threshold int
+	// CooldownSec — how long saves go to the fallback before the primary is tried again
+	CooldownSec int
+	// ProbeIntervalSec — schedule of the write probe that brings the primary back earlier; 0 disables it
+	ProbeIntervalSec int
+}
+
+// RetryConfig — backoff for transient failures inside export jobs
+type RetryConfig struct {
+	// Attempts — total tries including the first one; 1 disables retries
+	Attempts    int
+	BaseDelayMs int
+	MaxDelayMs  int
+}
+
+type RateLimitConfig struct {
+	IPPerMinute   int
+	IPBurst       int
+	UserPerMinute int
+	UserBurst     int
+}
+
+// TLSConfig — native TLS termination; disabled when CertFile is empty
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification (mTLS) for internal callers
+	ClientCAFile string
+	// ClientAuth — "optional" verifies a certificate if one is sent, "require" rejects clients without one
+	ClientAuth string
+	// RedirectAddr — plain HTTP listen address redirecting to HTTPS (e.g. :80); empty disables it
+	RedirectAddr string
+}
+
+// VaultConfig — credentials source; disabled when Addr is empty. Paths are
+// relative to the KV v2 mount, empty paths keep the env credentials.
+type VaultConfig struct {
+	Addr             string
+	Token            string
+	Mount            string
+	PostgresPath     string
+	RedisPath        string
+	TelephonyS3Path  string
+	RenewIntervalMin int
+}
+
+// SMTPConfig — mail server for email notifications; disabled when Addr is empty
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	User     string
+	Password string
+}
+
+// SFTPProfileConfig — counterparty SFTP server exports can be delivered to,
+// read from SFTP_<NAME>_* settings
+type SFTPProfileConfig struct {
+	Name     string
+	Host     string
+	Port     int
+	User     string
+	Password string
+	KeyFile  string
+	// KnownHostsFile pins the server host key (ssh known_hosts format)
+	KnownHostsFile string
+	Dir            string
+	TimeoutSec     int
+}
+
+// ClickHouseProfileConfig — ClickHouse table export rows can be inserted into,
+// read from CLICKHOUSE_<NAME>_* settings
+type ClickHouseProfileConfig struct {
+	Name string
+	// URL of the HTTP interface
+	URL        string
+	User       string
+	Password   string
+	Database   string
+	Table      string
+	BatchSize  int
+	TimeoutSec int
+}
+
+// BigQueryProfileConfig — BigQuery table export rows can be streamed into,
+// read from BIGQUERY_<NAME>_* settings
+type BigQueryProfileConfig struct {
+	Name    string
+	Project string
+	Dataset string
+	Table   string
+	// CredentialsFile is the JSON key of a service account
+	CredentialsFile string
+	BatchSize       int
+	TimeoutSec      int
+}
+
+// KafkaProfileConfig — Kafka topic export rows can be published to, read from
+// KAFKA_<NAME>_* settings
+type KafkaProfileConfig struct {
+	Name string
+	// Brokers — bootstrap host:port addresses of the cluster
+	Brokers []string
+	// User and Password authenticate with SASL (PLAIN, SCRAM-SHA-256 or
+	// SCRAM-SHA-512) when User is set
+	User          string
+	Password      string
+	SASLMechanism string
+	TLS           bool
+	Topic         string
+	// Mode — "row" (a message per row) or "ndjson" (chunks of ChunkRows rows)
+	Mode       string
+	ChunkRows  int
+	KeyField   string
+	TimeoutSec int
+}
+
+// APIKeyConfig — static key other services authenticate with instead of a
+// user token, read from API_KEY_<NAME>_* settings
+type APIKeyConfig struct {
+	Name string
+	// Hash is the hex SHA-256 of the key; the key itself is never configured
+	Hash string
+	// UserID is the synthetic user the exports of the key are attributed to
+	UserID    int64
+	Abilities []string
+}
+
+// WebSocketConfig — keepalive and buffering of /ws connections
+type WebSocketConfig struct {
+	// PingPeriodSec must be shorter than PongWaitSec; clients behind aggressive
+	// NATs need both lower than the NAT idle timeout
+	PingPeriodSec int
+	PongWaitSec   int
+	WriteWaitSec  int
+	// SendBuffer — messages queued per connection before a slow client is dropped
+	SendBuffer int
+	// BroadcastBuffer — messages queued in the hub before new ones are dropped
+	BroadcastBuffer int
+	// ReadBufferBytes / WriteBufferBytes — connection I/O buffers; 0 uses the HTTP server's
+	ReadBufferBytes  int
+	WriteBufferBytes int
+	// EventVersion — event envelope of clients that do not ask for one with
+	// ?version=; 1 keeps old frontends working until they are migrated
+	EventVersion int
+	// ReconnectJitterSec — clients closed on shutdown are told to reconnect
+	// after a random delay up to this long
+	ReconnectJitterSec int
+}
+
+// Enabled reports whether Vault should be queried at startup.
+func (v VaultConfig) Enabled() bool {
+	return v.Addr != ""
+}
+
+// Run modes of an instance, see AppConfig.Mode.
+const (
+	ModeAll    = "all"
+	ModeAPI    = "api"
+	ModeWorker = "worker"
+)
+
 type AppConfig struct {
+	// Env — "production" refuses to start with insecure defaults
+	Env string
+	// Mode — "api" serves HTTP/WS and queues exports, "worker" only runs queued exports, "all" does both
+	Mode     string
 	Port     string
 	Postgres PostgresConfig
 	Redis    RedisConfig
 	// Local export storage directory (where generated files will be written)
 	ExportDir string
+	// TemplatesDir — directory of admin-uploaded XLSX templates, kept apart from ExportDir
+	TemplatesDir string
+	// ColumnMasks — columns hidden from tokens per ability, "ability=column,column;..."
+	ColumnMasks string
 	// Public URL prefix where files will be served (e.g. /files)
 	FilesPublicPrefix string
+	// FilesAllowedExtensions — extensions /files serves (".xlsx"); other files are not found
+	FilesAllowedExtensions []string
+	// FilesURLSigningKey — HMAC key of expiring download links of exports; "" — links do not expire
+	FilesURLSigningKey string
+	// FilesURLTTLHours — lifetime of signed links unless an export asks for another one
+	FilesURLTTLHours int
 	// ExternalURL — optional absolute URL used when generating file urls (e.g. https://example.com:8060)
-	ExternalURL  string
-	ExportPrefix string
+	ExternalURL string
+	// BasePath — path a reverse proxy serves the app under (e.g. /export), prepended to generated urls
+	// and stripped from requests; "" — the root
+	BasePath string
+	// ForwardedURLs — without ExternalURL, make file urls in API responses absolute with the scheme and
+	// host of the request, taken from X-Forwarded-Proto/X-Forwarded-Host behind a proxy
+	ForwardedURLs bool
+	// TrustedProxies — addresses and CIDR ranges of reverse proxies whose X-Forwarded-* headers are
+	// honored; forwarded headers of other peers are ignored
+	TrustedProxies []string
+	ExportPrefix   string
+	// Telephony — S3-compatible storage holding call recordings; presigning is off when Bucket is empty
+	Telephony S3Config
+	Archive   ArchiveConfig
+	// Tenants maps tenant id to its Postgres schema; empty means single-tenant mode
+	Tenants map[string]string
+	// TenantHeader — request header naming the tenant whose database holds the
+	// credentials; it may only repeat the tenant they are bound to
+	TenantHeader string
+	ExportRetry  RetryConfig
+	// ExportWorkers — max exports generated at once; 0 means unlimited
+	ExportWorkers int
+	// ExportLanes — export type -> slots of its own, outside the shared pool
+	ExportLanes map[string]int
+	// ExportProgressIntervalSec — least time between progress reports of a generating export; 0 — no limit
+	ExportProgressIntervalSec int
+	// ExportStallTimeoutMin — an export without progress for this long is marked failed; 0 disables the watchdog
+	ExportStallTimeoutMin int
+	// ExportQueue — exports run from a job queue in Redis consumed by all instances instead of on the one that started them
+	ExportQueue bool
+	// ExportQueueLeaseSec — a claimed export whose instance stopped renewing it for this long is queued again
+	ExportQueueLeaseSec int
+	// ExportQueueMaxAttempts — claims of an export before a lost one is marked failed
+	ExportQueueMaxAttempts int
+	// DebugAddr — listen address of the pprof/diagnostics server (e.g. 127.0.0.1:6060); empty disables it
+	DebugAddr string
+	// DebugToken — optional bearer token required by the diagnostics server
+	DebugToken string
+	// SentryDSN enables error reporting of failed exports and panics; empty disables it
+	SentryDSN         string
+	SentryEnvironment string
+	// RateLimit — export starts allowed per minute (0 disables) and burst, per client IP and per user
+	RateLimit RateLimitConfig
+	TLS       TLSConfig
+	WebSocket WebSocketConfig
+	// FileRetentionHours — generated files older than this are deleted
+	FileRetentionHours int
+	// FileRetentionUndownloadedHours — retention of files nobody has downloaded yet; used when longer than FileRetentionHours
+	FileRetentionUndownloadedHours int
+	FileCleanupIntervalHours       int
+	// Janitor* — schedules of the maintenance tasks besides file cleanup; 0 disables a task
+	JanitorStalledJobsIntervalSec  int
+	JanitorStaleExportsIntervalMin int
+	JanitorExportIndexIntervalMin  int
+	JanitorTempFilesIntervalMin    int
+	JanitorOutboxIntervalSec       int
+	JanitorDiskIntervalSec         int
+	JanitorQueueIntervalSec        int
+	// JanitorTempFileAgeMin — unfinished ".tmp" writes older than this are removed
+	JanitorTempFileAgeMin int
+	// JanitorLeaderTTLSec — lease of the instance elected to run the tasks over
+	// shared state; a dead leader is replaced within it. 0 — every instance runs them
+	JanitorLeaderTTLSec int
+	// StorageQuotaUserMB / StorageQuotaTenantMB — disk space a user / tenant may hold before new exports are rejected; 0 disables
+	StorageQuotaUserMB   int
+	StorageQuotaTenantMB int
+	// DiskMinFreeMB — below this much free space in ExportDir new exports are refused; 0 disables the watchdog
+	DiskMinFreeMB int
+	// DiskLowRetentionHours — retention of downloaded files while the disk is low
+	DiskLowRetentionHours int
+	// StorageDedup stores identical files once, linked to a blob named by their SHA-256
+	StorageDedup bool
+	// StorageCompression — "gzip" or "zstd" to compress stored CSV/NDJSON files, "" — off
+	StorageCompression string
+	StorageFailover    StorageFailoverConfig
+	Vault              VaultConfig
+	// AutoMigrate applies pending service migrations on startup
+	AutoMigrate bool
+	// FilenameTemplates — file name template per export type (debts, users, actions, payments)
+	FilenameTemplates map[string]string
+	// CRMDebtURLTemplate makes debt numbers and ids in exports link to the CRM
+	// debt page, e.g. https://crm.example.com/debts/{debt_id}; empty disables links
+	CRMDebtURLTemplate string
+	// SFTPProfiles — delivery targets listed in SFTP_PROFILES
+	SFTPProfiles []SFTPProfileConfig
+	// ClickHouseProfiles and BigQueryProfiles — warehouse delivery targets
+	// listed in CLICKHOUSE_PROFILES and BIGQUERY_PROFILES
+	ClickHouseProfiles []ClickHouseProfileConfig
+	BigQueryProfiles   []BigQueryProfileConfig
+	// KafkaProfiles — topics listed in KAFKA_PROFILES
+	KafkaProfiles []KafkaProfileConfig
+	// APIKeys — service-to-service keys listed in API_KEYS
+	APIKeys []APIKeyConfig
+	// AuthDebug logs every auth decision (token id, user, outcome) with secrets
+	// fingerprinted; reloadable
+	AuthDebug bool
+	// TokenUsageFlushSec — how often last_used_at, IP and user agent of the
+	// tokens used are written; 0 disables tracking
+	TokenUsageFlushSec int
+	// SessionAuth lets the same-origin Laravel SPA authenticate by its session
+	// cookie; sessions are read from the Laravel cache store in Redis
+	SessionAuth bool
+	// SessionCookie — name of the Laravel session cookie
+	SessionCookie string
+	// LaravelAppKey — APP_KEY of the Laravel app, decrypts its cookies
+	LaravelAppKey string
+	// SessionKeyPrefix — prefix of session keys in Redis; empty means ExportPrefix
+	SessionKeyPrefix string
+	// SessionTenantKey — session attribute holding the tenant of the user
+	SessionTenantKey string
+	// CORSAllowedOrigins — origins (scheme://host[:port]) whose pages may call
+	// the API with credentials; empty allows any origin, with credentials only
+	// while SessionAuth is off
+	CORSAllowedOrigins []string
+	// TelegramBotToken enables Telegram notifications of finished exports; empty disables them
+	TelegramBotToken string
+	TelegramAPIURL   string
+	// SlackNotifications lets users set a Slack incoming webhook for finished exports
+	SlackNotifications bool
+	SMTP               SMTPConfig
+
+	// DefaultsUsed lists settings that fell back to their default value
+	DefaultsUsed []string
+}
+
+// parseExtensions parses "xlsx, .zip" into lower-case extensions with a leading dot.
+func parseExtensions(s string) []string {
+	var out []string
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			out = append(out, "."+ext)
+		}
+	}
+	return out
+}
+
+// parseList splits a comma-separated list, dropping empty items.
+func parseList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
 
-func getenv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// normalizeBasePath turns "export/", "/export" and "/export/" into "/export"
+// and "/" into "".
+func normalizeBasePath(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), "/")
+	if s == "" {
+		return ""
 	}
-	return def
+	return "/" + s
 }
 
-func mustAtoi(s string) int {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		log.Fatalf("invalid int value %q: %v", s, err)
+// parseTenants parses "id:schema,id2:schema2"; a bare "id" uses the id as schema name.
+func parseTenants(s string) map[string]string {
+	out := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, schema, _ := strings.Cut(item, ":")
+		id = strings.TrimSpace(id)
+		schema = strings.TrimSpace(schema)
+		if schema == "" {
+			schema = id
+		}
+		out[id] = schema
 	}
-	return i
+	return out
 }
 
-func mustBool(s string) bool {
-	b, err := strconv.ParseBool(s)
-	if err != nil {
-		log.Fatalf("invalid bool value %q: %v", s, err)
+// laneTypes are the export types that can be given a lane.
+var laneTypes = []string{"debts", "debts_aging", "users", "actions", "actions_daily", "payments"}
+
+// parseLanes parses "payments:2,debts:3" (export type:slots).
+func parseLanes(l *loader, key string) map[string]int {
+	out := map[string]int{}
+	for _, item := range strings.Split(l.str(key, ""), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		exportType, slots, _ := strings.Cut(item, ":")
+		exportType = strings.TrimSpace(exportType)
+		n, err := strconv.Atoi(strings.TrimSpace(slots))
+		switch {
+		case !slices.Contains(laneTypes, exportType):
+			l.errorf("%s: unknown export type %q, expected one of %s", key, exportType, strings.Join(laneTypes, ", "))
+		case err != nil || n < 1:
+			l.errorf("%s: %q needs a positive number of slots", key, exportType)
+		default:
+			out[exportType] = n
+		}
 	}
-	return b
+	return out
 }
 
-func Load() AppConfig {
-	return AppConfig{
-		Port: getenv("APP_PORT", "8010"),
+// loadSFTPProfiles reads the profiles listed in SFTP_PROFILES ("bank,agency");
+// a profile "bank" is configured with SFTP_BANK_HOST, SFTP_BANK_USER and so on.
+func loadSFTPProfiles(l *loader) []SFTPProfileConfig {
+	var out []SFTPProfileConfig
+	for _, name := range strings.Split(l.str("SFTP_PROFILES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := sftpPrefix(name)
+		out = append(out, SFTPProfileConfig{
+			Name:           name,
+			Host:           l.str(prefix+"HOST", ""),
+			Port:           l.int(prefix+"PORT", 22),
+			User:           l.str(prefix+"USER", ""),
+			Password:       l.str(prefix+"PASSWORD", ""),
+			KeyFile:        l.str(prefix+"KEY_FILE", ""),
+			KnownHostsFile: l.str(prefix+"KNOWN_HOSTS_FILE", ""),
+			Dir:            l.str(prefix+"DIR", ""),
+			TimeoutSec:     l.int(prefix+"TIMEOUT_SEC", 30),
+		})
+	}
+	return out
+}
+
+// loadClickHouseProfiles reads the profiles listed in CLICKHOUSE_PROFILES; a
+// profile "bi" is configured with CLICKHOUSE_BI_URL, CLICKHOUSE_BI_TABLE and so on.
+func loadClickHouseProfiles(l *loader) []ClickHouseProfileConfig {
+	var out []ClickHouseProfileConfig
+	for _, name := range strings.Split(l.str("CLICKHOUSE_PROFILES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := profilePrefix("CLICKHOUSE_", name)
+		out = append(out, ClickHouseProfileConfig{
+			Name:       name,
+			URL:        l.str(prefix+"URL", ""),
+			User:       l.str(prefix+"USER", ""),
+			Password:   l.str(prefix+"PASSWORD", ""),
+			Database:   l.str(prefix+"DATABASE", ""),
+			Table:      l.str(prefix+"TABLE", ""),
+			BatchSize:  l.int(prefix+"BATCH_SIZE", 1000),
+			TimeoutSec: l.int(prefix+"TIMEOUT_SEC", 60),
+		})
+	}
+	return out
+}
+
+// loadBigQueryProfiles reads the profiles listed in BIGQUERY_PROFILES; a
+// profile "bi" is configured with BIGQUERY_BI_PROJECT, BIGQUERY_BI_DATASET and so on.
+func loadBigQueryProfiles(l *loader) []BigQueryProfileConfig {
+	var out []BigQueryProfileConfig
+	for _, name := range strings.Split(l.str("BIGQUERY_PROFILES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := profilePrefix("BIGQUERY_", name)
+		out = append(out, BigQueryProfileConfig{
+			Name:            name,
+			Project:         l.str(prefix+"PROJECT", ""),
+			Dataset:         l.str(prefix+"DATASET", ""),
+			Table:           l.str(prefix+"TABLE", ""),
+			CredentialsFile: l.str(prefix+"CREDENTIALS_FILE", ""),
+			BatchSize:       l.int(prefix+"BATCH_SIZE", 1000),
+			TimeoutSec:      l.int(prefix+"TIMEOUT_SEC", 60),
+		})
+	}
+	return out
+}
+
+// loadKafkaProfiles reads the profiles listed in KAFKA_PROFILES; a profile
+// "events" is configured with KAFKA_EVENTS_BROKERS, KAFKA_EVENTS_TOPIC and so on.
+func loadKafkaProfiles(l *loader) []KafkaProfileConfig {
+	var out []KafkaProfileConfig
+	for _, name := range strings.Split(l.str("KAFKA_PROFILES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := profilePrefix("KAFKA_", name)
+		out = append(out, KafkaProfileConfig{
+			Name:          name,
+			Brokers:       parseList(l.str(prefix+"BROKERS", "")),
+			User:          l.str(prefix+"USER", ""),
+			Password:      l.str(prefix+"PASSWORD", ""),
+			SASLMechanism: l.str(prefix+"SASL_MECHANISM", "PLAIN"),
+			TLS:           l.bool(prefix+"TLS", false),
+			Topic:         l.str(prefix+"TOPIC", ""),
+			Mode:          l.str(prefix+"MODE", "row"),
+			ChunkRows:     l.int(prefix+"CHUNK_ROWS", 1000),
+			KeyField:      l.str(prefix+"KEY_FIELD", ""),
+			TimeoutSec:    l.int(prefix+"TIMEOUT_SEC", 60),
+		})
+	}
+	return out
+}
+
+// loadAPIKeys reads the keys listed in API_KEYS ("billing,reports"); a key
+// "billing" is configured with API_KEY_BILLING_HASH, _USER_ID and _ABILITIES.
+func loadAPIKeys(l *loader) []APIKeyConfig {
+	var out []APIKeyConfig
+	for _, name := range strings.Split(l.str("API_KEYS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := apiKeyPrefix(name)
+		var abilities []string
+		for _, a := range strings.Split(l.str(prefix+"ABILITIES", ""), ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				abilities = append(abilities, a)
+			}
+		}
+		out = append(out, APIKeyConfig{
+			Name:      name,
+			Hash:      strings.ToLower(strings.TrimSpace(l.str(prefix+"HASH", ""))),
+			UserID:    int64(l.int(prefix+"USER_ID", 0)),
+			Abilities: abilities,
+		})
+	}
+	return out
+}
+
+func apiKeyPrefix(name string) string {
+	return "API_KEY_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+func sftpPrefix(profile string) string {
+	return profilePrefix("SFTP_", profile)
+}
+
+func profilePrefix(kind, profile string) string {
+	return kind + strings.ToUpper(strings.ReplaceAll(profile, "-", "_")) + "_"
+}
+
+// Load reads the configuration from env and the optional CONFIG_FILE (env wins)
+// and validates it; the returned error lists all problems at once.
+func Load() (AppConfig, error) {
+	l := newLoader(os.Getenv("CONFIG_FILE"))
+
+	cfg := AppConfig{
+		Env:  l.str("APP_ENV", "development"),
+		Mode: strings.ToLower(l.str("APP_MODE", ModeAll)),
+		Port: l.str("APP_PORT", "8010"),
 		Postgres: PostgresConfig{
-			Host:     getenv("PG_HOST", "127.0.0.1"),
-			Port:     mustAtoi(getenv("PG_PORT", "5432")),
-			User:     getenv("PG_USER", "root"),
-			Password: getenv("PG_PASSWORD", "hello-world"),
-			DBName:   getenv("PG_DB", "debtster"),
-			SSLMode:  getenv("PG_SSLMODE", "disable"),
+			Host:        l.str("PG_HOST", "127.0.0.1"),
+			Port:        l.int("PG_PORT", 5432),
+			User:        l.str("PG_USER", "root"),
+			Password:    l.str("PG_PASSWORD", "hello-world"),
+			DBName:      l.str("PG_DB", "debtster"),
+			SSLMode:     l.str("PG_SSLMODE", "disable"),
+			QueryLog:    l.bool("PG_QUERY_LOG", false),
+			SlowQueryMs: l.int("PG_SLOW_QUERY_MS", 0),
 		},
 		Redis: RedisConfig{
-			Addr:        getenv("REDIS_ADDR", "127.0.0.1:6379"),
-			Password:    getenv("REDIS_PASSWORD", "hello-world"),
-			DB:          mustAtoi(getenv("REDIS_DB", "0")),
-			MaxRetries:  mustAtoi(getenv("REDIS_MAX_RETRIES", "5")),
-			DialTimeout: mustAtoi(getenv("REDIS_DIAL_TIMEOUT", "10")),
-			Timeout:     mustAtoi(getenv("REDIS_TIMEOUT", "5")),
-			Prefix:      getenv("REDIS_PREFIX", "debtster_database"),
+			Addr:        l.str("REDIS_ADDR", "127.0.0.1:6379"),
+			Password:    l.str("REDIS_PASSWORD", "hello-world"),
+			DB:          l.int("REDIS_DB", 0),
+			MaxRetries:  l.int("REDIS_MAX_RETRIES", 5),
+			DialTimeout: l.int("REDIS_DIAL_TIMEOUT", 10),
+			Timeout:     l.int("REDIS_TIMEOUT", 5),
+			Prefix:      l.str("REDIS_PREFIX", "debtster_database"),
+		},
+		ExportDir:              l.str("EXPORT_DIR", "./exports"),
+		TemplatesDir:           l.str("EXPORT_TEMPLATES_DIR", "./templates"),
+		ColumnMasks:            l.str("EXPORT_COLUMN_MASKS", ""),
+		FilesPublicPrefix:      l.str("EXPORT_PUBLIC_PREFIX", "/files"),
+		FilesAllowedExtensions: parseExtensions(l.str("FILES_ALLOWED_EXTENSIONS", "xlsx,zip,csv,parquet")),
+		FilesURLSigningKey:     l.str("FILES_URL_SIGNING_KEY", ""),
+		FilesURLTTLHours:       l.int("FILES_URL_TTL_HOURS", 48),
+		ExternalURL:            strings.TrimSuffix(strings.TrimSpace(l.str("EXTERNAL_URL", "")), "/"),
+		BasePath:               normalizeBasePath(l.str("BASE_PATH", "")),
+		ForwardedURLs:          l.bool("EXTERNAL_URL_FROM_REQUEST", false),
+		TrustedProxies:         parseList(l.str("TRUSTED_PROXIES", "")),
+		ExportPrefix:           l.str("EXPORT_CACHE_PREFIX", "pkb_database_cache"),
+		Telephony: S3Config{
+			Endpoint:   l.str("TELEPHONY_S3_ENDPOINT", ""),
+			AccessKey:  l.str("TELEPHONY_S3_ACCESS_KEY", ""),
+			SecretKey:  l.str("TELEPHONY_S3_SECRET_KEY", ""),
+			Bucket:     l.str("TELEPHONY_S3_BUCKET", ""),
+			Region:     l.str("TELEPHONY_S3_REGION", ""),
+			UseSSL:     l.bool("TELEPHONY_S3_USE_SSL", true),
+			PresignTTL: l.int("TELEPHONY_PRESIGN_TTL_HOURS", 48),
+		},
+		Archive: ArchiveConfig{
+			S3: S3Config{
+				Endpoint:  l.str("ARCHIVE_S3_ENDPOINT", ""),
+				AccessKey: l.str("ARCHIVE_S3_ACCESS_KEY", ""),
+				SecretKey: l.str("ARCHIVE_S3_SECRET_KEY", ""),
+				Bucket:    l.str("ARCHIVE_S3_BUCKET", ""),
+				Region:    l.str("ARCHIVE_S3_REGION", ""),
+				UseSSL:    l.bool("ARCHIVE_S3_USE_SSL", true),
+			},
+			Prefix:       l.str("ARCHIVE_S3_PREFIX", "exports"),
+			StorageClass: l.str("ARCHIVE_STORAGE_CLASS", "GLACIER"),
+			RestoreDays:  l.int("ARCHIVE_RESTORE_DAYS", 7),
+		},
+		Tenants:      parseTenants(l.str("TENANTS", "")),
+		TenantHeader: l.str("TENANT_HEADER", "X-Tenant"),
+		ExportRetry: RetryConfig{
+			Attempts:    l.int("EXPORT_RETRY_ATTEMPTS", 3),
+			BaseDelayMs: l.int("EXPORT_RETRY_BASE_DELAY_MS", 500),
+			MaxDelayMs:  l.int("EXPORT_RETRY_MAX_DELAY_MS", 10000),
 		},
-		ExportDir:         getenv("EXPORT_DIR", "./exports"),
-		FilesPublicPrefix: getenv("EXPORT_PUBLIC_PREFIX", "/files"),
-		ExternalURL:       getenv("EXTERNAL_URL", ""),
-		ExportPrefix:      getenv("EXPORT_CACHE_PREFIX", "pkb_database_cache"),
+		ExportWorkers:             l.int("EXPORT_WORKERS", 4),
+		ExportLanes:               parseLanes(l, "EXPORT_LANES"),
+		ExportProgressIntervalSec: l.int("EXPORT_PROGRESS_INTERVAL_SEC", 1),
+		ExportStallTimeoutMin:     l.int("EXPORT_STALL_TIMEOUT_MIN", 10),
+		ExportQueue:               l.bool("EXPORT_QUEUE", false),
+		ExportQueueLeaseSec:       l.int("EXPORT_QUEUE_LEASE_SEC", 60),
+		ExportQueueMaxAttempts:    l.int("EXPORT_QUEUE_MAX_ATTEMPTS", 3),
+		DebugAddr:                 l.str("DEBUG_ADDR", ""),
+		DebugToken:                l.str("DEBUG_TOKEN", ""),
+		SentryDSN:                 l.str("SENTRY_DSN", ""),
+		SentryEnvironment:         l.str("SENTRY_ENVIRONMENT", "production"),
+		RateLimit: RateLimitConfig{
+			IPPerMinute:   l.int("RATE_LIMIT_IP_PER_MIN", 30),
+			IPBurst:       l.int("RATE_LIMIT_IP_BURST", 10),
+			UserPerMinute: l.int("RATE_LIMIT_USER_PER_MIN", 10),
+			UserBurst:     l.int("RATE_LIMIT_USER_BURST", 5),
+		},
+		WebSocket: WebSocketConfig{
+			PingPeriodSec:      l.int("WS_PING_PERIOD_SEC", 54),
+			PongWaitSec:        l.int("WS_PONG_WAIT_SEC", 60),
+			WriteWaitSec:       l.int("WS_WRITE_WAIT_SEC", 10),
+			SendBuffer:         l.int("WS_SEND_BUFFER", 256),
+			BroadcastBuffer:    l.int("WS_BROADCAST_BUFFER", 256),
+			ReadBufferBytes:    l.int("WS_READ_BUFFER_BYTES", 0),
+			WriteBufferBytes:   l.int("WS_WRITE_BUFFER_BYTES", 0),
+			EventVersion:       l.int("WS_EVENT_VERSION", 1),
+			ReconnectJitterSec: l.int("WS_RECONNECT_JITTER_SEC", 10),
+		},
+		FileRetentionHours:             l.int("FILES_RETENTION_HOURS", 12),
+		FileRetentionUndownloadedHours: l.int("FILES_RETENTION_UNDOWNLOADED_HOURS", 48),
+		FileCleanupIntervalHours:       l.int("FILES_CLEANUP_INTERVAL_HOURS", 6),
+		JanitorStalledJobsIntervalSec:  l.int("JANITOR_STALLED_JOBS_INTERVAL_SEC", 60),
+		JanitorStaleExportsIntervalMin: l.int("JANITOR_STALE_EXPORTS_INTERVAL_MIN", 5),
+		JanitorExportIndexIntervalMin:  l.int("JANITOR_EXPORT_INDEX_INTERVAL_MIN", 10),
+		JanitorTempFilesIntervalMin:    l.int("JANITOR_TEMP_FILES_INTERVAL_MIN", 60),
+		JanitorOutboxIntervalSec:       l.int("JANITOR_OUTBOX_INTERVAL_SEC", 10),
+		JanitorDiskIntervalSec:         l.int("JANITOR_DISK_INTERVAL_SEC", 60),
+		JanitorQueueIntervalSec:        l.int("JANITOR_QUEUE_INTERVAL_SEC", 15),
+		JanitorTempFileAgeMin:          l.int("JANITOR_TEMP_FILE_AGE_MIN", 60),
+		JanitorLeaderTTLSec:            l.int("JANITOR_LEADER_TTL_SEC", 30),
+		StorageQuotaUserMB:             l.int("STORAGE_QUOTA_USER_MB", 0),
+		StorageQuotaTenantMB:           l.int("STORAGE_QUOTA_TENANT_MB", 0),
+		DiskMinFreeMB:                  l.int("DISK_MIN_FREE_MB", 1024),
+		DiskLowRetentionHours:          l.int("DISK_LOW_RETENTION_HOURS", 1),
+		StorageDedup:                   l.bool("STORAGE_DEDUP", false),
+		StorageCompression:             strings.ToLower(l.str("STORAGE_COMPRESSION", "")),
+		StorageFailover: StorageFailoverConfig{
+			FallbackDir:      l.str("EXPORT_FALLBACK_DIR", ""),
+			Threshold:        l.int("STORAGE_FAILOVER_THRESHOLD", 3),
+			CooldownSec:      l.int("STORAGE_FAILOVER_COOLDOWN_SEC", 60),
+			ProbeIntervalSec: l.int("JANITOR_STORAGE_PROBE_INTERVAL_SEC", 30),
+		},
+		AutoMigrate: l.bool("AUTO_MIGRATE", false),
+		FilenameTemplates: map[string]string{
+			"debts":    l.str("EXPORT_FILENAME_TEMPLATE_DEBTS", "{type}_{timestamp}"),
+			"users":    l.str("EXPORT_FILENAME_TEMPLATE_USERS", "{type}_{timestamp}"),
+			"actions":  l.str("EXPORT_FILENAME_TEMPLATE_ACTIONS", "{type}_{timestamp}"),
+			"payments": l.str("EXPORT_FILENAME_TEMPLATE_PAYMENTS", "{type}_{timestamp}"),
+		},
+		CRMDebtURLTemplate: l.str("CRM_DEBT_URL_TEMPLATE", ""),
+		SFTPProfiles:       loadSFTPProfiles(l),
+		ClickHouseProfiles: loadClickHouseProfiles(l),
+		BigQueryProfiles:   loadBigQueryProfiles(l),
+		KafkaProfiles:      loadKafkaProfiles(l),
+		APIKeys:            loadAPIKeys(l),
+		AuthDebug:          l.bool("AUTH_DEBUG", false),
+		TokenUsageFlushSec: l.int("TOKEN_USAGE_FLUSH_SEC", 30),
+		SessionAuth:        l.bool("SESSION_AUTH", false),
+		SessionCookie:      l.str("SESSION_COOKIE", "laravel_session"),
+		LaravelAppKey:      l.str("LARAVEL_APP_KEY", ""),
+		SessionKeyPrefix:   l.str("SESSION_KEY_PREFIX", ""),
+		SessionTenantKey:   l.str("SESSION_TENANT_KEY", "tenant_id"),
+		CORSAllowedOrigins: parseList(l.str("CORS_ALLOWED_ORIGINS", "")),
+		TelegramBotToken:   l.str("TELEGRAM_BOT_TOKEN", ""),
+		TelegramAPIURL:     l.str("TELEGRAM_API_URL", "https://api.telegram.org"),
+		SlackNotifications: l.bool("SLACK_NOTIFICATIONS", false),
+		SMTP: SMTPConfig{
+			Addr:     l.str("SMTP_ADDR", ""),
+			From:     l.str("SMTP_FROM", ""),
+			User:     l.str("SMTP_USER", ""),
+			Password: l.str("SMTP_PASSWORD", ""),
+		},
+		Vault: VaultConfig{
+			Addr:             l.str("VAULT_ADDR", ""),
+			Token:            l.str("VAULT_TOKEN", ""),
+			Mount:            l.str("VAULT_KV_MOUNT", "secret"),
+			PostgresPath:     l.str("VAULT_POSTGRES_PATH", ""),
+			RedisPath:        l.str("VAULT_REDIS_PATH", ""),
+			TelephonyS3Path:  l.str("VAULT_TELEPHONY_S3_PATH", ""),
+			RenewIntervalMin: l.int("VAULT_RENEW_INTERVAL_MIN", 60),
+		},
+		TLS: TLSConfig{
+			CertFile:     l.str("TLS_CERT_FILE", ""),
+			KeyFile:      l.str("TLS_KEY_FILE", ""),
+			ClientCAFile: l.str("TLS_CLIENT_CA_FILE", ""),
+			ClientAuth:   l.str("TLS_CLIENT_AUTH", "optional"),
+			RedirectAddr: l.str("TLS_REDIRECT_ADDR", ""),
+		},
+	}
+	validate(&cfg, l)
+
+	cfg.DefaultsUsed = l.defaults
+	if unknown := l.unknownFileKeys(); len(unknown) > 0 {
+		l.errorf("CONFIG_FILE: unknown keys %s", strings.Join(unknown, ", "))
+	}
+
+	if len(l.errs) > 0 {
+		return cfg, &ValidationError{Problems: l.errs}
+	}
+	return cfg, nil
+}
+
+// insecureDefaults are settings that must be set explicitly in production.
+var insecureDefaults = []string{"PG_USER", "PG_PASSWORD", "REDIS_PASSWORD"}
+
+func validate(cfg *AppConfig, l *loader) {
+	if _, err := strconv.Atoi(cfg.Port); err != nil {
+		l.errorf("APP_PORT: invalid port %q", cfg.Port)
+	}
+	if cfg.Postgres.Port <= 0 || cfg.Postgres.Port > 65535 {
+		l.errorf("PG_PORT: invalid port %d", cfg.Postgres.Port)
+	}
+	if cfg.Postgres.SlowQueryMs < 0 {
+		l.errorf("PG_SLOW_QUERY_MS: must not be negative")
+	}
+	if cfg.ExportRetry.Attempts < 1 {
+		l.errorf("EXPORT_RETRY_ATTEMPTS: must be at least 1")
+	}
+	if cfg.ExportWorkers < 0 {
+		l.errorf("EXPORT_WORKERS: must not be negative")
+	}
+	if cfg.ExportProgressIntervalSec < 0 {
+		l.errorf("EXPORT_PROGRESS_INTERVAL_SEC: must not be negative")
+	}
+	if cfg.ExportStallTimeoutMin < 0 {
+		l.errorf("EXPORT_STALL_TIMEOUT_MIN: must not be negative")
+	}
+	switch cfg.Mode {
+	case ModeAll:
+	case ModeAPI, ModeWorker:
+		if !cfg.ExportQueue {
+			l.errorf("APP_MODE=%s: needs EXPORT_QUEUE=true, api instances hand exports to workers through the queue", cfg.Mode)
+		}
+	default:
+		l.errorf("APP_MODE: unknown mode %q (want api, worker or all)", cfg.Mode)
+	}
+	if cfg.ExportQueue {
+		if cfg.ExportQueueLeaseSec < 3 {
+			l.errorf("EXPORT_QUEUE_LEASE_SEC: must be at least 3")
+		}
+		if cfg.ExportQueueMaxAttempts < 1 {
+			l.errorf("EXPORT_QUEUE_MAX_ATTEMPTS: must be at least 1")
+		}
+	}
+	if cfg.FileRetentionHours < 1 {
+		l.errorf("FILES_RETENTION_HOURS: must be at least 1")
+	}
+	if cfg.FileRetentionUndownloadedHours < 0 {
+		l.errorf("FILES_RETENTION_UNDOWNLOADED_HOURS: must not be negative")
+	}
+	if cfg.FileCleanupIntervalHours < 1 {
+		l.errorf("FILES_CLEANUP_INTERVAL_HOURS: must be at least 1")
+	}
+	if cfg.JanitorStalledJobsIntervalSec < 0 || cfg.JanitorStaleExportsIntervalMin < 0 ||
+		cfg.JanitorExportIndexIntervalMin < 0 || cfg.JanitorTempFilesIntervalMin < 0 ||
+		cfg.JanitorOutboxIntervalSec < 0 || cfg.JanitorDiskIntervalSec < 0 ||
+		cfg.JanitorQueueIntervalSec < 0 {
+		l.errorf("JANITOR_*_INTERVAL_*: must not be negative")
+	}
+	if cfg.JanitorTempFileAgeMin < 1 {
+		l.errorf("JANITOR_TEMP_FILE_AGE_MIN: must be at least 1")
+	}
+	if cfg.JanitorLeaderTTLSec != 0 && cfg.JanitorLeaderTTLSec < 3 {
+		l.errorf("JANITOR_LEADER_TTL_SEC: must be 0 or at least 3")
+	}
+	if cfg.StorageQuotaUserMB < 0 || cfg.StorageQuotaTenantMB < 0 {
+		l.errorf("STORAGE_QUOTA_USER_MB and STORAGE_QUOTA_TENANT_MB must not be negative")
+	}
+	if cfg.DiskMinFreeMB < 0 {
+		l.errorf("DISK_MIN_FREE_MB: must not be negative")
+	}
+	if cfg.DiskLowRetentionHours < 0 {
+		l.errorf("DISK_LOW_RETENTION_HOURS: must not be negative")
+	}
+	if fo := cfg.StorageFailover; fo.FallbackDir != "" {
+		if fo.Threshold < 1 {
+			l.errorf("STORAGE_FAILOVER_THRESHOLD: must be at least 1")
+		}
+		if fo.CooldownSec < 1 {
+			l.errorf("STORAGE_FAILOVER_COOLDOWN_SEC: must be at least 1")
+		}
+		if fo.ProbeIntervalSec < 0 {
+			l.errorf("JANITOR_STORAGE_PROBE_INTERVAL_SEC: must not be negative")
+		}
+		if filepath.Clean(fo.FallbackDir) == filepath.Clean(cfg.ExportDir) {
+			l.errorf("EXPORT_FALLBACK_DIR: must differ from EXPORT_DIR")
+		}
+	}
+	if cfg.ExternalURL != "" {
+		u, err := url.Parse(cfg.ExternalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			l.errorf("EXTERNAL_URL: want an absolute http(s) url like https://example.com:8060, got %q", cfg.ExternalURL)
+		}
+	}
+	if cfg.BasePath != "" && (path.Clean(cfg.BasePath) != cfg.BasePath || strings.ContainsAny(cfg.BasePath, "?#%\\ ")) {
+		l.errorf("BASE_PATH: want a clean url path like /export, got %q", cfg.BasePath)
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				l.errorf("TRUSTED_PROXIES: want addresses or CIDR ranges like 10.0.0.0/8, got %q", proxy)
+			}
+		}
+	}
+	for _, origin := range cfg.CORSAllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			l.errorf("CORS_ALLOWED_ORIGINS: want origins like https://crm.example.com, got %q", origin)
+		}
+	}
+	if cfg.FilesURLTTLHours < 1 || cfg.FilesURLTTLHours > 7*24 {
+		l.errorf("FILES_URL_TTL_HOURS: must be from 1 to 168")
+	}
+	switch cfg.StorageCompression {
+	case "", "gzip", "zstd":
+	default:
+		l.errorf("STORAGE_COMPRESSION: must be gzip, zstd or empty, got %q", cfg.StorageCompression)
+	}
+	ws := cfg.WebSocket
+	if ws.PingPeriodSec < 1 || ws.PongWaitSec < 1 || ws.WriteWaitSec < 1 {
+		l.errorf("WS_PING_PERIOD_SEC, WS_PONG_WAIT_SEC and WS_WRITE_WAIT_SEC must be at least 1")
+	} else if ws.PingPeriodSec >= ws.PongWaitSec {
+		l.errorf("WS_PING_PERIOD_SEC: must be shorter than WS_PONG_WAIT_SEC (%d)", ws.PongWaitSec)
+	}
+	if ws.SendBuffer < 1 || ws.BroadcastBuffer < 1 {
+		l.errorf("WS_SEND_BUFFER and WS_BROADCAST_BUFFER must be at least 1")
+	}
+	if ws.EventVersion != 1 && ws.EventVersion != 2 {
+		l.errorf("WS_EVENT_VERSION: want 1 or 2, got %d", ws.EventVersion)
+	}
+	if ws.ReconnectJitterSec < 1 {
+		l.errorf("WS_RECONNECT_JITTER_SEC: must be at least 1")
+	}
+	if ws.ReadBufferBytes < 0 || ws.WriteBufferBytes < 0 {
+		l.errorf("WS_READ_BUFFER_BYTES and WS_WRITE_BUFFER_BYTES must not be negative")
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		l.errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLS.ClientAuth != "optional" && cfg.TLS.ClientAuth != "require" {
+		l.errorf("TLS_CLIENT_AUTH: want optional or require, got %q", cfg.TLS.ClientAuth)
+	}
+	if cfg.Telephony.Bucket != "" && cfg.Telephony.Endpoint == "" {
+		l.errorf("TELEPHONY_S3_ENDPOINT: required when TELEPHONY_S3_BUCKET is set")
+	}
+	if cfg.Archive.S3.Bucket != "" && cfg.Archive.S3.Endpoint == "" {
+		l.errorf("ARCHIVE_S3_ENDPOINT: required when ARCHIVE_S3_BUCKET is set")
+	}
+	if cfg.Archive.RestoreDays < 1 {
+		l.errorf("ARCHIVE_RESTORE_DAYS: must be at least 1")
+	}
+
+	for _, p := range cfg.SFTPProfiles {
+		prefix := sftpPrefix(p.Name)
+		if p.Host == "" || p.User == "" {
+			l.errorf("%sHOST and %sUSER: required for sftp profile %q", prefix, prefix, p.Name)
+		}
+		if p.Password == "" && p.KeyFile == "" {
+			l.errorf("%sPASSWORD or %sKEY_FILE: required for sftp profile %q", prefix, prefix, p.Name)
+		}
+		if p.KnownHostsFile == "" {
+			l.errorf("%sKNOWN_HOSTS_FILE: required for sftp profile %q", prefix, p.Name)
+		}
+		if p.Port <= 0 || p.Port > 65535 {
+			l.errorf("%sPORT: invalid port %d", prefix, p.Port)
+		}
+		if p.TimeoutSec < 1 {
+			l.errorf("%sTIMEOUT_SEC: must be at least 1", prefix)
+		}
+	}
+
+	for _, p := range cfg.ClickHouseProfiles {
+		prefix := profilePrefix("CLICKHOUSE_", p.Name)
+		if p.URL == "" || p.Table == "" {
+			l.errorf("%sURL and %sTABLE: required for clickhouse profile %q", prefix, prefix, p.Name)
+		}
+		if p.BatchSize < 1 {
+			l.errorf("%sBATCH_SIZE: must be at least 1", prefix)
+		}
+		if p.TimeoutSec < 1 {
+			l.errorf("%sTIMEOUT_SEC: must be at least 1", prefix)
+		}
+	}
+	for _, p := range cfg.BigQueryProfiles {
+		prefix := profilePrefix("BIGQUERY_", p.Name)
+		if p.Project == "" || p.Dataset == "" || p.Table == "" {
+			l.errorf("%sPROJECT, %sDATASET and %sTABLE: required for bigquery profile %q", prefix, prefix, prefix, p.Name)
+		}
+		if p.CredentialsFile == "" {
+			l.errorf("%sCREDENTIALS_FILE: required for bigquery profile %q", prefix, p.Name)
+		}
+		if p.BatchSize < 1 {
+			l.errorf("%sBATCH_SIZE: must be at least 1", prefix)
+		}
+		if p.TimeoutSec < 1 {
+			l.errorf("%sTIMEOUT_SEC: must be at least 1", prefix)
+		}
+	}
+	for _, p := range cfg.KafkaProfiles {
+		prefix := profilePrefix("KAFKA_", p.Name)
+		if len(p.Brokers) == 0 || p.Topic == "" {
+			l.errorf("%sBROKERS and %sTOPIC: required for kafka profile %q", prefix, prefix, p.Name)
+		}
+		switch p.SASLMechanism {
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		default:
+			l.errorf("%sSASL_MECHANISM: must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got %q", prefix, p.SASLMechanism)
+		}
+		if p.Mode != "row" && p.Mode != "ndjson" {
+			l.errorf("%sMODE: must be row or ndjson, got %q", prefix, p.Mode)
+		}
+		if p.ChunkRows < 1 {
+			l.errorf("%sCHUNK_ROWS: must be at least 1", prefix)
+		}
+		if p.TimeoutSec < 1 {
+			l.errorf("%sTIMEOUT_SEC: must be at least 1", prefix)
+		}
+	}
+
+	hashes := map[string]bool{}
+	for _, k := range cfg.APIKeys {
+		prefix := apiKeyPrefix(k.Name)
+		if raw, err := hex.DecodeString(k.Hash); err != nil || len(raw) != sha256.Size {
+			l.errorf("%sHASH: want the hex SHA-256 of the key for api key %q", prefix, k.Name)
+		} else if hashes[k.Hash] {
+			l.errorf("%sHASH: api key %q reuses the key of another one", prefix, k.Name)
+		}
+		hashes[k.Hash] = true
+		if k.UserID == 0 {
+			l.errorf("%sUSER_ID: required for api key %q", prefix, k.Name)
+		}
+	}
+
+	if cfg.TokenUsageFlushSec < 0 {
+		l.errorf("TOKEN_USAGE_FLUSH_SEC: must not be negative")
+	}
+	if cfg.SessionAuth && cfg.LaravelAppKey == "" {
+		l.errorf("LARAVEL_APP_KEY: required when SESSION_AUTH is enabled")
+	}
+
+	if cfg.SMTP.Addr != "" {
+		if _, _, err := net.SplitHostPort(cfg.SMTP.Addr); err != nil {
+			l.errorf("SMTP_ADDR: want host:port, got %q", cfg.SMTP.Addr)
+		}
+		if cfg.SMTP.From == "" {
+			l.errorf("SMTP_FROM: required when SMTP_ADDR is set")
+		}
+	}
+
+	if cfg.Vault.Enabled() && cfg.Vault.Token == "" {
+		l.errorf("VAULT_TOKEN: required when VAULT_ADDR is set")
+	}
+
+	if cfg.Env != "production" {
+		return
+	}
+	// credentials coming from Vault are checked after they are fetched
+	fromVault := map[string]bool{
+		"PG_USER":        cfg.Vault.Enabled() && cfg.Vault.PostgresPath != "",
+		"PG_PASSWORD":    cfg.Vault.Enabled() && cfg.Vault.PostgresPath != "",
+		"REDIS_PASSWORD": cfg.Vault.Enabled() && cfg.Vault.RedisPath != "",
+	}
+	for _, key := range insecureDefaults {
+		if l.isDefault(key) && !fromVault[key] {
+			l.errorf("%s: must be set explicitly in production", key)
+		}
+	}
+	if (cfg.Postgres.Password == "hello-world" && !fromVault["PG_PASSWORD"]) ||
+		(cfg.Redis.Password == "hello-world" && !fromVault["REDIS_PASSWORD"]) {
+		l.errorf("PG_PASSWORD/REDIS_PASSWORD: the sample password is not allowed in production")
+	}
+	if cfg.DebugAddr != "" && cfg.DebugToken == "" {
+		l.errorf("DEBUG_TOKEN: required in production when DEBUG_ADDR is set")
 	}
 }
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadProblems runs Load and returns the problems it reports.
+func loadProblems(t *testing.T) []string {
+	t.Helper()
+	_, err := Load()
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load: %v, want a *ValidationError", err)
+	}
+	return verr.Problems
+}
+
+// hasProblem reports whether one of problems starts with prefix.
+func hasProblem(problems []string, prefix string) bool {
+	return slices.ContainsFunc(problems, func(p string) bool { return strings.HasPrefix(p, prefix) })
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	const hash = "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08" // sha256("test")
+	t.Setenv("API_KEYS", "billing, cron-reports")
+	t.Setenv("API_KEY_BILLING_HASH", hash)
+	t.Setenv("API_KEY_BILLING_USER_ID", "-1")
+	t.Setenv("API_KEY_BILLING_ABILITIES", "tenant:acme, export:admin,")
+	t.Setenv("API_KEY_CRON_REPORTS_HASH", strings.Repeat("ab", 32))
+	t.Setenv("API_KEY_CRON_REPORTS_USER_ID", "-2")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []APIKeyConfig{
+		{Name: "billing", Hash: strings.ToLower(hash), UserID: -1, Abilities: []string{"tenant:acme", "export:admin"}},
+		{Name: "cron-reports", Hash: strings.Repeat("ab", 32), UserID: -2},
+	}
+	if len(cfg.APIKeys) != len(want) {
+		t.Fatalf("APIKeys = %+v", cfg.APIKeys)
+	}
+	for i, k := range cfg.APIKeys {
+		if k.Name != want[i].Name || k.Hash != want[i].Hash || k.UserID != want[i].UserID || !slices.Equal(k.Abilities, want[i].Abilities) {
+			t.Errorf("APIKeys[%d] = %+v, want %+v", i, k, want[i])
+		}
+	}
+}
+
+func TestLoadAPIKeysRejectsMalformedHashes(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "missing", hash: ""},
+		{name: "plain key", hash: "billing-secret"},
+		{name: "not hex", hash: strings.Repeat("zz", 32)},
+		{name: "sha1", hash: "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"},
+		{name: "odd length", hash: strings.Repeat("a", 63)},
+		{name: "too long", hash: strings.Repeat("a", 66)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_KEYS", "billing")
+			t.Setenv("API_KEY_BILLING_HASH", tt.hash)
+			t.Setenv("API_KEY_BILLING_USER_ID", "-1")
+			if problems := loadProblems(t); !hasProblem(problems, "API_KEY_BILLING_HASH:") {
+				t.Errorf("problems = %q, want API_KEY_BILLING_HASH", problems)
+			}
+		})
+	}
+
+	// один ключ на два имени и ключ без пользователя
+	t.Setenv("API_KEYS", "billing,reports")
+	t.Setenv("API_KEY_BILLING_HASH", strings.Repeat("ab", 32))
+	t.Setenv("API_KEY_BILLING_USER_ID", "-1")
+	t.Setenv("API_KEY_REPORTS_HASH", strings.Repeat("AB", 32))
+	problems := loadProblems(t)
+	if !hasProblem(problems, "API_KEY_REPORTS_HASH: api key \"reports\" reuses") || !hasProblem(problems, "API_KEY_REPORTS_USER_ID:") {
+		t.Errorf("problems = %q", problems)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("APP_PORT", "http")
+	t.Setenv("PG_PORT", "five")
+	t.Setenv("REDIS_DB", "-")
+	t.Setenv("PG_QUERY_LOG", "maybe")
+	t.Setenv("EXPORT_RETRY_ATTEMPTS", "0")
+	t.Setenv("APP_MODE", "cron")
+	t.Setenv("VAULT_ADDR", "https://vault.internal")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://crm.example.com, crm.example.com/app")
+
+	// все ошибки сразу, а не только первая
+	problems := loadProblems(t)
+	for _, want := range []string{
+		`APP_PORT: invalid port "http"`,
+		`PG_PORT: invalid int value "five"`,
+		`REDIS_DB: invalid int value "-"`,
+		`PG_QUERY_LOG: invalid bool value "maybe"`,
+		"EXPORT_RETRY_ATTEMPTS: must be at least 1",
+		`APP_MODE: unknown mode "cron"`,
+		"VAULT_TOKEN: required when VAULT_ADDR is set",
+		`CORS_ALLOWED_ORIGINS: want origins like https://crm.example.com, got "crm.example.com/app"`,
+	} {
+		if !hasProblem(problems, want) {
+			t.Errorf("problems = %q, want %q", problems, want)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "config.yaml", "PG_HOST: db.internal\npg_port: 6432\nREDIS_ADDR: redis.internal:6379\n"},
+		{"json", "config.json", `{"PG_HOST": "db.internal", "pg_port": 6432, "REDIS_ADDR": "redis.internal:6379"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CONFIG_FILE", path)
+			// env важнее файла
+			t.Setenv("REDIS_ADDR", "redis.env:6380")
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Postgres.Host != "db.internal" || cfg.Postgres.Port != 6432 {
+				t.Errorf("postgres = %s:%d, want the file values", cfg.Postgres.Host, cfg.Postgres.Port)
+			}
+			if cfg.Redis.Addr != "redis.env:6380" {
+				t.Errorf("REDIS_ADDR = %q, want the env value", cfg.Redis.Addr)
+			}
+			if slices.Contains(cfg.DefaultsUsed, "PG_HOST") || slices.Contains(cfg.DefaultsUsed, "PG_PORT") {
+				t.Errorf("DefaultsUsed = %q lists file settings", cfg.DefaultsUsed)
+			}
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("PG_HOTS: db\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("CONFIG_FILE", path)
+		if problems := loadProblems(t); !hasProblem(problems, "CONFIG_FILE: unknown keys PG_HOTS") {
+			t.Errorf("problems = %q", problems)
+		}
+	})
+}
+
+func TestLoadProductionRefusesInsecureDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{
+				"PG_USER: must be set explicitly in production",
+				"PG_PASSWORD: must be set explicitly in production",
+				"REDIS_PASSWORD: must be set explicitly in production",
+				"PG_PASSWORD/REDIS_PASSWORD: the sample password is not allowed",
+			},
+		},
+		{
+			name: "sample password",
+			env:  map[string]string{"PG_USER": "export", "PG_PASSWORD": "hello-world", "REDIS_PASSWORD": "s3cret"},
+			want: []string{"PG_PASSWORD/REDIS_PASSWORD: the sample password is not allowed"},
+		},
+		{
+			name: "sample redis password",
+			env:  map[string]string{"PG_USER": "export", "PG_PASSWORD": "s3cret", "REDIS_PASSWORD": "hello-world"},
+			want: []string{"PG_PASSWORD/REDIS_PASSWORD: the sample password is not allowed"},
+		},
+		{
+			name: "explicit",
+			env:  map[string]string{"PG_USER": "export", "PG_PASSWORD": "s3cret", "REDIS_PASSWORD": "s3cret"},
+		},
+		{
+			// пароли придут из Vault и проверяются после загрузки
+			name: "vault",
+			env: map[string]string{
+				"VAULT_ADDR": "https://vault.internal", "VAULT_TOKEN": "t",
+				"VAULT_POSTGRES_PATH": "debtster/pg", "VAULT_REDIS_PATH": "debtster/redis",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_ENV", "production")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			problems := loadProblems(t)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("problems = %q, want %q", problems, tt.want)
+			}
+			for _, want := range tt.want {
+				if !hasProblem(problems, want) {
+					t.Errorf("problems = %q, want %q", problems, want)
+				}
+			}
+		})
+	}
+
+	// вне production те же значения допустимы
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("PG_PASSWORD", "hello-world")
+	if problems := loadProblems(t); len(problems) > 0 {
+		t.Errorf("development: problems = %q", problems)
+	}
+}
+
+func TestLoadKafkaProfiles(t *testing.T) {
+	t.Setenv("KAFKA_PROFILES", "events,audit")
+	t.Setenv("KAFKA_EVENTS_BROKERS", "kafka-1:9092, kafka-2:9092")
+	t.Setenv("KAFKA_EVENTS_TOPIC", "debtster.export.rows")
+	t.Setenv("KAFKA_EVENTS_SASL_MECHANISM", "SCRAM-SHA-512")
+	t.Setenv("KAFKA_EVENTS_TLS", "true")
+	t.Setenv("KAFKA_AUDIT_TOPIC", "audit")
+	t.Setenv("KAFKA_AUDIT_SASL_MECHANISM", "GSSAPI")
+
+	// профиль без брокеров или с неизвестным механизмом SASL не запускается
+	problems := loadProblems(t)
+	for _, want := range []string{
+		`KAFKA_AUDIT_BROKERS and KAFKA_AUDIT_TOPIC: required for kafka profile "audit"`,
+		`KAFKA_AUDIT_SASL_MECHANISM: must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, got "GSSAPI"`,
+	} {
+		if !hasProblem(problems, want) {
+			t.Errorf("problems = %q, want %q", problems, want)
+		}
+	}
+	if hasProblem(problems, "KAFKA_EVENTS_") {
+		t.Errorf("problems = %q for a valid profile", problems)
+	}
+
+	t.Setenv("KAFKA_PROFILES", "events")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.KafkaProfiles[0]
+	if !slices.Equal(p.Brokers, []string{"kafka-1:9092", "kafka-2:9092"}) || !p.TLS || p.SASLMechanism != "SCRAM-SHA-512" || p.Mode != "row" {
+		t.Fatalf("profile = %+v", p)
+	}
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loader resolves settings from env, then the optional config file, then
+// defaults, collecting every problem instead of stopping at the first one.
+type loader struct {
+	file     map[string]string
+	errs     []string
+	defaults []string
+	lookups  map[string]bool
+}
+
+func newLoader(path string) *loader {
+	l := &loader{file: map[string]string{}, lookups: map[string]bool{}}
+	if path == "" {
+		return l
+	}
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		l.errorf("CONFIG_FILE: %v", err)
+		return l
+	}
+	l.file = file
+	return l
+}
+
+// readConfigFile reads a flat YAML or JSON object whose keys are the env names
+// (PG_HOST: db, ...); the format is picked by extension.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file %q (want .yaml, .yml or .json)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	out := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case nil:
+			out[strings.ToUpper(k)] = ""
+		case map[string]any, []any:
+			return nil, fmt.Errorf("key %s: nested values are not supported", k)
+		default:
+			out[strings.ToUpper(k)] = fmt.Sprint(v)
+		}
+	}
+	return out, nil
+}
+
+func (l *loader) errorf(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Sprintf(format, args...))
+}
+
+func (l *loader) lookup(key string) (string, bool) {
+	l.lookups[key] = true
+	if v := os.Getenv(key); v != "" {
+		return v, true
+	}
+	if v, ok := l.file[key]; ok && v != "" {
+		return v, true
+	}
+	return "", false
+}
+
+func (l *loader) str(key, def string) string {
+	if v, ok := l.lookup(key); ok {
+		return v
+	}
+	if def != "" {
+		l.defaults = append(l.defaults, key)
+	}
+	return def
+}
+
+func (l *loader) int(key string, def int) int {
+	v, ok := l.lookup(key)
+	if !ok {
+		l.defaults = append(l.defaults, key)
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		l.errorf("%s: invalid int value %q", key, v)
+		return def
+	}
+	return i
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	v, ok := l.lookup(key)
+	if !ok {
+		l.defaults = append(l.defaults, key)
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.errorf("%s: invalid bool value %q", key, v)
+		return def
+	}
+	return b
+}
+
+// isDefault reports whether key fell back to its default value.
+func (l *loader) isDefault(key string) bool {
+	for _, k := range l.defaults {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownFileKeys lists config file keys that no setting reads, usually typos.
+func (l *loader) unknownFileKeys() []string {
+	var out []string
+	for k := range l.file {
+		if !l.lookups[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ValidationError lists every invalid or insecure setting found by Load.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
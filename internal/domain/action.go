@@ -11,8 +11,11 @@ type Action struct {
 
 	NextContact *time.Time
 
-	Type    string
-	Comment string
+	Type string
+	// TypeName is the display name of Type from the action type dictionary,
+	// filled in by the exporter when the column is selected.
+	TypeName *string
+	Comment  string
 
 	Payload []byte
 
@@ -37,4 +40,15 @@ type Action struct {
 
 	PayloadDatePromisedPayment   *string
 	PayloadAmountPromisedPayment *float64
+	PayloadRecordingURL          *string
+}
+
+// ActionDailyCount is the number of actions of one day within one group (a user
+// or an action type) of the daily actions report.
+type ActionDailyCount struct {
+	Day   time.Time
+	Group string
+	// Label is the display name of the group when the database has one.
+	Label string
+	Count int64
 }
@@ -3,6 +3,7 @@ package domain
 import "time"
 
 type Debt struct {
+	ID     string
 	Number string
 
 	StartDate *time.Time
@@ -50,4 +51,33 @@ type Debt struct {
 	DebtorIIN        *string
 
 	CounterpartyName *string
+
+	// payment history, loaded only when requested (DebtsFilter.PaymentHistory)
+	LastPaymentDate *time.Time
+	TotalPaid       float64
+	PaidThisMonth   float64
+
+	// latest court case and enforcement proceeding, loaded only when
+	// requested (DebtsFilter.LegalCase)
+	CourtCaseNumber      *string
+	CourtName            *string
+	CourtStage           *string
+	EnforcementNumber    *string
+	EnforcementAgent     *string
+	EnforcementStartedAt *time.Time
+
+	// contacts of the debtor, loaded only when requested (DebtsFilter.Contacts)
+	DebtorPhones    []string
+	DebtorAddresses []string
+}
+
+// DebtAgingCount sums the debts of one group (a counterparty or a department)
+// that fall into one overdue bucket of the aging report.
+type DebtAgingCount struct {
+	Group string
+	// Bucket is the index of the days-overdue bucket, -1 when the debt has no
+	// overdue date.
+	Bucket int
+	Count  int64
+	Amount float64
 }
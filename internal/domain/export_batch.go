@@ -0,0 +1,11 @@
+package domain
+
+// ExportBatchResult is the outcome of one export of a batch. Error is set when
+// the export failed or could not be started.
+type ExportBatchResult struct {
+	ExportID string `json:"export_id,omitempty"`
+	Type     string `json:"type"`
+	FileURL  string `json:"file_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
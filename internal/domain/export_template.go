@@ -0,0 +1,17 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTemplateNotFound is returned for a template name that is not stored.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ExportTemplate describes a stored XLSX template exports can be rendered into.
+type ExportTemplate struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	UploadedBy int64     `json:"uploaded_by"`
+}
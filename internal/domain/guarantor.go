@@ -0,0 +1,13 @@
+package domain
+
+type Guarantor struct {
+	DebtNumber string
+
+	Type *string
+
+	LastName   *string
+	FirstName  *string
+	MiddleName *string
+	IIN        *string
+	Phone      *string
+}
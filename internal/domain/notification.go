@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// Export events a user can subscribe to.
+const (
+	NotifyEventProgress = "progress"
+	NotifyEventComplete = "complete"
+	NotifyEventFailed   = "failed"
+)
+
+// Channels export events are sent over.
+const (
+	NotifyChannelWebSocket = "websocket"
+	NotifyChannelEmail     = "email"
+	NotifyChannelMessenger = "messenger"
+)
+
+var (
+	NotifyEvents   = []string{NotifyEventProgress, NotifyEventComplete, NotifyEventFailed}
+	NotifyChannels = []string{NotifyChannelWebSocket, NotifyChannelEmail, NotifyChannelMessenger}
+
+	// DefaultNotifyChannels are used until the user picks channels; email is opt-in.
+	DefaultNotifyChannels = []string{NotifyChannelWebSocket, NotifyChannelMessenger}
+)
+
+// NotificationSettings are the export events a user receives and the channels
+// they come over. Empty messenger values disable that messenger; nil Events or
+// Channels mean the defaults.
+type NotificationSettings struct {
+	UserID          int64
+	TelegramChatID  string
+	SlackWebhookURL string
+	Events          []string
+	Channels        []string
+	UpdatedAt       *time.Time
+}
+
+// EffectiveEvents returns the subscribed events with the default applied.
+func (s NotificationSettings) EffectiveEvents() []string {
+	if s.Events == nil {
+		return NotifyEvents
+	}
+	return s.Events
+}
+
+// EffectiveChannels returns the chosen channels with the default applied.
+func (s NotificationSettings) EffectiveChannels() []string {
+	if s.Channels == nil {
+		return DefaultNotifyChannels
+	}
+	return s.Channels
+}
+
+// Wants reports whether event should be sent to the user over channel.
+func (s NotificationSettings) Wants(channel, event string) bool {
+	return slices.Contains(s.EffectiveChannels(), channel) && slices.Contains(s.EffectiveEvents(), event)
+}
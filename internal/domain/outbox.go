@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEntry is an event that must reach its target at least once. DedupKey
+// makes publishing the same event twice a no-op.
+type OutboxEntry struct {
+	ID        int64
+	DedupKey  string
+	Kind      string
+	Payload   json.RawMessage
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+}
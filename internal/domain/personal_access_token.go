@@ -9,3 +9,12 @@ type PersonalAccessToken struct {
 	Abilities string
 	ExpiresAt *time.Time
 }
+
+// TokenUsage is the last use of a token seen by this service.
+type TokenUsage struct {
+	TokenID   int64
+	UserID    int64
+	UsedAt    time.Time
+	IP        string
+	UserAgent string
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// Upload is a file stored through POST /files/upload.
+type Upload struct {
+	// File is the storage name, as in /files/<file>
+	File         string `json:"file"`
+	UserID       int64  `json:"user_id"`
+	OriginalName string `json:"original_name"`
+	Size         int64  `json:"size"`
+	ContentType  string `json:"content_type"`
+	// Checksum is the hex SHA-256 of the content
+	Checksum  string    `json:"checksum"`
+	CreatedAt time.Time `json:"created_at"`
+}
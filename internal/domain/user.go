@@ -8,4 +8,8 @@ type User struct {
 	Email       *string
 	Phone       *string
 	Departments *string
+
+	AssignedDebtsCount     int64
+	AssignedDebtsAmount    float64
+	ActionsLast30DaysCount int64
 }
@@ -0,0 +1,383 @@
+// Package expr evaluates the computed column expressions of export requests.
+//
+// The language is deliberately small: number and string literals, field
+// references (column keys such as amount_actual_debt or debtor.full_name),
+// + - * / and parentheses. "+" adds numbers and concatenates as soon as one
+// side is a string. There are no functions, loops or access to anything but
+// the fields of the row, so expressions from requests are safe to evaluate.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxLength bounds the source of one expression.
+	MaxLength = 500
+	maxDepth  = 32
+)
+
+var (
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrNotANumber     = errors.New("value is not a number")
+)
+
+// Expr is a parsed expression.
+type Expr struct {
+	root   node
+	fields []string
+}
+
+// Parse compiles src; the error points at the offending position.
+func Parse(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, errors.New("expression is empty")
+	}
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxLength)
+	}
+
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, seen: map[string]bool{}}
+	root, err := p.parseSum(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos+1)
+	}
+	return &Expr{root: root, fields: p.fields}, nil
+}
+
+// Fields returns the field names the expression refers to, in order of first use.
+func (e *Expr) Fields() []string {
+	return e.fields
+}
+
+// Eval computes the expression for one row; field returns the value of a
+// referenced field. The result is a float64 or a string.
+func (e *Expr) Eval(field func(name string) any) (any, error) {
+	return e.root.eval(field)
+}
+
+type node interface {
+	eval(field func(name string) any) (any, error)
+}
+
+type literal struct{ value any }
+
+func (n literal) eval(func(string) any) (any, error) { return n.value, nil }
+
+type fieldRef struct{ name string }
+
+func (n fieldRef) eval(field func(string) any) (any, error) {
+	return normalize(field(n.name)), nil
+}
+
+type negate struct{ operand node }
+
+func (n negate) eval(field func(string) any) (any, error) {
+	v, err := n.operand.eval(field)
+	if err != nil {
+		return nil, err
+	}
+	f, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	return -f, nil
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (n binary) eval(field func(string) any) (any, error) {
+	l, err := n.left.eval(field)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(field)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == '+' {
+		ls, lStr := l.(string)
+		rs, rStr := r.(string)
+		// an empty field next to a number is a missing amount, not text
+		if lStr && ls == "" && !rStr {
+			return r, nil
+		}
+		if rStr && rs == "" && !lStr {
+			return l, nil
+		}
+		if lStr || rStr {
+			if !lStr {
+				ls = format(l)
+			}
+			if !rStr {
+				rs = format(r)
+			}
+			return ls + rs, nil
+		}
+	}
+
+	lf, err := toNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := toNumber(r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case '+':
+		return lf + rf, nil
+	case '-':
+		return lf - rf, nil
+	case '*':
+		return lf * rf, nil
+	default:
+		if rf == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return lf / rf, nil
+	}
+}
+
+// normalize maps field values onto the two types of the language.
+func normalize(v any) any {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64, string:
+		return t
+	case float32:
+		return float64(t)
+	case int:
+		return float64(t)
+	case int32:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// toNumber accepts numbers and numeric strings; an empty string is zero, like
+// an empty amount cell.
+func toNumber(v any) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case string:
+		s := strings.TrimSpace(t)
+		if s == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrNotANumber, t)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%w: %v", ErrNotANumber, t)
+	}
+}
+
+func format(v any) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	// value of number and string literals
+	value any
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case strings.IndexByte("+-*/()", c) >= 0:
+			tokens = append(tokens, token{kind: tokOp, text: string(c), pos: i})
+			i++
+
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			f, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", src[start:i], start+1)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start, value: f})
+
+		case c == '\'' || c == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for ; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				b.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", start+1)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: src[start:i], pos: start, value: b.String()})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	fields []string
+	seen   map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops string) bool {
+	t := p.peek()
+	return t.kind == tokOp && strings.Contains(ops, t.text)
+}
+
+// parseSum: product (("+" | "-") product)*
+func (p *parser) parseSum(depth int) (node, error) {
+	left, err := p.parseProduct(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+-") {
+		op := p.next().text[0]
+		right, err := p.parseProduct(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct: unary (("*" | "/") unary)*
+func (p *parser) parseProduct(depth int) (node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*/") {
+		op := p.next().text[0]
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary: "-" unary | primary
+func (p *parser) parseUnary(depth int) (node, error) {
+	if depth > maxDepth {
+		return nil, errors.New("expression is nested too deeply")
+	}
+	if p.isOp("-") {
+		p.next()
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return negate{operand: operand}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+// parsePrimary: number | string | field | "(" sum ")"
+func (p *parser) parsePrimary(depth int) (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber, tokString:
+		return literal{value: t.value}, nil
+	case tokIdent:
+		if !p.seen[t.text] {
+			p.seen[t.text] = true
+			p.fields = append(p.fields, t.text)
+		}
+		return fieldRef{name: t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			inner, err := p.parseSum(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); closing.kind != tokOp || closing.text != ")" {
+				return nil, fmt.Errorf("expected \")\" at position %d", closing.pos+1)
+			}
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos+1)
+}
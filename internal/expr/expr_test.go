@@ -0,0 +1,94 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	row := map[string]any{
+		"amount_actual_debt": 1500.5,
+		"amount_main_debt":   1000.0,
+		"amount_fine":        0.0,
+		"debtor.iin":         "900101300001",
+		"number":             "D-1",
+		"user_id":            int64(7),
+		"amount_purchased":   "",
+	}
+	field := func(name string) any { return row[name] }
+
+	tests := []struct {
+		src  string
+		want any
+	}{
+		{"amount_actual_debt - amount_main_debt", 500.5},
+		{"2 + 3 * 4", 14.0},
+		{"(2 + 3) * 4", 20.0},
+		{"-amount_main_debt / 4", -250.0},
+		{"user_id * 2", 14.0},
+		// пустая сумма считается нулём
+		{"amount_purchased + 1", 1.0},
+		// строка с любой стороны "+" — конкатенация
+		{`number + " / " + debtor.iin`, "D-1 / 900101300001"},
+		{`'№' + user_id`, "№7"},
+		{`"a\"b"`, `a"b`},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", tt.src, err)
+		}
+		got, err := e.Eval(field)
+		if err != nil {
+			t.Fatalf("%s: eval: %v", tt.src, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+
+	e, _ := Parse("amount_actual_debt / amount_fine")
+	if _, err := e.Eval(field); !errors.Is(err, ErrDivisionByZero) {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+	e, _ = Parse("number * 2")
+	if _, err := e.Eval(field); !errors.Is(err, ErrNotANumber) {
+		t.Fatalf("expected not a number, got %v", err)
+	}
+}
+
+func TestParse_Fields(t *testing.T) {
+	e, err := Parse("a.b + c * a.b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Fields(); !reflect.DeepEqual(got, []string{"a.b", "c"}) {
+		t.Fatalf("unexpected fields %v", got)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"a; b",
+		"'open",
+		"1..2",
+		"os.Exit(1)",
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+
+	deep := ""
+	for range maxDepth + 1 {
+		deep += "("
+	}
+	if _, err := Parse(deep + "1"); err == nil {
+		t.Error("expected nesting error")
+	}
+}
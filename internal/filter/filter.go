@@ -0,0 +1,383 @@
+// Package filter declares the filters of an export type in one place.
+//
+// A Def names a filter as it appears in requests and in the filters map of
+// the export status, gives its Kind (which decides how a request value is
+// validated), the field of the filter struct holding it and the SQL condition
+// it adds to the queries. A Set of them parses requests, builds WHERE clauses
+// and the filters map, so a new filter is a field of the filter struct plus
+// one Def.
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the type of the value of a filter and the type of its field:
+//
+//	String  *string     text id; an integer is kept as its decimal text
+//	ID      *int64      positive database id, as a number or numeric string
+//	Date    *time.Time  YYYY-MM-DD
+//	Bit     *int        0 or 1, bound to SQL as a boolean
+//	Flag    bool        boolean; its condition, if any, takes no value
+//	List    []string    array of non-empty strings, duplicates dropped
+//
+// null and "" mean "no filter" for every kind, as do false and [].
+type Kind int
+
+const (
+	String Kind = iota
+	ID
+	Date
+	Bit
+	Flag
+	List
+)
+
+// MaxListItems bounds the values of a List filter.
+const MaxListItems = 100
+
+const dateLayout = "2006-01-02"
+
+// Def declares one filter of the filter struct F.
+type Def[F any] struct {
+	// Name is the key of the filter in requests and in the filters map.
+	Name string
+	Kind Kind
+	// Field returns the pointer to the field of f holding the value.
+	Field func(f *F) any
+	// SQL is the condition the filter adds, with one "?" for the value.
+	// Empty for filters that change the shape of the query instead, which
+	// the repository reads from the struct itself.
+	SQL string
+	// Ref is the dictionary a String or ID value refers to, such as debt
+	// statuses, so the export status can show its name; empty for none.
+	Ref string
+}
+
+// Set is the filters of an export type, in the order their conditions are
+// added to a query.
+type Set[F any] struct {
+	defs []Def[F]
+}
+
+// NewSet checks that every field has the type of the kind of its filter and
+// that names are unique; a mismatch is a bug in the declaration, so it panics.
+func NewSet[F any](defs ...Def[F]) Set[F] {
+	seen := make(map[string]bool, len(defs))
+	for _, d := range defs {
+		if seen[d.Name] {
+			panic(fmt.Sprintf("filter: duplicate filter %q", d.Name))
+		}
+		seen[d.Name] = true
+
+		var f F
+		ok := false
+		switch d.Field(&f).(type) {
+		case **string:
+			ok = d.Kind == String
+		case **int64:
+			ok = d.Kind == ID
+		case **time.Time:
+			ok = d.Kind == Date
+		case **int:
+			ok = d.Kind == Bit
+		case *bool:
+			ok = d.Kind == Flag
+		case *[]string:
+			ok = d.Kind == List
+		}
+		if !ok {
+			panic(fmt.Sprintf("filter: field of %q does not match its kind", d.Name))
+		}
+		if d.Ref != "" && d.Kind != String && d.Kind != ID {
+			panic(fmt.Sprintf("filter: %q cannot refer to a dictionary", d.Name))
+		}
+	}
+	return Set[F]{defs: defs}
+}
+
+// Error is an invalid filter value of a request.
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Parse reads the filters from the top-level values of a request body; keys
+// that are not filters are left to the caller. Every invalid value is
+// reported, the others are set.
+func (s Set[F]) Parse(values map[string]any) (F, []*Error) {
+	var f F
+	var errs []*Error
+	for _, d := range s.defs {
+		if err := d.parse(d.Field(&f), values[d.Name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return f, errs
+}
+
+func (d Def[F]) parse(field, v any) *Error {
+	invalid := func(format string) *Error {
+		return &Error{Field: d.Name, Message: fmt.Sprintf(format, d.Name)}
+	}
+
+	switch d.Kind {
+	case String:
+		switch t := v.(type) {
+		case nil:
+		case string:
+			if t != "" {
+				*field.(**string) = &t
+			}
+		case float64:
+			// a fractional id would be truncated into another one
+			if t != math.Trunc(t) {
+				return invalid("%s must be string or empty")
+			}
+			s := strconv.FormatInt(int64(t), 10)
+			*field.(**string) = &s
+		default:
+			return invalid("%s must be string or empty")
+		}
+
+	case ID:
+		id, ok := toID(v)
+		if !ok {
+			// there is no row with id 0: it would silently match nothing
+			return invalid("%s must be a positive integer or empty")
+		}
+		*field.(**int64) = id
+
+	case Date:
+		switch t := v.(type) {
+		case nil:
+		case string:
+			if t == "" {
+				break
+			}
+			parsed, err := time.Parse(dateLayout, t)
+			if err != nil {
+				return invalid("%s must be YYYY-MM-DD or empty")
+			}
+			*field.(**time.Time) = &parsed
+		default:
+			return invalid("%s must be YYYY-MM-DD or empty")
+		}
+
+	case Bit:
+		var bit int
+		switch t := v.(type) {
+		case nil:
+			return nil
+		case float64:
+			bit = int(t)
+			if float64(bit) != t {
+				return invalid("%s must be 0, 1 or empty")
+			}
+		case string:
+			if t == "" {
+				return nil
+			}
+			parsed, err := strconv.Atoi(t)
+			if err != nil {
+				return invalid("%s must be 0, 1 or empty")
+			}
+			bit = parsed
+		default:
+			return invalid("%s must be 0, 1 or empty")
+		}
+		// any other number would silently mean 0
+		if bit != 0 && bit != 1 {
+			return invalid("%s must be 0, 1 or empty")
+		}
+		*field.(**int) = &bit
+
+	case Flag:
+		switch t := v.(type) {
+		case nil:
+		case bool:
+			*field.(*bool) = t
+		case float64:
+			*field.(*bool) = t != 0
+		case string:
+			if t == "" {
+				break
+			}
+			b, err := strconv.ParseBool(t)
+			if err != nil {
+				return invalid("%s must be boolean or empty")
+			}
+			*field.(*bool) = b
+		default:
+			return invalid("%s must be boolean or empty")
+		}
+
+	case List:
+		if v == nil {
+			return nil
+		}
+		items, ok := v.([]any)
+		if !ok {
+			return invalid("%s must be an array of non-empty strings or empty")
+		}
+		if len(items) > MaxListItems {
+			return &Error{Field: d.Name, Message: fmt.Sprintf("%s must contain at most %d items", d.Name, MaxListItems)}
+		}
+		var out []string
+		seen := make(map[string]bool, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok || strings.TrimSpace(s) == "" {
+				return invalid("%s must be an array of non-empty strings or empty")
+			}
+			if !seen[s] {
+				seen[s] = true
+				out = append(out, s)
+			}
+		}
+		*field.(*[]string) = out
+	}
+	return nil
+}
+
+// toID accepts a positive integer as a JSON number or a numeric string; ok
+// is false for anything else, nil id for an empty value.
+func toID(v any) (id *int64, ok bool) {
+	var n int64
+	switch t := v.(type) {
+	case nil:
+		return nil, true
+	case float64:
+		if t != math.Trunc(t) {
+			return nil, false
+		}
+		n = int64(t)
+	case string:
+		if t == "" {
+			return nil, true
+		}
+		parsed, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		n = parsed
+	default:
+		return nil, false
+	}
+	if n <= 0 {
+		return nil, false
+	}
+	return &n, true
+}
+
+// Where passes the condition and the value of every filter set in f to and,
+// in the order of the set.
+func (s Set[F]) Where(f F, and func(cond string, args ...any)) {
+	for _, d := range s.defs {
+		if d.SQL == "" {
+			continue
+		}
+		switch v := d.Field(&f).(type) {
+		case **string:
+			if *v != nil {
+				and(d.SQL, **v)
+			}
+		case **int64:
+			if *v != nil {
+				and(d.SQL, **v)
+			}
+		case **time.Time:
+			if *v != nil {
+				and(d.SQL, **v)
+			}
+		case **int:
+			if *v != nil {
+				and(d.SQL, **v == 1)
+			}
+		case *bool:
+			if *v {
+				and(d.SQL)
+			}
+		case *[]string:
+			if len(*v) > 0 {
+				and(d.SQL, *v)
+			}
+		}
+	}
+}
+
+// Map returns the filters of f as they are shown in the export status:
+// single values by name, nil when not set; flags and lists only when set.
+func (s Set[F]) Map(f F) map[string]any {
+	m := make(map[string]any, len(s.defs))
+	for _, d := range s.defs {
+		switch v := d.Field(&f).(type) {
+		case **string:
+			m[d.Name] = nil
+			if *v != nil {
+				m[d.Name] = **v
+			}
+		case **int64:
+			m[d.Name] = nil
+			if *v != nil {
+				m[d.Name] = **v
+			}
+		case **time.Time:
+			m[d.Name] = nil
+			if *v != nil {
+				m[d.Name] = (*v).Format(dateLayout)
+			}
+		case **int:
+			m[d.Name] = nil
+			if *v != nil {
+				m[d.Name] = **v
+			}
+		case *bool:
+			if *v {
+				m[d.Name] = true
+			}
+		case *[]string:
+			if len(*v) > 0 {
+				m[d.Name] = *v
+			}
+		}
+	}
+	return m
+}
+
+// Ref is a value of a filter that refers to a dictionary.
+type Ref struct {
+	Filter string
+	Dict   string
+	// ID is a string or an int64, as the filter holds it.
+	ID any
+}
+
+// Refs returns the values set in f that refer to dictionaries.
+func (s Set[F]) Refs(f F) []Ref {
+	var out []Ref
+	for _, d := range s.defs {
+		if d.Ref == "" {
+			continue
+		}
+		switch v := d.Field(&f).(type) {
+		case **string:
+			if *v != nil {
+				out = append(out, Ref{Filter: d.Name, Dict: d.Ref, ID: **v})
+			}
+		case **int64:
+			if *v != nil {
+				out = append(out, Ref{Filter: d.Name, Dict: d.Ref, ID: **v})
+			}
+		}
+	}
+	return out
+}
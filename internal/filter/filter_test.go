@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testFilter struct {
+	Name   *string
+	ID     *int64
+	From   *time.Time
+	Done   *int
+	Latest bool
+	Types  []string
+}
+
+var testSet = NewSet(
+	Def[testFilter]{Name: "name", Kind: String, Field: func(f *testFilter) any { return &f.Name }, SQL: "t.name = ?"},
+	Def[testFilter]{Name: "id", Kind: ID, Field: func(f *testFilter) any { return &f.ID }, SQL: "t.id = ?", Ref: "things"},
+	Def[testFilter]{Name: "from", Kind: Date, Field: func(f *testFilter) any { return &f.From }, SQL: "t.at >= ?"},
+	Def[testFilter]{Name: "done", Kind: Bit, Field: func(f *testFilter) any { return &f.Done }, SQL: "t.done = ?"},
+	Def[testFilter]{Name: "latest", Kind: Flag, Field: func(f *testFilter) any { return &f.Latest }},
+	Def[testFilter]{Name: "types", Kind: List, Field: func(f *testFilter) any { return &f.Types }, SQL: "t.type = ANY(?)"},
+)
+
+func TestSet_Parse(t *testing.T) {
+	f, errs := testSet.Parse(map[string]any{
+		"name": 42.0, "id": "7", "from": "2024-03-01", "done": 0.0, "latest": true, "types": []any{"a", "b", "a"}, "other": "x",
+	})
+	if len(errs) != 0 {
+		t.Fatalf("errs = %v", errs)
+	}
+	if *f.Name != "42" || *f.ID != 7 || f.From.Format("2006-01-02") != "2024-03-01" || *f.Done != 0 || !f.Latest || !reflect.DeepEqual(f.Types, []string{"a", "b"}) {
+		t.Fatalf("filter = %+v", f)
+	}
+
+	// пустые значения — это отсутствие фильтра
+	f, errs = testSet.Parse(map[string]any{"name": "", "id": nil, "from": "", "done": "", "types": []any{}})
+	if len(errs) != 0 || !reflect.DeepEqual(f, testFilter{}) {
+		t.Fatalf("empty values: %+v %v", f, errs)
+	}
+
+	_, errs = testSet.Parse(map[string]any{
+		"name": true, "id": 0.0, "from": "01.03.2024", "done": 2.0, "latest": "maybe", "types": []any{"a", 1.0},
+	})
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"name", "id", "from", "done", "latest", "types"}) {
+		t.Fatalf("errors of %v", fields)
+	}
+	if errs[1].Message != "id must be a positive integer or empty" {
+		t.Fatalf("message = %q", errs[1].Message)
+	}
+
+	// дробное число не обрезается до другого id
+	f, errs = testSet.Parse(map[string]any{"name": 1.5})
+	if len(errs) != 1 || errs[0].Message != "name must be string or empty" || f.Name != nil {
+		t.Fatalf("fractional name: %+v %v", f, errs)
+	}
+}
+
+func TestSet_WhereAndMap(t *testing.T) {
+	name, id, done := "x", int64(7), 1
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	f := testFilter{Name: &name, ID: &id, From: &from, Done: &done, Latest: true, Types: []string{"a"}}
+
+	var conds []string
+	var args []any
+	testSet.Where(f, func(cond string, a ...any) {
+		conds = append(conds, cond)
+		args = append(args, a...)
+	})
+	// флаг без SQL меняет сам запрос и условий не добавляет
+	if !reflect.DeepEqual(conds, []string{"t.name = ?", "t.id = ?", "t.at >= ?", "t.done = ?", "t.type = ANY(?)"}) {
+		t.Fatalf("conds = %v", conds)
+	}
+	if !reflect.DeepEqual(args, []any{"x", int64(7), from, true, []string{"a"}}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	want := map[string]any{"name": "x", "id": int64(7), "from": "2024-03-01", "done": 1, "latest": true, "types": []string{"a"}}
+	if m := testSet.Map(f); !reflect.DeepEqual(m, want) {
+		t.Fatalf("map = %v", m)
+	}
+	want = map[string]any{"name": nil, "id": nil, "from": nil, "done": nil}
+	if m := testSet.Map(testFilter{}); !reflect.DeepEqual(m, want) {
+		t.Fatalf("empty map = %v", m)
+	}
+}
+
+func TestSet_Refs(t *testing.T) {
+	name, id := "x", int64(7)
+	refs := testSet.Refs(testFilter{Name: &name, ID: &id})
+	if !reflect.DeepEqual(refs, []Ref{{Filter: "id", Dict: "things", ID: int64(7)}}) {
+		t.Fatalf("refs = %+v", refs)
+	}
+	if refs := testSet.Refs(testFilter{Name: &name}); len(refs) != 0 {
+		t.Fatalf("refs of an unset id: %+v", refs)
+	}
+}
+
+func TestNewSet_KindMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic on a field of another kind")
+		}
+	}()
+	NewSet(Def[testFilter]{Name: "id", Kind: String, Field: func(f *testFilter) any { return &f.ID }})
+}
@@ -0,0 +1,210 @@
+// Package janitor runs the periodic maintenance of the service (file retention,
+// export index pruning, stale export expiry, temp-file removal) on independent
+// schedules and keeps counters of every task for diagnostics.
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// disabledPoll is how often a disabled task checks whether it was re-enabled.
+const disabledPoll = time.Minute
+
+// TaskFunc does one pass of a task and returns how many items it removed or fixed.
+type TaskFunc func(ctx context.Context) (int, error)
+
+// TaskStats are the counters of one task.
+type TaskStats struct {
+	Name         string     `json:"name"`
+	Interval     string     `json:"interval"`
+	Runs         int64      `json:"runs"`
+	Errors       int64      `json:"errors"`
+	Processed    int64      `json:"processed"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	// Shared tasks run on the leader instance only; Skipped counts the passes
+	// left to it.
+	Shared  bool  `json:"shared,omitempty"`
+	Skipped int64 `json:"skipped,omitempty"`
+}
+
+// Leader tells whether this instance leads the cluster. Implemented by
+// *clients.LeaderElection.
+type Leader interface {
+	IsLeader() bool
+}
+
+type task struct {
+	name   string
+	run    TaskFunc
+	shared bool
+
+	mu       sync.Mutex
+	interval time.Duration
+	stats    TaskStats
+}
+
+// Janitor schedules maintenance tasks; a task never overlaps with itself and a
+// failing or panicking task does not affect the others.
+type Janitor struct {
+	mu     sync.Mutex
+	tasks  []*task
+	leader Leader
+}
+
+func New() *Janitor {
+	return &Janitor{}
+}
+
+// Add registers a task run every interval; interval <= 0 keeps it disabled
+// until SetInterval enables it. Tasks must be added before Run.
+func (j *Janitor) Add(name string, interval time.Duration, run TaskFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.tasks = append(j.tasks, &task{name: name, run: run, interval: interval})
+}
+
+// AddShared registers a task over state shared by all instances (Redis,
+// Postgres, the export storage): with a leader set it runs on the leader only,
+// so replicas do not do the same pass twice.
+func (j *Janitor) AddShared(name string, interval time.Duration, run TaskFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.tasks = append(j.tasks, &task{name: name, run: run, interval: interval, shared: true})
+}
+
+// SetLeader makes shared tasks run only while leader leads; without it every
+// instance runs them. Must be called before Run.
+func (j *Janitor) SetLeader(leader Leader) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.leader = leader
+}
+
+// SetInterval changes the schedule of a task; it applies after the current wait.
+func (j *Janitor) SetInterval(name string, interval time.Duration) {
+	if t := j.task(name); t != nil {
+		t.mu.Lock()
+		t.interval = interval
+		t.mu.Unlock()
+	}
+}
+
+func (j *Janitor) task(name string) *task {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, t := range j.tasks {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Run runs every task on its schedule until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	j.mu.Lock()
+	tasks := append([]*task(nil), j.tasks...)
+	leader := j.leader
+	j.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range tasks {
+		wg.Go(func() { t.loop(ctx, leader) })
+	}
+	wg.Wait()
+}
+
+// Stats returns the counters of all tasks in registration order.
+func (j *Janitor) Stats() []TaskStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	out := make([]TaskStats, 0, len(j.tasks))
+	for _, t := range j.tasks {
+		t.mu.Lock()
+		st := t.stats
+		st.Name = t.name
+		st.Interval = t.interval.String()
+		st.Shared = t.shared
+		if t.interval <= 0 {
+			st.Interval = "disabled"
+		}
+		t.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+func (t *task) loop(ctx context.Context, leader Leader) {
+	for {
+		t.mu.Lock()
+		interval := t.interval
+		t.mu.Unlock()
+
+		wait := interval
+		if wait <= 0 {
+			wait = disabledPoll
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		switch {
+		case interval <= 0:
+		case t.shared && leader != nil && !leader.IsLeader():
+			t.mu.Lock()
+			t.stats.Skipped++
+			t.mu.Unlock()
+		default:
+			t.runOnce(ctx)
+		}
+	}
+}
+
+func (t *task) runOnce(ctx context.Context) {
+	started := time.Now()
+	n, err := t.safeRun(ctx)
+	elapsed := time.Since(started)
+
+	t.mu.Lock()
+	t.stats.Runs++
+	t.stats.Processed += int64(n)
+	t.stats.LastRun = &started
+	t.stats.LastDuration = elapsed.String()
+	t.stats.LastError = ""
+	if err != nil {
+		t.stats.Errors++
+		t.stats.LastError = err.Error()
+	}
+	t.mu.Unlock()
+
+	switch {
+	case err != nil:
+		log.Printf("[JANITOR] %s failed after %s: %v", t.name, elapsed, err)
+	case n > 0:
+		log.Printf("[JANITOR] %s: %d processed in %s", t.name, n, elapsed)
+	}
+}
+
+func (t *task) safeRun(ctx context.Context) (n int, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return t.run(ctx)
+}
@@ -0,0 +1,122 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJanitor_RunsTasksAndCountsResults(t *testing.T) {
+	j := New()
+
+	var cleaned atomic.Int32
+	j.Add("files", 5*time.Millisecond, func(context.Context) (int, error) {
+		cleaned.Add(1)
+		return 2, nil
+	})
+	j.Add("broken", 5*time.Millisecond, func(context.Context) (int, error) {
+		return 0, errors.New("redis down")
+	})
+	j.Add("panicking", 5*time.Millisecond, func(context.Context) (int, error) {
+		panic("boom")
+	})
+	j.Add("disabled", 0, func(context.Context) (int, error) {
+		t.Error("disabled task must not run")
+		return 0, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for cleaned.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	stats := j.Stats()
+	if len(stats) != 4 {
+		t.Fatalf("expected 4 tasks, got %+v", stats)
+	}
+
+	files := stats[0]
+	if files.Runs < 3 || files.Processed != 2*files.Runs || files.Errors != 0 || files.LastRun == nil {
+		t.Fatalf("unexpected files stats: %+v", files)
+	}
+	if broken := stats[1]; broken.Runs == 0 || broken.Errors != broken.Runs || broken.LastError != "redis down" {
+		t.Fatalf("unexpected broken stats: %+v", broken)
+	}
+	if p := stats[2]; p.Errors == 0 || p.LastError != "panic: boom" {
+		t.Fatalf("panic must be counted as an error: %+v", p)
+	}
+	if d := stats[3]; d.Runs != 0 || d.Interval != "disabled" {
+		t.Fatalf("unexpected disabled stats: %+v", d)
+	}
+}
+
+func TestJanitor_SetInterval(t *testing.T) {
+	j := New()
+	j.Add("files", time.Hour, func(context.Context) (int, error) { return 0, nil })
+	j.SetInterval("files", 0)
+	j.SetInterval("unknown", time.Minute)
+
+	if got := j.Stats()[0].Interval; got != "disabled" {
+		t.Fatalf("expected disabled task, got %s", got)
+	}
+}
+
+type fakeLeader struct{ leads atomic.Bool }
+
+func (l *fakeLeader) IsLeader() bool { return l.leads.Load() }
+
+// Общие задачи выполняет только лидер, локальные — каждый экземпляр.
+func TestJanitor_SharedTasksRunOnLeader(t *testing.T) {
+	j := New()
+	leader := &fakeLeader{}
+	j.SetLeader(leader)
+
+	var shared, local atomic.Int32
+	j.AddShared("outbox", 5*time.Millisecond, func(context.Context) (int, error) {
+		shared.Add(1)
+		return 0, nil
+	})
+	j.Add("temp_files", 5*time.Millisecond, func(context.Context) (int, error) {
+		local.Add(1)
+		return 0, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+
+	wait := func(cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(func() bool { return local.Load() >= 3 })
+	if shared.Load() != 0 {
+		t.Fatalf("shared task ran on a follower %d times", shared.Load())
+	}
+
+	leader.leads.Store(true)
+	wait(func() bool { return shared.Load() > 0 })
+	cancel()
+	<-done
+
+	stats := j.Stats()
+	if !stats[0].Shared || stats[0].Skipped == 0 || stats[0].Runs == 0 || stats[1].Shared {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id          uuid PRIMARY KEY,
+    type        varchar(32)  NOT NULL,
+    user_id     bigint       NOT NULL,
+    state       varchar(16)  NOT NULL DEFAULT 'queued',
+    progress    numeric(5,2) NOT NULL DEFAULT 0,
+    file_url    text,
+    error       text,
+    params      jsonb,
+    attempt     integer      NOT NULL DEFAULT 1,
+    retry_of    uuid REFERENCES export_jobs (id) ON DELETE SET NULL,
+    request_id  varchar(128),
+    created_at  timestamptz  NOT NULL DEFAULT now(),
+    updated_at  timestamptz  NOT NULL DEFAULT now(),
+    finished_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS export_jobs_user_id_created_at_idx ON export_jobs (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS export_jobs_state_idx ON export_jobs (state) WHERE finished_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS export_jobs;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS export_notification_settings (
+    user_id           bigint PRIMARY KEY,
+    telegram_chat_id  varchar(64),
+    slack_webhook_url text,
+    updated_at        timestamptz NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS export_notification_settings;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- NULL keeps the defaults: every event, over websocket and messengers
+ALTER TABLE export_notification_settings
+    ADD COLUMN IF NOT EXISTS events   jsonb,
+    ADD COLUMN IF NOT EXISTS channels jsonb;
+
+-- +goose Down
+ALTER TABLE export_notification_settings
+    DROP COLUMN IF EXISTS events,
+    DROP COLUMN IF EXISTS channels;
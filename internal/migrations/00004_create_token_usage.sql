@@ -0,0 +1,13 @@
+-- +goose Up
+-- client of the last request of each personal access token; last_used_at
+-- itself is kept in personal_access_tokens like Sanctum does
+CREATE TABLE IF NOT EXISTS export_token_usage (
+    token_id     bigint PRIMARY KEY,
+    user_id      bigint NOT NULL,
+    last_used_at timestamptz NOT NULL,
+    ip           varchar(64),
+    user_agent   text
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS export_token_usage;
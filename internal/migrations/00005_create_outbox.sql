@@ -0,0 +1,20 @@
+-- +goose Up
+-- final export statuses and notifications waiting to be delivered at least once
+CREATE TABLE IF NOT EXISTS export_outbox (
+    id              bigserial PRIMARY KEY,
+    dedup_key       varchar(255) NOT NULL UNIQUE,
+    kind            varchar(64) NOT NULL,
+    payload         jsonb NOT NULL,
+    attempts        integer NOT NULL DEFAULT 0,
+    last_error      text,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    delivered_at    timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS export_outbox_pending_idx
+    ON export_outbox (next_attempt_at)
+    WHERE delivered_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS export_outbox;
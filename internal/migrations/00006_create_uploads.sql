@@ -0,0 +1,16 @@
+-- +goose Up
+-- files stored through POST /files/upload; downloads of them are limited to the owner
+CREATE TABLE IF NOT EXISTS export_uploads (
+    file          varchar(512) PRIMARY KEY,
+    user_id       bigint NOT NULL,
+    original_name varchar(255) NOT NULL,
+    size          bigint NOT NULL,
+    content_type  varchar(255) NOT NULL,
+    checksum      char(64) NOT NULL,
+    created_at    timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS export_uploads_user_idx ON export_uploads (user_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS export_uploads;
@@ -0,0 +1,68 @@
+// Package migrations embeds the schema of tables owned by the export service
+// (the rest of the schema belongs to the main application).
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// versionTable is separate from the main application's migration bookkeeping.
+const versionTable = "export_service_migrations"
+
+func newProvider(db *sql.DB) (*goose.Provider, error) {
+	return goose.NewProvider(goose.DialectPostgres, db, files, goose.WithTableName(versionTable))
+}
+
+// Up applies all pending migrations.
+func Up(ctx context.Context, db *sql.DB) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	results, err := p.Up(ctx)
+	for _, r := range results {
+		fmt.Printf("migrate: applied %s (%s)\n", r.Source.Path, r.Duration)
+	}
+	return err
+}
+
+// Down rolls back the latest migration.
+func Down(ctx context.Context, db *sql.DB) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	r, err := p.Down(ctx)
+	if r != nil {
+		fmt.Printf("migrate: rolled back %s\n", r.Source.Path)
+	}
+	return err
+}
+
+// Status prints applied and pending migrations.
+func Status(ctx context.Context, db *sql.DB) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	statuses, err := p.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		applied := "pending"
+		if s.State == goose.StateApplied {
+			applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-40s %s\n", s.Source.Path, applied)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"database/sql"
+	"io/fs"
+	"strings"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	// sql.Open не подключается: провайдеру база нужна только при запуске
+	db, err := sql.Open("pgx", "postgres://localhost:1/none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	p, err := newProvider(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := p.ListSources()
+	if len(sources) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+
+	// версии идут подряд: пропуск означает потерянный или переименованный файл
+	for i, s := range sources {
+		if s.Version != int64(i+1) || s.Type != goose.TypeSQL {
+			t.Fatalf("migration %d is %+v", i+1, s)
+		}
+	}
+}
+
+func TestMigrationsCanBeRolledBack(t *testing.T) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, down, ok := strings.Cut(string(data), "-- +goose Down")
+		if !strings.HasPrefix(up, "-- +goose Up") || strings.Count(string(data), "-- +goose Up") != 1 {
+			t.Errorf("%s: must start with a single -- +goose Up", name)
+		}
+		// `migrate down` обязан откатывать каждую миграцию
+		if !ok || strings.TrimSpace(down) == "" {
+			t.Errorf("%s: no -- +goose Down statements", name)
+		}
+	}
+}
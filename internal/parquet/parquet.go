@@ -0,0 +1,408 @@
+// Package parquet writes flat tables as Apache Parquet files.
+//
+// It covers what exports need and nothing more: one row group, optional
+// columns of the BOOLEAN, INT64, DOUBLE and UTF-8 BYTE_ARRAY types, PLAIN
+// encoding and no compression. The tests read the files back with parquet-go,
+// a reader written apart from this package.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Type is the physical type of a column.
+type Type int32
+
+const (
+	Boolean   Type = 0
+	Int64     Type = 2
+	Double    Type = 5
+	ByteArray Type = 6
+)
+
+func (t Type) String() string {
+	switch t {
+	case Boolean:
+		return "BOOLEAN"
+	case Int64:
+		return "INT64"
+	case Double:
+		return "DOUBLE"
+	case ByteArray:
+		return "BYTE_ARRAY"
+	}
+	return "Type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Column is one column of the schema; every column is optional, nil values
+// are written as nulls.
+type Column struct {
+	Name string
+	Type Type
+}
+
+const magic = "PAR1"
+
+// parquet-format enum values
+const (
+	encodingPlain  = 0
+	encodingRLE    = 3
+	pageTypeData   = 0
+	repetitionOpt  = 1
+	convertedUTF8  = 0
+	codecNone      = 0
+	formatVersion  = 1
+	createdBy      = "debtster-export"
+	thriftStop     = 0
+	thriftI32      = 5
+	thriftI64      = 6
+	thriftBinary   = 8
+	thriftList     = 9
+	thriftStruct   = 12
+	maxShortListSz = 14
+)
+
+// Write writes rows as a Parquet file with the given schema. Values are
+// converted to the type of their column: numbers and numeric strings to
+// INT64 and DOUBLE, anything to text for BYTE_ARRAY; values that do not
+// convert, empty strings included, become nulls.
+func Write(w io.Writer, columns []Column, rows [][]any) error {
+	var file bytes.Buffer
+	file.WriteString(magic)
+
+	chunks := make([]columnChunk, len(columns))
+	for i, col := range columns {
+		page, err := encodePage(col, i, rows)
+		if err != nil {
+			return err
+		}
+		chunks[i] = columnChunk{
+			column: col,
+			offset: int64(file.Len()),
+			size:   int64(len(page)),
+			values: int64(len(rows)),
+		}
+		file.Write(page)
+	}
+
+	footer := encodeFileMetaData(columns, chunks, int64(len(rows)))
+	file.Write(footer)
+	_ = binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.WriteString(magic)
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+type columnChunk struct {
+	column Column
+	offset int64
+	size   int64
+	values int64
+}
+
+// encodePage encodes column idx of rows as a v1 data page with its header.
+func encodePage(col Column, idx int, rows [][]any) ([]byte, error) {
+	defined := make([]bool, len(rows))
+	var values bytes.Buffer
+	var bits []bool
+
+	for r, row := range rows {
+		var v any
+		if idx < len(row) {
+			v = row[idx]
+		}
+		switch col.Type {
+		case Boolean:
+			b, ok := toBool(v)
+			if !ok {
+				continue
+			}
+			bits = append(bits, b)
+		case Int64:
+			n, ok := toInt64(v)
+			if !ok {
+				continue
+			}
+			_ = binary.Write(&values, binary.LittleEndian, n)
+		case Double:
+			f, ok := toFloat64(v)
+			if !ok {
+				continue
+			}
+			_ = binary.Write(&values, binary.LittleEndian, math.Float64bits(f))
+		case ByteArray:
+			s, ok := toString(v)
+			if !ok {
+				continue
+			}
+			_ = binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+			values.WriteString(s)
+		default:
+			return nil, fmt.Errorf("parquet: column %q: unsupported type %s", col.Name, col.Type)
+		}
+		defined[r] = true
+	}
+	if col.Type == Boolean {
+		values.Write(packBits(bits))
+	}
+
+	levels := encodeLevels(defined)
+	var data bytes.Buffer
+	_ = binary.Write(&data, binary.LittleEndian, uint32(len(levels)))
+	data.Write(levels)
+	data.Write(values.Bytes())
+
+	var page thriftWriter
+	page.i32(1, pageTypeData)
+	page.i32(2, int32(data.Len()))
+	page.i32(3, int32(data.Len()))
+	page.beginStruct(5) // DataPageHeader
+	page.i32(1, int32(len(rows)))
+	page.i32(2, encodingPlain)
+	page.i32(3, encodingRLE)
+	page.i32(4, encodingRLE)
+	page.endStruct()
+	page.stop()
+
+	return append(page.buf.Bytes(), data.Bytes()...), nil
+}
+
+// encodeLevels encodes definition levels of bit width 1 as one bit-packed
+// run of the RLE/bit-packing hybrid.
+func encodeLevels(defined []bool) []byte {
+	groups := (len(defined) + 7) / 8
+	var out bytes.Buffer
+	writeUvarint(&out, uint64(groups)<<1|1)
+	packed := packBits(defined)
+	out.Write(packed)
+	// the run covers whole groups of eight values
+	out.Write(make([]byte, groups-len(packed)))
+	return out.Bytes()
+}
+
+// packBits packs bools LSB first, as PLAIN booleans and bit-packed levels are.
+func packBits(bits []bool) []byte {
+	out := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out
+}
+
+func encodeFileMetaData(columns []Column, chunks []columnChunk, rows int64) []byte {
+	var t thriftWriter
+	t.i32(1, formatVersion)
+
+	t.beginList(2, thriftStruct, len(columns)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endElem()
+	for _, col := range columns {
+		t.beginElem()
+		t.i32(1, int32(col.Type))
+		t.i32(3, repetitionOpt)
+		t.binary(4, col.Name)
+		if col.Type == ByteArray {
+			t.i32(6, convertedUTF8)
+		}
+		t.endElem()
+	}
+
+	t.i64(3, rows)
+
+	var total int64
+	for _, c := range chunks {
+		total += c.size
+	}
+	t.beginList(4, thriftStruct, 1)
+	t.beginElem() // RowGroup
+	t.beginList(1, thriftStruct, len(chunks))
+	for _, c := range chunks {
+		t.beginElem() // ColumnChunk
+		t.i64(2, c.offset)
+		t.beginStruct(3) // ColumnMetaData
+		t.i32(1, int32(c.column.Type))
+		t.beginList(2, thriftI32, 2)
+		t.listI32(encodingPlain)
+		t.listI32(encodingRLE)
+		t.beginList(3, thriftBinary, 1)
+		t.listBinary(c.column.Name)
+		t.i32(4, codecNone)
+		t.i64(5, c.values)
+		t.i64(6, c.size)
+		t.i64(7, c.size)
+		t.i64(9, c.offset)
+		t.endStruct()
+		t.endElem()
+	}
+	t.i64(2, total)
+	t.i64(3, rows)
+	t.endElem()
+
+	t.binary(6, createdBy)
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// thriftWriter writes the Thrift compact protocol. Fields of a struct must be
+// written in increasing id order; lists are written element by element right
+// after beginList.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		writeUvarint(&t.buf, zigzag(int64(id)))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	writeUvarint(&t.buf, zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+func (t *thriftWriter) endStruct() { t.endElem() }
+
+func (t *thriftWriter) beginList(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size <= maxShortListSz {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xF0 | elem)
+	writeUvarint(&t.buf, uint64(size))
+}
+
+// beginElem and endElem enclose a struct nested in a struct or a list.
+func (t *thriftWriter) beginElem() {
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) endElem() {
+	t.stop()
+	t.lastID = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) listI32(v int32) { writeUvarint(&t.buf, zigzag(int64(v))) }
+
+func (t *thriftWriter) listBinary(s string) {
+	writeUvarint(&t.buf, uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) stop() { t.buf.WriteByte(thriftStop) }
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+// Convert converts v to the value Write stores in a column of type t: a bool,
+// int64, float64 or string. ok is false for values written as nulls.
+func Convert(t Type, v any) (value any, ok bool) {
+	switch t {
+	case Boolean:
+		return toBool(v)
+	case Int64:
+		return toInt64(v)
+	case Double:
+		return toFloat64(v)
+	case ByteArray:
+		return toString(v)
+	}
+	return nil, false
+}
+
+func toBool(v any) (bool, bool) {
+	switch t := v.(type) {
+	case bool:
+		return t, true
+	case string:
+		b, err := strconv.ParseBool(t)
+		return b, err == nil
+	}
+	return false, false
+}
+
+func toInt64(v any) (int64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return t, true
+	case int:
+		return int64(t), true
+	case float64:
+		if t != math.Trunc(t) || math.Abs(t) > 1<<53 {
+			return 0, false
+		}
+		return int64(t), true
+	case string:
+		n, err := strconv.ParseInt(t, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case int64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v any) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, t != ""
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	default:
+		return fmt.Sprint(t), true
+	}
+}
@@ -0,0 +1,324 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// compact decodes one Thrift compact struct into field id → value: int64 for
+// integers, string for binaries, []any for lists and map[int16]any for structs.
+type compact struct {
+	data []byte
+	pos  int
+}
+
+func (c *compact) uvarint() uint64 {
+	v, n := binary.Uvarint(c.data[c.pos:])
+	c.pos += n
+	return v
+}
+
+func (c *compact) varint() int64 {
+	u := c.uvarint()
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (c *compact) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return c.varint()
+	case thriftBinary:
+		n := int(c.uvarint())
+		s := string(c.data[c.pos : c.pos+n])
+		c.pos += n
+		return s
+	case thriftList:
+		head := c.data[c.pos]
+		c.pos++
+		size, elem := int(head>>4), head&0x0F
+		if size == 15 {
+			size = int(c.uvarint())
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = c.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return c.structure()
+	}
+	panic("unexpected thrift type")
+}
+
+func (c *compact) structure() map[int16]any {
+	fields := map[int16]any{}
+	var last int16
+	for {
+		head := c.data[c.pos]
+		c.pos++
+		if head == thriftStop {
+			return fields
+		}
+		id := last + int16(head>>4)
+		if head>>4 == 0 {
+			id = int16(c.varint())
+		}
+		fields[id] = c.value(head & 0x0F)
+		last = id
+	}
+}
+
+func TestWrite(t *testing.T) {
+	columns := []Column{
+		{Name: "number", Type: ByteArray},
+		{Name: "amount", Type: Double},
+		{Name: "user_id", Type: Int64},
+		{Name: "confirmed", Type: Boolean},
+	}
+	rows := [][]any{
+		{"D-1", 1500.5, float64(7), true},
+		{"", "", "", ""},
+		{"D-3", 10.0, int64(9), false},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if string(file[:4]) != magic || string(file[len(file)-4:]) != magic {
+		t.Fatalf("missing magic: %q", file)
+	}
+
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := &compact{data: file[len(file)-8-size : len(file)-8]}
+	meta := footer.structure()
+	if footer.pos != size {
+		t.Fatalf("footer decoded %d of %d bytes", footer.pos, size)
+	}
+
+	if meta[3] != int64(3) {
+		t.Fatalf("num_rows = %v", meta[3])
+	}
+	schema := meta[2].([]any)
+	if len(schema) != 5 || schema[0].(map[int16]any)[5] != int64(4) {
+		t.Fatalf("unexpected schema: %v", schema)
+	}
+	for i, col := range columns {
+		el := schema[i+1].(map[int16]any)
+		if el[4] != col.Name || el[1] != int64(col.Type) || el[3] != int64(repetitionOpt) {
+			t.Fatalf("schema element %d: %v", i, el)
+		}
+	}
+
+	// страница каждой колонки: заголовок, уровни определения и значения без null
+	chunks := meta[4].([]any)[0].(map[int16]any)[1].([]any)
+	wantValues := [][]byte{
+		append(append(le32(3), "D-1"...), append(le32(3), "D-3"...)...),
+		append(le64(math.Float64bits(1500.5)), le64(math.Float64bits(10))...),
+		append(le64(7), le64(9)...),
+		{0b01},
+	}
+	for i, chunk := range chunks {
+		md := chunk.(map[int16]any)[3].(map[int16]any)
+		offset := int(md[9].(int64))
+		page := &compact{data: file[offset : offset+int(md[7].(int64))]}
+		header := page.structure()
+		if header[5].(map[int16]any)[1] != int64(3) {
+			t.Fatalf("column %d: page header %v", i, header)
+		}
+
+		data := page.data[page.pos:]
+		levelsLen := int(binary.LittleEndian.Uint32(data))
+		// один bit-packed прогон: строки 1 и 3 определены
+		if !reflect.DeepEqual(data[4:4+levelsLen], []byte{0b11, 0b101}) {
+			t.Fatalf("column %d: levels %08b", i, data[4:4+levelsLen])
+		}
+		if values := data[4+levelsLen:]; !bytes.Equal(values, wantValues[i]) {
+			t.Fatalf("column %d: values %v, want %v", i, values, wantValues[i])
+		}
+	}
+}
+
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func le64(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
+
+// readFile is a minimal reader of what Write produces: the schema from the
+// footer and the rows from the PLAIN pages of one row group, nulls as nil.
+// It follows the format spec rather than the writer, so a round trip through
+// it checks the offsets, sizes and levels a real reader relies on.
+func readFile(t *testing.T, file []byte) ([]Column, [][]any) {
+	t.Helper()
+	if len(file) < 12 || string(file[:4]) != magic || string(file[len(file)-4:]) != magic {
+		t.Fatal("not a parquet file")
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := (&compact{data: file[len(file)-8-size : len(file)-8]}).structure()
+
+	schema := meta[2].([]any)
+	var columns []Column
+	for _, el := range schema[1:] {
+		el := el.(map[int16]any)
+		columns = append(columns, Column{Name: el[4].(string), Type: Type(el[1].(int64))})
+	}
+	numRows := int(meta[3].(int64))
+	rows := make([][]any, numRows)
+	for r := range rows {
+		rows[r] = make([]any, len(columns))
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 1 || groups[0].(map[int16]any)[3] != int64(numRows) {
+		t.Fatalf("row groups: %v", groups)
+	}
+	for i, chunk := range groups[0].(map[int16]any)[1].([]any) {
+		md := chunk.(map[int16]any)[3].(map[int16]any)
+		if md[1] != int64(columns[i].Type) || md[3].([]any)[0] != columns[i].Name || md[4] != int64(codecNone) {
+			t.Fatalf("column %d: metadata %v", i, md)
+		}
+		offset, total := int(md[9].(int64)), int(md[7].(int64))
+		page := &compact{data: file[offset : offset+total]}
+		header := page.structure()
+		dph := header[5].(map[int16]any)
+		if header[1] != int64(pageTypeData) || dph[2] != int64(encodingPlain) || dph[3] != int64(encodingRLE) {
+			t.Fatalf("column %d: page header %v", i, header)
+		}
+		data := page.data[page.pos:]
+		if int64(len(data)) != header[3].(int64) {
+			t.Fatalf("column %d: page of %d bytes, header says %v", i, len(data), header[3])
+		}
+
+		levelsLen := int(binary.LittleEndian.Uint32(data))
+		defined := decodeLevels(t, data[4:4+levelsLen], numRows)
+		values := data[4+levelsLen:]
+		var bit int
+		for r := range numRows {
+			if !defined[r] {
+				continue
+			}
+			switch columns[i].Type {
+			case Boolean:
+				rows[r][i] = values[bit/8]&(1<<(bit%8)) != 0
+				bit++
+			case Int64:
+				rows[r][i] = int64(binary.LittleEndian.Uint64(values))
+				values = values[8:]
+			case Double:
+				rows[r][i] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+				values = values[8:]
+			case ByteArray:
+				n := int(binary.LittleEndian.Uint32(values))
+				rows[r][i] = string(values[4 : 4+n])
+				values = values[4+n:]
+			}
+		}
+		if columns[i].Type == Boolean {
+			values = values[(bit+7)/8:]
+		}
+		if len(values) != 0 {
+			t.Fatalf("column %d: %d bytes left after the values", i, len(values))
+		}
+	}
+	return columns, rows
+}
+
+// decodeLevels decodes n definition levels of bit width 1 from the RLE/bit-packing hybrid.
+func decodeLevels(t *testing.T, data []byte, n int) []bool {
+	t.Helper()
+	c := &compact{data: data}
+	var out []bool
+	for c.pos < len(data) {
+		header := c.uvarint()
+		if header&1 == 1 {
+			// bit-packed: header>>1 groups of eight values, one byte each
+			for range header >> 1 {
+				b := data[c.pos]
+				c.pos++
+				for j := range 8 {
+					out = append(out, b&(1<<j) != 0)
+				}
+			}
+			continue
+		}
+		v := data[c.pos] != 0
+		c.pos++
+		for range header >> 1 {
+			out = append(out, v)
+		}
+	}
+	if len(out) < n {
+		t.Fatalf("%d levels for %d rows", len(out), n)
+	}
+	return out[:n]
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	columns := []Column{
+		{Name: "number", Type: ByteArray},
+		{Name: "amount", Type: Double},
+		{Name: "user_id", Type: Int64},
+		{Name: "confirmed", Type: Boolean},
+	}
+	// больше 14 колонок — длинная форма списков Thrift в схеме
+	for i := range 12 {
+		columns = append(columns, Column{Name: "extra_" + string(rune('a'+i)), Type: Int64})
+	}
+
+	var rows [][]any
+	var want [][]any
+	for r := range 21 {
+		row := make([]any, len(columns))
+		exp := make([]any, len(columns))
+		switch r % 3 {
+		case 0:
+			row[0], exp[0] = "Д-"+string(rune('А'+r)), "Д-"+string(rune('А'+r))
+			row[1], exp[1] = float64(r)+0.25, float64(r)+0.25
+			row[2], exp[2] = int64(-r), int64(-r)
+			row[3], exp[3] = r%2 == 0, r%2 == 0
+		case 1:
+			// строки и числа приводятся к типу колонки
+			row[0], exp[0] = 42.5, "42.5"
+			row[1], exp[1] = "1e3", 1000.0
+			row[2], exp[2] = "12", int64(12)
+			row[3], exp[3] = "true", true
+		}
+		for i := 4; i < len(columns); i++ {
+			if r%(i-2) == 0 {
+				row[i], exp[i] = int64(r*i), int64(r*i)
+			}
+		}
+		rows = append(rows, row)
+		want = append(want, exp)
+	}
+	// короткая строка: недостающие значения — null
+	rows = append(rows, []any{"short"})
+	exp := make([]any, len(columns))
+	exp[0] = "short"
+	want = append(want, exp)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	gotColumns, got := readFile(t, buf.Bytes())
+	if !reflect.DeepEqual(gotColumns, columns) {
+		t.Fatalf("columns = %v", gotColumns)
+	}
+	for r := range want {
+		if !reflect.DeepEqual(got[r], want[r]) {
+			t.Errorf("row %d = %v, want %v", r, got[r], want[r])
+		}
+	}
+
+	// пустой файл тоже читается
+	buf.Reset()
+	if err := Write(&buf, columns[:1], nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := readFile(t, buf.Bytes()); len(got) != 0 {
+		t.Fatalf("empty file rows = %v", got)
+	}
+}
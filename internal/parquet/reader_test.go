@@ -0,0 +1,123 @@
+package parquet_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"debtster-export/internal/parquet"
+
+	pq "github.com/parquet-go/parquet-go"
+)
+
+// readWithParquetGo reads file with parquet-go, a reader written apart from
+// this package, and returns the columns of its schema and its rows with nil
+// for nulls.
+func readWithParquetGo(t *testing.T, file []byte) ([]parquet.Column, [][]any) {
+	t.Helper()
+	f, err := pq.OpenFile(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("parquet-go open: %v", err)
+	}
+
+	var columns []parquet.Column
+	for _, field := range f.Schema().Fields() {
+		if !field.Leaf() || !field.Optional() {
+			t.Fatalf("column %s is not an optional leaf", field.Name())
+		}
+		typ := field.Type()
+		col := parquet.Column{Name: field.Name()}
+		switch typ.Kind() {
+		case pq.Boolean:
+			col.Type = parquet.Boolean
+		case pq.Int64:
+			col.Type = parquet.Int64
+		case pq.Double:
+			col.Type = parquet.Double
+		case pq.ByteArray:
+			if lt := typ.LogicalType(); lt == nil || lt.String() != "STRING" {
+				t.Fatalf("column %s is not UTF-8: %v", field.Name(), lt)
+			}
+			col.Type = parquet.ByteArray
+		default:
+			t.Fatalf("column %s has type %v", field.Name(), typ)
+		}
+		columns = append(columns, col)
+	}
+
+	var rows [][]any
+	for _, group := range f.RowGroups() {
+		reader := group.Rows()
+		buf := make([]pq.Row, 8)
+		for {
+			n, err := reader.ReadRows(buf)
+			for _, row := range buf[:n] {
+				out := make([]any, len(columns))
+				for _, v := range row {
+					if v.IsNull() {
+						continue
+					}
+					switch v.Kind() {
+					case pq.Boolean:
+						out[v.Column()] = v.Boolean()
+					case pq.Int64:
+						out[v.Column()] = v.Int64()
+					case pq.Double:
+						out[v.Column()] = v.Double()
+					case pq.ByteArray:
+						out[v.Column()] = string(v.ByteArray())
+					}
+				}
+				rows = append(rows, out)
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("parquet-go read: %v", err)
+			}
+		}
+		_ = reader.Close()
+	}
+	if int64(len(rows)) != f.NumRows() {
+		t.Fatalf("read %d rows of %d", len(rows), f.NumRows())
+	}
+	return columns, rows
+}
+
+func TestWriteReadByParquetGo(t *testing.T) {
+	columns := []parquet.Column{
+		{Name: "number", Type: parquet.ByteArray},
+		{Name: "amount", Type: parquet.Double},
+		{Name: "user_id", Type: parquet.Int64},
+		{Name: "confirmed", Type: parquet.Boolean},
+		{Name: "comment", Type: parquet.ByteArray},
+	}
+	rows := [][]any{
+		{"Д-1", 1500.25, int64(7), true, "первый"},
+		{"Д-2", "1e3", "12", "false", ""},
+		{"Д-3", nil, nil, nil, nil},
+		{"short"},
+	}
+	// порядок колонок и null'ы должны совпасть у независимого читателя
+	want := [][]any{
+		{"Д-1", 1500.25, int64(7), true, "первый"},
+		{"Д-2", 1000.0, int64(12), false, nil},
+		{"Д-3", nil, nil, nil, nil},
+		{"short", nil, nil, nil, nil},
+	}
+
+	var buf bytes.Buffer
+	if err := parquet.Write(&buf, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	gotColumns, got := readWithParquetGo(t, buf.Bytes())
+	if !reflect.DeepEqual(gotColumns, columns) {
+		t.Fatalf("columns = %v", gotColumns)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,22 @@
+// Package progress carries the estimated time left of an export into the
+// progress events sent while it is generated, the way requestid carries the
+// request id.
+package progress
+
+import (
+	"context"
+	"time"
+)
+
+type etaKey struct{}
+
+// WithETA attaches the estimated time left of the export to ctx.
+func WithETA(ctx context.Context, eta time.Duration) context.Context {
+	return context.WithValue(ctx, etaKey{}, eta)
+}
+
+// ETAFromContext returns the estimated time left attached to ctx, if any.
+func ETAFromContext(ctx context.Context) (time.Duration, bool) {
+	eta, ok := ctx.Value(etaKey{}).(time.Duration)
+	return eta, ok
+}
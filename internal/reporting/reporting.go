@@ -0,0 +1,124 @@
+// Package reporting sends errors and panics of background work to an external
+// error tracker. Until Set is called every call is a no-op apart from logging.
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Reporter is the error tracker backend.
+type Reporter interface {
+	CaptureError(ctx context.Context, err error, tags map[string]string)
+	CapturePanic(ctx context.Context, recovered any, stack []byte, tags map[string]string)
+	Flush(timeout time.Duration)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) CaptureError(context.Context, error, map[string]string) {}
+
+func (nopReporter) CapturePanic(context.Context, any, []byte, map[string]string) {}
+
+func (nopReporter) Flush(time.Duration) {}
+
+var current Reporter = nopReporter{}
+
+// Set replaces the reporter used by the package functions; call it before
+// starting background work.
+func Set(r Reporter) {
+	if r == nil {
+		r = nopReporter{}
+	}
+	current = r
+}
+
+// CaptureError reports err with the given context tags (export_id, user_id, ...).
+func CaptureError(ctx context.Context, err error, tags map[string]string) {
+	if err == nil {
+		return
+	}
+	current.CaptureError(ctx, err, tags)
+}
+
+// Recover must be deferred at the top of a goroutine: it logs and reports a
+// panic and calls onPanic (if any) instead of crashing the process.
+func Recover(ctx context.Context, tags map[string]string, onPanic func(recovered any)) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	log.Printf("[PANIC] %v %v\n%s", rec, tags, stack)
+	current.CapturePanic(ctx, rec, stack, tags)
+
+	if onPanic != nil {
+		onPanic(rec)
+	}
+}
+
+// Middleware reports handler panics and re-panics so the router's recoverer
+// still writes the 500 response; place it inside middleware.Recoverer.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec != http.ErrAbortHandler {
+					current.CapturePanic(r.Context(), rec, debug.Stack(), map[string]string{
+						"method": r.Method,
+						"path":   r.URL.Path,
+					})
+				}
+				panic(rec)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Flush waits for queued reports to be delivered.
+func Flush(timeout time.Duration) {
+	current.Flush(timeout)
+}
+
+// SentryReporter delivers reports to Sentry.
+type SentryReporter struct{}
+
+// NewSentryReporter initialises the Sentry SDK for dsn.
+func NewSentryReporter(dsn, environment, release string) (*SentryReporter, error) {
+	if err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: environment,
+		Release:     release,
+	}); err != nil {
+		return nil, fmt.Errorf("sentry init: %w", err)
+	}
+	return &SentryReporter{}, nil
+}
+
+func (SentryReporter) CaptureError(_ context.Context, err error, tags map[string]string) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		sentry.CaptureException(err)
+	})
+}
+
+func (SentryReporter) CapturePanic(_ context.Context, recovered any, stack []byte, tags map[string]string) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTags(tags)
+		scope.SetLevel(sentry.LevelFatal)
+		scope.SetContext("panic", sentry.Context{"stack": string(stack)})
+		sentry.CurrentHub().Recover(recovered)
+	})
+}
+
+func (SentryReporter) Flush(timeout time.Duration) {
+	sentry.Flush(timeout)
+}
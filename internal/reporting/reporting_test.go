@@ -0,0 +1,180 @@
+package reporting
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+type report struct {
+	err       error
+	recovered any
+	stack     []byte
+	tags      map[string]string
+}
+
+type fakeReporter struct {
+	mu      sync.Mutex
+	reports []report
+	flushed time.Duration
+}
+
+func (f *fakeReporter) CaptureError(_ context.Context, err error, tags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reports = append(f.reports, report{err: err, tags: tags})
+}
+
+func (f *fakeReporter) CapturePanic(_ context.Context, recovered any, stack []byte, tags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reports = append(f.reports, report{recovered: recovered, stack: stack, tags: tags})
+}
+
+func (f *fakeReporter) Flush(timeout time.Duration) {
+	f.flushed = timeout
+}
+
+// useFake routes the package functions to a fake until the end of the test.
+func useFake(t *testing.T) *fakeReporter {
+	f := &fakeReporter{}
+	Set(f)
+	t.Cleanup(func() { Set(nil) })
+	return f
+}
+
+func TestCaptureError(t *testing.T) {
+	f := useFake(t)
+
+	CaptureError(context.Background(), nil, nil)
+	if len(f.reports) != 0 {
+		t.Fatalf("nil error is reported: %+v", f.reports)
+	}
+
+	err := errors.New("query failed")
+	CaptureError(context.Background(), err, map[string]string{"export_id": "exports:1"})
+	if len(f.reports) != 1 || f.reports[0].err != err || f.reports[0].tags["export_id"] != "exports:1" {
+		t.Fatalf("reports = %+v", f.reports)
+	}
+
+	Flush(time.Second)
+	if f.flushed != time.Second {
+		t.Fatalf("flush timeout = %v", f.flushed)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	f := useFake(t)
+
+	var got any
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer Recover(context.Background(), map[string]string{"export_id": "exports:1"}, func(rec any) { got = rec })
+		panic("boom")
+	}()
+	<-done
+
+	if got != "boom" {
+		t.Fatalf("onPanic got %v", got)
+	}
+	if len(f.reports) != 1 {
+		t.Fatalf("reports = %+v", f.reports)
+	}
+	r := f.reports[0]
+	if r.recovered != "boom" || r.tags["export_id"] != "exports:1" || !strings.Contains(string(r.stack), "TestRecover") {
+		t.Fatalf("report = %+v", r)
+	}
+
+	// без паники — ни отчёта, ни onPanic
+	func() {
+		defer Recover(context.Background(), nil, func(any) { t.Fatal("onPanic without a panic") })
+	}()
+	if len(f.reports) != 1 {
+		t.Fatalf("reports = %+v", f.reports)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	serve := func(h http.HandlerFunc) (recovered any) {
+		defer func() { recovered = recover() }()
+		Middleware(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/export/debts", nil))
+		return nil
+	}
+
+	t.Run("reports and re-panics", func(t *testing.T) {
+		f := useFake(t)
+		rec := serve(func(http.ResponseWriter, *http.Request) { panic("boom") })
+		if rec != "boom" {
+			t.Fatalf("recovered %v, want the panic passed on to the recoverer", rec)
+		}
+		if len(f.reports) != 1 || f.reports[0].tags["method"] != http.MethodPost || f.reports[0].tags["path"] != "/export/debts" {
+			t.Fatalf("reports = %+v", f.reports)
+		}
+	})
+
+	t.Run("aborted handler is not an error", func(t *testing.T) {
+		f := useFake(t)
+		if rec := serve(func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) }); rec != http.ErrAbortHandler {
+			t.Fatalf("recovered %v", rec)
+		}
+		if len(f.reports) != 0 {
+			t.Fatalf("reports = %+v", f.reports)
+		}
+	})
+
+	t.Run("no panic", func(t *testing.T) {
+		f := useFake(t)
+		if rec := serve(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusAccepted) }); rec != nil {
+			t.Fatalf("recovered %v", rec)
+		}
+		if len(f.reports) != 0 {
+			t.Fatalf("reports = %+v", f.reports)
+		}
+	})
+}
+
+func TestSetNil(t *testing.T) {
+	Set(nil)
+	// без трекера вызовы ничего не делают
+	CaptureError(context.Background(), errors.New("x"), nil)
+	Flush(time.Millisecond)
+}
+
+func TestSentryReporter(t *testing.T) {
+	transport := &sentry.MockTransport{}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: "https://public@sentry.example.com/1", Transport: transport}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sentry.CurrentHub().BindClient(nil) })
+
+	var r SentryReporter
+	r.CaptureError(context.Background(), errors.New("query failed"), map[string]string{"export_id": "exports:1"})
+	r.CapturePanic(context.Background(), "boom", []byte("goroutine 1"), map[string]string{"export_id": "exports:2"})
+	r.Flush(time.Second)
+
+	events := transport.Events()
+	if len(events) != 2 {
+		t.Fatalf("events = %d", len(events))
+	}
+	failed, panicked := events[0], events[1]
+	if failed.Tags["export_id"] != "exports:1" || len(failed.Exception) == 0 || failed.Exception[len(failed.Exception)-1].Value != "query failed" {
+		t.Fatalf("error event = %+v", failed)
+	}
+	if panicked.Tags["export_id"] != "exports:2" || panicked.Level != sentry.LevelFatal || panicked.Contexts["panic"]["stack"] != "goroutine 1" {
+		t.Fatalf("panic event = %+v", panicked)
+	}
+
+	// теги одного отчёта не переходят в следующий
+	r.CaptureError(context.Background(), errors.New("untagged"), nil)
+	if events := transport.Events(); len(events) != 3 || events[2].Tags["export_id"] != "" {
+		t.Fatalf("tags leaked into the next event: %+v", events[len(events)-1].Tags)
+	}
+}
@@ -2,17 +2,20 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
 
 	"debtster-export/internal/domain"
+	"debtster-export/internal/filter"
 )
 
 type ActionsFilter struct {
-	CounterpartyID  *string
+	CounterpartyID *string
+	// StatusID is the current status of the debt, DebtStatusID the status
+	// the debt had when the action was recorded.
+	StatusID        *int64
 	DebtStatusID    *int64
 	DepartmentID    *int64
 	TypeID          *string
@@ -21,86 +24,86 @@ type ActionsFilter struct {
 	CreatedTo       *time.Time
 	NextContactFrom *time.Time
 	NextContactTo   *time.Time
-}
 
-type ActionRepository struct {
-	db *sql.DB
-}
+	// TypeIDs keeps actions of any of the types, ExcludeTypeIDs drops actions
+	// of these types; both apply together with TypeID.
+	TypeIDs        []string
+	ExcludeTypeIDs []string
 
-func NewActionRepository(db *sql.DB) *ActionRepository {
-	return &ActionRepository{
-		db: db,
-	}
+	// LatestPerDebt keeps only the most recent matching action of every debt.
+	LatestPerDebt bool
 }
 
-func buildActionsWhere(f ActionsFilter, startIndex int, base []string, args []any) (string, []any) {
-	where := append([]string{}, base...)
-	i := startIndex
-
-	if f.CounterpartyID != nil {
-		where = append(where, "d.counterparty_id = $"+strconv.Itoa(i))
-		args = append(args, *f.CounterpartyID)
-		i++
-	}
-
-	if f.DebtStatusID != nil {
-		where = append(where, "a.debt_status_id = $"+strconv.Itoa(i))
-		args = append(args, *f.DebtStatusID)
-		i++
-	}
-
-	if f.UserID != nil {
-		where = append(where, "a.user_id = $"+strconv.Itoa(i))
-		args = append(args, *f.UserID)
-		i++
-	}
-
-	if f.TypeID != nil && *f.TypeID != "" {
-		where = append(where, "a.type = $"+strconv.Itoa(i))
-		args = append(args, *f.TypeID)
-		i++
-	}
-
-	if f.DepartmentID != nil {
-		where = append(where, `
+// ActionsFilters are the filters of actions exports and the daily report.
+var ActionsFilters = filter.NewSet(
+	filter.Def[ActionsFilter]{Name: "counterparty_id", Kind: filter.String, Field: func(f *ActionsFilter) any { return &f.CounterpartyID },
+		SQL: "d.counterparty_id = ?", Ref: DictCounterparties},
+	filter.Def[ActionsFilter]{Name: "status_id", Kind: filter.ID, Field: func(f *ActionsFilter) any { return &f.StatusID },
+		SQL: "d.status_id = ?", Ref: DictStatuses},
+	filter.Def[ActionsFilter]{Name: "debt_status_id", Kind: filter.ID, Field: func(f *ActionsFilter) any { return &f.DebtStatusID },
+		SQL: "a.debt_status_id = ?", Ref: DictStatuses},
+	filter.Def[ActionsFilter]{Name: "user_id", Kind: filter.ID, Field: func(f *ActionsFilter) any { return &f.UserID },
+		SQL: "a.user_id = ?", Ref: DictUsers},
+	filter.Def[ActionsFilter]{Name: "type_id", Kind: filter.String, Field: func(f *ActionsFilter) any { return &f.TypeID },
+		SQL: "a.type = ?"},
+	filter.Def[ActionsFilter]{Name: "type_ids", Kind: filter.List, Field: func(f *ActionsFilter) any { return &f.TypeIDs },
+		SQL: "a.type = ANY(?)"},
+	filter.Def[ActionsFilter]{Name: "exclude_type_ids", Kind: filter.List, Field: func(f *ActionsFilter) any { return &f.ExcludeTypeIDs },
+		SQL: "a.type <> ALL(?)"},
+	filter.Def[ActionsFilter]{Name: "department_id", Kind: filter.ID, Field: func(f *ActionsFilter) any { return &f.DepartmentID }, Ref: DictDepartments,
+		SQL: `
 			EXISTS (
 				SELECT 1
 				FROM department_user du
 				WHERE du.user_id = u.id
-				  AND du.department_id = $`+strconv.Itoa(i)+`
-			)`)
-		args = append(args, *f.DepartmentID)
-		i++
-	}
+				  AND du.department_id = ?
+			)`},
+	filter.Def[ActionsFilter]{Name: "create_start_date", Kind: filter.Date, Field: func(f *ActionsFilter) any { return &f.CreatedFrom },
+		SQL: "a.created_at >= ?"},
+	filter.Def[ActionsFilter]{Name: "create_end_date", Kind: filter.Date, Field: func(f *ActionsFilter) any { return &f.CreatedTo },
+		SQL: "a.created_at <= ?"},
+	filter.Def[ActionsFilter]{Name: "next_contact_start_date", Kind: filter.Date, Field: func(f *ActionsFilter) any { return &f.NextContactFrom },
+		SQL: "a.next_contact >= ?"},
+	filter.Def[ActionsFilter]{Name: "next_contact_end_date", Kind: filter.Date, Field: func(f *ActionsFilter) any { return &f.NextContactTo },
+		SQL: "a.next_contact <= ?"},
+	// applied by List and Count, which pick the latest action per debt
+	filter.Def[ActionsFilter]{Name: "latest_per_debt", Kind: filter.Flag, Field: func(f *ActionsFilter) any { return &f.LatestPerDebt }},
+)
 
-	if f.CreatedFrom != nil {
-		where = append(where, "a.created_at >= $"+strconv.Itoa(i))
-		args = append(args, *f.CreatedFrom)
-		i++
-	}
-	if f.CreatedTo != nil {
-		where = append(where, "a.created_at <= $"+strconv.Itoa(i))
-		args = append(args, *f.CreatedTo)
-		i++
-	}
+type ActionRepository struct {
+	db *DB
+}
 
-	if f.NextContactFrom != nil {
-		where = append(where, "a.next_contact >= $"+strconv.Itoa(i))
-		args = append(args, *f.NextContactFrom)
-		i++
-	}
-	if f.NextContactTo != nil {
-		where = append(where, "a.next_contact <= $"+strconv.Itoa(i))
-		args = append(args, *f.NextContactTo)
-		i++
+func NewActionRepository(db *DB) *ActionRepository {
+	return &ActionRepository{
+		db: db,
 	}
+}
 
-	return strings.Join(where, " AND "), args
+// actionsWhere adds the conditions of f to w.
+func actionsWhere(w *whereClause, f ActionsFilter) *whereClause {
+	return whereFilters(w, ActionsFilters, f)
+}
+
+// actionsListWhere builds the WHERE of List and of the queries counting its
+// rows. With LatestPerDebt actions without a debt are left out: DISTINCT ON
+// would keep one of them while COUNT(DISTINCT a.debt_id) skips them all.
+func actionsListWhere(f ActionsFilter) (string, []any) {
+	w := newWhere(nil, "a.deleted_at IS NULL")
+	if f.LatestPerDebt {
+		w.and("a.debt_id IS NOT NULL")
+	}
+	return actionsWhere(w, f).build()
 }
 
 func (r *ActionRepository) List(ctx context.Context, f ActionsFilter) ([]domain.Action, error) {
+	selectClause := "SELECT"
+	if f.LatestPerDebt {
+		selectClause = "SELECT DISTINCT ON (a.debt_id)"
+	}
+
 	baseQuery := `
-		SELECT
+		` + selectClause + `
 			a.debt_id,
 			a.user_id,
 			a.debt_status_id,
@@ -148,13 +151,18 @@ func (r *ActionRepository) List(ctx context.Context, f ActionsFilter) ([]domain.
 			ON dbt.id = d.debtor_id
 	`
 
-	baseWhere := []string{"a.deleted_at IS NULL"}
-	args := []any{}
+	where, args := actionsListWhere(f)
+	query := baseQuery + " WHERE " + where
+	if f.LatestPerDebt {
+		query += " ORDER BY a.debt_id, a.created_at DESC, a.id DESC"
+	}
 
-	whereClause, args := buildActionsWhere(f, 1, baseWhere, args)
-	query := baseQuery + " WHERE " + whereClause
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -205,6 +213,10 @@ func (r *ActionRepository) List(ctx context.Context, f ActionsFilter) ([]domain.
 					a.PayloadDatePromisedPayment = &v
 				}
 
+				if v, ok := payload["recording_url"].(string); ok && v != "" {
+					a.PayloadRecordingURL = &v
+				}
+
 				if val, ok := payload["amount_promised_payment"]; ok {
 					switch vv := val.(type) {
 					case float64:
@@ -245,9 +257,11 @@ func (r *ActionRepository) List(ctx context.Context, f ActionsFilter) ([]domain.
 	return result, nil
 }
 
+// HasMoreThan reports whether more than limit actions (debts with
+// LatestPerDebt) match f, see hasMoreThan.
 func (r *ActionRepository) HasMoreThan(ctx context.Context, limit int64, f ActionsFilter) (bool, error) {
 	baseQuery := `
-		SELECT COUNT(*) > $1
+		SELECT ` + actionsRowExpr(f) + `
 		FROM actions a
 		LEFT JOIN debts d
 			ON d.id = a.debt_id
@@ -255,18 +269,148 @@ func (r *ActionRepository) HasMoreThan(ctx context.Context, limit int64, f Actio
 			ON u.id = a.user_id
 	`
 
-	baseWhere := []string{"a.deleted_at IS NULL"}
-	args := []any{limit}
-
-	whereClause, args := buildActionsWhere(f, 2, baseWhere, args)
-	query := baseQuery + " WHERE " + whereClause
+	where, args := actionsListWhere(f)
 
-	var tooMany bool
-	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&tooMany); err != nil {
+	db, err := r.db.For(ctx)
+	if err != nil {
 		return false, err
 	}
+	return hasMoreThan(ctx, db, limit, baseQuery+" WHERE "+where, args)
+}
+
+// Count returns the number of actions matching f.
+func (r *ActionRepository) Count(ctx context.Context, f ActionsFilter) (int64, error) {
+	baseQuery := `
+		SELECT ` + actionsCountExpr(f) + `
+		FROM actions a
+		LEFT JOIN debts d
+			ON d.id = a.debt_id
+		LEFT JOIN users u
+			ON u.id = a.user_id
+	`
+
+	where, args := actionsListWhere(f)
+	query := baseQuery + " WHERE " + where
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// TypeNames returns the display names of the action types configured in the
+// CRM by type id (the value of actions.type).
+func (r *ActionRepository) TypeNames(ctx context.Context) (map[string]string, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name
+		FROM action_types
+		WHERE deleted_at IS NULL AND name <> ''
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return tooMany, nil
+	names := map[string]string{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// ActionsGroupBy selects the columns of the daily actions report.
+type ActionsGroupBy string
+
+const (
+	ActionsGroupByUser ActionsGroupBy = "user"
+	ActionsGroupByType ActionsGroupBy = "type"
+)
+
+// DailyCounts counts the actions matching f per day of created_at and per
+// user or action type. Rows come ordered by day; LatestPerDebt is ignored.
+func (r *ActionRepository) DailyCounts(ctx context.Context, f ActionsFilter, groupBy ActionsGroupBy) ([]domain.ActionDailyCount, error) {
+	group, label := "a.type", "a.type"
+	if groupBy == ActionsGroupByUser {
+		group = "COALESCE(a.user_id::text, '')"
+		label = "COALESCE(concat_ws(' ', NULLIF(trim(u.last_name), ''), NULLIF(trim(u.first_name), ''), NULLIF(trim(u.middle_name), '')), '')"
+	}
+
+	baseQuery := `
+		SELECT
+			a.created_at::date AS day,
+			` + group + ` AS grp,
+			` + label + ` AS label,
+			COUNT(*)
+		FROM actions a
+		LEFT JOIN debts d
+			ON d.id = a.debt_id
+		LEFT JOIN users u
+			ON u.id = a.user_id
+	`
+
+	where, args := actionsWhere(newWhere(nil, "a.deleted_at IS NULL", "a.created_at IS NOT NULL"), f).build()
+	query := baseQuery + " WHERE " + where + " GROUP BY 1, 2, 3 ORDER BY 1, 2"
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.ActionDailyCount
+	for rows.Next() {
+		var c domain.ActionDailyCount
+		if err := rows.Scan(&c.Day, &c.Group, &c.Label, &c.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// actionsCountExpr counts the rows List returns for f.
+func actionsCountExpr(f ActionsFilter) string {
+	if f.LatestPerDebt {
+		return "COUNT(DISTINCT a.debt_id)"
+	}
+	return "COUNT(*)"
+}
+
+// actionsRowExpr selects one row per row List returns for f.
+func actionsRowExpr(f ActionsFilter) string {
+	if f.LatestPerDebt {
+		return "DISTINCT a.debt_id"
+	}
+	return "1"
 }
 
 func strOrEmpty(s *string) string {
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"debtster-export/internal/tenant"
+)
+
+// DB routes queries to the connection pool of the tenant carried by the
+// context. Requests without a tenant use the default pool.
+type DB struct {
+	def     *sql.DB
+	tenants map[string]*sql.DB
+	log     *queryLogger
+}
+
+// NewDB wraps the default pool and per-tenant pools (keyed by tenant id),
+// each of which is expected to be opened with the tenant's search_path.
+func NewDB(def *sql.DB, tenants map[string]*sql.DB) *DB {
+	return &DB{def: def, tenants: tenants, log: &queryLogger{}}
+}
+
+// SetQueryLog changes the logging of queries; it is safe to call while
+// queries run.
+func (d *DB) SetQueryLog(cfg QueryLog) {
+	d.log.mu.Lock()
+	defer d.log.mu.Unlock()
+	d.log.cfg = cfg
+}
+
+// QueryStats returns the query counters since start.
+func (d *DB) QueryStats() QueryStats {
+	return d.log.snapshot()
+}
+
+// For returns the pool for the tenant in ctx. An unknown tenant is an error
+// rather than a fallback so that data from different tenants never mixes.
+// Queries through it are timed and counted, see SetQueryLog.
+func (d *DB) For(ctx context.Context) (Conn, error) {
+	db, err := d.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return loggedConn{db: db, log: d.log}, nil
+}
+
+// BeginTx starts a transaction on the pool for the tenant in ctx, see For.
+func (d *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	db, err := d.pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.BeginTx(ctx, opts)
+}
+
+func (d *DB) pool(ctx context.Context) (*sql.DB, error) {
+	if t, ok := tenant.FromContext(ctx); ok {
+		db, ok := d.tenants[t.ID]
+		if !ok {
+			return nil, fmt.Errorf("no database configured for tenant %q", t.ID)
+		}
+		return db, nil
+	}
+	return d.def, nil
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"debtster-export/internal/tenant"
+)
+
+func TestDBFor(t *testing.T) {
+	def, acme := new(sql.DB), new(sql.DB)
+	db := NewDB(def, map[string]*sql.DB{"acme": acme})
+
+	pool := func(ctx context.Context) *sql.DB {
+		t.Helper()
+		conn, err := db.For(ctx)
+		if err != nil {
+			t.Fatalf("For: %v", err)
+		}
+		return conn.(loggedConn).db
+	}
+
+	// без тенанта — пул по умолчанию
+	if pool(context.Background()) != def {
+		t.Error("no tenant: want the default pool")
+	}
+	ctx := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme", Schema: "acme"})
+	if pool(ctx) != acme {
+		t.Error("tenant acme: want its pool")
+	}
+	if pool(tenant.Without(ctx)) != def {
+		t.Error("tenant removed: want the default pool")
+	}
+
+	// неизвестный тенант — ошибка, а не пул по умолчанию
+	unknown := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "other"})
+	if _, err := db.For(unknown); err == nil {
+		t.Error("unknown tenant: want an error")
+	}
+	if _, err := db.BeginTx(unknown, nil); err == nil {
+		t.Error("unknown tenant: BeginTx wants an error")
+	}
+}
@@ -2,11 +2,11 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"strings"
 
 	"debtster-export/internal/domain"
+	"debtster-export/internal/filter"
 )
 
 type DebtsFilter struct {
@@ -15,19 +15,168 @@ type DebtsFilter struct {
 	DepartmentID   *int64
 	StatusID       *int64
 	UserID         *int64
+	// Stage is the stage of the latest court case of the debt (court_cases.stage).
+	Stage *string
+
+	// PaymentHistory makes List load the payment aggregates of every debt;
+	// set by the service when a payments.* column is selected, not a filter.
+	PaymentHistory bool `json:"-"`
+	// LegalCase makes List load the latest court case and enforcement
+	// proceeding of every debt, like PaymentHistory for court.* and
+	// enforcement.* columns.
+	LegalCase bool `json:"-"`
+	// Contacts makes List load the phones and addresses of the debtor.
+	Contacts bool `json:"-"`
+}
+
+// DebtsFilters are the filters of debts exports and the aging report.
+var DebtsFilters = filter.NewSet(
+	filter.Def[DebtsFilter]{Name: "registry_id", Kind: filter.String, Field: func(f *DebtsFilter) any { return &f.RegistryID },
+		SQL: "d.registry_id = ?", Ref: DictRegistries},
+	filter.Def[DebtsFilter]{Name: "counterparty_id", Kind: filter.String, Field: func(f *DebtsFilter) any { return &f.CounterpartyID },
+		SQL: "d.counterparty_id = ?", Ref: DictCounterparties},
+	filter.Def[DebtsFilter]{Name: "status_id", Kind: filter.ID, Field: func(f *DebtsFilter) any { return &f.StatusID },
+		SQL: "d.status_id = ?", Ref: DictStatuses},
+	filter.Def[DebtsFilter]{Name: "user_id", Kind: filter.ID, Field: func(f *DebtsFilter) any { return &f.UserID },
+		SQL: "d.user_id = ?", Ref: DictUsers},
+	filter.Def[DebtsFilter]{Name: "department_id", Kind: filter.ID, Field: func(f *DebtsFilter) any { return &f.DepartmentID }, Ref: DictDepartments,
+		SQL: `
+			EXISTS (
+				SELECT 1
+				FROM department_user du
+				WHERE du.user_id = d.user_id
+				  AND du.department_id = ?
+			)`},
+	// the stage of earlier cases of the debt does not count
+	filter.Def[DebtsFilter]{Name: "stage", Kind: filter.String, Field: func(f *DebtsFilter) any { return &f.Stage },
+		SQL: `
+			(
+				SELECT cs.stage
+				FROM court_cases cs
+				WHERE cs.debt_id = d.id
+				ORDER BY cs.created_at DESC
+				LIMIT 1
+			) = ?`},
+)
+
+// debtPaymentsColumns and debtPaymentsJoin add the payment aggregates of a
+// debt to List: confirmed, not deleted payments only; "this month" is the
+// calendar month of the database clock.
+const (
+	debtPaymentsColumns = `,
+			pay.last_payment_date,
+			pay.total_paid,
+			pay.paid_this_month`
+	debtPaymentsJoin = `
+		LEFT JOIN LATERAL (
+			SELECT
+				MAX(p.payment_date)          AS last_payment_date,
+				COALESCE(SUM(p.amount), 0)   AS total_paid,
+				COALESCE(SUM(p.amount) FILTER (
+					WHERE p.payment_date >= date_trunc('month', current_date)
+				), 0)                        AS paid_this_month
+			FROM payments p
+			WHERE p.debt_id = d.id
+			  AND p.confirmed
+			  AND p.deleted_at IS NULL
+		) pay ON true
+	`
+)
+
+// debtLegalColumns and debtLegalJoin add the latest court case (with its
+// court) and the latest enforcement proceeding of a debt to List.
+const (
+	debtLegalColumns = `,
+			cc.case_number,
+			cc.court_name,
+			cc.stage,
+			ep.number,
+			ep.agent_name,
+			ep.started_at`
+	debtLegalJoin = `
+		LEFT JOIN LATERAL (
+			SELECT cs.case_number, crt.name AS court_name, cs.stage
+			FROM court_cases cs
+			LEFT JOIN courts crt ON crt.id = cs.court_id
+			WHERE cs.debt_id = d.id
+			ORDER BY cs.created_at DESC
+			LIMIT 1
+		) cc ON true
+		LEFT JOIN LATERAL (
+			SELECT e.number, e.agent_name, e.started_at
+			FROM enforcement_proceedings e
+			WHERE e.debt_id = d.id
+			ORDER BY e.started_at DESC NULLS LAST
+			LIMIT 1
+		) ep ON true
+	`
+)
+
+// contactsSeparator joins the phones and addresses of a debtor in one column
+// of the query; line breaks in addresses are replaced with spaces.
+const contactsSeparator = "\n"
+
+// debtContactsColumns and debtContactsJoin add the phones and addresses of
+// the debtor to List, in the order they were added.
+const (
+	debtContactsColumns = `,
+			dph.phones,
+			dad.addresses`
+	debtContactsJoin = `
+		LEFT JOIN LATERAL (
+			SELECT string_agg(ph.phone, E'\n' ORDER BY ph.id) AS phones
+			FROM debtor_phones ph
+			WHERE ph.debtor_id = d.debtor_id
+			  AND ph.deleted_at IS NULL
+		) dph ON true
+		LEFT JOIN LATERAL (
+			SELECT string_agg(replace(ad.address, E'\n', ' '), E'\n' ORDER BY ad.id) AS addresses
+			FROM debtor_addresses ad
+			WHERE ad.debtor_id = d.debtor_id
+			  AND ad.deleted_at IS NULL
+		) dad ON true
+	`
+)
+
+// splitContacts splits an aggregated contacts column.
+func splitContacts(joined *string) []string {
+	if joined == nil || *joined == "" {
+		return nil
+	}
+	return strings.Split(*joined, contactsSeparator)
 }
 
 type DebtRepository struct {
-	db *sql.DB
+	db *DB
 }
 
-func NewDebtRepository(db *sql.DB) *DebtRepository {
+func NewDebtRepository(db *DB) *DebtRepository {
 	return &DebtRepository{db: db}
 }
 
+// debtsWhere adds the conditions of f to w.
+func debtsWhere(w *whereClause, f DebtsFilter) *whereClause {
+	return whereFilters(w, DebtsFilters, f)
+}
+
 func (r *DebtRepository) List(ctx context.Context, f DebtsFilter) ([]domain.Debt, error) {
+	extraColumns, extraJoins := "", ""
+	if f.PaymentHistory {
+		extraColumns += debtPaymentsColumns
+		extraJoins += debtPaymentsJoin
+	}
+	if f.LegalCase {
+		extraColumns += debtLegalColumns
+		extraJoins += debtLegalJoin
+	}
+	if f.Contacts {
+		extraColumns += debtContactsColumns
+		extraJoins += debtContactsJoin
+	}
+
 	baseQuery := `
 		SELECT
+			d.id,
 			d.number,
 			d.start_date,
 			d.end_date,
@@ -68,7 +217,7 @@ func (r *DebtRepository) List(ctx context.Context, f DebtsFilter) ([]domain.Debt
 			dbt.middle_name,
 			dbt.iin,
 
-			cp.name          AS counterparty_name
+			cp.name          AS counterparty_name` + extraColumns + `
 		FROM debts d
 		LEFT JOIN registries     rg  ON rg.id  = d.registry_id
 		LEFT JOIN users          u   ON u.id   = d.user_id
@@ -85,51 +234,17 @@ func (r *DebtRepository) List(ctx context.Context, f DebtsFilter) ([]domain.Debt
 		LEFT JOIN debt_statuses  ds  ON ds.id  = d.status_id
 		LEFT JOIN debtors        dbt ON dbt.id = d.debtor_id
 		LEFT JOIN counterparties cp  ON cp.id  = d.counterparty_id
-	`
-
-	where := []string{"1=1"}
-	args := []any{}
-	i := 1
-
-	if f.RegistryID != nil {
-		where = append(where, fmt.Sprintf("d.registry_id = $%d", i))
-		args = append(args, *f.RegistryID)
-		i++
-	}
+	` + extraJoins
 
-	if f.CounterpartyID != nil {
-		where = append(where, fmt.Sprintf("d.counterparty_id = $%d", i))
-		args = append(args, *f.CounterpartyID)
-		i++
-	}
-
-	if f.StatusID != nil {
-		where = append(where, fmt.Sprintf("d.status_id = $%d", i))
-		args = append(args, *f.StatusID)
-		i++
-	}
+	where, args := debtsWhere(newWhere(nil), f).build()
+	query := baseQuery + " WHERE " + where
 
-	if f.UserID != nil {
-		where = append(where, fmt.Sprintf("d.user_id = $%d", i))
-		args = append(args, *f.UserID)
-		i++
-	}
-
-	if f.DepartmentID != nil {
-		where = append(where, fmt.Sprintf(`
-			EXISTS (
-				SELECT 1
-				FROM department_user du
-				WHERE du.user_id = u.id
-				  AND du.department_id = $%d
-			)`, i))
-		args = append(args, *f.DepartmentID)
-		i++
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	query := baseQuery + " WHERE " + strings.Join(where, " AND ")
-
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -139,8 +254,10 @@ func (r *DebtRepository) List(ctx context.Context, f DebtsFilter) ([]domain.Debt
 
 	for rows.Next() {
 		var d domain.Debt
+		var phones, addresses *string
 
-		if err := rows.Scan(
+		dest := []any{
+			&d.ID,
 			&d.Number,
 			&d.StartDate,
 			&d.EndDate,
@@ -182,9 +299,24 @@ func (r *DebtRepository) List(ctx context.Context, f DebtsFilter) ([]domain.Debt
 			&d.DebtorIIN,
 
 			&d.CounterpartyName,
-		); err != nil {
+		}
+		if f.PaymentHistory {
+			dest = append(dest, &d.LastPaymentDate, &d.TotalPaid, &d.PaidThisMonth)
+		}
+		if f.LegalCase {
+			dest = append(dest,
+				&d.CourtCaseNumber, &d.CourtName, &d.CourtStage,
+				&d.EnforcementNumber, &d.EnforcementAgent, &d.EnforcementStartedAt,
+			)
+		}
+		if f.Contacts {
+			dest = append(dest, &phones, &addresses)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, err
 		}
+		d.DebtorPhones = splitContacts(phones)
+		d.DebtorAddresses = splitContacts(addresses)
 
 		result = append(result, d)
 	}
@@ -195,3 +327,162 @@ func (r *DebtRepository) List(ctx context.Context, f DebtsFilter) ([]domain.Debt
 
 	return result, nil
 }
+
+// Count returns the number of debts matching f.
+func (r *DebtRepository) Count(ctx context.Context, f DebtsFilter) (int64, error) {
+	where, args := debtsWhere(newWhere(nil), f).build()
+	query := "SELECT COUNT(*) FROM debts d WHERE " + where
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// HasMoreThan reports whether more than limit debts match f, see hasMoreThan.
+func (r *DebtRepository) HasMoreThan(ctx context.Context, limit int64, f DebtsFilter) (bool, error) {
+	where, args := debtsWhere(newWhere(nil), f).build()
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return false, err
+	}
+	return hasMoreThan(ctx, db, limit, "SELECT 1 FROM debts d WHERE "+where, args)
+}
+
+// DebtsGroupBy selects the rows of the aging report.
+type DebtsGroupBy string
+
+const (
+	DebtsGroupByCounterparty DebtsGroupBy = "counterparty"
+	DebtsGroupByDepartment   DebtsGroupBy = "department"
+)
+
+// AgingBucketBounds are the upper bounds, in days past late_due_date, of the
+// aging buckets; debts overdue longer fall into the last, open bucket.
+var AgingBucketBounds = []int{30, 60, 90}
+
+// AgingCounts counts and sums the actual debt of the debts matching f per
+// group and days-overdue bucket, see AgingBucketBounds. A debt of a user in
+// several departments is counted once, in the group of their joined names.
+func (r *DebtRepository) AgingCounts(ctx context.Context, f DebtsFilter, groupBy DebtsGroupBy) ([]domain.DebtAgingCount, error) {
+	group := "COALESCE(cp.name, '')"
+	if groupBy == DebtsGroupByDepartment {
+		group = "COALESCE(ud.departments, '')"
+	}
+
+	bucket := "CASE WHEN d.late_due_date IS NULL THEN -1"
+	for i, bound := range AgingBucketBounds {
+		bucket += fmt.Sprintf(" WHEN current_date - d.late_due_date <= %d THEN %d", bound, i)
+	}
+	bucket += fmt.Sprintf(" ELSE %d END", len(AgingBucketBounds))
+
+	baseQuery := `
+		SELECT
+			` + group + ` AS grp,
+			` + bucket + ` AS bucket,
+			COUNT(*),
+			COALESCE(SUM(d.amount_actual_debt), 0)::float8
+		FROM debts d
+		LEFT JOIN counterparties cp
+			ON cp.id = d.counterparty_id
+		LEFT JOIN LATERAL (
+			SELECT string_agg(dep.display_name, ', ' ORDER BY dep.display_name) AS departments
+			FROM department_user du
+			JOIN departments dep ON dep.id = du.department_id
+			WHERE du.user_id = d.user_id
+		) ud ON true
+	`
+
+	where, args := debtsWhere(newWhere(nil), f).build()
+	query := baseQuery + " WHERE " + where + " GROUP BY 1, 2 ORDER BY 1, 2"
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.DebtAgingCount
+	for rows.Next() {
+		var c domain.DebtAgingCount
+		if err := rows.Scan(&c.Group, &c.Bucket, &c.Count, &c.Amount); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ListGuarantors returns co-debtors and guarantors of the debts matching f,
+// ordered by contract number so they can be matched with the main sheet.
+func (r *DebtRepository) ListGuarantors(ctx context.Context, f DebtsFilter) ([]domain.Guarantor, error) {
+	baseQuery := `
+		SELECT
+			d.number,
+			g.type,
+			g.last_name,
+			g.first_name,
+			g.middle_name,
+			g.iin,
+			g.phone
+		FROM guarantors g
+		JOIN debts d ON d.id = g.debt_id
+	`
+
+	where, args := debtsWhere(newWhere(nil, "g.deleted_at IS NULL"), f).build()
+	query := baseQuery + " WHERE " + where + " ORDER BY d.number, g.last_name, g.first_name"
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []domain.Guarantor
+
+	for rows.Next() {
+		var g domain.Guarantor
+
+		if err := rows.Scan(
+			&g.DebtNumber,
+			&g.Type,
+			&g.LastName,
+			&g.FirstName,
+			&g.MiddleName,
+			&g.IIN,
+			&g.Phone,
+		); err != nil {
+			return nil, err
+		}
+
+		result = append(result, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Dictionaries the id filters refer to, see filter.Def.Ref.
+const (
+	DictRegistries     = "registries"
+	DictCounterparties = "counterparties"
+	DictStatuses       = "debt_statuses"
+	DictUsers          = "users"
+	DictDepartments    = "departments"
+)
+
+// nameQueries select the display name of a dictionary row by id.
+var nameQueries = map[string]string{
+	DictRegistries:     `SELECT COALESCE(number, '') FROM registries WHERE id = $1`,
+	DictCounterparties: `SELECT COALESCE(name, '') FROM counterparties WHERE id = $1`,
+	DictStatuses:       `SELECT COALESCE(name, '') FROM debt_statuses WHERE id = $1`,
+	DictUsers:          `SELECT COALESCE(concat_ws(' ', NULLIF(trim(last_name), ''), NULLIF(trim(first_name), ''), NULLIF(trim(middle_name), '')), '') FROM users WHERE id = $1`,
+	DictDepartments:    `SELECT COALESCE(display_name, '') FROM departments WHERE id = $1`,
+}
+
+// NameRepository looks up the display names of dictionary rows.
+type NameRepository struct {
+	db *DB
+}
+
+func NewNameRepository(db *DB) *NameRepository {
+	return &NameRepository{db: db}
+}
+
+// Name returns the display name of the row id of dict, "" when there is no
+// such row.
+func (r *NameRepository) Name(ctx context.Context, dict string, id any) (string, error) {
+	query, ok := nameQueries[dict]
+	if !ok {
+		return "", fmt.Errorf("unknown dictionary %q", dict)
+	}
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	err = db.QueryRowContext(ctx, query, id).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"debtster-export/internal/domain"
+)
+
+// NotificationSettingsRepository keeps per-user notification settings in the
+// service-owned export_notification_settings table.
+type NotificationSettingsRepository struct {
+	db *DB
+}
+
+func NewNotificationSettingsRepository(db *DB) *NotificationSettingsRepository {
+	return &NotificationSettingsRepository{db: db}
+}
+
+// Get returns the settings of userID; a user who never saved any gets the defaults.
+func (r *NotificationSettingsRepository) Get(ctx context.Context, userID int64) (domain.NotificationSettings, error) {
+	settings := domain.NotificationSettings{UserID: userID}
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return settings, err
+	}
+
+	query := `
+		SELECT
+			COALESCE(telegram_chat_id, ''),
+			COALESCE(slack_webhook_url, ''),
+			events,
+			channels,
+			updated_at
+		FROM export_notification_settings
+		WHERE user_id = $1
+	`
+	var events, channels []byte
+	err = db.QueryRowContext(ctx, query, userID).Scan(
+		&settings.TelegramChatID,
+		&settings.SlackWebhookURL,
+		&events,
+		&channels,
+		&settings.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+
+	if settings.Events, err = decodeStringList(events); err != nil {
+		return settings, fmt.Errorf("decode events: %w", err)
+	}
+	if settings.Channels, err = decodeStringList(channels); err != nil {
+		return settings, fmt.Errorf("decode channels: %w", err)
+	}
+	return settings, nil
+}
+
+// Save creates or replaces the settings of s.UserID.
+func (r *NotificationSettingsRepository) Save(ctx context.Context, s domain.NotificationSettings) error {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, err := encodeStringList(s.Events)
+	if err != nil {
+		return err
+	}
+	channels, err := encodeStringList(s.Channels)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO export_notification_settings (user_id, telegram_chat_id, slack_webhook_url, events, channels, updated_at)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4::jsonb, $5::jsonb, now())
+		ON CONFLICT (user_id) DO UPDATE SET
+			telegram_chat_id  = EXCLUDED.telegram_chat_id,
+			slack_webhook_url = EXCLUDED.slack_webhook_url,
+			events            = EXCLUDED.events,
+			channels          = EXCLUDED.channels,
+			updated_at        = EXCLUDED.updated_at
+	`
+	_, err = db.ExecContext(ctx, query, s.UserID, s.TelegramChatID, s.SlackWebhookURL, events, channels)
+	return err
+}
+
+// decodeStringList reads a jsonb array; NULL stays nil (the default).
+func decodeStringList(raw []byte) ([]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	out := []string{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// encodeStringList writes nil as NULL, so an empty list still means "nothing".
+func encodeStringList(list []string) (*string, error) {
+	if list == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	s := string(raw)
+	return &s, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"debtster-export/internal/domain"
+)
+
+// OutboxRepository keeps undelivered events in the service-owned export_outbox table.
+type OutboxRepository struct {
+	db *DB
+}
+
+func NewOutboxRepository(db *DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// Enqueue stores e as claimed for lease, so the publisher can try to deliver
+// it right away before a dispatcher picks it up. It reports false when an
+// entry with the same dedup key exists.
+func (r *OutboxRepository) Enqueue(ctx context.Context, e domain.OutboxEntry, lease time.Duration) (int64, bool, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	query := `
+		INSERT INTO export_outbox (dedup_key, kind, payload, attempts, next_attempt_at)
+		VALUES ($1, $2, $3::jsonb, 1, $4)
+		ON CONFLICT (dedup_key) DO NOTHING
+		RETURNING id
+	`
+	var id int64
+	err = db.QueryRowContext(ctx, query, e.DedupKey, e.Kind, string(e.Payload), time.Now().Add(lease)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// ClaimDue returns up to limit undelivered entries that are due and had fewer
+// than maxAttempts attempts, and claims them for lease; instances never claim
+// the same entry at once.
+func (r *OutboxRepository) ClaimDue(ctx context.Context, limit, maxAttempts int, lease time.Duration) ([]domain.OutboxEntry, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE export_outbox
+		SET attempts = attempts + 1, next_attempt_at = $3
+		WHERE id IN (
+			SELECT id FROM export_outbox
+			WHERE delivered_at IS NULL
+			  AND next_attempt_at <= now()
+			  AND attempts < $2
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, dedup_key, kind, payload, attempts, COALESCE(last_error, ''), created_at
+	`
+	rows, err := db.QueryContext(ctx, query, limit, maxAttempts, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.OutboxEntry
+	for rows.Next() {
+		var (
+			e       domain.OutboxEntry
+			payload []byte
+		)
+		if err := rows.Scan(&e.ID, &e.DedupKey, &e.Kind, &payload, &e.Attempts, &e.LastError, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// MarkDelivered records the delivery of entry id.
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, id int64) error {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE export_outbox SET delivered_at = now(), last_error = NULL WHERE id = $1`, id)
+	return err
+}
+
+// MarkFailed records a failed attempt and when to try again.
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id int64, errMsg string, retryAt time.Time) error {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE export_outbox SET last_error = $2, next_attempt_at = $3 WHERE id = $1`, id, errMsg, retryAt)
+	return err
+}
+
+// Prune removes entries delivered before deliveredBefore and all entries
+// created before createdBefore.
+func (r *OutboxRepository) Prune(ctx context.Context, deliveredBefore, createdBefore time.Time) (int, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.ExecContext(ctx, `DELETE FROM export_outbox WHERE delivered_at < $1 OR created_at < $2`, deliveredBefore, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
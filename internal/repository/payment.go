@@ -3,11 +3,10 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"strings"
 	"time"
 
 	"debtster-export/internal/domain"
+	"debtster-export/internal/filter"
 )
 
 type PaymentsFilter struct {
@@ -18,55 +17,47 @@ type PaymentsFilter struct {
 	PeriodImportedEndDate   *time.Time
 }
 
+// PaymentsFilters are the filters of payments exports.
+var PaymentsFilters = filter.NewSet(
+	// the column is boolean, the API keeps the 1/0 of the Laravel app
+	filter.Def[PaymentsFilter]{Name: "confirmed", Kind: filter.Bit, Field: func(f *PaymentsFilter) any { return &f.Confirmed },
+		SQL: "p.confirmed = ?"},
+	// payments do not store the counterparty: debts are joined for it
+	filter.Def[PaymentsFilter]{Name: "counterparty_id", Kind: filter.String, Field: func(f *PaymentsFilter) any { return &f.CounterpartyID },
+		SQL: "d.counterparty_id = ?", Ref: DictCounterparties},
+	filter.Def[PaymentsFilter]{Name: "user_id", Kind: filter.ID, Field: func(f *PaymentsFilter) any { return &f.UserID },
+		SQL: "p.user_id = ?", Ref: DictUsers},
+	filter.Def[PaymentsFilter]{Name: "period_imported_start_date", Kind: filter.Date, Field: func(f *PaymentsFilter) any { return &f.PeriodImportedStartDate },
+		SQL: "p.payment_date >= ?"},
+	filter.Def[PaymentsFilter]{Name: "period_imported_end_date", Kind: filter.Date, Field: func(f *PaymentsFilter) any { return &f.PeriodImportedEndDate },
+		SQL: "p.payment_date <= ?"},
+)
+
 type PaymentRepository struct {
-	db *sql.DB
+	db *DB
 }
 
-func NewPaymentRepository(db *sql.DB) *PaymentRepository {
+func NewPaymentRepository(db *DB) *PaymentRepository {
 	return &PaymentRepository{db: db}
 }
 
+// paymentsWhere adds the conditions of f to w.
+func paymentsWhere(w *whereClause, f PaymentsFilter) *whereClause {
+	return whereFilters(w, PaymentsFilters, f)
+}
+
 func (r *PaymentRepository) List(ctx context.Context, f PaymentsFilter) ([]domain.Payment, error) {
 	base := `SELECT p.id, p.debt_id, p.user_id, p.amount, p.amount_after_subtraction, p.amount_government_duty, p.amount_representation_expenses, p.amount_notary_fees, p.amount_postage, p.confirmed, p.payment_date, p.created_at, p.updated_at, p.deleted_at, p.amount_accounts_receivable, p.amount_main_debt, p.amount_accrual, p.amount_fine FROM payments p LEFT JOIN debts d ON d.id = p.debt_id`
 
-	where := []string{"1=1"}
-	args := []any{}
-	i := 1
+	where, args := paymentsWhere(newWhere(nil), f).build()
+	query := base + " WHERE " + where
 
-	if f.Confirmed != nil {
-		where = append(where, fmt.Sprintf("confirmed = $%d", i))
-		// Postgres column `confirmed` is boolean; convert incoming int (1/0) to bool
-		args = append(args, (*f.Confirmed) == 1)
-		i++
-	}
-
-	if f.CounterpartyID != nil && *f.CounterpartyID != "" {
-		// payments don't directly store counterparty_id — join debts table and filter by d.counterparty_id
-		where = append(where, fmt.Sprintf("d.counterparty_id = $%d", i))
-		args = append(args, *f.CounterpartyID)
-		i++
-	}
-
-	if f.UserID != nil {
-		where = append(where, fmt.Sprintf("user_id = $%d", i))
-		args = append(args, *f.UserID)
-		i++
-	}
-
-	if f.PeriodImportedStartDate != nil {
-		where = append(where, fmt.Sprintf("payment_date >= $%d", i))
-		args = append(args, *f.PeriodImportedStartDate)
-		i++
-	}
-	if f.PeriodImportedEndDate != nil {
-		where = append(where, fmt.Sprintf("payment_date <= $%d", i))
-		args = append(args, *f.PeriodImportedEndDate)
-		i++
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	query := base + " WHERE " + strings.Join(where, " AND ")
-
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -121,44 +112,34 @@ func (r *PaymentRepository) List(ctx context.Context, f PaymentsFilter) ([]domai
 	return out, nil
 }
 
+// HasMoreThan reports whether more than limit payments match f, see hasMoreThan.
 func (r *PaymentRepository) HasMoreThan(ctx context.Context, limit int64, f PaymentsFilter) (bool, error) {
-	base := `SELECT COUNT(*) > $1 FROM payments p LEFT JOIN debts d ON d.id = p.debt_id`
+	base := `SELECT 1 FROM payments p LEFT JOIN debts d ON d.id = p.debt_id`
 
-	where := []string{"1=1"}
-	args := []any{limit}
-	i := 2
+	where, args := paymentsWhere(newWhere(nil), f).build()
 
-	if f.Confirmed != nil {
-		where = append(where, fmt.Sprintf("confirmed = $%d", i))
-		args = append(args, (*f.Confirmed) == 1)
-		i++
-	}
-	if f.CounterpartyID != nil && *f.CounterpartyID != "" {
-		where = append(where, fmt.Sprintf("d.counterparty_id = $%d", i))
-		args = append(args, *f.CounterpartyID)
-		i++
-	}
-	if f.UserID != nil {
-		where = append(where, fmt.Sprintf("user_id = $%d", i))
-		args = append(args, *f.UserID)
-		i++
-	}
-	if f.PeriodImportedStartDate != nil {
-		where = append(where, fmt.Sprintf("payment_date >= $%d", i))
-		args = append(args, *f.PeriodImportedStartDate)
-		i++
-	}
-	if f.PeriodImportedEndDate != nil {
-		where = append(where, fmt.Sprintf("payment_date <= $%d", i))
-		args = append(args, *f.PeriodImportedEndDate)
-		i++
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return false, err
 	}
+	return hasMoreThan(ctx, db, limit, base+" WHERE "+where, args)
+}
 
-	query := base + " WHERE " + strings.Join(where, " AND ")
+// Count returns the number of payments matching f.
+func (r *PaymentRepository) Count(ctx context.Context, f PaymentsFilter) (int64, error) {
+	base := `SELECT COUNT(*) FROM payments p LEFT JOIN debts d ON d.id = p.debt_id`
 
-	var tooMany bool
-	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&tooMany); err != nil {
-		return false, err
+	where, args := paymentsWhere(newWhere(nil), f).build()
+	query := base + " WHERE " + where
+
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
 	}
-	return tooMany, nil
+	return count, nil
 }
@@ -6,7 +6,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -16,46 +15,45 @@ import (
 
 const userTokenableType = "App\\Infrastructure\\Persistence\\Models\\User"
 
+// PersonalAccessTokenRepository reads the Sanctum tokens of the tenant in the
+// context: each tenant schema has its own users and tokens.
 type PersonalAccessTokenRepository struct {
-	db *sql.DB
+	db *DB
 }
 
-func NewPersonalAccessTokenRepository(db *sql.DB) *PersonalAccessTokenRepository {
+func NewPersonalAccessTokenRepository(db *DB) *PersonalAccessTokenRepository {
 	return &PersonalAccessTokenRepository{db: db}
 }
 
+// FindTokenByPlainToken looks up the token of a Sanctum "id|secret" (or bare
+// secret) plain token; tokens are stored hashed, legacy rows in plain text.
 func (r *PersonalAccessTokenRepository) FindTokenByPlainToken(ctx context.Context, plainToken string) (*domain.PersonalAccessToken, error) {
 	plainToken = strings.TrimSpace(plainToken)
 	if plainToken == "" {
 		return nil, errors.New("empty token")
 	}
 
-	log.Printf("[TOKEN] plainToken=%q", plainToken)
-
 	var (
 		tokenID   *int64
 		tokenPart string
 	)
 
 	if idx := strings.Index(plainToken, "|"); idx > 0 {
-		idStr := plainToken[:idx]
 		tokenPart = plainToken[idx+1:]
-
-		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+		if id, err := strconv.ParseInt(plainToken[:idx], 10, 64); err == nil {
 			tokenID = &id
-		} else {
-			log.Printf("[TOKEN] failed to parse id %q: %v", idStr, err)
 		}
 	} else {
 		tokenPart = plainToken
 	}
 
-	log.Printf("[TOKEN] parsed id=%v tokenPart=%q", tokenID, tokenPart)
-
 	sum := sha256.Sum256([]byte(tokenPart))
 	hashStr := fmt.Sprintf("%x", sum)
 
-	log.Printf("[TOKEN] computed sha256=%s", hashStr)
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var pat domain.PersonalAccessToken
 
@@ -68,27 +66,18 @@ func (r *PersonalAccessTokenRepository) FindTokenByPlainToken(ctx context.Contex
 			  AND (expires_at IS NULL OR expires_at > $3)
 		`
 
-		log.Printf("[TOKEN] query by id=%d", *tokenID)
-
-		err := r.db.QueryRowContext(ctx, query, *tokenID, userTokenableType, time.Now()).Scan(
+		err := db.QueryRowContext(ctx, query, *tokenID, userTokenableType, time.Now()).Scan(
 			&pat.ID,
 			&pat.TokenHash,
 			&pat.UserID,
 			&pat.Abilities,
 			&pat.ExpiresAt,
 		)
-		if err != nil {
-			log.Printf("[TOKEN] query by id error: %v", err)
-		} else {
-			log.Printf("[TOKEN] DB row: id=%d dbToken=%q userID=%d abilities=%q expiresAt=%v",
-				pat.ID, pat.TokenHash, pat.UserID, pat.Abilities, pat.ExpiresAt)
-
-			if pat.TokenHash == hashStr || pat.TokenHash == tokenPart {
-				log.Printf("[TOKEN] token match (hash or plain) for id=%d", pat.ID)
-				return &pat, nil
-			}
-			log.Printf("[TOKEN] token mismatch: dbToken=%q, hashStr=%q, plain=%q",
-				pat.TokenHash, hashStr, tokenPart)
+		if err == nil && (pat.TokenHash == hashStr || pat.TokenHash == tokenPart) {
+			return &pat, nil
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("query token by id: %w", err)
 		}
 	}
 
@@ -102,22 +91,57 @@ func (r *PersonalAccessTokenRepository) FindTokenByPlainToken(ctx context.Contex
 		LIMIT 1
 	`
 
-	log.Printf("[TOKEN] fallback query by token IN (hash, plain)")
-
-	err := r.db.QueryRowContext(ctx, query, userTokenableType, hashStr, tokenPart, time.Now()).Scan(
+	err = db.QueryRowContext(ctx, query, userTokenableType, hashStr, tokenPart, time.Now()).Scan(
 		&pat.ID,
 		&pat.TokenHash,
 		&pat.UserID,
 		&pat.Abilities,
 		&pat.ExpiresAt,
 	)
-	if err != nil {
-		log.Printf("[TOKEN] fallback query error: %v", err)
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, errors.New("token not found")
 	}
-
-	log.Printf("[TOKEN] fallback row: id=%d dbToken=%q userID=%d abilities=%q expiresAt=%v",
-		pat.ID, pat.TokenHash, pat.UserID, pat.Abilities, pat.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("query token: %w", err)
+	}
 
 	return &pat, nil
 }
+
+// RecordUsage stores the last use of tokens: last_used_at in
+// personal_access_tokens, as Sanctum does, and the client in the service-owned
+// export_token_usage table, of the tenant in ctx. Older uses never overwrite
+// newer ones.
+func (r *PersonalAccessTokenRepository) RecordUsage(ctx context.Context, usage []domain.TokenUsage) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	touch := `
+		UPDATE personal_access_tokens
+		SET last_used_at = $2
+		WHERE id = $1
+		  AND (last_used_at IS NULL OR last_used_at < $2)
+	`
+	upsert := `
+		INSERT INTO export_token_usage (token_id, user_id, last_used_at, ip, user_agent)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''))
+		ON CONFLICT (token_id) DO UPDATE SET
+			user_id      = EXCLUDED.user_id,
+			last_used_at = EXCLUDED.last_used_at,
+			ip           = EXCLUDED.ip,
+			user_agent   = EXCLUDED.user_agent
+		WHERE export_token_usage.last_used_at < EXCLUDED.last_used_at
+	`
+	for _, u := range usage {
+		if _, err := tx.ExecContext(ctx, touch, u.TokenID, u.UsedAt); err != nil {
+			return fmt.Errorf("touch token %d: %w", u.TokenID, err)
+		}
+		if _, err := tx.ExecContext(ctx, upsert, u.TokenID, u.UserID, u.UsedAt, u.IP, u.UserAgent); err != nil {
+			return fmt.Errorf("record usage of token %d: %w", u.TokenID, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"debtster-export/internal/requestid"
+)
+
+// maxSlowQueries bounds the distinct slow queries kept in QueryStats.
+const maxSlowQueries = 100
+
+// QueryLog configures the logging of the queries of the repositories.
+type QueryLog struct {
+	// All logs every query with its duration.
+	All bool
+	// Slow logs queries that take at least this long as warnings and counts
+	// them per query; 0 disables it.
+	Slow time.Duration
+}
+
+// QueryStats are the query counters published as an expvar.
+type QueryStats struct {
+	Queries int64 `json:"queries"`
+	Errors  int64 `json:"errors"`
+	Slow    int64 `json:"slow"`
+	TotalMs int64 `json:"total_ms"`
+	// SlowQueries are the slow queries by fingerprint, see fingerprint.
+	SlowQueries map[string]SlowQueryStats `json:"slow_queries,omitempty"`
+}
+
+// SlowQueryStats counts the slow runs of one query.
+type SlowQueryStats struct {
+	SQL   string `json:"sql"`
+	Count int64  `json:"count"`
+	MaxMs int64  `json:"max_ms"`
+}
+
+// Conn is the part of a connection pool the repositories query through.
+type Conn interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// queryLogger records the queries of a pool; it is shared by all the pools of a DB.
+type queryLogger struct {
+	mu    sync.Mutex
+	cfg   QueryLog
+	stats QueryStats
+}
+
+// record counts a query and logs it by the configuration. Arguments are never
+// logged, only their types, as they carry personal data.
+func (l *queryLogger) record(ctx context.Context, query string, args []any, elapsed time.Duration, err error) {
+	l.mu.Lock()
+	cfg := l.cfg
+	l.stats.Queries++
+	l.stats.TotalMs += elapsed.Milliseconds()
+	if err != nil {
+		l.stats.Errors++
+	}
+	slow := cfg.Slow > 0 && elapsed >= cfg.Slow
+	var sqlText, id string
+	if slow || cfg.All {
+		sqlText = compactSQL(query)
+		id = fingerprint(sqlText)
+	}
+	if slow {
+		l.stats.Slow++
+		if l.stats.SlowQueries == nil {
+			l.stats.SlowQueries = map[string]SlowQueryStats{}
+		}
+		if s, ok := l.stats.SlowQueries[id]; ok || len(l.stats.SlowQueries) < maxSlowQueries {
+			s.SQL = sqlText
+			s.Count++
+			s.MaxMs = max(s.MaxMs, elapsed.Milliseconds())
+			l.stats.SlowQueries[id] = s
+		}
+	}
+	l.mu.Unlock()
+
+	switch {
+	case slow:
+		requestid.Logf(ctx, "[SQL] WARN slow query %s (q:%s, args: %s, err: %v): %s", elapsed.Round(time.Millisecond), id, argTypes(args), err, sqlText)
+	case cfg.All:
+		requestid.Logf(ctx, "[SQL] %s (q:%s, args: %s, err: %v): %s", elapsed.Round(time.Millisecond), id, argTypes(args), err, sqlText)
+	}
+}
+
+func (l *queryLogger) snapshot() QueryStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := l.stats
+	out.SlowQueries = make(map[string]SlowQueryStats, len(l.stats.SlowQueries))
+	for id, s := range l.stats.SlowQueries {
+		out.SlowQueries[id] = s
+	}
+	return out
+}
+
+// loggedConn times the queries of a pool.
+type loggedConn struct {
+	db  *sql.DB
+	log *queryLogger
+}
+
+// QueryContext times the query until its first rows are ready, not the reading of them.
+func (c loggedConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	c.log.record(ctx, query, args, time.Since(start), err)
+	return rows, err
+}
+
+func (c loggedConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := c.db.QueryRowContext(ctx, query, args...)
+	c.log.record(ctx, query, args, time.Since(start), row.Err())
+	return row
+}
+
+func (c loggedConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	res, err := c.db.ExecContext(ctx, query, args...)
+	c.log.record(ctx, query, args, time.Since(start), err)
+	return res, err
+}
+
+// compactSQL collapses the whitespace of the multi-line queries of this package.
+func compactSQL(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// fingerprint names a query text, so every filter combination, which builds a
+// different WHERE clause, is counted apart.
+func fingerprint(sqlText string) string {
+	sum := sha1.Sum([]byte(sqlText))
+	return hex.EncodeToString(sum[:4])
+}
+
+// argTypes lists the types of args, e.g. "$1 string, $2 int64".
+func argTypes(args []any) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = fmt.Sprintf("$%d %T", i+1, a)
+	}
+	return strings.Join(parts, ", ")
+}
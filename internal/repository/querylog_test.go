@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryLogger(t *testing.T) {
+	l := &queryLogger{cfg: QueryLog{Slow: 100 * time.Millisecond}}
+	query := `
+		SELECT COUNT(*)
+		FROM debts d
+		WHERE 1=1 AND d.status_id = $1`
+
+	l.record(context.Background(), query, []any{int64(3)}, 10*time.Millisecond, nil)
+	l.record(context.Background(), query, []any{int64(3)}, 300*time.Millisecond, nil)
+	l.record(context.Background(), query, []any{int64(4)}, 200*time.Millisecond, errors.New("canceled"))
+
+	stats := l.snapshot()
+	if stats.Queries != 3 || stats.Errors != 1 || stats.Slow != 2 || stats.TotalMs != 510 {
+		t.Fatalf("stats: %+v", stats)
+	}
+	// медленные запросы считаются по тексту, без значений аргументов
+	slow, ok := stats.SlowQueries[fingerprint(compactSQL(query))]
+	if !ok || slow.Count != 2 || slow.MaxMs != 300 || slow.SQL != "SELECT COUNT(*) FROM debts d WHERE 1=1 AND d.status_id = $1" {
+		t.Fatalf("slow queries: %+v", stats.SlowQueries)
+	}
+
+	if got := argTypes([]any{"Иванов", int64(1)}); got != "$1 string, $2 int64" || strings.Contains(got, "Иванов") {
+		t.Fatalf("argTypes = %q", got)
+	}
+}
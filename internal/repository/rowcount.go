@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// rowEstimateMargin is how far the planner estimate has to be from the limit
+// to be trusted: an estimate over limit*margin or under limit/margin decides
+// alone, one in between is checked by counting.
+const rowEstimateMargin = 4
+
+// hasMoreThan reports whether rowsQuery, a query returning one row per
+// counted row, yields more than limit rows.
+//
+// The answer comes from the planner estimate (EXPLAIN), which costs no scan,
+// unless the estimate is close to the limit; only then are the rows counted,
+// and at most limit+1 of them.
+func hasMoreThan(ctx context.Context, db Conn, limit int64, rowsQuery string, args []any) (bool, error) {
+	if estimate, err := estimateRows(ctx, db, rowsQuery, args); err == nil {
+		if tooMany, sure := estimateDecides(estimate, limit); sure {
+			return tooMany, nil
+		}
+	} else if ctx.Err() != nil {
+		return false, err
+	}
+
+	n := len(args) + 1
+	query := fmt.Sprintf("SELECT COUNT(*) > $%d FROM (%s LIMIT $%d + 1) t", n, rowsQuery, n)
+
+	var tooMany bool
+	if err := db.QueryRowContext(ctx, query, append(args[:len(args):len(args)], limit)...).Scan(&tooMany); err != nil {
+		return false, err
+	}
+	return tooMany, nil
+}
+
+// estimateDecides reports whether the estimate is far enough from limit to
+// answer hasMoreThan without a count.
+func estimateDecides(estimate, limit int64) (tooMany, sure bool) {
+	switch {
+	case estimate > limit*rowEstimateMargin:
+		return true, true
+	case estimate*rowEstimateMargin < limit:
+		return false, true
+	}
+	return false, false
+}
+
+// estimateRows returns the number of rows the planner expects from query.
+// Without filters the planner takes it from pg_class.reltuples.
+func estimateRows(ctx context.Context, db Conn, query string, args []any) (int64, error) {
+	var plan []byte
+	if err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&plan); err != nil {
+		return 0, err
+	}
+	return planRows(plan)
+}
+
+// planRows reads the row estimate of the top node from EXPLAIN (FORMAT JSON).
+func planRows(plan []byte) (int64, error) {
+	var out []struct {
+		Plan struct {
+			Rows *float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &out); err != nil {
+		return 0, fmt.Errorf("decode plan: %w", err)
+	}
+	if len(out) == 0 || out[0].Plan.Rows == nil {
+		return 0, errors.New("plan has no row estimate")
+	}
+	return int64(*out[0].Plan.Rows), nil
+}
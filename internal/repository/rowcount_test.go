@@ -0,0 +1,36 @@
+package repository
+
+import "testing"
+
+func TestPlanRows(t *testing.T) {
+	plan := []byte(`[{"Plan": {"Node Type": "Hash Join", "Startup Cost": 10.5, "Plan Rows": 125000, "Plan Width": 4}}]`)
+	if rows, err := planRows(plan); err != nil || rows != 125000 {
+		t.Fatalf("rows = %d, %v", rows, err)
+	}
+	if _, err := planRows([]byte(`[{"Plan": {"Node Type": "Result"}}]`)); err == nil {
+		t.Fatal("expected an error for a plan without rows")
+	}
+	if _, err := planRows([]byte(`not json`)); err == nil {
+		t.Fatal("expected an error for a broken plan")
+	}
+}
+
+// Оценка планировщика решает сама только вдали от лимита, рядом с ним строки считаются.
+func TestEstimateDecides(t *testing.T) {
+	for _, tc := range []struct {
+		estimate      int64
+		tooMany, sure bool
+	}{
+		{estimate: 0, tooMany: false, sure: true},
+		{estimate: 100_000, tooMany: false, sure: true},
+		{estimate: 125_000, tooMany: false, sure: false},
+		{estimate: 500_000, tooMany: false, sure: false},
+		{estimate: 2_000_000, tooMany: false, sure: false},
+		{estimate: 2_000_001, tooMany: true, sure: true},
+	} {
+		tooMany, sure := estimateDecides(tc.estimate, 500_000)
+		if tooMany != tc.tooMany || sure != tc.sure {
+			t.Errorf("estimate %d: got (%v, %v), want (%v, %v)", tc.estimate, tooMany, sure, tc.tooMany, tc.sure)
+		}
+	}
+}
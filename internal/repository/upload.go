@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"debtster-export/internal/domain"
+)
+
+// UploadRepository keeps the registry of uploaded files in the service-owned
+// export_uploads table.
+type UploadRepository struct {
+	db *DB
+}
+
+func NewUploadRepository(db *DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+// Create registers an uploaded file.
+func (r *UploadRepository) Create(ctx context.Context, u domain.Upload) error {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO export_uploads (file, user_id, original_name, size, content_type, checksum)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = db.ExecContext(ctx, query, u.File, u.UserID, u.OriginalName, u.Size, u.ContentType, u.Checksum)
+	return err
+}
+
+// ListByUser returns the uploads of userID, newest first.
+func (r *UploadRepository) ListByUser(ctx context.Context, userID int64) ([]domain.Upload, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT file, user_id, original_name, size, content_type, checksum, created_at
+		FROM export_uploads
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Upload{}
+	for rows.Next() {
+		var u domain.Upload
+		if err := rows.Scan(&u.File, &u.UserID, &u.OriginalName, &u.Size, &u.ContentType, &u.Checksum, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// Owner returns the user who uploaded file; ok is false for files that are
+// not uploads (generated exports).
+func (r *UploadRepository) Owner(ctx context.Context, file string) (int64, bool, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var userID int64
+	err = db.QueryRowContext(ctx, `SELECT user_id FROM export_uploads WHERE file = $1`, file).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return userID, true, nil
+}
+
+// Delete removes the registration of file if userID owns it and reports
+// whether it did.
+func (r *UploadRepository) Delete(ctx context.Context, file string, userID int64) (bool, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := db.ExecContext(ctx, `DELETE FROM export_uploads WHERE file = $1 AND user_id = $2`, file, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -2,16 +2,15 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 
 	"debtster-export/internal/domain"
 )
 
 type UserRepository struct {
-	db *sql.DB
+	db *DB
 }
 
-func NewUserRepository(db *sql.DB) *UserRepository {
+func NewUserRepository(db *DB) *UserRepository {
 	return &UserRepository{
 		db: db,
 	}
@@ -26,7 +25,11 @@ func (r *UserRepository) List(ctx context.Context) ([]domain.User, error) {
 			u.username,
 			u.email,
 			u.phone,
-			ud.departments
+			ud.departments,
+
+			COALESCE(dw.debts_count, 0)  AS assigned_debts_count,
+			COALESCE(dw.debts_amount, 0) AS assigned_debts_amount,
+			COALESCE(aw.actions_count, 0) AS actions_last_30_days_count
 		FROM users u
 		LEFT JOIN (
 			SELECT
@@ -36,10 +39,32 @@ func (r *UserRepository) List(ctx context.Context) ([]domain.User, error) {
 			JOIN departments d ON d.id = du.department_id
 			GROUP BY du.user_id
 		) ud ON ud.user_id = u.id
+		LEFT JOIN (
+			SELECT
+				d.user_id,
+				COUNT(*)                  AS debts_count,
+				SUM(d.amount_actual_debt) AS debts_amount
+			FROM debts d
+			GROUP BY d.user_id
+		) dw ON dw.user_id = u.id
+		LEFT JOIN (
+			SELECT
+				a.user_id,
+				COUNT(*) AS actions_count
+			FROM actions a
+			WHERE a.deleted_at IS NULL
+			  AND a.created_at >= NOW() - INTERVAL '30 days'
+			GROUP BY a.user_id
+		) aw ON aw.user_id = u.id
 		WHERE u.deleted_at IS NULL
 	`
 
-	rows, err := r.db.QueryContext(ctx, baseQuery)
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, baseQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +83,10 @@ func (r *UserRepository) List(ctx context.Context) ([]domain.User, error) {
 			&u.Email,
 			&u.Phone,
 			&u.Departments,
+
+			&u.AssignedDebtsCount,
+			&u.AssignedDebtsAmount,
+			&u.ActionsLast30DaysCount,
 		); err != nil {
 			return nil, err
 		}
@@ -71,3 +100,43 @@ func (r *UserRepository) List(ctx context.Context) ([]domain.User, error) {
 
 	return result, nil
 }
+
+// Count returns the number of users included in the users export.
+func (r *UserRepository) Count(ctx context.Context) (int64, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users u WHERE u.deleted_at IS NULL").Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// HasMoreThan reports whether the users export would include more than limit users.
+func (r *UserRepository) HasMoreThan(ctx context.Context, limit int64) (bool, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return hasMoreThan(ctx, db, limit, "SELECT 1 FROM users u WHERE u.deleted_at IS NULL", nil)
+}
+
+// Email returns the address of the user, empty when not set.
+func (r *UserRepository) Email(ctx context.Context, userID int64) (string, error) {
+	db, err := r.db.For(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var email *string
+	err = db.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL`, userID).Scan(&email)
+	if err != nil || email == nil {
+		return "", err
+	}
+	return *email, nil
+}
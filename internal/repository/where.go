@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"debtster-export/internal/filter"
+)
+
+// whereClause builds the WHERE clause of a query from conditions written with
+// "?" placeholders. They are numbered $n in the order the conditions are added,
+// after the arguments the query binds itself, so adding or reordering a filter
+// cannot break the numbering. Conditions are static SQL of this package; the
+// jsonb "?" operators cannot be used in them.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+// newWhere starts a clause after args, which the query itself binds as $1..$n,
+// with the base conditions.
+func newWhere(args []any, base ...string) *whereClause {
+	return &whereClause{conds: append([]string{}, base...), args: append([]any{}, args...)}
+}
+
+// and adds cond; each "?" in it binds the next of args. A count mismatch is a
+// bug in the query, so it panics.
+func (w *whereClause) and(cond string, args ...any) *whereClause {
+	parts := strings.Split(cond, "?")
+	if len(parts)-1 != len(args) {
+		panic(fmt.Sprintf("repository: %d placeholders for %d arguments in %q", len(parts)-1, len(args), cond))
+	}
+
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i, part := range parts[1:] {
+		w.args = append(w.args, args[i])
+		b.WriteString("$" + strconv.Itoa(len(w.args)))
+		b.WriteString(part)
+	}
+	w.conds = append(w.conds, b.String())
+	return w
+}
+
+// build returns the conditions joined with AND ("1=1" without any) and all
+// the arguments of the query.
+func (w *whereClause) build() (string, []any) {
+	if len(w.conds) == 0 {
+		return "1=1", w.args
+	}
+	return strings.Join(w.conds, " AND "), w.args
+}
+
+// whereFilters adds the conditions of the filters of set that f sets.
+func whereFilters[F any](w *whereClause, set filter.Set[F], f F) *whereClause {
+	set.Where(f, func(cond string, args ...any) { w.and(cond, args...) })
+	return w
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var placeholderRe = regexp.MustCompile(`\$(\d+)`)
+
+func TestWhereClause(t *testing.T) {
+	where, args := newWhere([]any{int64(100)}, "a.deleted_at IS NULL").
+		and("a.user_id = ?", int64(7)).
+		and("a.created_at BETWEEN ? AND ?", "2025-01-01", "2025-02-01").
+		build()
+	if where != "a.deleted_at IS NULL AND a.user_id = $2 AND a.created_at BETWEEN $3 AND $4" {
+		t.Fatalf("where = %q", where)
+	}
+	if !reflect.DeepEqual(args, []any{int64(100), int64(7), "2025-01-01", "2025-02-01"}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	if where, args := newWhere(nil).build(); where != "1=1" || len(args) != 0 {
+		t.Fatalf("empty clause: %q %v", where, args)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic on a placeholder mismatch")
+		}
+	}()
+	newWhere(nil).and("a.user_id = ?")
+}
+
+// Номера плейсхолдеров идут подряд при любом наборе фильтров, в том числе
+// после аргументов самого запроса (например, LIMIT).
+func TestFiltersWhere(t *testing.T) {
+	id, stage, dep, status := "cp-1", "court", int64(3), int64(0)
+	typeID := "sms"
+	confirmed := 1
+
+	check := func(name, where string, args []any, placeholders int) {
+		t.Helper()
+		var got []string
+		for _, m := range placeholderRe.FindAllStringSubmatch(where, -1) {
+			got = append(got, m[1])
+		}
+		var want []string
+		for i := len(args) - placeholders + 1; i <= len(args); i++ {
+			want = append(want, strconv.Itoa(i))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: placeholders %v, want %v: %s", name, got, want, where)
+		}
+	}
+
+	where, args := debtsWhere(newWhere(nil), DebtsFilter{CounterpartyID: &id, DepartmentID: &dep, Stage: &stage}).build()
+	check("debts", where, args, 3)
+	if args[0] != id || args[1] != dep || args[2] != stage {
+		t.Fatalf("debts args: %v", args)
+	}
+
+	where, args = actionsWhere(newWhere([]any{int64(10)}, "a.deleted_at IS NULL"), ActionsFilter{
+		CounterpartyID: &id, StatusID: &status, TypeID: &typeID, TypeIDs: []string{"a", "b"}, DepartmentID: &dep,
+	}).build()
+	check("actions", where, args, 5)
+	if !strings.Contains(where, "d.status_id = $3") || !strings.Contains(where, "a.type = ANY($5)") || !strings.Contains(where, "du.department_id = $6") {
+		t.Fatalf("actions where: %s", where)
+	}
+
+	where, args = paymentsWhere(newWhere(nil), PaymentsFilter{Confirmed: &confirmed, CounterpartyID: &id}).build()
+	check("payments", where, args, 2)
+	if args[0] != true {
+		t.Fatalf("confirmed is not a bool: %v", args)
+	}
+}
+
+// latest_per_debt: список (DISTINCT ON) и подсчёт (COUNT(DISTINCT)) должны
+// видеть одни и те же строки, поэтому действия без долга отбрасываются.
+func TestActionsListWhere(t *testing.T) {
+	typeID := "call"
+	where, args := actionsListWhere(ActionsFilter{LatestPerDebt: true, TypeID: &typeID})
+	if where != "a.deleted_at IS NULL AND a.debt_id IS NOT NULL AND a.type = $1" || !reflect.DeepEqual(args, []any{"call"}) {
+		t.Fatalf("latest per debt: %q %v", where, args)
+	}
+	if actionsCountExpr(ActionsFilter{LatestPerDebt: true}) != "COUNT(DISTINCT a.debt_id)" {
+		t.Fatal("latest per debt is not counted by debt")
+	}
+
+	where, _ = actionsListWhere(ActionsFilter{TypeID: &typeID})
+	if where != "a.deleted_at IS NULL AND a.type = $1" {
+		t.Fatalf("all actions: %q", where)
+	}
+}
+
+// Отдел долга — это отдел закреплённого сотрудника (d.user_id): запрос
+// поручителей не соединяет users, поэтому условие не может ссылаться на u.id.
+func TestDebtsDepartmentWhere(t *testing.T) {
+	dep := int64(3)
+	where, args := debtsWhere(newWhere(nil, "g.deleted_at IS NULL"), DebtsFilter{DepartmentID: &dep}).build()
+	where = strings.Join(strings.Fields(where), " ")
+
+	want := "g.deleted_at IS NULL AND EXISTS ( SELECT 1 FROM department_user du WHERE du.user_id = d.user_id AND du.department_id = $1 )"
+	if where != want {
+		t.Fatalf("where = %q, want %q", where, want)
+	}
+	if !reflect.DeepEqual(args, []any{int64(3)}) {
+		t.Fatalf("args = %v", args)
+	}
+}
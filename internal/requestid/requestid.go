@@ -0,0 +1,30 @@
+// Package requestid carries the HTTP request id (set by chi's RequestID
+// middleware) into background export jobs, status records and WS events.
+package requestid
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// FromContext returns the request id of ctx or "" if there is none.
+func FromContext(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+// WithID stores id as the request id, e.g. for jobs started outside of a request.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, middleware.RequestIDKey, id)
+}
+
+// Logf is log.Printf prefixed with the request id of ctx.
+func Logf(ctx context.Context, format string, args ...any) {
+	if id := FromContext(ctx); id != "" {
+		log.Printf("[req:%s] %s", id, fmt.Sprintf(format, args...))
+		return
+	}
+	log.Printf(format, args...)
+}
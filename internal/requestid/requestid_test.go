@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestWithID(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Fatalf("id without a request = %q", id)
+	}
+	if id := FromContext(WithID(context.Background(), "host/abc-000001")); id != "host/abc-000001" {
+		t.Fatalf("id = %q", id)
+	}
+}
+
+func TestFromContext_Middleware(t *testing.T) {
+	var got string
+	h := middleware.RequestID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	// id клиента сохраняется, чтобы его можно было найти в логах обеих сторон
+	req := httptest.NewRequest(http.MethodPost, "/export/debts", nil)
+	req.Header.Set(middleware.RequestIDHeader, "frontend-42")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "frontend-42" {
+		t.Fatalf("id = %q", got)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/export/debts", nil))
+	if got == "" {
+		t.Fatal("no id generated for a request without one")
+	}
+}
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+
+	Logf(WithID(context.Background(), "req-1"), "export %s started", "exports:1")
+	Logf(context.Background(), "export %s started", "exports:2")
+
+	want := "[req:req-1] export exports:1 started\nexport exports:2 started\n"
+	if buf.String() != want {
+		t.Fatalf("log = %q, want %q", buf.String(), want)
+	}
+}
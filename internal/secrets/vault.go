@@ -0,0 +1,171 @@
+// Package secrets fetches service credentials from HashiCorp Vault (KV v2)
+// through its HTTP API.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"debtster-export/internal/config"
+)
+
+type VaultClient struct {
+	addr  string
+	token string
+	mount string
+	http  *http.Client
+}
+
+// NewVaultClient returns a client for the KV v2 engine mounted at mount ("secret" by default).
+func NewVaultClient(addr, token, mount string) *VaultClient {
+	if mount == "" {
+		mount = "secret"
+	}
+	return &VaultClient{
+		addr:  strings.TrimRight(addr, "/"),
+		token: token,
+		mount: strings.Trim(mount, "/"),
+		http:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ReadKV returns the latest version of the secret at path as string values.
+func (c *VaultClient) ReadKV(ctx context.Context, path string) (map[string]string, error) {
+	url := fmt.Sprintf("%s/v1/%s/data/%s", c.addr, c.mount, strings.Trim(path, "/"))
+
+	var resp struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, url, nil, &resp); err != nil {
+		return nil, fmt.Errorf("vault read %s: %w", path, err)
+	}
+
+	out := make(map[string]string, len(resp.Data.Data))
+	for k, v := range resp.Data.Data {
+		out[k] = fmt.Sprint(v)
+	}
+	return out, nil
+}
+
+// RenewSelf extends the lease of the client token.
+func (c *VaultClient) RenewSelf(ctx context.Context) (time.Duration, error) {
+	var resp struct {
+		Auth struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		} `json:"auth"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.addr+"/v1/auth/token/renew-self", map[string]any{}, &resp); err != nil {
+		return 0, fmt.Errorf("vault token renew: %w", err)
+	}
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// KeepTokenAlive renews the token every interval until ctx is done.
+func (c *VaultClient) KeepTokenAlive(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ttl, err := c.RenewSelf(ctx)
+			if err != nil {
+				log.Printf("[VAULT] %v", err)
+				continue
+			}
+			if ttl > 0 && ttl < interval {
+				log.Printf("[VAULT] token lease %s is shorter than renew interval %s", ttl, interval)
+			}
+		}
+	}
+}
+
+func (c *VaultClient) do(ctx context.Context, method, url string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ApplyTo overrides the credentials in cfg with the secrets at the configured
+// Vault paths. Expected keys: postgres {username, password}, redis {password},
+// telephony S3 {access_key, secret_key}; missing keys keep the env value.
+func (c *VaultClient) ApplyTo(ctx context.Context, cfg *config.AppConfig) error {
+	v := cfg.Vault
+
+	if v.PostgresPath != "" {
+		s, err := c.ReadKV(ctx, v.PostgresPath)
+		if err != nil {
+			return err
+		}
+		setIfPresent(&cfg.Postgres.User, s, "username")
+		setIfPresent(&cfg.Postgres.Password, s, "password")
+	}
+
+	if v.RedisPath != "" {
+		s, err := c.ReadKV(ctx, v.RedisPath)
+		if err != nil {
+			return err
+		}
+		setIfPresent(&cfg.Redis.Password, s, "password")
+	}
+
+	if v.TelephonyS3Path != "" {
+		s, err := c.ReadKV(ctx, v.TelephonyS3Path)
+		if err != nil {
+			return err
+		}
+		setIfPresent(&cfg.Telephony.AccessKey, s, "access_key")
+		setIfPresent(&cfg.Telephony.SecretKey, s, "secret_key")
+	}
+
+	return nil
+}
+
+func setIfPresent(dst *string, secret map[string]string, key string) {
+	if v, ok := secret[key]; ok && v != "" {
+		*dst = v
+	}
+}
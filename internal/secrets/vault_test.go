@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"debtster-export/internal/config"
+)
+
+// fakeVault serves KV v2 secrets of mount by path and counts token renewals.
+type fakeVault struct {
+	mount   string
+	secrets map[string]map[string]any
+	renews  atomic.Int32
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "s.token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/auth/token/renew-self" {
+		v.renews.Add(1)
+		_, _ = w.Write([]byte(`{"auth":{"lease_duration":3600,"renewable":true}}`))
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/"+v.mount+"/data/")
+	secret, found := v.secrets[path]
+	if r.Method != http.MethodGet || !ok || !found {
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": secret}})
+}
+
+func newFakeVault(t *testing.T, mount string) (*fakeVault, *httptest.Server) {
+	v := &fakeVault{mount: mount, secrets: map[string]map[string]any{
+		"debtster/pg":    {"username": "export", "password": "pg-secret"},
+		"debtster/redis": {"password": "redis-secret"},
+		// ключ без значения не затирает значение из env
+		"debtster/s3":  {"access_key": "AKIA", "secret_key": ""},
+		"debtster/num": {"port": 6432},
+	}}
+	srv := httptest.NewServer(v)
+	t.Cleanup(srv.Close)
+	return v, srv
+}
+
+func TestVaultClient_ReadKV(t *testing.T) {
+	_, srv := newFakeVault(t, "kv")
+
+	// адрес со слешем и путь со слешами нормализуются
+	c := NewVaultClient(srv.URL+"/", "s.token", "/kv/")
+	got, err := c.ReadKV(context.Background(), "/debtster/num/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["port"] != "6432" {
+		t.Fatalf("secret = %v", got)
+	}
+
+	if _, err := c.ReadKV(context.Background(), "debtster/missing"); err == nil || !strings.Contains(err.Error(), "vault read debtster/missing: unexpected status 404") {
+		t.Fatalf("err = %v", err)
+	}
+
+	bad := NewVaultClient(srv.URL, "wrong", "kv")
+	if _, err := bad.ReadKV(context.Background(), "debtster/pg"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestVaultClient_DefaultMount(t *testing.T) {
+	_, srv := newFakeVault(t, "secret")
+
+	got, err := NewVaultClient(srv.URL, "s.token", "").ReadKV(context.Background(), "debtster/redis")
+	if err != nil || got["password"] != "redis-secret" {
+		t.Fatalf("secret = %v, err = %v", got, err)
+	}
+}
+
+func TestVaultClient_ApplyTo(t *testing.T) {
+	_, srv := newFakeVault(t, "secret")
+	c := NewVaultClient(srv.URL, "s.token", "secret")
+
+	cfg := config.AppConfig{
+		Postgres: config.PostgresConfig{User: "env-user", Password: "env-pass"},
+		Redis:    config.RedisConfig{Password: "env-redis"},
+		Vault: config.VaultConfig{
+			PostgresPath:    "debtster/pg",
+			RedisPath:       "debtster/redis",
+			TelephonyS3Path: "debtster/s3",
+		},
+	}
+	cfg.Telephony.SecretKey = "env-s3-secret"
+
+	if err := c.ApplyTo(context.Background(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Postgres.User != "export" || cfg.Postgres.Password != "pg-secret" || cfg.Redis.Password != "redis-secret" {
+		t.Fatalf("credentials = %+v %+v", cfg.Postgres, cfg.Redis)
+	}
+	if cfg.Telephony.AccessKey != "AKIA" || cfg.Telephony.SecretKey != "env-s3-secret" {
+		t.Fatalf("telephony = %q %q", cfg.Telephony.AccessKey, cfg.Telephony.SecretKey)
+	}
+
+	// без пути секрет не запрашивается, ошибка чтения останавливает запуск
+	cfg = config.AppConfig{Postgres: config.PostgresConfig{Password: "env-pass"}, Vault: config.VaultConfig{RedisPath: "debtster/missing"}}
+	if err := c.ApplyTo(context.Background(), &cfg); err == nil {
+		t.Fatal("expected the error of the missing secret")
+	}
+	if cfg.Postgres.Password != "env-pass" {
+		t.Fatalf("password = %q", cfg.Postgres.Password)
+	}
+}
+
+func TestVaultClient_KeepTokenAlive(t *testing.T) {
+	v, srv := newFakeVault(t, "secret")
+	c := NewVaultClient(srv.URL, "s.token", "")
+
+	ttl, err := c.RenewSelf(context.Background())
+	if err != nil || ttl != time.Hour {
+		t.Fatalf("ttl = %s, err = %v", ttl, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.KeepTokenAlive(ctx, 5*time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for v.renews.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if n := v.renews.Load(); n < 3 {
+		t.Fatalf("renewed %d times", n)
+	}
+
+	// нулевой интервал — продление выключено
+	c.KeepTokenAlive(context.Background(), 0)
+}
@@ -4,52 +4,117 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
 	"strings"
 	"time"
 
-	"debtster-export/internal/clients"
 	"debtster-export/internal/domain"
 	"debtster-export/internal/repository"
+	"debtster-export/internal/requestid"
 
-	"github.com/google/uuid"
 	"github.com/xuri/excelize/v2"
 )
 
 type ActionRepository interface {
 	List(ctx context.Context, f repository.ActionsFilter) ([]domain.Action, error)
 	HasMoreThan(ctx context.Context, limit int64, f repository.ActionsFilter) (bool, error)
+	Count(ctx context.Context, f repository.ActionsFilter) (int64, error)
+	DailyCounts(ctx context.Context, f repository.ActionsFilter, groupBy repository.ActionsGroupBy) ([]domain.ActionDailyCount, error)
+}
+
+// RecordingPresigner turns call-recording object keys into temporary download links.
+type RecordingPresigner interface {
+	PresignGet(ctx context.Context, key string) (string, error)
 }
 
 type ActionService struct {
-	repo        ActionRepository
-	redis       *clients.RedisClient
-	s3          *clients.StorageClient
-	ws          *clients.WebSocketClient
-	cachePrefix string
+	exporter
+
+	repo       ActionRepository
+	recordings RecordingPresigner
+	queue      *JobQueue
+	// progressEvery is the least time between two generating progress reports
+	progressEvery time.Duration
+	filenameTpl   string
+	links         LinkTemplates
+	templates     TemplateStore
+	types         ActionTypeDirectory
+	names         FilterNameDirectory
 }
 
+// NewActionService creates the actions exporter; recordings may be nil, in which
+// case recording references are exported as stored in the payload.
 func NewActionService(
 	repo ActionRepository,
-	redis *clients.RedisClient,
-	s3 *clients.StorageClient,
-	ws *clients.WebSocketClient,
+	redis Cache,
+	s3 FileStorage,
+	ws Notifier,
+	recordings RecordingPresigner,
 ) *ActionService {
 	return &ActionService{
-		repo:        repo,
-		redis:       redis,
-		s3:          s3,
-		ws:          ws,
-		cachePrefix: "pkb_database_cache",
+		repo:       repo,
+		exporter:   newExporter(redis, s3, ws),
+		recordings: recordings,
 	}
 }
 
+// SetRetryPolicy overrides the backoff used for transient DB and storage failures.
+func (s *ActionService) SetRetryPolicy(p RetryPolicy) {
+	s.retry = p
+}
+
+// SetJobRunner makes exports run on the shared worker pool watched for stalls.
+func (s *ActionService) SetJobRunner(r *JobRunner) {
+	s.jobs = r
+}
+
+// SetJobQueue makes exports run on the instances consuming q instead of the
+// one that started them.
+func (s *ActionService) SetJobQueue(q *JobQueue) {
+	s.queue = q
+	q.handle(s.runQueued, s.failExport, "actions", actionsDailyType)
+}
+
+// SetOutbox makes final statuses go through o, which retries failed writes.
+func (s *ActionService) SetOutbox(o *Outbox) {
+	s.outbox = o
+}
+
+// SetProgressInterval throttles the progress saved and sent while rows are
+// generated to one report per d; the last row is always reported.
+func (s *ActionService) SetProgressInterval(d time.Duration) {
+	s.progressEvery = d
+}
+
+// SetFilenameTemplate sets the naming template of generated files, see
+// DefaultFilenameTemplate for the placeholders; empty keeps the default.
+func (s *ActionService) SetFilenameTemplate(tpl string) {
+	s.filenameTpl = tpl
+}
+
+// SetDeliverers enables the delivery types exports may request.
+func (s *ActionService) SetDeliverers(d Deliverers) {
+	s.deliverers = d
+}
+
+// SetTemplateStore enables rendering exports into XLSX templates.
+func (s *ActionService) SetTemplateStore(ts TemplateStore) {
+	s.templates = ts
+}
+
+// SetLinkTemplates makes debt cells link back to the CRM, see LinkTemplates.
+func (s *ActionService) SetLinkTemplates(t LinkTemplates) {
+	s.links = t
+}
+
 type ActionColumn struct {
 	Header string
 	Value  func(a domain.Action) any
+	// Link, when set, makes the cell a hyperlink to the returned URL (if non-empty).
+	Link func(a domain.Action, links LinkTemplates) string
 }
 
+// actionTypeDisplay names the call types when the action type dictionary is
+// unavailable or lacks them.
 var actionTypeDisplay = map[string]string{
 	"incoming_call": "Входящий звонок",
 	"outgoing_call": "Исходящий звонок",
@@ -61,6 +126,9 @@ var actionColumns = map[string]ActionColumn{
 		Value: func(a domain.Action) any {
 			return a.DebtID
 		},
+		Link: func(a domain.Action, links LinkTemplates) string {
+			return links.debt(a.DebtID, strPtr(a.DebtNumber))
+		},
 	},
 	"user_id": {
 		Header: "ID пользователя",
@@ -77,6 +145,9 @@ var actionColumns = map[string]ActionColumn{
 			}
 			return *a.DebtNumber
 		},
+		Link: func(a domain.Action, links LinkTemplates) string {
+			return links.debt(a.DebtID, strPtr(a.DebtNumber))
+		},
 	},
 	"debt.counterparty.name": {
 		Header: "Контрагент",
@@ -100,13 +171,19 @@ var actionColumns = map[string]ActionColumn{
 	"debt_status_id": {
 		Header: "ID статуса долга",
 		Value: func(a domain.Action) any {
-			return a.DebtStatusID
+			if a.DebtStatusID == nil {
+				return ""
+			}
+			return *a.DebtStatusID
 		},
 	},
 
 	"actionType.name": {
 		Header: "Тип действия",
 		Value: func(a domain.Action) any {
+			if a.TypeName != nil {
+				return *a.TypeName
+			}
 			if title, ok := actionTypeDisplay[a.Type]; ok {
 				return title
 			}
@@ -219,6 +296,18 @@ var actionColumns = map[string]ActionColumn{
 			return *a.PayloadDatePromisedPayment
 		},
 	},
+	"payload.recording_url": {
+		Header: "Запись звонка",
+		Value: func(a domain.Action) any {
+			return strPtr(a.PayloadRecordingURL)
+		},
+		Link: func(a domain.Action, _ LinkTemplates) string {
+			if a.PayloadRecordingURL == nil || !isAbsoluteURL(*a.PayloadRecordingURL) {
+				return ""
+			}
+			return *a.PayloadRecordingURL
+		},
+	},
 	"payload.amount_promised_payment": {
 		Header: "Сумма обещанного платежа",
 		Value: func(a domain.Action) any {
@@ -269,52 +358,18 @@ var actionColumns = map[string]ActionColumn{
 
 const maxActionsForExport = 500_000
 
-func (s *ActionService) saveExportStatus(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-
-	data, err := json.Marshal(st)
-	if err != nil {
-		return err
-	}
-
-	if err := s.redis.Set(ctx, st.Key, string(data), exportTTL); err != nil {
-		return err
-	}
-
-	return s.redis.SAdd(ctx, exportSetKey, st.Key)
-}
-
-func (s *ActionService) toCacheItem(st *ExportStatus) ExportCacheItem {
-	created := st.Created.Format("2006-01-02 15:04:05")
-	return ExportCacheItem{
-		Key:      st.Key,
-		Type:     st.Type,
-		UserID:   st.UserID,
-		Progress: st.Progress,
-		FileURL:  st.FileURL,
-		Error:    st.Error,
-		Created:  created,
-	}
-}
-
-func (s *ActionService) saveLaravelCache(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-
-	cacheKey := s.cachePrefix + st.Key
-	item := s.toCacheItem(st)
-	serialized := phpSerializeExportItem(item)
-
-	return s.redis.Set(ctx, cacheKey, serialized, exportTTL)
+// actionsExportParams is the persisted form of an actions export request.
+type actionsExportParams struct {
+	Selected []string                 `json:"selected"`
+	Filter   repository.ActionsFilter `json:"filter"`
+	Options  ExportOptions            `json:"options"`
 }
 
 func (s *ActionService) StartActionsExport(
 	ctx context.Context,
 	selected []string,
 	filter repository.ActionsFilter,
+	opts ExportOptions,
 	userID int64,
 ) (string, error) {
 	if len(selected) == 0 {
@@ -327,70 +382,159 @@ func (s *ActionService) StartActionsExport(
 		}
 	}
 
-	tooMany, err := s.repo.HasMoreThan(ctx, maxActionsForExport, filter)
+	params := actionsExportParams{Selected: selected, Filter: filter, Options: opts}
+	return s.startActionsExport(ctx, params, userID, firstAttempt)
+}
+
+// RetryExport re-runs a failed actions export or daily actions report with its
+// original parameters.
+func (s *ActionService) RetryExport(ctx context.Context, original *ExportStatus) (string, error) {
+	if original.Type == actionsDailyType {
+		return s.retryActionsDailyReport(ctx, original)
+	}
+
+	var params actionsExportParams
+	if err := json.Unmarshal(original.Params, &params); err != nil {
+		return "", fmt.Errorf("failed to decode export params: %w", err)
+	}
+	return s.startActionsExport(ctx, params, original.UserID, nextAttempt(original))
+}
+
+// runQueued runs an actions export or daily actions report taken from the job
+// queue.
+func (s *ActionService) runQueued(ctx context.Context, status *ExportStatus) {
+	if status.Type == actionsDailyType {
+		if params, ok := queuedParams[actionsDailyParams](ctx, status, s.failExport); ok {
+			s.runActionsDailyReport(ctx, status, params)
+		}
+		return
+	}
+	if params, ok := queuedParams[actionsExportParams](ctx, status, s.failExport); ok {
+		s.runActionsExport(ctx, status, params.Selected, params.Filter, params.Options)
+	}
+}
+
+func (s *ActionService) startActionsExport(ctx context.Context, params actionsExportParams, userID int64, attempt exportAttempt) (string, error) {
+	// masked columns are dropped before anything is recorded
+	params.Options.HiddenColumns = withCallerMasks(ctx, params.Options.HiddenColumns)
+	params.Selected = visibleColumns(params.Selected, params.Options.HiddenColumns)
+
+	if err := s.deliverers.check(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if err := s.deliverers.checkSink(params.Options); err != nil {
+		return "", err
+	}
+	if err := checkTemplate(ctx, s.templates, params.Options.Template); err != nil {
+		return "", err
+	}
+	if err := checkOutput(params.Options); err != nil {
+		return "", err
+	}
+	if _, err := actionComputedColumns(params.Options.Computed, params.Options.HiddenColumns); err != nil {
+		return "", err
+	}
+
+	tooMany, err := s.repo.HasMoreThan(ctx, maxActionsForExport, params.Filter)
 	if err != nil {
 		return "", err
 	}
 	if tooMany {
-		return "", fmt.Errorf("слишком много действий для экспорта (больше %d записей)", maxActionsForExport)
+		return "", tooManyRows("действий", maxActionsForExport)
 	}
 
-	exportID := fmt.Sprintf("exports:%s", uuid.NewString())
-	now := time.Now()
+	status := newExportStatus(
+		ctx,
+		"actions",
+		userID,
+		s.buildActionsFiltersMap(ctx, params.Filter, params.Selected),
+		params,
+		attempt,
+	)
+	status.Delivery = newDeliveryStatus(params.Options.Delivery)
+	status.Comment = params.Options.Comment
 
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "actions",
-		UserID:   userID,
-		Filters:  buildActionsFiltersMap(filter, selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  now,
+	_ = s.saveExportStatus(ctx, status)
+
+	fail := func(ctx context.Context, errStr string) { s.failExport(ctx, status, errStr) }
+	if err := runJob(ctx, s.jobs, s.queue, status, fail, func(ctx context.Context) {
+		s.runActionsExport(ctx, status, params.Selected, params.Filter, params.Options)
+	}); err != nil {
+		return "", err
 	}
 
-	_ = s.saveExportStatus(ctx, status)
-	_ = s.saveLaravelCache(ctx, status)
+	return status.Key, nil
+}
 
-	go s.runActionsExport(context.Background(), exportID, selected, filter, userID, now)
+// EstimateActionsExport counts the rows an export with these parameters would produce.
+func (s *ActionService) EstimateActionsExport(ctx context.Context, selected []string, filter repository.ActionsFilter) (ExportEstimate, error) {
+	rows, err := s.repo.Count(ctx, filter)
+	if err != nil {
+		return ExportEstimate{}, err
+	}
+
+	columns := 0
+	for _, key := range visibleColumns(selected, withCallerMasks(ctx, nil)) {
+		if _, ok := actionColumns[key]; ok {
+			columns++
+		}
+	}
 
-	return exportID, nil
+	return newExportEstimate(rows, columns, maxActionsForExport), nil
 }
 
 func (s *ActionService) runActionsExport(
 	ctx context.Context,
-	exportID string,
+	status *ExportStatus,
 	selected []string,
 	filter repository.ActionsFilter,
-	userID int64,
-	createdAt time.Time,
+	opts ExportOptions,
 ) {
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "actions",
-		UserID:   userID,
-		Filters:  buildActionsFiltersMap(filter, selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  createdAt,
-	}
+	status.enter(ExportRunning)
+	exportID, userID := status.Key, status.UserID
 
-	actions, err := s.repo.List(ctx, filter)
+	var actions []domain.Action
+	err := s.retry.Do(ctx, "export "+exportID+": query", func() error {
+		var err error
+		actions, err = s.repo.List(ctx, filter)
+		return err
+	})
 	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("query failed: %v", err))
 		return
 	}
 
 	var cols []ActionColumn
-	for _, key := range selected {
+	for _, key := range visibleColumns(selected, opts.HiddenColumns) {
 		col, ok := actionColumns[key]
 		if !ok {
 			continue
 		}
 		cols = append(cols, col)
 	}
+	computed, err := actionComputedColumns(opts.Computed, opts.HiddenColumns)
+	if err != nil {
+		s.failExport(ctx, status, err.Error())
+		return
+	}
+	cols = append(cols, computed...)
 	if len(cols) == 0 {
+		s.failExport(ctx, status, "no valid columns selected")
 		return
 	}
 
+	if containsString(selected, "payload.recording_url") {
+		s.presignRecordings(ctx, actions)
+	}
+	if containsString(selected, "actionType.name") {
+		names := s.actionTypeNames(ctx)
+		for i := range actions {
+			if name, ok := names[actions[i].Type]; ok {
+				actions[i].TypeName = &name
+			}
+		}
+	}
+
 	f := excelize.NewFile()
 	sheet := "Actions"
 	f.SetSheetName(f.GetSheetName(0), sheet)
@@ -407,29 +551,31 @@ func (s *ActionService) runActionsExport(
 	rowIdx := 2
 	total := len(actions)
 	if total > 0 {
-		chunkSize := 1000
+		pacer := newRowPacer(total, s.progressEvery)
 		for i, a := range actions {
 			for colIdx, col := range cols {
 				cell, _ := excelize.CoordinatesToCellName(colIdx+1, rowIdx)
 				_ = f.SetCellValue(sheet, cell, col.Value(a))
+				if col.Link != nil {
+					if link := col.Link(a, s.links); link != "" {
+						_ = f.SetCellHyperLink(sheet, cell, link, "External")
+					}
+				}
 			}
 			rowIdx++
 
 			// update progress periodically
-			if (i+1)%chunkSize == 0 || i == total-1 {
-				raw := float64(i+1) / float64(total) * 100.0
-				progress := math.Round(raw)
-				// never report 100% here — reserve 100% for when file_url is ready
-				if progress >= 100 {
-					progress = 95
+			if pacer.due(i + 1) {
+				if err := ctx.Err(); err != nil {
+					s.failExport(ctx, status, err.Error())
+					return
 				}
 
-				status.Progress = progress
+				eventCtx := pacer.report(ctx, status, i+1)
 				_ = s.saveExportStatus(ctx, status)
-				_ = s.saveLaravelCache(ctx, status)
 
 				if s.ws != nil {
-					_ = s.ws.NotifyExportProgress(ctx, userID, exportID, progress, "generating")
+					_ = s.ws.NotifyExportProgress(eventCtx, userID, exportID, status.Progress, "generating")
 				}
 			}
 		}
@@ -442,99 +588,115 @@ func (s *ActionService) runActionsExport(
 			rowIdx++
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		s.failExport(ctx, status, err.Error())
+		return
+	}
+
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = col.Header
+	}
+	if err := writeInfoSheet(f, status, headers, len(actions), time.Now()); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
+	}
+
 	buf, err := f.WriteToBuffer()
 	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
+	}
+	data, err := applyTemplate(ctx, s.templates, opts.Template, buf.Bytes(), templateVars{Type: "actions", UserID: userID, ExportID: exportID, Comment: opts.Comment})
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("render template failed: %v", err))
+		return
+	}
+	if data, err = applyOutput(opts, data, sampleColumns(cols)); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write output failed: %v", err))
 		return
 	}
-	data := buf.Bytes()
-
-	fileName := fmt.Sprintf("actions_%s.xlsx", time.Now().Format("20060102_150405"))
 
-	if s.s3 != nil {
-		// notify upload phase before starting upload
-		status.Progress = 95
-		_ = s.saveExportStatus(ctx, status)
-		_ = s.saveLaravelCache(ctx, status)
-		if s.ws != nil {
-			_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 95, "uploading")
-		}
+	if s.deliverers.sink(opts.Delivery) != nil {
+		s.sinkExport(ctx, status, opts, data, sinkColumnNames(selected, actionColumns, opts.Computed), sampleColumns(cols))
+		return
+	}
 
-		savedName, err := s.s3.Save(ctx, fileName, data)
-		if err != nil {
-			errStr := fmt.Sprintf("save export failed: %v", err)
-			log.Printf("export %s: %s", exportID, errStr)
-			status.Error = &errStr
-			status.Progress = 100
+	fileName := exportFilename(s.filenameTpl, opts, filenameFields{
+		Type:         "actions",
+		UserID:       userID,
+		Counterparty: filter.CounterpartyID,
+		DateFrom:     filter.CreatedFrom,
+		DateTo:       filter.CreatedTo,
+		ExportID:     exportID,
+	})
 
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
+	s.saveExportFile(ctx, status, opts, fileName, data)
+}
 
-			if s.ws != nil {
-				_ = s.ws.NotifyExportFailed(ctx, userID, exportID, errStr)
-			}
-		} else {
-			url := s.s3.GetURL(savedName)
-			status.FileURL = &url
-			status.Progress = 100
+// presignRecordings replaces storage keys in recording references with presigned
+// URLs. Values that are already absolute URLs are left untouched, and keys that
+// fail to presign are kept as-is so the export still completes.
+func (s *ActionService) presignRecordings(ctx context.Context, actions []domain.Action) {
+	if s.recordings == nil {
+		return
+	}
 
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
+	failed := 0
+	for i := range actions {
+		ref := actions[i].PayloadRecordingURL
+		if ref == nil || *ref == "" || isAbsoluteURL(*ref) {
+			continue
+		}
 
-			if s.ws != nil {
-				_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 100, "ready")
-				_ = s.ws.NotifyExportComplete(ctx, userID, exportID, url, fileName)
+		url, err := s.recordings.PresignGet(ctx, *ref)
+		if err != nil {
+			if failed == 0 {
+				requestid.Logf(ctx, "presign recording %q: %v", *ref, err)
 			}
+			failed++
+			continue
 		}
+		actions[i].PayloadRecordingURL = &url
 	}
-}
 
-func buildActionsFiltersMap(f repository.ActionsFilter, fields []string) map[string]interface{} {
-	m := map[string]interface{}{}
-	if f.UserID != nil {
-		m["user_id"] = *f.UserID
-	} else {
-		m["user_id"] = nil
-	}
-	if f.DebtStatusID != nil {
-		m["debt_status_id"] = *f.DebtStatusID
-	} else {
-		m["debt_status_id"] = nil
-	}
-	if f.CounterpartyID != nil {
-		m["counterparty_id"] = *f.CounterpartyID
-	} else {
-		m["counterparty_id"] = nil
-	}
-	if f.DepartmentID != nil {
-		m["department_id"] = *f.DepartmentID
-	} else {
-		m["department_id"] = nil
-	}
-	if f.TypeID != nil {
-		m["type_id"] = *f.TypeID
-	} else {
-		m["type_id"] = nil
-	}
-	if f.CreatedFrom != nil {
-		m["create_start_date"] = f.CreatedFrom.Format("2006-01-02")
-	} else {
-		m["create_start_date"] = nil
+	if failed > 0 {
+		requestid.Logf(ctx, "presign recordings: %d of %d references left unsigned", failed, len(actions))
 	}
-	if f.CreatedTo != nil {
-		m["create_end_date"] = f.CreatedTo.Format("2006-01-02")
-	} else {
-		m["create_end_date"] = nil
-	}
-	if f.NextContactFrom != nil {
-		m["next_contact_start_date"] = f.NextContactFrom.Format("2006-01-02")
-	} else {
-		m["next_contact_start_date"] = nil
-	}
-	if f.NextContactTo != nil {
-		m["next_contact_end_date"] = f.NextContactTo.Format("2006-01-02")
-	} else {
-		m["next_contact_end_date"] = nil
+}
+
+func isAbsoluteURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
 	}
+	return false
+}
+
+func (s *ActionService) buildActionsFiltersMap(ctx context.Context, f repository.ActionsFilter, fields []string) map[string]interface{} {
+	m := repository.ActionsFilters.Map(f)
+	addFilterNames(ctx, s.names, m, repository.ActionsFilters.Refs(f))
 	m["fields"] = fields
 	return m
 }
+
+// actionComputedColumns compiles the computed columns of a actions export over actionColumns.
+func actionComputedColumns(defs []ComputedColumn, hidden []string) ([]ActionColumn, error) {
+	computed, err := compileComputedColumns(defs, func(key string) (func(domain.Action) any, bool) {
+		col, ok := actionColumns[key]
+		return col.Value, ok && !columnHidden(hidden, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ActionColumn, 0, len(computed))
+	for _, c := range computed {
+		out = append(out, ActionColumn{Header: c.Header, Value: c.Value})
+	}
+	return out, nil
+}
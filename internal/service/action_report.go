@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// actionsDailyType is the export type of the daily actions report.
+const actionsDailyType = "actions_daily"
+
+// actionsDailyParams is the persisted form of a daily actions report request.
+type actionsDailyParams struct {
+	Filter  repository.ActionsFilter  `json:"filter"`
+	GroupBy repository.ActionsGroupBy `json:"group_by"`
+	Options ExportOptions             `json:"options"`
+}
+
+// StartActionsDailyReport exports the number of actions matching filter as a
+// matrix: one row per day, one column per user or action type (groupBy) and
+// totals. Counting happens in the database, so the report has no row limit.
+func (s *ActionService) StartActionsDailyReport(
+	ctx context.Context,
+	filter repository.ActionsFilter,
+	groupBy repository.ActionsGroupBy,
+	opts ExportOptions,
+	userID int64,
+) (string, error) {
+	if groupBy == "" {
+		groupBy = repository.ActionsGroupByUser
+	}
+	params := actionsDailyParams{Filter: filter, GroupBy: groupBy, Options: opts}
+	return s.startActionsDailyReport(ctx, params, userID, firstAttempt)
+}
+
+func (s *ActionService) retryActionsDailyReport(ctx context.Context, original *ExportStatus) (string, error) {
+	var params actionsDailyParams
+	if err := json.Unmarshal(original.Params, &params); err != nil {
+		return "", fmt.Errorf("failed to decode export params: %w", err)
+	}
+	return s.startActionsDailyReport(ctx, params, original.UserID, nextAttempt(original))
+}
+
+func (s *ActionService) startActionsDailyReport(ctx context.Context, params actionsDailyParams, userID int64, attempt exportAttempt) (string, error) {
+	if params.GroupBy != repository.ActionsGroupByUser && params.GroupBy != repository.ActionsGroupByType {
+		return "", fmt.Errorf("unknown report grouping %q", params.GroupBy)
+	}
+	if err := s.deliverers.check(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if err := s.deliverers.checkFileDelivery(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if len(params.Options.Computed) > 0 {
+		return "", fmt.Errorf("%w: reports have no computed columns", ErrInvalidComputedColumn)
+	}
+	if params.Options.Template != "" {
+		return "", fmt.Errorf("%w: reports have their own layout", ErrInvalidTemplate)
+	}
+
+	filters := s.buildActionsFiltersMap(ctx, params.Filter, nil)
+	delete(filters, "fields")
+	filters["group_by"] = string(params.GroupBy)
+
+	status := newExportStatus(ctx, actionsDailyType, userID, filters, params, attempt)
+	status.Delivery = newDeliveryStatus(params.Options.Delivery)
+	status.Comment = params.Options.Comment
+
+	_ = s.saveExportStatus(ctx, status)
+
+	fail := func(ctx context.Context, errStr string) { s.failExport(ctx, status, errStr) }
+	if err := runJob(ctx, s.jobs, s.queue, status, fail, func(ctx context.Context) {
+		s.runActionsDailyReport(ctx, status, params)
+	}); err != nil {
+		return "", err
+	}
+
+	return status.Key, nil
+}
+
+func (s *ActionService) runActionsDailyReport(ctx context.Context, status *ExportStatus, params actionsDailyParams) {
+	status.enter(ExportRunning)
+	exportID, userID := status.Key, status.UserID
+
+	var counts []domain.ActionDailyCount
+	err := s.retry.Do(ctx, "export "+exportID+": query", func() error {
+		var err error
+		counts, err = s.repo.DailyCounts(ctx, params.Filter, params.GroupBy)
+		return err
+	})
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("query failed: %v", err))
+		return
+	}
+
+	if params.GroupBy == repository.ActionsGroupByType {
+		names := s.actionTypeNames(ctx)
+		for i := range counts {
+			if name, ok := names[counts[i].Group]; ok {
+				counts[i].Label = name
+			}
+		}
+	}
+
+	status.Progress = 50
+	_ = s.saveExportStatus(ctx, status)
+	if s.ws != nil {
+		_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 50, "generating")
+	}
+
+	data, err := writeActionsDailyReport(status, params.GroupBy, counts, time.Now())
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
+	}
+
+	fileName := exportFilename(s.filenameTpl, params.Options, filenameFields{
+		Type:         actionsDailyType,
+		UserID:       userID,
+		Counterparty: params.Filter.CounterpartyID,
+		DateFrom:     params.Filter.CreatedFrom,
+		DateTo:       params.Filter.CreatedTo,
+		ExportID:     exportID,
+	})
+
+	s.saveExportFile(ctx, status, params.Options, fileName, data)
+}
+
+// writeActionsDailyReport pivots the counts into the report workbook. Days
+// without actions between the first and the last one get a row of zeros.
+func writeActionsDailyReport(status *ExportStatus, groupBy repository.ActionsGroupBy, counts []domain.ActionDailyCount, now time.Time) ([]byte, error) {
+	type group struct {
+		key, header string
+	}
+	var groups []group
+	groupIdx := map[string]int{}
+	perDay := map[string]map[string]int64{}
+	var first, last time.Time
+
+	for _, c := range counts {
+		if _, ok := groupIdx[c.Group]; !ok {
+			groupIdx[c.Group] = len(groups)
+			groups = append(groups, group{key: c.Group, header: actionsGroupHeader(groupBy, c)})
+		}
+		day := c.Day.Format("2006-01-02")
+		if perDay[day] == nil {
+			perDay[day] = map[string]int64{}
+		}
+		perDay[day][c.Group] += c.Count
+		if first.IsZero() || c.Day.Before(first) {
+			first = c.Day
+		}
+		if c.Day.After(last) {
+			last = c.Day
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].header) < strings.ToLower(groups[j].header)
+	})
+
+	f := excelize.NewFile()
+	sheet := "Report"
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	_ = f.SetDocProps(&excelize.DocProperties{
+		Creator: fmt.Sprintf("user_%d", status.UserID),
+	})
+
+	header := []any{"Дата"}
+	for _, g := range groups {
+		header = append(header, g.header)
+	}
+	header = append(header, "Итого")
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return nil, err
+	}
+
+	totals := make([]int64, len(groups)+1)
+	rowIdx := 2
+	for day := first; !first.IsZero() && !day.After(last); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		row := []any{key}
+		var sum int64
+		for i, g := range groups {
+			n := perDay[key][g.key]
+			row = append(row, n)
+			totals[i] += n
+			sum += n
+		}
+		row = append(row, sum)
+		totals[len(groups)] += sum
+
+		cell, _ := excelize.CoordinatesToCellName(1, rowIdx)
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			return nil, err
+		}
+		rowIdx++
+	}
+
+	totalRow := []any{"Итого"}
+	for _, n := range totals {
+		totalRow = append(totalRow, n)
+	}
+	cell, _ := excelize.CoordinatesToCellName(1, rowIdx)
+	if err := f.SetSheetRow(sheet, cell, &totalRow); err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, len(header))
+	for i, h := range header {
+		fields[i] = h.(string)
+	}
+	if err := writeInfoSheet(f, status, fields, rowIdx-2, now); err != nil {
+		return nil, err
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// actionsGroupHeader is the column header of a user or an action type.
+func actionsGroupHeader(groupBy repository.ActionsGroupBy, c domain.ActionDailyCount) string {
+	if groupBy == repository.ActionsGroupByType {
+		if c.Label != "" && c.Label != c.Group {
+			return c.Label
+		}
+		if title, ok := actionTypeDisplay[c.Group]; ok {
+			return title
+		}
+		return c.Group
+	}
+	switch {
+	case c.Label != "":
+		return c.Label
+	case c.Group == "":
+		return "Без пользователя"
+	default:
+		return "Пользователь #" + c.Group
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestWriteActionsDailyReport(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC) }
+	counts := []domain.ActionDailyCount{
+		{Day: day(1), Group: "2", Label: "Петров Пётр", Count: 3},
+		{Day: day(1), Group: "1", Label: "Иванов Иван", Count: 1},
+		{Day: day(3), Group: "1", Label: "Иванов Иван", Count: 2},
+	}
+
+	data, err := writeActionsDailyReport(&ExportStatus{UserID: 1}, repository.ActionsGroupByUser, counts, day(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("Report")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// пропущенный день заполняется нулями, последняя строка и колонка — итоги
+	want := [][]string{
+		{"Дата", "Иванов Иван", "Петров Пётр", "Итого"},
+		{"2025-03-01", "1", "3", "4"},
+		{"2025-03-02", "0", "0", "0"},
+		{"2025-03-03", "2", "0", "2"},
+		{"Итого", "3", "3", "6"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("unexpected report:\n%v\nwant:\n%v", rows, want)
+	}
+}
+
+func TestActionsGroupHeader(t *testing.T) {
+	if got := actionsGroupHeader(repository.ActionsGroupByType, domain.ActionDailyCount{Group: "incoming_call"}); got != "Входящий звонок" {
+		t.Fatalf("unexpected type header %q", got)
+	}
+	if got := actionsGroupHeader(repository.ActionsGroupByType, domain.ActionDailyCount{Group: "sms", Label: "СМС"}); got != "СМС" {
+		t.Fatalf("unexpected dictionary type header %q", got)
+	}
+	if got := actionsGroupHeader(repository.ActionsGroupByType, domain.ActionDailyCount{Group: "sms", Label: "sms"}); got != "sms" {
+		t.Fatalf("unexpected raw type header %q", got)
+	}
+	if got := actionsGroupHeader(repository.ActionsGroupByUser, domain.ActionDailyCount{Group: "7"}); got != "Пользователь #7" {
+		t.Fatalf("unexpected user header %q", got)
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service/mocks"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/mock/gomock"
+)
+
+type actionMocks struct {
+	repo      *mocks.MockActionRepository
+	cache     *mocks.MockCache
+	storage   *mocks.MockFileStorage
+	ws        *mocks.MockNotifier
+	presigner *mocks.MockRecordingPresigner
+}
+
+// runActionsExport runs an actions export of actions with the given columns
+// and returns the saved workbook.
+func runActionsExport(t *testing.T, m actionMocks, presigner RecordingPresigner, actions []domain.Action, selected []string) *excelize.File {
+	t.Helper()
+	s := NewActionService(m.repo, m.cache, m.storage, m.ws, presigner)
+	s.SetRetryPolicy(RetryPolicy{Attempts: 1, BaseDelay: time.Millisecond})
+	saved := recordStatuses(m.cache)
+	status := newExportStatus(context.Background(), "actions", 7, nil, nil, firstAttempt)
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(actions, nil)
+	var file []byte
+	m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, name string, data []byte) (string, error) {
+			file = data
+			return "abc_" + name, nil
+		})
+	m.storage.EXPECT().SetOwner(gomock.Any(), int64(7), false).Return(nil)
+	m.storage.EXPECT().GetURL(gomock.Any()).DoAndReturn(func(name string) string { return "/files/" + name })
+	m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	m.ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), status.Key, gomock.Any(), gomock.Any())
+
+	s.runActionsExport(context.Background(), status, selected, repository.ActionsFilter{}, ExportOptions{})
+
+	if final := lastStatus(t, saved); final.Error != nil || final.FileURL == nil {
+		t.Fatalf("expected completed export, got %+v", final)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("not a workbook: %v", err)
+	}
+	return f
+}
+
+func newActionMocks(t *testing.T) actionMocks {
+	ctrl := gomock.NewController(t)
+	return actionMocks{
+		repo:      mocks.NewMockActionRepository(ctrl),
+		cache:     mocks.NewMockCache(ctrl),
+		storage:   mocks.NewMockFileStorage(ctrl),
+		ws:        mocks.NewMockNotifier(ctrl),
+		presigner: mocks.NewMockRecordingPresigner(ctrl),
+	}
+}
+
+func recordingActions() []domain.Action {
+	return []domain.Action{
+		{DebtID: "d-1", Type: "call", PayloadRecordingURL: ptr("calls/rec-1.mp3")},
+		{DebtID: "d-2", Type: "call", PayloadRecordingURL: ptr("calls/rec-2.mp3")},
+		{DebtID: "d-3", Type: "call", PayloadRecordingURL: ptr("https://pbx.example.com/rec-3.mp3")},
+		{DebtID: "d-4", Type: "sms"},
+	}
+}
+
+// recordingCells returns the value and the hyperlink of the recording column (B) of every row.
+func recordingCells(t *testing.T, f *excelize.File, rows int) (values, links []string) {
+	t.Helper()
+	for row := 2; row < 2+rows; row++ {
+		cell, _ := excelize.CoordinatesToCellName(2, row)
+		v, _ := f.GetCellValue("Actions", cell)
+		_, link, _ := f.GetCellHyperLink("Actions", cell)
+		values = append(values, v)
+		links = append(links, link)
+	}
+	return values, links
+}
+
+func TestRunActionsExport_RecordingLinks(t *testing.T) {
+	m := newActionMocks(t)
+	signed := "https://s3.example.com/recordings/calls/rec-1.mp3?X-Amz-Signature=test"
+	// абсолютные ссылки и пустые значения не подписываются
+	m.presigner.EXPECT().PresignGet(gomock.Any(), "calls/rec-1.mp3").Return(signed, nil)
+	m.presigner.EXPECT().PresignGet(gomock.Any(), "calls/rec-2.mp3").Return("", errors.New("AccessDenied"))
+
+	f := runActionsExport(t, m, m.presigner, recordingActions(), []string{"debt.number", "payload.recording_url"})
+
+	values, links := recordingCells(t, f, 4)
+	// ключ, который не удалось подписать, остаётся как есть и без ссылки
+	wantValues := []string{signed, "calls/rec-2.mp3", "https://pbx.example.com/rec-3.mp3", ""}
+	wantLinks := []string{signed, "", "https://pbx.example.com/rec-3.mp3", ""}
+	for i := range wantValues {
+		if values[i] != wantValues[i] || links[i] != wantLinks[i] {
+			t.Errorf("row %d: %q linked to %q, want %q linked to %q", i+2, values[i], links[i], wantValues[i], wantLinks[i])
+		}
+	}
+}
+
+func TestRunActionsExport_RecordingsNotSelected(t *testing.T) {
+	m := newActionMocks(t)
+	m.presigner.EXPECT().PresignGet(gomock.Any(), gomock.Any()).Times(0)
+
+	f := runActionsExport(t, m, m.presigner, recordingActions(), []string{"debt.number", "type"})
+	if rows, _ := f.GetRows("Actions"); len(rows) != 5 {
+		t.Fatalf("rows = %q", rows)
+	}
+}
+
+func TestRunActionsExport_RecordingsWithoutPresigner(t *testing.T) {
+	m := newActionMocks(t)
+
+	f := runActionsExport(t, m, nil, recordingActions(), []string{"debt.number", "payload.recording_url"})
+
+	// без хранилища записей ключи выгружаются как сохранены
+	values, links := recordingCells(t, f, 4)
+	if values[0] != "calls/rec-1.mp3" || links[0] != "" || links[2] != "https://pbx.example.com/rec-3.mp3" {
+		t.Fatalf("values %q, links %q", values, links)
+	}
+}
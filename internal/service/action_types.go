@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"debtster-export/internal/requestid"
+)
+
+// ActionTypeDirectory lists the action types configured in the CRM admin.
+// Implemented by *repository.ActionRepository.
+type ActionTypeDirectory interface {
+	TypeNames(ctx context.Context) (map[string]string, error)
+}
+
+const (
+	// actionTypesKey caches the action type dictionary for all instances.
+	actionTypesKey = "export_action_types"
+	actionTypesTTL = 10 * time.Minute
+)
+
+// SetActionTypes makes exports name action types after the dictionary in the
+// database; without it only the built-in actionTypeDisplay names are known.
+func (s *ActionService) SetActionTypes(d ActionTypeDirectory) {
+	s.types = d
+}
+
+// actionTypeNames returns the display names by action type: the dictionary,
+// cached for actionTypesTTL, over actionTypeDisplay. A failed lookup leaves
+// the built-in names so the export still completes.
+func (s *ActionService) actionTypeNames(ctx context.Context) map[string]string {
+	names := make(map[string]string, len(actionTypeDisplay))
+	for k, v := range actionTypeDisplay {
+		names[k] = v
+	}
+	if s.types == nil {
+		return names
+	}
+
+	var loaded map[string]string
+	if s.redis != nil {
+		if data, err := s.redis.Get(ctx, actionTypesKey); err == nil && data != "" {
+			_ = json.Unmarshal([]byte(data), &loaded)
+		}
+	}
+	if loaded == nil {
+		var err error
+		loaded, err = s.types.TypeNames(ctx)
+		if err != nil {
+			requestid.Logf(ctx, "action types: %v", err)
+			return names
+		}
+		if s.redis != nil {
+			if data, err := json.Marshal(loaded); err == nil {
+				_ = s.redis.Set(ctx, actionTypesKey, string(data), actionTypesTTL)
+			}
+		}
+	}
+
+	for k, v := range loaded {
+		names[k] = v
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"debtster-export/internal/service/mocks"
+
+	"go.uber.org/mock/gomock"
+)
+
+type fakeActionTypes struct {
+	names map[string]string
+	err   error
+	calls int
+}
+
+func (f *fakeActionTypes) TypeNames(context.Context) (map[string]string, error) {
+	f.calls++
+	return f.names, f.err
+}
+
+func TestActionTypeNames(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("dictionary over built-in names, cached", func(t *testing.T) {
+		redis := mocks.NewMockCache(gomock.NewController(t))
+		types := &fakeActionTypes{names: map[string]string{"sms": "СМС", "incoming_call": "Входящий"}}
+		s := &ActionService{exporter: exporter{redis: redis}}
+		s.SetActionTypes(types)
+
+		gomock.InOrder(
+			redis.EXPECT().Get(gomock.Any(), actionTypesKey).Return("", errors.New("redis: nil")),
+			redis.EXPECT().Set(gomock.Any(), actionTypesKey, `{"incoming_call":"Входящий","sms":"СМС"}`, actionTypesTTL).Return(nil),
+		)
+		names := s.actionTypeNames(ctx)
+		if names["sms"] != "СМС" || names["incoming_call"] != "Входящий" || names["outgoing_call"] != "Исходящий звонок" {
+			t.Fatalf("unexpected names %v", names)
+		}
+
+		// второй вызов берёт словарь из кеша
+		redis.EXPECT().Get(gomock.Any(), actionTypesKey).Return(`{"sms":"СМС"}`, nil)
+		if names := s.actionTypeNames(ctx); names["sms"] != "СМС" || types.calls != 1 {
+			t.Fatalf("expected cached names, got %v after %d lookups", names, types.calls)
+		}
+	})
+
+	t.Run("lookup failure keeps built-in names", func(t *testing.T) {
+		redis := mocks.NewMockCache(gomock.NewController(t))
+		redis.EXPECT().Get(gomock.Any(), actionTypesKey).Return("", errors.New("redis: nil"))
+		s := &ActionService{exporter: exporter{redis: redis}}
+		s.SetActionTypes(&fakeActionTypes{err: errors.New("relation does not exist")})
+
+		names := s.actionTypeNames(ctx)
+		if len(names) != len(actionTypeDisplay) || names["incoming_call"] != "Входящий звонок" {
+			t.Fatalf("unexpected names %v", names)
+		}
+	})
+}
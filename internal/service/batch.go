@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/requestid"
+
+	"github.com/google/uuid"
+)
+
+const exportBatchPrefix = "export_batches:"
+
+// batchStage is the stage of group progress events; their export id is the batch key.
+const batchStage = "batch"
+
+// Batch states recorded in ExportBatchStatus.State.
+const (
+	BatchRunning  = "running"
+	BatchFinished = "finished"
+)
+
+// ExportBatchStatus is the batch record kept in Redis next to the export records.
+type ExportBatchStatus struct {
+	Key        string                     `json:"key"`
+	UserID     int64                      `json:"user_id"`
+	State      string                     `json:"state"`
+	Progress   float64                    `json:"progress"`
+	Exports    []domain.ExportBatchResult `json:"exports"`
+	Created    time.Time                  `json:"created_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+}
+
+// ExportBatch groups the exports started by one batch request. An export joins
+// the batch through the context it is started with, see WithExportBatch; its
+// events are then folded into the group progress and a single completion.
+type ExportBatch struct {
+	notifier *BatchNotifier
+	size     int
+
+	mu       sync.Mutex
+	status   ExportBatchStatus
+	members  map[string]int // export id -> index in status.Exports
+	progress []float64
+	done     []bool
+	finished int
+}
+
+type batchCtxKey struct{}
+
+// WithExportBatch makes exports started with ctx members of b.
+func WithExportBatch(ctx context.Context, b *ExportBatch) context.Context {
+	return context.WithValue(ctx, batchCtxKey{}, b)
+}
+
+func exportBatchFromContext(ctx context.Context) *ExportBatch {
+	b, _ := ctx.Value(batchCtxKey{}).(*ExportBatch)
+	return b
+}
+
+// Key returns the batch id.
+func (b *ExportBatch) Key() string {
+	return b.status.Key
+}
+
+// Add records a started export of the batch.
+func (b *ExportBatch) Add(ctx context.Context, exportID, exportType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.status.Exports[b.member(exportID)].Type = exportType
+	b.notifier.save(ctx, &b.status)
+}
+
+// AddFailed records an export that could not be started; it counts as finished.
+func (b *ExportBatch) AddFailed(ctx context.Context, exportType, errMsg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.status.Exports = append(b.status.Exports, domain.ExportBatchResult{Type: exportType, Error: errMsg})
+	b.progress = append(b.progress, 100)
+	b.done = append(b.done, true)
+	b.finished++
+	b.update(ctx)
+}
+
+// member returns the index of exportID, adding it when its first event comes
+// before Add.
+func (b *ExportBatch) member(exportID string) int {
+	i, ok := b.members[exportID]
+	if !ok {
+		i = len(b.status.Exports)
+		b.members[exportID] = i
+		b.status.Exports = append(b.status.Exports, domain.ExportBatchResult{ExportID: exportID})
+		b.progress = append(b.progress, 0)
+		b.done = append(b.done, false)
+	}
+	return i
+}
+
+func (b *ExportBatch) reportProgress(ctx context.Context, exportID string, progress float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.member(exportID)
+	if b.done[i] {
+		return
+	}
+	b.progress[i] = progress
+	b.update(ctx)
+}
+
+func (b *ExportBatch) finish(ctx context.Context, exportID, url, filename, errMsg string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.member(exportID)
+	if b.done[i] {
+		return
+	}
+	b.status.Exports[i].FileURL = url
+	b.status.Exports[i].Filename = filename
+	b.status.Exports[i].Error = errMsg
+	b.progress[i] = 100
+	b.done[i] = true
+	b.finished++
+	b.update(ctx)
+}
+
+// update recomputes the group progress and sends it when it changed; once every
+// export is finished it sends the single completion notification.
+func (b *ExportBatch) update(ctx context.Context) {
+	if b.status.State == BatchFinished {
+		return
+	}
+
+	if b.finished >= b.size {
+		now := time.Now()
+		b.status.State = BatchFinished
+		b.status.Progress = 100
+		b.status.FinishedAt = &now
+		b.notifier.save(ctx, &b.status)
+		_ = b.notifier.next.NotifyBatchComplete(ctx, b.status.UserID, b.status.Key, slices.Clone(b.status.Exports))
+		return
+	}
+
+	var sum float64
+	for _, p := range b.progress {
+		sum += p
+	}
+	// 100% is reserved for the finished batch, like for single exports
+	progress := math.Min(math.Floor(sum/float64(b.size)), 99)
+	if progress == b.status.Progress {
+		return
+	}
+	b.status.Progress = progress
+	b.notifier.save(ctx, &b.status)
+	_ = b.notifier.next.NotifyExportProgress(ctx, b.status.UserID, b.status.Key, progress, batchStage)
+}
+
+// BatchNotifier sits in front of the notifiers the exporters use: events of
+// exports that belong to a batch are folded into the batch, all others pass
+// through unchanged. Batches live in the memory of the instance running their
+// exports; the record in Redis is for reading only.
+type BatchNotifier struct {
+	next  Notifier
+	redis Cache
+}
+
+func NewBatchNotifier(next Notifier, redis Cache) *BatchNotifier {
+	return &BatchNotifier{next: next, redis: redis}
+}
+
+// Begin creates a batch of size exports owned by userID.
+func (n *BatchNotifier) Begin(ctx context.Context, userID int64, size int) (*ExportBatch, error) {
+	b := &ExportBatch{
+		notifier: n,
+		size:     size,
+		status: ExportBatchStatus{
+			Key:     exportBatchPrefix + uuid.NewString(),
+			UserID:  userID,
+			State:   BatchRunning,
+			Exports: make([]domain.ExportBatchResult, 0, size),
+			Created: time.Now(),
+		},
+		members: make(map[string]int, size),
+	}
+
+	raw, err := json.Marshal(b.status)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.redis.Set(ctx, b.status.Key, string(raw), exportTTL); err != nil {
+		return nil, fmt.Errorf("save batch: %w", err)
+	}
+	return b, nil
+}
+
+// GetBatch returns the record of a batch owned by userID.
+func (n *BatchNotifier) GetBatch(ctx context.Context, batchID string, userID int64) (*ExportBatchStatus, error) {
+	data, err := n.redis.Get(ctx, batchID)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	var status ExportBatchStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return nil, fmt.Errorf("failed to parse batch status: %w", err)
+	}
+
+	if status.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+	return &status, nil
+}
+
+func (n *BatchNotifier) save(ctx context.Context, status *ExportBatchStatus) {
+	raw, err := json.Marshal(status)
+	if err == nil {
+		// the record must be written even when the member export was cancelled
+		err = n.redis.Set(context.WithoutCancel(ctx), status.Key, string(raw), exportTTL)
+	}
+	if err != nil {
+		requestid.Logf(ctx, "batch %s: save: %v", status.Key, err)
+	}
+}
+
+func (n *BatchNotifier) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	if b := exportBatchFromContext(ctx); b != nil {
+		b.reportProgress(ctx, exportID, progress)
+		return nil
+	}
+	return n.next.NotifyExportProgress(ctx, userID, exportID, progress, stage)
+}
+
+func (n *BatchNotifier) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	if b := exportBatchFromContext(ctx); b != nil {
+		b.finish(ctx, exportID, url, filename, "")
+		return nil
+	}
+	return n.next.NotifyExportComplete(ctx, userID, exportID, url, filename)
+}
+
+func (n *BatchNotifier) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	if b := exportBatchFromContext(ctx); b != nil {
+		b.finish(ctx, exportID, "", "", errMsg)
+		return nil
+	}
+	return n.next.NotifyExportFailed(ctx, userID, exportID, errMsg)
+}
+
+func (n *BatchNotifier) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	return n.next.NotifyBatchComplete(ctx, userID, batchID, results)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/service/mocks"
+
+	"go.uber.org/mock/gomock"
+)
+
+func TestBatchNotifier(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	redis := mocks.NewMockCache(ctrl)
+	next := mocks.NewMockNotifier(ctrl)
+	redis.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), exportTTL).Return(nil).AnyTimes()
+
+	n := NewBatchNotifier(next, redis)
+	batch, err := n.Begin(context.Background(), 7, 3)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	ctx := WithExportBatch(context.Background(), batch)
+
+	// события экспортов пакета не уходят дальше по одному, только общий прогресс
+	gomock.InOrder(
+		next.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), batch.Key(), float64(16), batchStage),
+		next.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), batch.Key(), float64(33), batchStage),
+		next.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), batch.Key(), float64(66), batchStage),
+		next.EXPECT().NotifyBatchComplete(gomock.Any(), int64(7), batch.Key(), []domain.ExportBatchResult{
+			{ExportID: "exports:1", Type: "debts", FileURL: "/files/a.xlsx", Filename: "a.xlsx"},
+			{Type: "users", Error: "failed to start export"},
+			{ExportID: "exports:2", Type: "payments", Error: "boom"},
+		}),
+	)
+
+	// первое событие может прийти раньше, чем экспорт добавлен в пакет
+	_ = n.NotifyExportProgress(ctx, 7, "exports:1", 50, "generating")
+	batch.Add(ctx, "exports:1", "debts")
+	_ = n.NotifyExportComplete(ctx, 7, "exports:1", "/files/a.xlsx", "a.xlsx")
+	batch.AddFailed(ctx, "users", "failed to start export")
+	batch.Add(ctx, "exports:2", "payments")
+	_ = n.NotifyExportFailed(ctx, 7, "exports:2", "boom")
+	// повторное завершение не отправляет второе уведомление
+	_ = n.NotifyExportFailed(ctx, 7, "exports:2", "boom")
+
+	// экспорты вне пакета уведомляются как обычно
+	next.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), "exports:3", "/files/b.xlsx", "b.xlsx")
+	_ = n.NotifyExportComplete(context.Background(), 7, "exports:3", "/files/b.xlsx", "b.xlsx")
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// CellWriter takes the value of a cell in its own type. Columns with a Write
+// func render through it, so the rows of a large export are not boxed cell by
+// cell into an any and type-switched again by the workbook.
+type CellWriter interface {
+	WriteString(s string)
+	WriteFloat(v float64)
+	WriteInt(v int64)
+	WriteBool(v bool)
+	// WriteTime writes t in the export date format, like timePtr; nil is an empty cell.
+	WriteTime(t *time.Time)
+}
+
+// typedCell defines a column of rows T by the one field it reads: write sets
+// the cell with the type of the field, value boxes the same field for
+// expressions, samples and the other outputs.
+type typedCell[T any] struct {
+	value func(row T) any
+	write func(row *T, w CellWriter)
+}
+
+func stringCell[T any](get func(row T) string) typedCell[T] {
+	return typedCell[T]{
+		value: func(row T) any { return get(row) },
+		write: func(row *T, w CellWriter) { w.WriteString(get(*row)) },
+	}
+}
+
+func floatCell[T any](get func(row T) float64) typedCell[T] {
+	return typedCell[T]{
+		value: func(row T) any { return get(row) },
+		write: func(row *T, w CellWriter) { w.WriteFloat(get(*row)) },
+	}
+}
+
+func boolCell[T any](get func(row T) bool) typedCell[T] {
+	return typedCell[T]{
+		value: func(row T) any { return get(row) },
+		write: func(row *T, w CellWriter) { w.WriteBool(get(*row)) },
+	}
+}
+
+// timeCell writes dates in the export date format; value is the same text, see timePtr.
+func timeCell[T any](get func(row T) *time.Time) typedCell[T] {
+	return typedCell[T]{
+		value: func(row T) any { return timePtr(get(row)) },
+		write: func(row *T, w CellWriter) { w.WriteTime(get(*row)) },
+	}
+}
+
+// exportTimeLayout is the format of dates in exported cells.
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// sheetWriter is the CellWriter of a workbook sheet: the caller positions it
+// with row and at, then the column writes the cell.
+type sheetWriter struct {
+	f     *excelize.File
+	sheet string
+	// names are the column letters, so cell names are not computed per cell
+	names  []string
+	rowNum string
+	col    int
+	buf    []byte
+}
+
+func newSheetWriter(f *excelize.File, sheet string, columns int) *sheetWriter {
+	names := make([]string, columns)
+	for i := range names {
+		names[i], _ = excelize.ColumnNumberToName(i + 1)
+	}
+	return &sheetWriter{f: f, sheet: sheet, names: names}
+}
+
+// row moves the writer to the 1-based row.
+func (w *sheetWriter) row(row int) {
+	w.rowNum = strconv.Itoa(row)
+}
+
+// at moves the writer to the 0-based column of the current row.
+func (w *sheetWriter) at(col int) {
+	w.col = col
+}
+
+// cell returns the name of the current cell, e.g. "C12".
+func (w *sheetWriter) cell() string {
+	return w.names[w.col] + w.rowNum
+}
+
+func (w *sheetWriter) WriteString(s string) {
+	_ = w.f.SetCellStr(w.sheet, w.cell(), s)
+}
+
+func (w *sheetWriter) WriteFloat(v float64) {
+	_ = w.f.SetCellFloat(w.sheet, w.cell(), v, -1, 64)
+}
+
+func (w *sheetWriter) WriteInt(v int64) {
+	_ = w.f.SetCellInt(w.sheet, w.cell(), v)
+}
+
+func (w *sheetWriter) WriteBool(v bool) {
+	_ = w.f.SetCellBool(w.sheet, w.cell(), v)
+}
+
+func (w *sheetWriter) WriteTime(t *time.Time) {
+	if t == nil {
+		w.WriteString("")
+		return
+	}
+	w.buf = t.AppendFormat(w.buf[:0], exportTimeLayout)
+	w.WriteString(string(w.buf))
+}
+
+// writeValue writes the value of a column without a Write func.
+func (w *sheetWriter) writeValue(v any) {
+	_ = w.f.SetCellValue(w.sheet, w.cell(), v)
+}
+
+// hyperlink makes the current cell a link to url.
+func (w *sheetWriter) hyperlink(url string) {
+	_ = w.f.SetCellHyperLink(w.sheet, w.cell(), url, "External")
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+
+	"debtster-export/internal/domain"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Write колонок должен давать ту же ячейку, что и Value: оба строятся из одного
+// typedCell, а Value по-прежнему используют выражения, выборки типов и другие форматы.
+func TestColumnWriteMatchesValue(t *testing.T) {
+	debts := generateDebts(3, 7)
+	debts[1] = domain.Debt{Number: "D-empty"}
+	payments := []domain.Payment{
+		{ID: "p-1", Amount: 10.5, Confirmed: true, PaymentDate: debts[0].StartDate},
+		{ID: "p-2"},
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	w := newSheetWriter(f, sheet, 2)
+
+	check := func(key string, row int, write func(), value any) {
+		t.Helper()
+		w.row(row)
+		w.at(0)
+		write()
+		w.at(1)
+		w.writeValue(value)
+
+		typed, _ := f.GetCellValue(sheet, w.names[0]+w.rowNum)
+		boxed, _ := f.GetCellValue(sheet, w.names[1]+w.rowNum)
+		typedType, _ := f.GetCellType(sheet, w.names[0]+w.rowNum)
+		boxedType, _ := f.GetCellType(sheet, w.names[1]+w.rowNum)
+		if typed != boxed || typedType != boxedType {
+			t.Errorf("%s row %d: Write %q (%v), Value %q (%v)", key, row, typed, typedType, boxed, boxedType)
+		}
+	}
+
+	row := 1
+	for key, col := range debtColumns {
+		if col.Write == nil {
+			continue
+		}
+		for i := range debts {
+			check(key, row, func() { col.Write(&debts[i], w) }, col.Value(debts[i]))
+			row++
+		}
+	}
+	for key, col := range paymentColumns {
+		if col.Write == nil {
+			continue
+		}
+		for i := range payments {
+			check(key, row, func() { col.Write(&payments[i], w) }, col.Value(payments[i]))
+			row++
+		}
+	}
+}
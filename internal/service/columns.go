@@ -0,0 +1,110 @@
+package service
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxColumnSuggestions bounds the "did you mean" keys of one unknown column.
+const maxColumnSuggestions = 3
+
+// UnknownColumn is a selected column key the export type does not have,
+// with the known keys it was probably meant to be.
+type UnknownColumn struct {
+	Key         string
+	Suggestions []string
+}
+
+// UnknownDebtColumns returns the keys of selected that are not debt columns.
+func UnknownDebtColumns(selected []string) []UnknownColumn {
+	return unknownColumns(selected, debtColumns)
+}
+
+// UnknownUserColumns returns the keys of selected that are not user columns.
+func UnknownUserColumns(selected []string) []UnknownColumn {
+	return unknownColumns(selected, userColumns)
+}
+
+// UnknownActionColumns returns the keys of selected that are not action columns.
+func UnknownActionColumns(selected []string) []UnknownColumn {
+	return unknownColumns(selected, actionColumns)
+}
+
+// UnknownPaymentColumns returns the keys of selected that are not payment columns.
+func UnknownPaymentColumns(selected []string) []UnknownColumn {
+	return unknownColumns(selected, paymentColumns)
+}
+
+// unknownColumns checks selected against a column registry. Masked columns
+// are known: hiding them is the business of the masks, not an error.
+func unknownColumns[C any](selected []string, known map[string]C) []UnknownColumn {
+	var out []UnknownColumn
+	for _, key := range selected {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		out = append(out, UnknownColumn{Key: key, Suggestions: suggestColumns(key, known)})
+	}
+	return out
+}
+
+// suggestColumns returns the known keys closest to key: those within a few
+// edits of it, nearest first, and failing that the ones with the same last
+// segment, e.g. "debtor.iin" for "iin".
+func suggestColumns[C any](key string, known map[string]C) []string {
+	type candidate struct {
+		key  string
+		dist int
+	}
+
+	lower := strings.ToLower(key)
+	limit := max(2, len([]rune(lower))/3)
+	var found []candidate
+	for k := range known {
+		if d := editDistance(lower, strings.ToLower(k)); d <= limit {
+			found = append(found, candidate{k, d})
+		}
+	}
+	if len(found) == 0 {
+		segment := lower[strings.LastIndex(lower, ".")+1:]
+		for k := range known {
+			if segment != "" && strings.ToLower(k[strings.LastIndex(k, ".")+1:]) == segment {
+				found = append(found, candidate{k, 0})
+			}
+		}
+	}
+
+	slices.SortFunc(found, func(a, b candidate) int {
+		if a.dist != b.dist {
+			return a.dist - b.dist
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	out := make([]string, 0, min(len(found), maxColumnSuggestions))
+	for _, c := range found[:min(len(found), maxColumnSuggestions)] {
+		out = append(out, c.key)
+	}
+	return out
+}
+
+// editDistance is the Levenshtein distance of a and b in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
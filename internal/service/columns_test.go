@@ -0,0 +1,45 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUnknownDebtColumns(t *testing.T) {
+	unknown := UnknownDebtColumns([]string{"number", "debtor.ful_name", "iin", "nonsense_column_xyz"})
+	if len(unknown) != 3 {
+		t.Fatalf("unknown = %+v", unknown)
+	}
+
+	// опечатка — ближайший ключ
+	if unknown[0].Key != "debtor.ful_name" || !slices.Contains(unknown[0].Suggestions, "debtor.full_name") {
+		t.Errorf("typo: %+v", unknown[0])
+	}
+	// ключ без префикса — по последнему сегменту
+	if unknown[1].Key != "iin" || !slices.Contains(unknown[1].Suggestions, "debtor.iin") {
+		t.Errorf("segment: %+v", unknown[1])
+	}
+	if len(unknown[2].Suggestions) != 0 {
+		t.Errorf("nonsense: %+v", unknown[2])
+	}
+
+	if unknown := UnknownActionColumns([]string{"debt.number", "payload.recording_url"}); len(unknown) != 0 {
+		t.Fatalf("known action columns reported: %+v", unknown)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"number", "number", 0},
+		{"numbr", "number", 1},
+		{"сумма", "суммы", 1},
+	} {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
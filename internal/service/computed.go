@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"debtster-export/internal/expr"
+)
+
+// maxComputedColumns bounds the computed columns of one export.
+const maxComputedColumns = 20
+
+// ErrInvalidComputedColumn is returned when a computed column does not parse
+// or refers to a field the export type does not have.
+var ErrInvalidComputedColumn = errors.New("invalid computed column")
+
+// ComputedColumn is a request-defined column whose cells are computed per row
+// from other columns of the export, see package expr for the language.
+type ComputedColumn struct {
+	Header string `json:"header"`
+	Expr   string `json:"expr"`
+}
+
+// computedColumn is a compiled ComputedColumn of rows of type T.
+type computedColumn[T any] struct {
+	Header string
+	Value  func(row T) any
+}
+
+// compileComputedColumns parses the expressions and resolves their fields with
+// field, which returns the value getter of a column key of the export type.
+// A row the expression cannot be computed for (division by zero, text in
+// arithmetic) gets an empty cell.
+func compileComputedColumns[T any](defs []ComputedColumn, field func(key string) (func(T) any, bool)) ([]computedColumn[T], error) {
+	if len(defs) > maxComputedColumns {
+		return nil, fmt.Errorf("%w: at most %d computed columns are allowed", ErrInvalidComputedColumn, maxComputedColumns)
+	}
+
+	out := make([]computedColumn[T], 0, len(defs))
+	for i, def := range defs {
+		e, err := expr.Parse(def.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: computed[%d]: %v", ErrInvalidComputedColumn, i, err)
+		}
+
+		values := make(map[string]func(T) any, len(e.Fields()))
+		for _, name := range e.Fields() {
+			value, ok := field(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: computed[%d]: unknown field %q", ErrInvalidComputedColumn, i, name)
+			}
+			values[name] = value
+		}
+
+		header := def.Header
+		if header == "" {
+			header = def.Expr
+		}
+		out = append(out, computedColumn[T]{
+			Header: header,
+			Value: func(row T) any {
+				v, err := e.Eval(func(name string) any { return values[name](row) })
+				if err != nil {
+					return ""
+				}
+				return v
+			},
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"debtster-export/internal/domain"
+)
+
+func TestDebtComputedColumns(t *testing.T) {
+	mainDebt := 1000.0
+	debt := domain.Debt{Number: "D-1", AmountActualDebt: 1500, AmountMainDebt: &mainDebt}
+
+	cols, err := debtComputedColumns([]ComputedColumn{
+		{Header: "Остаток", Expr: "amount_actual_debt - amount_main_debt"},
+		{Expr: `number + "!"`},
+		{Header: "Доля", Expr: "amount_actual_debt / amount_fine"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols[0].Header != "Остаток" || cols[0].Value(debt) != 500.0 {
+		t.Fatalf("unexpected first column %q = %v", cols[0].Header, cols[0].Value(debt))
+	}
+	// без заголовка используется само выражение
+	if cols[1].Header != `number + "!"` || cols[1].Value(debt) != "D-1!" {
+		t.Fatalf("unexpected second column %q = %v", cols[1].Header, cols[1].Value(debt))
+	}
+	// деление на ноль даёт пустую ячейку, а не ошибку выгрузки
+	if got := cols[2].Value(debt); got != "" {
+		t.Fatalf("expected empty cell, got %v", got)
+	}
+}
+
+func TestDebtComputedColumns_Invalid(t *testing.T) {
+	for _, defs := range [][]ComputedColumn{
+		{{Expr: "unknown_field * 2"}},
+		{{Expr: "amount_fine +"}},
+		make([]ComputedColumn, maxComputedColumns+1),
+	} {
+		if _, err := debtComputedColumns(defs, nil); !errors.Is(err, ErrInvalidComputedColumn) {
+			t.Errorf("expected ErrInvalidComputedColumn, got %v", err)
+		}
+	}
+}
@@ -1,40 +1,109 @@
 package service
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
+	"sort"
 	"strings"
 	"time"
 
-	"debtster-export/internal/clients"
 	"debtster-export/internal/domain"
+	"debtster-export/internal/expr"
 	"debtster-export/internal/repository"
 
-	"github.com/google/uuid"
 	"github.com/xuri/excelize/v2"
 )
 
 type DebtRepository interface {
 	List(ctx context.Context, f repository.DebtsFilter) ([]domain.Debt, error)
+	ListGuarantors(ctx context.Context, f repository.DebtsFilter) ([]domain.Guarantor, error)
+	Count(ctx context.Context, f repository.DebtsFilter) (int64, error)
+	AgingCounts(ctx context.Context, f repository.DebtsFilter, groupBy repository.DebtsGroupBy) ([]domain.DebtAgingCount, error)
+	HasMoreThan(ctx context.Context, limit int64, f repository.DebtsFilter) (bool, error)
 }
 
+// maxDebtsForExport keeps a debts export under the xlsx sheet limit of
+// 1 048 576 rows.
+const maxDebtsForExport = 1_000_000
+
+// debtsExportParams is the persisted form of a debts export request.
+type debtsExportParams struct {
+	Selected []string               `json:"selected"`
+	Filter   repository.DebtsFilter `json:"filter"`
+	Options  DebtsExportOptions     `json:"options"`
+}
+
+// DebtsExportOptions holds debts export settings that are not row filters.
+type DebtsExportOptions struct {
+	ExportOptions
+
+	// IncludeGuarantors adds a second sheet with co-debtors and guarantors per contract.
+	IncludeGuarantors bool
+	// SplitBy produces one workbook per group instead of a single one, zipped
+	// together; see DebtsSplitByCounterparty.
+	SplitBy string
+}
+
+// DebtsSplitByCounterparty writes the debts of every counterparty into their own workbook.
+const DebtsSplitByCounterparty = "counterparty"
+
 type ExportStatus struct {
-	Key      string    `json:"key"`
-	Type     string    `json:"type"`
-	UserID   int64     `json:"user_id"`
-	Filters  any       `json:"filters"`
-	Progress float64   `json:"progress"`
-	FileURL  *string   `json:"file_url"`
-	Error    *string   `json:"error,omitempty"`
-	Created  time.Time `json:"created_at"`
+	Key      string  `json:"key"`
+	Type     string  `json:"type"`
+	UserID   int64   `json:"user_id"`
+	Filters  any     `json:"filters"`
+	Progress float64 `json:"progress"`
+	// ETASeconds is the estimated time left while the rows are generated.
+	ETASeconds *int64    `json:"eta_seconds,omitempty"`
+	FileURL    *string   `json:"file_url"`
+	Error      *string   `json:"error,omitempty"`
+	Created    time.Time `json:"created_at"`
+
+	// Params are the original export parameters, kept so the export can be re-run.
+	Params    json.RawMessage `json:"params,omitempty"`
+	Attempt   int             `json:"attempt,omitempty"`
+	RetryOf   *string         `json:"retry_of,omitempty"`
+	RetriedBy *string         `json:"retried_by,omitempty"`
+
+	// Heartbeat is refreshed on every status save while the job is running.
+	Heartbeat *time.Time `json:"heartbeat_at,omitempty"`
+	// RequestID of the HTTP request that started the export, for tracing.
+	RequestID string `json:"request_id,omitempty"`
+
+	// File is the storage name of the finished file; downloads of it are
+	// counted in the fields below.
+	File             *string    `json:"file,omitempty"`
+	Downloads        int        `json:"downloads,omitempty"`
+	LastDownloadedAt *time.Time `json:"last_downloaded_at,omitempty"`
+	LastDownloadedBy *int64     `json:"last_downloaded_by,omitempty"`
+	SingleUse        bool       `json:"single_use,omitempty"`
+	// URLExpiresAt is when FileURL stops working when links are signed;
+	// URLTTLHours is kept to re-issue it with the same lifetime.
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+	URLTTLHours  int        `json:"url_ttl_hours,omitempty"`
+	// Storage is the backend holding File when the storage has several.
+	Storage string `json:"storage,omitempty"`
+
+	// Delivery tracks the push of the file to the requested external target.
+	Delivery *DeliveryStatus `json:"delivery,omitempty"`
+	// Comment given at start; later edits are stored under exportCommentPrefix.
+	Comment string `json:"comment,omitempty"`
+	// History lists the states the export went through, oldest first.
+	History []StatusTransition `json:"history,omitempty"`
 }
 
 const (
 	exportSetKey = "export_ids"
 	exportTTL    = 20 * time.Minute
+	// exportFilePrefix maps a stored file name to the key of its export.
+	exportFilePrefix = "export_files:"
+	// exportCommentPrefix holds comments edited after start; they outlive the
+	// record, which expires exportTTL after its last update.
+	exportCommentPrefix = "export_comments:"
+	exportCommentTTL    = 24 * time.Hour
 )
 
 type ExportCacheItem struct {
@@ -48,29 +117,79 @@ type ExportCacheItem struct {
 }
 
 type DebtService struct {
-	repo        DebtRepository
-	redis       *clients.RedisClient
-	s3          *clients.StorageClient
-	ws          *clients.WebSocketClient
-	cachePrefix string
+	exporter
+
+	repo  DebtRepository
+	queue *JobQueue
+	// progressEvery is the least time between two generating progress reports
+	progressEvery time.Duration
+	filenameTpl   string
+	links         LinkTemplates
+	templates     TemplateStore
+	names         FilterNameDirectory
 }
 
 func NewDebtService(
 	repo DebtRepository,
-	redis *clients.RedisClient,
-	s3 *clients.StorageClient,
-	ws *clients.WebSocketClient,
+	redis Cache,
+	s3 FileStorage,
+	ws Notifier,
 ) *DebtService {
-	prefix := "pkb_database_cache"
 	return &DebtService{
-		repo:        repo,
-		redis:       redis,
-		s3:          s3,
-		ws:          ws,
-		cachePrefix: prefix,
+		repo:     repo,
+		exporter: newExporter(redis, s3, ws),
 	}
 }
 
+// SetRetryPolicy overrides the backoff used for transient DB and storage failures.
+func (s *DebtService) SetRetryPolicy(p RetryPolicy) {
+	s.retry = p
+}
+
+// SetJobRunner makes exports run on the shared worker pool watched for stalls.
+func (s *DebtService) SetJobRunner(r *JobRunner) {
+	s.jobs = r
+}
+
+// SetJobQueue makes exports run on the instances consuming q instead of the
+// one that started them.
+func (s *DebtService) SetJobQueue(q *JobQueue) {
+	s.queue = q
+	q.handle(s.runQueued, s.failExport, "debts", debtsAgingType)
+}
+
+// SetOutbox makes final statuses go through o, which retries failed writes.
+func (s *DebtService) SetOutbox(o *Outbox) {
+	s.outbox = o
+}
+
+// SetProgressInterval throttles the progress saved and sent while rows are
+// generated to one report per d; the last row is always reported.
+func (s *DebtService) SetProgressInterval(d time.Duration) {
+	s.progressEvery = d
+}
+
+// SetFilenameTemplate sets the naming template of generated files, see
+// DefaultFilenameTemplate for the placeholders; empty keeps the default.
+func (s *DebtService) SetFilenameTemplate(tpl string) {
+	s.filenameTpl = tpl
+}
+
+// SetDeliverers enables the delivery types exports may request.
+func (s *DebtService) SetDeliverers(d Deliverers) {
+	s.deliverers = d
+}
+
+// SetTemplateStore enables rendering exports into XLSX templates.
+func (s *DebtService) SetTemplateStore(ts TemplateStore) {
+	s.templates = ts
+}
+
+// SetLinkTemplates makes debt cells link back to the CRM, see LinkTemplates.
+func (s *DebtService) SetLinkTemplates(t LinkTemplates) {
+	s.links = t
+}
+
 func strPtr(p *string) string {
 	if p == nil {
 		return ""
@@ -89,12 +208,29 @@ func timePtr(p *time.Time) string {
 	if p == nil {
 		return ""
 	}
-	return p.Format("2006-01-02 15:04:05")
+	return p.Format(exportTimeLayout)
 }
 
 type DebtColumn struct {
 	Header string
 	Value  func(d domain.Debt) any
+	// Write, when set, renders the cell of Value without boxing it; Value is
+	// still used by expressions, samples and the other outputs. Both come from
+	// one typedCell, see debtColumn.
+	Write func(d *domain.Debt, w CellWriter)
+	// Link, when set, makes the cell a hyperlink to the returned URL (if non-empty).
+	Link func(d domain.Debt, links LinkTemplates) string
+}
+
+// debtColumn is the column of a debt field read by cell.
+func debtColumn(header string, cell typedCell[domain.Debt]) DebtColumn {
+	return DebtColumn{Header: header, Value: cell.value, Write: cell.write}
+}
+
+// withLink returns c linking its cells to the URL of link.
+func (c DebtColumn) withLink(link func(d domain.Debt, links LinkTemplates) string) DebtColumn {
+	c.Link = link
+	return c
 }
 
 var debtColumns = map[string]DebtColumn{
@@ -109,130 +245,49 @@ var debtColumns = map[string]DebtColumn{
 			return strings.TrimSpace(strings.Join(parts, " "))
 		},
 	},
-	"debtor.iin": {
-		Header: "ИИН",
-		Value:  func(d domain.Debt) any { return strPtr(d.DebtorIIN) },
-	},
-	"registry.number": {
-		Header: "Номер реестра",
-		Value:  func(d domain.Debt) any { return strPtr(d.RegistryNumber) },
-	},
-	"registry.date": {
-		Header: "Дата реестра",
-		Value:  func(d domain.Debt) any { return timePtr(d.RegistryDate) },
-	},
-	"counterparty.name": {
-		Header: "Контрагент",
-		Value:  func(d domain.Debt) any { return strPtr(d.CounterpartyName) },
-	},
-	"user.username": {
-		Header: "Логин сотрудника",
-		Value:  func(d domain.Debt) any { return strPtr(d.UserUsername) },
-	},
-	"user.departments": {
-		Header: "Отдел",
-		Value:  func(d domain.Debt) any { return strPtr(d.UserDepartments) },
-	},
-	"status.name": {
-		Header: "Статус",
-		Value:  func(d domain.Debt) any { return strPtr(d.StatusName) },
-	},
-	"start_date": {
-		Header: "Дата выдачи займа",
-		Value:  func(d domain.Debt) any { return timePtr(d.StartDate) },
-	},
-	"end_date": {
-		Header: "Дата окончания договора",
-		Value:  func(d domain.Debt) any { return timePtr(d.EndDate) },
-	},
-	"filial": {
-		Header: "Каким филиалом выдавался кредит",
-		Value:  func(d domain.Debt) any { return strPtr(d.Filial) },
-	},
-	"product_name": {
-		Header: "Наименование продукта",
-		Value:  func(d domain.Debt) any { return strPtr(d.ProductName) },
-	},
-	"amount_currency": {
-		Header: "Валюта",
-		Value:  func(d domain.Debt) any { return strPtr(d.AmountCurrency) },
-	},
-	"amount_actual_debt": {
-		Header: "Актуальный остаток задолженности",
-		Value:  func(d domain.Debt) any { return d.AmountActualDebt },
-	},
-	"amount_purchased_loan": {
-		Header: "Сумма выкупленного кредита",
-		Value:  func(d domain.Debt) any { return d.AmountPurchasedLoan },
-	},
-	"init_amount_actual_debt": {
-		Header: "Сумма выкупленного долга",
-		Value:  func(d domain.Debt) any { return d.InitAmountActualDebt },
-	},
-	"amount_credit": {
-		Header: "Сумма кредита",
-		Value:  func(d domain.Debt) any { return d.AmountCredit },
-	},
-	"amount_main_debt": {
-		Header: "Сумма основного долга",
-		Value:  func(d domain.Debt) any { return floatPtr(d.AmountMainDebt) },
-	},
-	"amount_fine": {
-		Header: "Пеня",
-		Value:  func(d domain.Debt) any { return d.AmountFine },
-	},
-	"amount_accrual": {
-		Header: "Начисленное вознаграждение по Договору займа",
-		Value:  func(d domain.Debt) any { return d.AmountAccrual },
-	},
-	"amount_government_duty": {
-		Header: "Гос.пошлина",
-		Value:  func(d domain.Debt) any { return d.AmountGovernmentDuty },
-	},
-	"amount_representation_expenses": {
-		Header: "Представительские расходы",
-		Value:  func(d domain.Debt) any { return d.AmountRepresentationExp },
-	},
-	"amount_notary_fees": {
-		Header: "Нотариальные расходы",
-		Value:  func(d domain.Debt) any { return d.AmountNotaryFees },
-	},
-	"amount_postage": {
-		Header: "Почтовые расходы",
-		Value:  func(d domain.Debt) any { return d.AmountPostage },
-	},
-	"transfer_decision": {
-		Header: "Решение о передаче",
-		Value:  func(d domain.Debt) any { return strPtr(d.TransferDecision) },
-	},
-	"presence_solidarity": {
-		Header: "Наличие солидарности",
-		Value:  func(d domain.Debt) any { return d.PresenceSolidarity },
-	},
-	"government_duty_paid": {
-		Header: "Гос.пошлина оплачена",
-		Value:  func(d domain.Debt) any { return d.GovernmentDutyPaid },
-	},
-	"government_duty_refund": {
-		Header: "Возврат гос.пошлины",
-		Value:  func(d domain.Debt) any { return d.GovernmentDutyRefund },
-	},
-	"representation_expenses_paid": {
-		Header: "Представительские расходы оплачены",
-		Value:  func(d domain.Debt) any { return d.RepresentationExpensesPaid },
-	},
-	"late_due_date": {
-		Header: "Дата вынесения на просрочку",
-		Value:  func(d domain.Debt) any { return timePtr(d.LateDueDate) },
-	},
-	"next_contact": {
-		Header: "Дата следующего контакта",
-		Value:  func(d domain.Debt) any { return timePtr(d.NextContact) },
-	},
-	"last_contact": {
-		Header: "Последний контакт",
-		Value:  func(d domain.Debt) any { return timePtr(d.LastContact) },
-	},
+	"debtor.iin": debtColumn("ИИН", stringCell(func(d domain.Debt) string { return strPtr(d.DebtorIIN) })),
+	// contacts of the debtor, see debtContactsPrefixes
+	"debtor.phones": {
+		Header: "Телефоны",
+		Value:  func(d domain.Debt) any { return strings.Join(d.DebtorPhones, ", ") },
+	},
+	"debtor.phone_1": debtorPhoneColumn(1),
+	"debtor.phone_2": debtorPhoneColumn(2),
+	"debtor.phone_3": debtorPhoneColumn(3),
+	"debtor.addresses": {
+		Header: "Адреса",
+		Value:  func(d domain.Debt) any { return strings.Join(d.DebtorAddresses, "; ") },
+	},
+	"registry.number":                debtColumn("Номер реестра", stringCell(func(d domain.Debt) string { return strPtr(d.RegistryNumber) })),
+	"registry.date":                  debtColumn("Дата реестра", timeCell(func(d domain.Debt) *time.Time { return d.RegistryDate })),
+	"counterparty.name":              debtColumn("Контрагент", stringCell(func(d domain.Debt) string { return strPtr(d.CounterpartyName) })),
+	"user.username":                  debtColumn("Логин сотрудника", stringCell(func(d domain.Debt) string { return strPtr(d.UserUsername) })),
+	"user.departments":               debtColumn("Отдел", stringCell(func(d domain.Debt) string { return strPtr(d.UserDepartments) })),
+	"status.name":                    debtColumn("Статус", stringCell(func(d domain.Debt) string { return strPtr(d.StatusName) })),
+	"start_date":                     debtColumn("Дата выдачи займа", timeCell(func(d domain.Debt) *time.Time { return d.StartDate })),
+	"end_date":                       debtColumn("Дата окончания договора", timeCell(func(d domain.Debt) *time.Time { return d.EndDate })),
+	"filial":                         debtColumn("Каким филиалом выдавался кредит", stringCell(func(d domain.Debt) string { return strPtr(d.Filial) })),
+	"product_name":                   debtColumn("Наименование продукта", stringCell(func(d domain.Debt) string { return strPtr(d.ProductName) })),
+	"amount_currency":                debtColumn("Валюта", stringCell(func(d domain.Debt) string { return strPtr(d.AmountCurrency) })),
+	"amount_actual_debt":             debtColumn("Актуальный остаток задолженности", floatCell(func(d domain.Debt) float64 { return d.AmountActualDebt })),
+	"amount_purchased_loan":          debtColumn("Сумма выкупленного кредита", floatCell(func(d domain.Debt) float64 { return d.AmountPurchasedLoan })),
+	"init_amount_actual_debt":        debtColumn("Сумма выкупленного долга", floatCell(func(d domain.Debt) float64 { return d.InitAmountActualDebt })),
+	"amount_credit":                  debtColumn("Сумма кредита", floatCell(func(d domain.Debt) float64 { return d.AmountCredit })),
+	"amount_main_debt":               debtColumn("Сумма основного долга", floatCell(func(d domain.Debt) float64 { return floatPtr(d.AmountMainDebt) })),
+	"amount_fine":                    debtColumn("Пеня", floatCell(func(d domain.Debt) float64 { return d.AmountFine })),
+	"amount_accrual":                 debtColumn("Начисленное вознаграждение по Договору займа", floatCell(func(d domain.Debt) float64 { return d.AmountAccrual })),
+	"amount_government_duty":         debtColumn("Гос.пошлина", floatCell(func(d domain.Debt) float64 { return d.AmountGovernmentDuty })),
+	"amount_representation_expenses": debtColumn("Представительские расходы", floatCell(func(d domain.Debt) float64 { return d.AmountRepresentationExp })),
+	"amount_notary_fees":             debtColumn("Нотариальные расходы", floatCell(func(d domain.Debt) float64 { return d.AmountNotaryFees })),
+	"amount_postage":                 debtColumn("Почтовые расходы", floatCell(func(d domain.Debt) float64 { return d.AmountPostage })),
+	"transfer_decision":              debtColumn("Решение о передаче", stringCell(func(d domain.Debt) string { return strPtr(d.TransferDecision) })),
+	"presence_solidarity":            debtColumn("Наличие солидарности", boolCell(func(d domain.Debt) bool { return d.PresenceSolidarity })),
+	"government_duty_paid":           debtColumn("Гос.пошлина оплачена", boolCell(func(d domain.Debt) bool { return d.GovernmentDutyPaid })),
+	"government_duty_refund":         debtColumn("Возврат гос.пошлины", boolCell(func(d domain.Debt) bool { return d.GovernmentDutyRefund })),
+	"representation_expenses_paid":   debtColumn("Представительские расходы оплачены", boolCell(func(d domain.Debt) bool { return d.RepresentationExpensesPaid })),
+	"late_due_date":                  debtColumn("Дата вынесения на просрочку", timeCell(func(d domain.Debt) *time.Time { return d.LateDueDate })),
+	"next_contact":                   debtColumn("Дата следующего контакта", timeCell(func(d domain.Debt) *time.Time { return d.NextContact })),
+	"last_contact":                   debtColumn("Последний контакт", timeCell(func(d domain.Debt) *time.Time { return d.LastContact })),
 	"additional_data": {
 		Header: "Дополнительные данные",
 		Value: func(d domain.Debt) any {
@@ -242,40 +297,131 @@ var debtColumns = map[string]DebtColumn{
 			return string(d.AdditionalData)
 		},
 	},
-	"number": {
-		Header: "Номер договора",
-		Value:  func(d domain.Debt) any { return d.Number },
-	},
+	"number": debtColumn("Номер договора", stringCell(func(d domain.Debt) string { return d.Number })).
+		withLink(func(d domain.Debt, links LinkTemplates) string { return links.debt(d.ID, d.Number) }),
+
+	// payment history of the debt, see debtPaymentsPrefix
+	"payments.last_date":       debtColumn("Дата последнего платежа", timeCell(func(d domain.Debt) *time.Time { return d.LastPaymentDate })),
+	"payments.total_paid":      debtColumn("Всего оплачено", floatCell(func(d domain.Debt) float64 { return d.TotalPaid })),
+	"payments.paid_this_month": debtColumn("Оплачено в текущем месяце", floatCell(func(d domain.Debt) float64 { return d.PaidThisMonth })),
+
+	// latest court case and enforcement proceeding, see debtLegalPrefixes
+	"court.case_number":      debtColumn("Номер судебного дела", stringCell(func(d domain.Debt) string { return strPtr(d.CourtCaseNumber) })),
+	"court.name":             debtColumn("Суд", stringCell(func(d domain.Debt) string { return strPtr(d.CourtName) })),
+	"court.stage":            debtColumn("Стадия судебного дела", stringCell(func(d domain.Debt) string { return strPtr(d.CourtStage) })),
+	"enforcement.number":     debtColumn("Номер исполнительного производства", stringCell(func(d domain.Debt) string { return strPtr(d.EnforcementNumber) })),
+	"enforcement.agent":      debtColumn("Судебный исполнитель", stringCell(func(d domain.Debt) string { return strPtr(d.EnforcementAgent) })),
+	"enforcement.started_at": debtColumn("Дата возбуждения исполнительного производства", timeCell(func(d domain.Debt) *time.Time { return d.EnforcementStartedAt })),
 }
 
-func (s *DebtService) saveExportStatus(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
+// debtPaymentsPrefix marks the columns aggregated from the payments of a
+// debt; the aggregate join is only made when one of them is exported.
+const debtPaymentsPrefix = "payments."
 
-	data, err := json.Marshal(st)
-	if err != nil {
-		return err
+// debtLegalPrefixes mark the columns of the court module, joined on demand
+// the same way.
+var debtLegalPrefixes = []string{"court.", "enforcement."}
+
+// debtContactsPrefixes mark the columns of the debtor's phones and addresses.
+var debtContactsPrefixes = []string{"debtor.phone", "debtor.addresses"}
+
+// debtorPhoneColumn is the n-th phone of the debtor (from 1), for call
+// lists that need one number per cell.
+func debtorPhoneColumn(n int) DebtColumn {
+	return DebtColumn{
+		Header: fmt.Sprintf("Телефон %d", n),
+		Value: func(d domain.Debt) any {
+			if len(d.DebtorPhones) < n {
+				return ""
+			}
+			return d.DebtorPhones[n-1]
+		},
 	}
+}
 
-	if err := s.redis.Set(ctx, st.Key, string(data), exportTTL); err != nil {
-		return err
+// selectsColumns reports whether the columns or the computed columns of an
+// export read a column with one of prefixes.
+func selectsColumns(keys []string, computed []ComputedColumn, prefixes ...string) bool {
+	matches := func(key string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, key := range keys {
+		if matches(key) {
+			return true
+		}
 	}
+	for _, def := range computed {
+		e, err := expr.Parse(def.Expr)
+		if err != nil {
+			continue
+		}
+		for _, field := range e.Fields() {
+			if matches(field) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	return s.redis.SAdd(ctx, exportSetKey, st.Key)
+var guarantorTypeDisplay = map[string]string{
+	"co_borrower": "Созаемщик",
+	"guarantor":   "Гарант",
 }
 
-func (s *DebtService) toCacheItem(st *ExportStatus) ExportCacheItem {
-	created := st.Created.Format("2006-01-02 15:04:05")
-	return ExportCacheItem{
-		Key:      st.Key,
-		Type:     st.Type,
-		UserID:   st.UserID,
-		Progress: st.Progress,
-		FileURL:  st.FileURL,
-		Error:    st.Error,
-		Created:  created,
-	}
+type GuarantorColumn struct {
+	// Key identifies the column for ColumnMasks.
+	Key    string
+	Header string
+	Value  func(g domain.Guarantor) any
+}
+
+// guarantorColumns are written to the optional guarantors sheet in this order;
+// the contract number links each row back to the main debts sheet.
+var guarantorColumns = []GuarantorColumn{
+	{
+		Key:    "guarantors.debt_number",
+		Header: "Номер договора",
+		Value:  func(g domain.Guarantor) any { return g.DebtNumber },
+	},
+	{
+		Key:    "guarantors.type",
+		Header: "Роль",
+		Value: func(g domain.Guarantor) any {
+			t := strPtr(g.Type)
+			if title, ok := guarantorTypeDisplay[t]; ok {
+				return title
+			}
+			return t
+		},
+	},
+	{
+		Key:    "guarantors.full_name",
+		Header: "ФИО",
+		Value: func(g domain.Guarantor) any {
+			parts := []string{
+				strPtr(g.LastName),
+				strPtr(g.FirstName),
+				strPtr(g.MiddleName),
+			}
+			return strings.TrimSpace(strings.Join(parts, " "))
+		},
+	},
+	{
+		Key:    "guarantors.iin",
+		Header: "ИИН",
+		Value:  func(g domain.Guarantor) any { return strPtr(g.IIN) },
+	},
+	{
+		Key:    "guarantors.phone",
+		Header: "Телефон",
+		Value:  func(g domain.Guarantor) any { return strPtr(g.Phone) },
+	},
 }
 
 func phpSerializeExportItem(item ExportCacheItem) string {
@@ -323,22 +469,11 @@ func phpSerializeExportItem(item ExportCacheItem) string {
 	return b.String()
 }
 
-func (s *DebtService) saveLaravelCache(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-
-	cacheKey := s.cachePrefix + st.Key
-	item := s.toCacheItem(st)
-	serialized := phpSerializeExportItem(item)
-
-	return s.redis.Set(ctx, cacheKey, serialized, exportTTL)
-}
-
 func (s *DebtService) StartDebtsExport(
 	ctx context.Context,
 	selected []string,
 	filter repository.DebtsFilter,
+	opts DebtsExportOptions,
 	userID int64,
 ) (string, error) {
 	if len(selected) == 0 {
@@ -349,186 +484,485 @@ func (s *DebtService) StartDebtsExport(
 		}
 	}
 
-	exportID := fmt.Sprintf("exports:%s", uuid.NewString())
-	now := time.Now()
+	params := debtsExportParams{Selected: selected, Filter: filter, Options: opts}
+	return s.startDebtsExport(ctx, params, userID, firstAttempt)
+}
+
+// RetryExport re-runs a failed debts export or aging report with its original
+// parameters.
+func (s *DebtService) RetryExport(ctx context.Context, original *ExportStatus) (string, error) {
+	if original.Type == debtsAgingType {
+		return s.retryAgingReport(ctx, original)
+	}
+
+	var params debtsExportParams
+	if err := json.Unmarshal(original.Params, &params); err != nil {
+		return "", fmt.Errorf("failed to decode export params: %w", err)
+	}
+	return s.startDebtsExport(ctx, params, original.UserID, nextAttempt(original))
+}
+
+// runQueued runs a debts export or aging report taken from the job queue.
+func (s *DebtService) runQueued(ctx context.Context, status *ExportStatus) {
+	if status.Type == debtsAgingType {
+		if params, ok := queuedParams[debtsAgingParams](ctx, status, s.failExport); ok {
+			s.runAgingReport(ctx, status, params)
+		}
+		return
+	}
+	if params, ok := queuedParams[debtsExportParams](ctx, status, s.failExport); ok {
+		s.runDebtsExport(ctx, status, params.Selected, params.Filter, params.Options)
+	}
+}
+
+func (s *DebtService) startDebtsExport(ctx context.Context, params debtsExportParams, userID int64, attempt exportAttempt) (string, error) {
+	// masked columns are dropped before anything is recorded
+	params.Options.HiddenColumns = withCallerMasks(ctx, params.Options.HiddenColumns)
+	params.Selected = visibleColumns(params.Selected, params.Options.HiddenColumns)
 
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "debts",
-		UserID:   userID,
-		Filters:  buildDebtsFiltersMap(filter, selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  now,
+	if err := s.deliverers.check(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if err := s.deliverers.checkSink(params.Options.ExportOptions); err != nil {
+		return "", err
+	}
+	if s.deliverers.sink(params.Options.Delivery) != nil && (params.Options.IncludeGuarantors || params.Options.SplitBy != "") {
+		return "", fmt.Errorf("%w: a row delivery takes a single sheet, it cannot be combined with include_guarantors or split_by", ErrInvalidDelivery)
+	}
+	if err := checkTemplate(ctx, s.templates, params.Options.Template); err != nil {
+		return "", err
+	}
+	if params.Options.Template != "" && (params.Options.IncludeGuarantors || params.Options.SplitBy != "") {
+		return "", fmt.Errorf("%w: a template holds a single sheet, it cannot be combined with include_guarantors or split_by", ErrInvalidTemplate)
+	}
+	if err := checkOutput(params.Options.ExportOptions); err != nil {
+		return "", err
+	}
+	if ext := params.Options.fileExt(); ext != ".xlsx" && (params.Options.IncludeGuarantors || params.Options.SplitBy != "") {
+		return "", fmt.Errorf("%w: %s holds a single sheet, it cannot be combined with include_guarantors or split_by", ErrInvalidOutput, strings.TrimPrefix(ext, "."))
+	}
+	if _, err := debtComputedColumns(params.Options.Computed, params.Options.HiddenColumns); err != nil {
+		return "", err
 	}
 
+	tooMany, err := s.repo.HasMoreThan(ctx, maxDebtsForExport, params.Filter)
+	if err != nil {
+		return "", err
+	}
+	if tooMany {
+		return "", tooManyRows("долгов", maxDebtsForExport)
+	}
+
+	status := newExportStatus(
+		ctx,
+		"debts",
+		userID,
+		s.buildDebtsFiltersMap(ctx, params.Filter, params.Selected, params.Options),
+		params,
+		attempt,
+	)
+	status.Delivery = newDeliveryStatus(params.Options.Delivery)
+	status.Comment = params.Options.Comment
+
 	_ = s.saveExportStatus(ctx, status)
-	_ = s.saveLaravelCache(ctx, status)
 
-	go s.runDebtsExport(context.Background(), exportID, selected, filter, userID, now)
+	// the job outlives the request but keeps its values (tenant) for scoping
+	fail := func(ctx context.Context, errStr string) { s.failExport(ctx, status, errStr) }
+	if err := runJob(ctx, s.jobs, s.queue, status, fail, func(ctx context.Context) {
+		s.runDebtsExport(ctx, status, params.Selected, params.Filter, params.Options)
+	}); err != nil {
+		return "", err
+	}
 
-	return exportID, nil
+	return status.Key, nil
+}
+
+// EstimateDebtsExport counts the rows an export with these parameters would produce.
+func (s *DebtService) EstimateDebtsExport(ctx context.Context, selected []string, filter repository.DebtsFilter) (ExportEstimate, error) {
+	rows, err := s.repo.Count(ctx, filter)
+	if err != nil {
+		return ExportEstimate{}, err
+	}
+
+	columns := 0
+	for _, key := range visibleColumns(selected, withCallerMasks(ctx, nil)) {
+		if _, ok := debtColumns[key]; ok {
+			columns++
+		}
+	}
+
+	return newExportEstimate(rows, columns, maxDebtsForExport), nil
 }
 
 func (s *DebtService) runDebtsExport(
 	ctx context.Context,
-	exportID string,
+	status *ExportStatus,
 	selected []string,
 	filter repository.DebtsFilter,
-	userID int64,
-	createdAt time.Time,
+	opts DebtsExportOptions,
 ) {
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "debts",
-		UserID:   userID,
-		Filters:  buildDebtsFiltersMap(filter, selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  createdAt,
-	}
-
-	debts, err := s.repo.List(ctx, filter)
+	status.enter(ExportRunning)
+	exportID, userID := status.Key, status.UserID
+
+	visible := visibleColumns(selected, opts.HiddenColumns)
+	filter.PaymentHistory = selectsColumns(visible, opts.Computed, debtPaymentsPrefix)
+	filter.LegalCase = selectsColumns(visible, opts.Computed, debtLegalPrefixes...)
+	filter.Contacts = selectsColumns(visible, opts.Computed, debtContactsPrefixes...)
+
+	var debts []domain.Debt
+	err := s.retry.Do(ctx, "export "+exportID+": query", func() error {
+		var err error
+		debts, err = s.repo.List(ctx, filter)
+		return err
+	})
 	if err != nil {
-		// можно было бы сохранить ошибку в отдельное поле, если надо
+		s.failExport(ctx, status, fmt.Sprintf("query failed: %v", err))
 		return
 	}
 
 	var cols []DebtColumn
-	for _, key := range selected {
+	for _, key := range visibleColumns(selected, opts.HiddenColumns) {
 		col, ok := debtColumns[key]
 		if !ok {
 			continue
 		}
 		cols = append(cols, col)
 	}
+	computed, err := debtComputedColumns(opts.Computed, opts.HiddenColumns)
+	if err != nil {
+		s.failExport(ctx, status, err.Error())
+		return
+	}
+	cols = append(cols, computed...)
 	if len(cols) == 0 {
+		s.failExport(ctx, status, "no valid columns selected")
 		return
 	}
 
-	f := excelize.NewFile()
-	sheet := "Debts"
-	f.SetSheetName(f.GetSheetName(0), sheet)
+	var data []byte
+	fileName := exportFilename(s.filenameTpl, opts.ExportOptions, filenameFields{
+		Type:         "debts",
+		UserID:       userID,
+		Counterparty: filter.CounterpartyID,
+		ExportID:     exportID,
+	})
+	if opts.SplitBy == DebtsSplitByCounterparty {
+		data, err = s.writeDebtsArchive(ctx, status, cols, debts, filter, opts)
+		fileName = archiveFilename(fileName)
+	} else {
+		data, err = s.writeDebtsWorkbook(ctx, status, cols, debts, filter, opts)
+	}
+	if err != nil {
+		s.failExport(ctx, status, err.Error())
+		return
+	}
+	data, err = applyTemplate(ctx, s.templates, opts.Template, data, templateVars{Type: "debts", UserID: userID, ExportID: exportID, Comment: opts.Comment})
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("render template failed: %v", err))
+		return
+	}
+	if data, err = applyOutput(opts.ExportOptions, data, sampleColumns(cols)); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write output failed: %v", err))
+		return
+	}
 
-	_ = f.SetDocProps(&excelize.DocProperties{
-		Creator: fmt.Sprintf("user_%d", userID),
+	if s.deliverers.sink(opts.ExportOptions.Delivery) != nil {
+		s.sinkExport(ctx, status, opts.ExportOptions, data, sinkColumnNames(visible, debtColumns, opts.ExportOptions.Computed), sampleColumns(cols))
+		return
+	}
+
+	s.saveExportFile(ctx, status, opts.ExportOptions, fileName, data)
+}
+
+// writeDebtsWorkbook renders all debts into a single workbook.
+func (s *DebtService) writeDebtsWorkbook(
+	ctx context.Context,
+	status *ExportStatus,
+	cols []DebtColumn,
+	debts []domain.Debt,
+	filter repository.DebtsFilter,
+	opts DebtsExportOptions,
+) ([]byte, error) {
+	f := newDebtsWorkbook(status.UserID)
+	pacer := newRowPacer(len(debts), s.progressEvery)
+	err := writeDebtsSheet(f, cols, s.links, debts, func(written int) error {
+		return s.reportRows(ctx, status, pacer, written)
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	for i, col := range cols {
-		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
-		_ = f.SetCellValue(sheet, cell, col.Header)
+	if opts.IncludeGuarantors {
+		guarantors, err := s.listGuarantors(ctx, status, filter)
+		if err != nil {
+			return nil, err
+		}
+		writeGuarantorsSheet(f, guarantors, opts.HiddenColumns)
+	}
+	if err := writeInfoSheet(f, status, debtHeaders(cols), len(debts), time.Now()); err != nil {
+		return nil, fmt.Errorf("write workbook failed: %v", err)
 	}
 
-	total := len(debts)
-	if total == 0 {
-		// don't set progress to 100 here — file URL is not ready yet.
-		// keep progress at 0 and continue to generate/upload the file;
-		// final 100 will be set only after successful upload and URL generation.
-	} else {
-		chunkSize := 1000
-		rowIdx := 2
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-		for i, d := range debts {
-			for colIdx, col := range cols {
-				cell, _ := excelize.CoordinatesToCellName(colIdx+1, rowIdx)
-				_ = f.SetCellValue(sheet, cell, col.Value(d))
-			}
-			rowIdx++
-
-			if (i+1)%chunkSize == 0 || i == total-1 {
-				raw := float64(i+1) / float64(total) * 100.0
-				progress := math.Round(raw)
-				// Never report 100% based on row processing — reserve 100% for when file_url is ready
-				if progress >= 100 {
-					progress = 95
-				}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("write workbook failed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
 
-				status.Progress = progress
+// writeDebtsArchive renders one workbook per counterparty, each with the
+// guarantors of its own debts, and zips them together.
+func (s *DebtService) writeDebtsArchive(
+	ctx context.Context,
+	status *ExportStatus,
+	cols []DebtColumn,
+	debts []domain.Debt,
+	filter repository.DebtsFilter,
+	opts DebtsExportOptions,
+) ([]byte, error) {
+	var guarantors map[string][]domain.Guarantor
+	if opts.IncludeGuarantors {
+		list, err := s.listGuarantors(ctx, status, filter)
+		if err != nil {
+			return nil, err
+		}
+		guarantors = make(map[string][]domain.Guarantor)
+		for _, g := range list {
+			guarantors[g.DebtNumber] = append(guarantors[g.DebtNumber], g)
+		}
+	}
 
-				_ = s.saveExportStatus(ctx, status)
-				_ = s.saveLaravelCache(ctx, status)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	names := map[string]bool{}
+	written := 0
+	pacer := newRowPacer(len(debts), s.progressEvery)
+
+	for _, group := range groupDebtsByCounterparty(debts) {
+		f := newDebtsWorkbook(status.UserID)
+		offset := written
+		err := writeDebtsSheet(f, cols, s.links, group.debts, func(n int) error {
+			written = offset + n
+			return s.reportRows(ctx, status, pacer, written)
+		})
+		if err != nil {
+			return nil, err
+		}
 
-				if s.ws != nil {
-					_ = s.ws.NotifyExportProgress(ctx, userID, exportID, progress, "generating")
-				}
+		if opts.IncludeGuarantors {
+			var own []domain.Guarantor
+			for _, d := range group.debts {
+				own = append(own, guarantors[d.Number]...)
 			}
+			writeGuarantorsSheet(f, own, opts.HiddenColumns)
+		}
+		if err := writeInfoSheet(f, status, debtHeaders(cols), len(group.debts), time.Now()); err != nil {
+			return nil, fmt.Errorf("write workbook failed: %v", err)
+		}
 
+		w, err := zw.Create(uniqueArchiveName(names, group.name))
+		if err != nil {
+			return nil, fmt.Errorf("write archive failed: %v", err)
+		}
+		if err := f.Write(w); err != nil {
+			return nil, fmt.Errorf("write workbook failed: %v", err)
 		}
 	}
 
-	buf, err := f.WriteToBuffer()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("write archive failed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *DebtService) listGuarantors(ctx context.Context, status *ExportStatus, filter repository.DebtsFilter) ([]domain.Guarantor, error) {
+	var guarantors []domain.Guarantor
+	err := s.retry.Do(ctx, "export "+status.Key+": guarantors", func() error {
+		var err error
+		guarantors, err = s.repo.ListGuarantors(ctx, filter)
+		return err
+	})
 	if err != nil {
-		return
+		return nil, fmt.Errorf("guarantors query failed: %v", err)
 	}
-	data := buf.Bytes()
+	return guarantors, nil
+}
 
-	fileName := fmt.Sprintf("debts_%s.xlsx", time.Now().Format("20060102_150405"))
+// reportRows saves and sends the generating progress when pacer says it is due
+// and stops the export once its context is done.
+func (s *DebtService) reportRows(ctx context.Context, status *ExportStatus, pacer *rowPacer, written int) error {
+	if !pacer.due(written) {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	if s.s3 != nil {
-		// notify upload phase before starting upload
-		status.Progress = 95
-		_ = s.saveExportStatus(ctx, status)
-		_ = s.saveLaravelCache(ctx, status)
-		if s.ws != nil {
-			_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 95, "uploading")
-		}
+	eventCtx := pacer.report(ctx, status, written)
 
-		savedName, err := s.s3.Save(ctx, fileName, data)
-		if err != nil {
-			errStr := fmt.Sprintf("save export failed: %v", err)
-			log.Printf("export %s: %s", exportID, errStr)
-			status.Error = &errStr
-			status.Progress = 100
+	_ = s.saveExportStatus(ctx, status)
+
+	if s.ws != nil {
+		_ = s.ws.NotifyExportProgress(eventCtx, status.UserID, status.Key, status.Progress, "generating")
+	}
+	return nil
+}
 
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
+func newDebtsWorkbook(userID int64) *excelize.File {
+	f := excelize.NewFile()
+	f.SetSheetName(f.GetSheetName(0), "Debts")
 
-			if s.ws != nil {
-				_ = s.ws.NotifyExportFailed(ctx, userID, exportID, errStr)
-			}
-		} else {
-			url := s.s3.GetURL(savedName)
-			status.FileURL = &url
-			status.Progress = 100
+	_ = f.SetDocProps(&excelize.DocProperties{
+		Creator: fmt.Sprintf("user_%d", userID),
+	})
+	return f
+}
 
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
+func debtHeaders(cols []DebtColumn) []string {
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = col.Header
+	}
+	return headers
+}
 
-			if s.ws != nil {
-				_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 100, "ready")
-				_ = s.ws.NotifyExportComplete(ctx, userID, exportID, url, fileName)
+// writeDebtsSheet fills the "Debts" sheet; report is called after every row
+// with the number of rows written so far.
+func writeDebtsSheet(f *excelize.File, cols []DebtColumn, links LinkTemplates, debts []domain.Debt, report func(written int) error) error {
+	sheet := "Debts"
+	for i, col := range cols {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		_ = f.SetCellValue(sheet, cell, col.Header)
+	}
+
+	w := newSheetWriter(f, sheet, len(cols))
+	for i := range debts {
+		d := &debts[i]
+		w.row(i + 2)
+		for colIdx, col := range cols {
+			w.at(colIdx)
+			if col.Write != nil {
+				col.Write(d, w)
+			} else {
+				w.writeValue(col.Value(*d))
+			}
+			if col.Link != nil {
+				if link := col.Link(*d, links); link != "" {
+					w.hyperlink(link)
+				}
 			}
 		}
+		if err := report(i + 1); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func buildDebtsFiltersMap(f repository.DebtsFilter, fields []string) map[string]interface{} {
-	m := map[string]interface{}{}
-	if f.UserID != nil {
-		m["user_id"] = *f.UserID
-	} else {
-		m["user_id"] = nil
+// noCounterpartyName names the archive entry of debts without a counterparty.
+const noCounterpartyName = "Без контрагента"
+
+type debtsGroup struct {
+	name  string
+	debts []domain.Debt
+}
+
+// groupDebtsByCounterparty splits debts by counterparty name, keeping the
+// order of debts within a group; groups are sorted by name.
+func groupDebtsByCounterparty(debts []domain.Debt) []debtsGroup {
+	index := map[string]int{}
+	var groups []debtsGroup
+	for _, d := range debts {
+		name := strPtr(d.CounterpartyName)
+		if strings.TrimSpace(name) == "" {
+			name = noCounterpartyName
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, debtsGroup{name: name})
+		}
+		groups[i].debts = append(groups[i].debts, d)
 	}
-	if f.StatusID != nil {
-		m["status_id"] = *f.StatusID
-	} else {
-		m["status_id"] = nil
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
+
+// uniqueArchiveName returns the workbook name of a counterparty; names that
+// collide after sanitizing get a " (2)"-style suffix.
+func uniqueArchiveName(used map[string]bool, counterparty string) string {
+	base := sanitizeFilename(counterparty)
+	if base == "" {
+		base = "counterparty"
 	}
-	if f.RegistryID != nil {
-		m["registry_id"] = *f.RegistryID
-	} else {
-		m["registry_id"] = nil
+	name := base + ".xlsx"
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d).xlsx", base, n)
 	}
-	if f.CounterpartyID != nil {
-		m["counterparty_id"] = *f.CounterpartyID
-	} else {
-		m["counterparty_id"] = nil
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// writeGuarantorsSheet appends a "Поручители" sheet listing co-debtors and
+// guarantors without the hidden columns; it is written even when empty so the
+// workbook layout is stable.
+func writeGuarantorsSheet(f *excelize.File, guarantors []domain.Guarantor, hidden []string) {
+	sheet := "Поручители"
+	if _, err := f.NewSheet(sheet); err != nil {
+		return
 	}
-	if f.DepartmentID != nil {
-		m["department_id"] = *f.DepartmentID
-	} else {
-		m["department_id"] = nil
+
+	var cols []GuarantorColumn
+	for _, col := range guarantorColumns {
+		if !columnHidden(hidden, col.Key) {
+			cols = append(cols, col)
+		}
+	}
+
+	for i, col := range cols {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		_ = f.SetCellValue(sheet, cell, col.Header)
+	}
+
+	for rowIdx, g := range guarantors {
+		for colIdx, col := range cols {
+			cell, _ := excelize.CoordinatesToCellName(colIdx+1, rowIdx+2)
+			_ = f.SetCellValue(sheet, cell, col.Value(g))
+		}
+	}
+}
+
+func (s *DebtService) buildDebtsFiltersMap(ctx context.Context, f repository.DebtsFilter, fields []string, opts DebtsExportOptions) map[string]interface{} {
+	m := repository.DebtsFilters.Map(f)
+	addFilterNames(ctx, s.names, m, repository.DebtsFilters.Refs(f))
+	m["include_guarantors"] = opts.IncludeGuarantors
+	if opts.SplitBy != "" {
+		m["split_by"] = opts.SplitBy
 	}
 	m["fields"] = fields
 	return m
 }
+
+// debtComputedColumns compiles the computed columns of a debts export over debtColumns.
+func debtComputedColumns(defs []ComputedColumn, hidden []string) ([]DebtColumn, error) {
+	computed, err := compileComputedColumns(defs, func(key string) (func(domain.Debt) any, bool) {
+		col, ok := debtColumns[key]
+		return col.Value, ok && !columnHidden(hidden, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DebtColumn, 0, len(computed))
+	for _, c := range computed {
+		out = append(out, DebtColumn{Header: c.Header, Value: c.Value})
+	}
+	return out, nil
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// debtsAgingType is the export type of the portfolio aging report.
+const debtsAgingType = "debts_aging"
+
+// debtsAgingParams is the persisted form of an aging report request.
+type debtsAgingParams struct {
+	Filter  repository.DebtsFilter  `json:"filter"`
+	GroupBy repository.DebtsGroupBy `json:"group_by"`
+	Options ExportOptions           `json:"options"`
+}
+
+// StartAgingReport exports the debts matching filter bucketed by days overdue
+// (0–30, 31–60, 61–90, 90+) with their count and actual debt per counterparty
+// or department (groupBy). Aggregation happens in the database.
+func (s *DebtService) StartAgingReport(
+	ctx context.Context,
+	filter repository.DebtsFilter,
+	groupBy repository.DebtsGroupBy,
+	opts ExportOptions,
+	userID int64,
+) (string, error) {
+	if groupBy == "" {
+		groupBy = repository.DebtsGroupByCounterparty
+	}
+	params := debtsAgingParams{Filter: filter, GroupBy: groupBy, Options: opts}
+	return s.startAgingReport(ctx, params, userID, firstAttempt)
+}
+
+func (s *DebtService) retryAgingReport(ctx context.Context, original *ExportStatus) (string, error) {
+	var params debtsAgingParams
+	if err := json.Unmarshal(original.Params, &params); err != nil {
+		return "", fmt.Errorf("failed to decode export params: %w", err)
+	}
+	return s.startAgingReport(ctx, params, original.UserID, nextAttempt(original))
+}
+
+func (s *DebtService) startAgingReport(ctx context.Context, params debtsAgingParams, userID int64, attempt exportAttempt) (string, error) {
+	if params.GroupBy != repository.DebtsGroupByCounterparty && params.GroupBy != repository.DebtsGroupByDepartment {
+		return "", fmt.Errorf("unknown report grouping %q", params.GroupBy)
+	}
+	if err := s.deliverers.check(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if err := s.deliverers.checkFileDelivery(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if len(params.Options.Computed) > 0 {
+		return "", fmt.Errorf("%w: reports have no computed columns", ErrInvalidComputedColumn)
+	}
+	if params.Options.Template != "" {
+		return "", fmt.Errorf("%w: reports have their own layout", ErrInvalidTemplate)
+	}
+	params.Options.HiddenColumns = withCallerMasks(ctx, params.Options.HiddenColumns)
+
+	filters := s.buildDebtsFiltersMap(ctx, params.Filter, nil, DebtsExportOptions{})
+	delete(filters, "fields")
+	delete(filters, "include_guarantors")
+	filters["group_by"] = string(params.GroupBy)
+
+	status := newExportStatus(ctx, debtsAgingType, userID, filters, params, attempt)
+	status.Delivery = newDeliveryStatus(params.Options.Delivery)
+	status.Comment = params.Options.Comment
+
+	_ = s.saveExportStatus(ctx, status)
+
+	fail := func(ctx context.Context, errStr string) { s.failExport(ctx, status, errStr) }
+	if err := runJob(ctx, s.jobs, s.queue, status, fail, func(ctx context.Context) {
+		s.runAgingReport(ctx, status, params)
+	}); err != nil {
+		return "", err
+	}
+
+	return status.Key, nil
+}
+
+func (s *DebtService) runAgingReport(ctx context.Context, status *ExportStatus, params debtsAgingParams) {
+	status.enter(ExportRunning)
+	exportID, userID := status.Key, status.UserID
+
+	var counts []domain.DebtAgingCount
+	err := s.retry.Do(ctx, "export "+exportID+": query", func() error {
+		var err error
+		counts, err = s.repo.AgingCounts(ctx, params.Filter, params.GroupBy)
+		return err
+	})
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("query failed: %v", err))
+		return
+	}
+
+	status.Progress = 50
+	_ = s.saveExportStatus(ctx, status)
+	if s.ws != nil {
+		_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 50, "generating")
+	}
+
+	// the amounts are a sum of the actual debt, masked along with it
+	withSums := !columnHidden(params.Options.HiddenColumns, "amount_actual_debt")
+	data, err := writeAgingReport(status, params.GroupBy, counts, withSums, time.Now())
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
+	}
+
+	fileName := exportFilename(s.filenameTpl, params.Options, filenameFields{
+		Type:         debtsAgingType,
+		UserID:       userID,
+		Counterparty: params.Filter.CounterpartyID,
+		ExportID:     exportID,
+	})
+
+	s.saveExportFile(ctx, status, params.Options, fileName, data)
+}
+
+// agingBucketHeaders returns the bucket titles in the order of the report
+// columns: the buckets of repository.AgingBucketBounds, then debts without an
+// overdue date.
+func agingBucketHeaders() []string {
+	headers := make([]string, 0, len(repository.AgingBucketBounds)+2)
+	from := 0
+	for _, bound := range repository.AgingBucketBounds {
+		headers = append(headers, fmt.Sprintf("%d–%d дн.", from, bound))
+		from = bound + 1
+	}
+	return append(headers, fmt.Sprintf("%d+ дн.", from-1), "Без даты просрочки")
+}
+
+// writeAgingReport renders the summary sheet: a title, two header rows (bucket,
+// then count and, withSums, amount), one row per group and a totals row.
+func writeAgingReport(status *ExportStatus, groupBy repository.DebtsGroupBy, counts []domain.DebtAgingCount, withSums bool, now time.Time) ([]byte, error) {
+	buckets := agingBucketHeaders()
+	// column pair of a bucket; the one without a date goes last
+	pair := func(bucket int) int {
+		if bucket < 0 || bucket >= len(buckets)-1 {
+			return len(buckets) - 1
+		}
+		return bucket
+	}
+
+	type groupRow struct {
+		name   string
+		counts []int64
+		sums   []float64
+	}
+	rows := map[string]*groupRow{}
+	var names []string
+	total := groupRow{counts: make([]int64, len(buckets)+1), sums: make([]float64, len(buckets)+1)}
+	for _, c := range counts {
+		g, ok := rows[c.Group]
+		if !ok {
+			g = &groupRow{name: c.Group, counts: make([]int64, len(buckets)+1), sums: make([]float64, len(buckets)+1)}
+			rows[c.Group] = g
+			names = append(names, c.Group)
+		}
+		i := pair(c.Bucket)
+		for _, r := range []*groupRow{g, &total} {
+			r.counts[i] += c.Count
+			r.sums[i] += c.Amount
+			r.counts[len(buckets)] += c.Count
+			r.sums[len(buckets)] += c.Amount
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		// the group without a name goes last
+		if (names[i] == "") != (names[j] == "") {
+			return names[j] == ""
+		}
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	groupHeader, noGroup := "Контрагент", "Без контрагента"
+	if groupBy == repository.DebtsGroupByDepartment {
+		groupHeader, noGroup = "Отдел", "Без отдела"
+	}
+
+	f := excelize.NewFile()
+	sheet := "Aging"
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	_ = f.SetDocProps(&excelize.DocProperties{
+		Creator: fmt.Sprintf("user_%d", status.UserID),
+	})
+
+	border := []excelize.Border{
+		{Type: "left", Color: "A6A6A6", Style: 1},
+		{Type: "right", Color: "A6A6A6", Style: 1},
+		{Type: "top", Color: "A6A6A6", Style: 1},
+		{Type: "bottom", Color: "A6A6A6", Style: 1},
+	}
+	titleStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return nil, err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"D9E1F2"}},
+		Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center", WrapText: true},
+		Border:    border,
+	})
+	if err != nil {
+		return nil, err
+	}
+	countStyle, err := f.NewStyle(&excelize.Style{NumFmt: 3, Border: border})
+	if err != nil {
+		return nil, err
+	}
+	sumStyle, err := f.NewStyle(&excelize.Style{NumFmt: 4, Border: border})
+	if err != nil {
+		return nil, err
+	}
+	nameStyle, err := f.NewStyle(&excelize.Style{Border: border})
+	if err != nil {
+		return nil, err
+	}
+	totalCountStyle, err := f.NewStyle(&excelize.Style{NumFmt: 3, Border: border, Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	totalSumStyle, err := f.NewStyle(&excelize.Style{NumFmt: 4, Border: border, Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+
+	cell := func(col, row int) string {
+		name, _ := excelize.CoordinatesToCellName(col, row)
+		return name
+	}
+	// columns per bucket
+	per := 1
+	if withSums {
+		per = 2
+	}
+	lastCol := 1 + per*(len(buckets)+1)
+
+	_ = f.SetCellValue(sheet, "A1", "Портфель по срокам просрочки на "+now.Format("2006-01-02"))
+	_ = f.SetCellStyle(sheet, "A1", "A1", titleStyle)
+
+	// header: the group column spans both rows, every bucket spans count and amount
+	const headerRow = 3
+	_ = f.SetCellValue(sheet, cell(1, headerRow), groupHeader)
+	_ = f.MergeCell(sheet, cell(1, headerRow), cell(1, headerRow+1))
+	for i, title := range append(buckets, "Итого") {
+		col := 2 + per*i
+		_ = f.SetCellValue(sheet, cell(col, headerRow), title)
+		_ = f.SetCellValue(sheet, cell(col, headerRow+1), "Кол-во")
+		if withSums {
+			_ = f.MergeCell(sheet, cell(col, headerRow), cell(col+1, headerRow))
+			_ = f.SetCellValue(sheet, cell(col+1, headerRow+1), "Сумма")
+		}
+	}
+	_ = f.SetCellStyle(sheet, cell(1, headerRow), cell(lastCol, headerRow+1), headerStyle)
+
+	writeRow := func(rowIdx int, name string, r *groupRow, countStyle, sumStyle int) {
+		_ = f.SetCellValue(sheet, cell(1, rowIdx), name)
+		for i := range r.counts {
+			col := 2 + per*i
+			_ = f.SetCellValue(sheet, cell(col, rowIdx), r.counts[i])
+			_ = f.SetCellStyle(sheet, cell(col, rowIdx), cell(col, rowIdx), countStyle)
+			if withSums {
+				_ = f.SetCellValue(sheet, cell(col+1, rowIdx), r.sums[i])
+				_ = f.SetCellStyle(sheet, cell(col+1, rowIdx), cell(col+1, rowIdx), sumStyle)
+			}
+		}
+	}
+
+	rowIdx := headerRow + 2
+	for _, name := range names {
+		title := name
+		if title == "" {
+			title = noGroup
+		}
+		writeRow(rowIdx, title, rows[name], countStyle, sumStyle)
+		_ = f.SetCellStyle(sheet, cell(1, rowIdx), cell(1, rowIdx), nameStyle)
+		rowIdx++
+	}
+	writeRow(rowIdx, "Итого", &total, totalCountStyle, totalSumStyle)
+	_ = f.SetCellStyle(sheet, cell(1, rowIdx), cell(1, rowIdx), totalCountStyle)
+
+	_ = f.SetColWidth(sheet, "A", "A", 40)
+	lastName, _ := excelize.ColumnNumberToName(lastCol)
+	_ = f.SetColWidth(sheet, "B", lastName, 14)
+	_ = f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		XSplit:      1,
+		YSplit:      headerRow + 1,
+		TopLeftCell: cell(2, headerRow+2),
+		ActivePane:  "bottomRight",
+	})
+
+	if err := writeInfoSheet(f, status, append([]string{groupHeader}, buckets...), len(names), now); err != nil {
+		return nil, err
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
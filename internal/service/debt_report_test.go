@@ -0,0 +1,53 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestWriteAgingReport(t *testing.T) {
+	counts := []domain.DebtAgingCount{
+		{Group: "Test Bank", Bucket: 0, Count: 2, Amount: 1500},
+		{Group: "Test Bank", Bucket: 3, Count: 1, Amount: 100.5},
+		{Group: "", Bucket: -1, Count: 1, Amount: 10},
+		{Group: "Alpha", Bucket: 1, Count: 4, Amount: 400},
+	}
+
+	data, err := writeAgingReport(&ExportStatus{UserID: 1}, repository.DebtsGroupByCounterparty, counts, true, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("Aging", excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rows[0][0] != "Портфель по срокам просрочки на 2025-03-01" {
+		t.Fatalf("unexpected title %q", rows[0][0])
+	}
+	wantHeader := []string{"Контрагент", "0–30 дн.", "", "31–60 дн.", "", "61–90 дн.", "", "90+ дн.", "", "Без даты просрочки", "", "Итого"}
+	if !reflect.DeepEqual(rows[2], wantHeader) {
+		t.Fatalf("unexpected header %v", rows[2])
+	}
+	// группы по алфавиту, без контрагента — в конце, затем итоги
+	want := [][]string{
+		{"Alpha", "0", "0", "4", "400", "0", "0", "0", "0", "0", "0", "4", "400"},
+		{"Test Bank", "2", "1500", "0", "0", "0", "0", "1", "100.5", "0", "0", "3", "1600.5"},
+		{"Без контрагента", "0", "0", "0", "0", "0", "0", "0", "0", "1", "10", "1", "10"},
+		{"Итого", "2", "1500", "4", "400", "0", "0", "1", "100.5", "1", "10", "8", "2010.5"},
+	}
+	if !reflect.DeepEqual(rows[4:], want) {
+		t.Fatalf("unexpected rows:\n%v\nwant:\n%v", rows[4:], want)
+	}
+}
@@ -0,0 +1,607 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service/mocks"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/mock/gomock"
+)
+
+type debtMocks struct {
+	repo    *mocks.MockDebtRepository
+	cache   *mocks.MockCache
+	storage *mocks.MockFileStorage
+	ws      *mocks.MockNotifier
+}
+
+func newTestDebtService(t *testing.T) (*DebtService, debtMocks) {
+	ctrl := gomock.NewController(t)
+	m := debtMocks{
+		repo:    mocks.NewMockDebtRepository(ctrl),
+		cache:   mocks.NewMockCache(ctrl),
+		storage: mocks.NewMockFileStorage(ctrl),
+		ws:      mocks.NewMockNotifier(ctrl),
+	}
+	s := NewDebtService(m.repo, m.cache, m.storage, m.ws)
+	s.SetRetryPolicy(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	return s, m
+}
+
+// recordStatuses перехватывает сохранения статуса экспорта в Redis и возвращает их по порядку
+// (копия для Laravel-кеша с префиксом пропускается).
+func recordStatuses(cache *mocks.MockCache) *[]ExportStatus {
+	var saved []ExportStatus
+	cache.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), exportTTL).DoAndReturn(
+		func(_ context.Context, key string, value any, _ time.Duration) error {
+			raw, ok := value.(string)
+			if !ok || !strings.HasPrefix(key, "exports:") {
+				return nil
+			}
+			var st ExportStatus
+			if err := json.Unmarshal([]byte(raw), &st); err == nil {
+				saved = append(saved, st)
+			}
+			return nil
+		}).AnyTimes()
+	cache.EXPECT().SAdd(gomock.Any(), exportSetKey, gomock.Any()).Return(nil).AnyTimes()
+	return &saved
+}
+
+func lastStatus(t *testing.T, saved *[]ExportStatus) ExportStatus {
+	t.Helper()
+	if len(*saved) == 0 {
+		t.Fatal("export status was never saved")
+	}
+	return (*saved)[len(*saved)-1]
+}
+
+func testDebtStatus() *ExportStatus {
+	return newExportStatus(context.Background(), "debts", 7, nil, nil, firstAttempt)
+}
+
+var testDebts = []domain.Debt{
+	{Number: "D-0001"},
+	{Number: "D-0002"},
+}
+
+func TestRunDebtsExport_Success(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil)
+
+	var file []byte
+	m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, name string, data []byte) (string, error) {
+			file = data
+			return "abc_" + name, nil
+		})
+	m.storage.EXPECT().SetOwner(gomock.Any(), int64(7), false).Return(nil)
+	m.storage.EXPECT().GetURL(gomock.Any()).DoAndReturn(func(name string) string { return "/files/" + name })
+
+	gomock.InOrder(
+		m.ws.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), status.Key, float64(95), "generating"),
+		m.ws.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), status.Key, float64(95), "uploading"),
+		m.ws.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), status.Key, float64(100), "ready"),
+		m.ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), status.Key, gomock.Any(), gomock.Any()),
+	)
+
+	s.runDebtsExport(context.Background(), status, []string{"number", "unknown"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	final := lastStatus(t, saved)
+	if final.Progress != 100 || final.Error != nil {
+		t.Fatalf("expected completed export, got progress=%v error=%v", final.Progress, final.Error)
+	}
+	if final.FileURL == nil || !strings.HasPrefix(*final.FileURL, "/files/abc_debts_") {
+		t.Fatalf("unexpected file_url: %v", final.FileURL)
+	}
+	for _, st := range *saved {
+		if st.Heartbeat == nil {
+			t.Fatal("status saved without heartbeat")
+		}
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("saved file is not a workbook: %v", err)
+	}
+	rows, _ := f.GetRows("Debts")
+	if len(rows) != 3 || rows[0][0] != "Номер договора" || rows[2][0] != "D-0002" {
+		t.Fatalf("unexpected sheet contents: %v", rows)
+	}
+}
+
+func TestRunDebtsExport_SplitByCounterparty(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	bank, mfo := ptr("Bank/1"), ptr("МФО")
+	debts := []domain.Debt{
+		{Number: "D-0001", CounterpartyName: mfo},
+		{Number: "D-0002", CounterpartyName: bank},
+		{Number: "D-0003"},
+		{Number: "D-0004", CounterpartyName: mfo},
+	}
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(debts, nil)
+	m.repo.EXPECT().ListGuarantors(gomock.Any(), gomock.Any()).Return([]domain.Guarantor{
+		{DebtNumber: "D-0004", LastName: ptr("Сидоров")},
+	}, nil)
+
+	var file []byte
+	var fileName string
+	m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, name string, data []byte) (string, error) {
+			file, fileName = data, name
+			return "abc_" + name, nil
+		})
+	m.storage.EXPECT().SetOwner(gomock.Any(), int64(7), false).Return(nil)
+	m.storage.EXPECT().GetURL(gomock.Any()).DoAndReturn(func(name string) string { return "/files/" + name })
+	m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	m.ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), status.Key, gomock.Any(), gomock.Any())
+
+	opts := DebtsExportOptions{ExportOptions: ExportOptions{Filename: "отчёт.xlsx"}, IncludeGuarantors: true, SplitBy: DebtsSplitByCounterparty}
+	s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, opts)
+
+	if final := lastStatus(t, saved); final.Error != nil || final.Progress != 100 {
+		t.Fatalf("expected completed export, got %+v", final)
+	}
+	if fileName != "отчёт.zip" {
+		t.Fatalf("unexpected archive name %q", fileName)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("saved file is not a zip: %v", err)
+	}
+	// по книге на контрагента, отсортированы по имени; долги без контрагента — отдельно
+	want := map[string][]string{
+		"Bank_1.xlsx":          {"D-0002"},
+		"Без_контрагента.xlsx": {"D-0003"},
+		"МФО.xlsx":             {"D-0001", "D-0004"},
+	}
+	if len(archive.File) != len(want) {
+		t.Fatalf("unexpected archive entries: %d", len(archive.File))
+	}
+	for _, entry := range archive.File {
+		numbers, ok := want[entry.Name]
+		if !ok {
+			t.Fatalf("unexpected archive entry %q", entry.Name)
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := excelize.OpenReader(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("%s is not a workbook: %v", entry.Name, err)
+		}
+		rows, _ := f.GetRows("Debts")
+		if len(rows) != len(numbers)+1 {
+			t.Fatalf("%s: unexpected rows %v", entry.Name, rows)
+		}
+		for i, number := range numbers {
+			if rows[i+1][0] != number {
+				t.Fatalf("%s: unexpected rows %v", entry.Name, rows)
+			}
+		}
+		// поручители попадают только в книгу своего контрагента
+		guarantors, _ := f.GetRows("Поручители")
+		if wantGuarantors := map[bool]int{true: 2, false: 1}[entry.Name == "МФО.xlsx"]; len(guarantors) != wantGuarantors {
+			t.Fatalf("%s: unexpected guarantors %v", entry.Name, guarantors)
+		}
+	}
+}
+
+func TestRunDebtsExport_QueryError(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	// не транзиентная ошибка — без повторов
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, errors.New(`relation "debts" does not exist`)).Times(1)
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, `query failed: relation "debts" does not exist`)
+
+	s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	final := lastStatus(t, saved)
+	if final.Error == nil || final.Progress != 100 || final.FileURL != nil {
+		t.Fatalf("expected failed export, got %+v", final)
+	}
+}
+
+func TestRunDebtsExport_RetriesTransientQueryError(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	gomock.InOrder(
+		m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, io.ErrUnexpectedEOF),
+		m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil),
+	)
+	m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return("abc_debts.xlsx", nil)
+	m.storage.EXPECT().SetOwner("abc_debts.xlsx", int64(7), false).Return(nil)
+	m.storage.EXPECT().GetURL("abc_debts.xlsx").Return("/files/abc_debts.xlsx")
+	m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	m.ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), status.Key, "/files/abc_debts.xlsx", gomock.Any())
+
+	s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	if final := lastStatus(t, saved); final.Error != nil || final.Progress != 100 {
+		t.Fatalf("expected completed export, got %+v", final)
+	}
+}
+
+func TestStartDebtsExport_TooManyRows(t *testing.T) {
+	s, m := newTestDebtService(t)
+
+	// отказ до записи статуса: ни Redis, ни фоновой задачи
+	registry := "R-1"
+	filter := repository.DebtsFilter{RegistryID: &registry}
+	m.repo.EXPECT().HasMoreThan(gomock.Any(), int64(maxDebtsForExport), filter).Return(true, nil)
+
+	_, err := s.StartDebtsExport(context.Background(), nil, filter, DebtsExportOptions{}, 7)
+	if !errors.Is(err, ErrTooManyRows) || !strings.Contains(err.Error(), "больше 1000000 записей") {
+		t.Fatalf("expected ErrTooManyRows, got %v", err)
+	}
+}
+
+func TestEstimateDebtsExport(t *testing.T) {
+	s, m := newTestDebtService(t)
+
+	registry := "R-1"
+	filter := repository.DebtsFilter{RegistryID: &registry}
+	m.repo.EXPECT().Count(gomock.Any(), filter).Return(int64(maxDebtsForExport+1), nil)
+
+	// неизвестная колонка не считается, скрытые маской — тоже
+	ctx := WithHiddenColumns(context.Background(), []string{"amount_*"})
+	got, err := s.EstimateDebtsExport(ctx, []string{"number", "debtor.iin", "amount_currency", "nonsense"}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rows != maxDebtsForExport+1 || got.Columns != 2 || got.Limit != maxDebtsForExport || !got.ExceedsLimit {
+		t.Fatalf("estimate = %+v", got)
+	}
+
+	m.repo.EXPECT().Count(gomock.Any(), filter).Return(int64(0), errors.New("db down"))
+	if _, err := s.EstimateDebtsExport(context.Background(), []string{"number"}, filter); err == nil {
+		t.Fatal("expected the error of the count")
+	}
+}
+
+func TestRunDebtsExport_NoValidColumns(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil)
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, "no valid columns selected")
+
+	s.runDebtsExport(context.Background(), status, []string{"unknown"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	if final := lastStatus(t, saved); final.Error == nil || *final.Error != "no valid columns selected" {
+		t.Fatalf("expected failed export, got %+v", final)
+	}
+}
+
+func TestRunDebtsExport_SaveError(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil)
+	m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return("", errors.New("disk full")).Times(1)
+	m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, "save export failed: disk full")
+
+	s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	final := lastStatus(t, saved)
+	if final.Error == nil || final.FileURL != nil {
+		t.Fatalf("expected failed export without file, got %+v", final)
+	}
+}
+
+func TestRunDebtsExport_SingleUse(t *testing.T) {
+	opts := DebtsExportOptions{ExportOptions: ExportOptions{SingleUse: true}}
+
+	t.Run("protected file is published", func(t *testing.T) {
+		s, m := newTestDebtService(t)
+		saved := recordStatuses(m.cache)
+		status := testDebtStatus()
+
+		m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil)
+		m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return("abc_debts.xlsx", nil)
+		m.storage.EXPECT().SetOwner("abc_debts.xlsx", int64(7), true).Return(nil)
+		m.storage.EXPECT().GetURL("abc_debts.xlsx").Return("/files/abc_debts.xlsx")
+		m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		m.ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), status.Key, "/files/abc_debts.xlsx", gomock.Any())
+
+		s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, opts)
+
+		final := lastStatus(t, saved)
+		if !final.SingleUse || final.File == nil || *final.File != "abc_debts.xlsx" {
+			t.Fatalf("expected single-use export with file, got %+v", final)
+		}
+	})
+
+	t.Run("link is not published when the file cannot be protected", func(t *testing.T) {
+		s, m := newTestDebtService(t)
+		saved := recordStatuses(m.cache)
+		status := testDebtStatus()
+
+		m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil)
+		m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return("abc_debts.xlsx", nil)
+		m.storage.EXPECT().SetOwner("abc_debts.xlsx", int64(7), true).Return(errors.New("read-only file system"))
+		m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+		m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, "save export failed: read-only file system")
+
+		s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, opts)
+
+		if final := lastStatus(t, saved); final.Error == nil || final.FileURL != nil {
+			t.Fatalf("expected failed export without file, got %+v", final)
+		}
+	})
+}
+
+func TestRunDebtsExport_Cancelled(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrExportCancelled)
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, _ repository.DebtsFilter) ([]domain.Debt, error) {
+			return nil, ctx.Err()
+		})
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, ErrExportCancelled.Error())
+
+	s.runDebtsExport(ctx, status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	if final := lastStatus(t, saved); final.Error == nil || *final.Error != ErrExportCancelled.Error() {
+		t.Fatalf("expected cancelled export, got %+v", final)
+	}
+}
+
+func TestRunDebtsExport_ReportsFailure(t *testing.T) {
+	t.Run("failed export is reported with its tags", func(t *testing.T) {
+		reports := captureReports(t)
+		s, m := newTestDebtService(t)
+		recordStatuses(m.cache)
+		status := testDebtStatus()
+
+		m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, errors.New(`relation "debts" does not exist`))
+		m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, gomock.Any())
+
+		s.runDebtsExport(context.Background(), status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+		got := reports.captured()
+		if len(got) != 1 || got[0].err.Error() != `query failed: relation "debts" does not exist` {
+			t.Fatalf("reports = %+v", got)
+		}
+		tags := got[0].tags
+		if tags["export_id"] != status.Key || tags["export_type"] != "debts" || tags["user_id"] != "7" {
+			t.Fatalf("tags = %v", tags)
+		}
+	})
+
+	t.Run("cancelled export is not an error", func(t *testing.T) {
+		reports := captureReports(t)
+		s, m := newTestDebtService(t)
+		recordStatuses(m.cache)
+		status := testDebtStatus()
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrExportCancelled)
+		m.repo.EXPECT().List(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, _ repository.DebtsFilter) ([]domain.Debt, error) {
+				return nil, ctx.Err()
+			})
+		m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, gomock.Any())
+
+		s.runDebtsExport(ctx, status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+		if got := reports.captured(); len(got) != 0 {
+			t.Fatalf("reports = %+v", got)
+		}
+	})
+}
+
+func TestRunDebtsExport_StalledIsNotReportedTwice(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	// статус и уведомление уже записал watchdog
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errExportStalled)
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(nil, context.Canceled)
+
+	s.runDebtsExport(ctx, status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	if len(*saved) != 0 {
+		t.Fatalf("stalled export must not be saved again, got %+v", *saved)
+	}
+}
+
+func TestRunDebtsExport_CancelledAfterFirstChunk(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	debts := make([]domain.Debt, 3*pacerFirstChunk)
+	for i := range debts {
+		debts[i].Number = fmt.Sprintf("D-%04d", i)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(debts, nil)
+	// отмена приходит, пока пишется первая порция строк
+	m.ws.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), status.Key, gomock.Any(), "generating").
+		Do(func(context.Context, int64, string, float64, string) { cancel(ErrExportCancelled) }).Times(1)
+	// следующий запрос (поручители) не выполняется, файл не сохраняется
+	m.repo.EXPECT().ListGuarantors(gomock.Any(), gomock.Any()).Times(0)
+	m.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, ErrExportCancelled.Error())
+
+	opts := DebtsExportOptions{IncludeGuarantors: true}
+	s.runDebtsExport(ctx, status, []string{"number"}, repository.DebtsFilter{}, opts)
+
+	final := lastStatus(t, saved)
+	if final.Error == nil || *final.Error != ErrExportCancelled.Error() || final.FileURL != nil || final.File != nil {
+		t.Fatalf("expected cancelled export without file, got %+v", final)
+	}
+	if state := final.History[len(final.History)-1].State; state != ExportCancelled {
+		t.Fatalf("state = %s, want %s", state, ExportCancelled)
+	}
+	if final.Progress != 100 {
+		t.Fatalf("progress = %v", final.Progress)
+	}
+}
+
+// cancelOnSave отменяет экспорт в момент записи файла.
+type cancelOnSave struct {
+	*clients.StorageClient
+	cancel context.CancelCauseFunc
+}
+
+func (c cancelOnSave) Save(ctx context.Context, fileName string, data []byte) (string, error) {
+	c.cancel(ErrExportCancelled)
+	return c.StorageClient.Save(ctx, fileName, data)
+}
+
+func TestRunDebtsExport_CancelledWhileSaving(t *testing.T) {
+	s, m := newTestDebtService(t)
+	saved := recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	dir := t.TempDir()
+	storage, err := clients.NewLocalStorage(dir, "/files", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	s.s3 = cancelOnSave{StorageClient: storage, cancel: cancel}
+
+	m.repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(testDebts, nil)
+	m.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, ErrExportCancelled.Error())
+
+	s.runDebtsExport(ctx, status, []string{"number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	if final := lastStatus(t, saved); final.Error == nil || *final.Error != ErrExportCancelled.Error() || final.FileURL != nil {
+		t.Fatalf("expected cancelled export without file, got %+v", final)
+	}
+	// ни файла, ни метаданных, ни временных файлов
+	var left []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			left = append(left, path)
+		}
+		return nil
+	})
+	if len(left) != 0 {
+		t.Fatalf("cancelled export left %v", left)
+	}
+}
+
+func TestSelectsColumns(t *testing.T) {
+	tests := []struct {
+		keys     []string
+		computed []ComputedColumn
+		prefixes []string
+		want     bool
+	}{
+		{[]string{"number", "amount_actual_debt"}, nil, []string{debtPaymentsPrefix}, false},
+		{[]string{"number", "payments.total_paid"}, nil, []string{debtPaymentsPrefix}, true},
+		{[]string{"number"}, []ComputedColumn{{Expr: "amount_actual_debt - payments.paid_this_month"}}, []string{debtPaymentsPrefix}, true},
+		{[]string{"number"}, []ComputedColumn{{Expr: "amount_fine * 2"}}, []string{debtPaymentsPrefix}, false},
+		{[]string{"enforcement.agent"}, nil, debtLegalPrefixes, true},
+		{[]string{"payments.total_paid"}, nil, debtLegalPrefixes, false},
+		{[]string{"debtor.phone_2"}, nil, debtContactsPrefixes, true},
+		{[]string{"debtor.full_name", "debtor.iin"}, nil, debtContactsPrefixes, false},
+	}
+	for _, tt := range tests {
+		if got := selectsColumns(tt.keys, tt.computed, tt.prefixes...); got != tt.want {
+			t.Errorf("selectsColumns(%v, %v, %v) = %v, want %v", tt.keys, tt.computed, tt.prefixes, got, tt.want)
+		}
+	}
+}
+
+func TestRunDebtsExport_ExtraJoinsOnDemand(t *testing.T) {
+	s, m := newTestDebtService(t)
+	recordStatuses(m.cache)
+	status := testDebtStatus()
+
+	// колонки платежей скрыты маской — агрегаты не запрашиваются
+	m.repo.EXPECT().List(gomock.Any(), repository.DebtsFilter{}).Return(nil, errors.New("stop"))
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, gomock.Any())
+	s.runDebtsExport(context.Background(), status, []string{"number", "payments.total_paid"}, repository.DebtsFilter{},
+		DebtsExportOptions{ExportOptions: ExportOptions{HiddenColumns: []string{"payments.*"}}})
+
+	status = testDebtStatus()
+	m.repo.EXPECT().List(gomock.Any(), repository.DebtsFilter{PaymentHistory: true}).Return(nil, errors.New("stop"))
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, gomock.Any())
+	s.runDebtsExport(context.Background(), status, []string{"number", "payments.total_paid"}, repository.DebtsFilter{}, DebtsExportOptions{})
+
+	status = testDebtStatus()
+	m.repo.EXPECT().List(gomock.Any(), repository.DebtsFilter{LegalCase: true}).Return(nil, errors.New("stop"))
+	m.ws.EXPECT().NotifyExportFailed(gomock.Any(), int64(7), status.Key, gomock.Any())
+	s.runDebtsExport(context.Background(), status, []string{"number", "court.case_number"}, repository.DebtsFilter{}, DebtsExportOptions{})
+}
+
+// pipelinedCache — кеш с SaveStatus; отдельные Set/SAdd моку не ожидаются.
+type pipelinedCache struct {
+	*mocks.MockCache
+	saves []map[string]any
+}
+
+func (c *pipelinedCache) SaveStatus(_ context.Context, values map[string]any, ttl time.Duration, setKey string, member any) error {
+	if ttl != exportTTL || setKey != exportSetKey {
+		return errors.New("unexpected ttl or set")
+	}
+	values["member"] = member
+	c.saves = append(c.saves, values)
+	return nil
+}
+
+func TestSaveExportStatus_OneRoundTrip(t *testing.T) {
+	s, m := newTestDebtService(t)
+	cache := &pipelinedCache{MockCache: m.cache}
+	s.redis = cache
+	status := testDebtStatus()
+
+	if err := s.saveExportStatus(context.Background(), status); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.saves) != 1 {
+		t.Fatalf("expected one write, got %d", len(cache.saves))
+	}
+	values := cache.saves[0]
+	if values["member"] != status.Key || !strings.Contains(values[status.Key].(string), `"type":"debts"`) {
+		t.Fatalf("status record: %v", values)
+	}
+	if laravel, _ := values[s.cachePrefix+status.Key].(string); !strings.HasPrefix(laravel, "a:8:{") {
+		t.Fatalf("laravel item: %q", laravel)
+	}
+}
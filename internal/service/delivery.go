@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"debtster-export/internal/reporting"
+	"debtster-export/internal/requestid"
+)
+
+// DeliveryTypeSFTP drops the finished file onto a counterparty SFTP server.
+const DeliveryTypeSFTP = "sftp"
+
+// Row delivery types take the rows of an export instead of a file, see
+// RowSink: the BI warehouses insert them into a table, Kafka publishes them
+// as messages.
+const (
+	DeliveryTypeClickHouse = "clickhouse"
+	DeliveryTypeBigQuery   = "bigquery"
+	DeliveryTypeKafka      = "kafka"
+)
+
+// Delivery states recorded in DeliveryStatus.State.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// ErrInvalidDelivery is returned when an export asks for an unknown delivery
+// type or profile.
+var ErrInvalidDelivery = errors.New("invalid delivery")
+
+// DeliveryOptions push the finished file to an external target configured on
+// the server; Profile names the target, credentials are never sent by clients.
+type DeliveryOptions struct {
+	Type    string `json:"type"`
+	Profile string `json:"profile"`
+}
+
+// DeliveryStatus is the outcome of the delivery kept in the export record.
+type DeliveryStatus struct {
+	Type        string     `json:"type"`
+	Profile     string     `json:"profile"`
+	State       string     `json:"state"`
+	Path        string     `json:"path,omitempty"`
+	Error       *string    `json:"error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Deliverer uploads files to the profiles of one delivery type.
+// Implemented by *clients.SFTPClient.
+type Deliverer interface {
+	HasProfile(profile string) bool
+	Deliver(ctx context.Context, profile, fileName string, data []byte) (string, error)
+}
+
+// DeliveryTarget is the client of one delivery type: a Deliverer of files or
+// a RowSink of rows.
+type DeliveryTarget interface {
+	HasProfile(profile string) bool
+}
+
+// Deliverers maps a delivery type to its client.
+type Deliverers map[string]DeliveryTarget
+
+// check rejects a delivery that cannot be made, before the export is queued.
+func (d Deliverers) check(opts *DeliveryOptions) error {
+	if opts == nil {
+		return nil
+	}
+	deliverer, ok := d[opts.Type]
+	if !ok {
+		return fmt.Errorf("%w: delivery type %q is not available", ErrInvalidDelivery, opts.Type)
+	}
+	if !deliverer.HasProfile(opts.Profile) {
+		return fmt.Errorf("%w: unknown %s profile %q", ErrInvalidDelivery, opts.Type, opts.Profile)
+	}
+	return nil
+}
+
+func newDeliveryStatus(opts *DeliveryOptions) *DeliveryStatus {
+	if opts == nil {
+		return nil
+	}
+	return &DeliveryStatus{Type: opts.Type, Profile: opts.Profile, State: DeliveryPending}
+}
+
+// deliver uploads a saved export file to the requested target and records the
+// outcome in status. A failed delivery does not fail the export: the file stays
+// downloadable and the error is shown in the delivery status.
+func (d Deliverers) deliver(ctx context.Context, retry RetryPolicy, status *ExportStatus, opts *DeliveryOptions, fileName string, data []byte) {
+	if opts == nil || status.Delivery == nil {
+		return
+	}
+
+	deliverer, ok := d[opts.Type].(Deliverer)
+	if !ok {
+		errStr := fmt.Sprintf("delivery type %q is not available", opts.Type)
+		status.Delivery.State = DeliveryFailed
+		status.Delivery.Error = &errStr
+		return
+	}
+
+	var remote string
+	err := retry.Do(ctx, "export "+status.Key+": deliver", func() error {
+		var err error
+		remote, err = deliverer.Deliver(ctx, opts.Profile, fileName, data)
+		return err
+	})
+	if err != nil {
+		errStr := fmt.Sprintf("%s delivery to %q failed: %v", opts.Type, opts.Profile, err)
+		requestid.Logf(ctx, "export %s: %s", status.Key, errStr)
+		if ctx.Err() == nil {
+			reporting.CaptureError(ctx, errors.New(errStr), exportTags(status))
+		}
+		status.Delivery.State = DeliveryFailed
+		status.Delivery.Error = &errStr
+		return
+	}
+
+	now := time.Now()
+	status.Delivery.State = DeliveryDelivered
+	status.Delivery.Path = remote
+	status.Delivery.DeliveredAt = &now
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+type fakeDeliverer struct {
+	profiles map[string]bool
+	fails    int
+	calls    int
+}
+
+func (d *fakeDeliverer) HasProfile(profile string) bool {
+	return d.profiles[profile]
+}
+
+func (d *fakeDeliverer) Deliver(_ context.Context, profile, fileName string, _ []byte) (string, error) {
+	d.calls++
+	if d.calls <= d.fails {
+		return "", fmt.Errorf("write: %w", syscall.ECONNRESET)
+	}
+	return "/incoming/" + fileName, nil
+}
+
+func TestDeliverers_Check(t *testing.T) {
+	d := Deliverers{DeliveryTypeSFTP: &fakeDeliverer{profiles: map[string]bool{"bank": true}}}
+
+	if err := d.check(nil); err != nil {
+		t.Fatalf("no delivery: %v", err)
+	}
+	if err := d.check(&DeliveryOptions{Type: DeliveryTypeSFTP, Profile: "bank"}); err != nil {
+		t.Fatalf("known profile: %v", err)
+	}
+	if err := d.check(&DeliveryOptions{Type: DeliveryTypeSFTP, Profile: "other"}); !errors.Is(err, ErrInvalidDelivery) {
+		t.Fatalf("unknown profile: got %v", err)
+	}
+	// доставка не настроена на сервере
+	var none Deliverers
+	if err := none.check(&DeliveryOptions{Type: DeliveryTypeSFTP, Profile: "bank"}); !errors.Is(err, ErrInvalidDelivery) {
+		t.Fatalf("no deliverers: got %v", err)
+	}
+}
+
+func TestDeliverers_Deliver(t *testing.T) {
+	opts := &DeliveryOptions{Type: DeliveryTypeSFTP, Profile: "bank"}
+	retry := RetryPolicy{Attempts: 2}
+
+	fake := &fakeDeliverer{profiles: map[string]bool{"bank": true}, fails: 1}
+	status := &ExportStatus{Key: "exports:1", Delivery: newDeliveryStatus(opts)}
+	Deliverers{DeliveryTypeSFTP: fake}.deliver(context.Background(), retry, status, opts, "debts.xlsx", []byte("x"))
+
+	if status.Delivery.State != DeliveryDelivered || status.Delivery.Path != "/incoming/debts.xlsx" || status.Delivery.DeliveredAt == nil {
+		t.Fatalf("delivery is not recorded: %+v", status.Delivery)
+	}
+
+	// ошибка доставки не валит экспорт, а попадает в статус доставки
+	fake = &fakeDeliverer{profiles: map[string]bool{"bank": true}, fails: 5}
+	status = &ExportStatus{Key: "exports:2", Delivery: newDeliveryStatus(opts)}
+	Deliverers{DeliveryTypeSFTP: fake}.deliver(context.Background(), retry, status, opts, "debts.xlsx", []byte("x"))
+
+	if status.Delivery.State != DeliveryFailed || status.Delivery.Error == nil || status.Error != nil {
+		t.Fatalf("failed delivery: %+v, export error %v", status.Delivery, status.Error)
+	}
+	if fake.calls != 2 {
+		t.Fatalf("delivery must be retried, got %d calls", fake.calls)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"debtster-export/internal/domain"
+)
+
+//go:generate go tool mockgen -destination=mocks/mocks.go -package=mocks . Cache,FileStorage,Notifier,DebtRepository,UserRepository,ActionRepository,PaymentRepository,RecordingPresigner
+
+// Cache is the part of Redis the exporters use to keep export statuses.
+// Implemented by *clients.RedisClient.
+type Cache interface {
+	Set(ctx context.Context, key string, value any, ttl time.Duration) error
+	// SetNX sets key only if it does not exist; false means another caller
+	// set it first.
+	SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, key string) (string, error)
+	SAdd(ctx context.Context, key string, members ...any) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SRem(ctx context.Context, key string, members ...any) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Del(ctx context.Context, keys ...string) error
+}
+
+// StatusSaver is implemented by caches that write an export status and its
+// index entry in one round trip. Implemented by *clients.RedisClient.
+type StatusSaver interface {
+	SaveStatus(ctx context.Context, values map[string]any, ttl time.Duration, setKey string, member any) error
+}
+
+// FileStorage keeps generated export files and builds their public URLs.
+// Implemented by *clients.StorageClient.
+type FileStorage interface {
+	Save(ctx context.Context, fileName string, data []byte) (string, error)
+	GetURL(fileName string) string
+	// SetOwner accounts a saved file to ownerID; a singleUse file is served to
+	// the owner only and deleted after the first download.
+	SetOwner(fileName string, ownerID int64, singleUse bool) error
+}
+
+// StorageBackends is implemented by storages with more than one backend to
+// tell which one holds a saved file. Implemented by *clients.FailoverStorage.
+type StorageBackends interface {
+	Backend(fileName string) string
+}
+
+// storageBackend returns the backend holding file, "" for single-backend storages.
+func storageBackend(storage FileStorage, file string) string {
+	if b, ok := storage.(StorageBackends); ok {
+		return b.Backend(file)
+	}
+	return ""
+}
+
+// URLSigner is implemented by storages issuing download links valid for a
+// limited time. Implemented by *clients.StorageClient.
+type URLSigner interface {
+	SignURL(fileName string, ttl time.Duration) (string, time.Time, error)
+}
+
+// fileURL returns the download link of a saved file valid for ttlHours (0 —
+// the storage default) and its expiry, nil for links that do not expire.
+func fileURL(storage FileStorage, file string, ttlHours int) (string, *time.Time, error) {
+	signer, ok := storage.(URLSigner)
+	if !ok {
+		return storage.GetURL(file), nil, nil
+	}
+	url, expires, err := signer.SignURL(file, time.Duration(ttlHours)*time.Hour)
+	if err != nil || expires.IsZero() {
+		return url, nil, err
+	}
+	return url, &expires, nil
+}
+
+// Notifier pushes export events to the user's websocket connections.
+// Implemented by *clients.WebSocketClient.
+type Notifier interface {
+	NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error
+	NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error
+	NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error
+	// NotifyBatchComplete is sent once all exports of a batch are finished.
+	NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error
+}
@@ -5,24 +5,283 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"math"
 	"sort"
+	"strconv"
 	"time"
 
-	"debtster-export/internal/clients"
+	"debtster-export/internal/requestid"
+
+	"github.com/google/uuid"
+)
+
+// Heuristics used by export estimates; measured on typical debts exports.
+const (
+	estimatedBytesPerCell   = 12
+	estimatedCellsPerSecond = 200_000
+	estimatedQuerySeconds   = 2
+)
+
+// ExportOptions are request options shared by all export types.
+type ExportOptions struct {
+	// Filename replaces the naming template for this export; it is sanitized
+	// and gets the .xlsx extension when missing.
+	Filename string `json:"filename,omitempty"`
+	// SingleUse deletes the file and expires the status right after the owner
+	// downloads it; the file is then served to the owner only.
+	SingleUse bool `json:"single_use,omitempty"`
+	// Delivery additionally pushes the finished file to a server-side target.
+	Delivery *DeliveryOptions `json:"delivery,omitempty"`
+	// Comment is a free-text note on why the export was made, see UpdateComment.
+	Comment string `json:"comment,omitempty"`
+	// Computed columns are appended after the selected ones.
+	Computed []ComputedColumn `json:"computed,omitempty"`
+	// Template renders the data into an admin-uploaded workbook, see TemplateDataName.
+	Template string `json:"template,omitempty"`
+	// HiddenColumns are the column patterns masked for the user who started
+	// the export, see ColumnMasks; kept so retries stay masked.
+	HiddenColumns []string `json:"hidden_columns,omitempty"`
+	// URLTTLHours is how long the download link stays valid; 0 — the
+	// configured lifetime. Expired links are re-issued with RefreshURL.
+	URLTTLHours int `json:"url_ttl_hours,omitempty"`
+	// OutputProfile writes the export as CSV in the layout of an external
+	// system instead of XLSX, see outputProfiles.
+	OutputProfile string `json:"output_profile,omitempty"`
+	// Format is FormatXLSX (default) or FormatCSV; CSV sets the dialect of
+	// the latter.
+	Format string      `json:"format,omitempty"`
+	CSV    *CSVDialect `json:"csv,omitempty"`
+}
+
+// ExportEstimate is the dry-run result for an export: expected size and duration.
+type ExportEstimate struct {
+	Rows             int64   `json:"rows"`
+	Columns          int     `json:"columns"`
+	EstimatedBytes   int64   `json:"estimated_size_bytes"`
+	EstimatedSeconds float64 `json:"estimated_duration_seconds"`
+	Limit            int64   `json:"limit,omitempty"`
+	ExceedsLimit     bool    `json:"exceeds_limit"`
+}
+
+func newExportEstimate(rows int64, columns int, limit int64) ExportEstimate {
+	cells := rows * int64(columns)
+	seconds := float64(estimatedQuerySeconds) + float64(cells)/estimatedCellsPerSecond
+
+	return ExportEstimate{
+		Rows:             rows,
+		Columns:          columns,
+		EstimatedBytes:   cells * estimatedBytesPerCell,
+		EstimatedSeconds: math.Round(seconds*10) / 10,
+		Limit:            limit,
+		ExceedsLimit:     limit > 0 && rows > limit,
+	}
+}
+
+// rowsProgress converts processed rows into the export progress percentage.
+// 100% is reserved for when file_url is ready, so row processing never reports it.
+func rowsProgress(done, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	progress := math.Round(float64(done) / float64(total) * 100.0)
+	if progress >= 100 {
+		return 95
+	}
+	return progress
+}
+
+var (
+	ErrExportNotFound     = errors.New("export not found")
+	ErrExportNotRetryable = errors.New("export cannot be retried")
+	// ErrExportNotCancellable — export is already finished or runs on another instance
+	ErrExportNotCancellable = errors.New("export cannot be cancelled")
+	// ErrExportNotReady — the export has not produced a file (yet)
+	ErrExportNotReady = errors.New("export has no file")
+	// ErrExportFileGone — the file was removed by the retention cleanup
+	ErrExportFileGone = errors.New("export file no longer exists")
+	// ErrExportNotDeletable is returned for an unfinished export whose job runs
+	// in another instance.
+	ErrExportNotDeletable = errors.New("export is running in another instance")
+	// ErrTooManyRows — the filter matches more rows than an export of the type
+	// may hold
+	ErrTooManyRows = errors.New("too many rows")
 )
 
+// writeStatus writes the record of st, its Laravel cache item laravelValue
+// under laravelKey and its membership in the export index. A cache
+// implementing StatusSaver takes the three in one round trip.
+func writeStatus(ctx context.Context, cache Cache, st *ExportStatus, laravelKey, laravelValue string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if saver, ok := cache.(StatusSaver); ok {
+		values := map[string]any{st.Key: string(data), laravelKey: laravelValue}
+		return saver.SaveStatus(ctx, values, exportTTL, exportSetKey, st.Key)
+	}
+
+	err = cache.Set(ctx, st.Key, string(data), exportTTL)
+	if err == nil {
+		err = cache.SAdd(ctx, exportSetKey, st.Key)
+	}
+	// the Laravel copy is written even when the record is not
+	return errors.Join(err, cache.Set(ctx, laravelKey, laravelValue, exportTTL))
+}
+
+// tooManyRows is the error of an export over its row cap; what names the rows
+// in the genitive plural ("долгов", "платежей").
+func tooManyRows(what string, limit int64) error {
+	return fmt.Errorf("%w: слишком много %s для экспорта (больше %d записей)", ErrTooManyRows, what, limit)
+}
+
+// exportAttempt links a re-run export to the attempt it retries.
+type exportAttempt struct {
+	Number  int
+	RetryOf *string
+}
+
+var firstAttempt = exportAttempt{Number: 1}
+
+func nextAttempt(original *ExportStatus) exportAttempt {
+	number := original.Attempt
+	if number < 1 {
+		number = 1
+	}
+	key := original.Key
+	return exportAttempt{Number: number + 1, RetryOf: &key}
+}
+
+// Export states recorded in ExportStatus.History.
+const (
+	ExportQueued     = "queued"
+	ExportRunning    = "running"
+	ExportUploading  = "uploading"
+	ExportDelivering = "delivering"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+	ExportCancelled  = "cancelled"
+)
+
+// StatusTransition is an entry of the export timeline: the state entered and when.
+type StatusTransition struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+}
+
+// enter records that the export entered state; repeating the current state is a no-op.
+func (st *ExportStatus) enter(state string) {
+	if n := len(st.History); n > 0 && st.History[n-1].State == state {
+		return
+	}
+	st.History = append(st.History, StatusTransition{State: state, At: time.Now()})
+}
+
+// exportTimeline is the history of the detail endpoint: every state with the
+// time spent in it; the current state of a running export counts until now.
+func exportTimeline(history []StatusTransition, now time.Time) []map[string]any {
+	out := make([]map[string]any, 0, len(history))
+	for i, t := range history {
+		entry := map[string]any{"state": t.State, "at": t.At}
+		switch {
+		case i+1 < len(history):
+			entry["duration_ms"] = history[i+1].At.Sub(t.At).Milliseconds()
+		case t.State != ExportReady && t.State != ExportFailed && t.State != ExportCancelled:
+			entry["duration_ms"] = now.Sub(t.At).Milliseconds()
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// newExportStatus creates the initial (queued) status record for a new export.
+func newExportStatus(ctx context.Context, exportType string, userID int64, filters any, params any, attempt exportAttempt) *ExportStatus {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		rawParams = nil
+	}
+
+	st := &ExportStatus{
+		Key:      fmt.Sprintf("exports:%s", uuid.NewString()),
+		Type:     exportType,
+		UserID:   userID,
+		Filters:  filters,
+		Progress: 0,
+		FileURL:  nil,
+		Created:  time.Now(),
+		Params:   rawParams,
+		Attempt:  attempt.Number,
+		RetryOf:  attempt.RetryOf,
+
+		RequestID: requestid.FromContext(ctx),
+	}
+	st.enter(ExportQueued)
+	return st
+}
+
+// exportTags identifies an export in error reports.
+func exportTags(status *ExportStatus) map[string]string {
+	return map[string]string{
+		"export_id":   status.Key,
+		"export_type": status.Type,
+		"user_id":     strconv.FormatInt(status.UserID, 10),
+		"request_id":  status.RequestID,
+	}
+}
+
+// linkExportFile lets downloads of the stored file find the export record.
+func linkExportFile(ctx context.Context, redis Cache, status *ExportStatus) error {
+	if redis == nil || status.File == nil {
+		return nil
+	}
+	return redis.Set(ctx, exportFilePrefix+*status.File, status.Key, exportTTL)
+}
+
+// ExportRetrier re-runs a failed export of one type from its persisted parameters.
+type ExportRetrier interface {
+	RetryExport(ctx context.Context, original *ExportStatus) (string, error)
+}
+
 type ExportService struct {
-	redis       *clients.RedisClient
+	redis       Cache
 	cachePrefix string
+	retriers    map[string]ExportRetrier
+	jobs        *JobRunner
+	queue       *JobQueue
+	files       ExportFiles
+}
+
+// ExportFiles re-issues download links of stored export files; implemented
+// by *clients.StorageClient.
+type ExportFiles interface {
+	Resolve(file string) (string, error)
+	SignURL(file string, ttl time.Duration) (string, time.Time, error)
 }
 
-func NewExportService(redis *clients.RedisClient, cachePrefix string) *ExportService {
+func NewExportService(redis Cache, cachePrefix string) *ExportService {
 	return &ExportService{
 		redis:       redis,
 		cachePrefix: cachePrefix,
+		retriers:    map[string]ExportRetrier{},
 	}
 }
 
+// RegisterRetrier makes exports of exportType retryable through RetryExport.
+func (s *ExportService) RegisterRetrier(exportType string, r ExportRetrier) {
+	s.retriers[exportType] = r
+}
+
+// SetJobRunner lets CancelExport stop exports running in this process.
+func (s *ExportService) SetJobRunner(r *JobRunner) {
+	s.jobs = r
+}
+
+// SetJobQueue lets CancelExport and DeleteExport drop exports still waiting
+// in the job queue.
+func (s *ExportService) SetJobQueue(q *JobQueue) {
+	s.queue = q
+}
+
 func (s *ExportService) GetExports(ctx context.Context, userID int64) ([]interface{}, error) {
 	if s.redis == nil {
 		return nil, errors.New("redis client not configured")
@@ -58,14 +317,22 @@ func (s *ExportService) GetExports(ctx context.Context, userID int64) ([]interfa
 
 	for _, status := range statuses {
 		exportMap := map[string]interface{}{
-			"key":        status.Key,
-			"type":       status.Type,
-			"user_id":    status.UserID,
-			"progress":   status.Progress,
-			"file_url":   status.FileURL,
-			"error":      status.Error,
-			"filters":    status.Filters,
-			"created_at": humanizeRuAgo(status.Created),
+			"key":          status.Key,
+			"type":         status.Type,
+			"user_id":      status.UserID,
+			"progress":     status.Progress,
+			"file_url":     status.FileURL,
+			"error":        status.Error,
+			"filters":      status.Filters,
+			"attempt":      status.Attempt,
+			"retry_of":     status.RetryOf,
+			"retried_by":   status.RetriedBy,
+			"heartbeat_at": status.Heartbeat,
+			"request_id":   status.RequestID,
+			"created_at":   humanizeRuAgo(status.Created),
+			"downloads":    status.Downloads,
+			"delivery":     status.Delivery,
+			"comment":      s.comment(ctx, &status),
 		}
 		exports = append(exports, exportMap)
 	}
@@ -134,15 +401,418 @@ func (s *ExportService) GetExport(ctx context.Context, exportID string, userID i
 	}
 
 	exportMap := map[string]interface{}{
-		"key":        status.Key,
-		"type":       status.Type,
-		"user_id":    status.UserID,
-		"progress":   status.Progress,
-		"file_url":   status.FileURL,
-		"error":      status.Error,
-		"filters":    status.Filters,
-		"created_at": humanizeRuAgo(status.Created),
+		"key":          status.Key,
+		"type":         status.Type,
+		"user_id":      status.UserID,
+		"progress":     status.Progress,
+		"file_url":     status.FileURL,
+		"error":        status.Error,
+		"filters":      status.Filters,
+		"attempt":      status.Attempt,
+		"retry_of":     status.RetryOf,
+		"retried_by":   status.RetriedBy,
+		"heartbeat_at": status.Heartbeat,
+		"request_id":   status.RequestID,
+		"created_at":   humanizeRuAgo(status.Created),
+
+		"downloads":          status.Downloads,
+		"last_downloaded_at": status.LastDownloadedAt,
+		"last_downloaded_by": status.LastDownloadedBy,
+		"delivery":           status.Delivery,
+		"storage":            status.Storage,
+		"url_expires_at":     status.URLExpiresAt,
+		"comment":            s.comment(ctx, &status),
+		"history":            exportTimeline(status.History, time.Now()),
 	}
 
 	return exportMap, nil
 }
+
+// retryClaimPrefix starts the key claiming the retry of an export, so that
+// concurrent retries of it start one new attempt.
+const retryClaimPrefix = "retry:"
+
+// RetryExport starts a new attempt of a failed export owned by userID and links
+// the original record to it. It returns the new export id.
+func (s *ExportService) RetryExport(ctx context.Context, exportID string, userID int64) (string, error) {
+	if s.redis == nil {
+		return "", errors.New("redis client not configured")
+	}
+
+	data, err := s.redis.Get(ctx, exportID)
+	if err != nil {
+		return "", ErrExportNotFound
+	}
+
+	var status ExportStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return "", fmt.Errorf("failed to parse export status: %w", err)
+	}
+
+	if status.UserID != userID {
+		return "", ErrExportNotFound
+	}
+
+	if status.Error == nil || len(status.Params) == 0 || status.RetriedBy != nil {
+		return "", ErrExportNotRetryable
+	}
+
+	retrier, ok := s.retriers[status.Type]
+	if !ok {
+		return "", ErrExportNotRetryable
+	}
+
+	// RetriedBy is only set after the new attempt started; the claim closes
+	// the window in which a second request would start another one
+	claim := retryClaimPrefix + status.Key
+	claimed, err := s.redis.SetNX(ctx, claim, userID, exportTTL)
+	if err != nil {
+		return "", fmt.Errorf("claim retry: %w", err)
+	}
+	if !claimed {
+		return "", ErrExportNotRetryable
+	}
+
+	newID, err := retrier.RetryExport(ctx, &status)
+	if err != nil {
+		_ = s.redis.Del(context.WithoutCancel(ctx), claim)
+		return "", err
+	}
+
+	status.RetriedBy = &newID
+	if raw, err := json.Marshal(status); err == nil {
+		_ = s.redis.Set(ctx, status.Key, string(raw), exportTTL)
+	}
+
+	return newID, nil
+}
+
+// CancelExport stops a queued or running export owned by userID; the job
+// records the cancellation in its status itself.
+func (s *ExportService) CancelExport(ctx context.Context, exportID string, userID int64) error {
+	if s.redis == nil {
+		return errors.New("redis client not configured")
+	}
+
+	data, err := s.redis.Get(ctx, exportID)
+	if err != nil {
+		return ErrExportNotFound
+	}
+
+	var status ExportStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return fmt.Errorf("failed to parse export status: %w", err)
+	}
+
+	if status.UserID != userID {
+		return ErrExportNotFound
+	}
+
+	if status.FileURL != nil || status.Error != nil {
+		return ErrExportNotCancellable
+	}
+	if s.jobs.Cancel(status.Key) {
+		return nil
+	}
+	if cancelled, err := s.queue.Cancel(ctx, &status); err != nil || cancelled {
+		return err
+	}
+
+	return ErrExportNotCancellable
+}
+
+// SetExportFiles enables RefreshURL.
+func (s *ExportService) SetExportFiles(files ExportFiles) {
+	s.files = files
+}
+
+// RefreshURL issues a new download link of a finished export owned by userID,
+// valid as long as the export asked for, and stores it in the record.
+func (s *ExportService) RefreshURL(ctx context.Context, exportID string, userID int64) (string, *time.Time, error) {
+	if s.redis == nil || s.files == nil {
+		return "", nil, errors.New("export files not configured")
+	}
+
+	data, err := s.redis.Get(ctx, exportID)
+	if err != nil {
+		return "", nil, ErrExportNotFound
+	}
+
+	var status ExportStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return "", nil, fmt.Errorf("failed to parse export status: %w", err)
+	}
+
+	if status.UserID != userID {
+		return "", nil, ErrExportNotFound
+	}
+	if status.File == nil {
+		return "", nil, ErrExportNotReady
+	}
+	if _, err := s.files.Resolve(*status.File); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil, ErrExportFileGone
+		}
+		return "", nil, err
+	}
+
+	url, expires, err := s.files.SignURL(*status.File, time.Duration(status.URLTTLHours)*time.Hour)
+	if err != nil {
+		return "", nil, err
+	}
+	status.FileURL = &url
+	status.URLExpiresAt = nil
+	if !expires.IsZero() {
+		status.URLExpiresAt = &expires
+	}
+	if err := s.saveStatus(ctx, &status); err != nil {
+		return "", nil, err
+	}
+	_ = linkExportFile(ctx, s.redis, &status)
+	return url, status.URLExpiresAt, nil
+}
+
+// ExportFileRemover deletes stored export files; implemented by
+// *clients.StorageClient.
+type ExportFileRemover interface {
+	Delete(file string) error
+}
+
+// DeleteExport pulls an export of any user at once, e.g. a file with wrong
+// data: its job is stopped without recording anything, the file is removed
+// from storage, then the record, its Laravel cache copy, file link and comment
+// are deleted. An unfinished export can only be deleted by the instance
+// running it, or while it waits in the job queue.
+func (s *ExportService) DeleteExport(ctx context.Context, exportID string) (*ExportStatus, error) {
+	if s.redis == nil {
+		return nil, errors.New("redis client not configured")
+	}
+
+	data, err := s.redis.Get(ctx, exportID)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	var status ExportStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return nil, fmt.Errorf("failed to parse export status: %w", err)
+	}
+
+	finished := status.FileURL != nil || status.Error != nil
+	if n := len(status.History); n > 0 {
+		switch status.History[n-1].State {
+		case ExportReady, ExportFailed, ExportCancelled:
+			finished = true
+		}
+	}
+	if !s.jobs.Delete(status.Key) && !finished {
+		removed, err := s.queue.Remove(ctx, status.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !removed {
+			return nil, ErrExportNotDeletable
+		}
+	}
+
+	if status.File != nil {
+		remover, ok := s.files.(ExportFileRemover)
+		if !ok {
+			return nil, errors.New("export files not configured")
+		}
+		if err := remover.Delete(*status.File); err != nil {
+			return nil, fmt.Errorf("delete file %q: %w", *status.File, err)
+		}
+	}
+	if err := s.dropRecord(ctx, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// dropRecord deletes the record of the export with everything kept beside it:
+// the Laravel cache copy, the comment, the file link and the export index entry.
+func (s *ExportService) dropRecord(ctx context.Context, status *ExportStatus) error {
+	keys := []string{status.Key, s.cachePrefix + status.Key, exportCommentPrefix + status.Key}
+	if status.File != nil {
+		keys = append(keys, exportFilePrefix+*status.File)
+	}
+	if err := s.redis.Del(ctx, keys...); err != nil {
+		return err
+	}
+	return s.redis.SRem(ctx, exportSetKey, status.Key)
+}
+
+// UpdateComment replaces the comment of an export owned by userID. The comment
+// is kept apart from the record, which a running job keeps overwriting.
+func (s *ExportService) UpdateComment(ctx context.Context, exportID string, userID int64, comment string) error {
+	if s.redis == nil {
+		return errors.New("redis client not configured")
+	}
+
+	data, err := s.redis.Get(ctx, exportID)
+	if err != nil {
+		return ErrExportNotFound
+	}
+
+	var status ExportStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return fmt.Errorf("failed to parse export status: %w", err)
+	}
+
+	if status.UserID != userID {
+		return ErrExportNotFound
+	}
+
+	return s.redis.Set(ctx, exportCommentPrefix+status.Key, comment, exportCommentTTL)
+}
+
+// comment returns the edited comment of the export, or the one given at start.
+func (s *ExportService) comment(ctx context.Context, status *ExportStatus) string {
+	if edited, err := s.redis.Get(ctx, exportCommentPrefix+status.Key); err == nil {
+		return edited
+	}
+	return status.Comment
+}
+
+// RecordDownload counts a successful download of a stored file in the record
+// of the export that produced it; userID is nil for anonymous downloads. Files
+// whose export record has already expired are ignored. The record of a
+// single-use export is deleted once its owner downloads the file.
+func (s *ExportService) RecordDownload(ctx context.Context, file string, userID *int64, at time.Time) error {
+	if s.redis == nil {
+		return errors.New("redis client not configured")
+	}
+
+	key, err := s.redis.Get(ctx, exportFilePrefix+file)
+	if err != nil {
+		return nil
+	}
+	data, err := s.redis.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+
+	var status ExportStatus
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		return fmt.Errorf("failed to parse export status: %w", err)
+	}
+
+	status.Downloads++
+	status.LastDownloadedAt = &at
+	status.LastDownloadedBy = userID
+
+	// single-use export is gone once its owner has the file
+	if status.SingleUse && userID != nil && *userID == status.UserID {
+		status.File = &file
+		return s.dropRecord(ctx, &status)
+	}
+
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, status.Key, string(raw), exportTTL)
+}
+
+// PruneExportSet drops the keys of expired export records from the export
+// index and returns how many were removed.
+func (s *ExportService) PruneExportSet(ctx context.Context) (int, error) {
+	if s.redis == nil {
+		return 0, nil
+	}
+
+	keys, err := s.redis.SMembers(ctx, exportSetKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get export keys: %w", err)
+	}
+
+	var gone []any
+	for _, key := range keys {
+		exists, err := s.redis.Exists(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			gone = append(gone, key)
+		}
+	}
+	if len(gone) == 0 {
+		return 0, nil
+	}
+	if err := s.redis.SRem(ctx, exportSetKey, gone...); err != nil {
+		return 0, err
+	}
+	return len(gone), nil
+}
+
+// ExpireStaleExports marks failed the started exports whose record has had no
+// heartbeat for staleAfter and that do not run in this process, i.e. exports
+// left behind by a crashed instance. Queued records are left to their TTL.
+func (s *ExportService) ExpireStaleExports(ctx context.Context, staleAfter time.Duration) (int, error) {
+	if s.redis == nil || staleAfter <= 0 {
+		return 0, nil
+	}
+
+	keys, err := s.redis.SMembers(ctx, exportSetKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get export keys: %w", err)
+	}
+
+	now := time.Now()
+	expired := 0
+	for _, key := range keys {
+		data, err := s.redis.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		var status ExportStatus
+		if err := json.Unmarshal([]byte(data), &status); err != nil {
+			continue
+		}
+
+		if status.FileURL != nil || status.Error != nil || status.Progress == 0 || s.jobs.Running(status.Key) {
+			continue
+		}
+		last := status.Created
+		if status.Heartbeat != nil {
+			last = *status.Heartbeat
+		}
+		if now.Sub(last) < staleAfter {
+			continue
+		}
+
+		errStr := fmt.Sprintf("export stalled: no progress for %s", staleAfter)
+		status.Error = &errStr
+		status.Progress = 100
+		status.enter(ExportFailed)
+		if err := s.saveStatus(ctx, &status); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// saveStatus stores a status changed outside of its export job, together
+// with the Laravel cache copy.
+func (s *ExportService) saveStatus(ctx context.Context, status *ExportStatus) error {
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, status.Key, string(raw), exportTTL); err != nil {
+		return err
+	}
+
+	item := ExportCacheItem{
+		Key:      status.Key,
+		Type:     status.Type,
+		UserID:   status.UserID,
+		Progress: status.Progress,
+		FileURL:  status.FileURL,
+		Error:    status.Error,
+		Created:  status.Created.Format("2006-01-02 15:04:05"),
+	}
+	return s.redis.Set(ctx, s.cachePrefix+status.Key, phpSerializeExportItem(item), exportTTL)
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+)
+
+// Бенчмарки движка экспорта на синтетических долгах:
+//
+//	go test ./internal/service -run '^$' -bench DebtsExport -benchmem -bench.rows 1000000
+//
+// peak-rss-MB — пик RSS всего процесса, поэтому для честного сравнения форматов
+// каждый формат лучше запускать отдельно (-bench 'DebtsExport/xlsx').
+var benchRows = flag.Int("bench.rows", 10000, "number of synthetic debts per export in benchmarks")
+
+var (
+	benchFilials  = []string{"Алматы", "Астана", "Шымкент", "Караганда"}
+	benchProducts = []string{"Потребительский кредит", "Автокредит", "Ипотека", "Кредитная карта"}
+	benchStatuses = []string{"В работе", "Обещание оплаты", "Передан в суд", "Закрыт"}
+	benchNames    = []string{"Иванов", "Петров", "Сапарова", "Ахметов", "Ким", "Нурланов"}
+)
+
+// generateDebts returns n deterministic debts with every column filled, so the
+// same seed always produces the same dataset.
+func generateDebts(n int, seed uint64) []domain.Debt {
+	rnd := rand.New(rand.NewPCG(seed, seed))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pick := func(values []string) *string { return &values[rnd.IntN(len(values))] }
+	money := func(limit float64) float64 { return float64(int64(rnd.Float64()*limit*100)) / 100 }
+	date := func(days int) *time.Time {
+		t := base.AddDate(0, 0, rnd.IntN(days))
+		return &t
+	}
+
+	debts := make([]domain.Debt, n)
+	for i := range debts {
+		iin := fmt.Sprintf("%012d", rnd.Int64N(1e12))
+		registry := fmt.Sprintf("R-%04d", i/5000)
+		mainDebt := money(1e6)
+
+		debts[i] = domain.Debt{
+			Number:                     fmt.Sprintf("D-%07d", i+1),
+			StartDate:                  date(365),
+			EndDate:                    date(3 * 365),
+			Filial:                     pick(benchFilials),
+			ProductName:                pick(benchProducts),
+			AmountCurrency:             ptr("KZT"),
+			AmountActualDebt:           money(2e6),
+			AmountPurchasedLoan:        money(2e6),
+			InitAmountActualDebt:       money(2e6),
+			AmountCredit:               money(3e6),
+			AmountMainDebt:             &mainDebt,
+			AmountFine:                 money(1e5),
+			AmountAccrual:              money(1e5),
+			AmountGovernmentDuty:       money(1e4),
+			AmountRepresentationExp:    money(1e4),
+			AmountNotaryFees:           money(1e4),
+			AmountPostage:              money(1e3),
+			TransferDecision:           pick(benchStatuses),
+			PresenceSolidarity:         rnd.IntN(2) == 0,
+			GovernmentDutyPaid:         rnd.IntN(2) == 0,
+			GovernmentDutyRefund:       rnd.IntN(2) == 0,
+			RepresentationExpensesPaid: rnd.IntN(2) == 0,
+			LateDueDate:                date(365),
+			NextContact:                date(60),
+			LastContact:                date(60),
+			AdditionalData:             []byte(`{"source":"import","batch":` + fmt.Sprint(i/1000) + `}`),
+			RegistryNumber:             &registry,
+			RegistryDate:               date(365),
+			UserUsername:               pick(benchNames),
+			UserDepartments:            ptr("Soft collection"),
+			StatusName:                 pick(benchStatuses),
+			DebtorLastName:             pick(benchNames),
+			DebtorFirstName:            ptr("Иван"),
+			DebtorMiddleName:           ptr("Иванович"),
+			DebtorIIN:                  &iin,
+			CounterpartyName:           ptr("Test Bank"),
+		}
+	}
+	return debts
+}
+
+// benchDebtRepository serves a pre-generated dataset.
+type benchDebtRepository struct{ debts []domain.Debt }
+
+func (r benchDebtRepository) List(context.Context, repository.DebtsFilter) ([]domain.Debt, error) {
+	return r.debts, nil
+}
+
+func (r benchDebtRepository) ListGuarantors(context.Context, repository.DebtsFilter) ([]domain.Guarantor, error) {
+	return nil, nil
+}
+
+func (r benchDebtRepository) Count(context.Context, repository.DebtsFilter) (int64, error) {
+	return int64(len(r.debts)), nil
+}
+
+func (r benchDebtRepository) AgingCounts(context.Context, repository.DebtsFilter, repository.DebtsGroupBy) ([]domain.DebtAgingCount, error) {
+	return nil, nil
+}
+
+func (r benchDebtRepository) HasMoreThan(_ context.Context, limit int64, _ repository.DebtsFilter) (bool, error) {
+	return int64(len(r.debts)) > limit, nil
+}
+
+type nopCache struct{}
+
+func (nopCache) Set(context.Context, string, any, time.Duration) error           { return nil }
+func (nopCache) SetNX(context.Context, string, any, time.Duration) (bool, error) { return true, nil }
+func (nopCache) Get(context.Context, string) (string, error)                     { return "", nil }
+func (nopCache) SAdd(context.Context, string, ...any) error                      { return nil }
+func (nopCache) SMembers(context.Context, string) ([]string, error)              { return nil, nil }
+func (nopCache) SRem(context.Context, string, ...any) error                      { return nil }
+func (nopCache) Exists(context.Context, string) (bool, error)                    { return false, nil }
+func (nopCache) Del(context.Context, ...string) error                            { return nil }
+
+// discardStorage drops the file but keeps its size for the bytes/row metric.
+type discardStorage struct{ size int }
+
+func (s *discardStorage) Save(_ context.Context, name string, data []byte) (string, error) {
+	s.size = len(data)
+	return name, nil
+}
+
+func (s *discardStorage) GetURL(name string) string { return "/files/" + name }
+
+func (s *discardStorage) SetOwner(string, int64, bool) error { return nil }
+
+type nopNotifier struct{}
+
+func (nopNotifier) NotifyExportProgress(context.Context, int64, string, float64, string) error {
+	return nil
+}
+func (nopNotifier) NotifyExportComplete(context.Context, int64, string, string, string) error {
+	return nil
+}
+func (nopNotifier) NotifyExportFailed(context.Context, int64, string, string) error { return nil }
+func (nopNotifier) NotifyBatchComplete(context.Context, int64, string, []domain.ExportBatchResult) error {
+	return nil
+}
+
+// countingWriter measures the encoded size without keeping the output.
+type countingWriter struct{ n int }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+// writeDebtsCSV and writeDebtsNDJSON are baselines for the streaming formats;
+// they reuse the column registry so the cell work matches the XLSX path.
+func writeDebtsCSV(w io.Writer, cols []DebtColumn, debts []domain.Debt) error {
+	cw := csv.NewWriter(w)
+	record := make([]string, len(cols))
+	for i, col := range cols {
+		record[i] = col.Header
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for _, d := range debts {
+		for i, col := range cols {
+			record[i] = fmt.Sprint(col.Value(d))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeDebtsNDJSON(w io.Writer, keys []string, cols []DebtColumn, debts []domain.Debt) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	row := make(map[string]any, len(cols))
+	for _, d := range debts {
+		for i, col := range cols {
+			row[keys[i]] = col.Value(d)
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func reportExportMetrics(b *testing.B, rows, size int) {
+	b.ReportMetric(float64(rows)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+	b.ReportMetric(float64(size)/float64(rows), "bytes/row")
+	if rss := peakRSS(); rss > 0 {
+		b.ReportMetric(float64(rss)/(1<<20), "peak-rss-MB")
+	}
+}
+
+func BenchmarkDebtsExport(b *testing.B) {
+	debts := generateDebts(*benchRows, 1)
+	keys := allColumns(debtColumns)
+	cols := make([]DebtColumn, len(keys))
+	for i, k := range keys {
+		cols[i] = debtColumns[k]
+	}
+
+	b.Run("xlsx", func(b *testing.B) {
+		storage := &discardStorage{}
+		s := NewDebtService(benchDebtRepository{debts}, nopCache{}, storage, nopNotifier{})
+		b.ReportAllocs()
+		for b.Loop() {
+			status := newExportStatus(context.Background(), "debts", 1, nil, nil, firstAttempt)
+			s.runDebtsExport(context.Background(), status, keys, repository.DebtsFilter{}, DebtsExportOptions{})
+			if status.Error != nil {
+				b.Fatal(*status.Error)
+			}
+		}
+		reportExportMetrics(b, len(debts), storage.size)
+	})
+
+	b.Run("csv", func(b *testing.B) {
+		var w countingWriter
+		b.ReportAllocs()
+		for b.Loop() {
+			w = countingWriter{}
+			if err := writeDebtsCSV(&w, cols, debts); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportExportMetrics(b, len(debts), w.n)
+	})
+
+	b.Run("ndjson", func(b *testing.B) {
+		var w countingWriter
+		b.ReportAllocs()
+		for b.Loop() {
+			w = countingWriter{}
+			if err := writeDebtsNDJSON(&w, keys, cols, debts); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportExportMetrics(b, len(debts), w.n)
+	})
+}
+
+func BenchmarkGenerateDebts(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = generateDebts(*benchRows, 1)
+	}
+	b.ReportMetric(float64(*benchRows)*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+}
+
+// BenchmarkDebtsSheet compares rendering the debts sheet through the typed
+// Write of the columns with the boxed Value of the same columns:
+//
+//	go test ./internal/service -run '^$' -bench DebtsSheet -benchmem
+func BenchmarkDebtsSheet(b *testing.B) {
+	debts := generateDebts(*benchRows, 1)
+	keys := allColumns(debtColumns)
+	typed := make([]DebtColumn, len(keys))
+	boxed := make([]DebtColumn, len(keys))
+	for i, k := range keys {
+		typed[i] = debtColumns[k]
+		boxed[i] = DebtColumn{Header: typed[i].Header, Value: typed[i].Value, Link: typed[i].Link}
+	}
+
+	for _, bc := range []struct {
+		name string
+		cols []DebtColumn
+	}{{"typed", typed}, {"boxed", boxed}} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				f := newDebtsWorkbook(1)
+				if err := writeDebtsSheet(f, bc.cols, LinkTemplates{}, debts, func(int) error { return nil }); err != nil {
+					b.Fatal(err)
+				}
+				_ = f.Close()
+			}
+			b.ReportMetric(float64(len(debts))*float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
@@ -0,0 +1,861 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"debtster-export/internal/reporting"
+	"debtster-export/internal/requestid"
+	"debtster-export/internal/service/mocks"
+
+	"go.uber.org/mock/gomock"
+)
+
+type capturedReport struct {
+	err       error
+	recovered any
+	tags      map[string]string
+}
+
+// reportRecorder is a reporting.Reporter keeping what it was sent.
+type reportRecorder struct {
+	mu      sync.Mutex
+	reports []capturedReport
+}
+
+func (r *reportRecorder) CaptureError(_ context.Context, err error, tags map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, capturedReport{err: err, tags: tags})
+}
+
+func (r *reportRecorder) CapturePanic(_ context.Context, recovered any, _ []byte, tags map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, capturedReport{recovered: recovered, tags: tags})
+}
+
+func (r *reportRecorder) Flush(time.Duration) {}
+
+func (r *reportRecorder) captured() []capturedReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]capturedReport(nil), r.reports...)
+}
+
+// captureReports sends the reports of the test to a recorder.
+func captureReports(t *testing.T) *reportRecorder {
+	r := &reportRecorder{}
+	reporting.Set(r)
+	t.Cleanup(func() { reporting.Set(nil) })
+	return r
+}
+
+func TestRowsProgress(t *testing.T) {
+	tests := []struct {
+		done, total int
+		want        float64
+	}{
+		{0, 0, 0},
+		{1, 3, 33},
+		{2, 3, 67},
+		{990, 1000, 99},
+		// 100% только после того, как готов file_url
+		{995, 1000, 95},
+		{1000, 1000, 95},
+	}
+
+	for _, tt := range tests {
+		if got := rowsProgress(tt.done, tt.total); got != tt.want {
+			t.Errorf("rowsProgress(%d, %d) = %v, want %v", tt.done, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	legacy := &ExportStatus{Key: "exports:old"}
+	if a := nextAttempt(legacy); a.Number != 2 || a.RetryOf == nil || *a.RetryOf != "exports:old" {
+		t.Fatalf("unexpected attempt for legacy status: %+v", a)
+	}
+
+	third := &ExportStatus{Key: "exports:2nd", Attempt: 2}
+	if a := nextAttempt(third); a.Number != 3 {
+		t.Fatalf("expected attempt 3, got %d", a.Number)
+	}
+}
+
+// retrierFunc adapts a function to ExportRetrier.
+type retrierFunc func(ctx context.Context, original *ExportStatus) (string, error)
+
+func (f retrierFunc) RetryExport(ctx context.Context, original *ExportStatus) (string, error) {
+	return f(ctx, original)
+}
+
+func storedStatus(t *testing.T, st ExportStatus) string {
+	t.Helper()
+	raw, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestExportTimeline(t *testing.T) {
+	st := newExportStatus(context.Background(), "debts", 7, nil, nil, firstAttempt)
+	st.enter(ExportRunning)
+	st.enter(ExportRunning)
+	if len(st.History) != 2 || st.History[0].State != ExportQueued || st.History[1].State != ExportRunning {
+		t.Fatalf("history = %+v", st.History)
+	}
+
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	history := []StatusTransition{
+		{State: ExportQueued, At: start},
+		{State: ExportRunning, At: start.Add(2 * time.Second)},
+		{State: ExportUploading, At: start.Add(10 * time.Second)},
+	}
+	// текущее состояние идёт до now
+	got := exportTimeline(history, start.Add(15*time.Second))
+	for i, want := range []int64{2000, 8000, 5000} {
+		if got[i]["duration_ms"] != want {
+			t.Errorf("%s: duration_ms = %v, want %d", got[i]["state"], got[i]["duration_ms"], want)
+		}
+	}
+
+	// у завершённого экспорта последнее состояние без длительности
+	history = append(history, StatusTransition{State: ExportReady, At: start.Add(12 * time.Second)})
+	got = exportTimeline(history, start.Add(time.Hour))
+	if _, ok := got[3]["duration_ms"]; ok || got[2]["duration_ms"] != int64(2000) {
+		t.Fatalf("timeline = %v", got)
+	}
+}
+
+func TestExportService_RetryExport(t *testing.T) {
+	errMsg := "query failed"
+	retried := "exports:newer"
+
+	failed := ExportStatus{Key: "exports:1", Type: "debts", UserID: 7, Error: &errMsg, Params: json.RawMessage(`{}`)}
+	running := ExportStatus{Key: "exports:1", Type: "debts", UserID: 7, Params: json.RawMessage(`{}`)}
+	alreadyRetried := failed
+	alreadyRetried.RetriedBy = &retried
+	unknownType := failed
+	unknownType.Type = "registries"
+
+	tests := []struct {
+		name    string
+		stored  *ExportStatus
+		userID  int64
+		wantErr error
+	}{
+		{"missing", nil, 7, ErrExportNotFound},
+		{"other user", &failed, 8, ErrExportNotFound},
+		{"still running", &running, 7, ErrExportNotRetryable},
+		{"already retried", &alreadyRetried, 7, ErrExportNotRetryable},
+		{"unknown type", &unknownType, 7, ErrExportNotRetryable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			cache := mocks.NewMockCache(ctrl)
+			s := NewExportService(cache, "pkb_database_cache")
+			s.RegisterRetrier("debts", retrierFunc(func(context.Context, *ExportStatus) (string, error) {
+				t.Fatal("retrier must not be called")
+				return "", nil
+			}))
+
+			if tt.stored == nil {
+				cache.EXPECT().Get(gomock.Any(), "exports:1").Return("", errors.New("redis: nil"))
+			} else {
+				cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, *tt.stored), nil)
+			}
+
+			if _, err := s.RetryExport(context.Background(), "exports:1", tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("links original to the new attempt", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cache := mocks.NewMockCache(ctrl)
+		s := NewExportService(cache, "pkb_database_cache")
+		s.RegisterRetrier("debts", retrierFunc(func(_ context.Context, original *ExportStatus) (string, error) {
+			if original.Key != "exports:1" {
+				t.Fatalf("unexpected original: %+v", original)
+			}
+			return "exports:2", nil
+		}))
+
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, failed), nil)
+		cache.EXPECT().SetNX(gomock.Any(), "retry:exports:1", int64(7), exportTTL).Return(true, nil)
+		cache.EXPECT().Set(gomock.Any(), "exports:1", gomock.Any(), exportTTL).DoAndReturn(
+			func(_ context.Context, _ string, value any, _ time.Duration) error {
+				var st ExportStatus
+				if err := json.Unmarshal([]byte(value.(string)), &st); err != nil {
+					t.Fatal(err)
+				}
+				if st.RetriedBy == nil || *st.RetriedBy != "exports:2" {
+					t.Fatalf("original status is not linked: %+v", st)
+				}
+				return nil
+			})
+
+		newID, err := s.RetryExport(context.Background(), "exports:1", 7)
+		if err != nil || newID != "exports:2" {
+			t.Fatalf("unexpected result: %q, %v", newID, err)
+		}
+	})
+
+	// второй повтор, пришедший до записи retried_by, видит занятый ключ
+	t.Run("second retry refused", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cache := mocks.NewMockCache(ctrl)
+		s := NewExportService(cache, "pkb_database_cache")
+		s.RegisterRetrier("debts", retrierFunc(func(context.Context, *ExportStatus) (string, error) {
+			t.Fatal("retrier must not be called")
+			return "", nil
+		}))
+
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, failed), nil)
+		cache.EXPECT().SetNX(gomock.Any(), "retry:exports:1", int64(7), exportTTL).Return(false, nil)
+
+		if _, err := s.RetryExport(context.Background(), "exports:1", 7); !errors.Is(err, ErrExportNotRetryable) {
+			t.Fatalf("expected %v, got %v", ErrExportNotRetryable, err)
+		}
+	})
+
+	t.Run("failed retry releases the claim", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cache := mocks.NewMockCache(ctrl)
+		s := NewExportService(cache, "pkb_database_cache")
+		quota := errors.New("quota exceeded")
+		s.RegisterRetrier("debts", retrierFunc(func(context.Context, *ExportStatus) (string, error) {
+			return "", quota
+		}))
+
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, failed), nil)
+		cache.EXPECT().SetNX(gomock.Any(), "retry:exports:1", int64(7), exportTTL).Return(true, nil)
+		cache.EXPECT().Del(gomock.Any(), "retry:exports:1").Return(nil)
+
+		if _, err := s.RetryExport(context.Background(), "exports:1", 7); !errors.Is(err, quota) {
+			t.Fatalf("expected %v, got %v", quota, err)
+		}
+	})
+
+	t.Run("concurrent retries start one attempt", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cache := mocks.NewMockCache(ctrl)
+		s := NewExportService(cache, "pkb_database_cache")
+
+		var (
+			mu      sync.Mutex
+			claims  = map[string]bool{}
+			started int
+		)
+		s.RegisterRetrier("debts", retrierFunc(func(context.Context, *ExportStatus) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			started++
+			return "exports:2", nil
+		}))
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, failed), nil).AnyTimes()
+		cache.EXPECT().SetNX(gomock.Any(), "retry:exports:1", gomock.Any(), exportTTL).DoAndReturn(
+			func(_ context.Context, key string, _ any, _ time.Duration) (bool, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if claims[key] {
+					return false, nil
+				}
+				claims[key] = true
+				return true, nil
+			}).AnyTimes()
+		cache.EXPECT().Set(gomock.Any(), "exports:1", gomock.Any(), exportTTL).Return(nil).AnyTimes()
+
+		const requests = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, requests)
+		for range requests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.RetryExport(context.Background(), "exports:1", 7)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		refused := 0
+		for err := range errs {
+			if errors.Is(err, ErrExportNotRetryable) {
+				refused++
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if started != 1 || refused != requests-1 {
+			t.Fatalf("started %d attempts, refused %d requests", started, refused)
+		}
+	})
+}
+
+func TestExportService_CancelExport(t *testing.T) {
+	fileURL := "/files/abc_debts.xlsx"
+	done := ExportStatus{Key: "exports:1", UserID: 7, Progress: 100, FileURL: &fileURL}
+	queued := ExportStatus{Key: "exports:1", UserID: 7}
+
+	t.Run("finished export", func(t *testing.T) {
+		cache := mocks.NewMockCache(gomock.NewController(t))
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, done), nil)
+
+		s := NewExportService(cache, "pkb_database_cache")
+		s.SetJobRunner(NewJobRunner(1))
+
+		if err := s.CancelExport(context.Background(), "exports:1", 7); !errors.Is(err, ErrExportNotCancellable) {
+			t.Fatalf("expected ErrExportNotCancellable, got %v", err)
+		}
+	})
+
+	t.Run("running on another instance", func(t *testing.T) {
+		cache := mocks.NewMockCache(gomock.NewController(t))
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, queued), nil)
+
+		s := NewExportService(cache, "pkb_database_cache")
+		s.SetJobRunner(NewJobRunner(1))
+
+		if err := s.CancelExport(context.Background(), "exports:1", 7); !errors.Is(err, ErrExportNotCancellable) {
+			t.Fatalf("expected ErrExportNotCancellable, got %v", err)
+		}
+	})
+
+	t.Run("queued in this process", func(t *testing.T) {
+		cache := mocks.NewMockCache(gomock.NewController(t))
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, queued), nil)
+
+		jobs := NewJobRunner(1)
+		s := NewExportService(cache, "pkb_database_cache")
+		s.SetJobRunner(jobs)
+
+		cancelled := make(chan error, 1)
+		jobs.Go(context.Background(), "exports:1", "debts", nil, func(ctx context.Context) {
+			<-ctx.Done()
+			cancelled <- context.Cause(ctx)
+		})
+
+		if err := s.CancelExport(context.Background(), "exports:1", 7); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		if cause := <-cancelled; !errors.Is(cause, ErrExportCancelled) {
+			t.Fatalf("expected ErrExportCancelled cause, got %v", cause)
+		}
+	})
+}
+
+func TestExportService_UpdateComment(t *testing.T) {
+	stored := ExportStatus{Key: "exports:1", UserID: 7, Comment: "для суда"}
+
+	cache := mocks.NewMockCache(gomock.NewController(t))
+	s := NewExportService(cache, "pkb_database_cache")
+
+	// чужой экспорт
+	cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, stored), nil)
+	if err := s.UpdateComment(context.Background(), "exports:1", 8, "x"); !errors.Is(err, ErrExportNotFound) {
+		t.Fatalf("expected ErrExportNotFound, got %v", err)
+	}
+
+	cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, stored), nil)
+	cache.EXPECT().Set(gomock.Any(), exportCommentPrefix+"exports:1", "для банка", exportCommentTTL).Return(nil)
+	if err := s.UpdateComment(context.Background(), "exports:1", 7, "для банка"); err != nil {
+		t.Fatalf("update comment: %v", err)
+	}
+
+	// отредактированный комментарий важнее исходного
+	cache.EXPECT().Get(gomock.Any(), exportCommentPrefix+"exports:1").Return("для банка", nil)
+	if got := s.comment(context.Background(), &stored); got != "для банка" {
+		t.Fatalf("got comment %q", got)
+	}
+	cache.EXPECT().Get(gomock.Any(), exportCommentPrefix+"exports:1").Return("", errors.New("redis: nil"))
+	if got := s.comment(context.Background(), &stored); got != "для суда" {
+		t.Fatalf("got comment %q, want the one from start", got)
+	}
+}
+
+// signedFiles is an ExportFiles holding the files mapped to true.
+type signedFiles map[string]bool
+
+func (f signedFiles) Resolve(file string) (string, error) {
+	if !f[file] {
+		return "", fs.ErrNotExist
+	}
+	return "/exports/" + file, nil
+}
+
+func (f signedFiles) SignURL(file string, ttl time.Duration) (string, time.Time, error) {
+	return "/files/" + file + "?ttl=" + ttl.String(), time.Date(2025, 5, 8, 0, 0, 0, 0, time.UTC), nil
+}
+
+func TestExportService_RefreshURL(t *testing.T) {
+	file, gone := "abc_debts.xlsx", "old_debts.xlsx"
+	cache := mocks.NewMockCache(gomock.NewController(t))
+	s := NewExportService(cache, "pkb_database_cache")
+	s.SetExportFiles(signedFiles{file: true})
+
+	cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, ExportStatus{Key: "exports:1", UserID: 7}), nil)
+	if _, _, err := s.RefreshURL(context.Background(), "exports:1", 7); !errors.Is(err, ErrExportNotReady) {
+		t.Fatalf("expected ErrExportNotReady, got %v", err)
+	}
+
+	// файл уже удалён очисткой
+	cache.EXPECT().Get(gomock.Any(), "exports:2").Return(storedStatus(t, ExportStatus{Key: "exports:2", UserID: 7, File: &gone}), nil)
+	if _, _, err := s.RefreshURL(context.Background(), "exports:2", 7); !errors.Is(err, ErrExportFileGone) {
+		t.Fatalf("expected ErrExportFileGone, got %v", err)
+	}
+
+	stored := ExportStatus{Key: "exports:3", UserID: 7, File: &file, URLTTLHours: 2}
+	cache.EXPECT().Get(gomock.Any(), "exports:3").Return(storedStatus(t, stored), nil).Times(2)
+	if _, _, err := s.RefreshURL(context.Background(), "exports:3", 8); !errors.Is(err, ErrExportNotFound) {
+		t.Fatalf("expected ErrExportNotFound for another user, got %v", err)
+	}
+	cache.EXPECT().Set(gomock.Any(), "exports:3", gomock.Any(), exportTTL).DoAndReturn(
+		func(_ context.Context, _ string, value any, _ time.Duration) error {
+			var st ExportStatus
+			if err := json.Unmarshal([]byte(value.(string)), &st); err != nil {
+				t.Fatal(err)
+			}
+			if st.FileURL == nil || *st.FileURL != "/files/abc_debts.xlsx?ttl=2h0m0s" || st.URLExpiresAt == nil {
+				t.Fatalf("link is not refreshed: %+v", st)
+			}
+			return nil
+		})
+	cache.EXPECT().Set(gomock.Any(), "pkb_database_cache"+"exports:3", gomock.Any(), exportTTL).Return(nil)
+	cache.EXPECT().Set(gomock.Any(), exportFilePrefix+file, "exports:3", exportTTL).Return(nil)
+
+	url, expires, err := s.RefreshURL(context.Background(), "exports:3", 7)
+	if err != nil {
+		t.Fatalf("refresh url: %v", err)
+	}
+	if url != "/files/abc_debts.xlsx?ttl=2h0m0s" || expires == nil {
+		t.Fatalf("got %q, %v", url, expires)
+	}
+}
+
+func TestExportService_RecordDownload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cache := mocks.NewMockCache(ctrl)
+	s := NewExportService(cache, "pkb_database_cache")
+
+	file := "abc_debts.xlsx"
+	userID := int64(7)
+	at := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	cache.EXPECT().Get(gomock.Any(), exportFilePrefix+file).Return("exports:1", nil)
+	cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, ExportStatus{Key: "exports:1", UserID: 7, File: &file, Downloads: 1}), nil)
+	cache.EXPECT().Set(gomock.Any(), "exports:1", gomock.Any(), exportTTL).DoAndReturn(
+		func(_ context.Context, _ string, value any, _ time.Duration) error {
+			var st ExportStatus
+			if err := json.Unmarshal([]byte(value.(string)), &st); err != nil {
+				t.Fatal(err)
+			}
+			if st.Downloads != 2 || st.LastDownloadedBy == nil || *st.LastDownloadedBy != 7 || !st.LastDownloadedAt.Equal(at) {
+				t.Fatalf("download is not recorded: %+v", st)
+			}
+			return nil
+		})
+
+	if err := s.RecordDownload(context.Background(), file, &userID, at); err != nil {
+		t.Fatalf("record download: %v", err)
+	}
+
+	// запись экспорта уже истекла
+	cache.EXPECT().Get(gomock.Any(), exportFilePrefix+"gone.xlsx").Return("", errors.New("redis: nil"))
+	if err := s.RecordDownload(context.Background(), "gone.xlsx", nil, at); err != nil {
+		t.Fatalf("expired export must be ignored, got %v", err)
+	}
+}
+
+func TestExportService_RecordDownload_SingleUse(t *testing.T) {
+	file := "abc_debts.xlsx"
+	stored := ExportStatus{Key: "exports:1", UserID: 7, File: &file, SingleUse: true}
+	at := time.Now()
+
+	t.Run("other user keeps the record", func(t *testing.T) {
+		cache := mocks.NewMockCache(gomock.NewController(t))
+		cache.EXPECT().Get(gomock.Any(), exportFilePrefix+file).Return("exports:1", nil)
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, stored), nil)
+		cache.EXPECT().Set(gomock.Any(), "exports:1", gomock.Any(), exportTTL).Return(nil)
+
+		other := int64(8)
+		if err := NewExportService(cache, "pkb_database_cache").RecordDownload(context.Background(), file, &other, at); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("owner download expires the record", func(t *testing.T) {
+		cache := mocks.NewMockCache(gomock.NewController(t))
+		cache.EXPECT().Get(gomock.Any(), exportFilePrefix+file).Return("exports:1", nil)
+		cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, stored), nil)
+		// копия для Laravel, комментарий и запись в индексе уходят вместе с записью
+		cache.EXPECT().Del(gomock.Any(), "exports:1", "pkb_database_cacheexports:1", exportCommentPrefix+"exports:1", exportFilePrefix+file).Return(nil)
+		cache.EXPECT().SRem(gomock.Any(), exportSetKey, "exports:1").Return(nil)
+
+		owner := int64(7)
+		if err := NewExportService(cache, "pkb_database_cache").RecordDownload(context.Background(), file, &owner, at); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestExportService_PruneExportSet(t *testing.T) {
+	cache := mocks.NewMockCache(gomock.NewController(t))
+	cache.EXPECT().SMembers(gomock.Any(), exportSetKey).Return([]string{"exports:1", "exports:2", "exports:3"}, nil)
+	cache.EXPECT().Exists(gomock.Any(), "exports:1").Return(true, nil)
+	cache.EXPECT().Exists(gomock.Any(), "exports:2").Return(false, nil)
+	cache.EXPECT().Exists(gomock.Any(), "exports:3").Return(false, nil)
+	cache.EXPECT().SRem(gomock.Any(), exportSetKey, "exports:2", "exports:3").Return(nil)
+
+	n, err := NewExportService(cache, "pkb_database_cache").PruneExportSet(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 pruned keys, got %d, %v", n, err)
+	}
+}
+
+func TestExportService_ExpireStaleExports(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	fresh := time.Now()
+	fileURL := "/files/abc_debts.xlsx"
+
+	records := map[string]ExportStatus{
+		"exports:stale":  {Key: "exports:stale", Progress: 40, Heartbeat: &old},
+		"exports:fresh":  {Key: "exports:fresh", Progress: 40, Heartbeat: &fresh},
+		"exports:queued": {Key: "exports:queued", Heartbeat: &old},
+		"exports:done":   {Key: "exports:done", Progress: 100, Heartbeat: &old, FileURL: &fileURL},
+		"exports:local":  {Key: "exports:local", Progress: 40, Heartbeat: &old},
+	}
+
+	cache := mocks.NewMockCache(gomock.NewController(t))
+	cache.EXPECT().SMembers(gomock.Any(), exportSetKey).Return([]string{
+		"exports:stale", "exports:fresh", "exports:queued", "exports:done", "exports:local", "exports:expired",
+	}, nil)
+	cache.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string) (string, error) {
+		st, ok := records[key]
+		if !ok {
+			return "", errors.New("redis: nil")
+		}
+		return storedStatus(t, st), nil
+	}).AnyTimes()
+	cache.EXPECT().Set(gomock.Any(), "exports:stale", gomock.Any(), exportTTL).DoAndReturn(
+		func(_ context.Context, _ string, value any, _ time.Duration) error {
+			var st ExportStatus
+			if err := json.Unmarshal([]byte(value.(string)), &st); err != nil {
+				t.Fatal(err)
+			}
+			if st.Error == nil || st.Progress != 100 {
+				t.Fatalf("stale export is not failed: %+v", st)
+			}
+			return nil
+		})
+	cache.EXPECT().Set(gomock.Any(), "pkb_database_cacheexports:stale", gomock.Any(), exportTTL).Return(nil)
+
+	jobs := NewJobRunner(1)
+	release := make(chan struct{})
+	defer close(release)
+	jobs.Go(context.Background(), "exports:local", "debts", nil, func(context.Context) { <-release })
+
+	s := NewExportService(cache, "pkb_database_cache")
+	s.SetJobRunner(jobs)
+
+	n, err := s.ExpireStaleExports(context.Background(), 10*time.Minute)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 expired export, got %d, %v", n, err)
+	}
+}
+
+// removableFiles is an ExportFiles that records deleted files.
+type removableFiles struct {
+	signedFiles
+	deleted []string
+}
+
+func (f *removableFiles) Delete(file string) error {
+	f.deleted = append(f.deleted, file)
+	return nil
+}
+
+func TestExportService_DeleteExport(t *testing.T) {
+	file := "abc_debts.xlsx"
+	cache := mocks.NewMockCache(gomock.NewController(t))
+	files := &removableFiles{signedFiles: signedFiles{file: true}}
+	s := NewExportService(cache, "pkb_database_cache")
+	s.SetExportFiles(files)
+
+	cache.EXPECT().Get(gomock.Any(), "exports:404").Return("", errors.New("redis: nil"))
+	if _, err := s.DeleteExport(context.Background(), "exports:404"); !errors.Is(err, ErrExportNotFound) {
+		t.Fatalf("expected ErrExportNotFound, got %v", err)
+	}
+
+	// незавершённый экспорт без задачи в этом процессе удалить нельзя
+	running := ExportStatus{Key: "exports:1", UserID: 7, History: []StatusTransition{{State: ExportRunning}}}
+	cache.EXPECT().Get(gomock.Any(), "exports:1").Return(storedStatus(t, running), nil)
+	if _, err := s.DeleteExport(context.Background(), "exports:1"); !errors.Is(err, ErrExportNotDeletable) {
+		t.Fatalf("expected ErrExportNotDeletable, got %v", err)
+	}
+	if len(files.deleted) != 0 {
+		t.Fatalf("files deleted: %v", files.deleted)
+	}
+
+	url := "/files/" + file
+	ready := ExportStatus{Key: "exports:2", UserID: 7, File: &file, FileURL: &url}
+	cache.EXPECT().Get(gomock.Any(), "exports:2").Return(storedStatus(t, ready), nil)
+	cache.EXPECT().Del(gomock.Any(), "exports:2", "pkb_database_cache"+"exports:2", exportCommentPrefix+"exports:2", exportFilePrefix+file).Return(nil)
+	cache.EXPECT().SRem(gomock.Any(), exportSetKey, "exports:2").Return(nil)
+	st, err := s.DeleteExport(context.Background(), "exports:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.UserID != 7 || len(files.deleted) != 1 || files.deleted[0] != file {
+		t.Fatalf("deleted %+v, files %v", st, files.deleted)
+	}
+}
+
+func TestJobRunner_Delete(t *testing.T) {
+	r := NewJobRunner(1)
+	started := make(chan struct{})
+	var failed []string
+	r.Go(context.Background(), "exports:1", "debts", func(ctx context.Context, errStr string) {
+		failed = append(failed, errStr)
+	}, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		// задача сама решает, записывать ли отмену, как failExport
+		if _, report := abortReason(ctx); report {
+			failed = append(failed, "recorded")
+		}
+	})
+	<-started
+
+	if !r.Delete("exports:1") {
+		t.Fatal("running job is not found")
+	}
+	r.Shutdown(context.Background())
+	if len(failed) != 0 {
+		t.Fatalf("deleted export must record nothing, got %v", failed)
+	}
+}
+
+func TestJobRunner_Lanes(t *testing.T) {
+	r := NewJobRunner(1)
+	r.SetLanes(map[string]int{"payments": 1})
+	defer r.Shutdown(context.Background())
+
+	release := make(chan struct{})
+	started := make(chan string, 4)
+	run := func(key, exportType string) {
+		r.Go(context.Background(), key, exportType, nil, func(context.Context) {
+			started <- key
+			<-release
+		})
+	}
+
+	// длинные платежи занимают свою полосу, а пользователи — общий пул
+	run("exports:p1", "payments")
+	run("exports:p2", "payments")
+	run("exports:u1", "users")
+	got := map[string]bool{<-started: true, <-started: true}
+	if !got["exports:p1"] || !got["exports:u1"] {
+		t.Fatalf("started %v", got)
+	}
+
+	lanes := r.Lanes()
+	if size, busy := r.Workers(); size != 1 || busy != 1 || len(lanes) != 1 || lanes[0].Busy != 1 {
+		t.Fatalf("pool %d/%d, lanes %+v", busy, size, lanes)
+	}
+	select {
+	case key := <-started:
+		t.Fatalf("%s started over the lane limit", key)
+	default:
+	}
+	close(release)
+	if key := <-started; key != "exports:p2" {
+		t.Fatalf("started %s", key)
+	}
+}
+
+func TestJobRunner_ReapStalled(t *testing.T) {
+	r := NewJobRunner(2)
+	r.SetStallTimeout(50 * time.Millisecond)
+	defer r.Shutdown(context.Background())
+
+	var mu sync.Mutex
+	failed := map[string][]string{}
+	fail := func(key string) func(context.Context, string) {
+		return func(_ context.Context, errStr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed[key] = append(failed[key], errStr)
+		}
+	}
+
+	// зависшая выгрузка ждёт отмены и не пишет ошибку сама, как failExport
+	stalledDone := make(chan bool, 1)
+	r.Go(context.Background(), "exports:stalled", "debts", fail("exports:stalled"), func(ctx context.Context) {
+		<-ctx.Done()
+		_, report := abortReason(ctx)
+		stalledDone <- report
+	})
+	// активная выгрузка регулярно отмечает прогресс
+	stopActive := make(chan struct{})
+	r.Go(context.Background(), "exports:active", "debts", fail("exports:active"), func(ctx context.Context) {
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stopActive:
+				return
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				r.Beat("exports:active")
+			}
+		}
+	})
+	defer close(stopActive)
+	// третья ждёт свободного слота
+	queuedStarted := make(chan struct{})
+	r.Go(context.Background(), "exports:queued", "debts", fail("exports:queued"), func(ctx context.Context) {
+		close(queuedStarted)
+		<-ctx.Done()
+	})
+
+	if n := r.ReapStalled(); n != 0 {
+		t.Fatalf("reaped %d jobs before the timeout", n)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if n := r.ReapStalled(); n != 1 {
+		t.Fatalf("reaped %d jobs, want 1", n)
+	}
+
+	select {
+	case report := <-stalledDone:
+		if report {
+			t.Fatal("stalled job recorded its own failure over the watchdog's")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stalled job was not cancelled")
+	}
+	mu.Lock()
+	if got := failed["exports:stalled"]; len(got) != 1 || got[0] != "export stalled: no progress for 50ms" {
+		t.Errorf("stalled export failures = %q", got)
+	}
+	if len(failed["exports:active"]) != 0 || len(failed["exports:queued"]) != 0 {
+		t.Errorf("healthy exports failed: %v", failed)
+	}
+	mu.Unlock()
+
+	if r.Running("exports:stalled") || !r.Running("exports:active") {
+		t.Fatalf("jobs = %+v", r.Jobs())
+	}
+	// слот зависшей выгрузки освобождён для очереди
+	select {
+	case <-queuedStarted:
+	case <-time.After(time.Second):
+		t.Fatal("queued job did not get the freed slot")
+	}
+
+	r.SetStallTimeout(0)
+	time.Sleep(80 * time.Millisecond)
+	if n := r.ReapStalled(); n != 0 {
+		t.Fatalf("disabled watchdog reaped %d jobs", n)
+	}
+}
+
+func TestNewExportEstimate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    int64
+		columns int
+		limit   int64
+		want    ExportEstimate
+	}{
+		{"empty", 0, 5, 100, ExportEstimate{Columns: 5, EstimatedSeconds: 2, Limit: 100}},
+		// 300 000 ячеек: 3,6 МБ и 1,5 с сверх запроса
+		{"cells", 100_000, 3, 1_000_000, ExportEstimate{Rows: 100_000, Columns: 3, EstimatedBytes: 3_600_000, EstimatedSeconds: 3.5, Limit: 1_000_000}},
+		{"rounded", 1, 1, 0, ExportEstimate{Rows: 1, Columns: 1, EstimatedBytes: 12, EstimatedSeconds: 2}},
+		{"at limit", 100, 1, 100, ExportEstimate{Rows: 100, Columns: 1, EstimatedBytes: 1200, EstimatedSeconds: 2, Limit: 100}},
+		{"over limit", 101, 1, 100, ExportEstimate{Rows: 101, Columns: 1, EstimatedBytes: 1212, EstimatedSeconds: 2, Limit: 100, ExceedsLimit: true}},
+		// без лимита превысить нечего
+		{"no limit", 5_000_000, 1, 0, ExportEstimate{Rows: 5_000_000, Columns: 1, EstimatedBytes: 60_000_000, EstimatedSeconds: 27}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newExportEstimate(tt.rows, tt.columns, tt.limit); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobRunner_ReportsPanic(t *testing.T) {
+	reports := captureReports(t)
+
+	for _, r := range []*JobRunner{NewJobRunner(1), nil} {
+		failed := make(chan string, 1)
+		r.Go(context.Background(), "exports:1", "debts", func(_ context.Context, errStr string) {
+			failed <- errStr
+		}, func(context.Context) {
+			panic("nil map")
+		})
+
+		// паника не роняет процесс: экспорт помечается упавшим
+		if errStr := <-failed; errStr != "internal error: nil map" {
+			t.Fatalf("failed with %q", errStr)
+		}
+		if r != nil {
+			r.Shutdown(context.Background())
+		}
+	}
+
+	got := reports.captured()
+	if len(got) != 2 {
+		t.Fatalf("reports = %+v", got)
+	}
+	for _, rep := range got {
+		if rep.recovered != "nil map" || rep.tags["export_id"] != "exports:1" {
+			t.Fatalf("report = %+v", rep)
+		}
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	ctx := requestid.WithID(context.Background(), "req-1")
+
+	// статус и теги отчётов помнят запрос, запустивший выгрузку
+	status := newExportStatus(ctx, "debts", 7, nil, nil, firstAttempt)
+	if status.RequestID != "req-1" || exportTags(status)["request_id"] != "req-1" {
+		t.Fatalf("status request id %q, tags %v", status.RequestID, exportTags(status))
+	}
+
+	// задача видит id и после того, как HTTP-запрос завершился
+	reqCtx, cancel := context.WithCancel(ctx)
+	r := NewJobRunner(1)
+	got := make(chan string, 2)
+	r.Go(context.WithoutCancel(reqCtx), "exports:1", "debts", func(ctx context.Context, _ string) {
+		got <- requestid.FromContext(ctx)
+	}, func(ctx context.Context) {
+		got <- requestid.FromContext(ctx)
+		panic("boom")
+	})
+	cancel()
+	r.Shutdown(context.Background())
+
+	for _, where := range []string{"job", "fail"} {
+		if id := <-got; id != "req-1" {
+			t.Fatalf("%s context has request id %q", where, id)
+		}
+	}
+}
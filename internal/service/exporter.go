@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"debtster-export/internal/reporting"
+	"debtster-export/internal/requestid"
+)
+
+// exporter holds the dependencies the export services share to save the state
+// of an export and to complete or fail it. Each service embeds one, so the
+// steps below are written once for all export types.
+type exporter struct {
+	redis       Cache
+	s3          FileStorage
+	ws          Notifier
+	cachePrefix string
+	retry       RetryPolicy
+	jobs        *JobRunner
+	outbox      *Outbox
+	deliverers  Deliverers
+}
+
+// newExporter returns the exporter of a service writing to the Laravel cache.
+func newExporter(redis Cache, s3 FileStorage, ws Notifier) exporter {
+	return exporter{redis: redis, s3: s3, ws: ws, cachePrefix: "pkb_database_cache", retry: DefaultRetryPolicy}
+}
+
+// failExport marks the export as failed and notifies the user.
+func (e *exporter) failExport(ctx context.Context, status *ExportStatus, errStr string) {
+	aborted := ctx.Err() != nil
+	if aborted {
+		reason, report := abortReason(ctx)
+		if !report {
+			return
+		}
+		errStr = reason
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	requestid.Logf(ctx, "export %s: %s", status.Key, errStr)
+	if !aborted {
+		reporting.CaptureError(ctx, errors.New(errStr), exportTags(status))
+	}
+	status.Error = &errStr
+	status.Progress = 100
+	if aborted {
+		status.enter(ExportCancelled)
+	} else {
+		status.enter(ExportFailed)
+	}
+
+	e.saveFinalStatus(ctx, status)
+
+	if e.ws != nil {
+		_ = e.ws.NotifyExportFailed(ctx, status.UserID, status.Key, errStr)
+	}
+}
+
+// saveExportStatus writes the status record and its Laravel cache item, see
+// writeStatus.
+func (e *exporter) saveExportStatus(ctx context.Context, st *ExportStatus) error {
+	now := time.Now()
+	st.Heartbeat = &now
+	e.jobs.Beat(st.Key)
+
+	if e.redis == nil {
+		return nil
+	}
+	return writeStatus(ctx, e.redis, st, e.cachePrefix+st.Key, phpSerializeExportItem(e.toCacheItem(st)))
+}
+
+func (e *exporter) toCacheItem(st *ExportStatus) ExportCacheItem {
+	created := st.Created.Format("2006-01-02 15:04:05")
+	return ExportCacheItem{
+		Key:      st.Key,
+		Type:     st.Type,
+		UserID:   st.UserID,
+		Progress: st.Progress,
+		FileURL:  st.FileURL,
+		Error:    st.Error,
+		Created:  created,
+	}
+}
+
+// saveFinalStatus writes the last status of an export; with an outbox the
+// writes are retried until Redis takes them.
+func (e *exporter) saveFinalStatus(ctx context.Context, st *ExportStatus) {
+	if e.outbox == nil || e.redis == nil {
+		_ = e.saveExportStatus(ctx, st)
+		return
+	}
+	now := time.Now()
+	st.Heartbeat = &now
+	e.jobs.Beat(st.Key)
+	_ = e.outbox.SaveStatus(ctx, st, e.cachePrefix+st.Key, phpSerializeExportItem(e.toCacheItem(st)))
+}
+
+// sinkExport sends the rows of the generated workbook to the sink of the
+// delivery and completes the export without a file.
+func (e *exporter) sinkExport(ctx context.Context, status *ExportStatus, opts ExportOptions, data []byte, names []string, samples []any) {
+	exportID, userID := status.Key, status.UserID
+
+	status.Progress = 95
+	status.enter(ExportDelivering)
+	_ = e.saveExportStatus(ctx, status)
+	if e.ws != nil {
+		_ = e.ws.NotifyExportProgress(ctx, userID, exportID, 95, "delivering")
+	}
+
+	rows, err := sinkRows(data, names, samples)
+	if err != nil {
+		e.failExport(ctx, status, fmt.Sprintf("read rows failed: %v", err))
+		return
+	}
+	if err := e.deliverers.insertRows(ctx, e.retry, status, opts.Delivery, rows); err != nil {
+		e.failExport(ctx, status, err.Error())
+		return
+	}
+	status.Progress = 100
+	status.enter(ExportReady)
+	e.saveFinalStatus(ctx, status)
+
+	if e.ws != nil {
+		_ = e.ws.NotifyExportProgress(ctx, userID, exportID, 100, "ready")
+	}
+}
+
+// saveExportFile stores a generated file, delivers it when requested and
+// completes the export.
+func (e *exporter) saveExportFile(ctx context.Context, status *ExportStatus, opts ExportOptions, fileName string, data []byte) {
+	exportID, userID := status.Key, status.UserID
+
+	if e.s3 != nil {
+		// notify upload phase before starting upload
+		status.Progress = 95
+		status.enter(ExportUploading)
+		_ = e.saveExportStatus(ctx, status)
+		if e.ws != nil {
+			_ = e.ws.NotifyExportProgress(ctx, userID, exportID, 95, "uploading")
+		}
+
+		var savedName string
+		err := e.retry.Do(ctx, "export "+exportID+": save", func() error {
+			var err error
+			savedName, err = e.s3.Save(ctx, fileName, data)
+			return err
+		})
+		if err == nil {
+			// the link is published only once the file is accounted and protected
+			err = e.s3.SetOwner(savedName, userID, opts.SingleUse)
+		}
+		var (
+			url        string
+			urlExpires *time.Time
+		)
+		if err == nil {
+			url, urlExpires, err = fileURL(e.s3, savedName, opts.URLTTLHours)
+		}
+		if err != nil {
+			e.failExport(ctx, status, fmt.Sprintf("save export failed: %v", err))
+		} else {
+			if opts.Delivery != nil {
+				status.enter(ExportDelivering)
+				if e.ws != nil {
+					_ = e.ws.NotifyExportProgress(ctx, userID, exportID, 95, "delivering")
+				}
+				e.deliverers.deliver(ctx, e.retry, status, opts.Delivery, fileName, data)
+			}
+			status.FileURL = &url
+			status.URLExpiresAt = urlExpires
+			status.URLTTLHours = opts.URLTTLHours
+			status.File = &savedName
+			status.Storage = storageBackend(e.s3, savedName)
+			status.SingleUse = opts.SingleUse
+			_ = linkExportFile(ctx, e.redis, status)
+			status.Progress = 100
+			status.enter(ExportReady)
+
+			e.saveFinalStatus(ctx, status)
+
+			if e.ws != nil {
+				_ = e.ws.NotifyExportProgress(ctx, userID, exportID, 100, "ready")
+				_ = e.ws.NotifyExportComplete(ctx, userID, exportID, url, fileName)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultFilenameTemplate keeps the historical "<type>_<YYYYMMDD_HHMMSS>.xlsx" names.
+const DefaultFilenameTemplate = "{type}_{timestamp}"
+
+const maxFilenameRunes = 150
+
+// filenameFields are the placeholder values of one export.
+type filenameFields struct {
+	Type         string
+	UserID       int64
+	Counterparty *string
+	DateFrom     *time.Time
+	DateTo       *time.Time
+	ExportID     string
+}
+
+var (
+	filenamePlaceholder  = regexp.MustCompile(`\{([a-z_]+)\}`)
+	filenamePlaceholders = []string{"type", "user", "counterparty", "date_from", "date_to", "export_id", "date", "timestamp"}
+)
+
+// ValidateFilenameTemplate rejects templates with unknown placeholders or that
+// can never produce a usable name.
+func ValidateFilenameTemplate(tpl string) error {
+	for _, m := range filenamePlaceholder.FindAllStringSubmatch(tpl, -1) {
+		if !slices.Contains(filenamePlaceholders, m[1]) {
+			return fmt.Errorf("unknown placeholder {%s}, allowed: {%s}", m[1], strings.Join(filenamePlaceholders, "}, {"))
+		}
+	}
+	if sanitizeFilename(expandFilenameTemplate(tpl, filenameFields{Type: "export"}, time.Now())) == "" {
+		return fmt.Errorf("template %q produces an empty file name", tpl)
+	}
+	return nil
+}
+
+// exportFilename returns the file name of a finished export: the sanitized
+// custom name if one was requested, otherwise the expanded template.
+func exportFilename(tpl string, opts ExportOptions, f filenameFields) string {
+	name := sanitizeFilename(opts.Filename)
+	if name == "" {
+		if tpl == "" {
+			tpl = DefaultFilenameTemplate
+		}
+		name = sanitizeFilename(expandFilenameTemplate(tpl, f, time.Now()))
+	}
+	if name == "" {
+		name = sanitizeFilename(expandFilenameTemplate(DefaultFilenameTemplate, f, time.Now()))
+	}
+
+	if ext := opts.fileExt(); !strings.EqualFold(filepath.Ext(name), ext) {
+		name += ext
+	}
+	return name
+}
+
+// archiveFilename turns the workbook name of an export into the name of the
+// zip archive holding several workbooks.
+func archiveFilename(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".zip"
+}
+
+func expandFilenameTemplate(tpl string, f filenameFields, now time.Time) string {
+	date := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+
+	return filenamePlaceholder.ReplaceAllStringFunc(tpl, func(m string) string {
+		switch m[1 : len(m)-1] {
+		case "type":
+			return f.Type
+		case "user":
+			return strconv.FormatInt(f.UserID, 10)
+		case "counterparty":
+			if f.Counterparty == nil || *f.Counterparty == "" {
+				return "all"
+			}
+			return *f.Counterparty
+		case "date_from":
+			return date(f.DateFrom)
+		case "date_to":
+			return date(f.DateTo)
+		case "export_id":
+			return strings.TrimPrefix(f.ExportID, "exports:")
+		case "date":
+			return now.Format("2006-01-02")
+		case "timestamp":
+			return now.Format("20060102_150405")
+		}
+		return m
+	})
+}
+
+// sanitizeFilename keeps letters (any script), digits and ".-()"; everything
+// else, including path separators, collapses into a single "_".
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+	replaced := false
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(".-()", r) {
+			b.WriteRune(r)
+			replaced = false
+			continue
+		}
+		if !replaced {
+			b.WriteByte('_')
+			replaced = true
+		}
+	}
+
+	out := strings.Trim(b.String(), "._-")
+	if runes := []rune(out); len(runes) > maxFilenameRunes {
+		out = strings.Trim(string(runes[:maxFilenameRunes]), "._-")
+	}
+	return out
+}
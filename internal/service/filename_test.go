@@ -0,0 +1,64 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Отчёт по долгам (май)", "Отчёт_по_долгам_(май)"},
+		{"../../etc/passwd", "etc_passwd"},
+		{"a  /\\:*?b", "a_b"},
+		{"  ...  ", ""},
+		{strings.Repeat("я", 200), strings.Repeat("я", maxFilenameRunes)},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandFilenameTemplate(t *testing.T) {
+	now := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cp := "42"
+	f := filenameFields{Type: "payments", UserID: 7, Counterparty: &cp, DateFrom: &from, ExportID: "exports:abc"}
+
+	got := expandFilenameTemplate("{type}_{user}_{counterparty}_{date_from}-{date_to}_{export_id}_{timestamp}", f, now)
+	if want := "payments_7_42_2025-01-01-_abc_20250506_070809"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportFilename(t *testing.T) {
+	f := filenameFields{Type: "debts"}
+
+	if got := exportFilename("", ExportOptions{Filename: "Реестр 5.xlsx"}, f); got != "Реестр_5.xlsx" {
+		t.Errorf("custom name: got %q", got)
+	}
+	if got := exportFilename("{counterparty}_{user}", ExportOptions{}, f); got != "all_0.xlsx" {
+		t.Errorf("template: got %q", got)
+	}
+	if got := exportFilename("{date_from}", ExportOptions{Filename: "///"}, f); !strings.HasPrefix(got, "debts_") {
+		t.Errorf("empty name must fall back to the default template, got %q", got)
+	}
+}
+
+func TestValidateFilenameTemplate(t *testing.T) {
+	if err := ValidateFilenameTemplate(DefaultFilenameTemplate); err != nil {
+		t.Fatalf("default template: %v", err)
+	}
+	if err := ValidateFilenameTemplate("{type}"); err != nil {
+		t.Fatalf("type-only template: %v", err)
+	}
+	if err := ValidateFilenameTemplate("{type}_{registry}"); err == nil {
+		t.Fatal("expected error for unknown placeholder")
+	}
+	if err := ValidateFilenameTemplate("{date_from}"); err == nil {
+		t.Fatal("expected error for template that can be empty")
+	}
+}
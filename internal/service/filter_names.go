@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"debtster-export/internal/filter"
+	"debtster-export/internal/requestid"
+)
+
+// FilterNameDirectory looks up the names of the rows id filters refer to.
+// Implemented by *repository.NameRepository.
+type FilterNameDirectory interface {
+	Name(ctx context.Context, dict string, id any) (string, error)
+}
+
+// SetFilterNames makes the filters map of debts exports and the aging report
+// carry the names of the filtered status, counterparty, user etc.
+func (s *DebtService) SetFilterNames(d FilterNameDirectory) {
+	s.names = d
+}
+
+// SetFilterNames is DebtService.SetFilterNames for actions exports and the
+// daily report.
+func (s *ActionService) SetFilterNames(d FilterNameDirectory) {
+	s.names = d
+}
+
+// SetFilterNames is DebtService.SetFilterNames for payments exports.
+func (s *PaymentService) SetFilterNames(d FilterNameDirectory) {
+	s.names = d
+}
+
+// addFilterNames puts the name of every id of refs into the filters map next
+// to it, "status_id" as "status_name", so the export history can show
+// "Статус: Судебный" without lookups of its own. The names are taken when the
+// export starts. Unknown ids and failed lookups go without a name: the export
+// does not depend on them.
+func addFilterNames(ctx context.Context, d FilterNameDirectory, m map[string]any, refs []filter.Ref) {
+	if d == nil {
+		return
+	}
+	for _, ref := range refs {
+		name, err := d.Name(ctx, ref.Dict, ref.ID)
+		if err != nil {
+			requestid.Logf(ctx, "filter names: %s %v: %v", ref.Filter, ref.ID, err)
+			continue
+		}
+		if name != "" {
+			m[strings.TrimSuffix(ref.Filter, "_id")+"_name"] = name
+		}
+	}
+}
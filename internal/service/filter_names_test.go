@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"debtster-export/internal/repository"
+)
+
+type fakeFilterNames map[string]string
+
+func (f fakeFilterNames) Name(_ context.Context, dict string, id any) (string, error) {
+	key := fmt.Sprintf("%s/%v", dict, id)
+	if key == "counterparties/broken" {
+		return "", errors.New("db down")
+	}
+	return f[key], nil
+}
+
+func TestBuildDebtsFiltersMap_Names(t *testing.T) {
+	status, user, counterparty := int64(5), int64(404), "cp-1"
+	filter := repository.DebtsFilter{StatusID: &status, UserID: &user, CounterpartyID: &counterparty}
+
+	s := &DebtService{}
+	m := s.buildDebtsFiltersMap(context.Background(), filter, []string{"number"}, DebtsExportOptions{})
+	if _, ok := m["status_name"]; ok {
+		t.Fatalf("names without a directory: %v", m)
+	}
+
+	s.SetFilterNames(fakeFilterNames{
+		"debt_statuses/5":     "Судебный",
+		"counterparties/cp-1": "Банк X",
+	})
+	m = s.buildDebtsFiltersMap(context.Background(), filter, []string{"number"}, DebtsExportOptions{})
+	// id остаётся, имя — рядом; у несуществующего пользователя имени нет
+	if m["status_id"] != int64(5) || m["status_name"] != "Судебный" || m["counterparty_name"] != "Банк X" {
+		t.Fatalf("filters = %v", m)
+	}
+	if _, ok := m["user_name"]; ok {
+		t.Fatalf("name of an unknown user: %v", m)
+	}
+
+	// ошибка справочника не мешает выгрузке
+	broken := "broken"
+	filter.CounterpartyID = &broken
+	m = s.buildDebtsFiltersMap(context.Background(), filter, nil, DebtsExportOptions{})
+	if _, ok := m["counterparty_name"]; ok || m["status_name"] != "Судебный" {
+		t.Fatalf("filters after a failed lookup = %v", m)
+	}
+}
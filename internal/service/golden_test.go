@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service/mocks"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/mock/gomock"
+)
+
+// go test ./internal/service -run Golden -update перезаписывает эталоны
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata/golden")
+
+// goldenDeps are the dependencies every exporter needs in a golden test; the
+// generated workbook ends up in file.
+type goldenDeps struct {
+	cache   *mocks.MockCache
+	storage *mocks.MockFileStorage
+	ws      *mocks.MockNotifier
+	file    []byte
+}
+
+func newGoldenDeps(t *testing.T, ctrl *gomock.Controller) *goldenDeps {
+	t.Helper()
+
+	d := &goldenDeps{
+		cache:   mocks.NewMockCache(ctrl),
+		storage: mocks.NewMockFileStorage(ctrl),
+		ws:      mocks.NewMockNotifier(ctrl),
+	}
+	d.cache.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	d.cache.EXPECT().SAdd(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	d.ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	d.ws.EXPECT().NotifyExportComplete(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	d.ws.EXPECT().NotifyExportFailed(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ int64, _ string, errMsg string) error {
+			t.Fatalf("export failed: %s", errMsg)
+			return nil
+		}).AnyTimes()
+	d.storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, name string, data []byte) (string, error) {
+			d.file = data
+			return name, nil
+		})
+	d.storage.EXPECT().SetOwner(gomock.Any(), int64(7), false).Return(nil)
+	d.storage.EXPECT().GetURL(gomock.Any()).DoAndReturn(func(name string) string { return "/files/" + name })
+	return d
+}
+
+// renderWorkbook dumps every sheet as text, one line per non-empty cell:
+// coordinate, cell type, value and, when set, style id and hyperlink. The info
+// sheet changes with every run and is covered by TestWriteInfoSheet.
+func renderWorkbook(t *testing.T, data []byte) string {
+	t.Helper()
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("open workbook: %v", err)
+	}
+	defer f.Close()
+
+	var out strings.Builder
+	for _, sheet := range f.GetSheetList() {
+		if sheet == infoSheet {
+			continue
+		}
+		fmt.Fprintf(&out, "== %s\n", sheet)
+
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatalf("read %s: %v", sheet, err)
+		}
+		for r, row := range rows {
+			for c := range row {
+				cell, _ := excelize.CoordinatesToCellName(c+1, r+1)
+				value, _ := f.GetCellValue(sheet, cell)
+				if value == "" {
+					continue
+				}
+				typ, _ := f.GetCellType(sheet, cell)
+				fmt.Fprintf(&out, "%s\t%s\t%q", cell, cellTypeName(typ), value)
+
+				if style, _ := f.GetCellStyle(sheet, cell); style != 0 {
+					fmt.Fprintf(&out, "\tstyle=%d", style)
+				}
+				if ok, link, _ := f.GetCellHyperLink(sheet, cell); ok {
+					fmt.Fprintf(&out, "\tlink=%s", link)
+				}
+				out.WriteByte('\n')
+			}
+		}
+	}
+	return out.String()
+}
+
+func cellTypeName(t excelize.CellType) string {
+	switch t {
+	case excelize.CellTypeBool:
+		return "bool"
+	case excelize.CellTypeNumber, excelize.CellTypeUnset:
+		// numbers are written without an explicit type attribute
+		return "number"
+	case excelize.CellTypeDate:
+		return "date"
+	case excelize.CellTypeFormula:
+		return "formula"
+	case excelize.CellTypeInlineString, excelize.CellTypeSharedString:
+		return "string"
+	default:
+		return fmt.Sprintf("type(%d)", t)
+	}
+}
+
+// assertGolden compares got with testdata/golden/<name>.golden.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (run with -update to create it): %v", err)
+	}
+	if string(want) == got {
+		return
+	}
+
+	wantLines, gotLines := strings.Split(string(want), "\n"), strings.Split(got, "\n")
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			t.Fatalf("%s differs at line %d:\nwant: %s\ngot:  %s\n(run with -update if the change is intended)", path, i+1, w, g)
+		}
+	}
+}
+
+// allColumns returns every key of a column registry in a stable order.
+func allColumns[C any](registry map[string]C) []string {
+	keys := make([]string, 0, len(registry))
+	for k := range registry {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func ptr[T any](v T) *T { return &v }
+
+var goldenTime = time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+
+func goldenStatus(exportType string) *ExportStatus {
+	return newExportStatus(context.Background(), exportType, 7, nil, nil, firstAttempt)
+}
+
+func TestGoldenDebts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deps := newGoldenDeps(t, ctrl)
+	repo := mocks.NewMockDebtRepository(ctrl)
+
+	debts := []domain.Debt{
+		{
+			Number:                     "D-0001",
+			StartDate:                  ptr(goldenTime),
+			EndDate:                    ptr(goldenTime.AddDate(1, 0, 0)),
+			Filial:                     ptr("Алматы"),
+			ProductName:                ptr("Потребительский кредит"),
+			AmountCurrency:             ptr("KZT"),
+			AmountActualDebt:           150000.5,
+			AmountPurchasedLoan:        120000,
+			InitAmountActualDebt:       160000,
+			AmountCredit:               200000,
+			AmountMainDebt:             ptr(110000.25),
+			AmountFine:                 1500,
+			AmountAccrual:              3200.75,
+			AmountGovernmentDuty:       500,
+			AmountRepresentationExp:    700,
+			AmountNotaryFees:           300,
+			AmountPostage:              120,
+			TransferDecision:           ptr("Передан в суд"),
+			PresenceSolidarity:         true,
+			GovernmentDutyPaid:         true,
+			RepresentationExpensesPaid: true,
+			LateDueDate:                ptr(goldenTime.AddDate(0, 2, 0)),
+			NextContact:                ptr(goldenTime.Add(48 * time.Hour)),
+			LastContact:                ptr(goldenTime.Add(-24 * time.Hour)),
+			AdditionalData:             []byte(`{"source":"import"}`),
+			RegistryNumber:             ptr("R-001"),
+			RegistryDate:               ptr(goldenTime.AddDate(0, -1, 0)),
+			UserUsername:               ptr("a.saparova"),
+			UserDepartments:            ptr("Soft collection"),
+			StatusName:                 ptr("В работе"),
+			DebtorLastName:             ptr("Иванов"),
+			DebtorFirstName:            ptr("Иван"),
+			DebtorMiddleName:           ptr("Иванович"),
+			DebtorIIN:                  ptr("900101300001"),
+			CounterpartyName:           ptr("Test Bank"),
+			LastPaymentDate:            ptr(goldenTime.AddDate(0, 3, 0)),
+			TotalPaid:                  25000,
+			PaidThisMonth:              5000,
+			CourtCaseNumber:            ptr("2-1234/2025"),
+			CourtName:                  ptr("Медеуский районный суд г. Алматы"),
+			CourtStage:                 ptr("enforcement"),
+			EnforcementNumber:          ptr("ИП-0042"),
+			EnforcementAgent:           ptr("Касымов Н."),
+			EnforcementStartedAt:       ptr(goldenTime.AddDate(0, 4, 0)),
+			DebtorPhones:               []string{"+77010000001", "+77270000001"},
+			DebtorAddresses:            []string{"г. Алматы, ул. Абая, 1", "г. Алматы, ул. Сатпаева, 2"},
+		},
+		// почти пустая строка: проверяем значения по умолчанию для nil-полей
+		{Number: "D-0002"},
+	}
+	guarantors := []domain.Guarantor{
+		{DebtNumber: "D-0001", Type: ptr("guarantor"), LastName: ptr("Сидоров"), FirstName: ptr("Сидор"), IIN: ptr("800303300003"), Phone: ptr("+77010000000")},
+	}
+
+	repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(debts, nil)
+	repo.EXPECT().ListGuarantors(gomock.Any(), gomock.Any()).Return(guarantors, nil)
+
+	s := NewDebtService(repo, deps.cache, deps.storage, deps.ws)
+	s.runDebtsExport(context.Background(), goldenStatus("debts"), allColumns(debtColumns), repository.DebtsFilter{}, DebtsExportOptions{IncludeGuarantors: true})
+
+	assertGolden(t, "debts", renderWorkbook(t, deps.file))
+}
+
+func TestGoldenUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deps := newGoldenDeps(t, ctrl)
+	repo := mocks.NewMockUserRepository(ctrl)
+
+	users := []domain.User{
+		{
+			FirstName:              ptr("Айгуль"),
+			LastName:               ptr("Сапарова"),
+			MiddleName:             ptr("Маратовна"),
+			Username:               ptr("a.saparova"),
+			Email:                  ptr("a.saparova@example.com"),
+			Phone:                  ptr("+77010000001"),
+			Departments:            ptr("Hard collection, Soft collection"),
+			AssignedDebtsCount:     42,
+			AssignedDebtsAmount:    1250000.5,
+			ActionsLast30DaysCount: 17,
+		},
+		{Username: ptr("e.akhmetov")},
+	}
+	repo.EXPECT().List(gomock.Any()).Return(users, nil)
+
+	s := NewUserService(repo, deps.cache, deps.storage, deps.ws)
+	s.runUsersExport(context.Background(), goldenStatus("users"), allColumns(userColumns), ExportOptions{})
+
+	assertGolden(t, "users", renderWorkbook(t, deps.file))
+}
+
+func TestGoldenActions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deps := newGoldenDeps(t, ctrl)
+	repo := mocks.NewMockActionRepository(ctrl)
+	presigner := mocks.NewMockRecordingPresigner(ctrl)
+
+	actions := []domain.Action{
+		{
+			DebtID:                       "44444444-4444-4444-4444-444444444441",
+			UserID:                       1,
+			DebtStatusID:                 ptr(int64(2)),
+			NextContact:                  ptr(goldenTime.Add(72 * time.Hour)),
+			Type:                         "call",
+			Comment:                      "Обещал оплатить до конца месяца",
+			Payload:                      []byte(`{"date_promised_payment":"2025-03-31","amount_promised_payment":5000,"recording_url":"calls/rec-1.mp3"}`),
+			CreatedAt:                    ptr(goldenTime),
+			UpdatedAt:                    ptr(goldenTime.Add(time.Hour)),
+			DebtNumber:                   ptr("D-0001"),
+			CounterpartyName:             ptr("Test Bank"),
+			DebtStatusName:               ptr("Обещание оплаты"),
+			UserFirstName:                ptr("Айгуль"),
+			UserLastName:                 ptr("Сапарова"),
+			UserDepartments:              ptr("Soft collection"),
+			DebtorFirstName:              ptr("Иван"),
+			DebtorLastName:               ptr("Иванов"),
+			PayloadDatePromisedPayment:   ptr("2025-03-31"),
+			PayloadAmountPromisedPayment: ptr(5000.0),
+			PayloadRecordingURL:          ptr("calls/rec-1.mp3"),
+		},
+		{
+			DebtID:              "44444444-4444-4444-4444-444444444442",
+			UserID:              2,
+			Type:                "sms",
+			PayloadRecordingURL: ptr("https://pbx.example.com/rec-2.mp3"),
+			DeletedAt:           ptr(goldenTime),
+		},
+	}
+	repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(actions, nil)
+	presigner.EXPECT().PresignGet(gomock.Any(), "calls/rec-1.mp3").Return("https://s3.example.com/recordings/calls/rec-1.mp3?X-Amz-Signature=test", nil)
+
+	s := NewActionService(repo, deps.cache, deps.storage, deps.ws, presigner)
+	s.runActionsExport(context.Background(), goldenStatus("actions"), allColumns(actionColumns), repository.ActionsFilter{}, ExportOptions{})
+
+	assertGolden(t, "actions", renderWorkbook(t, deps.file))
+}
+
+func TestGoldenPayments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deps := newGoldenDeps(t, ctrl)
+	repo := mocks.NewMockPaymentRepository(ctrl)
+
+	payments := []domain.Payment{
+		{
+			ID:                           "55555555-5555-5555-5555-555555555551",
+			DebtID:                       "44444444-4444-4444-4444-444444444441",
+			UserID:                       ptr(int64(1)),
+			Amount:                       5000,
+			AmountAfterSubtraction:       4500.5,
+			AmountGovernmentDuty:         100,
+			AmountRepresentationExpenses: 200,
+			AmountNotaryFees:             50,
+			AmountPostage:                25,
+			Confirmed:                    true,
+			PaymentDate:                  ptr(goldenTime),
+			CreatedAt:                    ptr(goldenTime),
+			UpdatedAt:                    ptr(goldenTime.Add(time.Hour)),
+			AmountAccountsReceivable:     10,
+			AmountMainDebt:               4000,
+			AmountAccrual:                300,
+			AmountFine:                   99.99,
+		},
+		{
+			ID:     "55555555-5555-5555-5555-555555555552",
+			DebtID: "44444444-4444-4444-4444-444444444442",
+		},
+	}
+	repo.EXPECT().List(gomock.Any(), gomock.Any()).Return(payments, nil)
+
+	s := NewPaymentService(repo, deps.cache, deps.storage, deps.ws)
+	s.runPaymentsExport(context.Background(), goldenStatus("payments"), allColumns(paymentColumns), repository.PaymentsFilter{}, PaymentsExportOptions{})
+
+	assertGolden(t, "payments", renderWorkbook(t, deps.file))
+}
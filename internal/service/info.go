@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"debtster-export/internal/version"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// infoSheet is the hidden sheet describing how a workbook was generated, so a
+// file forwarded by email can be traced back to its export.
+const infoSheet = "Info"
+
+// writeInfoSheet adds the hidden infoSheet: the export id and type, the user,
+// the generation time, the number of data rows, the columns (fields), the
+// build version and the filters of status, one per row.
+func writeInfoSheet(f *excelize.File, status *ExportStatus, fields []string, rows int, now time.Time) error {
+	if _, err := f.NewSheet(infoSheet); err != nil {
+		return err
+	}
+
+	values := [][]any{
+		{"Выгрузка", status.Key},
+		{"Тип", status.Type},
+		{"Пользователь", status.UserID},
+		{"Сформирована", now.Format("2006-01-02 15:04:05 -07:00")},
+		{"Строк", rows},
+		{"Поля", strings.Join(fields, ", ")},
+		{"Версия", version.String()},
+	}
+	if status.RequestID != "" {
+		values = append(values, []any{"Запрос", status.RequestID})
+	}
+
+	if filters, ok := status.Filters.(map[string]any); ok {
+		keys := make([]string, 0, len(filters))
+		for k := range filters {
+			if k != "fields" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		values = append(values, []any{}, []any{"Фильтры"})
+		for _, k := range keys {
+			values = append(values, []any{k, infoValue(filters[k])})
+		}
+	}
+
+	for i, row := range values {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow(infoSheet, cell, &row); err != nil {
+			return err
+		}
+	}
+	_ = f.SetColWidth(infoSheet, "A", "A", 20)
+	_ = f.SetColWidth(infoSheet, "B", "B", 60)
+
+	return f.SetSheetVisible(infoSheet, false)
+}
+
+// infoValue renders a filter value as text; unset filters stay empty.
+func infoValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(raw)
+}
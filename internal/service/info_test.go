@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestWriteInfoSheet(t *testing.T) {
+	f := excelize.NewFile()
+	status := &ExportStatus{
+		Key:    "exports:1",
+		Type:   "debts",
+		UserID: 7,
+		Filters: map[string]any{
+			"fields":          []string{"number"},
+			"counterparty_id": int64(3),
+			"status_id":       nil,
+		},
+	}
+	now := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+
+	if err := writeInfoSheet(f, status, []string{"Номер", "Сумма"}, 42, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if visible, _ := f.GetSheetVisible(infoSheet); visible {
+		t.Fatal("info sheet must be hidden")
+	}
+	if f.GetActiveSheetIndex() != 0 {
+		t.Fatal("info sheet must not become active")
+	}
+
+	rows, _ := f.GetRows(infoSheet)
+	got := map[string]string{}
+	for _, row := range rows {
+		if len(row) == 2 {
+			got[row[0]] = row[1]
+		}
+	}
+	want := map[string]string{
+		"Выгрузка":        "exports:1",
+		"Тип":             "debts",
+		"Пользователь":    "7",
+		"Сформирована":    "2025-03-01 10:30:00 +00:00",
+		"Строк":           "42",
+		"Поля":            "Номер, Сумма",
+		"counterparty_id": "3",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+	// поля не дублируются среди фильтров, пустые фильтры остаются пустыми
+	if _, ok := got["fields"]; ok {
+		t.Error("fields must not be listed among filters")
+	}
+	if got["Версия"] == "" {
+		t.Error("version is empty")
+	}
+}
+
+func TestRenderTemplate_KeepsInfoSheet(t *testing.T) {
+	src := excelize.NewFile()
+	_ = src.SetSheetRow("Sheet1", "A1", &[]any{"Номер"})
+	if err := writeInfoSheet(src, &ExportStatus{Key: "exports:1"}, []string{"Номер"}, 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := src.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := renderTemplate(testTemplate(t), buf.Bytes(), templateVars{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := f.GetCellValue(infoSheet, "B1"); v != "exports:1" {
+		t.Fatalf("info sheet not carried over: %q", v)
+	}
+	if visible, _ := f.GetSheetVisible(infoSheet); visible {
+		t.Fatal("info sheet must stay hidden")
+	}
+}
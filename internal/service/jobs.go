@@ -0,0 +1,480 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"debtster-export/internal/reporting"
+	"debtster-export/internal/requestid"
+)
+
+var (
+	// ErrExportCancelled is the cancellation cause of an export cancelled on request.
+	ErrExportCancelled = errors.New("export cancelled")
+	// ErrShuttingDown is the cancellation cause of exports interrupted by shutdown.
+	ErrShuttingDown = errors.New("export interrupted by service shutdown")
+
+	errExportStalled = errors.New("export stalled")
+	errExportDeleted = errors.New("export deleted")
+	errLeaseLost     = errors.New("export taken over by another instance")
+)
+
+// JobRunner runs export jobs on a bounded worker pool and keeps track of the
+// jobs of this process, so stalled ones can be detected and their slot freed.
+// Export types can be given lanes: slots of their own, so long exports of one
+// type do not hold up the others.
+type JobRunner struct {
+	mu   sync.Mutex
+	jobs map[string]*runningJob
+	wg   sync.WaitGroup
+
+	// shared is the pool of types without a lane; waiters are queued jobs of
+	// all pools in FIFO order
+	shared  *workerPool
+	lanes   map[string]*workerPool
+	waiters []*runningJob
+
+	stallAfter time.Duration
+}
+
+// workerPool counts the slots of the shared pool or of a lane; limit <= 0
+// means unlimited.
+type workerPool struct {
+	limit int
+	busy  int
+}
+
+func (p *workerPool) free() bool {
+	return p.limit <= 0 || p.busy < p.limit
+}
+
+type runningJob struct {
+	exportType string
+	// pool is the one the job took its slot from
+	pool      *workerPool
+	cancel    context.CancelCauseFunc
+	fail      func(ctx context.Context, errStr string)
+	failCtx   context.Context
+	started   bool
+	queuedAt  time.Time
+	startedAt time.Time
+	heartbeat time.Time
+	released  bool
+	// granted is closed when a queued job gets a worker slot
+	granted chan struct{}
+}
+
+// JobInfo is a point-in-time view of a job for diagnostics.
+type JobInfo struct {
+	Key       string     `json:"key"`
+	Type      string     `json:"type"`
+	State     string     `json:"state"`
+	QueuedAt  time.Time  `json:"queued_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Heartbeat *time.Time `json:"heartbeat_at,omitempty"`
+}
+
+// NewJobRunner creates a runner executing at most workers jobs at once;
+// workers <= 0 means no limit.
+func NewJobRunner(workers int) *JobRunner {
+	return &JobRunner{jobs: map[string]*runningJob{}, shared: &workerPool{limit: workers}, lanes: map[string]*workerPool{}}
+}
+
+// SetWorkers resizes the pool; shrinking never interrupts running jobs, it
+// only delays queued ones until enough slots are free.
+func (r *JobRunner) SetWorkers(workers int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.shared.limit = workers
+	r.dispatchLocked()
+}
+
+// SetLanes gives export types slots of their own (type -> slots); the jobs of
+// other types share the pool. Like SetWorkers it never interrupts running
+// jobs: a job keeps its slot in the pool it started in.
+func (r *JobRunner) SetLanes(lanes map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := make(map[string]*workerPool, len(lanes))
+	for exportType, slots := range lanes {
+		pool, ok := r.lanes[exportType]
+		if !ok {
+			pool = &workerPool{}
+		}
+		pool.limit = slots
+		next[exportType] = pool
+	}
+	r.lanes = next
+	r.dispatchLocked()
+}
+
+// SetStallTimeout changes how long a job may go without a heartbeat; 0 disables the watchdog.
+func (r *JobRunner) SetStallTimeout(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stallAfter = d
+}
+
+// StallTimeout returns the current stall timeout.
+func (r *JobRunner) StallTimeout() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stallAfter
+}
+
+// Go schedules fn for the export key of exportType, in the lane of the type if
+// it has one. fail is used by the watchdog to mark the export as failed if it
+// stops reporting progress. A nil runner just starts a goroutine.
+func (r *JobRunner) Go(ctx context.Context, key, exportType string, fail func(ctx context.Context, errStr string), fn func(ctx context.Context)) {
+	tags := map[string]string{"export_id": key, "request_id": requestid.FromContext(ctx)}
+	onPanic := func(rec any) {
+		if fail != nil {
+			fail(ctx, fmt.Sprintf("internal error: %v", rec))
+		}
+	}
+
+	if r == nil {
+		go func() {
+			defer reporting.Recover(ctx, tags, onPanic)
+			fn(ctx)
+		}()
+		return
+	}
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	job := &runningJob{exportType: exportType, cancel: cancel, fail: fail, failCtx: ctx, queuedAt: time.Now(), granted: make(chan struct{})}
+
+	r.mu.Lock()
+	r.jobs[key] = job
+	r.waiters = append(r.waiters, job)
+	r.dispatchLocked()
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.finish(key, job)
+
+		select {
+		case <-job.granted:
+		case <-jobCtx.Done():
+			if r.dequeue(job) {
+				// cancelled while queued
+				if fail != nil {
+					fail(jobCtx, jobCtx.Err().Error())
+				}
+				return
+			}
+			// the slot was granted at the same moment; fn sees the cancelled context
+		}
+
+		defer reporting.Recover(ctx, tags, onPanic)
+		requestid.Logf(ctx, "[JOBS] export %s started", key)
+		fn(jobCtx)
+		requestid.Logf(ctx, "[JOBS] export %s finished", key)
+	}()
+}
+
+// Beat records progress of the export key.
+func (r *JobRunner) Beat(key string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[key]; ok {
+		job.heartbeat = time.Now()
+	}
+}
+
+func (r *JobRunner) finish(key string, job *runningJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.cancel(nil)
+	r.releaseLocked(job)
+	if r.jobs[key] == job {
+		delete(r.jobs, key)
+	}
+}
+
+// Jobs lists the queued and running jobs of this process.
+func (r *JobRunner) Jobs() []JobInfo {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]JobInfo, 0, len(r.jobs))
+	for key, job := range r.jobs {
+		info := JobInfo{Key: key, Type: job.exportType, State: "queued", QueuedAt: job.queuedAt}
+		if job.started {
+			startedAt, heartbeat := job.startedAt, job.heartbeat
+			info.State = "running"
+			info.StartedAt = &startedAt
+			info.Heartbeat = &heartbeat
+		}
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].QueuedAt.Before(out[j].QueuedAt) })
+	return out
+}
+
+// Workers returns the shared pool size and the number of its busy slots; size
+// 0 means unlimited.
+func (r *JobRunner) Workers() (size, busy int) {
+	if r == nil {
+		return 0, 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.shared.limit, r.shared.busy
+}
+
+// LaneInfo is the state of the lane of an export type.
+type LaneInfo struct {
+	Type string `json:"type"`
+	Size int    `json:"size"`
+	Busy int    `json:"busy"`
+}
+
+// Lanes lists the configured lanes, ordered by type.
+func (r *JobRunner) Lanes() []LaneInfo {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]LaneInfo, 0, len(r.lanes))
+	for exportType, pool := range r.lanes {
+		out = append(out, LaneInfo{Type: exportType, Size: pool.limit, Busy: pool.busy})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// Cancel stops the export key if it is queued or running in this process.
+func (r *JobRunner) Cancel(key string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[key]
+	if ok {
+		job.cancel(ErrExportCancelled)
+	}
+	return ok
+}
+
+// Delete stops the export key like Cancel, but the job records nothing: the
+// export is being deleted.
+func (r *JobRunner) Delete(key string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[key]
+	if ok {
+		job.cancel(errExportDeleted)
+	}
+	return ok
+}
+
+// abandon stops the export key like Delete: a queued export whose lease was
+// lost runs on another instance now, which records its outcome.
+func (r *JobRunner) abandon(key string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[key]
+	if ok {
+		job.cancel(errLeaseLost)
+	}
+	return ok
+}
+
+// Shutdown cancels all jobs and waits for them to return until ctx is done.
+func (r *JobRunner) Shutdown(ctx context.Context) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	for _, job := range r.jobs {
+		job.cancel(ErrShuttingDown)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("[JOBS] shutdown timed out, some exports are still running")
+	}
+}
+
+// dispatchLocked hands free slots to queued jobs. A job whose pool is full
+// does not hold up the jobs queued after it in other pools.
+func (r *JobRunner) dispatchLocked() {
+	waiting := r.waiters[:0]
+	for _, job := range r.waiters {
+		pool := r.poolLocked(job.exportType)
+		if !pool.free() {
+			waiting = append(waiting, job)
+			continue
+		}
+
+		pool.busy++
+		job.pool = pool
+		job.started = true
+		job.startedAt = time.Now()
+		job.heartbeat = job.startedAt
+		close(job.granted)
+	}
+	clear(r.waiters[len(waiting):])
+	r.waiters = waiting
+}
+
+func (r *JobRunner) poolLocked(exportType string) *workerPool {
+	if pool, ok := r.lanes[exportType]; ok {
+		return pool
+	}
+	return r.shared
+}
+
+// dequeue removes a job that is still waiting for a slot; false means it already got one.
+func (r *JobRunner) dequeue(job *runningJob) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, w := range r.waiters {
+		if w == job {
+			r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *JobRunner) releaseLocked(job *runningJob) {
+	if job.released || !job.started {
+		return
+	}
+	job.released = true
+	job.pool.busy--
+	r.dispatchLocked()
+}
+
+// Running reports whether the export key is queued or running in this process.
+func (r *JobRunner) Running(key string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.jobs[key]
+	return ok
+}
+
+// Idle reports whether no job waits for a slot, so a job taken from a shared
+// queue would not just wait here.
+func (r *JobRunner) Idle() bool {
+	if r == nil {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.waiters) == 0
+}
+
+// ReapStalled is the watchdog pass: a job without a heartbeat for the stall
+// timeout is cancelled, marked failed and its slot is freed. It returns how
+// many jobs were reaped.
+func (r *JobRunner) ReapStalled() int {
+	if r == nil {
+		return 0
+	}
+
+	type stalled struct {
+		key string
+		job *runningJob
+	}
+	var found []stalled
+
+	r.mu.Lock()
+	stallAfter := r.stallAfter
+	if stallAfter <= 0 {
+		r.mu.Unlock()
+		return 0
+	}
+	now := time.Now()
+	for key, job := range r.jobs {
+		if !job.started || job.released || now.Sub(job.heartbeat) < stallAfter {
+			continue
+		}
+		job.cancel(errExportStalled)
+		r.releaseLocked(job)
+		delete(r.jobs, key)
+		found = append(found, stalled{key: key, job: job})
+	}
+	r.mu.Unlock()
+
+	for _, st := range found {
+		requestid.Logf(st.job.failCtx, "[WATCHDOG] export %s has no progress for %s, marking failed", st.key, stallAfter)
+		if st.job.fail != nil {
+			st.job.fail(st.job.failCtx, fmt.Sprintf("export stalled: no progress for %s", stallAfter))
+		}
+	}
+	return len(found)
+}
+
+// abortReason describes why the job context was cancelled; report is false
+// when the failure was already recorded by the watchdog, the export is being
+// deleted or another instance took it over.
+func abortReason(ctx context.Context) (reason string, report bool) {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errExportStalled) || errors.Is(cause, errExportDeleted) || errors.Is(cause, errLeaseLost) {
+		return "", false
+	}
+	if cause == nil {
+		cause = ctx.Err()
+	}
+	return cause.Error(), true
+}
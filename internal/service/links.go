@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// LinkTemplates are URL templates of Debtster pages that exported cells link
+// back to, e.g. "https://crm.example.com/debts/{debt_id}". An empty template
+// disables its links.
+type LinkTemplates struct {
+	// Debt accepts the {debt_id} and {number} placeholders.
+	Debt string
+}
+
+var debtLinkPlaceholders = []string{"{debt_id}", "{number}"}
+
+// ValidateLinkTemplates rejects templates that are not absolute http(s) URLs
+// or do not identify the page with a placeholder.
+func ValidateLinkTemplates(t LinkTemplates) error {
+	if t.Debt == "" {
+		return nil
+	}
+	if !isAbsoluteURL(t.Debt) {
+		return fmt.Errorf("debt link template %q must be an absolute http(s) URL", t.Debt)
+	}
+	for _, p := range debtLinkPlaceholders {
+		if strings.Contains(t.Debt, p) {
+			return nil
+		}
+	}
+	return fmt.Errorf("debt link template %q must contain %s", t.Debt, strings.Join(debtLinkPlaceholders, " or "))
+}
+
+// debt returns the link to the CRM page of a debt; empty when links are off or
+// the template needs a value the row does not have.
+func (t LinkTemplates) debt(id, number string) string {
+	if t.Debt == "" {
+		return ""
+	}
+	if (id == "" && strings.Contains(t.Debt, "{debt_id}")) || (number == "" && strings.Contains(t.Debt, "{number}")) {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{debt_id}", url.PathEscape(id),
+		"{number}", url.PathEscape(number),
+	).Replace(t.Debt)
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+
+	"debtster-export/internal/domain"
+)
+
+func TestValidateLinkTemplates(t *testing.T) {
+	valid := []string{"", "https://crm.example.com/debts/{debt_id}", "http://crm/search?number={number}"}
+	for _, tpl := range valid {
+		if err := ValidateLinkTemplates(LinkTemplates{Debt: tpl}); err != nil {
+			t.Errorf("%q: unexpected error %v", tpl, err)
+		}
+	}
+
+	invalid := []string{"/debts/{debt_id}", "https://crm.example.com/debts", "javascript:{debt_id}"}
+	for _, tpl := range invalid {
+		if err := ValidateLinkTemplates(LinkTemplates{Debt: tpl}); err == nil {
+			t.Errorf("%q: expected error", tpl)
+		}
+	}
+}
+
+func TestLinkTemplates_Debt(t *testing.T) {
+	links := LinkTemplates{Debt: "https://crm.example.com/debts/{debt_id}?n={number}"}
+
+	if got := links.debt("42", "D/1 2"); got != "https://crm.example.com/debts/42?n=D%2F1%202" {
+		t.Fatalf("unexpected link %q", got)
+	}
+	// без значения для плейсхолдера ссылку не ставим
+	if got := links.debt("", "D-1"); got != "" {
+		t.Fatalf("expected no link without id, got %q", got)
+	}
+	if got := (LinkTemplates{}).debt("42", "D-1"); got != "" {
+		t.Fatalf("expected no link when disabled, got %q", got)
+	}
+}
+
+func TestWriteDebtsSheet_Links(t *testing.T) {
+	links := LinkTemplates{Debt: "https://crm.example.com/debts/{debt_id}"}
+	cols := []DebtColumn{debtColumns["number"], debtColumns["debtor.iin"]}
+
+	f := newDebtsWorkbook(1)
+	err := writeDebtsSheet(f, cols, links, []domain.Debt{{ID: "42", Number: "D-0001"}}, func(int) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, target, err := f.GetCellHyperLink("Debts", "A2")
+	if err != nil || !ok || target != "https://crm.example.com/debts/42" {
+		t.Fatalf("number cell link: %v %q %v", ok, target, err)
+	}
+	if ok, _, _ := f.GetCellHyperLink("Debts", "B2"); ok {
+		t.Fatal("only linked columns get hyperlinks")
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"debtster-export/internal/tenant"
+)
+
+// maintenanceKey holds the maintenance flag; it is shared by all tenants.
+const maintenanceKey = "export_maintenance"
+
+// DefaultMaintenanceMessage is shown when maintenance is enabled without a message.
+const DefaultMaintenanceMessage = "Экспорт временно недоступен: идут технические работы. Попробуйте позже."
+
+// Maintenance is the state of an enabled maintenance mode.
+type Maintenance struct {
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+	// By is the admin who enabled it.
+	By int64 `json:"by"`
+}
+
+// MaintenanceSwitch pauses the acceptance of new exports on every instance,
+// e.g. during a DB maintenance window; exports already started finish. The
+// flag lives in Redis until it is turned off.
+type MaintenanceSwitch struct {
+	redis Cache
+}
+
+func NewMaintenanceSwitch(redis Cache) *MaintenanceSwitch {
+	return &MaintenanceSwitch{redis: redis}
+}
+
+// Get returns the enabled maintenance mode, nil when exports are accepted.
+func (m *MaintenanceSwitch) Get(ctx context.Context) (*Maintenance, error) {
+	exists, err := m.redis.Exists(tenant.Without(ctx), maintenanceKey)
+	if err != nil || !exists {
+		return nil, err
+	}
+	raw, err := m.redis.Get(tenant.Without(ctx), maintenanceKey)
+	if err != nil {
+		return nil, err
+	}
+	var state Maintenance
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return nil, errors.New("invalid maintenance flag")
+	}
+	if state.Message == "" {
+		state.Message = DefaultMaintenanceMessage
+	}
+	return &state, nil
+}
+
+// Enable stops accepting new exports; message is shown to their callers.
+func (m *MaintenanceSwitch) Enable(ctx context.Context, message string, by int64) (*Maintenance, error) {
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	state := Maintenance{Message: message, Since: time.Now(), By: by}
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.redis.Set(tenant.Without(ctx), maintenanceKey, string(raw), 0); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Disable accepts new exports again.
+func (m *MaintenanceSwitch) Disable(ctx context.Context) error {
+	return m.redis.Del(tenant.Without(ctx), maintenanceKey)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"debtster-export/internal/service/mocks"
+	"debtster-export/internal/tenant"
+
+	"go.uber.org/mock/gomock"
+)
+
+// tenantless matches contexts without a tenant, so the flag key is not namespaced.
+type tenantless struct{}
+
+func (tenantless) Matches(x any) bool {
+	ctx, ok := x.(context.Context)
+	if !ok {
+		return false
+	}
+	_, bound := tenant.FromContext(ctx)
+	return !bound
+}
+
+func (tenantless) String() string { return "context without a tenant" }
+
+func TestMaintenanceSwitch(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme", Schema: "acme"})
+
+	t.Run("off", func(t *testing.T) {
+		redis := mocks.NewMockCache(gomock.NewController(t))
+		redis.EXPECT().Exists(tenantless{}, maintenanceKey).Return(false, nil)
+
+		state, err := NewMaintenanceSwitch(redis).Get(ctx)
+		if err != nil || state != nil {
+			t.Fatalf("expected no maintenance, got %v, %v", state, err)
+		}
+	})
+
+	t.Run("enable and read back", func(t *testing.T) {
+		redis := mocks.NewMockCache(gomock.NewController(t))
+		var stored string
+		redis.EXPECT().Set(tenantless{}, maintenanceKey, gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ string, v any, _ time.Duration) error {
+				stored = v.(string)
+				return nil
+			})
+		m := NewMaintenanceSwitch(redis)
+
+		// без сообщения показывается сообщение по умолчанию
+		if _, err := m.Enable(ctx, "", 7); err != nil {
+			t.Fatal(err)
+		}
+		redis.EXPECT().Exists(tenantless{}, maintenanceKey).Return(true, nil)
+		redis.EXPECT().Get(tenantless{}, maintenanceKey).DoAndReturn(func(context.Context, string) (string, error) {
+			return stored, nil
+		})
+		state, err := m.Get(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state == nil || state.Message != DefaultMaintenanceMessage || state.By != 7 || state.Since.IsZero() {
+			t.Fatalf("unexpected state %+v", state)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		redis := mocks.NewMockCache(gomock.NewController(t))
+		redis.EXPECT().Del(tenantless{}, maintenanceKey).Return(nil)
+		if err := NewMaintenanceSwitch(redis).Disable(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
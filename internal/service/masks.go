@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ColumnMasks maps a token ability (a role of the CRM is granted as one) to
+// the columns its holders must not export, as column keys or path.Match
+// patterns like "amount_*". Masked columns are dropped from exports silently.
+type ColumnMasks map[string][]string
+
+// ParseColumnMasks parses "ability=pattern,pattern;ability=pattern", e.g.
+// "role:collector=amount_*,debtor.iin;role:intern=debtor.*".
+func ParseColumnMasks(s string) (ColumnMasks, error) {
+	masks := ColumnMasks{}
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		ability, patterns, ok := strings.Cut(rule, "=")
+		ability = strings.TrimSpace(ability)
+		if !ok || ability == "" {
+			return nil, fmt.Errorf("rule %q must look like ability=column,column", rule)
+		}
+		for _, p := range strings.Split(patterns, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("rule %q: bad pattern %q", rule, p)
+			}
+			masks[ability] = append(masks[ability], p)
+		}
+	}
+	return masks, nil
+}
+
+// Hidden returns the column patterns masked for a token with abilities.
+func (m ColumnMasks) Hidden(abilities []string) []string {
+	var hidden []string
+	for _, ability := range abilities {
+		for _, p := range m[ability] {
+			if !slices.Contains(hidden, p) {
+				hidden = append(hidden, p)
+			}
+		}
+	}
+	return hidden
+}
+
+type hiddenColumnsKey struct{}
+
+// WithHiddenColumns marks the column patterns the caller must not export; the
+// exports started with ctx drop them and remember them for retries.
+func WithHiddenColumns(ctx context.Context, patterns []string) context.Context {
+	if len(patterns) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, hiddenColumnsKey{}, patterns)
+}
+
+// withCallerMasks adds the patterns hidden from the caller of ctx to the ones
+// persisted with the export.
+func withCallerMasks(ctx context.Context, hidden []string) []string {
+	patterns, _ := ctx.Value(hiddenColumnsKey{}).([]string)
+	for _, p := range patterns {
+		if !slices.Contains(hidden, p) {
+			hidden = append(hidden, p)
+		}
+	}
+	return hidden
+}
+
+// columnHidden reports whether the column key matches one of patterns.
+func columnHidden(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleColumns drops the selected columns matching patterns.
+func visibleColumns(selected, patterns []string) []string {
+	if len(patterns) == 0 {
+		return selected
+	}
+	out := make([]string, 0, len(selected))
+	for _, key := range selected {
+		if !columnHidden(patterns, key) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseColumnMasks(t *testing.T) {
+	masks, err := ParseColumnMasks("role:collector=amount_*, *.iin ; role:intern=debtor.*;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ColumnMasks{
+		"role:collector": {"amount_*", "*.iin"},
+		"role:intern":    {"debtor.*"},
+	}
+	if !reflect.DeepEqual(masks, want) {
+		t.Fatalf("unexpected masks %v", masks)
+	}
+
+	// шаблоны нескольких способностей объединяются без повторов
+	hidden := masks.Hidden([]string{"role:collector", "role:intern", "role:collector"})
+	if !reflect.DeepEqual(hidden, []string{"amount_*", "*.iin", "debtor.*"}) {
+		t.Fatalf("unexpected hidden %v", hidden)
+	}
+	if hidden := masks.Hidden([]string{"*"}); hidden != nil {
+		t.Fatalf("unexpected hidden for unrestricted token %v", hidden)
+	}
+
+	for _, bad := range []string{"amount_*", "=amount_*", "role:x=[a"} {
+		if _, err := ParseColumnMasks(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestVisibleColumns(t *testing.T) {
+	selected := []string{"number", "debtor.iin", "amount_actual_debt", "debtor.full_name"}
+	got := visibleColumns(selected, []string{"amount_*", "*.iin"})
+	if !reflect.DeepEqual(got, []string{"number", "debtor.full_name"}) {
+		t.Fatalf("unexpected columns %v", got)
+	}
+}
+
+func TestColumnMasks_Computed(t *testing.T) {
+	ctx := WithHiddenColumns(context.Background(), []string{"amount_*"})
+	hidden := withCallerMasks(ctx, []string{"*.iin"})
+
+	// скрытое поле нельзя вытащить через вычисляемую колонку
+	_, err := debtComputedColumns([]ComputedColumn{{Expr: "amount_actual_debt * 1"}}, hidden)
+	if !errors.Is(err, ErrInvalidComputedColumn) {
+		t.Fatalf("expected ErrInvalidComputedColumn, got %v", err)
+	}
+	if _, err := debtComputedColumns([]ComputedColumn{{Expr: `number + "!"`}}, hidden); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/requestid"
+)
+
+// Kinds of outbox entries.
+const (
+	outboxStatus         = "export_status"
+	outboxExportComplete = "export_complete"
+	outboxExportFailed   = "export_failed"
+	outboxBatchComplete  = "batch_complete"
+)
+
+const (
+	// outboxLease — how long a claimed entry is left to its publisher or
+	// dispatcher before another dispatcher may retry it
+	outboxLease = time.Minute
+	// outboxMaxAttempts — entries failing this often stay undelivered for inspection
+	outboxMaxAttempts = 12
+	outboxMaxBackoff  = 5 * time.Minute
+	outboxClaimLimit  = 100
+	// outboxDeliveredRetention / outboxRetention — delivered entries are pruned
+	// after the first, all others after the second
+	outboxDeliveredRetention = time.Hour
+	outboxRetention          = 24 * time.Hour
+)
+
+// OutboxStore keeps undelivered outbox entries; implemented by
+// *repository.OutboxRepository.
+type OutboxStore interface {
+	// Enqueue stores e claimed for lease; created is false when an entry with
+	// the same dedup key exists.
+	Enqueue(ctx context.Context, e domain.OutboxEntry, lease time.Duration) (id int64, created bool, err error)
+	ClaimDue(ctx context.Context, limit, maxAttempts int, lease time.Duration) ([]domain.OutboxEntry, error)
+	MarkDelivered(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, errMsg string, retryAt time.Time) error
+	Prune(ctx context.Context, deliveredBefore, createdBefore time.Time) (int, error)
+}
+
+// OutboxTarget is a notifier the outbox delivers terminal events to; Name
+// identifies it in stored entries and must stay stable across restarts.
+type OutboxTarget struct {
+	Name     string
+	Notifier Notifier
+}
+
+// outboxEvent is the payload of an outbox entry.
+type outboxEvent struct {
+	Target   string                     `json:"target,omitempty"`
+	UserID   int64                      `json:"user_id,omitempty"`
+	ExportID string                     `json:"export_id,omitempty"`
+	URL      string                     `json:"url,omitempty"`
+	Filename string                     `json:"filename,omitempty"`
+	Error    string                     `json:"error,omitempty"`
+	Results  []domain.ExportBatchResult `json:"results,omitempty"`
+	// Writes are the Redis records of a final export status
+	Writes []outboxWrite `json:"writes,omitempty"`
+}
+
+type outboxWrite struct {
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+// Outbox delivers the final status of exports and their completion events at
+// least once. Every event is stored in Postgres under a dedup key before it is
+// delivered; what fails to reach Redis or a notifier is retried by Dispatch,
+// so a Redis blip no longer loses a "complete" event. Progress events are not
+// worth keeping and go straight to the targets.
+//
+// Outbox is a Notifier and sits behind the BatchNotifier; the exporters write
+// final statuses through SaveStatus.
+type Outbox struct {
+	store   OutboxStore
+	redis   Cache
+	targets []OutboxTarget
+}
+
+func NewOutbox(store OutboxStore, redis Cache, targets ...OutboxTarget) *Outbox {
+	return &Outbox{store: store, redis: redis, targets: targets}
+}
+
+// SaveStatus writes the final status of an export: its record, the Laravel
+// cache item laravelValue under laravelKey and its membership in the export
+// index. Writes are retried until they succeed.
+func (o *Outbox) SaveStatus(ctx context.Context, st *ExportStatus, laravelKey, laravelValue string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	ttl := int64(exportTTL / time.Second)
+	ev := outboxEvent{
+		ExportID: st.Key,
+		Writes: []outboxWrite{
+			{Key: st.Key, Value: string(data), TTLSeconds: ttl},
+			{Key: laravelKey, Value: laravelValue, TTLSeconds: ttl},
+		},
+	}
+	return o.publish(ctx, st.Key+":status", outboxStatus, ev)
+}
+
+func (o *Outbox) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	var errs []error
+	for _, t := range o.targets {
+		errs = append(errs, t.Notifier.NotifyExportProgress(ctx, userID, exportID, progress, stage))
+	}
+	return errors.Join(errs...)
+}
+
+func (o *Outbox) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	return o.publishToTargets(ctx, exportID+":complete", outboxExportComplete, outboxEvent{
+		UserID: userID, ExportID: exportID, URL: url, Filename: filename,
+	})
+}
+
+func (o *Outbox) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	return o.publishToTargets(ctx, exportID+":failed", outboxExportFailed, outboxEvent{
+		UserID: userID, ExportID: exportID, Error: errMsg,
+	})
+}
+
+func (o *Outbox) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	return o.publishToTargets(ctx, batchID+":complete", outboxBatchComplete, outboxEvent{
+		UserID: userID, ExportID: batchID, Results: results,
+	})
+}
+
+// publishToTargets publishes ev once per target, so a failing target is
+// retried without repeating the event on the others.
+func (o *Outbox) publishToTargets(ctx context.Context, dedupKey, kind string, ev outboxEvent) error {
+	var errs []error
+	for _, t := range o.targets {
+		ev.Target = t.Name
+		errs = append(errs, o.publish(ctx, dedupKey+":"+t.Name, kind, ev))
+	}
+	return errors.Join(errs...)
+}
+
+// publish stores ev and delivers it right away. A failed delivery is left to
+// Dispatch; when the entry cannot be stored, ev is delivered without the
+// guarantee. Events whose dedup key was published before are dropped.
+func (o *Outbox) publish(ctx context.Context, dedupKey, kind string, ev outboxEvent) error {
+	// the final status of a cancelled export must be recorded all the same
+	ctx = context.WithoutCancel(ctx)
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	id, created, err := o.store.Enqueue(ctx, domain.OutboxEntry{DedupKey: dedupKey, Kind: kind, Payload: payload}, outboxLease)
+	if err != nil {
+		requestid.Logf(ctx, "outbox %s: enqueue: %v; delivering without retries", dedupKey, err)
+		return o.deliver(ctx, kind, ev)
+	}
+	if !created {
+		return nil
+	}
+
+	if err := o.deliver(ctx, kind, ev); err != nil {
+		requestid.Logf(ctx, "outbox %s: %v; will retry", dedupKey, err)
+		if err := o.store.MarkFailed(ctx, id, err.Error(), time.Now().Add(outboxBackoff(1))); err != nil {
+			requestid.Logf(ctx, "outbox %s: mark failed: %v", dedupKey, err)
+		}
+		return nil
+	}
+	if err := o.store.MarkDelivered(ctx, id); err != nil {
+		// the entry is delivered once more after its lease
+		requestid.Logf(ctx, "outbox %s: mark delivered: %v", dedupKey, err)
+	}
+	return nil
+}
+
+// Dispatch retries the entries that are due and prunes old ones; it is a
+// maintenance task and returns how many entries it delivered.
+func (o *Outbox) Dispatch(ctx context.Context) (int, error) {
+	entries, err := o.store.ClaimDue(ctx, outboxClaimLimit, outboxMaxAttempts, outboxLease)
+	if err != nil {
+		return 0, fmt.Errorf("claim outbox: %w", err)
+	}
+
+	delivered := 0
+	var errs []error
+	for _, e := range entries {
+		var ev outboxEvent
+		err := json.Unmarshal(e.Payload, &ev)
+		if err == nil {
+			err = o.deliver(ctx, e.Kind, ev)
+		}
+		if err != nil {
+			requestid.Logf(ctx, "outbox %s: attempt %d: %v", e.DedupKey, e.Attempts, err)
+			errs = append(errs, o.store.MarkFailed(ctx, e.ID, err.Error(), time.Now().Add(outboxBackoff(e.Attempts))))
+			continue
+		}
+		errs = append(errs, o.store.MarkDelivered(ctx, e.ID))
+		delivered++
+	}
+
+	now := time.Now()
+	if _, err := o.store.Prune(ctx, now.Add(-outboxDeliveredRetention), now.Add(-outboxRetention)); err != nil {
+		errs = append(errs, fmt.Errorf("prune outbox: %w", err))
+	}
+	return delivered, errors.Join(errs...)
+}
+
+func (o *Outbox) deliver(ctx context.Context, kind string, ev outboxEvent) error {
+	if kind == outboxStatus {
+		if saver, ok := o.redis.(StatusSaver); ok && len(ev.Writes) > 0 {
+			values := make(map[string]any, len(ev.Writes))
+			for _, w := range ev.Writes {
+				values[w.Key] = w.Value
+			}
+			// the writes of a status entry share the export TTL
+			return saver.SaveStatus(ctx, values, time.Duration(ev.Writes[0].TTLSeconds)*time.Second, exportSetKey, ev.ExportID)
+		}
+		for _, w := range ev.Writes {
+			if err := o.redis.Set(ctx, w.Key, w.Value, time.Duration(w.TTLSeconds)*time.Second); err != nil {
+				return err
+			}
+		}
+		return o.redis.SAdd(ctx, exportSetKey, ev.ExportID)
+	}
+
+	var n Notifier
+	for _, t := range o.targets {
+		if t.Name == ev.Target {
+			n = t.Notifier
+		}
+	}
+	if n == nil {
+		// the target was removed from the configuration since
+		return nil
+	}
+
+	switch kind {
+	case outboxExportComplete:
+		return n.NotifyExportComplete(ctx, ev.UserID, ev.ExportID, ev.URL, ev.Filename)
+	case outboxExportFailed:
+		return n.NotifyExportFailed(ctx, ev.UserID, ev.ExportID, ev.Error)
+	case outboxBatchComplete:
+		return n.NotifyBatchComplete(ctx, ev.UserID, ev.ExportID, ev.Results)
+	}
+	return fmt.Errorf("unknown outbox entry kind %q", kind)
+}
+
+// outboxBackoff is the delay before retrying an entry that failed attempts times.
+func outboxBackoff(attempts int) time.Duration {
+	d := 5 * time.Second
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
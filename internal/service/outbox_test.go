@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/service/mocks"
+
+	"go.uber.org/mock/gomock"
+)
+
+// memOutbox is an OutboxStore in memory; every undelivered entry is due.
+type memOutbox struct {
+	entries   []domain.OutboxEntry
+	delivered map[int64]bool
+}
+
+func (m *memOutbox) Enqueue(_ context.Context, e domain.OutboxEntry, _ time.Duration) (int64, bool, error) {
+	for _, old := range m.entries {
+		if old.DedupKey == e.DedupKey {
+			return 0, false, nil
+		}
+	}
+	e.ID = int64(len(m.entries) + 1)
+	e.Attempts = 1
+	m.entries = append(m.entries, e)
+	return e.ID, true, nil
+}
+
+func (m *memOutbox) ClaimDue(_ context.Context, limit, maxAttempts int, _ time.Duration) ([]domain.OutboxEntry, error) {
+	var out []domain.OutboxEntry
+	for i := range m.entries {
+		e := &m.entries[i]
+		if m.delivered[e.ID] || e.Attempts >= maxAttempts || len(out) == limit {
+			continue
+		}
+		e.Attempts++
+		out = append(out, *e)
+	}
+	return out, nil
+}
+
+func (m *memOutbox) MarkDelivered(_ context.Context, id int64) error {
+	m.delivered[id] = true
+	return nil
+}
+
+func (m *memOutbox) MarkFailed(_ context.Context, id int64, errMsg string, _ time.Time) error {
+	m.entries[id-1].LastError = errMsg
+	return nil
+}
+
+func (m *memOutbox) Prune(context.Context, time.Time, time.Time) (int, error) {
+	return 0, nil
+}
+
+func TestOutbox_RetriesFailedTargetOnly(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ws := mocks.NewMockNotifier(ctrl)
+	email := mocks.NewMockNotifier(ctrl)
+	store := &memOutbox{delivered: map[int64]bool{}}
+	o := NewOutbox(store, mocks.NewMockCache(ctrl),
+		OutboxTarget{Name: "websocket", Notifier: ws},
+		OutboxTarget{Name: "email", Notifier: email})
+
+	// письмо ушло сразу, websocket не принял событие
+	email.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), "exports:1", "/files/a.xlsx", "a.xlsx").Return(nil)
+	ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), "exports:1", "/files/a.xlsx", "a.xlsx").Return(errors.New("down"))
+	if err := o.NotifyExportComplete(context.Background(), 7, "exports:1", "/files/a.xlsx", "a.xlsx"); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	// повторная публикация того же события отбрасывается
+	if err := o.NotifyExportComplete(context.Background(), 7, "exports:1", "/files/a.xlsx", "a.xlsx"); err != nil {
+		t.Fatalf("notify again: %v", err)
+	}
+
+	// диспетчер повторяет только websocket
+	ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), "exports:1", "/files/a.xlsx", "a.xlsx").Return(nil)
+	n, err := o.Dispatch(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("dispatch = %d, %v; want 1, nil", n, err)
+	}
+	if n, _ := o.Dispatch(context.Background()); n != 0 {
+		t.Fatalf("second dispatch delivered %d entries", n)
+	}
+}
+
+func TestOutbox_SaveStatusRetriesRedis(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	redis := mocks.NewMockCache(ctrl)
+	store := &memOutbox{delivered: map[int64]bool{}}
+	o := NewOutbox(store, redis)
+
+	st := &ExportStatus{Key: "exports:1", Type: "debts", UserID: 7, Progress: 100}
+	redis.EXPECT().Set(gomock.Any(), "exports:1", gomock.Any(), exportTTL).Return(errors.New("connection refused"))
+	if err := o.SaveStatus(context.Background(), st, "cache:exports:1", "serialized"); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if store.entries[0].LastError != "connection refused" {
+		t.Fatalf("last error = %q", store.entries[0].LastError)
+	}
+
+	gomock.InOrder(
+		redis.EXPECT().Set(gomock.Any(), "exports:1", gomock.Any(), exportTTL).Return(nil),
+		redis.EXPECT().Set(gomock.Any(), "cache:exports:1", "serialized", exportTTL).Return(nil),
+		redis.EXPECT().SAdd(gomock.Any(), exportSetKey, "exports:1").Return(nil),
+	)
+	if n, err := o.Dispatch(context.Background()); err != nil || n != 1 {
+		t.Fatalf("dispatch = %d, %v; want 1, nil", n, err)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 5 * time.Second, 2: 10 * time.Second, 4: 40 * time.Second, 30: outboxMaxBackoff} {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/parquet"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// ErrInvalidOutput is returned for an unknown output profile or format, or
+// one that cannot be combined with the other options of the export.
+var ErrInvalidOutput = errors.New("invalid output profile")
+
+// Output formats of ExportOptions.Format.
+const (
+	FormatXLSX    = "xlsx"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// OutputProfile is a CSV layout an external system imports: the first sheet
+// of the export is written with it instead of the workbook.
+type OutputProfile struct {
+	// Encoding is "utf-8" (default) or "windows-1251"; characters the
+	// encoding lacks are replaced.
+	Encoding string
+	// Delimiter separates the fields; ',' when zero.
+	Delimiter rune
+	// DateLayout rewrites the dates and timestamps of the export (written as
+	// "2006-01-02" and "2006-01-02 15:04:05"); empty keeps them.
+	DateLayout string
+	// DecimalSeparator of numbers; "." when empty.
+	DecimalSeparator string
+	// CRLF ends lines with \r\n instead of \n.
+	CRLF bool
+	// QuoteAll quotes every field, not only those that need it.
+	QuoteAll bool
+	// BOM starts a UTF-8 file with the byte order mark Excel on Windows
+	// needs to detect the encoding.
+	BOM bool
+	// Null is written for empty cells.
+	Null string
+}
+
+// CSVDialect are the request options of a generic CSV export, see FormatCSV.
+type CSVDialect struct {
+	// Delimiter is a single character, "," by default.
+	Delimiter string `json:"delimiter,omitempty"`
+	// Quoting is "minimal" (default) or "all".
+	Quoting string `json:"quoting,omitempty"`
+	BOM     bool   `json:"bom,omitempty"`
+	// LineEnding is "lf" (default) or "crlf".
+	LineEnding string `json:"line_ending,omitempty"`
+	// Null is written for empty cells, "" by default.
+	Null string `json:"null,omitempty"`
+}
+
+// maxCSVNullRunes limits the null representation, e.g. NULL or \N.
+const maxCSVNullRunes = 16
+
+// profile validates the dialect and turns it into the profile of the writer.
+func (d CSVDialect) profile() (OutputProfile, error) {
+	p := OutputProfile{Delimiter: ',', BOM: d.BOM, Null: d.Null}
+
+	if d.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(d.Delimiter)
+		if size != len(d.Delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return OutputProfile{}, fmt.Errorf("%w: csv.delimiter must be a single character other than a quote or a line break", ErrInvalidOutput)
+		}
+		p.Delimiter = r
+	}
+	switch d.Quoting {
+	case "", "minimal":
+	case "all":
+		p.QuoteAll = true
+	default:
+		return OutputProfile{}, fmt.Errorf("%w: csv.quoting must be minimal or all", ErrInvalidOutput)
+	}
+	switch d.LineEnding {
+	case "", "lf":
+	case "crlf":
+		p.CRLF = true
+	default:
+		return OutputProfile{}, fmt.Errorf("%w: csv.line_ending must be lf or crlf", ErrInvalidOutput)
+	}
+	if utf8.RuneCountInString(d.Null) > maxCSVNullRunes || strings.ContainsAny(d.Null, "\r\n") {
+		return OutputProfile{}, fmt.Errorf("%w: csv.null must be a single line of at most %d characters", ErrInvalidOutput, maxCSVNullRunes)
+	}
+	return p, nil
+}
+
+// outputProfiles are the profiles output_profile may request.
+var outputProfiles = map[string]OutputProfile{
+	// import of payments and registries into 1C:Бухгалтерия
+	"1c": {Encoding: "windows-1251", Delimiter: ';', DateLayout: "02.01.2006", DecimalSeparator: ",", CRLF: true},
+}
+
+// outputEncodings are the encodings an OutputProfile may use; nil is UTF-8.
+var outputEncodings = map[string]encoding.Encoding{
+	"utf-8":        nil,
+	"windows-1251": charmap.Windows1251,
+}
+
+// OutputProfileNames lists the defined output profiles, sorted.
+func OutputProfileNames() []string {
+	names := make([]string, 0, len(outputProfiles))
+	for name := range outputProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileExt is the extension of the file the export is written to.
+func (o ExportOptions) fileExt() string {
+	switch {
+	case o.OutputProfile != "" || o.Format == FormatCSV:
+		return ".csv"
+	case o.Format == FormatParquet:
+		return ".parquet"
+	}
+	return ".xlsx"
+}
+
+// checkOutput rejects an output profile or format that cannot be used,
+// before the export is queued.
+func checkOutput(opts ExportOptions) error {
+	_, err := opts.outputProfile()
+	if err != nil || opts.fileExt() == ".xlsx" {
+		return err
+	}
+	if opts.Template != "" {
+		return fmt.Errorf("%w: templates are workbooks, the export is written as %s", ErrInvalidOutput, strings.TrimPrefix(opts.fileExt(), "."))
+	}
+	return nil
+}
+
+// outputProfile returns the profile the export is written with: the named
+// one, the dialect of a csv export or nil for a workbook.
+func (o ExportOptions) outputProfile() (*OutputProfile, error) {
+	switch o.Format {
+	case "", FormatXLSX, FormatCSV, FormatParquet:
+	default:
+		return nil, fmt.Errorf("%w: format must be %s, %s or %s", ErrInvalidOutput, FormatXLSX, FormatCSV, FormatParquet)
+	}
+	if o.CSV != nil && o.Format != FormatCSV {
+		return nil, fmt.Errorf("%w: csv options need format %s", ErrInvalidOutput, FormatCSV)
+	}
+
+	if o.OutputProfile != "" {
+		if o.Format != "" || o.CSV != nil {
+			return nil, fmt.Errorf("%w: output_profile defines its own format", ErrInvalidOutput)
+		}
+		p, err := outputProfile(o.OutputProfile)
+		return &p, err
+	}
+	if o.Format != FormatCSV {
+		return nil, nil
+	}
+	var d CSVDialect
+	if o.CSV != nil {
+		d = *o.CSV
+	}
+	p, err := d.profile()
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func outputProfile(name string) (OutputProfile, error) {
+	p, ok := outputProfiles[name]
+	if !ok {
+		return OutputProfile{}, fmt.Errorf("%w: %q, expected one of %s", ErrInvalidOutput, name, strings.Join(OutputProfileNames(), ", "))
+	}
+	if _, ok := outputEncodings[strings.ToLower(p.Encoding)]; !ok && p.Encoding != "" {
+		return OutputProfile{}, fmt.Errorf("%w: %q: unsupported encoding %q", ErrInvalidOutput, name, p.Encoding)
+	}
+	return p, nil
+}
+
+// applyOutput writes the first sheet of the generated workbook in the format
+// of the export; a workbook export is returned as is. samples are the values
+// the columns give for an empty record, see sampleColumns.
+func applyOutput(opts ExportOptions, workbook []byte, samples []any) ([]byte, error) {
+	p, err := opts.outputProfile()
+	if err != nil || (p == nil && opts.Format != FormatParquet) {
+		return workbook, err
+	}
+
+	src, err := excelize.OpenReader(bytes.NewReader(workbook))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	rows, err := sourceRows(src, src.GetSheetName(0))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if opts.Format == FormatParquet {
+		if len(rows) == 0 {
+			return nil, errors.New("no header row")
+		}
+		err = parquet.Write(&buf, parquetSchema(rows[0], samples, rows[1:]), rows[1:])
+	} else {
+		err = writeCSV(&buf, rows, *p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sampledColumn is a column of a registry that can report its value for an
+// empty record.
+type sampledColumn interface {
+	sample() any
+}
+
+func (c DebtColumn) sample() any    { return c.Value(domain.Debt{}) }
+func (c UserColumn) sample() any    { return c.Value(domain.User{}) }
+func (c ActionColumn) sample() any  { return c.Value(domain.Action{}) }
+func (c PaymentColumn) sample() any { return c.Value(domain.Payment{}) }
+
+// sampleColumns returns the values cols give for an empty record, the type
+// information of the registry parquetSchema builds on.
+func sampleColumns[C sampledColumn](cols []C) []any {
+	out := make([]any, len(cols))
+	for i, c := range cols {
+		out[i] = c.sample()
+	}
+	return out
+}
+
+// parquetSchema types the columns after the registry: numbers, integers and
+// booleans as such. Columns the registry renders as text, like optional
+// amounts that are empty for an empty record, are numbers when all their
+// values are.
+func parquetSchema(header, samples []any, rows [][]any) []parquet.Column {
+	cols := make([]parquet.Column, len(header))
+	for i, h := range header {
+		cols[i] = parquet.Column{Name: fmt.Sprint(h), Type: parquet.ByteArray}
+		var sample any
+		if i < len(samples) {
+			sample = samples[i]
+		}
+		switch sample.(type) {
+		case float64:
+			cols[i].Type = parquet.Double
+		case int64, int:
+			cols[i].Type = parquet.Int64
+		case bool:
+			cols[i].Type = parquet.Boolean
+		default:
+			if allNumbers(rows, i) {
+				cols[i].Type = parquet.Double
+			}
+		}
+	}
+	return cols
+}
+
+// allNumbers reports whether column i has values and all of them are numbers.
+func allNumbers(rows [][]any, i int) bool {
+	seen := false
+	for _, row := range rows {
+		if i >= len(row) || row[i] == "" || row[i] == nil {
+			continue
+		}
+		if _, ok := row[i].(float64); !ok {
+			return false
+		}
+		seen = true
+	}
+	return seen
+}
+
+// writeCSV writes rows, as read by sourceRows, in the layout of p.
+func writeCSV(w io.Writer, rows [][]any, p OutputProfile) error {
+	var tw *transform.Writer
+	if enc := outputEncodings[strings.ToLower(p.Encoding)]; enc != nil {
+		tw = transform.NewWriter(w, encoding.ReplaceUnsupported(enc.NewEncoder()))
+		w = tw
+	} else if p.BOM {
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return err
+		}
+	}
+
+	comma := p.Delimiter
+	if comma == 0 {
+		comma = ','
+	}
+	eol := "\n"
+	if p.CRLF {
+		eol = "\r\n"
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteRune(comma)
+			}
+			field := p.format(v)
+			if field == "" {
+				field = p.Null
+			}
+			if p.QuoteAll || fieldNeedsQuotes(field, comma) {
+				bw.WriteByte('"')
+				bw.WriteString(strings.ReplaceAll(field, `"`, `""`))
+				bw.WriteByte('"')
+			} else {
+				bw.WriteString(field)
+			}
+		}
+		bw.WriteString(eol)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if tw != nil {
+		return tw.Close()
+	}
+	return nil
+}
+
+// fieldNeedsQuotes follows encoding/csv: fields with the delimiter, quotes,
+// line breaks or a leading space are quoted.
+func fieldNeedsQuotes(field string, comma rune) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` || strings.ContainsRune(field, comma) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return r == ' ' || r == '\t'
+}
+
+// exportDateLayouts are the layouts dates are exported in.
+var exportDateLayouts = []string{"2006-01-02 15:04:05", "2006-01-02"}
+
+// format renders one cell value.
+func (p OutputProfile) format(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		s := strconv.FormatFloat(t, 'f', -1, 64)
+		if p.DecimalSeparator != "" {
+			s = strings.Replace(s, ".", p.DecimalSeparator, 1)
+		}
+		return s
+	case bool:
+		if t {
+			return "1"
+		}
+		return "0"
+	case string:
+		if p.DateLayout != "" && len(t) >= len("2006-01-02") && t[4] == '-' {
+			for _, layout := range exportDateLayouts {
+				if d, err := time.Parse(layout, t); err == nil {
+					return d.Format(p.DateLayout)
+				}
+			}
+		}
+		return t
+	default:
+		return fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"debtster-export/internal/parquet"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestApplyOutput_1C(t *testing.T) {
+	f := excelize.NewFile()
+	sheet := f.GetSheetName(0)
+	_ = f.SetSheetRow(sheet, "A1", &[]any{"Дата платежа", "Сумма", "Подтверждено", "Комментарий"})
+	_ = f.SetSheetRow(sheet, "A2", &[]any{"2025-03-05 00:00:00", 1500.5, true, "оплата; частично"})
+	_ = f.SetSheetRow(sheet, "A3", &[]any{"2025-03-06", 10.0, false, "✓"})
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := applyOutput(ExportOptions{OutputProfile: "1c"}, buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := charmap.Windows1251.NewDecoder().Bytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// windows-1251, ";" и CRLF, даты ДД.ММ.ГГГГ, дробная часть через запятую;
+	// символ вне кодировки заменяется
+	want := "Дата платежа;Сумма;Подтверждено;Комментарий\r\n" +
+		"05.03.2025;1500,5;1;\"оплата; частично\"\r\n" +
+		"06.03.2025;10;0;\x1a\r\n"
+	if string(text) != want {
+		t.Fatalf("unexpected csv:\n%q\nwant:\n%q", text, want)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	if err := checkOutput(ExportOptions{}); err != nil {
+		t.Fatalf("no profile: %v", err)
+	}
+	if err := checkOutput(ExportOptions{OutputProfile: "1c"}); err != nil {
+		t.Fatalf("1c: %v", err)
+	}
+	for _, opts := range []ExportOptions{
+		{OutputProfile: "sap"},
+		{OutputProfile: "1c", Template: "bank"},
+	} {
+		if err := checkOutput(opts); !errors.Is(err, ErrInvalidOutput) {
+			t.Fatalf("%+v: expected ErrInvalidOutput, got %v", opts, err)
+		}
+	}
+
+	if name := exportFilename("", ExportOptions{Filename: "payments", OutputProfile: "1c"}, filenameFields{}); name != "payments.csv" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+}
+
+func TestWriteCSV_Dialect(t *testing.T) {
+	rows := [][]any{
+		{"id", "comment", "amount"},
+		{"1", "", 10.5},
+		{"2", `say "hi"`, nil},
+	}
+
+	cases := []struct {
+		name    string
+		dialect CSVDialect
+		want    string
+	}{
+		{"defaults", CSVDialect{}, "id,comment,amount\n1,,10.5\n2,\"say \"\"hi\"\"\",\n"},
+		{"excel on windows", CSVDialect{Delimiter: ";", BOM: true, LineEnding: "crlf"}, "\uFEFFid;comment;amount\r\n1;;10.5\r\n2;\"say \"\"hi\"\"\";\r\n"},
+		{"quote all, nulls", CSVDialect{Delimiter: "\t", Quoting: "all", Null: `\N`}, "\"id\"\t\"comment\"\t\"amount\"\n\"1\"\t\"\\N\"\t\"10.5\"\n\"2\"\t\"say \"\"hi\"\"\"\t\"\\N\"\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := tc.dialect.profile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf strings.Builder
+			if err := writeCSV(&buf, rows, p); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Fatalf("unexpected csv:\n%q\nwant:\n%q", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestExportOptions_OutputProfile(t *testing.T) {
+	for _, opts := range []ExportOptions{
+		{Format: "json"},
+		{Format: FormatCSV, CSV: &CSVDialect{Delimiter: ";;"}},
+		{Format: FormatCSV, CSV: &CSVDialect{Delimiter: `"`}},
+		{Format: FormatCSV, CSV: &CSVDialect{Quoting: "none"}},
+		{Format: FormatCSV, CSV: &CSVDialect{LineEnding: "cr"}},
+		{CSV: &CSVDialect{}},
+		{OutputProfile: "1c", Format: FormatCSV, CSV: &CSVDialect{}},
+	} {
+		if _, err := opts.outputProfile(); !errors.Is(err, ErrInvalidOutput) {
+			t.Fatalf("%+v: expected ErrInvalidOutput, got %v", opts, err)
+		}
+	}
+
+	if p, err := (ExportOptions{Format: FormatXLSX}).outputProfile(); p != nil || err != nil {
+		t.Fatalf("xlsx: %v, %v", p, err)
+	}
+	if name := exportFilename("", ExportOptions{Filename: "debts", Format: FormatCSV}, filenameFields{}); name != "debts.csv" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	cols := []DebtColumn{debtColumns["number"], debtColumns["amount_actual_debt"], debtColumns["amount_main_debt"], debtColumns["presence_solidarity"], debtColumns["debtor.iin"]}
+	header := []any{"Номер", "Долг", "Основной долг", "Солидарность", "ИИН"}
+	rows := [][]any{
+		{"D-1", 100.5, 50.0, true, "900101300001"},
+		{"D-2", 0.0, "", false, ""},
+	}
+
+	// тип из реестра, опциональная сумма — по данным
+	got := parquetSchema(header, sampleColumns(cols), rows)
+	want := []parquet.Column{
+		{Name: "Номер", Type: parquet.ByteArray},
+		{Name: "Долг", Type: parquet.Double},
+		{Name: "Основной долг", Type: parquet.Double},
+		{Name: "Солидарность", Type: parquet.Boolean},
+		{Name: "ИИН", Type: parquet.ByteArray},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected schema:\n%v\nwant:\n%v", got, want)
+	}
+
+	if err := checkOutput(ExportOptions{Format: FormatParquet, Template: "bank"}); !errors.Is(err, ErrInvalidOutput) {
+		t.Fatalf("expected ErrInvalidOutput for a template, got %v", err)
+	}
+	if name := exportFilename("", ExportOptions{Filename: "debts", Format: FormatParquet}, filenameFields{}); name != "debts.parquet" {
+		t.Fatalf("unexpected file name %q", name)
+	}
+}
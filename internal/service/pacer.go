@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"debtster-export/internal/progress"
+)
+
+const (
+	// pacerFirstChunk is the number of rows before the first progress report.
+	pacerFirstChunk = 1000
+	// pacerInterval is the target time between progress reports.
+	pacerInterval = time.Second
+	// chunk bounds: narrow rows are reported in big chunks, wide ones in small
+	pacerMinChunk = 100
+	pacerMaxChunk = 100_000
+)
+
+// rowPacer decides when a generating export reports its progress. It tracks
+// the rows per second and sizes the next chunk to report about once per
+// pacerInterval, whatever the width of the rows, and estimates the time left.
+// Reports closer than minGap are coalesced into the next one, so a burst of
+// fast chunks does not flood Redis and the WS; the last row is always reported.
+type rowPacer struct {
+	total  int
+	chunk  int
+	next   int
+	minGap time.Duration
+
+	start    time.Time
+	last     time.Time
+	lastRows int
+	now      func() time.Time
+}
+
+func newRowPacer(total int, minGap time.Duration) *rowPacer {
+	now := time.Now()
+	return &rowPacer{total: total, chunk: pacerFirstChunk, next: pacerFirstChunk, minGap: minGap, start: now, last: now, now: time.Now}
+}
+
+// due reports whether the progress should be reported after done rows; the
+// last row is always reported.
+func (p *rowPacer) due(done int) bool {
+	if done >= p.total {
+		return true
+	}
+	return done >= p.next && (p.minGap <= 0 || p.now().Sub(p.last) >= p.minGap)
+}
+
+// mark records a report after done rows, resizes the next chunk from the rate
+// of the last one and returns the estimated time left, 0 once all rows are done.
+func (p *rowPacer) mark(done int) time.Duration {
+	now := p.now()
+	if elapsed := now.Sub(p.last); elapsed > 0 && done > p.lastRows {
+		perInterval := float64(done-p.lastRows) / elapsed.Seconds() * pacerInterval.Seconds()
+		p.chunk = min(max(int(math.Round(perInterval)), pacerMinChunk), pacerMaxChunk)
+	}
+	p.last, p.lastRows = now, done
+	p.next = done + p.chunk
+
+	if done >= p.total || done == 0 {
+		return 0
+	}
+	rate := float64(done) / now.Sub(p.start).Seconds()
+	if rate <= 0 || math.IsInf(rate, 0) {
+		return 0
+	}
+	return time.Duration(float64(p.total-done) / rate * float64(time.Second)).Round(time.Second)
+}
+
+// report records a progress report after done rows on status: the progress
+// and, until the last row, the estimated time left. The returned context
+// carries the estimate to the progress event.
+func (p *rowPacer) report(ctx context.Context, status *ExportStatus, done int) context.Context {
+	eta := p.mark(done)
+	status.Progress = rowsProgress(done, p.total)
+	status.ETASeconds = nil
+	if done >= p.total {
+		return ctx
+	}
+	seconds := int64(eta / time.Second)
+	status.ETASeconds = &seconds
+	return progress.WithETA(ctx, eta)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"debtster-export/internal/progress"
+)
+
+func TestRowPacer(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	p := newRowPacer(100_000, 0)
+	p.start, p.last = now, now
+	p.now = func() time.Time { return now }
+
+	if p.due(999) || !p.due(1000) {
+		t.Fatal("first report is due after pacerFirstChunk rows")
+	}
+
+	// широкие строки: 1000 строк за 4 секунды — следующий отчёт через 250 строк
+	now = now.Add(4 * time.Second)
+	if eta := p.mark(1000); eta != 396*time.Second {
+		t.Fatalf("eta = %s", eta)
+	}
+	if p.chunk != 250 || p.due(1249) || !p.due(1250) {
+		t.Fatalf("chunk = %d", p.chunk)
+	}
+
+	// узкие строки: 50250 строк за секунду — шаг растёт вместе со скоростью
+	now = now.Add(time.Second)
+	p.mark(51_250)
+	if p.chunk != 50_250 || !p.due(100_000) {
+		t.Fatalf("chunk = %d", p.chunk)
+	}
+
+	st := &ExportStatus{}
+	ctx := p.report(context.Background(), st, 60_000)
+	if eta, ok := progress.ETAFromContext(ctx); !ok || st.ETASeconds == nil || *st.ETASeconds != int64(eta/time.Second) {
+		t.Fatalf("eta %v in status %v", eta, st.ETASeconds)
+	}
+	ctx = p.report(context.Background(), st, 100_000)
+	if _, ok := progress.ETAFromContext(ctx); ok || st.ETASeconds != nil || st.Progress != 95 {
+		t.Fatalf("last report: %+v", st)
+	}
+}
+
+func TestRowPacer_MinGap(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	p := newRowPacer(10_000, 2*time.Second)
+	p.start, p.last = now, now
+	p.now = func() time.Time { return now }
+
+	// чанк набран, но с прошлого отчёта прошло меньше двух секунд — отчёт откладывается
+	now = now.Add(time.Second)
+	if p.due(1000) {
+		t.Fatal("report within minGap")
+	}
+	now = now.Add(time.Second)
+	if !p.due(1500) {
+		t.Fatal("coalesced report is not due after minGap")
+	}
+	p.mark(1500)
+
+	// последняя строка отчитывается всегда
+	if !p.due(10_000) {
+		t.Fatal("last row is not reported")
+	}
+}
@@ -4,159 +4,290 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
 	"time"
 
-	"debtster-export/internal/clients"
 	"debtster-export/internal/domain"
 	"debtster-export/internal/repository"
 
-	"github.com/google/uuid"
 	"github.com/xuri/excelize/v2"
 )
 
 type PaymentRepository interface {
 	List(ctx context.Context, f repository.PaymentsFilter) ([]domain.Payment, error)
 	HasMoreThan(ctx context.Context, limit int64, f repository.PaymentsFilter) (bool, error)
+	Count(ctx context.Context, f repository.PaymentsFilter) (int64, error)
 }
 
 type PaymentColumn struct {
 	Header string
 	Value  func(p domain.Payment) any
+	// Write, when set, renders the cell of Value without boxing it; both come
+	// from one typedCell, see paymentColumn.
+	Write func(p *domain.Payment, w CellWriter)
+	// Link, when set, makes the cell a hyperlink to the returned URL (if non-empty).
+	Link func(p domain.Payment, links LinkTemplates) string
+}
+
+// paymentColumn is the column of a payment field read by cell.
+func paymentColumn(header string, cell typedCell[domain.Payment]) PaymentColumn {
+	return PaymentColumn{Header: header, Value: cell.value, Write: cell.write}
 }
 
 var paymentColumns = map[string]PaymentColumn{
-	"id":      {Header: "ID", Value: func(p domain.Payment) any { return p.ID }},
-	"debt_id": {Header: "ID долга", Value: func(p domain.Payment) any { return p.DebtID }},
+	"id": paymentColumn("ID", stringCell(func(p domain.Payment) string { return p.ID })),
+	"debt_id": {
+		Header: "ID долга",
+		Value:  func(p domain.Payment) any { return p.DebtID },
+		Link:   func(p domain.Payment, links LinkTemplates) string { return links.debt(p.DebtID, "") },
+	},
 	"user_id": {Header: "ID пользователя", Value: func(p domain.Payment) any {
 		if p.UserID == nil {
 			return ""
 		}
 		return *p.UserID
 	}},
-	"confirmed":                      {Header: "Подтвержено", Value: func(p domain.Payment) any { return p.Confirmed }},
-	"amount":                         {Header: "Сумма", Value: func(p domain.Payment) any { return p.Amount }},
-	"amount_after_subtraction":       {Header: "Сумма после вычета", Value: func(p domain.Payment) any { return p.AmountAfterSubtraction }},
-	"amount_government_duty":         {Header: "Госпошлина", Value: func(p domain.Payment) any { return p.AmountGovernmentDuty }},
-	"amount_representation_expenses": {Header: "Представительские расходы", Value: func(p domain.Payment) any { return p.AmountRepresentationExpenses }},
-	"amount_notary_fees":             {Header: "Нотариальные расходы", Value: func(p domain.Payment) any { return p.AmountNotaryFees }},
-	"amount_postage":                 {Header: "Почтовые расходы", Value: func(p domain.Payment) any { return p.AmountPostage }},
-	"amount_accounts_receivable":     {Header: "Дебиторская задолженность", Value: func(p domain.Payment) any { return p.AmountAccountsReceivable }},
-	"amount_main_debt":               {Header: "Основной долг", Value: func(p domain.Payment) any { return p.AmountMainDebt }},
-	"amount_accrual":                 {Header: "Начисления", Value: func(p domain.Payment) any { return p.AmountAccrual }},
-	"amount_fine":                    {Header: "Пени", Value: func(p domain.Payment) any { return p.AmountFine }},
-	"payment_date":                   {Header: "Дата платежа", Value: func(p domain.Payment) any { return timePtr(p.PaymentDate) }},
-	"created_at":                     {Header: "Создано", Value: func(p domain.Payment) any { return timePtr(p.CreatedAt) }},
-	"updated_at":                     {Header: "Обновлено", Value: func(p domain.Payment) any { return timePtr(p.UpdatedAt) }},
-	"deleted_at":                     {Header: "Удалено", Value: func(p domain.Payment) any { return timePtr(p.DeletedAt) }},
+	"confirmed":                      paymentColumn("Подтвержено", boolCell(func(p domain.Payment) bool { return p.Confirmed })),
+	"amount":                         paymentColumn("Сумма", floatCell(func(p domain.Payment) float64 { return p.Amount })),
+	"amount_after_subtraction":       paymentColumn("Сумма после вычета", floatCell(func(p domain.Payment) float64 { return p.AmountAfterSubtraction })),
+	"amount_government_duty":         paymentColumn("Госпошлина", floatCell(func(p domain.Payment) float64 { return p.AmountGovernmentDuty })),
+	"amount_representation_expenses": paymentColumn("Представительские расходы", floatCell(func(p domain.Payment) float64 { return p.AmountRepresentationExpenses })),
+	"amount_notary_fees":             paymentColumn("Нотариальные расходы", floatCell(func(p domain.Payment) float64 { return p.AmountNotaryFees })),
+	"amount_postage":                 paymentColumn("Почтовые расходы", floatCell(func(p domain.Payment) float64 { return p.AmountPostage })),
+	"amount_accounts_receivable":     paymentColumn("Дебиторская задолженность", floatCell(func(p domain.Payment) float64 { return p.AmountAccountsReceivable })),
+	"amount_main_debt":               paymentColumn("Основной долг", floatCell(func(p domain.Payment) float64 { return p.AmountMainDebt })),
+	"amount_accrual":                 paymentColumn("Начисления", floatCell(func(p domain.Payment) float64 { return p.AmountAccrual })),
+	"amount_fine":                    paymentColumn("Пени", floatCell(func(p domain.Payment) float64 { return p.AmountFine })),
+	"payment_date":                   paymentColumn("Дата платежа", timeCell(func(p domain.Payment) *time.Time { return p.PaymentDate })),
+	"created_at":                     paymentColumn("Создано", timeCell(func(p domain.Payment) *time.Time { return p.CreatedAt })),
+	"updated_at":                     paymentColumn("Обновлено", timeCell(func(p domain.Payment) *time.Time { return p.UpdatedAt })),
+	"deleted_at":                     paymentColumn("Удалено", timeCell(func(p domain.Payment) *time.Time { return p.DeletedAt })),
 }
 
 const maxPaymentsForExport = 500_000
 
+// PaymentsExportOptions are the options of a payments export.
+type PaymentsExportOptions struct {
+	ExportOptions
+
+	// GroupBy lays the rows out in groups with subtotals, see
+	// PaymentsGroupByDate; empty keeps a flat list.
+	GroupBy string `json:"group_by,omitempty"`
+	// FormatProfile replaces the selected and computed columns with a fixed
+	// layout, see paymentsProfiles.
+	FormatProfile string `json:"format_profile,omitempty"`
+}
+
 type PaymentService struct {
-	repo        PaymentRepository
-	redis       *clients.RedisClient
-	s3          *clients.StorageClient
-	ws          *clients.WebSocketClient
-	cachePrefix string
+	exporter
+
+	repo  PaymentRepository
+	queue *JobQueue
+	// progressEvery is the least time between two generating progress reports
+	progressEvery time.Duration
+	filenameTpl   string
+	links         LinkTemplates
+	templates     TemplateStore
+	names         FilterNameDirectory
 }
 
-func NewPaymentService(repo PaymentRepository, redis *clients.RedisClient, s3 *clients.StorageClient, ws *clients.WebSocketClient) *PaymentService {
-	return &PaymentService{repo: repo, redis: redis, s3: s3, ws: ws, cachePrefix: "pkb_database_cache"}
+func NewPaymentService(repo PaymentRepository, redis Cache, s3 FileStorage, ws Notifier) *PaymentService {
+	return &PaymentService{repo: repo, exporter: newExporter(redis, s3, ws)}
 }
 
-func (s *PaymentService) saveExportStatus(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-	data, err := json.Marshal(st)
-	if err != nil {
-		return err
-	}
-	if err := s.redis.Set(ctx, st.Key, string(data), exportTTL); err != nil {
-		return err
-	}
-	return s.redis.SAdd(ctx, exportSetKey, st.Key)
+// SetRetryPolicy overrides the backoff used for transient DB and storage failures.
+func (s *PaymentService) SetRetryPolicy(p RetryPolicy) {
+	s.retry = p
 }
 
-func (s *PaymentService) saveLaravelCache(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-	cacheKey := s.cachePrefix + st.Key
-	item := ExportCacheItem{
-		Key:      st.Key,
-		Type:     st.Type,
-		UserID:   st.UserID,
-		Progress: st.Progress,
-		FileURL:  st.FileURL,
-		Error:    st.Error,
-		Created:  st.Created.Format("2006-01-02 15:04:05"),
-	}
-	serialized := phpSerializeExportItem(item)
-	return s.redis.Set(ctx, cacheKey, serialized, exportTTL)
+// SetJobRunner makes exports run on the shared worker pool watched for stalls.
+func (s *PaymentService) SetJobRunner(r *JobRunner) {
+	s.jobs = r
 }
 
-func (s *PaymentService) StartPaymentsExport(ctx context.Context, selected []string, filter repository.PaymentsFilter, userID int64) (string, error) {
+// SetJobQueue makes exports run on the instances consuming q instead of the
+// one that started them.
+func (s *PaymentService) SetJobQueue(q *JobQueue) {
+	s.queue = q
+	q.handle(s.runQueued, s.failExport, "payments")
+}
+
+// SetOutbox makes final statuses go through o, which retries failed writes.
+func (s *PaymentService) SetOutbox(o *Outbox) {
+	s.outbox = o
+}
+
+// SetProgressInterval throttles the progress saved and sent while rows are
+// generated to one report per d; the last row is always reported.
+func (s *PaymentService) SetProgressInterval(d time.Duration) {
+	s.progressEvery = d
+}
+
+// SetFilenameTemplate sets the naming template of generated files, see
+// DefaultFilenameTemplate for the placeholders; empty keeps the default.
+func (s *PaymentService) SetFilenameTemplate(tpl string) {
+	s.filenameTpl = tpl
+}
+
+// SetDeliverers enables the delivery types exports may request.
+func (s *PaymentService) SetDeliverers(d Deliverers) {
+	s.deliverers = d
+}
+
+// SetTemplateStore enables rendering exports into XLSX templates.
+func (s *PaymentService) SetTemplateStore(ts TemplateStore) {
+	s.templates = ts
+}
+
+// SetLinkTemplates makes debt cells link back to the CRM, see LinkTemplates.
+func (s *PaymentService) SetLinkTemplates(t LinkTemplates) {
+	s.links = t
+}
+
+// paymentsExportParams is the persisted form of a payments export request.
+type paymentsExportParams struct {
+	Selected []string                  `json:"selected"`
+	Filter   repository.PaymentsFilter `json:"filter"`
+	Options  PaymentsExportOptions     `json:"options"`
+}
+
+func (s *PaymentService) StartPaymentsExport(ctx context.Context, selected []string, filter repository.PaymentsFilter, opts PaymentsExportOptions, userID int64) (string, error) {
 	if len(selected) == 0 {
 		selected = []string{"payment_date", "id", "debt_id", "user_id", "confirmed", "amount", "amount_after_subtraction", "amount_government_duty", "amount_representation_expenses", "amount_notary_fees", "amount_postage", "amount_accounts_receivable", "amount_main_debt", "amount_accrual", "amount_fine", "created_at", "updated_at", "deleted_at"}
 	}
 
-	tooMany, err := s.repo.HasMoreThan(ctx, maxPaymentsForExport, filter)
+	return s.startPaymentsExport(ctx, paymentsExportParams{Selected: selected, Filter: filter, Options: opts}, userID, firstAttempt)
+}
+
+// RetryExport re-runs a failed payments export with its original parameters.
+func (s *PaymentService) RetryExport(ctx context.Context, original *ExportStatus) (string, error) {
+	var params paymentsExportParams
+	if err := json.Unmarshal(original.Params, &params); err != nil {
+		return "", fmt.Errorf("failed to decode export params: %w", err)
+	}
+	return s.startPaymentsExport(ctx, params, original.UserID, nextAttempt(original))
+}
+
+// runQueued runs a payments export taken from the job queue.
+func (s *PaymentService) runQueued(ctx context.Context, status *ExportStatus) {
+	if params, ok := queuedParams[paymentsExportParams](ctx, status, s.failExport); ok {
+		s.runPaymentsExport(ctx, status, params.Selected, params.Filter, params.Options)
+	}
+}
+
+func (s *PaymentService) startPaymentsExport(ctx context.Context, params paymentsExportParams, userID int64, attempt exportAttempt) (string, error) {
+	// masked columns are dropped before anything is recorded
+	params.Options.HiddenColumns = withCallerMasks(ctx, params.Options.HiddenColumns)
+	params.Selected = visibleColumns(params.Selected, params.Options.HiddenColumns)
+
+	if err := s.deliverers.check(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if err := s.deliverers.checkSink(params.Options.ExportOptions); err != nil {
+		return "", err
+	}
+	if s.deliverers.sink(params.Options.Delivery) != nil && (params.Options.GroupBy != "" || params.Options.FormatProfile != "") {
+		return "", fmt.Errorf("%w: a row delivery takes registry columns only, it cannot be combined with group_by or format_profile", ErrInvalidDelivery)
+	}
+	if err := checkTemplate(ctx, s.templates, params.Options.Template); err != nil {
+		return "", err
+	}
+	if err := checkOutput(params.Options.ExportOptions); err != nil {
+		return "", err
+	}
+	if _, err := paymentComputedColumns(params.Options.Computed, params.Options.HiddenColumns); err != nil {
+		return "", err
+	}
+	if params.Options.FormatProfile != "" {
+		if _, err := paymentProfileColumns(params.Options.FormatProfile, params.Options.HiddenColumns); err != nil {
+			return "", err
+		}
+	}
+
+	tooMany, err := s.repo.HasMoreThan(ctx, maxPaymentsForExport, params.Filter)
 	if err != nil {
 		return "", err
 	}
 	if tooMany {
-		return "", fmt.Errorf("слишком много платежей для экспорта (больше %d записей)", maxPaymentsForExport)
+		return "", tooManyRows("платежей", maxPaymentsForExport)
 	}
 
-	exportID := fmt.Sprintf("exports:%s", uuid.NewString())
-	now := time.Now()
-
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "payments",
-		UserID:   userID,
-		Filters:  buildPaymentsFiltersMap(filter, selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  now,
+	filters := s.buildPaymentsFiltersMap(ctx, params.Filter, params.Selected)
+	if params.Options.GroupBy != "" {
+		filters["group_by"] = params.Options.GroupBy
+	}
+	if params.Options.FormatProfile != "" {
+		filters["format_profile"] = params.Options.FormatProfile
 	}
+	status := newExportStatus(ctx, "payments", userID, filters, params, attempt)
+	status.Delivery = newDeliveryStatus(params.Options.Delivery)
+	status.Comment = params.Options.Comment
 
 	_ = s.saveExportStatus(ctx, status)
-	_ = s.saveLaravelCache(ctx, status)
 
-	go s.runPaymentsExport(context.Background(), exportID, selected, filter, userID, now)
+	fail := func(ctx context.Context, errStr string) { s.failExport(ctx, status, errStr) }
+	if err := runJob(ctx, s.jobs, s.queue, status, fail, func(ctx context.Context) {
+		s.runPaymentsExport(ctx, status, params.Selected, params.Filter, params.Options)
+	}); err != nil {
+		return "", err
+	}
 
-	return exportID, nil
+	return status.Key, nil
 }
 
-func (s *PaymentService) runPaymentsExport(ctx context.Context, exportID string, selected []string, filter repository.PaymentsFilter, userID int64, createdAt time.Time) {
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "payments",
-		UserID:   userID,
-		Filters:  buildPaymentsFiltersMap(filter, selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  createdAt,
+// EstimatePaymentsExport counts the rows an export with these parameters would produce.
+func (s *PaymentService) EstimatePaymentsExport(ctx context.Context, selected []string, filter repository.PaymentsFilter) (ExportEstimate, error) {
+	rows, err := s.repo.Count(ctx, filter)
+	if err != nil {
+		return ExportEstimate{}, err
 	}
 
-	payments, err := s.repo.List(ctx, filter)
+	columns := 0
+	for _, key := range visibleColumns(selected, withCallerMasks(ctx, nil)) {
+		if _, ok := paymentColumns[key]; ok {
+			columns++
+		}
+	}
+
+	return newExportEstimate(rows, columns, maxPaymentsForExport), nil
+}
+
+func (s *PaymentService) runPaymentsExport(ctx context.Context, status *ExportStatus, selected []string, filter repository.PaymentsFilter, opts PaymentsExportOptions) {
+	status.enter(ExportRunning)
+	exportID, userID := status.Key, status.UserID
+
+	var payments []domain.Payment
+	err := s.retry.Do(ctx, "export "+exportID+": query", func() error {
+		var err error
+		payments, err = s.repo.List(ctx, filter)
+		return err
+	})
 	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("query failed: %v", err))
 		return
 	}
 
 	var cols []PaymentColumn
-	for _, key := range selected {
+	for _, key := range visibleColumns(selected, opts.HiddenColumns) {
 		col, ok := paymentColumns[key]
 		if !ok {
 			continue
 		}
 		cols = append(cols, col)
 	}
+	computed, err := paymentComputedColumns(opts.Computed, opts.HiddenColumns)
+	if err != nil {
+		s.failExport(ctx, status, err.Error())
+		return
+	}
+	cols = append(cols, computed...)
+	if opts.FormatProfile != "" {
+		if cols, err = paymentProfileColumns(opts.FormatProfile, opts.HiddenColumns); err != nil {
+			s.failExport(ctx, status, err.Error())
+			return
+		}
+	}
 	if len(cols) == 0 {
+		s.failExport(ctx, status, "no valid columns selected")
 		return
 	}
 
@@ -171,28 +302,53 @@ func (s *PaymentService) runPaymentsExport(ctx context.Context, exportID string,
 		_ = f.SetCellValue(sheet, cell, col.Header)
 	}
 
+	var days *paymentDayGroups
+	if opts.GroupBy == PaymentsGroupByDate {
+		sortPaymentsByDay(payments)
+		if days, err = newPaymentDayGroups(f, sheet, cols); err != nil {
+			s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+			return
+		}
+	}
+
 	total := len(payments)
 	rowIdx := 2
 	if total > 0 {
-		chunkSize := 1000
+		pacer := newRowPacer(total, s.progressEvery)
+		w := newSheetWriter(f, sheet, len(cols))
 		for i, p := range payments {
+			if days != nil {
+				rowIdx = days.next(p, rowIdx)
+			}
+			w.row(rowIdx)
 			for colIdx, col := range cols {
-				cell, _ := excelize.CoordinatesToCellName(colIdx+1, rowIdx)
-				_ = f.SetCellValue(sheet, cell, col.Value(p))
+				w.at(colIdx)
+				if col.Write != nil {
+					col.Write(&payments[i], w)
+				} else {
+					w.writeValue(col.Value(p))
+				}
+				if col.Link != nil {
+					if link := col.Link(p, s.links); link != "" {
+						w.hyperlink(link)
+					}
+				}
+			}
+			if days != nil {
+				days.add(p, rowIdx)
 			}
 			rowIdx++
 
-			if (i+1)%chunkSize == 0 || i == total-1 {
-				raw := float64(i+1) / float64(total) * 100.0
-				progress := math.Round(raw)
-				if progress >= 100 {
-					progress = 95
+			if pacer.due(i + 1) {
+				if err := ctx.Err(); err != nil {
+					s.failExport(ctx, status, err.Error())
+					return
 				}
-				status.Progress = progress
+
+				eventCtx := pacer.report(ctx, status, i+1)
 				_ = s.saveExportStatus(ctx, status)
-				_ = s.saveLaravelCache(ctx, status)
 				if s.ws != nil {
-					_ = s.ws.NotifyExportProgress(ctx, userID, exportID, progress, "generating")
+					_ = s.ws.NotifyExportProgress(eventCtx, userID, exportID, status.Progress, "generating")
 				}
 			}
 		}
@@ -205,75 +361,75 @@ func (s *PaymentService) runPaymentsExport(ctx context.Context, exportID string,
 			rowIdx++
 		}
 	}
+	if days != nil {
+		days.finish(rowIdx)
+	}
 
-	buf, err := f.WriteToBuffer()
-	if err != nil {
+	if err := ctx.Err(); err != nil {
+		s.failExport(ctx, status, err.Error())
 		return
 	}
-	data := buf.Bytes()
-
-	fileName := fmt.Sprintf("payments_%s.xlsx", time.Now().Format("20060102_150405"))
-
-	if s.s3 != nil {
-		status.Progress = 95
-		_ = s.saveExportStatus(ctx, status)
-		_ = s.saveLaravelCache(ctx, status)
-		if s.ws != nil {
-			_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 95, "uploading")
-		}
 
-		savedName, err := s.s3.Save(ctx, fileName, data)
-		if err != nil {
-			errStr := fmt.Sprintf("save export failed: %v", err)
-			log.Printf("export %s: %s", exportID, errStr)
-			status.Error = &errStr
-			status.Progress = 100
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
-			if s.ws != nil {
-				_ = s.ws.NotifyExportFailed(ctx, userID, exportID, errStr)
-			}
-		} else {
-			url := s.s3.GetURL(savedName)
-			status.FileURL = &url
-			status.Progress = 100
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
-			if s.ws != nil {
-				_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 100, "ready")
-				_ = s.ws.NotifyExportComplete(ctx, userID, exportID, url, fileName)
-			}
-		}
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = col.Header
 	}
-}
-
-func buildPaymentsFiltersMap(f repository.PaymentsFilter, fields []string) map[string]interface{} {
-	m := map[string]interface{}{}
-	if f.Confirmed != nil {
-		m["confirmed"] = *f.Confirmed
-	} else {
-		m["confirmed"] = nil
+	if err := writeInfoSheet(f, status, headers, len(payments), time.Now()); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
 	}
-	if f.CounterpartyID != nil {
-		m["counterparty_id"] = *f.CounterpartyID
-	} else {
-		m["counterparty_id"] = nil
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
 	}
-	if f.UserID != nil {
-		m["user_id"] = *f.UserID
-	} else {
-		m["user_id"] = nil
+	data, err := applyTemplate(ctx, s.templates, opts.Template, buf.Bytes(), templateVars{Type: "payments", UserID: userID, ExportID: exportID, Comment: opts.Comment})
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("render template failed: %v", err))
+		return
 	}
-	if f.PeriodImportedStartDate != nil {
-		m["period_imported_start_date"] = f.PeriodImportedStartDate.Format("2006-01-02")
-	} else {
-		m["period_imported_start_date"] = nil
+	if data, err = applyOutput(opts.ExportOptions, data, sampleColumns(cols)); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write output failed: %v", err))
+		return
 	}
-	if f.PeriodImportedEndDate != nil {
-		m["period_imported_end_date"] = f.PeriodImportedEndDate.Format("2006-01-02")
-	} else {
-		m["period_imported_end_date"] = nil
+
+	if s.deliverers.sink(opts.ExportOptions.Delivery) != nil {
+		s.sinkExport(ctx, status, opts.ExportOptions, data, sinkColumnNames(selected, paymentColumns, opts.ExportOptions.Computed), sampleColumns(cols))
+		return
 	}
+
+	fileName := exportFilename(s.filenameTpl, opts.ExportOptions, filenameFields{
+		Type:         "payments",
+		UserID:       userID,
+		Counterparty: filter.CounterpartyID,
+		DateFrom:     filter.PeriodImportedStartDate,
+		DateTo:       filter.PeriodImportedEndDate,
+		ExportID:     exportID,
+	})
+
+	s.saveExportFile(ctx, status, opts.ExportOptions, fileName, data)
+}
+
+func (s *PaymentService) buildPaymentsFiltersMap(ctx context.Context, f repository.PaymentsFilter, fields []string) map[string]interface{} {
+	m := repository.PaymentsFilters.Map(f)
+	addFilterNames(ctx, s.names, m, repository.PaymentsFilters.Refs(f))
 	m["fields"] = fields
 	return m
 }
+
+// paymentComputedColumns compiles the computed columns of a payments export over paymentColumns.
+func paymentComputedColumns(defs []ComputedColumn, hidden []string) ([]PaymentColumn, error) {
+	computed, err := compileComputedColumns(defs, func(key string) (func(domain.Payment) any, bool) {
+		col, ok := paymentColumns[key]
+		return col.Value, ok && !columnHidden(hidden, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PaymentColumn, 0, len(computed))
+	for _, c := range computed {
+		out = append(out, PaymentColumn{Header: c.Header, Value: c.Value})
+	}
+	return out, nil
+}
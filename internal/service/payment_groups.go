@@ -0,0 +1,128 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"debtster-export/internal/domain"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// PaymentsGroupByDate groups the rows of a payments export by day of
+// payment_date: every day is an outline group followed by its subtotal row,
+// the sheet ends with the grand total.
+const PaymentsGroupByDate = "payment_date"
+
+// sortPaymentsByDay orders payments by payment_date, payments without a date
+// last; the order within a day is kept.
+func sortPaymentsByDay(payments []domain.Payment) {
+	sort.SliceStable(payments, func(i, j int) bool {
+		a, b := payments[i].PaymentDate, payments[j].PaymentDate
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return paymentDay(a) < paymentDay(b)
+	})
+}
+
+func paymentDay(p *time.Time) string {
+	if p == nil {
+		return ""
+	}
+	return p.Format("2006-01-02")
+}
+
+// paymentDayGroups writes the subtotal rows of a payments export grouped by
+// day. Columns holding amounts (float64 values) are summed, the label goes to
+// the first column that is not.
+type paymentDayGroups struct {
+	f     *excelize.File
+	sheet string
+	cols  []PaymentColumn
+	style int
+
+	summed     []bool
+	day, total []float64
+	current    *time.Time
+	rows       int
+}
+
+func newPaymentDayGroups(f *excelize.File, sheet string, cols []PaymentColumn) (*paymentDayGroups, error) {
+	style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	return &paymentDayGroups{
+		f:      f,
+		sheet:  sheet,
+		cols:   cols,
+		style:  style,
+		summed: make([]bool, len(cols)),
+		day:    make([]float64, len(cols)),
+		total:  make([]float64, len(cols)),
+	}, nil
+}
+
+// next is called before the row of p is written at rowIdx; it closes the
+// previous day when p starts a new one and returns the row for p.
+func (g *paymentDayGroups) next(p domain.Payment, rowIdx int) int {
+	if g.rows > 0 && paymentDay(g.current) != paymentDay(p.PaymentDate) {
+		rowIdx = g.closeDay(rowIdx)
+	}
+	g.current = p.PaymentDate
+	return rowIdx
+}
+
+// add accounts the row of p written at rowIdx to its day.
+func (g *paymentDayGroups) add(p domain.Payment, rowIdx int) {
+	_ = g.f.SetRowOutlineLevel(g.sheet, rowIdx, 1)
+	for i, col := range g.cols {
+		if v, ok := col.Value(p).(float64); ok {
+			g.summed[i] = true
+			g.day[i] += v
+			g.total[i] += v
+		}
+	}
+	g.rows++
+}
+
+// finish closes the last day and writes the grand total; it returns the row
+// after the last one written.
+func (g *paymentDayGroups) finish(rowIdx int) int {
+	if g.rows == 0 {
+		return rowIdx
+	}
+	rowIdx = g.closeDay(rowIdx)
+	g.writeTotal(rowIdx, "Итого", g.total)
+	return rowIdx + 1
+}
+
+func (g *paymentDayGroups) closeDay(rowIdx int) int {
+	label := "Итого без даты"
+	if g.current != nil {
+		label = "Итого за " + g.current.Format("02.01.2006")
+	}
+	g.writeTotal(rowIdx, label, g.day)
+	for i := range g.day {
+		g.day[i] = 0
+	}
+	return rowIdx + 1
+}
+
+func (g *paymentDayGroups) writeTotal(rowIdx int, label string, sums []float64) {
+	labelled := false
+	for i := range g.cols {
+		cell, _ := excelize.CoordinatesToCellName(i+1, rowIdx)
+		switch {
+		case g.summed[i]:
+			_ = g.f.SetCellValue(g.sheet, cell, sums[i])
+		case !labelled:
+			_ = g.f.SetCellValue(g.sheet, cell, label)
+			labelled = true
+		}
+	}
+	first, _ := excelize.CoordinatesToCellName(1, rowIdx)
+	last, _ := excelize.CoordinatesToCellName(len(g.cols), rowIdx)
+	_ = g.f.SetCellStyle(g.sheet, first, last, g.style)
+}
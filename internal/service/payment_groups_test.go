@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service/mocks"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRunPaymentsExport_GroupByDate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deps := newGoldenDeps(t, ctrl)
+	repo := mocks.NewMockPaymentRepository(ctrl)
+
+	day := func(d int) *time.Time {
+		t := time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	repo.EXPECT().List(gomock.Any(), gomock.Any()).Return([]domain.Payment{
+		{ID: "p3", Amount: 30, PaymentDate: day(2)},
+		{ID: "p1", Amount: 10, PaymentDate: day(1)},
+		{ID: "p0", Amount: 5},
+		{ID: "p2", Amount: 20.5, PaymentDate: day(1)},
+	}, nil)
+
+	s := NewPaymentService(repo, deps.cache, deps.storage, deps.ws)
+	opts := PaymentsExportOptions{GroupBy: PaymentsGroupByDate}
+	s.runPaymentsExport(context.Background(), goldenStatus("payments"), []string{"id", "amount"}, repository.PaymentsFilter{}, opts)
+
+	f, err := excelize.OpenReader(bytes.NewReader(deps.file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("Payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// платежи по дням, после каждого дня — подытог, без даты — в конце
+	want := [][]string{
+		{"ID", "Сумма"},
+		{"p1", "10"},
+		{"p2", "20.5"},
+		{"Итого за 01.03.2025", "30.5"},
+		{"p3", "30"},
+		{"Итого за 02.03.2025", "30"},
+		{"p0", "5"},
+		{"Итого без даты", "5"},
+		{"Итого", "65.5"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("unexpected sheet:\n%v\nwant:\n%v", rows, want)
+	}
+
+	// строки платежей сворачиваются, подытоги остаются видимыми
+	for row, level := range map[int]uint8{2: 1, 3: 1, 4: 0, 5: 1, 6: 0, 9: 0} {
+		got, err := f.GetRowOutlineLevel("Payments", row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != level {
+			t.Fatalf("row %d: outline level %d, want %d", row, got, level)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"debtster-export/internal/domain"
+)
+
+// ErrInvalidFormatProfile is returned for a format profile that is not defined.
+var ErrInvalidFormatProfile = errors.New("invalid format profile")
+
+// PaymentsProfile is a fixed layout of a payments export agreed with a
+// counterparty: the columns, their headers and order do not depend on the
+// fields of the request.
+type PaymentsProfile struct {
+	Columns []ProfileColumn
+}
+
+// ProfileColumn is one column of a PaymentsProfile.
+type ProfileColumn struct {
+	Header string
+	// Field is a key of paymentColumns; empty for a constant column.
+	Field string
+	// Format is a time layout for the date fields (see paymentDates) or an
+	// fmt verb for amounts, e.g. "%.2f"; empty keeps the value as exported.
+	Format string
+	// Const is the value of every cell of a column without Field.
+	Const string
+}
+
+// paymentsProfiles are the layouts format_profile may request. A new
+// counterparty layout only needs an entry here.
+var paymentsProfiles = map[string]PaymentsProfile{
+	// 12-column reconciliation register of the bank
+	"bank_reconciliation": {Columns: []ProfileColumn{
+		{Header: "Дата платежа", Field: "payment_date", Format: "02.01.2006"},
+		{Header: "Референс платежа", Field: "id"},
+		{Header: "Идентификатор договора", Field: "debt_id"},
+		{Header: "Сумма платежа", Field: "amount", Format: "%.2f"},
+		{Header: "Сумма после удержания", Field: "amount_after_subtraction", Format: "%.2f"},
+		{Header: "Основной долг", Field: "amount_main_debt", Format: "%.2f"},
+		{Header: "Вознаграждение", Field: "amount_accrual", Format: "%.2f"},
+		{Header: "Пеня", Field: "amount_fine", Format: "%.2f"},
+		{Header: "Госпошлина", Field: "amount_government_duty", Format: "%.2f"},
+		{Header: "Валюта", Const: "KZT"},
+		{Header: "Назначение платежа", Const: "Погашение задолженности"},
+		{Header: "Дата загрузки", Field: "created_at", Format: "02.01.2006 15:04:05"},
+	}},
+}
+
+// paymentDates are the date fields of a payment, which profiles format
+// themselves instead of the fixed layout of paymentColumns.
+var paymentDates = map[string]func(p domain.Payment) *time.Time{
+	"payment_date": func(p domain.Payment) *time.Time { return p.PaymentDate },
+	"created_at":   func(p domain.Payment) *time.Time { return p.CreatedAt },
+	"updated_at":   func(p domain.Payment) *time.Time { return p.UpdatedAt },
+	"deleted_at":   func(p domain.Payment) *time.Time { return p.DeletedAt },
+}
+
+// PaymentsProfileNames lists the defined format profiles, sorted.
+func PaymentsProfileNames() []string {
+	names := make([]string, 0, len(paymentsProfiles))
+	for name := range paymentsProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paymentProfileColumns builds the columns of the named profile. Hidden
+// fields keep their column, empty, so the layout stays fixed.
+func paymentProfileColumns(name string, hidden []string) ([]PaymentColumn, error) {
+	profile, ok := paymentsProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q, expected one of %s", ErrInvalidFormatProfile, name, strings.Join(PaymentsProfileNames(), ", "))
+	}
+
+	cols := make([]PaymentColumn, 0, len(profile.Columns))
+	for _, pc := range profile.Columns {
+		col := PaymentColumn{Header: pc.Header}
+		switch {
+		case pc.Field == "":
+			value := pc.Const
+			col.Value = func(domain.Payment) any { return value }
+		case columnHidden(hidden, pc.Field):
+			col.Value = func(domain.Payment) any { return "" }
+		case paymentDates[pc.Field] != nil && pc.Format != "":
+			date, layout := paymentDates[pc.Field], pc.Format
+			col.Value = func(p domain.Payment) any {
+				if t := date(p); t != nil {
+					return t.Format(layout)
+				}
+				return ""
+			}
+		default:
+			src, ok := paymentColumns[pc.Field]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q: unknown field %q", ErrInvalidFormatProfile, name, pc.Field)
+			}
+			col.Value, col.Write = src.Value, src.Write
+			if pc.Format != "" {
+				format := pc.Format
+				col.Value = func(p domain.Payment) any { return fmt.Sprintf(format, src.Value(p)) }
+				col.Write = nil
+			}
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
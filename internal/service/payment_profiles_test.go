@@ -0,0 +1,70 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service/mocks"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPaymentsProfiles(t *testing.T) {
+	// все поля профилей существуют, иначе экспорт упадёт только в рантайме
+	for _, name := range PaymentsProfileNames() {
+		if _, err := paymentProfileColumns(name, nil); err != nil {
+			t.Fatalf("profile %s: %v", name, err)
+		}
+	}
+	if cols, _ := paymentProfileColumns("bank_reconciliation", nil); len(cols) != 12 {
+		t.Fatalf("bank layout has %d columns, want 12", len(cols))
+	}
+	if _, err := paymentProfileColumns("nope", nil); !errors.Is(err, ErrInvalidFormatProfile) {
+		t.Fatalf("expected ErrInvalidFormatProfile, got %v", err)
+	}
+}
+
+func TestRunPaymentsExport_BankReconciliation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	deps := newGoldenDeps(t, ctrl)
+	repo := mocks.NewMockPaymentRepository(ctrl)
+
+	paid := time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC)
+	created := time.Date(2025, 3, 6, 9, 30, 0, 0, time.UTC)
+	repo.EXPECT().List(gomock.Any(), gomock.Any()).Return([]domain.Payment{{
+		ID:                   "p1",
+		DebtID:               "d1",
+		Amount:               1500,
+		AmountMainDebt:       1000.5,
+		AmountGovernmentDuty: 12.345,
+		PaymentDate:          &paid,
+		CreatedAt:            &created,
+	}}, nil)
+
+	s := NewPaymentService(repo, deps.cache, deps.storage, deps.ws)
+	opts := PaymentsExportOptions{FormatProfile: "bank_reconciliation"}
+	opts.HiddenColumns = []string{"debt_id"}
+	s.runPaymentsExport(context.Background(), goldenStatus("payments"), []string{"amount"}, repository.PaymentsFilter{}, opts)
+
+	f, err := excelize.OpenReader(bytes.NewReader(deps.file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("Payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// скрытое поле остаётся пустой колонкой, раскладка не меняется
+	want := []string{"05.03.2025", "p1", "", "1500.00", "0.00", "1000.50", "0.00", "0.00", "12.35", "KZT", "Погашение задолженности", "06.03.2025 09:30:00"}
+	if len(rows) != 2 || rows[0][0] != "Дата платежа" || !reflect.DeepEqual(rows[1], want) {
+		t.Fatalf("unexpected sheet: %v", rows)
+	}
+}
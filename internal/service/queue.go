@@ -0,0 +1,397 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"debtster-export/internal/requestid"
+	"debtster-export/internal/tenant"
+)
+
+// QueueStore keeps the jobs of a JobQueue. Implemented by *clients.RedisQueue.
+type QueueStore interface {
+	Push(ctx context.Context, id, payload string, runAt time.Time) error
+	Claim(ctx context.Context, lease time.Duration) (id, payload string, attempts int, err error)
+	Extend(ctx context.Context, id string, attempt int, lease time.Duration) (bool, error)
+	Complete(ctx context.Context, id string, attempt int) (bool, error)
+	Ack(ctx context.Context, id string) error
+	Remove(ctx context.Context, id string) (bool, error)
+	Expired(ctx context.Context) ([]string, error)
+	Reclaim(ctx context.Context, id string, maxAttempts int, backoff, hold time.Duration) (payload string, attempts int, requeued, ok bool, err error)
+	Stats(ctx context.Context) (pending, scheduled, active int64, err error)
+}
+
+// Defaults of a JobQueue.
+const (
+	defaultQueueLease       = time.Minute
+	defaultQueueMaxAttempts = 3
+	// queuePoll is how often an idle consumer looks for due jobs
+	queuePoll = time.Second
+	// queueBackoff is the delay before an export lost once runs again; it
+	// grows by as much with every lost attempt
+	queueBackoff = 30 * time.Second
+)
+
+// queuedJob is the payload of a queued export: its record as it was at
+// start, which holds the parameters, and its tenant. The record in Redis, when
+// it has not expired yet, is newer and wins.
+type queuedJob struct {
+	Tenant string        `json:"tenant,omitempty"`
+	Status *ExportStatus `json:"status"`
+}
+
+// queueHandler runs the exports of one type from their records.
+type queueHandler struct {
+	run  func(ctx context.Context, status *ExportStatus)
+	fail func(ctx context.Context, status *ExportStatus, errStr string)
+}
+
+// QueueStats is the state of the job queue for diagnostics.
+type QueueStats struct {
+	Pending   int64 `json:"pending"`
+	Scheduled int64 `json:"scheduled"`
+	Active    int64 `json:"active"`
+	// counters of this instance since start
+	Enqueued  int64 `json:"enqueued"`
+	Claimed   int64 `json:"claimed"`
+	Requeued  int64 `json:"requeued"`
+	GivenUp   int64 `json:"given_up"`
+	Cancelled int64 `json:"cancelled"`
+}
+
+// JobQueue hands exports over to the instances consuming it instead of
+// running them where they were started. The consumer runs a claimed export on
+// its JobRunner (pool, lanes, watchdog and cancellation stay local) and keeps
+// a lease on it while it runs; the export of an instance that died is pushed
+// again when its lease runs out, with backoff, up to the attempt limit. An
+// instance that finds its lease lost (it stalled while another took the
+// export over) stops its run, so an export runs on one instance at a time.
+//
+// Exports of a batch are not queued: the batch folds their progress in the
+// memory of the instance that started it.
+type JobQueue struct {
+	store    QueueStore
+	redis    Cache
+	jobs     *JobRunner
+	tenants  func(id string) (tenant.Tenant, bool)
+	handlers map[string]queueHandler
+
+	lease       time.Duration
+	maxAttempts int
+
+	mu    sync.Mutex
+	stats QueueStats
+}
+
+// NewJobQueue creates a queue of store whose export records are read from
+// redis and whose claimed exports run on jobs.
+func NewJobQueue(store QueueStore, redis Cache, jobs *JobRunner) *JobQueue {
+	return &JobQueue{
+		store:       store,
+		redis:       redis,
+		jobs:        jobs,
+		handlers:    map[string]queueHandler{},
+		lease:       defaultQueueLease,
+		maxAttempts: defaultQueueMaxAttempts,
+	}
+}
+
+// SetLease changes how long a claimed export stays with an instance that
+// stopped prolonging it; it is prolonged every lease/3.
+func (q *JobQueue) SetLease(d time.Duration) {
+	q.lease = d
+}
+
+// SetMaxAttempts changes how many times an export is claimed before a lost
+// one is failed instead of pushed again.
+func (q *JobQueue) SetMaxAttempts(n int) {
+	q.maxAttempts = n
+}
+
+// SetTenants resolves the tenant of a queued export, so it runs against the
+// database and Redis namespace of its tenant.
+func (q *JobQueue) SetTenants(get func(id string) (tenant.Tenant, bool)) {
+	q.tenants = get
+}
+
+// handle makes the queue run the exports of exportTypes with run; fail
+// records an export the queue gives up on.
+func (q *JobQueue) handle(run func(ctx context.Context, status *ExportStatus), fail func(ctx context.Context, status *ExportStatus, errStr string), exportTypes ...string) {
+	if q == nil {
+		return
+	}
+	for _, exportType := range exportTypes {
+		q.handlers[exportType] = queueHandler{run: run, fail: fail}
+	}
+}
+
+// Enqueue queues the export of status, due at once.
+func (q *JobQueue) Enqueue(ctx context.Context, status *ExportStatus) error {
+	job := queuedJob{Tenant: tenantID(ctx), Status: status}
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := q.store.Push(ctx, jobID(job.Tenant, status.Key), string(payload), time.Now()); err != nil {
+		return fmt.Errorf("queue export: %w", err)
+	}
+	q.count(func(st *QueueStats) { st.Enqueued++ })
+	return nil
+}
+
+// jobID keeps the queue ids of tenants apart; export keys are only unique
+// within the Redis namespace of a tenant.
+func jobID(tenantID, key string) string {
+	if tenantID == "" {
+		return key
+	}
+	return tenantID + "/" + key
+}
+
+func tenantID(ctx context.Context) string {
+	if t, ok := tenant.FromContext(ctx); ok {
+		return t.ID
+	}
+	return ""
+}
+
+// Cancel removes the export of status if it is still queued and records it
+// as cancelled; false means it is not queued (claimed already or unknown).
+func (q *JobQueue) Cancel(ctx context.Context, status *ExportStatus) (bool, error) {
+	if q == nil {
+		return false, nil
+	}
+	removed, err := q.store.Remove(ctx, jobID(tenantID(ctx), status.Key))
+	if err != nil || !removed {
+		return false, err
+	}
+	q.count(func(st *QueueStats) { st.Cancelled++ })
+
+	if h, ok := q.handlers[status.Type]; ok {
+		// recorded the way a cancelled running export is
+		cancelled, cancel := context.WithCancelCause(ctx)
+		cancel(ErrExportCancelled)
+		h.fail(cancelled, status, ErrExportCancelled.Error())
+	}
+	return true, nil
+}
+
+// Remove drops the export key if it is still queued, recording nothing: the
+// export is being deleted.
+func (q *JobQueue) Remove(ctx context.Context, key string) (bool, error) {
+	if q == nil {
+		return false, nil
+	}
+	return q.store.Remove(ctx, jobID(tenantID(ctx), key))
+}
+
+// Stats returns the queue sizes and the counters of this instance.
+func (q *JobQueue) Stats(ctx context.Context) (QueueStats, error) {
+	q.mu.Lock()
+	st := q.stats
+	q.mu.Unlock()
+
+	var err error
+	st.Pending, st.Scheduled, st.Active, err = q.store.Stats(ctx)
+	return st, err
+}
+
+func (q *JobQueue) count(fn func(st *QueueStats)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(&q.stats)
+}
+
+// Run consumes the queue until ctx is done: whenever no job waits for a slot
+// of the JobRunner it claims the next due export and starts it.
+func (q *JobQueue) Run(ctx context.Context) {
+	for {
+		claimed := false
+		if q.jobs.Idle() {
+			id, payload, attempts, err := q.store.Claim(ctx, q.lease)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				log.Printf("[QUEUE] claim: %v", err)
+			case id != "":
+				claimed = true
+				q.count(func(st *QueueStats) { st.Claimed++ })
+				q.start(ctx, id, payload, attempts)
+			}
+		}
+		if claimed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(queuePoll):
+		}
+	}
+}
+
+// start runs a claimed export; an export that cannot run is acknowledged so
+// it is not claimed again.
+func (q *JobQueue) start(runCtx context.Context, id, payload string, attempts int) {
+	ctx, h, status, err := q.load(context.WithoutCancel(runCtx), payload)
+	if err != nil {
+		log.Printf("[QUEUE] job %s dropped: %v", id, err)
+		_ = q.store.Ack(runCtx, id)
+		return
+	}
+	if status.FileURL != nil || status.Error != nil {
+		// finished by an earlier attempt that lost its lease at the very end
+		_ = q.store.Ack(ctx, id)
+		return
+	}
+	requestid.Logf(ctx, "[QUEUE] export %s claimed (attempt %d)", status.Key, attempts)
+
+	fail := func(ctx context.Context, errStr string) {
+		h.fail(ctx, status, errStr)
+		_, _ = q.store.Complete(context.WithoutCancel(ctx), id, attempts)
+	}
+	q.jobs.Go(ctx, status.Key, status.Type, fail, func(ctx context.Context) {
+		h.run(ctx, status)
+		_, _ = q.store.Complete(context.WithoutCancel(ctx), id, attempts)
+	})
+	go q.holdLease(runCtx, id, status.Key, attempts)
+}
+
+// holdLease prolongs the lease of claim attempt of an export while it is
+// queued or running on this instance, and stops the export once the lease
+// turns out lost.
+func (q *JobQueue) holdLease(ctx context.Context, id, key string, attempt int) {
+	ticker := time.NewTicker(q.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !q.jobs.Running(key) {
+			return
+		}
+		if held, err := q.store.Extend(ctx, id, attempt, q.lease); err == nil && !held {
+			log.Printf("[QUEUE] job %s lost its lease (attempt %d), stopping it", id, attempt)
+			q.jobs.abandon(key)
+			return
+		}
+	}
+}
+
+// load decodes a queued export and returns it with the context it runs in:
+// the tenant and the request id of the start request, as a job started
+// in-process inherits them.
+func (q *JobQueue) load(ctx context.Context, payload string) (context.Context, queueHandler, *ExportStatus, error) {
+	var job queuedJob
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		return ctx, queueHandler{}, nil, fmt.Errorf("decode job: %w", err)
+	}
+	if job.Status == nil {
+		return ctx, queueHandler{}, nil, errors.New("job has no export")
+	}
+	status := job.Status
+	h, ok := q.handlers[status.Type]
+	if !ok {
+		return ctx, h, nil, fmt.Errorf("no handler for export type %q", status.Type)
+	}
+	if job.Tenant != "" {
+		var t tenant.Tenant
+		if q.tenants != nil {
+			t, ok = q.tenants(job.Tenant)
+		}
+		if !ok {
+			return ctx, h, nil, fmt.Errorf("unknown tenant %q", job.Tenant)
+		}
+		ctx = tenant.WithTenant(ctx, t)
+	}
+	if status.RequestID != "" {
+		ctx = requestid.WithID(ctx, status.RequestID)
+	}
+
+	if raw, err := q.redis.Get(ctx, status.Key); err == nil {
+		var current ExportStatus
+		if err := json.Unmarshal([]byte(raw), &current); err == nil {
+			status = &current
+		}
+	}
+	return ctx, h, status, nil
+}
+
+// Recover is the janitor pass over lost exports: a claimed export whose lease
+// ran out is queued again after a backoff, or failed once it used up its
+// attempts. Reclaiming and queueing again is one step of the store, so a
+// janitor dying halfway loses no export. It returns how many exports it
+// handled.
+func (q *JobQueue) Recover(ctx context.Context) (int, error) {
+	if q == nil {
+		return 0, nil
+	}
+	ids, err := q.store.Expired(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		payload, attempts, requeued, ok, err := q.store.Reclaim(ctx, id, q.maxAttempts, queueBackoff, q.lease)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+		n++
+
+		if requeued {
+			q.count(func(st *QueueStats) { st.Requeued++ })
+			log.Printf("[QUEUE] job %s lost its worker, queued again (attempt %d of %d)", id, attempts, q.maxAttempts)
+			continue
+		}
+
+		// the export is held for a lease: if this instance dies before the
+		// ack, the next pass gives up on it again
+		q.count(func(st *QueueStats) { st.GivenUp++ })
+		if jobCtx, h, status, err := q.load(ctx, payload); err == nil {
+			h.fail(jobCtx, status, fmt.Sprintf("export worker lost %d times, giving up", attempts))
+		}
+		if err := q.store.Ack(ctx, id); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// queuedParams decodes the parameters of a queued export; an export whose
+// parameters cannot be decoded is failed.
+func queuedParams[T any](ctx context.Context, status *ExportStatus, fail func(ctx context.Context, status *ExportStatus, errStr string)) (T, bool) {
+	var params T
+	if err := json.Unmarshal(status.Params, &params); err != nil {
+		fail(ctx, status, fmt.Sprintf("failed to decode export params: %v", err))
+		return params, false
+	}
+	return params, true
+}
+
+// runJob starts fn for the export of status: through the queue when one is
+// set, on this instance's JobRunner otherwise. Members of a batch always run
+// here, the batch folding their events lives in this instance's memory; api
+// instances, which run no exports, do not take batches. An export that cannot
+// be queued is recorded as failed.
+func runJob(ctx context.Context, jobs *JobRunner, queue *JobQueue, status *ExportStatus, fail func(ctx context.Context, errStr string), fn func(ctx context.Context)) error {
+	if queue == nil || exportBatchFromContext(ctx) != nil {
+		jobs.Go(context.WithoutCancel(ctx), status.Key, status.Type, fail, fn)
+		return nil
+	}
+	if err := queue.Enqueue(ctx, status); err != nil {
+		fail(ctx, err.Error())
+		return err
+	}
+	return nil
+}
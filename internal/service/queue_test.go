@@ -0,0 +1,476 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"debtster-export/internal/service/mocks"
+	"debtster-export/internal/tenant"
+
+	"go.uber.org/mock/gomock"
+)
+
+// memQueue is a QueueStore in memory.
+type memQueue struct {
+	mu       sync.Mutex
+	payloads map[string]string
+	pending  map[string]time.Time
+	active   map[string]time.Time
+	attempts map[string]int
+}
+
+func newMemQueue() *memQueue {
+	return &memQueue{payloads: map[string]string{}, pending: map[string]time.Time{}, active: map[string]time.Time{}, attempts: map[string]int{}}
+}
+
+func (m *memQueue) Push(_ context.Context, id, payload string, runAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.payloads[id] = payload
+	m.pending[id] = runAt
+	return nil
+}
+
+func (m *memQueue) Claim(_ context.Context, lease time.Duration) (string, string, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, runAt := range m.pending {
+		if runAt.After(time.Now()) {
+			continue
+		}
+		delete(m.pending, id)
+		m.active[id] = time.Now().Add(lease)
+		m.attempts[id]++
+		return id, m.payloads[id], m.attempts[id], nil
+	}
+	return "", "", 0, nil
+}
+
+func (m *memQueue) Extend(_ context.Context, id string, attempt int, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.active[id]; !ok || m.attempts[id] != attempt {
+		return false, nil
+	}
+	m.active[id] = time.Now().Add(lease)
+	return true, nil
+}
+
+func (m *memQueue) Complete(_ context.Context, id string, attempt int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.active[id]; !ok || m.attempts[id] != attempt {
+		return false, nil
+	}
+	delete(m.payloads, id)
+	delete(m.pending, id)
+	delete(m.active, id)
+	delete(m.attempts, id)
+	return true, nil
+}
+
+func (m *memQueue) Ack(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.payloads, id)
+	delete(m.pending, id)
+	delete(m.active, id)
+	delete(m.attempts, id)
+	return nil
+}
+
+func (m *memQueue) Remove(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pending[id]; !ok {
+		return false, nil
+	}
+	delete(m.pending, id)
+	delete(m.payloads, id)
+	delete(m.attempts, id)
+	return true, nil
+}
+
+func (m *memQueue) Expired(context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id, deadline := range m.active {
+		if !deadline.After(time.Now()) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memQueue) Reclaim(_ context.Context, id string, maxAttempts int, backoff, hold time.Duration) (string, int, bool, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deadline, ok := m.active[id]
+	if !ok || deadline.After(time.Now()) {
+		return "", 0, false, false, nil
+	}
+	attempts := m.attempts[id]
+	if attempts < maxAttempts {
+		delete(m.active, id)
+		m.pending[id] = time.Now().Add(time.Duration(attempts) * backoff)
+		return m.payloads[id], attempts, true, true, nil
+	}
+	m.attempts[id]++
+	m.active[id] = time.Now().Add(hold)
+	return m.payloads[id], attempts, false, true, nil
+}
+
+func (m *memQueue) Stats(context.Context) (pending, scheduled, active int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, runAt := range m.pending {
+		if runAt.After(time.Now()) {
+			scheduled++
+		} else {
+			pending++
+		}
+	}
+	return pending, scheduled, int64(len(m.active)), nil
+}
+
+// makeDue переносит отложенные задачи на сейчас
+func (m *memQueue) makeDue() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.pending {
+		m.pending[id] = time.Now()
+	}
+}
+
+// newTestJobQueue возвращает очередь без записей экспортов в Redis: задачи
+// выполняются по статусу из самой задачи.
+func newTestJobQueue(t *testing.T, store QueueStore) *JobQueue {
+	ctrl := gomock.NewController(t)
+	cache := mocks.NewMockCache(ctrl)
+	cache.EXPECT().Get(gomock.Any(), gomock.Any()).Return("", errors.New("redis: nil")).AnyTimes()
+
+	q := NewJobQueue(store, cache, NewJobRunner(2))
+	q.SetTenants(tenant.NewRegistry(map[string]string{"acme": "acme"}).Get)
+	return q
+}
+
+func TestJobQueue_RunsQueuedExport(t *testing.T) {
+	store := newMemQueue()
+	q := newTestJobQueue(t, store)
+
+	ran := make(chan context.Context, 1)
+	q.handle(func(ctx context.Context, status *ExportStatus) {
+		ran <- ctx
+	}, func(context.Context, *ExportStatus, string) {
+		t.Error("the export must not fail")
+	}, "debts")
+
+	// экспорт ставит в очередь один экземпляр, выполняет другой — в контексте тенанта
+	t1, _ := tenant.NewRegistry(map[string]string{"acme": "acme"}).Get("acme")
+	status := testDebtStatus()
+	status.RequestID = "req-1"
+	if err := q.Enqueue(tenant.WithTenant(context.Background(), t1), status); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.pending["acme/"+status.Key]; !ok {
+		t.Fatalf("queued jobs: %v", store.pending)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	select {
+	case jobCtx := <-ran:
+		if got, _ := tenant.FromContext(jobCtx); got.ID != "acme" {
+			t.Fatalf("tenant = %q", got.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued export never ran")
+	}
+
+	// после выполнения задача снимается с очереди
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, scheduled, active, _ := store.Stats(ctx)
+		if pending+scheduled+active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job left in the queue: %d pending, %d active", pending, active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st, _ := q.Stats(ctx); st.Enqueued != 1 || st.Claimed != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestJobQueue_RecoverRequeuesLostExports(t *testing.T) {
+	store := newMemQueue()
+	q := newTestJobQueue(t, store)
+	q.SetMaxAttempts(2)
+
+	var failed []string
+	q.handle(func(context.Context, *ExportStatus) {}, func(_ context.Context, _ *ExportStatus, errStr string) {
+		failed = append(failed, errStr)
+	}, "debts")
+
+	ctx := context.Background()
+	status := testDebtStatus()
+	if err := q.Enqueue(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+
+	// экземпляр взял задачу и умер: аренда истекла, задача возвращается в очередь с задержкой
+	if id, _, _, _ := store.Claim(ctx, -time.Second); id != status.Key {
+		t.Fatalf("claimed %q", id)
+	}
+	if n, err := q.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("recover = %d, %v", n, err)
+	}
+	if pending, scheduled, _, _ := store.Stats(ctx); pending != 0 || scheduled != 1 {
+		t.Fatalf("requeued job: %d pending, %d scheduled", pending, scheduled)
+	}
+
+	// вторая потеря исчерпывает попытки: экспорт помечается упавшим
+	store.makeDue()
+	if _, _, attempts, _ := store.Claim(ctx, -time.Second); attempts != 2 {
+		t.Fatalf("attempts = %d", attempts)
+	}
+	if n, err := q.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("recover = %d, %v", n, err)
+	}
+	if len(failed) != 1 || !strings.Contains(failed[0], "lost 2 times") {
+		t.Fatalf("failed with %q", failed)
+	}
+	if len(store.payloads) != 0 {
+		t.Fatalf("job left in the queue: %v", store.payloads)
+	}
+	if st, _ := q.Stats(ctx); st.Requeued != 1 || st.GivenUp != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+// dyingQueue is a store whose janitor dies after reclaiming: every other
+// write fails.
+type dyingQueue struct {
+	*memQueue
+}
+
+var errJanitorDied = errors.New("janitor died")
+
+func (d dyingQueue) Push(context.Context, string, string, time.Time) error { return errJanitorDied }
+func (d dyingQueue) Ack(context.Context, string) error                     { return errJanitorDied }
+
+func TestJobQueue_RecoverSurvivesJanitorCrash(t *testing.T) {
+	store := newMemQueue()
+	var failed int
+	survivor := newTestJobQueue(t, store)
+	survivor.SetMaxAttempts(2)
+	survivor.handle(func(context.Context, *ExportStatus) {}, func(context.Context, *ExportStatus, string) { failed++ }, "debts")
+
+	dying := newTestJobQueue(t, dyingQueue{store})
+	dying.SetMaxAttempts(2)
+	dying.SetLease(50 * time.Millisecond)
+	dying.handle(func(context.Context, *ExportStatus) {}, func(context.Context, *ExportStatus, string) {}, "debts")
+
+	ctx := context.Background()
+	if err := survivor.Enqueue(ctx, testDebtStatus()); err != nil {
+		t.Fatal(err)
+	}
+
+	// janitor умирает сразу после reclaim: задача уже снова в очереди
+	if id, _, _, _ := store.Claim(ctx, -time.Second); id == "" {
+		t.Fatal("job not claimed")
+	}
+	if n, err := dying.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("recover = %d, %v", n, err)
+	}
+	if pending, scheduled, active, _ := store.Stats(ctx); pending+scheduled != 1 || active != 0 {
+		t.Fatalf("lost job: %d pending, %d scheduled, %d active", pending, scheduled, active)
+	}
+
+	// попытки исчерпаны, janitor умирает до ack: задача остаётся за ним на
+	// время аренды, а следующий проход снова от неё отказывается
+	store.makeDue()
+	_, _, _, _ = store.Claim(ctx, -time.Second)
+	if _, err := dying.Recover(ctx); !errors.Is(err, errJanitorDied) {
+		t.Fatalf("recover = %v", err)
+	}
+	if expired, _ := store.Expired(ctx); len(expired) != 0 || len(store.active) != 1 {
+		t.Fatalf("job not held: expired %v, active %v", expired, store.active)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if n, err := survivor.Recover(ctx); err != nil || n != 1 || failed != 1 {
+		t.Fatalf("recover = %d, %v, failed %d times", n, err, failed)
+	}
+	if len(store.payloads) != 0 {
+		t.Fatalf("job left in the queue: %v", store.payloads)
+	}
+}
+
+func TestJobQueue_CancelQueuedExport(t *testing.T) {
+	store := newMemQueue()
+	q := newTestJobQueue(t, store)
+
+	var cause error
+	q.handle(func(context.Context, *ExportStatus) {}, func(ctx context.Context, _ *ExportStatus, _ string) {
+		cause = context.Cause(ctx)
+	}, "debts")
+
+	ctx := context.Background()
+	status := testDebtStatus()
+	if err := q.Enqueue(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := q.Cancel(ctx, status); err != nil || !ok {
+		t.Fatalf("cancel = %t, %v", ok, err)
+	}
+	if !errors.Is(cause, ErrExportCancelled) {
+		t.Fatalf("recorded cause %v", cause)
+	}
+
+	// взятую задачу отменяет только выполняющий её экземпляр
+	if err := q.Enqueue(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _ = store.Claim(ctx, time.Minute)
+	if ok, _ := q.Cancel(ctx, status); ok {
+		t.Fatal("a claimed export was cancelled through the queue")
+	}
+}
+
+// takeOver делает то, что janitor и другой экземпляр делают с задачей,
+// аренда которой истекла: задача снова взята, уже следующей попыткой.
+func (m *memQueue) takeOver(id string, lease time.Duration) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[id]++
+	m.active[id] = time.Now().Add(lease)
+	return m.attempts[id]
+}
+
+func TestJobQueue_RedeliversExportOfCrashedInstance(t *testing.T) {
+	store := newMemQueue()
+	crashed := newTestJobQueue(t, store)
+	crashed.handle(func(context.Context, *ExportStatus) {}, func(context.Context, *ExportStatus, string) {}, "debts")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := testDebtStatus()
+	if err := crashed.Enqueue(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+	// экземпляр взял задачу и упал, не продлив аренду
+	if id, _, _, _ := store.Claim(ctx, -time.Second); id != status.Key {
+		t.Fatalf("claimed %q", id)
+	}
+
+	survivor := newTestJobQueue(t, store)
+	var runs int
+	ran := make(chan struct{}, 2)
+	survivor.handle(func(context.Context, *ExportStatus) {
+		runs++
+		ran <- struct{}{}
+	}, func(context.Context, *ExportStatus, string) {
+		t.Error("the export must not fail")
+	}, "debts")
+
+	// задача возвращается в очередь и выполняется уцелевшим экземпляром
+	if n, err := survivor.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("recover = %d, %v", n, err)
+	}
+	store.makeDue()
+	go survivor.Run(ctx)
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lost export was never delivered again")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, scheduled, active, _ := store.Stats(ctx)
+		if pending+scheduled+active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job left in the queue: %d pending, %d scheduled, %d active", pending, scheduled, active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// повторно уже не выполняется
+	if n, _ := survivor.Recover(ctx); n != 0 || runs != 1 {
+		t.Fatalf("recovered %d, ran %d times", n, runs)
+	}
+}
+
+func TestJobQueue_StaleHolderStopsAfterTakeover(t *testing.T) {
+	store := newMemQueue()
+	q := newTestJobQueue(t, store)
+	q.SetLease(60 * time.Millisecond)
+
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	q.handle(func(ctx context.Context, status *ExportStatus) {
+		close(started)
+		<-ctx.Done()
+		stopped <- context.Cause(ctx)
+	}, func(context.Context, *ExportStatus, string) {
+		t.Error("the taken over export must not be failed here")
+	}, "debts")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := testDebtStatus()
+	if err := q.Enqueue(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+	go q.Run(ctx)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the export never started")
+	}
+
+	// экземпляр «завис», задачу забрал другой: зависший узнаёт об этом при
+	// продлении аренды и останавливает свой запуск
+	attempt := store.takeOver(status.Key, time.Minute)
+	select {
+	case cause := <-stopped:
+		if !errors.Is(cause, errLeaseLost) {
+			t.Fatalf("stopped with %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stale run was not stopped")
+	}
+	if reason, report := abortReason(stoppedCtx(errLeaseLost)); report {
+		t.Fatalf("a taken over export would be recorded as %q", reason)
+	}
+
+	// завершение устаревшего запуска не снимает задачу нового владельца
+	time.Sleep(50 * time.Millisecond)
+	if ok, _ := store.Extend(ctx, status.Key, attempt, time.Minute); !ok {
+		t.Fatal("the new holder lost its claim")
+	}
+	if ok, _ := store.Complete(ctx, status.Key, attempt-1); ok {
+		t.Fatal("the stale holder completed the new claim")
+	}
+	if ok, _ := store.Complete(ctx, status.Key, attempt); !ok {
+		t.Fatal("the new holder cannot complete its claim")
+	}
+}
+
+// stoppedCtx returns a context cancelled with cause.
+func stoppedCtx(cause error) context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(cause)
+	return ctx
+}
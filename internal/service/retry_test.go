@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	// задержка удваивается и упирается в MaxDelay
+	for n, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		60: time.Second,
+	} {
+		if got := p.delay(n); got != want {
+			t.Errorf("delay(%d) = %s, want %s", n, got, want)
+		}
+	}
+
+	// база больше потолка и потолок без ограничения
+	if got := (RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Second}).delay(1); got != time.Second {
+		t.Errorf("base over cap: %s", got)
+	}
+	if got := (RetryPolicy{BaseDelay: time.Second}).delay(4); got != 8*time.Second {
+		t.Errorf("no cap: %s", got)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	pg := func(code string) error {
+		return fmt.Errorf("query: %w", &pgconn.PgError{Code: code})
+	}
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"bad conn", driver.ErrBadConn, true},
+		{"unexpected eof", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, true},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"net timeout", timeout, true},
+		{"wrapped net timeout", fmt.Errorf("upload: %w", timeout), true},
+		{"connection_exception", pg("08006"), true},
+		{"connection_failure", pg("08001"), true},
+		{"serialization_failure", pg("40001"), true},
+		{"deadlock_detected", pg("40P01"), true},
+		{"too_many_connections", pg("53300"), true},
+		{"admin_shutdown", pg("57P01"), true},
+		{"cannot_connect_now", pg("57P03"), true},
+		{"undefined_column", pg("42703"), false},
+		{"query_canceled", pg("57014"), false},
+		{"unique_violation", pg("23505"), false},
+		// отмена и дедлайн экспорта не повторяются
+		{"canceled", context.Canceled, false},
+		{"wrapped canceled", fmt.Errorf("query: %w", context.Canceled), false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"plain error", errors.New("invalid input"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	p := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	transient := fmt.Errorf("query: %w", &pgconn.PgError{Code: "40001"})
+	permanent := errors.New("bad sql")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"transient then success", []error{transient, transient, nil}, 3, nil},
+		{"attempts exhausted", []error{transient, transient, transient, nil}, 3, transient},
+		{"permanent", []error{permanent, nil}, 1, permanent},
+		{"canceled", []error{context.Canceled, nil}, 1, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := p.Do(context.Background(), "query", func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// без попыток функция всё равно вызывается один раз
+	calls := 0
+	_ = RetryPolicy{}.Do(context.Background(), "query", func() error { calls++; return transient })
+	if calls != 1 {
+		t.Fatalf("zero attempts: %d calls", calls)
+	}
+}
+
+func TestRetryPolicy_DoStopsOnCancel(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	transient := fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Do(ctx, "query", func() error {
+			calls++
+			return transient
+		})
+	}()
+
+	// отмена во время ожидания прерывает его сразу, без новой попытки
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("err = %v, want the last error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Do kept waiting after the context was cancelled")
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d", calls)
+	}
+}
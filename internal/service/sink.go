@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"debtster-export/internal/parquet"
+	"debtster-export/internal/reporting"
+	"debtster-export/internal/requestid"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// RowSink takes the rows of an export instead of a file: a BI table or a
+// message topic. token identifies the export, so a retried insert can be
+// deduplicated. Implemented by *clients.ClickHouseClient,
+// *clients.BigQueryClient and *clients.KafkaClient.
+type RowSink interface {
+	HasProfile(profile string) bool
+	Insert(ctx context.Context, profile, token string, rows []map[string]any) (string, error)
+}
+
+// sink returns the target the rows of the export go to, nil when the export
+// produces a file.
+func (d Deliverers) sink(opts *DeliveryOptions) RowSink {
+	if opts == nil {
+		return nil
+	}
+	sink, _ := d[opts.Type].(RowSink)
+	return sink
+}
+
+// checkSink rejects the options that shape a file when the rows of the export
+// go to a sink.
+func (d Deliverers) checkSink(opts ExportOptions) error {
+	if d.sink(opts.Delivery) == nil {
+		return nil
+	}
+	if opts.Template != "" || opts.OutputProfile != "" || (opts.Format != "" && opts.Format != FormatXLSX) {
+		return fmt.Errorf("%w: %s delivery inserts rows, it cannot be combined with template, output_profile or format", ErrInvalidDelivery, opts.Delivery.Type)
+	}
+	return nil
+}
+
+// checkFileDelivery rejects a row delivery of an export that only makes sense
+// as a file, like a report.
+func (d Deliverers) checkFileDelivery(opts *DeliveryOptions) error {
+	if d.sink(opts) != nil {
+		return fmt.Errorf("%w: %s delivery is not supported by reports", ErrInvalidDelivery, opts.Type)
+	}
+	return nil
+}
+
+// insertRows sends rows to the sink of the delivery and records the
+// outcome in status. Unlike a file delivery a failure fails the export: there
+// is no file to download instead.
+func (d Deliverers) insertRows(ctx context.Context, retry RetryPolicy, status *ExportStatus, opts *DeliveryOptions, rows []map[string]any) error {
+	sink := d.sink(opts)
+	if sink == nil {
+		return fmt.Errorf("delivery type %q is not available", opts.Type)
+	}
+
+	var table string
+	err := retry.Do(ctx, "export "+status.Key+": insert", func() error {
+		var err error
+		table, err = sink.Insert(ctx, opts.Profile, status.Key, rows)
+		return err
+	})
+	if err != nil {
+		errStr := fmt.Sprintf("%s insert into %q failed: %v", opts.Type, opts.Profile, err)
+		requestid.Logf(ctx, "export %s: %s", status.Key, errStr)
+		if ctx.Err() == nil {
+			reporting.CaptureError(ctx, errors.New(errStr), exportTags(status))
+		}
+		status.Delivery.State = DeliveryFailed
+		status.Delivery.Error = &errStr
+		return errors.New(errStr)
+	}
+
+	now := time.Now()
+	status.Delivery.State = DeliveryDelivered
+	status.Delivery.Path = table
+	status.Delivery.DeliveredAt = &now
+	return nil
+}
+
+// sinkIdent is a header of a computed column usable as a column name.
+var sinkIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sinkColumnNames names the columns of an export, table columns or message
+// fields, after the registry keys of visible: "debtor.full_name" becomes "debtor_full_name".
+// Computed columns keep their header when it is an identifier and are named
+// computed_1, computed_2... otherwise.
+func sinkColumnNames[C any](visible []string, registry map[string]C, computed []ComputedColumn) []string {
+	var out []string
+	for _, key := range visible {
+		if _, ok := registry[key]; ok {
+			out = append(out, strings.ReplaceAll(key, ".", "_"))
+		}
+	}
+	for i, def := range computed {
+		name := def.Header
+		if !sinkIdent.MatchString(name) {
+			name = fmt.Sprintf("computed_%d", i+1)
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// sinkRows reads the first sheet of the generated workbook as rows of the
+// named columns, typed after the registry like a Parquet export.
+func sinkRows(workbook []byte, names []string, samples []any) ([]map[string]any, error) {
+	src, err := excelize.OpenReader(bytes.NewReader(workbook))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	rows, err := sourceRows(src, src.GetSheetName(0))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no header row")
+	}
+	if len(rows[0]) != len(names) {
+		return nil, fmt.Errorf("sheet has %d columns, expected %d", len(rows[0]), len(names))
+	}
+
+	schema := parquetSchema(rows[0], samples, rows[1:])
+	out := make([]map[string]any, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]any, len(names))
+		for i, name := range names {
+			record[name] = nil
+			if i < len(row) {
+				if v, ok := parquet.Convert(schema[i].Type, row[i]); ok {
+					record[name] = v
+				}
+			}
+		}
+		out = append(out, record)
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+type fakeSink struct {
+	profiles map[string]bool
+	fails    int
+	calls    int
+	token    string
+	rows     []map[string]any
+}
+
+func (s *fakeSink) HasProfile(profile string) bool {
+	return s.profiles[profile]
+}
+
+func (s *fakeSink) Insert(_ context.Context, profile, token string, rows []map[string]any) (string, error) {
+	s.calls++
+	if s.calls <= s.fails {
+		return "", fmt.Errorf("write: %w", syscall.ECONNRESET)
+	}
+	s.token, s.rows = token, rows
+	return "bi." + profile, nil
+}
+
+func TestSinkColumnNames(t *testing.T) {
+	registry := map[string]UserColumn{"id": {}, "debtor.full_name": {}}
+	computed := []ComputedColumn{{Header: "total_due", Expr: "id"}, {Header: "Итого", Expr: "id"}}
+
+	got := sinkColumnNames([]string{"id", "unknown", "debtor.full_name"}, registry, computed)
+	want := []string{"id", "debtor_full_name", "total_due", "computed_2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("names = %v, want %v", got, want)
+	}
+}
+
+func TestSinkRows(t *testing.T) {
+	f := excelize.NewFile()
+	sheet := f.GetSheetName(0)
+	_ = f.SetSheetRow(sheet, "A1", &[]any{"ID", "Номер", "Сумма", "Подтверждено"})
+	_ = f.SetSheetRow(sheet, "A2", &[]any{7, "D-1", 1500.5, true})
+	_ = f.SetSheetRow(sheet, "A3", &[]any{8, "", "", false})
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// типы из реестра; сумма без значения в пустой записи — число по данным
+	names := []string{"id", "number", "amount", "confirmed"}
+	rows, err := sinkRows(buf.Bytes(), names, []any{int64(0), "", "", false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"id": int64(7), "number": "D-1", "amount": 1500.5, "confirmed": true},
+		{"id": int64(8), "number": nil, "amount": nil, "confirmed": false},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %v, want %v", rows, want)
+	}
+
+	if _, err := sinkRows(buf.Bytes(), names[:2], nil); err == nil {
+		t.Fatal("expected an error for a sheet wider than the columns")
+	}
+}
+
+func TestDeliverers_CheckSink(t *testing.T) {
+	d := Deliverers{DeliveryTypeClickHouse: &fakeSink{profiles: map[string]bool{"bi": true}}}
+	sink := &DeliveryOptions{Type: DeliveryTypeClickHouse, Profile: "bi"}
+
+	if err := d.check(sink); err != nil {
+		t.Fatalf("known profile: %v", err)
+	}
+	if err := d.checkSink(ExportOptions{Delivery: sink, Format: FormatXLSX}); err != nil {
+		t.Fatalf("plain export: %v", err)
+	}
+	for _, opts := range []ExportOptions{
+		{Delivery: sink, Template: "bank"},
+		{Delivery: sink, OutputProfile: "1c"},
+		{Delivery: sink, Format: FormatCSV},
+	} {
+		if err := d.checkSink(opts); !errors.Is(err, ErrInvalidDelivery) {
+			t.Fatalf("%+v: expected ErrInvalidDelivery, got %v", opts, err)
+		}
+	}
+	if err := d.checkFileDelivery(sink); !errors.Is(err, ErrInvalidDelivery) {
+		t.Fatalf("report to a sink: got %v", err)
+	}
+	// файловая доставка вставкой строк не считается
+	if err := d.checkSink(ExportOptions{Delivery: &DeliveryOptions{Type: DeliveryTypeSFTP}, Template: "bank"}); err != nil {
+		t.Fatalf("sftp delivery: %v", err)
+	}
+}
+
+func TestDeliverers_InsertRows(t *testing.T) {
+	opts := &DeliveryOptions{Type: DeliveryTypeBigQuery, Profile: "bi"}
+	retry := RetryPolicy{Attempts: 2}
+	rows := []map[string]any{{"id": int64(1)}}
+
+	fake := &fakeSink{profiles: map[string]bool{"bi": true}, fails: 1}
+	status := &ExportStatus{Key: "exports:1", Delivery: newDeliveryStatus(opts)}
+	if err := (Deliverers{DeliveryTypeBigQuery: fake}).insertRows(context.Background(), retry, status, opts, rows); err != nil {
+		t.Fatal(err)
+	}
+	if status.Delivery.State != DeliveryDelivered || status.Delivery.Path != "bi.bi" || fake.token != "exports:1" || len(fake.rows) != 1 {
+		t.Fatalf("insert is not recorded: %+v, token %q", status.Delivery, fake.token)
+	}
+
+	// без файла неудачная вставка валит экспорт
+	fake = &fakeSink{profiles: map[string]bool{"bi": true}, fails: 5}
+	status = &ExportStatus{Key: "exports:2", Delivery: newDeliveryStatus(opts)}
+	if err := (Deliverers{DeliveryTypeBigQuery: fake}).insertRows(context.Background(), retry, status, opts, rows); err == nil {
+		t.Fatal("expected an error")
+	}
+	if status.Delivery.State != DeliveryFailed || status.Delivery.Error == nil || fake.calls != 2 {
+		t.Fatalf("failed insert: %+v after %d calls", status.Delivery, fake.calls)
+	}
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// TemplateDataName is the defined name marking where a template receives the
+// export: its first row gets the column headers, the row below is the style of
+// the data rows, which are inserted there pushing any content below down.
+const TemplateDataName = "data"
+
+// ErrInvalidTemplate is returned for an unknown template or one the export
+// cannot be rendered into.
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// TemplateStore keeps the XLSX templates exports can be rendered into.
+// Implemented by *clients.TemplateStorage.
+type TemplateStore interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+// templateVars are the values of the {placeholders} in template cells.
+type templateVars struct {
+	Type     string
+	UserID   int64
+	ExportID string
+	Comment  string
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{(type|user|export_id|comment|date|rows)\}`)
+
+// templateRegion is the location of TemplateDataName.
+type templateRegion struct {
+	sheet string
+	col   int
+	row   int
+	width int
+}
+
+// ValidateTemplate checks that data is a workbook with the TemplateDataName region.
+func ValidateTemplate(data []byte) error {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("not an xlsx workbook: %w", err)
+	}
+	defer f.Close()
+
+	_, _, err = findTemplateRegion(f)
+	return err
+}
+
+// checkTemplate rejects a template that cannot be used, before the export is queued.
+func checkTemplate(ctx context.Context, store TemplateStore, name string) error {
+	if name == "" {
+		return nil
+	}
+	if store == nil {
+		return fmt.Errorf("%w: templates are not available", ErrInvalidTemplate)
+	}
+	data, err := store.Get(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if err := ValidateTemplate(data); err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrInvalidTemplate, name, err)
+	}
+	return nil
+}
+
+// applyTemplate renders the first sheet of the generated workbook into the
+// template name; without a template the workbook is returned as is.
+func applyTemplate(ctx context.Context, store TemplateStore, name string, workbook []byte, vars templateVars) ([]byte, error) {
+	if name == "" {
+		return workbook, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("%w: templates are not available", ErrInvalidTemplate)
+	}
+	tpl, err := store.Get(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("load template: %w", err)
+	}
+	return renderTemplate(tpl, workbook, vars, time.Now())
+}
+
+func renderTemplate(tpl, workbook []byte, vars templateVars, now time.Time) ([]byte, error) {
+	src, err := excelize.OpenReader(bytes.NewReader(workbook))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	rows, err := sourceRows(src, src.GetSheetName(0))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(tpl))
+	if err != nil {
+		return nil, fmt.Errorf("open template: %w", err)
+	}
+	defer f.Close()
+	region, scope, err := findTemplateRegion(f)
+	if err != nil {
+		return nil, err
+	}
+
+	dataRows := max(len(rows)-1, 0)
+	if err := fillTemplatePlaceholders(f, vars, dataRows, now); err != nil {
+		return nil, err
+	}
+
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r))
+	}
+	// styles of the template header and sample row, the last region column
+	// repeats for exports wider than the region
+	headerStyles := make([]int, width)
+	rowStyles := make([]int, width)
+	for i := range width {
+		col := region.col + min(i, region.width-1)
+		headerCell, _ := excelize.CoordinatesToCellName(col, region.row)
+		rowCell, _ := excelize.CoordinatesToCellName(col, region.row+1)
+		headerStyles[i], _ = f.GetCellStyle(region.sheet, headerCell)
+		rowStyles[i], _ = f.GetCellStyle(region.sheet, rowCell)
+	}
+
+	if dataRows > 1 {
+		if err := f.InsertRows(region.sheet, region.row+2, dataRows-1); err != nil {
+			return nil, err
+		}
+	}
+	if dataRows == 0 {
+		// the sample row is not data
+		for i := range region.width {
+			cell, _ := excelize.CoordinatesToCellName(region.col+i, region.row+1)
+			_ = f.SetCellValue(region.sheet, cell, nil)
+		}
+	}
+
+	for r, values := range rows {
+		cell, _ := excelize.CoordinatesToCellName(region.col, region.row+r)
+		if err := f.SetSheetRow(region.sheet, cell, &values); err != nil {
+			return nil, err
+		}
+	}
+	for i := range width {
+		headerCell, _ := excelize.CoordinatesToCellName(region.col+i, region.row)
+		if headerStyles[i] != 0 {
+			_ = f.SetCellStyle(region.sheet, headerCell, headerCell, headerStyles[i])
+		}
+		if rowStyles[i] != 0 && dataRows > 0 {
+			top, _ := excelize.CoordinatesToCellName(region.col+i, region.row+1)
+			bottom, _ := excelize.CoordinatesToCellName(region.col+i, region.row+dataRows)
+			_ = f.SetCellStyle(region.sheet, top, bottom, rowStyles[i])
+		}
+	}
+
+	if err := copyInfoSheet(src, f); err != nil {
+		return nil, err
+	}
+
+	// the name now covers the written table
+	if width > 0 {
+		topLeft, _ := excelize.CoordinatesToCellName(region.col, region.row, true)
+		bottomRight, _ := excelize.CoordinatesToCellName(region.col+width-1, region.row+max(dataRows, 1), true)
+		_ = f.DeleteDefinedName(&excelize.DefinedName{Name: TemplateDataName, Scope: scope})
+		_ = f.SetDefinedName(&excelize.DefinedName{
+			Name:     TemplateDataName,
+			RefersTo: quoteSheetName(region.sheet) + "!" + topLeft + ":" + bottomRight,
+			Scope:    scope,
+		})
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sourceRows reads a generated sheet back with its numbers and booleans typed.
+func sourceRows(f *excelize.File, sheet string) ([][]any, error) {
+	raw, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]any, len(raw))
+	for r, cells := range raw {
+		rows[r] = make([]any, len(cells))
+		for c, v := range cells {
+			rows[r][c] = v
+			if v == "" {
+				continue
+			}
+			cell, _ := excelize.CoordinatesToCellName(c+1, r+1)
+			switch typ, _ := f.GetCellType(sheet, cell); typ {
+			case excelize.CellTypeUnset, excelize.CellTypeNumber:
+				if n, err := strconv.ParseFloat(v, 64); err == nil {
+					rows[r][c] = n
+				}
+			case excelize.CellTypeBool:
+				rows[r][c] = v == "1"
+			}
+		}
+	}
+	return rows, nil
+}
+
+// findTemplateRegion locates TemplateDataName and returns it with the scope of the name.
+func findTemplateRegion(f *excelize.File) (templateRegion, string, error) {
+	for _, dn := range f.GetDefinedName() {
+		if !strings.EqualFold(dn.Name, TemplateDataName) {
+			continue
+		}
+		region, err := parseTemplateRegion(dn.RefersTo)
+		if err != nil {
+			return templateRegion{}, "", err
+		}
+		if idx, _ := f.GetSheetIndex(region.sheet); idx < 0 {
+			return templateRegion{}, "", fmt.Errorf("defined name %q refers to unknown sheet %q", TemplateDataName, region.sheet)
+		}
+		return region, dn.Scope, nil
+	}
+	return templateRegion{}, "", fmt.Errorf("template has no defined name %q marking the data region", TemplateDataName)
+}
+
+// parseTemplateRegion parses references like "Sheet1!$A$5:$F$6" or "'My sheet'!$B$3".
+func parseTemplateRegion(ref string) (templateRegion, error) {
+	i := strings.LastIndex(ref, "!")
+	if i <= 0 {
+		return templateRegion{}, fmt.Errorf("defined name %q must refer to cells of a sheet, got %q", TemplateDataName, ref)
+	}
+	sheet := ref[:i]
+	if strings.HasPrefix(sheet, "'") && strings.HasSuffix(sheet, "'") && len(sheet) > 1 {
+		sheet = strings.ReplaceAll(sheet[1:len(sheet)-1], "''", "'")
+	}
+
+	cells := strings.Split(strings.ReplaceAll(ref[i+1:], "$", ""), ":")
+	col, row, err := excelize.CellNameToCoordinates(cells[0])
+	if err != nil || len(cells) > 2 {
+		return templateRegion{}, fmt.Errorf("defined name %q must refer to a cell range, got %q", TemplateDataName, ref)
+	}
+	width := 1
+	if len(cells) == 2 {
+		endCol, _, err := excelize.CellNameToCoordinates(cells[1])
+		if err != nil || endCol < col {
+			return templateRegion{}, fmt.Errorf("defined name %q must refer to a cell range, got %q", TemplateDataName, ref)
+		}
+		width = endCol - col + 1
+	}
+	return templateRegion{sheet: sheet, col: col, row: row, width: width}, nil
+}
+
+// fillTemplatePlaceholders expands {type}, {user}, {export_id}, {comment},
+// {date} and {rows} in the text cells of every sheet.
+func fillTemplatePlaceholders(f *excelize.File, vars templateVars, rows int, now time.Time) error {
+	values := map[string]string{
+		"type":      vars.Type,
+		"user":      strconv.FormatInt(vars.UserID, 10),
+		"export_id": vars.ExportID,
+		"comment":   vars.Comment,
+		"date":      now.Format("2006-01-02 15:04"),
+		"rows":      strconv.Itoa(rows),
+	}
+
+	for _, sheet := range f.GetSheetList() {
+		cells, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return err
+		}
+		for r, row := range cells {
+			for c, v := range row {
+				if !strings.Contains(v, "{") || !templatePlaceholder.MatchString(v) {
+					continue
+				}
+				cell, _ := excelize.CoordinatesToCellName(c+1, r+1)
+				if typ, _ := f.GetCellType(sheet, cell); typ == excelize.CellTypeFormula {
+					continue
+				}
+				expanded := templatePlaceholder.ReplaceAllStringFunc(v, func(m string) string {
+					return values[m[1:len(m)-1]]
+				})
+				if err := f.SetCellValue(sheet, cell, expanded); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// copyInfoSheet carries the hidden info sheet of the generated workbook over
+// to the template, unless the template has a sheet of that name itself.
+func copyInfoSheet(src, dst *excelize.File) error {
+	if idx, _ := src.GetSheetIndex(infoSheet); idx < 0 {
+		return nil
+	}
+	if idx, _ := dst.GetSheetIndex(infoSheet); idx >= 0 {
+		return nil
+	}
+	rows, err := src.GetRows(infoSheet)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.NewSheet(infoSheet); err != nil {
+		return err
+	}
+	for r, values := range rows {
+		row := make([]any, len(values))
+		for i, v := range values {
+			row[i] = v
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, r+1)
+		if err := dst.SetSheetRow(infoSheet, cell, &row); err != nil {
+			return err
+		}
+	}
+	return dst.SetSheetVisible(infoSheet, false)
+}
+
+func quoteSheetName(name string) string {
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
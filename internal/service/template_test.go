@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// testTemplate — шаблон с шапкой, областью data в B3:C4 и подвалом ниже неё.
+func testTemplate(t *testing.T) []byte {
+	t.Helper()
+
+	f := excelize.NewFile()
+	sheet := "Отчёт банку"
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	_ = f.SetCellValue(sheet, "A1", "Выгрузка {export_id} от {date}, строк: {rows}")
+	_ = f.SetCellValue(sheet, "B6", "Подвал")
+
+	headerStyle, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	rowStyle, _ := f.NewStyle(&excelize.Style{NumFmt: 4})
+	_ = f.SetCellStyle(sheet, "B3", "C3", headerStyle)
+	_ = f.SetCellStyle(sheet, "B4", "C4", rowStyle)
+	_ = f.SetCellValue(sheet, "B4", "образец")
+	if err := f.SetDefinedName(&excelize.DefinedName{Name: TemplateDataName, RefersTo: "'Отчёт банку'!$B$3:$C$4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testWorkbook(t *testing.T, rows [][]any) []byte {
+	t.Helper()
+
+	f := excelize.NewFile()
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		_ = f.SetSheetRow("Sheet1", cell, &row)
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRenderTemplate(t *testing.T) {
+	workbook := testWorkbook(t, [][]any{
+		{"Номер", "Сумма", "Комментарий"},
+		{"D-1", 1500.5, "первый"},
+		{"D-2", 20.0, ""},
+		{"D-3", 0.0, "третий"},
+	})
+	now := time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC)
+
+	data, err := renderTemplate(testTemplate(t), workbook, templateVars{ExportID: "exports:1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := "Отчёт банку"
+
+	if v, _ := f.GetCellValue(sheet, "A1"); v != "Выгрузка exports:1 от 2025-03-01 10:30, строк: 3" {
+		t.Fatalf("placeholders not filled: %q", v)
+	}
+	rows, _ := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	want := [][]string{
+		{"Номер", "Сумма", "Комментарий"},
+		{"D-1", "1500.5", "первый"},
+		{"D-2", "20"},
+		{"D-3", "0", "третий"},
+	}
+	for i, w := range want {
+		if got := rows[2+i][1:]; !reflect.DeepEqual(got, w) {
+			t.Errorf("row %d = %v, want %v", 3+i, got, w)
+		}
+	}
+	// подвал сдвигается вниз, а не перезаписывается
+	if v, _ := f.GetCellValue(sheet, "B8"); v != "Подвал" {
+		t.Fatalf("footer moved to unexpected place, B8 = %q", v)
+	}
+
+	// числа остаются числами и получают стиль строки-образца, лишняя колонка — стиль последней
+	if typ, _ := f.GetCellType(sheet, "C5"); typ != excelize.CellTypeUnset && typ != excelize.CellTypeNumber {
+		t.Errorf("amount is not a number: %v", typ)
+	}
+	sampleStyle, _ := f.GetCellStyle(sheet, "C4")
+	if s, _ := f.GetCellStyle(sheet, "D6"); s != sampleStyle || s == 0 {
+		t.Errorf("extra column style %d, want %d", s, sampleStyle)
+	}
+
+	region, _, err := findTemplateRegion(f)
+	if err != nil || region.width != 3 {
+		t.Fatalf("data name not updated: %+v %v", region, err)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	if err := ValidateTemplate(testTemplate(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateTemplate(testWorkbook(t, [][]any{{"a"}})); err == nil {
+		t.Fatal("expected error for a workbook without the data region")
+	}
+	if err := ValidateTemplate([]byte("not a workbook")); err == nil {
+		t.Fatal("expected error for garbage")
+	}
+}
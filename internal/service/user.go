@@ -4,47 +4,90 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
 	"time"
 
-	"debtster-export/internal/clients"
 	"debtster-export/internal/domain"
 
-	"github.com/google/uuid"
 	"github.com/xuri/excelize/v2"
 )
 
 type UserRepository interface {
 	List(ctx context.Context) ([]domain.User, error)
+	Count(ctx context.Context) (int64, error)
+	HasMoreThan(ctx context.Context, limit int64) (bool, error)
 }
 
+// maxUsersForExport — users are exported without a filter, the cap guards
+// against a runaway users table
+const maxUsersForExport = 200_000
+
 type UserService struct {
-	repo        UserRepository
-	redis       *clients.RedisClient
-	s3          *clients.StorageClient
-	ws          *clients.WebSocketClient
-	cachePrefix string
+	exporter
+
+	repo  UserRepository
+	queue *JobQueue
+	// progressEvery is the least time between two generating progress reports
+	progressEvery time.Duration
+	filenameTpl   string
+	templates     TemplateStore
 }
 
 func NewUserService(
 	repo UserRepository,
-	redis *clients.RedisClient,
-	s3 *clients.StorageClient,
-	ws *clients.WebSocketClient,
+	redis Cache,
+	s3 FileStorage,
+	ws Notifier,
 ) *UserService {
-	// тот же префикс, что и у DebtService
-	prefix := "pkb_database_cache"
-
 	return &UserService{
-		repo:        repo,
-		redis:       redis,
-		s3:          s3,
-		ws:          ws,
-		cachePrefix: prefix,
+		repo:     repo,
+		exporter: newExporter(redis, s3, ws),
 	}
 }
 
+// SetRetryPolicy overrides the backoff used for transient DB and storage failures.
+func (s *UserService) SetRetryPolicy(p RetryPolicy) {
+	s.retry = p
+}
+
+// SetJobRunner makes exports run on the shared worker pool watched for stalls.
+func (s *UserService) SetJobRunner(r *JobRunner) {
+	s.jobs = r
+}
+
+// SetJobQueue makes exports run on the instances consuming q instead of the
+// one that started them.
+func (s *UserService) SetJobQueue(q *JobQueue) {
+	s.queue = q
+	q.handle(s.runQueued, s.failExport, "users")
+}
+
+// SetOutbox makes final statuses go through o, which retries failed writes.
+func (s *UserService) SetOutbox(o *Outbox) {
+	s.outbox = o
+}
+
+// SetProgressInterval throttles the progress saved and sent while rows are
+// generated to one report per d; the last row is always reported.
+func (s *UserService) SetProgressInterval(d time.Duration) {
+	s.progressEvery = d
+}
+
+// SetFilenameTemplate sets the naming template of generated files, see
+// DefaultFilenameTemplate for the placeholders; empty keeps the default.
+func (s *UserService) SetFilenameTemplate(tpl string) {
+	s.filenameTpl = tpl
+}
+
+// SetDeliverers enables the delivery types exports may request.
+func (s *UserService) SetDeliverers(d Deliverers) {
+	s.deliverers = d
+}
+
+// SetTemplateStore enables rendering exports into XLSX templates.
+func (s *UserService) SetTemplateStore(ts TemplateStore) {
+	s.templates = ts
+}
+
 type UserColumn struct {
 	Header string
 	Value  func(u domain.User) any
@@ -103,45 +146,32 @@ var userColumns = map[string]UserColumn{
 			return strPtr(u.Departments)
 		},
 	},
+	"assigned_debts_count": {
+		Header: "Количество закреплённых долгов",
+		Value: func(u domain.User) any {
+			return u.AssignedDebtsCount
+		},
+	},
+	"assigned_debts_amount": {
+		Header: "Сумма актуальной задолженности в работе",
+		Value: func(u domain.User) any {
+			return u.AssignedDebtsAmount
+		},
+	},
+	"actions_last_30_days_count": {
+		Header: "Действий за последние 30 дней",
+		Value: func(u domain.User) any {
+			return u.ActionsLast30DaysCount
+		},
+	},
 }
 
 // --- helpers для статуса экспорта (аналогичные DebtService) ---
 
-func (s *UserService) saveExportStatus(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-
-	data, err := json.Marshal(st)
-	if err != nil {
-		return err
-	}
-
-	if err := s.redis.Set(ctx, st.Key, string(data), exportTTL); err != nil {
-		return err
-	}
-
-	return s.redis.SAdd(ctx, exportSetKey, st.Key)
-}
-
-func (s *UserService) saveLaravelCache(ctx context.Context, st *ExportStatus) error {
-	if s.redis == nil {
-		return nil
-	}
-
-	cacheKey := s.cachePrefix + st.Key
-	item := ExportCacheItem{
-		Key:      st.Key,
-		Type:     st.Type,
-		UserID:   st.UserID,
-		Progress: st.Progress,
-		FileURL:  st.FileURL,
-		Error:    st.Error,
-		Created:  st.Created.Format("2006-01-02 15:04:05"),
-	}
-
-	serialized := phpSerializeExportItem(item)
-	return s.redis.Set(ctx, cacheKey, serialized, exportTTL)
+// usersExportParams — сохраняемые параметры экспорта пользователей
+type usersExportParams struct {
+	Selected []string      `json:"selected"`
+	Options  ExportOptions `json:"options"`
 }
 
 // --- публичный метод, который ожидает Handler (как StartDebtsExport) ---
@@ -149,6 +179,7 @@ func (s *UserService) saveLaravelCache(ctx context.Context, st *ExportStatus) er
 func (s *UserService) StartUsersExport(
 	ctx context.Context,
 	selected []string,
+	opts ExportOptions,
 	userID int64,
 ) (string, error) {
 	if len(selected) == 0 {
@@ -160,60 +191,134 @@ func (s *UserService) StartUsersExport(
 		}
 	}
 
-	exportID := fmt.Sprintf("exports:%s", uuid.NewString())
-	now := time.Now()
+	return s.startUsersExport(ctx, usersExportParams{Selected: selected, Options: opts}, userID, firstAttempt)
+}
 
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "users",
-		UserID:   userID,
-		Filters:  buildUsersFiltersMap(selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  now,
+// RetryExport перезапускает упавший экспорт с исходными параметрами
+func (s *UserService) RetryExport(ctx context.Context, original *ExportStatus) (string, error) {
+	var params usersExportParams
+	if err := json.Unmarshal(original.Params, &params); err != nil {
+		return "", fmt.Errorf("failed to decode export params: %w", err)
+	}
+	return s.startUsersExport(ctx, params, original.UserID, nextAttempt(original))
+}
+
+// runQueued runs a users export taken from the job queue.
+func (s *UserService) runQueued(ctx context.Context, status *ExportStatus) {
+	if params, ok := queuedParams[usersExportParams](ctx, status, s.failExport); ok {
+		s.runUsersExport(ctx, status, params.Selected, params.Options)
+	}
+}
+
+func (s *UserService) startUsersExport(ctx context.Context, params usersExportParams, userID int64, attempt exportAttempt) (string, error) {
+	// masked columns are dropped before anything is recorded
+	params.Options.HiddenColumns = withCallerMasks(ctx, params.Options.HiddenColumns)
+	params.Selected = visibleColumns(params.Selected, params.Options.HiddenColumns)
+
+	if err := s.deliverers.check(params.Options.Delivery); err != nil {
+		return "", err
+	}
+	if err := s.deliverers.checkSink(params.Options); err != nil {
+		return "", err
+	}
+	if err := checkTemplate(ctx, s.templates, params.Options.Template); err != nil {
+		return "", err
+	}
+	if err := checkOutput(params.Options); err != nil {
+		return "", err
+	}
+	if _, err := userComputedColumns(params.Options.Computed, params.Options.HiddenColumns); err != nil {
+		return "", err
 	}
 
+	tooMany, err := s.repo.HasMoreThan(ctx, maxUsersForExport)
+	if err != nil {
+		return "", err
+	}
+	if tooMany {
+		return "", tooManyRows("пользователей", maxUsersForExport)
+	}
+
+	status := newExportStatus(ctx, "users", userID, buildUsersFiltersMap(params.Selected), params, attempt)
+	status.Delivery = newDeliveryStatus(params.Options.Delivery)
+	status.Comment = params.Options.Comment
+
 	_ = s.saveExportStatus(ctx, status)
-	_ = s.saveLaravelCache(ctx, status)
 
 	// запускаем фоновую задачу
-	go s.runUsersExport(context.Background(), exportID, selected, userID, now)
+	fail := func(ctx context.Context, errStr string) { s.failExport(ctx, status, errStr) }
+	if err := runJob(ctx, s.jobs, s.queue, status, fail, func(ctx context.Context) {
+		s.runUsersExport(ctx, status, params.Selected, params.Options)
+	}); err != nil {
+		return "", err
+	}
 
-	return exportID, nil
+	return status.Key, nil
+}
+
+// EstimateUsersExport считает, сколько строк даст экспорт пользователей
+func (s *UserService) EstimateUsersExport(ctx context.Context, selected []string) (ExportEstimate, error) {
+	if len(selected) == 0 {
+		selected = []string{
+			"full_name",
+			"username",
+			"email",
+			"departments",
+		}
+	}
+
+	rows, err := s.repo.Count(ctx)
+	if err != nil {
+		return ExportEstimate{}, err
+	}
+
+	columns := 0
+	for _, key := range visibleColumns(selected, withCallerMasks(ctx, nil)) {
+		if _, ok := userColumns[key]; ok {
+			columns++
+		}
+	}
+
+	return newExportEstimate(rows, columns, maxUsersForExport), nil
 }
 
 // собственно выполнение экспорта, очень похоже на runDebtsExport
 func (s *UserService) runUsersExport(
 	ctx context.Context,
-	exportID string,
+	status *ExportStatus,
 	selected []string,
-	userID int64,
-	createdAt time.Time,
+	opts ExportOptions,
 ) {
-	status := &ExportStatus{
-		Key:      exportID,
-		Type:     "users",
-		UserID:   userID,
-		Filters:  buildUsersFiltersMap(selected),
-		Progress: 0,
-		FileURL:  nil,
-		Created:  createdAt,
-	}
+	status.enter(ExportRunning)
+	exportID, userID := status.Key, status.UserID
 
-	users, err := s.repo.List(ctx)
+	var users []domain.User
+	err := s.retry.Do(ctx, "export "+exportID+": query", func() error {
+		var err error
+		users, err = s.repo.List(ctx)
+		return err
+	})
 	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("query failed: %v", err))
 		return
 	}
 
 	var cols []UserColumn
-	for _, key := range selected {
+	for _, key := range visibleColumns(selected, opts.HiddenColumns) {
 		col, ok := userColumns[key]
 		if !ok {
 			continue
 		}
 		cols = append(cols, col)
 	}
+	computed, err := userComputedColumns(opts.Computed, opts.HiddenColumns)
+	if err != nil {
+		s.failExport(ctx, status, err.Error())
+		return
+	}
+	cols = append(cols, computed...)
 	if len(cols) == 0 {
+		s.failExport(ctx, status, "no valid columns selected")
 		return
 	}
 
@@ -236,7 +341,7 @@ func (s *UserService) runUsersExport(
 		// keep progress at 0 and continue to generate/upload the file;
 		// final 100 will be set only after successful upload and URL generation.
 	} else {
-		chunkSize := 1000
+		pacer := newRowPacer(total, s.progressEvery)
 		rowIdx := 2
 
 		for i, u := range users {
@@ -246,68 +351,63 @@ func (s *UserService) runUsersExport(
 			}
 			rowIdx++
 
-			if (i+1)%chunkSize == 0 || i == total-1 {
-				raw := float64(i+1) / float64(total) * 100.0
-				progress := math.Round(raw)
-				if progress >= 100 {
-					progress = 95
+			if pacer.due(i + 1) {
+				if err := ctx.Err(); err != nil {
+					s.failExport(ctx, status, err.Error())
+					return
 				}
 
-				status.Progress = progress
+				eventCtx := pacer.report(ctx, status, i+1)
 				_ = s.saveExportStatus(ctx, status)
-				_ = s.saveLaravelCache(ctx, status)
 
 				if s.ws != nil {
-					_ = s.ws.NotifyExportProgress(ctx, userID, exportID, progress, "generating")
+					_ = s.ws.NotifyExportProgress(eventCtx, userID, exportID, status.Progress, "generating")
 				}
 			}
 		}
 	}
 
-	buf, err := f.WriteToBuffer()
-	if err != nil {
+	if err := ctx.Err(); err != nil {
+		s.failExport(ctx, status, err.Error())
 		return
 	}
-	data := buf.Bytes()
-
-	fileName := fmt.Sprintf("users_%s.xlsx", time.Now().Format("20060102_150405"))
 
-	if s.s3 != nil {
-		// notify upload phase before starting upload
-		status.Progress = 95
-		_ = s.saveExportStatus(ctx, status)
-		_ = s.saveLaravelCache(ctx, status)
-		if s.ws != nil {
-			_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 95, "uploading")
-		}
-
-		savedName, err := s.s3.Save(ctx, fileName, data)
-		if err != nil {
-			errStr := fmt.Sprintf("save export failed: %v", err)
-			log.Printf("export %s: %s", exportID, errStr)
-			status.Error = &errStr
-			status.Progress = 100
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = col.Header
+	}
+	if err := writeInfoSheet(f, status, headers, len(users), time.Now()); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
+	}
 
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write workbook failed: %v", err))
+		return
+	}
+	data, err := applyTemplate(ctx, s.templates, opts.Template, buf.Bytes(), templateVars{Type: "users", UserID: userID, ExportID: exportID, Comment: opts.Comment})
+	if err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("render template failed: %v", err))
+		return
+	}
+	if data, err = applyOutput(opts, data, sampleColumns(cols)); err != nil {
+		s.failExport(ctx, status, fmt.Sprintf("write output failed: %v", err))
+		return
+	}
 
-			if s.ws != nil {
-				_ = s.ws.NotifyExportFailed(ctx, userID, exportID, errStr)
-			}
-		} else {
-			url := s.s3.GetURL(savedName)
-			status.FileURL = &url
-			status.Progress = 100
+	if s.deliverers.sink(opts.Delivery) != nil {
+		s.sinkExport(ctx, status, opts, data, sinkColumnNames(selected, userColumns, opts.Computed), sampleColumns(cols))
+		return
+	}
 
-			_ = s.saveExportStatus(ctx, status)
-			_ = s.saveLaravelCache(ctx, status)
+	fileName := exportFilename(s.filenameTpl, opts, filenameFields{
+		Type:     "users",
+		UserID:   userID,
+		ExportID: exportID,
+	})
 
-			if s.ws != nil {
-				_ = s.ws.NotifyExportProgress(ctx, userID, exportID, 100, "ready")
-				_ = s.ws.NotifyExportComplete(ctx, userID, exportID, url, fileName)
-			}
-		}
-	}
+	s.saveExportFile(ctx, status, opts, fileName, data)
 }
 
 // buildUsersFiltersMap возвращает карту с выбранными полями для экспорта пользователей
@@ -337,7 +437,7 @@ func (s *UserService) ExportUsersToXLSX(
 	}
 
 	var cols []UserColumn
-	for _, key := range selected {
+	for _, key := range visibleColumns(selected, withCallerMasks(ctx, nil)) {
 		col, ok := userColumns[key]
 		if !ok {
 			continue
@@ -366,6 +466,10 @@ func (s *UserService) ExportUsersToXLSX(
 		rowIdx++
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	buf, err := f.WriteToBuffer()
 	if err != nil {
 		return nil, err
@@ -373,3 +477,19 @@ func (s *UserService) ExportUsersToXLSX(
 
 	return buf.Bytes(), nil
 }
+
+// userComputedColumns compiles the computed columns of a users export over userColumns.
+func userComputedColumns(defs []ComputedColumn, hidden []string) ([]UserColumn, error) {
+	computed, err := compileComputedColumns(defs, func(key string) (func(domain.User) any, bool) {
+		col, ok := userColumns[key]
+		return col.Value, ok && !columnHidden(hidden, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]UserColumn, 0, len(computed))
+	for _, c := range computed {
+		out = append(out, UserColumn{Header: c.Header, Value: c.Value})
+	}
+	return out, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/service/mocks"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/mock/gomock"
+)
+
+var workloadColumns = []string{"username", "assigned_debts_count", "assigned_debts_amount", "actions_last_30_days_count"}
+
+var workloadUsers = []domain.User{
+	{Username: ptr("a.saparova"), AssignedDebtsCount: 42, AssignedDebtsAmount: 1250000.5, ActionsLast30DaysCount: 17},
+	// без долгов и действий — нули, а не пустые ячейки
+	{Username: ptr("e.akhmetov")},
+}
+
+// checkWorkloadSheet checks the workload columns of the Users sheet of file:
+// headers, values and that the numbers are stored as numbers.
+func checkWorkloadSheet(t *testing.T, file []byte) {
+	t.Helper()
+	f, err := excelize.OpenReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("not a workbook: %v", err)
+	}
+	rows, err := f.GetRows("Users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Логин", "Количество закреплённых долгов", "Сумма актуальной задолженности в работе", "Действий за последние 30 дней"},
+		{"a.saparova", "42", "1250000.5", "17"},
+		{"e.akhmetov", "0", "0", "0"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %q", rows)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if j >= len(rows[i]) || rows[i][j] != want[i][j] {
+				t.Fatalf("row %d = %q, want %q", i, rows[i], want[i])
+			}
+		}
+	}
+	for _, cell := range []string{"B2", "C2", "D2", "B3", "C3", "D3"} {
+		if typ, _ := f.GetCellType("Users", cell); typ != excelize.CellTypeUnset && typ != excelize.CellTypeNumber {
+			t.Fatalf("%s has type %v, want a number", cell, typ)
+		}
+	}
+}
+
+func TestRunUsersExport_WorkloadColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockUserRepository(ctrl)
+	cache := mocks.NewMockCache(ctrl)
+	storage := mocks.NewMockFileStorage(ctrl)
+	ws := mocks.NewMockNotifier(ctrl)
+	saved := recordStatuses(cache)
+
+	s := NewUserService(repo, cache, storage, ws)
+	s.SetRetryPolicy(RetryPolicy{Attempts: 1, BaseDelay: time.Millisecond})
+	status := newExportStatus(context.Background(), "users", 7, nil, nil, firstAttempt)
+
+	repo.EXPECT().List(gomock.Any()).Return(workloadUsers, nil)
+	var file []byte
+	storage.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, name string, data []byte) (string, error) {
+			file = data
+			return "abc_" + name, nil
+		})
+	storage.EXPECT().SetOwner(gomock.Any(), int64(7), false).Return(nil)
+	storage.EXPECT().GetURL(gomock.Any()).DoAndReturn(func(name string) string { return "/files/" + name })
+	ws.EXPECT().NotifyExportProgress(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	ws.EXPECT().NotifyExportComplete(gomock.Any(), int64(7), status.Key, gomock.Any(), gomock.Any())
+
+	s.runUsersExport(context.Background(), status, workloadColumns, ExportOptions{})
+
+	if final := lastStatus(t, saved); final.Error != nil || final.FileURL == nil {
+		t.Fatalf("expected completed export, got %+v", final)
+	}
+	checkWorkloadSheet(t, file)
+}
+
+func TestExportUsersToXLSX_WorkloadColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockUserRepository(ctrl)
+	s := NewUserService(repo, nil, nil, nil)
+
+	repo.EXPECT().List(gomock.Any()).Return(workloadUsers, nil)
+	file, err := s.ExportUsersToXLSX(context.Background(), workloadColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWorkloadSheet(t, file)
+
+	// новые колонки известны валидации
+	if unknown := UnknownUserColumns(workloadColumns); len(unknown) != 0 {
+		t.Fatalf("unknown columns: %+v", unknown)
+	}
+}
+
+func TestEstimateUsersExport_DefaultColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	repo := mocks.NewMockUserRepository(ctrl)
+	s := NewUserService(repo, mocks.NewMockCache(ctrl), mocks.NewMockFileStorage(ctrl), mocks.NewMockNotifier(ctrl))
+
+	repo.EXPECT().Count(gomock.Any()).Return(int64(10), nil).Times(2)
+
+	// без полей — колонки выгрузки по умолчанию
+	got, err := s.EstimateUsersExport(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rows != 10 || got.Columns != 4 || got.EstimatedBytes != 480 || got.Limit != maxUsersForExport || got.ExceedsLimit {
+		t.Fatalf("estimate = %+v", got)
+	}
+
+	got, err = s.EstimateUsersExport(WithHiddenColumns(context.Background(), []string{"email"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Columns != 3 {
+		t.Fatalf("masked email is counted: %+v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"debtster-export/internal/domain"
+)
+
+// ExportWatch wakes long-polling status requests when an export running on
+// this instance reports an event. It sits in front of the notifiers the
+// exporters use and passes every event on to next. Exports of other instances
+// do not wake anyone; waiters have to re-check the status now and then.
+type ExportWatch struct {
+	next Notifier
+
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]bool
+}
+
+func NewExportWatch(next Notifier) *ExportWatch {
+	return &ExportWatch{next: next, waiters: map[string]map[chan struct{}]bool{}}
+}
+
+// Watch returns a channel closed at the next event of exportID and a func
+// that stops watching; it must be called once the caller stops waiting.
+func (w *ExportWatch) Watch(exportID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	w.mu.Lock()
+	if w.waiters[exportID] == nil {
+		w.waiters[exportID] = map[chan struct{}]bool{}
+	}
+	w.waiters[exportID][ch] = true
+	w.mu.Unlock()
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if waiters, ok := w.waiters[exportID]; ok && waiters[ch] {
+			delete(waiters, ch)
+			if len(waiters) == 0 {
+				delete(w.waiters, exportID)
+			}
+		}
+	}
+}
+
+func (w *ExportWatch) wake(exportID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.waiters[exportID] {
+		close(ch)
+	}
+	delete(w.waiters, exportID)
+}
+
+func (w *ExportWatch) NotifyExportProgress(ctx context.Context, userID int64, exportID string, progress float64, stage string) error {
+	w.wake(exportID)
+	return w.next.NotifyExportProgress(ctx, userID, exportID, progress, stage)
+}
+
+func (w *ExportWatch) NotifyExportComplete(ctx context.Context, userID int64, exportID string, url string, filename string) error {
+	w.wake(exportID)
+	return w.next.NotifyExportComplete(ctx, userID, exportID, url, filename)
+}
+
+func (w *ExportWatch) NotifyExportFailed(ctx context.Context, userID int64, exportID string, errMsg string) error {
+	w.wake(exportID)
+	return w.next.NotifyExportFailed(ctx, userID, exportID, errMsg)
+}
+
+func (w *ExportWatch) NotifyBatchComplete(ctx context.Context, userID int64, batchID string, results []domain.ExportBatchResult) error {
+	return w.next.NotifyBatchComplete(ctx, userID, batchID, results)
+}
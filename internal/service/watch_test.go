@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"debtster-export/internal/service/mocks"
+
+	"go.uber.org/mock/gomock"
+)
+
+func TestExportWatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := mocks.NewMockNotifier(ctrl)
+	w := NewExportWatch(next)
+
+	changed, stop := w.Watch("exports:1")
+	defer stop()
+	other, stopOther := w.Watch("exports:2")
+
+	next.EXPECT().NotifyExportProgress(gomock.Any(), int64(7), "exports:1", float64(50), "generating")
+	_ = w.NotifyExportProgress(context.Background(), 7, "exports:1", 50, "generating")
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("watcher of exports:1 was not woken")
+	}
+	select {
+	case <-other:
+		t.Fatal("watcher of exports:2 was woken")
+	default:
+	}
+
+	// после stop ожидающих не остаётся
+	stopOther()
+	if len(w.waiters) != 0 {
+		t.Fatalf("waiters left: %v", w.waiters)
+	}
+}
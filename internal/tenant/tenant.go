@@ -0,0 +1,73 @@
+package tenant
+
+import (
+	"context"
+	"sort"
+)
+
+// Tenant is a collection company served by the shared Laravel install.
+type Tenant struct {
+	ID string
+	// Schema is the Postgres schema holding the tenant's tables.
+	Schema string
+}
+
+type ctxKey struct{}
+
+func WithTenant(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// Without returns ctx with no tenant attached, for state shared by all tenants.
+func Without(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, nil)
+}
+
+// FromContext returns the tenant attached to ctx, if any.
+func FromContext(ctx context.Context) (Tenant, bool) {
+	t, ok := ctx.Value(ctxKey{}).(Tenant)
+	return t, ok
+}
+
+// Registry holds the tenants known to this deployment. An empty registry
+// means the service runs in single-tenant mode.
+type Registry struct {
+	tenants map[string]Tenant
+}
+
+// NewRegistry builds a registry from a tenant id -> schema mapping.
+func NewRegistry(schemas map[string]string) *Registry {
+	r := &Registry{tenants: make(map[string]Tenant, len(schemas))}
+	for id, schema := range schemas {
+		if schema == "" {
+			schema = id
+		}
+		r.tenants[id] = Tenant{ID: id, Schema: schema}
+	}
+	return r
+}
+
+func (r *Registry) Enabled() bool {
+	return r != nil && len(r.tenants) > 0
+}
+
+func (r *Registry) Get(id string) (Tenant, bool) {
+	if r == nil {
+		return Tenant{}, false
+	}
+	t, ok := r.tenants[id]
+	return t, ok
+}
+
+// All returns the registered tenants ordered by id.
+func (r *Registry) All() []Tenant {
+	if r == nil {
+		return nil
+	}
+	out := make([]Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
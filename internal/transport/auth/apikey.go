@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader carries the key of a service-to-service request.
+const APIKeyHeader = "X-Api-Key"
+
+// APIKey is a static key other services (cron jobs) authenticate with instead
+// of a user token. Requests made with it act as the synthetic user UserID and
+// have Abilities, like a Sanctum token would.
+type APIKey struct {
+	Name string
+	// Hash is the SHA-256 of the key, see HashAPIKey.
+	Hash      []byte
+	UserID    int64
+	Abilities []string
+}
+
+// HashAPIKey returns the hex SHA-256 of key, the form keys are configured in.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// findAPIKey returns the key matching plain; every key is compared in
+// constant time.
+func findAPIKey(keys []APIKey, plain string) (APIKey, bool) {
+	sum := sha256.Sum256([]byte(plain))
+	var found APIKey
+	ok := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(sum[:], k.Hash) == 1 {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
+
+// APIKeyMiddleware authenticates requests carrying APIKeyHeader by keys and
+// hands all others to fallback (SanctumMiddleware). A wrong key is rejected
+// rather than passed on.
+func APIKeyMiddleware(keys []APIKey, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		other := fallback(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			plain := strings.TrimSpace(r.Header.Get(APIKeyHeader))
+			if plain == "" || len(keys) == 0 {
+				other.ServeHTTP(w, r)
+				return
+			}
+
+			key, ok := findAPIKey(keys, plain)
+			if !ok {
+				logDecision(r, "invalid_api_key", "key", Fingerprint(plain))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			logDecision(r, "ok", "source", "api_key", "api_key", key.Name, "user_id", key.UserID)
+
+			ctx := context.WithValue(r.Context(), UserIDKey, key.UserID)
+			ctx = context.WithValue(ctx, AbilitiesKey, key.Abilities)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"debtster-export/internal/tenant"
+)
+
+func testAPIKey(name, plain string, userID int64, abilities ...string) APIKey {
+	hash, _ := hex.DecodeString(HashAPIKey(plain))
+	return APIKey{Name: name, Hash: hash, UserID: userID, Abilities: abilities}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	keys := []APIKey{
+		testAPIKey("billing", "billing-secret", -1, "tenant:acme", "export:admin"),
+		testAPIKey("reports", "reports-secret", -2),
+	}
+
+	tests := []struct {
+		name          string
+		header        http.Header
+		keys          []APIKey
+		wantStatus    int
+		wantUser      int64
+		wantAbilities []string
+		wantFallback  bool
+	}{
+		{name: "valid key", header: http.Header{"X-Api-Key": {"billing-secret"}}, keys: keys,
+			wantStatus: 200, wantUser: -1, wantAbilities: []string{"tenant:acme", "export:admin"}},
+		{name: "key without abilities", header: http.Header{"X-Api-Key": {" reports-secret "}}, keys: keys,
+			wantStatus: 200, wantUser: -2},
+		// неверный ключ не передаётся дальше в Sanctum, даже с валидным токеном
+		{name: "wrong key", header: http.Header{"X-Api-Key": {"billing-secreT"}, "Authorization": {"Bearer 1|good"}}, keys: keys,
+			wantStatus: http.StatusUnauthorized},
+		{name: "no header falls through", header: http.Header{"Authorization": {"Bearer 1|good"}}, keys: keys,
+			wantStatus: 200, wantUser: 10, wantAbilities: []string{"*"}, wantFallback: true},
+		{name: "empty header falls through", header: http.Header{"X-Api-Key": {" "}}, keys: keys,
+			wantStatus: http.StatusUnauthorized, wantFallback: true},
+		{name: "no keys configured", header: http.Header{"X-Api-Key": {"billing-secret"}, "Authorization": {"Bearer 1|good"}},
+			wantStatus: 200, wantUser: 10, wantAbilities: []string{"*"}, wantFallback: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotUser      int64
+				gotAbilities []string
+				fellBack     bool
+			)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = GetUserID(r.Context())
+				gotAbilities = GetAbilities(r.Context())
+			})
+			fallback := func(next http.Handler) http.Handler {
+				sanctum := SanctumMiddleware(fakeTokens{"1|good": {ID: 1, UserID: 10, Abilities: `["*"]`}}, nil)(next)
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fellBack = true
+					sanctum.ServeHTTP(w, r)
+				})
+			}
+
+			r := httptest.NewRequest("GET", "/export", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			APIKeyMiddleware(tt.keys, fallback)(next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if fellBack != tt.wantFallback {
+				t.Errorf("fell back to sanctum = %v, want %v", fellBack, tt.wantFallback)
+			}
+			if gotUser != tt.wantUser || !slices.Equal(gotAbilities, tt.wantAbilities) {
+				t.Errorf("user %d, abilities %v; want %d, %v", gotUser, gotAbilities, tt.wantUser, tt.wantAbilities)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAbilities(t *testing.T) {
+	registry := tenant.NewRegistry(map[string]string{"acme": "acme", "beta": "beta"})
+	keys := []APIKey{
+		testAPIKey("billing", "billing-secret", -1, "tenant:acme", "export:admin"),
+		testAPIKey("reports", "reports-secret", -2, "*"),
+	}
+	noSanctum := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		header     string
+		wantStatus int
+		wantTenant string
+		wantAdmin  bool
+	}{
+		{name: "bound key", key: "billing-secret", wantStatus: 200, wantTenant: "acme", wantAdmin: true},
+		{name: "bound key, other tenant", key: "billing-secret", header: "beta", wantStatus: http.StatusForbidden},
+		// "*" не даёт ни тенанта, ни export:admin
+		{name: "unbound key", key: "reports-secret", header: "acme", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTenant string
+			var admin bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tn, _ := tenant.FromContext(r.Context())
+				gotTenant = tn.ID
+				admin = HasAbility(r.Context(), "export:admin")
+			})
+			r := httptest.NewRequest("GET", "/export", nil)
+			r.Header.Set(APIKeyHeader, tt.key)
+			if tt.header != "" {
+				r.Header.Set("X-Tenant", tt.header)
+			}
+			w := httptest.NewRecorder()
+			TenantHint(registry, "X-Tenant")(APIKeyMiddleware(keys, noSanctum)(TenantMiddleware(registry, "X-Tenant")(next))).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if gotTenant != tt.wantTenant || admin != tt.wantAdmin {
+				t.Errorf("tenant %q, admin %v; want %q, %v", gotTenant, admin, tt.wantTenant, tt.wantAdmin)
+			}
+		})
+	}
+}
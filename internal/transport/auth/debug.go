@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// debug enables the auth decision log, see SetDebug.
+var debug atomic.Bool
+
+// SetDebug turns the auth decision log (AUTH_DEBUG) on or off. It logs one
+// key=value line per decision with the token id, user and outcome; secrets are
+// only ever logged as a Fingerprint.
+func SetDebug(on bool) {
+	debug.Store(on)
+}
+
+// Fingerprint identifies a secret in logs without revealing it: a short
+// prefix of its SHA-256, so the same token can be correlated across lines.
+func Fingerprint(secret string) string {
+	if secret == "" {
+		return "-"
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// tokenID returns the id part of a Sanctum "id|secret" token, which is not secret.
+func tokenID(plain string) string {
+	if id, _, ok := strings.Cut(plain, "|"); ok && id != "" {
+		return id
+	}
+	return "-"
+}
+
+// logDecision logs an auth decision when AUTH_DEBUG is on; fields are
+// alternating keys and values. The query string is left out, it may carry ?token=.
+func logDecision(r *http.Request, outcome string, fields ...any) {
+	if !debug.Load() {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "auth: outcome=%s method=%s path=%q remote=%s", outcome, r.Method, r.URL.Path, r.RemoteAddr)
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := fmt.Sprint(fields[i+1])
+		if strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %v=%s", fields[i], v)
+	}
+	log.Print(b.String())
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogDecisionRedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	const plain = "12|s3cr3t-token-value"
+	r := httptest.NewRequest("GET", "/ws?token="+plain, nil)
+
+	SetDebug(false)
+	logDecision(r, "invalid_token", "token_id", tokenID(plain), "token", Fingerprint(plain))
+	if buf.Len() != 0 {
+		t.Fatalf("logged with debug off: %q", buf.String())
+	}
+
+	SetDebug(true)
+	t.Cleanup(func() { SetDebug(false) })
+	logDecision(r, "invalid_token", "token_id", tokenID(plain), "token", Fingerprint(plain), "error", "token not found")
+
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t") {
+		t.Fatalf("secret leaked into log: %q", out)
+	}
+	for _, want := range []string{"outcome=invalid_token", `path="/ws"`, "token_id=12", "token=" + Fingerprint(plain), `error="token not found"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %q lacks %q", out, want)
+		}
+	}
+}
@@ -2,79 +2,151 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"debtster-export/internal/domain"
-	"debtster-export/internal/repository"
+	"debtster-export/internal/tenant"
 )
 
 type ctxKey string
 
-const UserIDKey ctxKey = "userID"
+const (
+	UserIDKey    ctxKey = "userID"
+	AbilitiesKey ctxKey = "abilities"
+	// tenantKey is the id of the tenant whose database holds the credential
+	// of the request, see TenantHint.
+	tenantKey ctxKey = "tenant"
+)
+
+const tenantAbilityPrefix = "tenant:"
 
-func SanctumMiddleware(tokenRepo *repository.PersonalAccessTokenRepository) func(http.Handler) http.Handler {
+// TokenFinder resolves plain Sanctum tokens; implemented by
+// *repository.PersonalAccessTokenRepository.
+type TokenFinder interface {
+	FindTokenByPlainToken(ctx context.Context, plainToken string) (*domain.PersonalAccessToken, error)
+}
+
+// SanctumMiddleware authenticates requests by a personal access token
+// (Authorization header or ?token=); uses of the token are recorded in usage.
+func SanctumMiddleware(tokenRepo TokenFinder, usage *TokenUsage) func(http.Handler) http.Handler {
+	return tokenMiddleware(tokenRepo, usage, bearerCandidates)
+}
+
+// tokenMiddleware authenticates by the first token of candidates that resolves.
+func tokenMiddleware(tokenRepo TokenFinder, usage *TokenUsage, candidates func(*http.Request) []bearerCandidate) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Debug: request info
-			fmt.Printf("[AUTH] request %s %s from %s, UA=%q\n", r.Method, r.URL.String(), r.RemoteAddr, r.UserAgent())
-
-			// Try Authorization header first
-			authHeader := r.Header.Get("Authorization")
-			var pat *domain.PersonalAccessToken
-			if authHeader != "" {
-				fmt.Printf("[AUTH] Authorization header present: %q\n", authHeader)
-			}
-			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-				plainToken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
-				fmt.Printf("[AUTH] trying token from header: %q\n", plainToken)
-				if plainToken != "" {
-					p, err := tokenRepo.FindTokenByPlainToken(r.Context(), plainToken)
-					if err != nil {
-						fmt.Printf("[AUTH] token lookup (header) error: %v\n", err)
-					} else {
-						pat = p
-						fmt.Printf("[AUTH] token found by header: id=%d userID=%d expiresAt=%v abilities=%q\n", pat.ID, pat.UserID, pat.ExpiresAt, pat.Abilities)
-					}
-				}
-			}
-
-			// If not found in header, try token query parameter (useful for websocket connections)
-			if pat == nil {
-				token := r.URL.Query().Get("token")
-				if token != "" {
-					fmt.Printf("[AUTH] trying token from query param: %q\n", token)
-					p, err := tokenRepo.FindTokenByPlainToken(r.Context(), token)
-					if err != nil {
-						fmt.Printf("[AUTH] token lookup (query) error: %v\n", err)
-					} else {
-						pat = p
-						fmt.Printf("[AUTH] token found by query: id=%d userID=%d expiresAt=%v abilities=%q\n", pat.ID, pat.UserID, pat.ExpiresAt, pat.Abilities)
-					}
-				} else {
-					fmt.Printf("[AUTH] no token in query param\n")
+			var (
+				pat    *domain.PersonalAccessToken
+				owner  tenant.Tenant
+				source string
+			)
+			for _, c := range candidates(r) {
+				p, t, err := findToken(r.Context(), tokenRepo, c.token)
+				if err != nil {
+					logDecision(r, "invalid_token", "source", c.source, "token_id", tokenID(c.token),
+						"token", Fingerprint(c.token), "error", err)
+					continue
 				}
+				pat, owner, source = p, t, c.source
+				break
 			}
 
 			if pat == nil {
-				fmt.Printf("[AUTH] no valid token found -> 401\n")
+				logDecision(r, "unauthorized")
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
 			if pat.ExpiresAt != nil && pat.ExpiresAt.Before(time.Now()) {
-				fmt.Printf("[AUTH] token expired at %v -> 401\n", pat.ExpiresAt)
+				logDecision(r, "expired", "source", source, "token_id", pat.ID, "user_id", pat.UserID,
+					"expired_at", pat.ExpiresAt.Format(time.RFC3339))
 				http.Error(w, "Token expired", http.StatusUnauthorized)
 				return
 			}
 
-			fmt.Printf("[AUTH] authenticated user=%d (token id=%d)\n", pat.UserID, pat.ID)
+			logDecision(r, "ok", "source", source, "token_id", pat.ID, "user_id", pat.UserID, "tenant", owner.ID)
+			usage.record(r, owner, pat)
+			next.ServeHTTP(w, r.WithContext(withToken(r.Context(), owner, pat)))
+		})
+	}
+}
 
-			ctx := context.WithValue(r.Context(), UserIDKey, pat.UserID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+// findToken looks plainToken up in the database of the tenant named by the
+// request (see TenantHint), which binds the request to that tenant, and then
+// in the default database, where only a tenant ability of the token does.
+// owner is the tenant the token was found in, zero for the default database.
+func findToken(ctx context.Context, tokenRepo TokenFinder, plainToken string) (pat *domain.PersonalAccessToken, owner tenant.Tenant, err error) {
+	if t, ok := tenant.FromContext(ctx); ok {
+		if pat, err := tokenRepo.FindTokenByPlainToken(ctx, plainToken); err == nil {
+			return pat, t, nil
+		}
+		ctx = tenant.Without(ctx)
+	}
+	pat, err = tokenRepo.FindTokenByPlainToken(ctx, plainToken)
+	return pat, tenant.Tenant{}, err
+}
+
+// withToken attaches the user and abilities of pat, found in the database of
+// owner, to ctx.
+func withToken(ctx context.Context, owner tenant.Tenant, pat *domain.PersonalAccessToken) context.Context {
+	ctx = context.WithValue(ctx, UserIDKey, pat.UserID)
+	ctx = context.WithValue(ctx, AbilitiesKey, parseAbilities(pat.Abilities))
+	if owner.ID != "" {
+		ctx = context.WithValue(ctx, tenantKey, owner.ID)
+	}
+	return ctx
+}
+
+type bearerCandidate struct {
+	source string
+	token  string
+}
+
+// bearerCandidates lists the tokens a request carries, in the order they are
+// tried: the Authorization header, then ?token=.
+func bearerCandidates(r *http.Request) []bearerCandidate {
+	var out []bearerCandidate
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		if t := strings.TrimSpace(strings.TrimPrefix(h, "Bearer ")); t != "" {
+			out = append(out, bearerCandidate{source: "header", token: t})
+		}
+	}
+	if t := r.URL.Query().Get("token"); t != "" {
+		out = append(out, bearerCandidate{source: "query", token: t})
+	}
+	return out
+}
+
+// OptionalSanctumMiddleware attaches the user of a valid token (Authorization
+// header or ?token=) to the context but lets anonymous requests through.
+func OptionalSanctumMiddleware(tokenRepo TokenFinder, usage *TokenUsage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var plainToken string
+			if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+				plainToken = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+			}
+			if plainToken == "" {
+				plainToken = r.URL.Query().Get("token")
+			}
+			if plainToken == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			pat, owner, err := findToken(r.Context(), tokenRepo, plainToken)
+			if err != nil || (pat.ExpiresAt != nil && pat.ExpiresAt.Before(time.Now())) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			usage.record(r, owner, pat)
+			next.ServeHTTP(w, r.WithContext(withToken(r.Context(), owner, pat)))
 		})
 	}
 }
@@ -86,3 +158,84 @@ func GetUserID(ctx context.Context) (int64, error) {
 	}
 	return userID, nil
 }
+
+// GetAbilities returns the abilities of the token that authenticated the request.
+func GetAbilities(ctx context.Context) []string {
+	abilities, _ := ctx.Value(AbilitiesKey).([]string)
+	return abilities
+}
+
+// HasAbility reports whether the token that authenticated the request was
+// granted ability; the "*" of unrestricted tokens does not count, so
+// privileged abilities have to be granted explicitly.
+func HasAbility(ctx context.Context, ability string) bool {
+	return slices.Contains(GetAbilities(ctx), ability)
+}
+
+// parseAbilities decodes the Sanctum abilities JSON array; malformed values yield no abilities.
+func parseAbilities(raw string) []string {
+	var abilities []string
+	if err := json.Unmarshal([]byte(raw), &abilities); err != nil {
+		return nil
+	}
+	return abilities
+}
+
+// TenantHint attaches the tenant named by header, if it is known, to the
+// context so that the credentials of the request are looked up in the
+// database of that tenant. It authorizes nothing: TenantMiddleware decides
+// the tenant of the request. With an empty registry it is a no-op.
+func TenantHint(registry *tenant.Registry, header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := registry.Get(strings.TrimSpace(r.Header.Get(header))); ok {
+				r = r.WithContext(tenant.WithTenant(r.Context(), t))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TenantMiddleware attaches the tenant bound to the credential of the request
+// to the context: the tenant whose database holds the token, or the one of a
+// "tenant:<id>" ability. A credential bound to no tenant, or to several, is
+// refused; header may only repeat the bound tenant. It must run after the
+// authentication middlewares. With an empty registry it is a no-op.
+func TenantMiddleware(registry *tenant.Registry, header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !registry.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var bound []string
+			if id, ok := r.Context().Value(tenantKey).(string); ok {
+				bound = append(bound, id)
+			}
+			for _, ability := range GetAbilities(r.Context()) {
+				if id, ok := strings.CutPrefix(ability, tenantAbilityPrefix); ok && !slices.Contains(bound, id) {
+					bound = append(bound, id)
+				}
+			}
+			headerTenant := strings.TrimSpace(r.Header.Get(header))
+
+			if len(bound) == 0 {
+				http.Error(w, "Tenant required", http.StatusForbidden)
+				return
+			}
+			if len(bound) > 1 || (headerTenant != "" && headerTenant != bound[0]) {
+				http.Error(w, "Tenant mismatch", http.StatusForbidden)
+				return
+			}
+
+			t, ok := registry.Get(bound[0])
+			if !ok {
+				http.Error(w, "Unknown tenant", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), t)))
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/tenant"
+)
+
+// tenantTokens holds the tokens of each tenant database, "" being the default one.
+type tenantTokens map[string]map[string]*domain.PersonalAccessToken
+
+func (f tenantTokens) FindTokenByPlainToken(ctx context.Context, plainToken string) (*domain.PersonalAccessToken, error) {
+	t, _ := tenant.FromContext(ctx)
+	if pat, ok := f[t.ID][plainToken]; ok {
+		return pat, nil
+	}
+	return nil, errors.New("token not found")
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	registry := tenant.NewRegistry(map[string]string{"acme": "acme", "beta": "beta"})
+	tokens := tenantTokens{
+		"": {
+			"plain":   {ID: 1, UserID: 1, Abilities: `["*"]`},
+			"bound":   {ID: 2, UserID: 2, Abilities: `["tenant:acme"]`},
+			"ghost":   {ID: 3, UserID: 3, Abilities: `["tenant:ghost"]`},
+			"doubled": {ID: 4, UserID: 4, Abilities: `["tenant:acme","tenant:beta"]`},
+		},
+		"acme": {
+			"acme-user":  {ID: 1, UserID: 10, Abilities: `["*"]`},
+			"acme-beta":  {ID: 2, UserID: 11, Abilities: `["tenant:beta"]`},
+			"acme-bound": {ID: 3, UserID: 12, Abilities: `["tenant:acme"]`},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "token of the tenant database", token: "acme-user", header: "acme", wantStatus: 200, wantTenant: "acme"},
+		{name: "token bound by ability", token: "bound", wantStatus: 200, wantTenant: "acme"},
+		{name: "header repeats the binding", token: "bound", header: "acme", wantStatus: 200, wantTenant: "acme"},
+		{name: "header repeats the database binding", token: "acme-bound", header: "acme", wantStatus: 200, wantTenant: "acme"},
+		// заголовок не может переопределить привязку токена
+		{name: "header overrides ability", token: "bound", header: "beta", wantStatus: http.StatusForbidden},
+		{name: "ability contradicts database", token: "acme-beta", header: "acme", wantStatus: http.StatusForbidden},
+		{name: "several tenant abilities", token: "doubled", wantStatus: http.StatusForbidden},
+		// токен без привязки не выбирает тенанта заголовком
+		{name: "unbound token picks tenant by header", token: "plain", header: "acme", wantStatus: http.StatusForbidden},
+		{name: "unbound token without header", token: "plain", wantStatus: http.StatusForbidden},
+		{name: "token of another database", token: "acme-user", header: "beta", wantStatus: http.StatusUnauthorized},
+		{name: "unknown tenant", token: "ghost", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tn, _ := tenant.FromContext(r.Context())
+				got = tn.ID
+			})
+			h := TenantHint(registry, "X-Tenant")(SanctumMiddleware(tokens, nil)(TenantMiddleware(registry, "X-Tenant")(next)))
+
+			r := httptest.NewRequest("GET", "/export", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.header != "" {
+				r.Header.Set("X-Tenant", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.wantStatus)
+			}
+			if got != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", got, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantMiddlewareSingleTenant(t *testing.T) {
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := tenant.FromContext(r.Context())
+		called = !ok
+	})
+	r := httptest.NewRequest("GET", "/export", nil)
+	r.Header.Set("X-Tenant", "acme")
+	TenantHint(nil, "X-Tenant")(TenantMiddleware(nil, "X-Tenant")(next)).ServeHTTP(httptest.NewRecorder(), r)
+	if !called {
+		t.Fatal("single-tenant mode: want the request passed on without a tenant")
+	}
+}
+
+func TestTokenUsageRecordsTenant(t *testing.T) {
+	registry := tenant.NewRegistry(map[string]string{"acme": "acme"})
+	tokens := tenantTokens{"acme": {"acme-user": {ID: 1, UserID: 10}}, "": {"bound": {ID: 1, UserID: 20, Abilities: `["tenant:acme"]`}}}
+	store := &tenantUsageStore{}
+	usage := NewTokenUsage(store, 0)
+	h := TenantHint(registry, "X-Tenant")(SanctumMiddleware(tokens, usage)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	for _, token := range []string{"acme-user", "bound"} {
+		r := httptest.NewRequest("GET", "/export", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Tenant", "acme")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if err := usage.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// токены с одинаковым id из разных баз пишутся каждый в свою базу
+	if store.users["acme"] != 10 || store.users[""] != 20 {
+		t.Fatalf("uses by tenant = %v", store.users)
+	}
+}
+
+type tenantUsageStore struct {
+	users map[string]int64
+}
+
+func (s *tenantUsageStore) RecordUsage(ctx context.Context, usage []domain.TokenUsage) error {
+	if s.users == nil {
+		s.users = map[string]int64{}
+	}
+	t, _ := tenant.FromContext(ctx)
+	for _, u := range usage {
+		s.users[t.ID] = u.UserID
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// phpUnserialize decodes the output of PHP's serialize(): strings, numbers,
+// booleans and null become Go values, arrays and objects a map keyed by the
+// string form of their keys. Objects with custom serialization and references
+// decode to nil.
+func phpUnserialize(s string) (any, error) {
+	d := phpDecoder{s: s}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.s) {
+		return nil, fmt.Errorf("unserialize: trailing data at %d", d.pos)
+	}
+	return v, nil
+}
+
+type phpDecoder struct {
+	s   string
+	pos int
+}
+
+var errPHPSyntax = errors.New("unserialize: invalid data")
+
+func (d *phpDecoder) value() (any, error) {
+	if d.pos+1 >= len(d.s) {
+		return nil, errPHPSyntax
+	}
+	typ := d.s[d.pos]
+	if typ == 'N' {
+		d.pos++
+		return nil, d.expect(';')
+	}
+	d.pos++
+	if err := d.expect(':'); err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case 'b', 'i', 'd', 'r', 'R':
+		raw, err := d.until(';')
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case 'b':
+			return raw == "1", nil
+		case 'i':
+			return strconv.ParseInt(raw, 10, 64)
+		case 'd':
+			return strconv.ParseFloat(raw, 64)
+		}
+		return nil, nil
+	case 's':
+		str, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		return str, d.expect(';')
+	case 'a':
+		return d.array()
+	case 'O':
+		if _, err := d.str(); err != nil {
+			return nil, err
+		}
+		if err := d.expect(':'); err != nil {
+			return nil, err
+		}
+		return d.array()
+	case 'C':
+		if _, err := d.str(); err != nil {
+			return nil, err
+		}
+		if err := d.expect(':'); err != nil {
+			return nil, err
+		}
+		n, err := d.length(':')
+		if err != nil {
+			return nil, err
+		}
+		if err := d.expect('{'); err != nil {
+			return nil, err
+		}
+		if d.pos+n >= len(d.s) {
+			return nil, errPHPSyntax
+		}
+		d.pos += n
+		return nil, d.expect('}')
+	}
+	return nil, fmt.Errorf("unserialize: unknown type %q at %d", typ, d.pos-2)
+}
+
+// array reads "n:{key;value;...}" with the type prefix already consumed.
+func (d *phpDecoder) array() (map[string]any, error) {
+	n, err := d.length(':')
+	if err != nil {
+		return nil, err
+	}
+	if err := d.expect('{'); err != nil {
+		return nil, err
+	}
+	// every entry takes at least 4 bytes ("N;N;"), so n cannot preallocate more
+	out := make(map[string]any, min(n, (len(d.s)-d.pos)/4))
+	for range n {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		out[fmt.Sprint(k)] = v
+	}
+	return out, d.expect('}')
+}
+
+// str reads `len:"bytes"`.
+func (d *phpDecoder) str() (string, error) {
+	n, err := d.length(':')
+	if err != nil {
+		return "", err
+	}
+	if err := d.expect('"'); err != nil {
+		return "", err
+	}
+	if d.pos+n > len(d.s) {
+		return "", errPHPSyntax
+	}
+	str := d.s[d.pos : d.pos+n]
+	d.pos += n
+	return str, d.expect('"')
+}
+
+func (d *phpDecoder) length(end byte) (int, error) {
+	raw, err := d.until(end)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, errPHPSyntax
+	}
+	return n, nil
+}
+
+func (d *phpDecoder) until(end byte) (string, error) {
+	i := strings.IndexByte(d.s[d.pos:], end)
+	if i < 0 {
+		return "", errPHPSyntax
+	}
+	raw := d.s[d.pos : d.pos+i]
+	d.pos += i + 1
+	return raw, nil
+}
+
+func (d *phpDecoder) expect(c byte) error {
+	if d.pos >= len(d.s) || d.s[d.pos] != c {
+		return errPHPSyntax
+	}
+	d.pos++
+	return nil
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPHPUnserialize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{name: "null", in: "N;", want: nil},
+		{name: "bool", in: "b:1;", want: true},
+		{name: "int", in: "i:-42;", want: int64(-42)},
+		{name: "float", in: "d:1.5;", want: 1.5},
+		{name: "string", in: `s:5:"a;b:c";`, want: "a;b:c"},
+		// длина строки в байтах, а не в символах
+		{name: "utf-8 string", in: `s:12:"Иванов";`, want: "Иванов"},
+		{name: "array", in: `a:2:{i:0;s:1:"x";s:1:"k";b:0;}`, want: map[string]any{"0": "x", "k": false}},
+		{name: "nested arrays and objects",
+			in: `a:2:{s:5:"flash";a:2:{s:3:"old";a:0:{}s:3:"new";a:1:{i:0;s:6:"status";}}s:4:"user";O:8:"stdClass":2:{s:2:"id";i:7;s:4:"tags";a:1:{i:0;N;}}}`,
+			want: map[string]any{
+				"flash": map[string]any{"old": map[string]any{}, "new": map[string]any{"0": "status"}},
+				"user":  map[string]any{"id": int64(7), "tags": map[string]any{"0": nil}},
+			}},
+		{name: "custom serialization", in: `a:1:{s:1:"c";C:3:"Foo":4:{abcd}}`, want: map[string]any{"c": nil}},
+		{name: "reference", in: `a:2:{i:0;i:1;i:1;R:2;}`, want: map[string]any{"0": int64(1), "1": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := phpUnserialize(tt.in)
+			if err != nil {
+				t.Fatalf("phpUnserialize(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("phpUnserialize(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPHPUnserializeMalformed(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"N",
+		"x:1;",
+		"i:1",
+		"i:abc;",
+		"b:1;trailing",
+		`s:10:"short";`,
+		`s:-1:"";`,
+		`s:3:"abc"`,
+		`s:3:"abcd";`,
+		`a:2:{i:0;i:1;}`,
+		`a:1:{i:0;i:1;`,
+		`a:-1:{}`,
+		`a:1000000000000:{}`,
+		`a:1:{s:1:"k";a:1:{s:1:"n";}}`,
+		`O:8:"stdClass":1:{s:1:"a";}`,
+		`C:3:"Foo":100:{abcd}`,
+	} {
+		if v, err := phpUnserialize(in); err == nil {
+			t.Errorf("phpUnserialize(%q) = %#v, want an error", in, v)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// sessionLoginPrefix starts the session attribute holding the user id of the
+// "web" guard: "login_web_" + sha1(Illuminate\Auth\SessionGuard).
+const sessionLoginPrefix = "login_web_"
+
+// defaultSessionTenantAttribute is the session attribute holding the tenant
+// of the logged in user, see SetTenantAttribute.
+const defaultSessionTenantAttribute = "tenant_id"
+
+// SessionStore reads Laravel sessions; implemented by *clients.RedisClient,
+// whose prefix matches the connection prefix of the Laravel app.
+type SessionStore interface {
+	Get(ctx context.Context, key string) (string, error)
+}
+
+// LaravelSessions authenticates same-origin requests of the Laravel SPA by its
+// session cookie. The cookie is decrypted with the APP_KEY of the Laravel app
+// and the session is read from the shared Redis cache store; only the
+// unencrypted redis session driver is supported.
+type LaravelSessions struct {
+	store      SessionStore
+	key        []byte
+	cookie     string
+	keyPrefix  string
+	tenantAttr string
+}
+
+// NewLaravelSessions configures session auth; appKey is the APP_KEY of the
+// Laravel app ("base64:..."), cookie the session cookie name and keyPrefix the
+// prefix of the cache store sessions are kept in.
+func NewLaravelSessions(store SessionStore, appKey, cookie, keyPrefix string) (*LaravelSessions, error) {
+	key := []byte(appKey)
+	if raw, ok := strings.CutPrefix(appKey, "base64:"); ok {
+		decoded, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid APP_KEY: %w", err)
+		}
+		key = decoded
+	}
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("invalid APP_KEY: want a 16 or 32 byte key, got %d bytes", len(key))
+	}
+	if cookie == "" {
+		cookie = "laravel_session"
+	}
+	return &LaravelSessions{store: store, key: key, cookie: cookie, keyPrefix: keyPrefix,
+		tenantAttr: defaultSessionTenantAttribute}, nil
+}
+
+// SetTenantAttribute changes the session attribute the tenant of the logged
+// in user is read from (default "tenant_id"). Session users are bound to that
+// tenant like tokens with a "tenant:<id>" ability.
+func (s *LaravelSessions) SetTenantAttribute(name string) {
+	s.tenantAttr = name
+}
+
+// laravelSession is what the service reads from a logged in session.
+type laravelSession struct {
+	userID int64
+	csrf   string
+	// tenant is "" when the session names none.
+	tenant string
+}
+
+// session reads the session the request cookie refers to.
+func (s *LaravelSessions) session(r *http.Request) (laravelSession, error) {
+	c, err := r.Cookie(s.cookie)
+	if err != nil {
+		return laravelSession{}, err
+	}
+	id, err := s.decryptCookie(s.cookie, c.Value)
+	if err != nil {
+		return laravelSession{}, err
+	}
+
+	raw, err := s.store.Get(r.Context(), s.keyPrefix+id)
+	if err != nil {
+		return laravelSession{}, fmt.Errorf("session lookup: %w", err)
+	}
+	return s.parseSession(raw)
+}
+
+// parseSession decodes a session as the Laravel cache store keeps it.
+func (s *LaravelSessions) parseSession(raw string) (laravelSession, error) {
+	// the cache store serializes the already serialized session attributes
+	payload, err := phpUnserialize(raw)
+	if err != nil {
+		return laravelSession{}, fmt.Errorf("session payload: %w", err)
+	}
+	serialized, ok := payload.(string)
+	if !ok {
+		return laravelSession{}, errors.New("session payload: not a serialized string")
+	}
+	data, err := phpUnserialize(serialized)
+	if err != nil {
+		return laravelSession{}, fmt.Errorf("session attributes: %w", err)
+	}
+	attrs, ok := data.(map[string]any)
+	if !ok {
+		return laravelSession{}, errors.New("session attributes: not an array")
+	}
+
+	sess := laravelSession{}
+	sess.csrf, _ = attrs["_token"].(string)
+	switch t := attrs[s.tenantAttr].(type) {
+	case string:
+		sess.tenant = t
+	case int64:
+		sess.tenant = strconv.FormatInt(t, 10)
+	}
+	for k, v := range attrs {
+		if !strings.HasPrefix(k, sessionLoginPrefix) {
+			continue
+		}
+		switch id := v.(type) {
+		case int64:
+			sess.userID = id
+			return sess, nil
+		case string:
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				sess.userID = n
+				return sess, nil
+			}
+		}
+	}
+	return laravelSession{}, errors.New("session is not logged in")
+}
+
+// checkCSRF verifies the token the SPA sends with unsafe requests: the
+// X-XSRF-TOKEN header (the encrypted XSRF-TOKEN cookie) or a plain X-CSRF-TOKEN.
+func (s *LaravelSessions) checkCSRF(r *http.Request, want string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if want == "" {
+		return false
+	}
+	got := r.Header.Get("X-CSRF-TOKEN")
+	if got == "" {
+		if header := r.Header.Get("X-XSRF-TOKEN"); header != "" {
+			got, _ = s.decryptCookie("XSRF-TOKEN", header)
+		}
+	}
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// decryptCookie decrypts a cookie set by Laravel's EncryptCookies middleware
+// and strips its name-bound prefix.
+func (s *LaravelSessions) decryptCookie(name, value string) (string, error) {
+	plain, err := s.decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha1.New, s.key)
+	mac.Write([]byte(name + "v2"))
+	prefix := hex.EncodeToString(mac.Sum(nil)) + "|"
+	value, ok := strings.CutPrefix(plain, prefix)
+	if !ok {
+		return "", errors.New("cookie was issued for another name")
+	}
+	return value, nil
+}
+
+// decrypt opens a payload of Laravel's Encrypter (AES-CBC with an HMAC-SHA256).
+func (s *LaravelSessions) decrypt(payload string) (string, error) {
+	if unescaped, err := url.PathUnescape(payload); err == nil {
+		payload = unescaped
+	}
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload: %w", err)
+	}
+	var p struct {
+		IV    string `json:"iv"`
+		Value string `json:"value"`
+		MAC   string `json:"mac"`
+	}
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return "", fmt.Errorf("invalid payload: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(p.IV + p.Value))
+	want, err := hex.DecodeString(p.MAC)
+	if err != nil || !hmac.Equal(mac.Sum(nil), want) {
+		return "", errors.New("invalid payload MAC")
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(p.IV)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload iv: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(p.Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload value: %w", err)
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return "", errors.New("invalid payload size")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return "", errors.New("invalid payload padding")
+	}
+	for _, b := range data[len(data)-pad:] {
+		if int(b) != pad {
+			return "", errors.New("invalid payload padding")
+		}
+	}
+	return string(data[:len(data)-pad]), nil
+}
+
+// SessionMiddleware authenticates requests without a bearer token or API key
+// by the Laravel session cookie and hands all others, and those with an
+// invalid session, to fallback (SanctumMiddleware). Unsafe requests must carry
+// the CSRF token of the session. Session users get the "*" ability like SPA
+// requests in Sanctum and are bound to the tenant of their session.
+func SessionMiddleware(sessions *LaravelSessions, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		other := fallback(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if sessions == nil || r.Header.Get("Authorization") != "" || r.URL.Query().Get("token") != "" {
+				other.ServeHTTP(w, r)
+				return
+			}
+			if _, err := r.Cookie(sessions.cookie); err != nil {
+				other.ServeHTTP(w, r)
+				return
+			}
+
+			sess, err := sessions.session(r)
+			if err != nil {
+				logDecision(r, "invalid_session", "error", err)
+				other.ServeHTTP(w, r)
+				return
+			}
+			if !sessions.checkCSRF(r, sess.csrf) {
+				logDecision(r, "csrf_mismatch", "source", "session", "user_id", sess.userID)
+				http.Error(w, "CSRF token mismatch", 419)
+				return
+			}
+
+			logDecision(r, "ok", "source", "session", "user_id", sess.userID, "tenant", sess.tenant)
+
+			ctx := context.WithValue(r.Context(), UserIDKey, sess.userID)
+			ctx = context.WithValue(ctx, AbilitiesKey, []string{"*"})
+			if sess.tenant != "" {
+				ctx = context.WithValue(ctx, tenantKey, sess.tenant)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"debtster-export/internal/tenant"
+)
+
+// testAppKey is the APP_KEY the fixtures are encrypted with.
+const testAppKey = "base64:dGVzdC1hcHAta2V5LWZvci1kZWJ0c3Rlci1leHBvcnQ="
+
+// sessionCookieFixture is a laravel_session cookie of session id
+// "QmZ0y3sVq8mBn1uXKyWfW0ZzTq2mJ6rHh4cP9dLe" as EncryptCookies sets it
+// (url-encoded by setcookie).
+const sessionCookieFixture = "eyJpdiI6IkFBRUNBd1FGQmdjSUNRb0xEQTBPRHc9PSIsInZhbHVlIjoiUi8vaFczQzFLakpBeGVMUVRUZ0lOVG1UMSs2ZU9IQzRSaEVSbjV6T1dTY1lrajlrdHhsaVd5WVEraGlxd2lLeE1DMGVoOGRqT2tWR1FIWm9hSXVIM29oSDF2UHFMSUFrNTI0NFlDS1pNUlZCK0FzQUNud1JGenU3RzRSSGRQMzMiLCJtYWMiOiIyOWJhMTdiZmIwMWFmNjM5ZGZjNGZmYjUyY2NiZjY5NmFiOWMxN2U2ZWFjZWJjNzRlMjkxZTIyNjM0ZTgyMjQxIiwidGFnIjoiIn0%3D"
+
+const testSessionID = "QmZ0y3sVq8mBn1uXKyWfW0ZzTq2mJ6rHh4cP9dLe"
+
+// laravelEncrypt encrypts plain like Illuminate\Encryption\Encrypter::encrypt
+// without serialization: AES-CBC with PKCS#7 padding, the HMAC-SHA256 of
+// base64(iv).base64(value), as base64 of {"iv","value","mac","tag"}.
+func laravelEncrypt(t *testing.T, key []byte, plain string, iv []byte) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append([]byte(plain), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return laravelPayload(key, base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(data))
+}
+
+// laravelPayload signs iv and value as given.
+func laravelPayload(key []byte, iv, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(iv + value))
+	payload := fmt.Sprintf(`{"iv":%q,"value":%q,"mac":%q,"tag":""}`, iv, value, hex.EncodeToString(mac.Sum(nil)))
+	return base64.StdEncoding.EncodeToString([]byte(payload))
+}
+
+// cookiePlain prefixes value like CookieValuePrefix::create.
+func cookiePlain(key []byte, name, value string) string {
+	mac := hmac.New(sha1.New, key)
+	mac.Write([]byte(name + "v2"))
+	return hex.EncodeToString(mac.Sum(nil)) + "|" + value
+}
+
+func testSessions(t *testing.T, store SessionStore) *LaravelSessions {
+	t.Helper()
+	s, err := NewLaravelSessions(store, testAppKey, "", "laravel_cache:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLaravelSessionsDecryptCookie(t *testing.T) {
+	s := testSessions(t, nil)
+	iv := []byte("0123456789abcdef")
+	valid := laravelEncrypt(t, s.key, cookiePlain(s.key, "laravel_session", testSessionID), iv)
+
+	// испорченный MAC
+	tamperedMAC := []byte(valid)
+	tamperedMAC[len(tamperedMAC)-8] ^= 1
+
+	// подписанные, но некорректные iv, длина и дополнение
+	block, _ := aes.NewCipher(s.key)
+	badPadding := make([]byte, aes.BlockSize)
+	copy(badPadding, "session-id\x03\x03\x03\x03\x02\x03")
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(badPadding, badPadding)
+	zeroPadding := make([]byte, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(zeroPadding, zeroPadding)
+	b64 := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name    string
+		cookie  string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "fixture", cookie: "laravel_session", value: sessionCookieFixture, want: testSessionID},
+		{name: "url-encoded", cookie: "laravel_session", value: url.QueryEscape(valid), want: testSessionID},
+		{name: "valid", cookie: "laravel_session", value: valid, want: testSessionID},
+		{name: "issued for another cookie", cookie: "XSRF-TOKEN", value: valid, wantErr: true},
+		{name: "tampered MAC", cookie: "laravel_session", value: string(tamperedMAC), wantErr: true},
+		{name: "other key", cookie: "laravel_session", value: laravelEncrypt(t, bytes.Repeat([]byte("k"), 32), cookiePlain(s.key, "laravel_session", testSessionID), iv), wantErr: true},
+		{name: "short iv", cookie: "laravel_session", value: laravelPayload(s.key, b64(iv[:8]), b64(badPadding)), wantErr: true},
+		{name: "iv not base64", cookie: "laravel_session", value: laravelPayload(s.key, "!!", b64(badPadding)), wantErr: true},
+		{name: "bad padding", cookie: "laravel_session", value: laravelPayload(s.key, b64(iv), b64(badPadding)), wantErr: true},
+		{name: "zero padding", cookie: "laravel_session", value: laravelPayload(s.key, b64(iv), b64(zeroPadding)), wantErr: true},
+		{name: "partial block", cookie: "laravel_session", value: laravelPayload(s.key, b64(iv), b64(badPadding[:10])), wantErr: true},
+		{name: "empty value", cookie: "laravel_session", value: laravelPayload(s.key, b64(iv), ""), wantErr: true},
+		{name: "not base64", cookie: "laravel_session", value: "not base64!", wantErr: true},
+		{name: "not json", cookie: "laravel_session", value: b64([]byte("iv=1")), wantErr: true},
+		{name: "mac not hex", cookie: "laravel_session", value: b64([]byte(`{"iv":"","value":"","mac":"zz"}`)), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.decryptCookie(tt.cookie, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decryptCookie = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decryptCookie: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("decryptCookie = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLaravelSessionsKey(t *testing.T) {
+	for _, key := range []string{"base64:!!", "base64:" + base64.StdEncoding.EncodeToString([]byte("short")), "too-short-plain-key"} {
+		if _, err := NewLaravelSessions(nil, key, "", ""); err == nil {
+			t.Errorf("key %q: want an error", key)
+		}
+	}
+	// AES-128-CBC: 16-байтовый ключ без base64
+	s, err := NewLaravelSessions(nil, "0123456789abcdef", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := laravelEncrypt(t, s.key, cookiePlain(s.key, "laravel_session", "id"), []byte("fedcba9876543210"))
+	if got, err := s.decryptCookie("laravel_session", value); err != nil || got != "id" {
+		t.Fatalf("decryptCookie = %q, %v", got, err)
+	}
+}
+
+// phpString serializes s like PHP's serialize().
+func phpString(s string) string {
+	return fmt.Sprintf(`s:%d:"%s";`, len(s), s)
+}
+
+// cachedSession is a session as the Laravel cache store keeps it:
+// serialize(serialize($attributes)).
+func cachedSession(attrs string) string {
+	return phpString(attrs)
+}
+
+type fakeSessionStore map[string]string
+
+func (f fakeSessionStore) Get(ctx context.Context, key string) (string, error) {
+	if v, ok := f[key]; ok {
+		return v, nil
+	}
+	return "", errors.New("redis: nil")
+}
+
+func TestLaravelSessionsParseSession(t *testing.T) {
+	s := testSessions(t, nil)
+	login := "login_web_59ba36addc2b2f9401580f014c7f58ea4e30989d"
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    laravelSession
+		wantErr bool
+	}{
+		{name: "logged in", raw: cachedSession(`a:4:{s:6:"_token";s:5:"csrf1";s:9:"_previous";a:1:{s:3:"url";s:4:"http";}` + phpString(login) + `i:42;s:9:"tenant_id";s:4:"acme";}`),
+			want: laravelSession{userID: 42, csrf: "csrf1", tenant: "acme"}},
+		{name: "string user id and numeric tenant", raw: cachedSession(`a:3:{s:6:"_token";s:1:"c";` + phpString(login) + `s:2:"42";s:9:"tenant_id";i:7;}`),
+			want: laravelSession{userID: 42, csrf: "c", tenant: "7"}},
+		{name: "no tenant", raw: cachedSession(`a:1:{` + phpString(login) + `i:42;}`),
+			want: laravelSession{userID: 42}},
+		{name: "logged out", raw: cachedSession(`a:1:{s:6:"_token";s:1:"c";}`), wantErr: true},
+		{name: "not double serialized", raw: `a:1:{` + phpString(login) + `i:42;}`, wantErr: true},
+		{name: "attributes not an array", raw: cachedSession(`s:1:"x";`), wantErr: true},
+		{name: "truncated payload", raw: cachedSession(`a:1:{` + phpString(login) + `i:42;}`)[:20], wantErr: true},
+		{name: "truncated attributes", raw: phpString(`a:1:{` + phpString(login) + `i:4`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.parseSession(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSession = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSession: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSession = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	login := phpString("login_web_59ba36addc2b2f9401580f014c7f58ea4e30989d")
+	store := fakeSessionStore{
+		"laravel_cache:" + testSessionID: cachedSession(`a:3:{s:6:"_token";s:5:"csrf1";` + login + `i:42;s:9:"tenant_id";s:4:"acme";}`),
+		"laravel_cache:untenanted":       cachedSession(`a:2:{s:6:"_token";s:5:"csrf2";` + login + `i:43;}`),
+	}
+	sessions := testSessions(t, store)
+	registry := tenant.NewRegistry(map[string]string{"acme": "acme", "beta": "beta"})
+	cookie := func(id string) string {
+		return laravelEncrypt(t, sessions.key, cookiePlain(sessions.key, "laravel_session", id), []byte("0123456789abcdef"))
+	}
+	xsrf := laravelEncrypt(t, sessions.key, cookiePlain(sessions.key, "XSRF-TOKEN", "csrf1"), []byte("abcdef0123456789"))
+
+	tests := []struct {
+		name       string
+		method     string
+		cookie     string
+		header     http.Header
+		wantStatus int
+		wantUser   int64
+		wantTenant string
+	}{
+		{name: "get", method: "GET", cookie: cookie(testSessionID), wantStatus: 200, wantUser: 42, wantTenant: "acme"},
+		{name: "fixture cookie", method: "GET", cookie: sessionCookieFixture, wantStatus: 200, wantUser: 42, wantTenant: "acme"},
+		{name: "post without csrf", method: "POST", cookie: cookie(testSessionID), wantStatus: 419},
+		{name: "post with wrong csrf", method: "POST", cookie: cookie(testSessionID), header: http.Header{"X-Csrf-Token": {"csrf2"}}, wantStatus: 419},
+		{name: "post with csrf", method: "POST", cookie: cookie(testSessionID), header: http.Header{"X-Csrf-Token": {"csrf1"}}, wantStatus: 200, wantUser: 42, wantTenant: "acme"},
+		{name: "post with xsrf cookie", method: "POST", cookie: cookie(testSessionID), header: http.Header{"X-Xsrf-Token": {xsrf}}, wantStatus: 200, wantUser: 42, wantTenant: "acme"},
+		// тенант сессии нельзя сменить заголовком
+		{name: "header names another tenant", method: "GET", cookie: cookie(testSessionID), header: http.Header{"X-Tenant": {"beta"}}, wantStatus: http.StatusForbidden},
+		{name: "session without tenant", method: "GET", cookie: cookie("untenanted"), header: http.Header{"X-Tenant": {"acme"}}, wantStatus: http.StatusForbidden},
+		{name: "unknown session falls back", method: "GET", cookie: cookie("gone"), wantStatus: http.StatusUnauthorized},
+		{name: "forged cookie falls back", method: "GET", cookie: "forged", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser int64
+			var gotTenant string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = GetUserID(r.Context())
+				tn, _ := tenant.FromContext(r.Context())
+				gotTenant = tn.ID
+				if !HasAbility(r.Context(), "*") {
+					t.Error("session user lacks the * ability")
+				}
+			})
+			h := TenantHint(registry, "X-Tenant")(SessionMiddleware(sessions, SanctumMiddleware(fakeTokens{}, nil))(TenantMiddleware(registry, "X-Tenant")(next)))
+
+			r := httptest.NewRequest(tt.method, "/export", nil)
+			r.AddCookie(&http.Cookie{Name: "laravel_session", Value: tt.cookie})
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.wantStatus)
+			}
+			if gotUser != tt.wantUser || gotTenant != tt.wantTenant {
+				t.Errorf("user %d, tenant %q; want %d, %q", gotUser, gotTenant, tt.wantUser, tt.wantTenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/tenant"
+)
+
+// maxUserAgentLen caps stored user agents; longer ones are truncated.
+const maxUserAgentLen = 512
+
+// TokenUsageStore persists token usage; implemented by
+// *repository.PersonalAccessTokenRepository.
+type TokenUsageStore interface {
+	RecordUsage(ctx context.Context, usage []domain.TokenUsage) error
+}
+
+// TokenUsage records the last use of the personal access tokens this service
+// authenticates, like Sanctum does for the main application. Uses are kept in
+// memory, one per token, and written in batches by Run, so authentication
+// never waits for the database. A nil *TokenUsage records nothing.
+type TokenUsage struct {
+	store    TokenUsageStore
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[usageKey]domain.TokenUsage
+}
+
+// usageKey identifies a token: ids are per tenant database, the zero tenant
+// is the default one.
+type usageKey struct {
+	tenant tenant.Tenant
+	id     int64
+}
+
+// NewTokenUsage returns a recorder writing to store every interval.
+func NewTokenUsage(store TokenUsageStore, interval time.Duration) *TokenUsage {
+	return &TokenUsage{store: store, interval: interval, pending: map[usageKey]domain.TokenUsage{}}
+}
+
+// record notes that pat, found in the database of t, authenticated r; only
+// the latest use per token is kept.
+func (u *TokenUsage) record(r *http.Request, t tenant.Tenant, pat *domain.PersonalAccessToken) {
+	if u == nil {
+		return
+	}
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLen {
+		ua = ua[:maxUserAgentLen]
+	}
+
+	u.mu.Lock()
+	u.pending[usageKey{t, pat.ID}] = domain.TokenUsage{
+		TokenID:   pat.ID,
+		UserID:    pat.UserID,
+		UsedAt:    time.Now(),
+		IP:        ip,
+		UserAgent: ua,
+	}
+	u.mu.Unlock()
+}
+
+// Run flushes recorded uses every interval until ctx is done; call Flush
+// afterwards to write the last ones.
+func (u *TokenUsage) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := u.Flush(ctx); err != nil {
+				log.Printf("token usage: %v", err)
+			}
+		}
+	}
+}
+
+// Flush writes the uses recorded since the last flush, to the database of
+// their tenant. On failure they are put back unless a newer use of the same
+// token was recorded meanwhile.
+func (u *TokenUsage) Flush(ctx context.Context) error {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	batches := make(map[tenant.Tenant][]domain.TokenUsage)
+	for key, usage := range u.pending {
+		batches[key.tenant] = append(batches[key.tenant], usage)
+	}
+	u.pending = map[usageKey]domain.TokenUsage{}
+	u.mu.Unlock()
+
+	var errs []error
+	for t, batch := range batches {
+		tctx := tenant.Without(ctx)
+		if t.ID != "" {
+			tctx = tenant.WithTenant(ctx, t)
+		}
+		err := u.store.RecordUsage(tctx, batch)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+		u.mu.Lock()
+		for _, usage := range batch {
+			key := usageKey{t, usage.TokenID}
+			if _, ok := u.pending[key]; !ok {
+				u.pending[key] = usage
+			}
+		}
+		u.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/tenant"
+)
+
+type fakeUsageStore struct {
+	batches [][]domain.TokenUsage
+	err     error
+}
+
+func (s *fakeUsageStore) RecordUsage(ctx context.Context, usage []domain.TokenUsage) error {
+	s.batches = append(s.batches, usage)
+	return s.err
+}
+
+func TestTokenUsageCoalescesPerToken(t *testing.T) {
+	store := &fakeUsageStore{}
+	usage := NewTokenUsage(store, 0)
+	pat := &domain.PersonalAccessToken{ID: 7, UserID: 42}
+
+	first := httptest.NewRequest("GET", "/export", nil)
+	first.RemoteAddr = "10.0.0.1:5555"
+	usage.record(first, tenant.Tenant{}, pat)
+	second := httptest.NewRequest("GET", "/export", nil)
+	second.RemoteAddr = "10.0.0.2:5555"
+	second.Header.Set("User-Agent", "cron/1.0")
+	usage.record(second, tenant.Tenant{}, pat)
+
+	if err := usage.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 1 || len(store.batches[0]) != 1 {
+		t.Fatalf("batches = %+v, want one use", store.batches)
+	}
+	got := store.batches[0][0]
+	if got.TokenID != 7 || got.UserID != 42 || got.IP != "10.0.0.2" || got.UserAgent != "cron/1.0" || got.UsedAt.IsZero() {
+		t.Errorf("use = %+v", got)
+	}
+
+	// nothing new, nothing written
+	if err := usage.Flush(context.Background()); err != nil || len(store.batches) != 1 {
+		t.Fatalf("empty flush wrote: %v, %d batches", err, len(store.batches))
+	}
+}
+
+func TestTokenUsageKeepsUsesOfFailedFlush(t *testing.T) {
+	store := &fakeUsageStore{err: errors.New("db down")}
+	usage := NewTokenUsage(store, 0)
+	usage.record(httptest.NewRequest("GET", "/", nil), tenant.Tenant{}, &domain.PersonalAccessToken{ID: 1, UserID: 1})
+
+	if err := usage.Flush(context.Background()); err == nil {
+		t.Fatal("want the store error")
+	}
+	store.err = nil
+	if err := usage.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 2 || len(store.batches[1]) != 1 {
+		t.Fatalf("batches = %+v, want the use retried", store.batches)
+	}
+}
+
+func TestNilTokenUsageRecordsNothing(t *testing.T) {
+	var usage *TokenUsage
+	usage.record(httptest.NewRequest("GET", "/", nil), tenant.Tenant{}, &domain.PersonalAccessToken{ID: 1})
+	if err := usage.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
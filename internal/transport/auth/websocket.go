@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WSProtocol is the WebSocket subprotocol browsers authenticate with, as they
+// cannot set headers on the handshake: new WebSocket(url, ["bearer", token])
+// offers the token as the protocol following WSProtocol. The server must
+// select WSProtocol, never the token.
+const WSProtocol = "bearer"
+
+// WSAuth authenticates WebSocket handshakes like SanctumMiddleware and also
+// accepts the token offered as a subprotocol (see WSProtocol).
+func WSAuth(tokenRepo TokenFinder, usage *TokenUsage) func(http.Handler) http.Handler {
+	return tokenMiddleware(tokenRepo, usage, wsCandidates)
+}
+
+// wsCandidates adds the subprotocol token to bearerCandidates.
+func wsCandidates(r *http.Request) []bearerCandidate {
+	out := bearerCandidates(r)
+	protocols := websocketProtocols(r)
+	for i, p := range protocols {
+		if p == WSProtocol && i+1 < len(protocols) && protocols[i+1] != "" {
+			out = append(out, bearerCandidate{source: "subprotocol", token: protocols[i+1]})
+			break
+		}
+	}
+	return out
+}
+
+// websocketProtocols lists the subprotocols offered in Sec-WebSocket-Protocol.
+func websocketProtocols(r *http.Request) []string {
+	var out []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			out = append(out, strings.TrimSpace(p))
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"debtster-export/internal/domain"
+)
+
+type fakeTokens map[string]*domain.PersonalAccessToken
+
+func (f fakeTokens) FindTokenByPlainToken(ctx context.Context, plainToken string) (*domain.PersonalAccessToken, error) {
+	if pat, ok := f[plainToken]; ok {
+		return pat, nil
+	}
+	return nil, errors.New("token not found")
+}
+
+func TestWSAuth(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	tokens := fakeTokens{
+		"1|good":    {ID: 1, UserID: 10, Abilities: `["*"]`},
+		"2|expired": {ID: 2, UserID: 20, ExpiresAt: &expired},
+	}
+
+	var gotUser int64
+	handler := WSAuth(tokens, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = GetUserID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		target   string
+		header   http.Header
+		wantCode int
+		wantUser int64
+	}{
+		{name: "header", target: "/ws", header: http.Header{"Authorization": {"Bearer 1|good"}}, wantCode: http.StatusOK, wantUser: 10},
+		{name: "query", target: "/ws?token=1|good", wantCode: http.StatusOK, wantUser: 10},
+		{name: "subprotocol", target: "/ws", header: http.Header{"Sec-Websocket-Protocol": {"bearer, 1|good"}}, wantCode: http.StatusOK, wantUser: 10},
+		{name: "invalid header falls back to subprotocol", target: "/ws",
+			header:   http.Header{"Authorization": {"Bearer 9|bad"}, "Sec-Websocket-Protocol": {"bearer", "1|good"}},
+			wantCode: http.StatusOK, wantUser: 10},
+		{name: "subprotocol without bearer", target: "/ws", header: http.Header{"Sec-Websocket-Protocol": {"1|good"}}, wantCode: http.StatusUnauthorized},
+		{name: "bearer without token", target: "/ws", header: http.Header{"Sec-Websocket-Protocol": {"bearer"}}, wantCode: http.StatusUnauthorized},
+		{name: "expired", target: "/ws?token=2|expired", wantCode: http.StatusUnauthorized},
+		{name: "user_id is no credential", target: "/ws?user_id=10", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUser = 0
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("user = %d, want %d", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
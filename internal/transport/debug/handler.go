@@ -0,0 +1,140 @@
+package debug
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/service"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// JobLister exposes the in-flight exports of this process.
+type JobLister interface {
+	Jobs() []service.JobInfo
+	Workers() (size, busy int)
+	Lanes() []service.LaneInfo
+}
+
+// StorageReporter exposes the disk usage of stored export files.
+type StorageReporter interface {
+	Usage() ([]clients.StorageUsage, error)
+}
+
+// NewRouter returns pprof handlers, /debug/vars, /debug/exports, /debug/storage
+// when storage is not nil and, when reload is not nil, POST /debug/reload. When
+// token is not empty every request must carry it as "Authorization: Bearer <token>".
+func NewRouter(jobs JobLister, storage StorageReporter, reload func() error, token string) http.Handler {
+	r := chi.NewRouter()
+	if token != "" {
+		r.Use(requireToken(token))
+	}
+
+	r.HandleFunc("/debug/pprof/", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.Handle("/debug/pprof/{profile}", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pprof.Handler(chi.URLParam(req, "profile")).ServeHTTP(w, req)
+	}))
+
+	r.Handle("/debug/vars", expvar.Handler())
+
+	r.Get("/debug/exports", exportsHandler(jobs))
+	if storage != nil {
+		r.Get("/debug/storage", storageHandler(storage))
+	}
+	if reload != nil {
+		r.Post("/debug/reload", reloadHandler(reload))
+	}
+
+	return r
+}
+
+func requireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func exportsHandler(jobs JobLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		size, busy := jobs.Workers()
+
+		resp := map[string]any{
+			"time":       time.Now(),
+			"goroutines": runtime.NumGoroutine(),
+			"workers": map[string]int{
+				"size": size,
+				"busy": busy,
+			},
+			"lanes": jobs.Lanes(),
+			"jobs":  jobs.Jobs(),
+			"memory": map[string]uint64{
+				"alloc_bytes":       mem.Alloc,
+				"heap_inuse_bytes":  mem.HeapInuse,
+				"heap_objects":      mem.HeapObjects,
+				"sys_bytes":         mem.Sys,
+				"total_alloc_bytes": mem.TotalAlloc,
+				"num_gc":            uint64(mem.NumGC),
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func storageHandler(storage StorageReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		usage, err := storage.Usage()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		var files int
+		var bytes int64
+		for _, u := range usage {
+			files += u.Files
+			bytes += u.Bytes
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"files": files,
+			"bytes": bytes,
+			"usage": usage,
+		})
+	}
+}
+
+func reloadHandler(reload func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := reload(); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "reloaded"})
+	}
+}
@@ -0,0 +1,62 @@
+package debug
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"debtster-export/internal/service"
+)
+
+type fakeJobs struct{}
+
+func (fakeJobs) Jobs() []service.JobInfo   { return nil }
+func (fakeJobs) Workers() (size, busy int) { return 4, 1 }
+func (fakeJobs) Lanes() []service.LaneInfo { return nil }
+
+func TestReload(t *testing.T) {
+	var reloadErr error
+	calls := 0
+	router := NewRouter(fakeJobs{}, nil, func() error {
+		calls++
+		return reloadErr
+	}, "secret")
+
+	post := func(token string) (*httptest.ResponseRecorder, map[string]string) {
+		req := httptest.NewRequest(http.MethodPost, "/debug/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var body map[string]string
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body
+	}
+
+	if rec, _ := post(""); rec.Code != http.StatusUnauthorized || calls != 0 {
+		t.Fatalf("reload without the token: %d, %d calls", rec.Code, calls)
+	}
+
+	if rec, body := post("secret"); rec.Code != http.StatusOK || body["status"] != "reloaded" || calls != 1 {
+		t.Fatalf("reload: %d %v", rec.Code, body)
+	}
+
+	// ошибка конфигурации возвращается оператору, настройки остаются прежними
+	reloadErr = errors.New("FILES_RETENTION_HOURS: must be at least 1")
+	if rec, body := post("secret"); rec.Code != http.StatusUnprocessableEntity || body["error"] != reloadErr.Error() {
+		t.Fatalf("failed reload: %d %v", rec.Code, body)
+	}
+}
+
+func TestReloadNotConfigured(t *testing.T) {
+	router := NewRouter(fakeJobs{}, nil, nil, "")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/reload", nil))
+	if rec.Code == http.StatusOK {
+		t.Fatal("reload is served without a reload function")
+	}
+}
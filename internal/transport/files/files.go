@@ -0,0 +1,301 @@
+// Package files serves the generated export files under /files.
+package files
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/tenant"
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DefaultExtensions are served when no list is configured.
+var DefaultExtensions = []string{".xlsx", ".zip", ".csv", ".parquet"}
+
+// contentTypes are the media types of the extensions this service produces;
+// others fall back to the system table and then to application/octet-stream.
+var contentTypes = map[string]string{
+	".xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".zip":     "application/zip",
+	".csv":     "text/csv; charset=utf-8",
+	".parquet": "application/vnd.apache.parquet",
+}
+
+// Storage is the part of the local storage the handler reads; implemented by
+// *clients.StorageClient.
+type Storage interface {
+	Resolve(file string) (string, error)
+	SingleUseOwner(file string) (int64, bool)
+	OriginalName(file string) string
+	Encoding(file string) string
+	VerifyURL(file string, query url.Values, now time.Time) error
+	RecordDownload(file string, userID *int64, at time.Time) error
+	Delete(file string) error
+}
+
+// DownloadRecorder counts downloads in the export records; implemented by
+// *service.ExportService.
+type DownloadRecorder interface {
+	RecordDownload(ctx context.Context, file string, userID *int64, at time.Time) error
+}
+
+// UploadOwners tells which stored files are uploads and who uploaded them;
+// implemented by *repository.UploadRepository.
+type UploadOwners interface {
+	Owner(ctx context.Context, file string) (int64, bool, error)
+}
+
+// Handler serves stored files (tenant files live under /files/<tenant>/<file>)
+// and records full downloads in the file metadata and in the export record.
+// Authentication is optional; when a token is sent the downloader is recorded.
+// Single-use files need the owner's token and are deleted after the download;
+// so do registered uploads. Signed files need an unexpired link.
+type Handler struct {
+	storage    Storage
+	exports    DownloadRecorder
+	uploads    UploadOwners
+	tenants    *tenant.Registry
+	extensions map[string]bool
+}
+
+// NewHandler creates the handler; only files with one of extensions
+// (".xlsx", case-insensitive) are served, DefaultExtensions when empty.
+func NewHandler(storage Storage, exports DownloadRecorder, tenants *tenant.Registry, extensions []string) *Handler {
+	if len(extensions) == 0 {
+		extensions = DefaultExtensions
+	}
+	allowed := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		allowed[strings.ToLower(ext)] = true
+	}
+	return &Handler{storage: storage, exports: exports, tenants: tenants, extensions: allowed}
+}
+
+// SetUploadOwners limits downloads of registered uploads to their owner.
+func (h *Handler) SetUploadOwners(uploads UploadOwners) {
+	h.uploads = uploads
+}
+
+// validName reports whether file is a name Save could have returned:
+// "<file>" or "<tenant>/<file>" without dot segments, hidden names,
+// backslashes or control characters.
+func validName(file string) bool {
+	if file == "" || !utf8.ValidString(file) || strings.ContainsAny(file, `\`) {
+		return false
+	}
+	segments := strings.Split(file, "/")
+	if len(segments) > 2 {
+		return false
+	}
+	for _, seg := range segments {
+		if seg == "" || strings.HasPrefix(seg, ".") {
+			return false
+		}
+		if strings.IndexFunc(seg, unicode.IsControl) >= 0 {
+			return false
+		}
+	}
+	return !clients.IsMetaFile(file) && !clients.IsTempFile(file)
+}
+
+// contentType returns the media type files with extension ext are served with.
+func contentType(ext string) string {
+	if ct, ok := contentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// Download serves GET /files/*.
+func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
+	// the route parameter is taken from the raw path when it has escapes, so
+	// "%2e%2e%2f" has to be decoded before it is checked
+	file, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil || !validName(file) {
+		http.NotFound(w, r)
+		return
+	}
+	ext := strings.ToLower(path.Ext(file))
+	if !h.extensions[ext] {
+		http.NotFound(w, r)
+		return
+	}
+
+	local, err := h.storage.Resolve(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, clients.ErrOutsideStorage) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "failed to access file", http.StatusInternalServerError)
+		return
+	}
+
+	// expired links are told apart from unknown ones, so clients know to ask
+	// for a fresh link
+	if err := h.storage.VerifyURL(file, r.URL.Query(), time.Now()); err != nil {
+		if errors.Is(err, clients.ErrURLExpired) {
+			http.Error(w, "link expired, request a new one with POST /export/{export_id}/refresh-url", http.StatusGone)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	var downloader *int64
+	if userID, err := auth.GetUserID(r.Context()); err == nil {
+		downloader = &userID
+	}
+
+	// export records and uploads of tenant files live in the tenant's namespace
+	ctx := r.Context()
+	if id, _, ok := strings.Cut(file, "/"); ok {
+		if t, found := h.tenants.Get(id); found {
+			ctx = tenant.WithTenant(ctx, t)
+		}
+	}
+
+	// single-use files and uploads exist for their owner only
+	owner, singleUse := h.storage.SingleUseOwner(file)
+	if singleUse && (downloader == nil || *downloader != owner) {
+		http.NotFound(w, r)
+		return
+	}
+	if h.uploads != nil {
+		uploader, isUpload, err := h.uploads.Owner(ctx, file)
+		if err != nil {
+			log.Printf("[FILES] upload owner of %s: %v", file, err)
+			http.Error(w, "failed to access file", http.StatusInternalServerError)
+			return
+		}
+		if isUpload && (downloader == nil || *downloader != uploader) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// original filename is kept in the storage metadata; FormatMediaType
+	// switches to filename*= for non-ASCII names
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": h.storage.OriginalName(file),
+	}))
+	w.Header().Set("Content-Type", contentType(ext))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	sw := &statusWriter{ResponseWriter: w}
+	// compressed files go out as stored to clients accepting their encoding
+	// and are decoded on the fly for the others
+	if enc := h.storage.Encoding(file); enc != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), enc) {
+			w.Header().Set("Content-Encoding", enc)
+			http.ServeFile(sw, r, local)
+		} else if err := serveDecoded(sw, r, local, enc); err != nil {
+			log.Printf("[FILES] decode %s: %v", file, err)
+			if sw.status == 0 {
+				http.Error(w, "failed to read file", http.StatusInternalServerError)
+			}
+			return
+		}
+	} else {
+		http.ServeFile(sw, r, local)
+	}
+	// range requests and cache revalidations are not downloads
+	if sw.status != http.StatusOK || r.Method != http.MethodGet {
+		return
+	}
+
+	now := time.Now()
+	if err := h.storage.RecordDownload(file, downloader, now); err != nil {
+		log.Printf("[FILES] record download %s: %v", file, err)
+	}
+
+	if err := h.exports.RecordDownload(context.WithoutCancel(ctx), file, downloader, now); err != nil {
+		log.Printf("[FILES] record download %s: %v", file, err)
+	}
+
+	if singleUse {
+		if err := h.storage.Delete(file); err != nil {
+			log.Printf("[FILES] delete single-use file %s: %v", file, err)
+		}
+	}
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows enc.
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		token, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token != enc && token != "*" {
+			continue
+		}
+		if _, q, ok := strings.Cut(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(q), 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveDecoded sends the decoded content of a compressed file; ranges are not
+// supported, so the whole file is always sent.
+func serveDecoded(w http.ResponseWriter, r *http.Request, local, enc string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec, err := clients.NewDecoder(enc, f)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = io.Copy(w, dec)
+	return err
+}
+
+// statusWriter remembers the response status so only complete downloads are counted.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
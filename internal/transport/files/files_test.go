@@ -0,0 +1,245 @@
+package files
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type recordedDownloads []string
+
+func (d *recordedDownloads) RecordDownload(_ context.Context, file string, _ *int64, _ time.Time) error {
+	*d = append(*d, file)
+	return nil
+}
+
+func TestDownload(t *testing.T) {
+	root := t.TempDir()
+	storage, err := clients.NewLocalStorage(filepath.Join(root, "exports"), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	saved, err := storage.Save(context.Background(), "debts.xlsx", []byte("xlsx"))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// files outside of the storage and symlinks leading there
+	if err := os.WriteFile(filepath.Join(root, "secret.xlsx"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "secret.xlsx"), filepath.Join(storage.BaseDir, "link.xlsx")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storage.BaseDir, "notes.txt"), []byte("txt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var downloads recordedDownloads
+	r := chi.NewRouter()
+	r.Get("/files/*", NewHandler(storage, &downloads, nil, nil).Download)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/files/" + saved, http.StatusOK},
+		{"/files/link.xlsx", http.StatusNotFound},
+		{"/files/%2e%2e%2fsecret.xlsx", http.StatusNotFound},
+		{"/files/x/%2e%2e/%2e%2e/secret.xlsx", http.StatusNotFound},
+		{"/files/notes.txt", http.StatusNotFound},
+		{"/files/" + saved + ".meta.json", http.StatusNotFound},
+		{"/files/.tmp/x.xlsx", http.StatusNotFound},
+		{"/files/missing.xlsx", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("get %s: %v", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+		if tt.want == http.StatusOK {
+			if ct := resp.Header.Get("Content-Type"); ct != contentTypes[".xlsx"] {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("nosniff header missing")
+			}
+		}
+	}
+	if len(downloads) != 1 || downloads[0] != saved {
+		t.Fatalf("recorded downloads = %v", downloads)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"abc_debts.xlsx":         true,
+		"abc_report 1.xlsx":      true,
+		"acme/abc_Должники.xlsx": true,
+		"":                       false,
+		"../secret.xlsx":         false,
+		"a/b/c.xlsx":             false,
+		".hidden.xlsx":           false,
+		`a\..\b.xlsx`:            false,
+		"a\x00.xlsx":             false,
+		"acme/":                  false,
+	} {
+		if got := validName(name); got != want {
+			t.Errorf("validName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+type uploadOwners map[string]int64
+
+func (o uploadOwners) Owner(_ context.Context, file string) (int64, bool, error) {
+	id, ok := o[file]
+	return id, ok, nil
+}
+
+func TestDownload_UploadsOwnerOnly(t *testing.T) {
+	storage, err := clients.NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	upload, _ := storage.Save(context.Background(), "upload.xlsx", []byte("up"))
+	export, _ := storage.Save(context.Background(), "export.xlsx", []byte("ex"))
+
+	var downloads recordedDownloads
+	h := NewHandler(storage, &downloads, nil, nil)
+	h.SetUploadOwners(uploadOwners{upload: 7})
+	r := chi.NewRouter()
+	r.Get("/files/*", h.Download)
+
+	tests := []struct {
+		file string
+		user int64
+		want int
+	}{
+		{upload, 7, http.StatusOK},
+		{upload, 8, http.StatusNotFound},
+		{upload, 0, http.StatusNotFound},
+		{export, 0, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/files/"+tt.file, nil)
+		if tt.user != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, tt.user))
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s as %d = %d, want %d", tt.file, tt.user, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestDownload_Compressed(t *testing.T) {
+	storage, err := clients.NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	if err := storage.EnableCompression(clients.EncodingGzip); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := storage.Save(context.Background(), "debts.csv", []byte("id;sum\n1;100\n"))
+
+	var downloads recordedDownloads
+	r := chi.NewRouter()
+	r.Get("/files/*", NewHandler(storage, &downloads, nil, nil).Download)
+
+	tests := []struct {
+		accept   string
+		encoding string
+	}{
+		{"gzip, deflate", "gzip"},
+		{"br;q=1, *;q=0.5", "gzip"},
+		{"gzip;q=0", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/files/"+saved, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != tt.encoding {
+			t.Fatalf("Accept-Encoding %q: %d, Content-Encoding %q", tt.accept, rec.Code, rec.Header().Get("Content-Encoding"))
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != contentTypes[".csv"] {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if tt.encoding == "" && rec.Body.String() != "id;sum\n1;100\n" {
+			t.Errorf("Accept-Encoding %q: body %q", tt.accept, rec.Body)
+		}
+	}
+	if len(downloads) != len(tests) {
+		t.Fatalf("recorded %d downloads", len(downloads))
+	}
+}
+
+// laterStorage checks links as if shift has passed.
+type laterStorage struct {
+	*clients.StorageClient
+	shift time.Duration
+}
+
+func (s laterStorage) VerifyURL(file string, query url.Values, now time.Time) error {
+	return s.StorageClient.VerifyURL(file, query, now.Add(s.shift))
+}
+
+func TestDownload_SignedURL(t *testing.T) {
+	storage, err := clients.NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	if err := storage.EnableSignedURLs([]byte("secret"), 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := storage.Save(context.Background(), "debts.xlsx", []byte("xlsx"))
+	link, _, err := storage.SignURL(saved, time.Hour)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	var downloads recordedDownloads
+	tests := []struct {
+		path  string
+		shift time.Duration
+		want  int
+	}{
+		{link, 0, http.StatusOK},
+		{link, 2 * time.Hour, http.StatusGone},
+		{"/files/" + saved, 0, http.StatusNotFound},
+		{strings.Replace(link, "signature=", "signature=00", 1), 0, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := chi.NewRouter()
+		r.Get("/files/*", NewHandler(laterStorage{storage, tt.shift}, &downloads, nil, nil).Download)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s after %s = %d, want %d", tt.path, tt.shift, rec.Code, tt.want)
+		}
+	}
+	if len(downloads) != 1 {
+		t.Fatalf("recorded downloads = %v", downloads)
+	}
+}
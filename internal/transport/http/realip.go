@@ -0,0 +1,121 @@
+package httpmw
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardedHeaders are the headers a reverse proxy sets about the client; they
+// are honored from trusted proxies only and dropped from everyone else.
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Real-Ip", "True-Client-Ip", "X-Forwarded-Proto", "X-Forwarded-Host"}
+
+// TrustedProxies is a list of networks whose forwarded headers are believed.
+type TrustedProxies struct {
+	nets []netip.Prefix
+}
+
+// ParseTrustedProxies parses addresses and CIDR ranges, e.g.
+// ["10.0.0.0/8", "127.0.0.1"]. An empty list trusts no one.
+func ParseTrustedProxies(list []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			p.nets = append(p.nets, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		p.nets = append(p.nets, prefix.Masked())
+	}
+	return p, nil
+}
+
+// Trusted reports whether addr (an IP, with or without a port) belongs to a
+// trusted proxy.
+func (p *TrustedProxies) Trusted(addr string) bool {
+	ip, ok := parseIP(addr)
+	if !ok {
+		return false
+	}
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP replaces RemoteAddr with the client address for requests coming
+// from a trusted proxy: the last X-Forwarded-For entry that is not a trusted
+// proxy itself, or X-Real-IP without one. Requests from other addresses keep
+// their RemoteAddr and lose the forwarded headers, so nothing downstream
+// (rate limits, token usage, absolute URLs) can be fooled by them.
+func (p *TrustedProxies) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Trusted(r.RemoteAddr) {
+			for _, h := range forwardedHeaders {
+				r.Header.Del(h)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ip := p.clientIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP walks X-Forwarded-For from the nearest hop back, skipping trusted
+// proxies; entries left of the first untrusted one could be forged by the
+// client. Returns "" when the headers name no valid address.
+func (p *TrustedProxies) clientIP(r *http.Request) string {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseIP(strings.TrimSpace(hops[i]))
+		if !ok {
+			break
+		}
+		client = ip.String()
+		if !p.Trusted(client) {
+			return client
+		}
+	}
+	if client != "" {
+		// every hop is a trusted proxy: the leftmost one is as close to the client as we get
+		return client
+	}
+	if ip, ok := parseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); ok {
+		return ip.String()
+	}
+	return ""
+}
+
+// parseIP accepts "1.2.3.4", "1.2.3.4:80", "::1" and "[::1]:80".
+func parseIP(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
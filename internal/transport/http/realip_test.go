@@ -0,0 +1,68 @@
+package httpmw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies_RealIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 127.0.0.1 ", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	h := proxies.RealIP(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { got = r }))
+	serve := func(remote string, headers map[string]string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		return got
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5:4000"},
+		// клиент не может подделать адрес, обращаясь напрямую
+		{"spoofed by a client", "203.0.113.5:4000", map[string]string{"X-Forwarded-For": "1.1.1.1", "X-Real-IP": "1.1.1.1"}, "203.0.113.5:4000"},
+		{"trusted proxy", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		// левее первого недоверенного адреса всё мог написать клиент
+		{"forged hops", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"proxy chain only", "127.0.0.1:80", map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2"}, "10.0.0.1"},
+		{"x-real-ip", "[fd00::1]:80", map[string]string{"X-Real-IP": "2001:db8::1"}, "2001:db8::1"},
+		{"garbage", "10.1.2.3:80", map[string]string{"X-Forwarded-For": "not-an-ip"}, "10.1.2.3:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if r := serve(tt.remote, tt.headers); r.RemoteAddr != tt.want {
+				t.Fatalf("RemoteAddr = %q, want %q", r.RemoteAddr, tt.want)
+			}
+		})
+	}
+
+	// заголовки недоверенного клиента не доходят до обработчиков
+	r := serve("203.0.113.5:4000", map[string]string{"X-Forwarded-Host": "evil.example", "X-Forwarded-Proto": "https"})
+	if r.Header.Get("X-Forwarded-Host") != "" || r.Header.Get("X-Forwarded-Proto") != "" {
+		t.Fatalf("forwarded headers kept: %v", r.Header)
+	}
+	r = serve("10.1.2.3:80", map[string]string{"X-Forwarded-Host": "public.example"})
+	if r.Header.Get("X-Forwarded-Host") != "public.example" {
+		t.Fatal("forwarded host of a trusted proxy dropped")
+	}
+}
+
+func TestParseTrustedProxies_Invalid(t *testing.T) {
+	for _, list := range [][]string{{"10.0.0.0/33"}, {"proxy.local"}} {
+		if _, err := ParseTrustedProxies(list); err == nil {
+			t.Fatalf("%v accepted", list)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestLogger logs every request like middleware.Logger, except that the
+// ?token= value (a bearer token, see auth.SanctumMiddleware) is logged only as
+// its auth.Fingerprint.
+func requestLogger(logger middleware.LoggerInterface) func(http.Handler) http.Handler {
+	return middleware.RequestLogger(redactingLogFormatter{&middleware.DefaultLogFormatter{Logger: logger}})
+}
+
+// accessLog is the request log of the REST router.
+var accessLog = requestLogger(log.New(os.Stdout, "", log.LstdFlags))
+
+// redactingLogFormatter hides the token query parameter from the wrapped formatter.
+type redactingLogFormatter struct {
+	middleware.LogFormatter
+}
+
+func (f redactingLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	if uri, ok := redactToken(r.RequestURI); ok {
+		redacted := *r
+		redacted.RequestURI = uri
+		r = &redacted
+	}
+	return f.LogFormatter.NewLogEntry(r)
+}
+
+// redactToken replaces the values of the token parameters in the query of uri
+// with their fingerprints; ok is false when uri carries no token.
+func redactToken(uri string) (string, bool) {
+	path, query, found := strings.Cut(uri, "?")
+	if !found {
+		return uri, false
+	}
+	params := strings.Split(query, "&")
+	redacted := false
+	for i, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err != nil || name != "token" {
+			continue
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		params[i] = key + "=" + auth.Fingerprint(value)
+		redacted = true
+	}
+	if !redacted {
+		return uri, false
+	}
+	return path + "?" + strings.Join(params, "&"), true
+}
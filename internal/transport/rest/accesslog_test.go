@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"debtster-export/internal/transport/auth"
+)
+
+func TestRequestLogger_RedactsToken(t *testing.T) {
+	var buf bytes.Buffer
+	h := requestLogger(log.New(&buf, "", 0))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// обработчик получает токен как есть
+		if r.URL.Query().Get("token") != "12|s3cr3t" {
+			t.Errorf("token = %q", r.URL.Query().Get("token"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/files/report.xlsx?download=1&token=12%7Cs3cr3t", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	if strings.Contains(line, "s3cr3t") {
+		t.Fatalf("token secret is logged: %s", line)
+	}
+	if !strings.Contains(line, "/files/report.xlsx?download=1&token="+auth.Fingerprint("12|s3cr3t")) {
+		t.Fatalf("log line = %s", line)
+	}
+}
+
+func TestRedactToken(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"/export/1", "/export/1"},
+		{"/export?page=2", "/export?page=2"},
+		{"/files/a.xlsx?token=abc", "/files/a.xlsx?token=" + auth.Fingerprint("abc")},
+		// повторный параметр тоже скрывается
+		{"/files/a.xlsx?token=a&token=b", "/files/a.xlsx?token=" + auth.Fingerprint("a") + "&token=" + auth.Fingerprint("b")},
+		{"/files/a.xlsx?token=", "/files/a.xlsx?token=-"},
+	}
+	for _, tt := range tests {
+		if got, _ := redactToken(tt.uri); got != tt.want {
+			t.Errorf("redactToken(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
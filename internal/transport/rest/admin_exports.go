@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"debtster-export/internal/service"
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ExportAdmin manages the exports of every user. Implemented by *service.ExportService.
+type ExportAdmin interface {
+	DeleteExport(ctx context.Context, exportID string) (*service.ExportStatus, error)
+}
+
+// SetExportAdmin enables /admin/exports.
+func (h *Handler) SetExportAdmin(a ExportAdmin) {
+	h.exportAdmin = a
+}
+
+// deleteExportAdmin serves DELETE /admin/exports/{export_id}: the export of
+// any user is stopped and its file and records are removed at once.
+func (h *Handler) deleteExportAdmin(w http.ResponseWriter, r *http.Request) {
+	if h.exportAdmin == nil {
+		ErrorNotFound(w, "export admin is not configured")
+		return
+	}
+
+	exportIDParam := chi.URLParam(r, "export_id")
+	if exportIDParam == "" {
+		ErrorBadRequest(w, "export_id is required")
+		return
+	}
+	exportID := "exports:" + exportIDParam
+
+	status, err := h.exportAdmin.DeleteExport(r.Context(), exportID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrExportNotFound):
+			ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+		case errors.Is(err, service.ErrExportNotDeletable):
+			ErrorWithCode(w, err.Error(), serviceCode(err), http.StatusConflict)
+		default:
+			log.Printf("[HTTP] deleteExportAdmin error: %v", err)
+			ErrorInternal(w, "failed to delete export")
+		}
+		return
+	}
+
+	adminID, _ := auth.GetUserID(r.Context())
+	log.Printf("[ADMIN] export %s of user %d deleted by user %d", status.Key, status.UserID, adminID)
+
+	data := map[string]any{
+		"export_id": status.Key,
+		"user_id":   status.UserID,
+	}
+	if status.File != nil {
+		data["file"] = *status.File
+	}
+	Success(w, "Экспорт удалён", data)
+}
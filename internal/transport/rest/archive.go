@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/tenant"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ArchiveRestorer brings expired files back from cold storage; implemented by
+// *clients.StorageClient.
+type ArchiveRestorer interface {
+	Restore(ctx context.Context, file string) error
+	GetURL(fileName string) string
+}
+
+// SetArchiveRestorer enables /admin/archive.
+func (h *Handler) SetArchiveRestorer(archive ArchiveRestorer) {
+	h.archive = archive
+}
+
+// restoreArchived serves POST /admin/archive/restore/{file}: 200 with the url
+// once the file is back in storage, 202 while cold storage is restoring it.
+func (h *Handler) restoreArchived(w http.ResponseWriter, r *http.Request) {
+	if h.archive == nil {
+		ErrorNotFound(w, "archive is not configured")
+		return
+	}
+	file, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil || file == "" {
+		ErrorNotFound(w, "file not found")
+		return
+	}
+	// admins of a tenant restore the tenant's files only
+	if t, ok := tenant.FromContext(r.Context()); ok && !strings.HasPrefix(file, t.ID+"/") {
+		ErrorNotFound(w, "file not found")
+		return
+	}
+
+	err = h.archive.Restore(r.Context(), file)
+	switch {
+	case errors.Is(err, clients.ErrNotArchived):
+		ErrorNotFound(w, "file not found")
+	case errors.Is(err, clients.ErrRestorePending):
+		SuccessAccepted(w, "Файл восстанавливается из архива, повторите запрос позже", map[string]string{"file": file})
+	case err != nil:
+		log.Printf("[HTTP] restoreArchived error: %v", err)
+		ErrorInternal(w, "failed to restore file")
+	default:
+		Success(w, "Файл восстановлен", map[string]string{
+			"file": file,
+			"url":  h.fileURL(r, h.archive.GetURL(file)),
+		})
+	}
+}
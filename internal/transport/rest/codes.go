@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	"debtster-export/internal/service"
+)
+
+// Error codes are the machine-readable reason of an error response, sent as
+// "code" next to the message, so clients can localize messages instead of
+// parsing them. They are part of the API: add new ones, never rename them.
+const (
+	// generic codes of an HTTP status, for errors without a more specific one
+	CodeInvalidRequest     = "INVALID_REQUEST"     // 400
+	CodeUnauthorized       = "UNAUTHORIZED"        // 401
+	CodeForbidden          = "FORBIDDEN"           // 403
+	CodeNotFound           = "NOT_FOUND"           // 404
+	CodeConflict           = "CONFLICT"            // 409
+	CodeGone               = "GONE"                // 410
+	CodeRateLimited        = "RATE_LIMITED"        // 429
+	CodeInternal           = "INTERNAL_ERROR"      // 500
+	CodeServiceUnavailable = "SERVICE_UNAVAILABLE" // 503
+
+	// request body
+	CodeInvalidJSON  = "INVALID_JSON"  // 400, not JSON of the expected shape
+	CodeInvalidField = "INVALID_FIELD" // 422, see the "errors" map
+
+	// starting an export
+	CodeExportTooLarge        = "EXPORT_TOO_LARGE"        // the filter matches more rows than the export type allows
+	CodeInvalidDelivery       = "INVALID_DELIVERY"        // unknown or unusable delivery profile
+	CodeInvalidTemplate       = "INVALID_TEMPLATE"        // unknown or broken template
+	CodeInvalidComputedColumn = "INVALID_COMPUTED_COLUMN" // computed column referring to an unknown field
+	CodeInvalidOutput         = "INVALID_OUTPUT"          // unknown output profile or format options
+	CodeInvalidFormatProfile  = "INVALID_FORMAT_PROFILE"  // unknown payments format profile
+	CodeQuotaExceeded         = "QUOTA_EXCEEDED"          // 507, storage quota of the user or tenant used up
+	CodeStorageUnavailable    = "STORAGE_UNAVAILABLE"     // 507, the export disk is full
+	CodeMaintenance           = "MAINTENANCE"             // 503, exports are paused by an admin
+
+	// existing exports
+	CodeExportNotFound       = "EXPORT_NOT_FOUND"
+	CodeExportNotReady       = "EXPORT_NOT_READY"
+	CodeExportNotCancellable = "EXPORT_NOT_CANCELLABLE"
+	CodeExportNotRetryable   = "EXPORT_NOT_RETRYABLE"
+	CodeExportNotDeletable   = "EXPORT_NOT_DELETABLE"
+	CodeExportFileGone       = "EXPORT_FILE_GONE"
+)
+
+// statusCodes are the generic codes of the HTTP statuses.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeInvalidRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusGone:                CodeGone,
+	http.StatusUnprocessableEntity: CodeInvalidField,
+	http.StatusTooManyRequests:     CodeRateLimited,
+	http.StatusServiceUnavailable:  CodeServiceUnavailable,
+	http.StatusInsufficientStorage: CodeStorageUnavailable,
+}
+
+// statusCode returns the generic code of an HTTP status.
+func statusCode(httpStatus int) string {
+	if code, ok := statusCodes[httpStatus]; ok {
+		return code
+	}
+	return CodeInternal
+}
+
+// serviceCodes are the codes of the service errors a client can act on.
+var serviceCodes = []struct {
+	err  error
+	code string
+}{
+	{service.ErrTooManyRows, CodeExportTooLarge},
+	{service.ErrInvalidDelivery, CodeInvalidDelivery},
+	{service.ErrInvalidTemplate, CodeInvalidTemplate},
+	{service.ErrInvalidComputedColumn, CodeInvalidComputedColumn},
+	{service.ErrInvalidOutput, CodeInvalidOutput},
+	{service.ErrInvalidFormatProfile, CodeInvalidFormatProfile},
+	{service.ErrExportNotFound, CodeExportNotFound},
+	{service.ErrExportNotReady, CodeExportNotReady},
+	{service.ErrExportNotCancellable, CodeExportNotCancellable},
+	{service.ErrExportNotRetryable, CodeExportNotRetryable},
+	{service.ErrExportNotDeletable, CodeExportNotDeletable},
+	{service.ErrExportFileGone, CodeExportFileGone},
+}
+
+// serviceCode returns the code of a service error, "" for errors that are
+// not the client's business.
+func serviceCode(err error) string {
+	for _, c := range serviceCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// errorStartExport answers the errors of starting an export that the client
+// can fix with 400 and their code, and reports whether err was one of them.
+func errorStartExport(w http.ResponseWriter, err error) bool {
+	code := exportStartCode(err)
+	if code == "" {
+		return false
+	}
+	ErrorWithCode(w, err.Error(), code, http.StatusBadRequest)
+	return true
+}
+
+// exportStartCode returns the code of an error of starting an export the
+// client can fix, "" for the others.
+func exportStartCode(err error) string {
+	switch code := serviceCode(err); code {
+	case CodeExportTooLarge, CodeInvalidDelivery, CodeInvalidTemplate, CodeInvalidComputedColumn, CodeInvalidOutput, CodeInvalidFormatProfile:
+		return code
+	}
+	return ""
+}
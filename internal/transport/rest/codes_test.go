@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"debtster-export/internal/service"
+)
+
+func TestErrorCodes(t *testing.T) {
+	decode := func(rec *httptest.ResponseRecorder) APIResponse {
+		var resp APIResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// ошибка сервиса, обёрнутая с подробностями, получает свой код
+	rec := httptest.NewRecorder()
+	err := fmt.Errorf("%w: не больше 100000 долгов", service.ErrTooManyRows)
+	if !errorStartExport(rec, err) {
+		t.Fatal("too many rows not answered")
+	}
+	if resp := decode(rec); rec.Code != http.StatusBadRequest || resp.Code != CodeExportTooLarge || resp.ErrorCode != 400 {
+		t.Fatalf("%d %+v", rec.Code, resp)
+	}
+
+	// внутренние ошибки отвечает вызывающий
+	if errorStartExport(httptest.NewRecorder(), service.ErrExportNotFound) {
+		t.Fatal("not found answered as a start error")
+	}
+
+	// без своего кода — общий код статуса
+	rec = httptest.NewRecorder()
+	ErrorTooManyRequests(rec, "slow down")
+	if resp := decode(rec); resp.Code != CodeRateLimited || resp.Message != "slow down" {
+		t.Fatalf("%+v", resp)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"debtster-export/internal/service"
 	"debtster-export/internal/transport/auth"
 )
 
@@ -14,11 +15,7 @@ func (h *Handler) exportActions(w http.ResponseWriter, r *http.Request) {
 	}
 	req, err := ValidateActionsExportRequest(r)
 	if err != nil {
-		if _, ok := err.(*ValidationError); ok {
-			ErrorBadRequest(w, err.Error())
-			return
-		}
-		ErrorBadRequest(w, "invalid JSON")
+		errorRequest(w, err)
 		return
 	}
 
@@ -30,7 +27,10 @@ func (h *Handler) exportActions(w http.ResponseWriter, r *http.Request) {
 
 	filter := req.ToRepositoryFilter()
 
-	exportID, err := h.actions.StartActionsExport(r.Context(), req.Fields, filter, userID)
+	exportID, err := h.actions.StartActionsExport(r.Context(), req.Fields, filter, service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template}, userID)
+	if errorStartExport(w, err) {
+		return
+	}
 	if err != nil {
 		log.Printf("[HTTP] startActionsExport error: %v", err)
 		ErrorInternal(w, "failed to start actions export")
@@ -41,3 +41,37 @@ func (h *Handler) exportActions(w http.ResponseWriter, r *http.Request) {
 		"export_id": exportID,
 	})
 }
+
+func (h *Handler) exportActionsDaily(w http.ResponseWriter, r *http.Request) {
+	if h.actions == nil {
+		ErrorInternal(w, "actions export not configured")
+		return
+	}
+	req, err := ValidateActionsDailyReportRequest(r)
+	if err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	filter := req.ToRepositoryFilter()
+
+	exportID, err := h.actions.StartActionsDailyReport(r.Context(), filter, req.GroupBy, service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, Delivery: req.Delivery, Comment: req.Comment}, userID)
+	if errorStartExport(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[HTTP] startActionsDailyReport error: %v", err)
+		ErrorInternal(w, "failed to start actions daily report")
+		return
+	}
+
+	SuccessAccepted(w, "Отчёт по действиям поставлен в очередь", map[string]interface{}{
+		"export_id": exportID,
+	})
+}
@@ -0,0 +1,261 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"debtster-export/internal/service"
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBatchExports bounds one batch; every export of it is a separate job.
+const maxBatchExports = 10
+
+// ExportBatches groups exports started together. Implemented by *service.BatchNotifier.
+type ExportBatches interface {
+	Begin(ctx context.Context, userID int64, size int) (*service.ExportBatch, error)
+	GetBatch(ctx context.Context, batchID string, userID int64) (*service.ExportBatchStatus, error)
+}
+
+func (h *Handler) SetExportBatches(b ExportBatches) {
+	h.batches = b
+}
+
+type ExportBatchRequest struct {
+	// Exports are the bodies of the single export endpoints plus their "type".
+	Exports []json.RawMessage `json:"exports"`
+}
+
+// batchExport is a validated export of a batch, ready to be started.
+type batchExport struct {
+	exportType string
+	start      func(ctx context.Context, userID int64) (string, error)
+}
+
+type batchStartError struct {
+	Index int    `json:"index"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+func (h *Handler) exportBatch(w http.ResponseWriter, r *http.Request) {
+	if h.batches == nil {
+		ErrorNotFound(w, "batch exports are not configured")
+		return
+	}
+
+	var req ExportBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		ErrorWithCode(w, "invalid JSON", CodeInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	var v ValidationErrors
+	if len(req.Exports) == 0 {
+		v.addf("exports", "exports is required and must be a non-empty array")
+	}
+	if len(req.Exports) > maxBatchExports {
+		v.addf("exports", "exports must contain at most %d items", maxBatchExports)
+	}
+	if err := v.Err(); err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	// the whole batch is rejected when any export of it is invalid; the errors
+	// of all of them are reported under "exports.<index>.<field>"
+	exports := make([]batchExport, 0, len(req.Exports))
+	for i, raw := range req.Exports {
+		export, err := h.parseBatchExport(raw)
+		if err != nil {
+			list := asValidationErrors(err)
+			if list == nil {
+				ErrorBadRequest(w, fmt.Sprintf("exports[%d]: invalid JSON", i))
+				return
+			}
+			v.add(list.prefixed(fmt.Sprintf("exports.%d.", i)))
+			continue
+		}
+		exports = append(exports, export)
+	}
+	if err := v.Err(); err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	batch, err := h.batches.Begin(r.Context(), userID, len(exports))
+	if err != nil {
+		log.Printf("[HTTP] exportBatch error: %v", err)
+		ErrorInternal(w, "failed to start batch export")
+		return
+	}
+
+	// an export that cannot be started is recorded as failed in the batch, the
+	// others still run
+	ctx := service.WithExportBatch(r.Context(), batch)
+	exportIDs := make([]string, len(exports))
+	var startErrors []batchStartError
+	for i, export := range exports {
+		exportID, err := export.start(ctx, userID)
+		if err != nil {
+			msg, code := "failed to start export", exportStartCode(err)
+			if code != "" {
+				msg = err.Error()
+			} else {
+				code = CodeInternal
+				log.Printf("[HTTP] exportBatch: start %s export: %v", export.exportType, err)
+			}
+			batch.AddFailed(ctx, export.exportType, msg)
+			startErrors = append(startErrors, batchStartError{Index: i, Code: code, Error: msg})
+			continue
+		}
+		batch.Add(ctx, exportID, export.exportType)
+		exportIDs[i] = exportID
+	}
+
+	data := map[string]interface{}{
+		"batch_id":   batch.Key(),
+		"export_ids": exportIDs,
+	}
+	if len(startErrors) > 0 {
+		data["errors"] = startErrors
+	}
+	SuccessAccepted(w, "Пакет экспортов поставлен в очередь", data)
+}
+
+// parseBatchExport validates one export of a batch like its single endpoint does.
+func (h *Handler) parseBatchExport(raw json.RawMessage) (batchExport, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return batchExport{}, err
+	}
+
+	switch head.Type {
+	case "debts":
+		req, err := parseExportRequest(bytes.NewReader(raw))
+		if err != nil {
+			return batchExport{}, err
+		}
+		filter := req.ToRepositoryFilter()
+		opts := service.DebtsExportOptions{
+			ExportOptions:     service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template},
+			IncludeGuarantors: req.IncludeGuarantors,
+			SplitBy:           req.SplitBy,
+		}
+		return batchExport{exportType: head.Type, start: func(ctx context.Context, userID int64) (string, error) {
+			return h.debts.StartDebtsExport(ctx, req.Fields, filter, opts, userID)
+		}}, nil
+
+	case "debts_aging":
+		req, err := parseAgingReportRequest(bytes.NewReader(raw))
+		if err != nil {
+			return batchExport{}, err
+		}
+		filter := req.ToRepositoryFilter()
+		opts := service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, Delivery: req.Delivery, Comment: req.Comment}
+		return batchExport{exportType: head.Type, start: func(ctx context.Context, userID int64) (string, error) {
+			return h.debts.StartAgingReport(ctx, filter, req.GroupBy, opts, userID)
+		}}, nil
+
+	case "users":
+		if h.users == nil {
+			return batchExport{}, &ValidationError{Field: "type", Message: "users export not configured"}
+		}
+		req, err := parseUsersExportRequest(bytes.NewReader(raw))
+		if err != nil {
+			return batchExport{}, err
+		}
+		opts := service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template}
+		return batchExport{exportType: head.Type, start: func(ctx context.Context, userID int64) (string, error) {
+			return h.users.StartUsersExport(ctx, req.Fields, opts, userID)
+		}}, nil
+
+	case "actions":
+		if h.actions == nil {
+			return batchExport{}, &ValidationError{Field: "type", Message: "actions export not configured"}
+		}
+		req, err := parseActionsExportRequest(bytes.NewReader(raw))
+		if err != nil {
+			return batchExport{}, err
+		}
+		filter := req.ToRepositoryFilter()
+		opts := service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template}
+		return batchExport{exportType: head.Type, start: func(ctx context.Context, userID int64) (string, error) {
+			return h.actions.StartActionsExport(ctx, req.Fields, filter, opts, userID)
+		}}, nil
+
+	case "actions_daily":
+		if h.actions == nil {
+			return batchExport{}, &ValidationError{Field: "type", Message: "actions export not configured"}
+		}
+		req, err := parseActionsDailyReportRequest(bytes.NewReader(raw))
+		if err != nil {
+			return batchExport{}, err
+		}
+		filter := req.ToRepositoryFilter()
+		opts := service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, Delivery: req.Delivery, Comment: req.Comment}
+		return batchExport{exportType: head.Type, start: func(ctx context.Context, userID int64) (string, error) {
+			return h.actions.StartActionsDailyReport(ctx, filter, req.GroupBy, opts, userID)
+		}}, nil
+
+	case "payments":
+		req, err := parsePaymentsExportRequest(bytes.NewReader(raw))
+		if err != nil {
+			return batchExport{}, err
+		}
+		filter := req.ToRepositoryFilter()
+		opts := service.PaymentsExportOptions{
+			ExportOptions: service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template},
+			GroupBy:       req.GroupBy,
+			FormatProfile: req.FormatProfile,
+		}
+		return batchExport{exportType: head.Type, start: func(ctx context.Context, userID int64) (string, error) {
+			return h.payments.StartPaymentsExport(ctx, req.Fields, filter, opts, userID)
+		}}, nil
+
+	default:
+		return batchExport{}, &ValidationError{Field: "type", Message: "type must be one of debts, debts_aging, users, actions, actions_daily, payments"}
+	}
+}
+
+func (h *Handler) getExportBatch(w http.ResponseWriter, r *http.Request) {
+	if h.batches == nil {
+		ErrorNotFound(w, "batch not found")
+		return
+	}
+
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	batchIDParam := chi.URLParam(r, "batch_id")
+	if batchIDParam == "" {
+		ErrorBadRequest(w, "batch_id is required")
+		return
+	}
+
+	batch, err := h.batches.GetBatch(r.Context(), "export_batches:"+batchIDParam, userID)
+	if err != nil {
+		log.Printf("[HTTP] getExportBatch error: %v", err)
+		ErrorNotFound(w, "batch not found")
+		return
+	}
+
+	Success(w, "", h.batchFileURLs(r, batch))
+}
@@ -0,0 +1,26 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportBatchNotConfigured(t *testing.T) {
+	// инстанс APP_MODE=api не принимает пакеты: их экспорты он не запускает
+	router := NewHandler(nil, nil, nil, nil, nil).InitRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/export/batch", strings.NewReader(`{"exports": [{"type": "debts"}]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "batch exports are not configured") {
+		t.Fatalf("POST /export/batch: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export/batch/1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /export/batch/1: %d", rec.Code)
+	}
+}
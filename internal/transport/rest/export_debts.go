@@ -1,22 +1,17 @@
 package rest
 
 import (
-	"debtster-export/internal/repository"
 	"log"
 	"net/http"
-	"strconv"
 
+	"debtster-export/internal/service"
 	"debtster-export/internal/transport/auth"
 )
 
 func (h *Handler) exportDebts(w http.ResponseWriter, r *http.Request) {
 	req, err := ValidateExportRequest(r)
 	if err != nil {
-		if _, ok := err.(*ValidationError); ok {
-			ErrorBadRequest(w, err.Error())
-			return
-		}
-		ErrorBadRequest(w, "invalid JSON")
+		errorRequest(w, err)
 		return
 	}
 
@@ -26,7 +21,7 @@ func (h *Handler) exportDebts(w http.ResponseWriter, r *http.Request) {
 	//	return
 	//}
 
-	filter := req.ToDebtsFilter().ToRepositoryFilter()
+	filter := req.ToRepositoryFilter()
 
 	userID, err := auth.GetUserID(r.Context())
 	if err != nil {
@@ -34,7 +29,16 @@ func (h *Handler) exportDebts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exportID, err := h.debts.StartDebtsExport(r.Context(), req.Fields, filter, userID)
+	opts := service.DebtsExportOptions{
+		ExportOptions:     service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template},
+		IncludeGuarantors: req.IncludeGuarantors,
+		SplitBy:           req.SplitBy,
+	}
+
+	exportID, err := h.debts.StartDebtsExport(r.Context(), req.Fields, filter, opts, userID)
+	if errorStartExport(w, err) {
+		return
+	}
 	if err != nil {
 		log.Printf("[HTTP] startDebtsExport error: %v", err)
 		ErrorInternal(w, "failed to start export")
@@ -46,26 +50,32 @@ func (h *Handler) exportDebts(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (f DebtsFilter) ToRepositoryFilter() repository.DebtsFilter {
-	rf := repository.DebtsFilter{}
-
-	if f.RegistryID != "" {
-		rf.RegistryID = &f.RegistryID
-	}
-	if f.CounterpartyID != "" {
-		rf.CounterpartyID = &f.CounterpartyID
+func (h *Handler) exportAgingReport(w http.ResponseWriter, r *http.Request) {
+	req, err := ValidateAgingReportRequest(r)
+	if err != nil {
+		errorRequest(w, err)
+		return
 	}
-	if f.DepartmentID != "" {
-		if id, err := strconv.ParseInt(f.DepartmentID, 10, 64); err == nil {
-			rf.DepartmentID = &id
-		}
+
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
 	}
-	if f.UserID != nil {
-		rf.UserID = f.UserID
+
+	filter := req.ToRepositoryFilter()
+
+	exportID, err := h.debts.StartAgingReport(r.Context(), filter, req.GroupBy, service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, Delivery: req.Delivery, Comment: req.Comment}, userID)
+	if errorStartExport(w, err) {
+		return
 	}
-	if f.StatusID != nil && *f.StatusID != 0 {
-		rf.StatusID = f.StatusID
+	if err != nil {
+		log.Printf("[HTTP] startAgingReport error: %v", err)
+		ErrorInternal(w, "failed to start aging report")
+		return
 	}
 
-	return rf
+	SuccessAccepted(w, "Отчёт по просрочке поставлен в очередь", map[string]interface{}{
+		"export_id": exportID,
+	})
 }
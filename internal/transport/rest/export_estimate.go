@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Estimate handlers accept the same body as the corresponding export endpoint
+// but only count rows, so the UI can warn before launching a huge export.
+
+func (h *Handler) estimateDebts(w http.ResponseWriter, r *http.Request) {
+	req, err := ValidateExportRequest(r)
+	if err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	filter := req.ToRepositoryFilter()
+
+	estimate, err := h.debts.EstimateDebtsExport(r.Context(), req.Fields, filter)
+	if err != nil {
+		log.Printf("[HTTP] estimateDebtsExport error: %v", err)
+		ErrorInternal(w, "failed to estimate export")
+		return
+	}
+
+	Success(w, "", estimate)
+}
+
+func (h *Handler) estimateUsers(w http.ResponseWriter, r *http.Request) {
+	if h.users == nil {
+		ErrorInternal(w, "users export not configured")
+		return
+	}
+
+	var req UsersExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		ErrorWithCode(w, "invalid JSON", CodeInvalidJSON, http.StatusBadRequest)
+		return
+	}
+
+	estimate, err := h.users.EstimateUsersExport(r.Context(), req.Fields)
+	if err != nil {
+		log.Printf("[HTTP] estimateUsersExport error: %v", err)
+		ErrorInternal(w, "failed to estimate users export")
+		return
+	}
+
+	Success(w, "", estimate)
+}
+
+func (h *Handler) estimateActions(w http.ResponseWriter, r *http.Request) {
+	if h.actions == nil {
+		ErrorInternal(w, "actions export not configured")
+		return
+	}
+	req, err := ValidateActionsExportRequest(r)
+	if err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	estimate, err := h.actions.EstimateActionsExport(r.Context(), req.Fields, req.ToRepositoryFilter())
+	if err != nil {
+		log.Printf("[HTTP] estimateActionsExport error: %v", err)
+		ErrorInternal(w, "failed to estimate actions export")
+		return
+	}
+
+	Success(w, "", estimate)
+}
+
+func (h *Handler) estimatePayments(w http.ResponseWriter, r *http.Request) {
+	req, err := ValidatePaymentsExportRequest(r)
+	if err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	estimate, err := h.payments.EstimatePaymentsExport(r.Context(), req.Fields, req.ToRepositoryFilter())
+	if err != nil {
+		log.Printf("[HTTP] estimatePaymentsExport error: %v", err)
+		ErrorInternal(w, "failed to estimate export")
+		return
+	}
+
+	Success(w, "", estimate)
+}
@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"debtster-export/internal/repository"
+	"debtster-export/internal/requestid"
+	"debtster-export/internal/service"
+)
+
+// fakeEstimator answers the estimates of every export type with estimate or
+// err and records what it was asked for. Other methods of the exporters are
+// not used by the estimate endpoints.
+type fakeEstimator struct {
+	DebtExporter
+	UserExporter
+	ActionExporter
+	PaymentExporter
+
+	estimate service.ExportEstimate
+	err      error
+
+	selected    []string
+	debtsFilter repository.DebtsFilter
+	requestID   string
+}
+
+func (f *fakeEstimator) EstimateDebtsExport(ctx context.Context, selected []string, filter repository.DebtsFilter) (service.ExportEstimate, error) {
+	f.selected, f.debtsFilter = selected, filter
+	f.requestID = requestid.FromContext(ctx)
+	return f.estimate, f.err
+}
+
+func (f *fakeEstimator) EstimateUsersExport(_ context.Context, selected []string) (service.ExportEstimate, error) {
+	f.selected = selected
+	return f.estimate, f.err
+}
+
+func (f *fakeEstimator) EstimateActionsExport(_ context.Context, selected []string, _ repository.ActionsFilter) (service.ExportEstimate, error) {
+	f.selected = selected
+	return f.estimate, f.err
+}
+
+func (f *fakeEstimator) EstimatePaymentsExport(_ context.Context, selected []string, _ repository.PaymentsFilter) (service.ExportEstimate, error) {
+	f.selected = selected
+	return f.estimate, f.err
+}
+
+func TestEstimateEndpoints(t *testing.T) {
+	estimate := service.ExportEstimate{Rows: 1500, Columns: 2, EstimatedBytes: 36000, EstimatedSeconds: 2, Limit: 1000, ExceedsLimit: true}
+
+	tests := []struct {
+		path   string
+		body   string
+		fields []string
+	}{
+		{"/export/debts/estimate", `{"fields": ["number", "debtor.iin"], "registry_id": "R-1"}`, []string{"number", "debtor.iin"}},
+		{"/export/users/estimate", `{"fields": ["username"]}`, []string{"username"}},
+		// без тела — колонки по умолчанию выбирает сервис
+		{"/export/users/estimate", ``, nil},
+		{"/export/actions/estimate", `{"fields": ["debt_id", "user_id"]}`, []string{"debt_id", "user_id"}},
+		{"/export/payments/estimate", `{"fields": ["id"]}`, []string{"id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			fake := &fakeEstimator{estimate: estimate}
+			router := NewHandler(fake, fake, fake, fake, nil).InitRouter()
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Status string                 `json:"status"`
+				Data   service.ExportEstimate `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != "success" || resp.Data != estimate {
+				t.Fatalf("response = %+v", resp)
+			}
+			if !slices.Equal(fake.selected, tt.fields) {
+				t.Fatalf("selected = %q, want %q", fake.selected, tt.fields)
+			}
+		})
+	}
+}
+
+func TestEstimateDebts_PassesFilter(t *testing.T) {
+	fake := &fakeEstimator{}
+	router := NewHandler(fake, nil, nil, nil, nil).InitRouter()
+
+	rec := httptest.NewRecorder()
+	body := `{"fields": ["number"], "registry_id": "R-1"}`
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/export/debts/estimate", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if fake.debtsFilter.RegistryID == nil || *fake.debtsFilter.RegistryID != "R-1" {
+		t.Fatalf("filter = %+v", fake.debtsFilter)
+	}
+}
+
+func TestRequestIDReachesServices(t *testing.T) {
+	fake := &fakeEstimator{}
+	router := NewHandler(fake, nil, nil, nil, nil).InitRouter()
+
+	// id от фронтенда доходит до сервиса, а значит и до статуса и WS-событий
+	req := httptest.NewRequest(http.MethodPost, "/export/debts/estimate", strings.NewReader(`{"fields": ["number"]}`))
+	req.Header.Set("X-Request-Id", "frontend-42")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if fake.requestID != "frontend-42" {
+		t.Fatalf("request id = %q", fake.requestID)
+	}
+}
+
+func TestEstimateEndpoints_Errors(t *testing.T) {
+	failing := &fakeEstimator{err: errors.New("db down")}
+
+	tests := []struct {
+		name    string
+		handler *Handler
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		// ошибка БД не уходит клиенту
+		{"debts", NewHandler(failing, nil, nil, nil, nil), "/export/debts/estimate", `{"fields": ["number"]}`, http.StatusInternalServerError, "failed to estimate export"},
+		{"users", NewHandler(nil, failing, nil, nil, nil), "/export/users/estimate", `{}`, http.StatusInternalServerError, "failed to estimate users export"},
+		{"actions", NewHandler(nil, nil, failing, nil, nil), "/export/actions/estimate", `{"fields": ["debt_id"]}`, http.StatusInternalServerError, "failed to estimate actions export"},
+		{"payments", NewHandler(nil, nil, nil, failing, nil), "/export/payments/estimate", `{"fields": ["id"]}`, http.StatusInternalServerError, "failed to estimate export"},
+
+		{"users not configured", NewHandler(nil, nil, nil, nil, nil), "/export/users/estimate", `{}`, http.StatusInternalServerError, "users export not configured"},
+		{"actions not configured", NewHandler(nil, nil, nil, nil, nil), "/export/actions/estimate", `{"fields": ["debt_id"]}`, http.StatusInternalServerError, "actions export not configured"},
+
+		// до сервиса невалидный запрос не доходит
+		{"invalid filter", NewHandler(failing, nil, nil, nil, nil), "/export/debts/estimate", `{"fields": ["number"], "status_id": "x"}`, http.StatusUnprocessableEntity, ""},
+		{"invalid users JSON", NewHandler(nil, failing, nil, nil, nil), "/export/users/estimate", `{"fields":`, http.StatusBadRequest, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.InitRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var resp APIResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.message != "" && resp.Message != tt.message {
+				t.Fatalf("message = %q, want %q", resp.Message, tt.message)
+			}
+		})
+	}
+}
@@ -2,9 +2,14 @@ package rest
 
 import (
 	"context"
+	"debtster-export/internal/service"
 	"debtster-export/internal/transport/auth"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -12,6 +17,10 @@ import (
 type ExportListService interface {
 	GetExports(ctx context.Context, userID int64) ([]interface{}, error)
 	GetExport(ctx context.Context, exportID string, userID int64) (interface{}, error)
+	RetryExport(ctx context.Context, exportID string, userID int64) (string, error)
+	CancelExport(ctx context.Context, exportID string, userID int64) error
+	UpdateComment(ctx context.Context, exportID string, userID int64, comment string) error
+	RefreshURL(ctx context.Context, exportID string, userID int64) (string, *time.Time, error)
 }
 
 func (h *Handler) listExports(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +36,9 @@ func (h *Handler) listExports(w http.ResponseWriter, r *http.Request) {
 		ErrorInternal(w, "failed to get exports")
 		return
 	}
+	for _, export := range exports {
+		h.exportFileURL(r, export)
+	}
 
 	Success(w, "", exports)
 }
@@ -45,12 +57,193 @@ func (h *Handler) getExport(w http.ResponseWriter, r *http.Request) {
 	}
 	exportID := "exports:" + exportIDParam
 
+	wait, err := parseWait(r.URL.Query().Get("wait"))
+	if err != nil {
+		ErrorBadRequest(w, err.Error())
+		return
+	}
+
 	export, err := h.exportList.GetExport(r.Context(), exportID, userID)
 	if err != nil {
 		log.Printf("[HTTP] getExport error: %v", err)
-		ErrorNotFound(w, "export not found")
+		ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+		return
+	}
+	etag := exportETag(export)
+
+	// long poll: hold the request until the status differs from the one the
+	// client has (If-None-Match) or, without it, from the current one
+	if wait > 0 {
+		seen := r.Header.Get("If-None-Match")
+		if seen == "" {
+			seen = etag
+		}
+		if etagMatch(seen, etag) {
+			export, etag, err = h.waitExportChange(w, r, exportID, userID, seen, wait)
+			if err != nil {
+				log.Printf("[HTTP] getExport error: %v", err)
+				ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	h.exportFileURL(r, export)
 
 	Success(w, "", export)
 }
+
+func (h *Handler) retryExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	exportIDParam := chi.URLParam(r, "export_id")
+	if exportIDParam == "" {
+		ErrorBadRequest(w, "export_id is required")
+		return
+	}
+	exportID := "exports:" + exportIDParam
+
+	newID, err := h.exportList.RetryExport(r.Context(), exportID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrExportNotFound):
+			ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+		case errors.Is(err, service.ErrExportNotRetryable), errors.Is(err, service.ErrInvalidDelivery), errors.Is(err, service.ErrInvalidComputedColumn) || errors.Is(err, service.ErrInvalidTemplate), errors.Is(err, service.ErrTooManyRows):
+			ErrorWithCode(w, err.Error(), serviceCode(err), http.StatusConflict)
+		default:
+			log.Printf("[HTTP] retryExport error: %v", err)
+			ErrorInternal(w, "failed to retry export")
+		}
+		return
+	}
+
+	SuccessAccepted(w, "Экспорт перезапущен", map[string]string{
+		"export_id": newID,
+	})
+}
+
+func (h *Handler) cancelExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	exportIDParam := chi.URLParam(r, "export_id")
+	if exportIDParam == "" {
+		ErrorBadRequest(w, "export_id is required")
+		return
+	}
+	exportID := "exports:" + exportIDParam
+
+	if err := h.exportList.CancelExport(r.Context(), exportID, userID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrExportNotFound):
+			ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+		case errors.Is(err, service.ErrExportNotCancellable):
+			ErrorWithCode(w, err.Error(), serviceCode(err), http.StatusConflict)
+		default:
+			log.Printf("[HTTP] cancelExport error: %v", err)
+			ErrorInternal(w, "failed to cancel export")
+		}
+		return
+	}
+
+	SuccessAccepted(w, "Экспорт отменяется", map[string]string{
+		"export_id": exportID,
+	})
+}
+
+// refreshExportURL issues a new download link of a finished export, e.g. after
+// the previous one expired.
+func (h *Handler) refreshExportURL(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	exportIDParam := chi.URLParam(r, "export_id")
+	if exportIDParam == "" {
+		ErrorBadRequest(w, "export_id is required")
+		return
+	}
+	exportID := "exports:" + exportIDParam
+
+	url, expiresAt, err := h.exportList.RefreshURL(r.Context(), exportID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrExportNotFound):
+			ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+		case errors.Is(err, service.ErrExportNotReady):
+			ErrorWithCode(w, err.Error(), serviceCode(err), http.StatusConflict)
+		case errors.Is(err, service.ErrExportFileGone):
+			ErrorWithCode(w, "export file no longer exists, start the export again", CodeExportFileGone, http.StatusGone)
+		default:
+			log.Printf("[HTTP] refreshExportURL error: %v", err)
+			ErrorInternal(w, "failed to refresh export url")
+		}
+		return
+	}
+
+	Success(w, "", map[string]any{
+		"export_id":  exportID,
+		"url":        h.fileURL(r, url),
+		"expires_at": expiresAt,
+	})
+}
+
+// updateExport edits the comment of an export: {"comment": "..."}; an empty
+// comment removes it.
+func (h *Handler) updateExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	exportIDParam := chi.URLParam(r, "export_id")
+	if exportIDParam == "" {
+		ErrorBadRequest(w, "export_id is required")
+		return
+	}
+	exportID := "exports:" + exportIDParam
+
+	var raw struct {
+		Comment interface{} `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil && err != io.EOF {
+		ErrorWithCode(w, "invalid JSON", CodeInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	comment, err := toComment(raw.Comment)
+	if err != nil {
+		ErrorBadRequest(w, err.Error())
+		return
+	}
+
+	if err := h.exportList.UpdateComment(r.Context(), exportID, userID, comment); err != nil {
+		if errors.Is(err, service.ErrExportNotFound) {
+			ErrorWithCode(w, "export not found", CodeExportNotFound, http.StatusNotFound)
+			return
+		}
+		log.Printf("[HTTP] updateExport error: %v", err)
+		ErrorInternal(w, "failed to update export")
+		return
+	}
+
+	Success(w, "Комментарий сохранён", map[string]string{
+		"export_id": exportID,
+		"comment":   comment,
+	})
+}
@@ -2,24 +2,18 @@ package rest
 
 import (
 	"debtster-export/internal/repository"
-	"encoding/json"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
-	"time"
 
+	"debtster-export/internal/service"
 	"debtster-export/internal/transport/auth"
 )
 
 func (h *Handler) exportPayments(w http.ResponseWriter, r *http.Request) {
 	req, err := ValidatePaymentsExportRequest(r)
 	if err != nil {
-		if _, ok := err.(*ValidationError); ok {
-			ErrorBadRequest(w, err.Error())
-			return
-		}
-		ErrorBadRequest(w, "invalid JSON")
+		errorRequest(w, err)
 		return
 	}
 
@@ -31,7 +25,16 @@ func (h *Handler) exportPayments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exportID, err := h.payments.StartPaymentsExport(r.Context(), req.Fields, filter, userID)
+	opts := service.PaymentsExportOptions{
+		ExportOptions: service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template},
+		GroupBy:       req.GroupBy,
+		FormatProfile: req.FormatProfile,
+	}
+
+	exportID, err := h.payments.StartPaymentsExport(r.Context(), req.Fields, filter, opts, userID)
+	if errorStartExport(w, err) {
+		return
+	}
 	if err != nil {
 		log.Printf("[HTTP] startPaymentsExport error: %v", err)
 		ErrorInternal(w, "failed to start export")
@@ -42,120 +45,129 @@ func (h *Handler) exportPayments(w http.ResponseWriter, r *http.Request) {
 }
 
 type PaymentsExportRequest struct {
-	Fields              []string   `json:"fields"`
-	Confirmed           *int       `json:"confirmed,omitempty"`
-	CounterpartyID      *string    `json:"counterparty_id,omitempty"`
-	UserID              *int64     `json:"user_id,omitempty"`
-	PeriodImportedStart *time.Time `json:"period_imported_start_date,omitempty"`
-	PeriodImportedEnd   *time.Time `json:"period_imported_end_date,omitempty"`
+	Fields        []string                  `json:"fields"`
+	Filter        repository.PaymentsFilter `json:"-"`
+	Filename      string                    `json:"filename,omitempty"`
+	SingleUse     bool                      `json:"single_use,omitempty"`
+	Delivery      *service.DeliveryOptions  `json:"delivery,omitempty"`
+	Comment       string                    `json:"comment,omitempty"`
+	Computed      []service.ComputedColumn  `json:"computed,omitempty"`
+	Template      string                    `json:"template,omitempty"`
+	URLTTLHours   int                       `json:"url_ttl_hours,omitempty"`
+	GroupBy       string                    `json:"group_by,omitempty"`
+	FormatProfile string                    `json:"format_profile,omitempty"`
+	OutputProfile string                    `json:"output_profile,omitempty"`
+	Format        string                    `json:"format,omitempty"`
+	CSV           *service.CSVDialect       `json:"csv,omitempty"`
 }
 
+// rawPaymentsExportRequest is the body of a payments export without the
+// filters, which repository.PaymentsFilters parses.
 type rawPaymentsExportRequest struct {
-	Fields              []string    `json:"fields"`
-	Confirmed           interface{} `json:"confirmed"`
-	CounterpartyID      interface{} `json:"counterparty_id"`
-	UserID              interface{} `json:"user_id"`
-	PeriodImportedStart interface{} `json:"period_imported_start_date"`
-	PeriodImportedEnd   interface{} `json:"period_imported_end_date"`
+	Fields        []string    `json:"fields"`
+	Filename      interface{} `json:"filename"`
+	SingleUse     interface{} `json:"single_use"`
+	Delivery      interface{} `json:"delivery"`
+	Comment       interface{} `json:"comment"`
+	Computed      interface{} `json:"computed"`
+	Template      interface{} `json:"template"`
+	URLTTLHours   interface{} `json:"url_ttl_hours"`
+	GroupBy       interface{} `json:"group_by"`
+	FormatProfile interface{} `json:"format_profile"`
+	OutputProfile interface{} `json:"output_profile"`
+	Format        interface{} `json:"format"`
+	CSV           interface{} `json:"csv"`
 }
 
 // ValidatePaymentsExportRequest parses and validates JSON input for payments export
 func ValidatePaymentsExportRequest(r *http.Request) (*PaymentsExportRequest, error) {
+	return parsePaymentsExportRequest(r.Body)
+}
+
+func parsePaymentsExportRequest(body io.Reader) (*PaymentsExportRequest, error) {
 	var raw rawPaymentsExportRequest
-	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil && err != io.EOF {
+	values, err := decodeRequest(body, &raw)
+	if err != nil {
 		return nil, err
 	}
-	if len(raw.Fields) == 0 {
-		return nil, &ValidationError{Field: "fields", Message: "fields is required and must be an array"}
-	}
 
-	var confirmed *int
-	if raw.Confirmed != nil {
-		switch v := raw.Confirmed.(type) {
-		case float64:
-			i := int(v)
-			confirmed = &i
-		case string:
-			if v != "" {
-				if parsed, err := strconv.Atoi(v); err == nil {
-					confirmed = &parsed
-				} else {
-					return nil, &ValidationError{Field: "confirmed", Message: "confirmed must be integer or empty"}
-				}
-			}
-		default:
-			return nil, &ValidationError{Field: "confirmed", Message: "confirmed must be integer or empty"}
+	var v ValidationErrors
+	formatProfile, ok := raw.FormatProfile.(string)
+	if raw.FormatProfile != nil && !ok {
+		v.addf("format_profile", "format_profile must be string or empty")
+	}
+	// a profile has its own fixed columns
+	if formatProfile != "" {
+		if len(raw.Fields) > 0 {
+			v.addf("fields", "fields is not supported with format_profile")
+		}
+		if raw.Computed != nil {
+			v.addf("computed", "computed is not supported with format_profile")
 		}
+		if raw.GroupBy != nil {
+			v.addf("group_by", "group_by is not supported with format_profile")
+		}
+	} else if len(raw.Fields) == 0 {
+		v.addf("fields", "fields is required and must be an array")
 	}
+	v.add(checkFields(service.UnknownPaymentColumns(raw.Fields)))
 
-	counterpartyID, err := toStringPtr(raw.CounterpartyID)
-	if err != nil {
-		return nil, &ValidationError{Field: "counterparty_id", Message: "counterparty_id must be string or empty"}
-	}
+	filters, errs := repository.PaymentsFilters.Parse(values)
+	v.addFilters(errs)
 
-	userID, err := toInt64Ptr(raw.UserID)
-	if err != nil {
-		return nil, &ValidationError{Field: "user_id", Message: "user_id must be integer or empty"}
-	}
+	filename, err := toFilename(raw.Filename)
+	v.add(err)
 
-	// parse dates (YYYY-MM-DD) if provided
-	var startDate *time.Time
-	var endDate *time.Time
-	if raw.PeriodImportedStart != nil {
-		switch v := raw.PeriodImportedStart.(type) {
-		case string:
-			if v != "" {
-				parsed, err := time.Parse("2006-01-02", v)
-				if err != nil {
-					return nil, &ValidationError{Field: "period_imported_start_date", Message: "must be YYYY-MM-DD or empty"}
-				}
-				startDate = &parsed
-			}
-		default:
-			return nil, &ValidationError{Field: "period_imported_start_date", Message: "must be YYYY-MM-DD or empty"}
-		}
-	}
-	if raw.PeriodImportedEnd != nil {
-		switch v := raw.PeriodImportedEnd.(type) {
-		case string:
-			if v != "" {
-				parsed, err := time.Parse("2006-01-02", v)
-				if err != nil {
-					return nil, &ValidationError{Field: "period_imported_end_date", Message: "must be YYYY-MM-DD or empty"}
-				}
-				endDate = &parsed
-			}
-		default:
-			return nil, &ValidationError{Field: "period_imported_end_date", Message: "must be YYYY-MM-DD or empty"}
-		}
+	singleUse, err := toBool(raw.SingleUse)
+	v.check(err, "single_use", "single_use must be boolean or empty")
+
+	delivery, err := toDelivery(raw.Delivery)
+	v.add(err)
+
+	comment, err := toComment(raw.Comment)
+	v.add(err)
+
+	computed, err := toComputed(raw.Computed)
+	v.add(err)
+
+	template, err := toTemplate(raw.Template)
+	v.add(err)
+
+	urlTTL, err := toURLTTL(raw.URLTTLHours)
+	v.add(err)
+
+	outputProfile, err := toOutputProfile(raw.OutputProfile)
+	v.add(err)
+
+	format, csvDialect, err := toFormat(raw.Format, raw.CSV)
+	v.add(err)
+
+	groupBy, ok := raw.GroupBy.(string)
+	if formatProfile == "" && ((raw.GroupBy != nil && !ok) || (groupBy != "" && groupBy != service.PaymentsGroupByDate)) {
+		v.addf("group_by", "group_by must be payment_date or empty")
 	}
 
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
 	return &PaymentsExportRequest{
-		Fields:              raw.Fields,
-		Confirmed:           confirmed,
-		CounterpartyID:      counterpartyID,
-		UserID:              userID,
-		PeriodImportedStart: startDate,
-		PeriodImportedEnd:   endDate,
+		Fields:        raw.Fields,
+		Filter:        filters,
+		Filename:      filename,
+		SingleUse:     singleUse,
+		Delivery:      delivery,
+		Comment:       comment,
+		Computed:      computed,
+		Template:      template,
+		URLTTLHours:   urlTTL,
+		GroupBy:       groupBy,
+		FormatProfile: formatProfile,
+		OutputProfile: outputProfile,
+		Format:        format,
+		CSV:           csvDialect,
 	}, nil
 }
 
 func (r *PaymentsExportRequest) ToRepositoryFilter() repository.PaymentsFilter {
-	rf := repository.PaymentsFilter{}
-	if r.Confirmed != nil {
-		rf.Confirmed = r.Confirmed
-	}
-	if r.CounterpartyID != nil {
-		rf.CounterpartyID = r.CounterpartyID
-	}
-	if r.UserID != nil {
-		rf.UserID = r.UserID
-	}
-	if r.PeriodImportedStart != nil {
-		rf.PeriodImportedStartDate = r.PeriodImportedStart
-	}
-	if r.PeriodImportedEnd != nil {
-		rf.PeriodImportedEndDate = r.PeriodImportedEnd
-	}
-	return rf
+	return r.Filter
 }
@@ -6,11 +6,22 @@ import (
 	"log"
 	"net/http"
 
+	"debtster-export/internal/service"
 	"debtster-export/internal/transport/auth"
 )
 
 type UsersExportRequest struct {
-	Fields []string `json:"fields"`
+	Fields        []string                 `json:"fields"`
+	Filename      string                   `json:"filename,omitempty"`
+	SingleUse     bool                     `json:"single_use,omitempty"`
+	Delivery      *service.DeliveryOptions `json:"delivery,omitempty"`
+	Comment       string                   `json:"comment,omitempty"`
+	Computed      []service.ComputedColumn `json:"computed,omitempty"`
+	Template      string                   `json:"template,omitempty"`
+	URLTTLHours   int                      `json:"url_ttl_hours,omitempty"`
+	OutputProfile string                   `json:"output_profile,omitempty"`
+	Format        string                   `json:"format,omitempty"`
+	CSV           *service.CSVDialect      `json:"csv,omitempty"`
 }
 
 func (h *Handler) exportUsers(w http.ResponseWriter, r *http.Request) {
@@ -19,10 +30,9 @@ func (h *Handler) exportUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req UsersExportRequest
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
-		ErrorBadRequest(w, "invalid JSON")
+	req, err := parseUsersExportRequest(r.Body)
+	if err != nil {
+		errorRequest(w, err)
 		return
 	}
 
@@ -32,7 +42,10 @@ func (h *Handler) exportUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exportID, err := h.users.StartUsersExport(r.Context(), req.Fields, userID)
+	exportID, err := h.users.StartUsersExport(r.Context(), req.Fields, service.ExportOptions{Filename: req.Filename, SingleUse: req.SingleUse, URLTTLHours: req.URLTTLHours, OutputProfile: req.OutputProfile, Format: req.Format, CSV: req.CSV, Delivery: req.Delivery, Comment: req.Comment, Computed: req.Computed, Template: req.Template}, userID)
+	if errorStartExport(w, err) {
+		return
+	}
 	if err != nil {
 		log.Printf("[HTTP] startUsersExport error: %v", err)
 		ErrorInternal(w, "failed to start users export")
@@ -43,3 +56,27 @@ func (h *Handler) exportUsers(w http.ResponseWriter, r *http.Request) {
 		"export_id": exportID,
 	})
 }
+
+func parseUsersExportRequest(body io.Reader) (*UsersExportRequest, error) {
+	var req UsersExportRequest
+
+	if err := json.NewDecoder(body).Decode(&req); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var v ValidationErrors
+	v.add(checkFields(service.UnknownUserColumns(req.Fields)))
+	_, err := validateDelivery(req.Delivery)
+	v.add(err)
+	comment, err := toComment(req.Comment)
+	v.add(err)
+	req.Comment = comment
+	v.add(validateComputed(req.Computed))
+	v.add(validateTemplateName(req.Template))
+	v.add(validateURLTTL(req.URLTTLHours))
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
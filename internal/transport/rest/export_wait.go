@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxExportWait caps ?wait= of GET /export/{id}
+	maxExportWait = 30 * time.Second
+	// exportRecheckInterval — how often a long poll re-reads the status when no
+	// event wakes it (exports running on another instance, cancellations)
+	exportRecheckInterval = 2 * time.Second
+)
+
+// ExportWatcher wakes long polls at events of the exports of this instance;
+// implemented by *service.ExportWatch.
+type ExportWatcher interface {
+	Watch(exportID string) (<-chan struct{}, func())
+}
+
+// SetExportWatcher lets long polls of GET /export/{id} return right after an
+// event instead of at the next re-check.
+func (h *Handler) SetExportWatcher(watch ExportWatcher) {
+	h.exportWatch = watch
+}
+
+// parseWait parses ?wait= as a duration ("30s") or whole seconds ("30").
+func parseWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		secs, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid wait %q: want a duration like 30s", raw)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid wait %q: must not be negative", raw)
+	}
+	return min(d, maxExportWait), nil
+}
+
+// exportETag is the entity tag of an export status response.
+func exportETag(export any) string {
+	raw, _ := json.Marshal(export)
+	sum := sha256.Sum256(raw)
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// etagMatch reports whether the If-None-Match header lists etag.
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// waitExportChange re-reads the export until its entity tag differs from seen,
+// wait passes or the client goes away, and returns the last status read.
+func (h *Handler) waitExportChange(w http.ResponseWriter, r *http.Request, exportID string, userID int64, seen string, wait time.Duration) (any, string, error) {
+	// the server write timeout would cut long polls short
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	recheck := time.NewTicker(exportRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		var (
+			changed <-chan struct{}
+			stop    = func() {}
+		)
+		if h.exportWatch != nil {
+			changed, stop = h.exportWatch.Watch(exportID)
+		}
+
+		timedOut := false
+		select {
+		case <-r.Context().Done():
+			timedOut = true
+		case <-deadline.C:
+			timedOut = true
+		case <-changed:
+		case <-recheck.C:
+		}
+		stop()
+
+		export, err := h.exportList.GetExport(r.Context(), exportID, userID)
+		if err != nil {
+			return nil, "", err
+		}
+		etag := exportETag(export)
+		if timedOut || !etagMatch(seen, etag) {
+			return export, etag, nil
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// FileUploader stores uploaded files; implemented by *clients.StorageClient.
+type FileUploader interface {
+	SaveStream(ctx context.Context, fileName string, r io.Reader) (string, error)
+	SetOwner(fileName string, ownerID int64, singleUse bool) error
+	GetURL(fileName string) string
+	Delete(fileName string) error
+}
+
+// UploadRegistry records who uploaded which file; implemented by
+// *repository.UploadRepository.
+type UploadRegistry interface {
+	Create(ctx context.Context, u domain.Upload) error
+	ListByUser(ctx context.Context, userID int64) ([]domain.Upload, error)
+	Delete(ctx context.Context, file string, userID int64) (bool, error)
+}
+
+// uploadInfo is an entry of GET /files.
+type uploadInfo struct {
+	domain.Upload
+	URL string `json:"url"`
+}
+
+// SetFileUploader enables POST /files/upload.
+func (h *Handler) SetFileUploader(files FileUploader) {
+	h.files = files
+}
+
+// SetUploadRegistry records uploads and enables GET /files and DELETE /files/*.
+func (h *Handler) SetUploadRegistry(uploads UploadRegistry) {
+	h.uploads = uploads
+}
+
+// uploadFile stores the "file" of a multipart form and returns its url and
+// storage name; the file is accounted to the uploading user. The file is
+// streamed to the storage as it arrives, so its size is not limited by memory.
+func (h *Handler) uploadFile(w http.ResponseWriter, r *http.Request) {
+	if h.files == nil {
+		http.Error(w, "uploads are not configured", http.StatusNotFound)
+		return
+	}
+	form, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	var part *multipart.Part
+	for {
+		part, err = form.NextPart()
+		if err != nil {
+			http.Error(w, "file required", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			break
+		}
+		part.Close()
+	}
+	defer part.Close()
+
+	// the first bytes are kept for content sniffing, checksum and size are
+	// taken on the way to the storage
+	content := bufio.NewReader(part)
+	head, _ := content.Peek(512)
+	ct := part.Header.Get("Content-Type")
+	if ct == "" || ct == "application/octet-stream" {
+		ct = http.DetectContentType(head)
+	}
+	sum := sha256.New()
+	size := &countingWriter{}
+
+	saved, err := h.files.SaveStream(r.Context(), part.FileName(), io.TeeReader(content, io.MultiWriter(sum, size)))
+	if err != nil {
+		log.Printf("[HTTP] uploadFile error: %v", err)
+		http.Error(w, "failed to save file", http.StatusInternalServerError)
+		return
+	}
+	// account the upload to the user's storage quota
+	userID, authErr := auth.GetUserID(r.Context())
+	if authErr == nil {
+		if err := h.files.SetOwner(saved, userID, false); err != nil {
+			log.Printf("upload: set owner of %s: %v", saved, err)
+		}
+	}
+
+	// anonymous uploads stay unregistered and downloadable by anyone
+	if h.uploads != nil && authErr == nil {
+		err := h.uploads.Create(r.Context(), domain.Upload{
+			File:         saved,
+			UserID:       userID,
+			OriginalName: part.FileName(),
+			Size:         size.n,
+			ContentType:  ct,
+			Checksum:     hex.EncodeToString(sum.Sum(nil)),
+		})
+		if err != nil {
+			log.Printf("[HTTP] uploadFile register %s: %v", saved, err)
+			// an unregistered file would be served to everyone
+			if err := h.files.Delete(saved); err != nil {
+				log.Printf("upload: delete %s: %v", saved, err)
+			}
+			http.Error(w, "failed to save file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"url":  h.fileURL(r, h.files.GetURL(saved)),
+		"file": saved,
+	})
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// listUploads returns the files the caller uploaded, newest first.
+func (h *Handler) listUploads(w http.ResponseWriter, r *http.Request) {
+	if h.files == nil || h.uploads == nil {
+		ErrorNotFound(w, "uploads are not configured")
+		return
+	}
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	list, err := h.uploads.ListByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("[HTTP] listUploads error: %v", err)
+		ErrorInternal(w, "failed to list files")
+		return
+	}
+
+	out := make([]uploadInfo, 0, len(list))
+	for _, u := range list {
+		out = append(out, uploadInfo{Upload: u, URL: h.fileURL(r, h.files.GetURL(u.File))})
+	}
+	Success(w, "", out)
+}
+
+// deleteUpload removes an upload of the caller; files of other users and
+// generated exports are reported as not found.
+func (h *Handler) deleteUpload(w http.ResponseWriter, r *http.Request) {
+	if h.files == nil || h.uploads == nil {
+		ErrorNotFound(w, "uploads are not configured")
+		return
+	}
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+	file, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil || file == "" {
+		ErrorNotFound(w, "file not found")
+		return
+	}
+
+	deleted, err := h.uploads.Delete(r.Context(), file, userID)
+	if err != nil {
+		log.Printf("[HTTP] deleteUpload error: %v", err)
+		ErrorInternal(w, "failed to delete file")
+		return
+	}
+	if !deleted {
+		ErrorNotFound(w, "file not found")
+		return
+	}
+	if err := h.files.Delete(file); err != nil {
+		log.Printf("[HTTP] deleteUpload %s: %v", file, err)
+	}
+
+	Success(w, "Файл удалён", nil)
+}
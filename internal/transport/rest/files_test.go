@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/transport/auth"
+)
+
+type fakeUploads struct {
+	saved  map[string][]byte
+	owners map[string]int64
+}
+
+func (f *fakeUploads) SaveStream(_ context.Context, fileName string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	name := "0123456789abcdef_" + fileName
+	f.saved[name] = data
+	return name, nil
+}
+
+func (f *fakeUploads) SetOwner(fileName string, ownerID int64, _ bool) error {
+	f.owners[fileName] = ownerID
+	return nil
+}
+
+func (f *fakeUploads) GetURL(fileName string) string {
+	return "/files/" + fileName
+}
+
+func (f *fakeUploads) Delete(fileName string) error {
+	delete(f.saved, fileName)
+	return nil
+}
+
+type fakeRegistry struct {
+	uploads []domain.Upload
+}
+
+func (f *fakeRegistry) Create(_ context.Context, u domain.Upload) error {
+	f.uploads = append(f.uploads, u)
+	return nil
+}
+
+func (f *fakeRegistry) ListByUser(_ context.Context, userID int64) ([]domain.Upload, error) {
+	var out []domain.Upload
+	for _, u := range f.uploads {
+		if u.UserID == userID {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRegistry) Delete(_ context.Context, file string, userID int64) (bool, error) {
+	for i, u := range f.uploads {
+		if u.File == file && u.UserID == userID {
+			f.uploads = append(f.uploads[:i], f.uploads[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestUploadFile(t *testing.T) {
+	uploads := &fakeUploads{saved: map[string][]byte{}, owners: map[string]int64{}}
+	h := NewHandler(nil, nil, nil, nil, nil)
+	h.SetFileUploader(uploads)
+	router := h.InitRouter()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "report.xlsx")
+	_, _ = part.Write([]byte("xlsx"))
+	_ = form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/files/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, int64(7)))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response: %v", err)
+	}
+	name := "0123456789abcdef_report.xlsx"
+	if resp["file"] != name || resp["url"] != "/files/"+name {
+		t.Fatalf("response = %v", resp)
+	}
+	if string(uploads.saved[name]) != "xlsx" || uploads.owners[name] != 7 {
+		t.Fatalf("saved %q owned by %d", uploads.saved[name], uploads.owners[name])
+	}
+
+	// без файла в форме
+	req = httptest.NewRequest(http.MethodPost, "/files/upload", bytes.NewBufferString("x"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=none")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status without file = %d", rec.Code)
+	}
+}
+
+func TestUploadRegistry(t *testing.T) {
+	uploads := &fakeUploads{saved: map[string][]byte{}, owners: map[string]int64{}}
+	registry := &fakeRegistry{}
+	h := NewHandler(nil, nil, nil, nil, nil)
+	h.SetFileUploader(uploads)
+	h.SetUploadRegistry(registry)
+	router := h.InitRouter()
+
+	as := func(req *http.Request, userID int64) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("comment", "перед файлом")
+	part, _ := form.CreateFormFile("file", "notes.txt")
+	_, _ = part.Write([]byte("hello"))
+	_ = form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/files/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, as(req, 7))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload status = %d: %s", rec.Code, rec.Body)
+	}
+
+	if len(registry.uploads) != 1 {
+		t.Fatalf("registered %d uploads", len(registry.uploads))
+	}
+	u := registry.uploads[0]
+	// sha256("hello")
+	if u.UserID != 7 || u.OriginalName != "notes.txt" || u.Size != 5 ||
+		u.Checksum != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" ||
+		u.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("registered %+v", u)
+	}
+
+	// список видит только владелец
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, as(httptest.NewRequest(http.MethodGet, "/files", nil), 8))
+	var list struct {
+		Data []map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Data) != 0 {
+		t.Fatalf("list of another user = %s", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, as(httptest.NewRequest(http.MethodGet, "/files", nil), 7))
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Data) != 1 ||
+		list.Data[0]["url"] != "/files/"+u.File {
+		t.Fatalf("list = %s", rec.Body)
+	}
+
+	// чужой файл удалить нельзя
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, as(httptest.NewRequest(http.MethodDelete, "/files/"+u.File, nil), 8))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("delete by another user = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, as(httptest.NewRequest(http.MethodDelete, "/files/"+u.File, nil), 7))
+	if rec.Code != http.StatusOK || len(registry.uploads) != 0 || uploads.saved[u.File] != nil {
+		t.Fatalf("delete = %d, registry %v", rec.Code, registry.uploads)
+	}
+}
@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"debtster-export/internal/repository"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func date(s string) *time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return &t
+}
+
+// Каждый фильтр из запроса либо доходит до репозитория как есть, либо
+// отклоняется валидацией — молча не теряется ни один.
+func TestFiltersToRepository(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		parse func(string) (any, error)
+		want  any
+	}{
+		{
+			name:  "debts",
+			body:  `{"fields": ["number"], "registry_id": "r-1", "counterparty_id": 42, "department_id": "3", "status_id": 5, "user_id": "7", "stage": "court"}`,
+			parse: parseDebts,
+			want: repository.DebtsFilter{
+				RegistryID: ptr("r-1"), CounterpartyID: ptr("42"), DepartmentID: ptr(int64(3)),
+				StatusID: ptr(int64(5)), UserID: ptr(int64(7)), Stage: ptr("court"),
+			},
+		},
+		{
+			name:  "debts without filters",
+			body:  `{"fields": ["number"], "registry_id": "", "status_id": null, "stage": ""}`,
+			parse: parseDebts,
+			want:  repository.DebtsFilter{},
+		},
+		{
+			name:  "aging report",
+			body:  `{"status_id": 5, "department_id": 3}`,
+			parse: parseAging,
+			want:  repository.DebtsFilter{StatusID: ptr(int64(5)), DepartmentID: ptr(int64(3))},
+		},
+		{
+			name: "actions",
+			body: `{"fields": ["comment"], "counterparty_id": "cp", "status_id": 5, "debt_status_id": 6, "department_id": 3, "type_id": "sms",
+				"type_ids": ["call"], "exclude_type_ids": ["email"], "user_id": 7, "create_start_date": "2024-01-01", "create_end_date": "2024-01-31",
+				"next_contact_start_date": "2024-02-01", "next_contact_end_date": "2024-02-29", "latest_per_debt": true}`,
+			parse: parseActions,
+			want: repository.ActionsFilter{
+				CounterpartyID: ptr("cp"), StatusID: ptr(int64(5)), DebtStatusID: ptr(int64(6)), DepartmentID: ptr(int64(3)),
+				TypeID: ptr("sms"), TypeIDs: []string{"call"}, ExcludeTypeIDs: []string{"email"}, UserID: ptr(int64(7)),
+				CreatedFrom: date("2024-01-01"), CreatedTo: date("2024-01-31"), NextContactFrom: date("2024-02-01"), NextContactTo: date("2024-02-29"),
+				LatestPerDebt: true,
+			},
+		},
+		{
+			name:  "actions daily report",
+			body:  `{"status_id": 5, "user_id": 7}`,
+			parse: parseActionsDaily,
+			want:  repository.ActionsFilter{StatusID: ptr(int64(5)), UserID: ptr(int64(7))},
+		},
+		{
+			name: "payments",
+			body: `{"fields": ["amount"], "confirmed": 0, "counterparty_id": "cp", "user_id": 7,
+				"period_imported_start_date": "2024-01-01", "period_imported_end_date": "2024-01-31"}`,
+			parse: parsePayments,
+			want: repository.PaymentsFilter{
+				Confirmed: ptr(0), CounterpartyID: ptr("cp"), UserID: ptr(int64(7)),
+				PeriodImportedStartDate: date("2024-01-01"), PeriodImportedEndDate: date("2024-01-31"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filter = %+v\nwant     %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFiltersRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		parse func(string) (any, error)
+		field string
+	}{
+		// раньше status_id 0 выгружал все долги без фильтра
+		{"debts status 0", `{"fields": ["number"], "status_id": 0}`, parseDebts, "status_id"},
+		{"debts negative user", `{"fields": ["number"], "user_id": -1}`, parseDebts, "user_id"},
+		{"debts fractional status", `{"fields": ["number"], "status_id": 1.5}`, parseDebts, "status_id"},
+		// раньше нечисловой отдел молча отбрасывался
+		{"debts department", `{"fields": ["number"], "department_id": "sales"}`, parseDebts, "department_id"},
+		{"aging status 0", `{"status_id": "0"}`, parseAging, "status_id"},
+		{"actions status 0", `{"fields": ["comment"], "status_id": 0}`, parseActions, "status_id"},
+		{"actions debt status 0", `{"fields": ["comment"], "debt_status_id": 0}`, parseActions, "debt_status_id"},
+		{"actions department 0", `{"fields": ["comment"], "department_id": 0}`, parseActions, "department_id"},
+		{"daily status 0", `{"status_id": 0}`, parseActionsDaily, "status_id"},
+		// confirmed 2 раньше означал «не подтверждён»
+		{"payments confirmed", `{"fields": ["amount"], "confirmed": 2}`, parsePayments, "confirmed"},
+		{"payments user 0", `{"fields": ["amount"], "user_id": 0}`, parsePayments, "user_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.parse(tt.body)
+			list := asValidationErrors(err)
+			if list == nil || len(list.Fields()[tt.field]) == 0 {
+				t.Fatalf("err = %v, want an error for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func parseDebts(body string) (any, error) {
+	req, err := parseExportRequest(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return req.ToRepositoryFilter(), nil
+}
+
+func parseAging(body string) (any, error) {
+	req, err := parseAgingReportRequest(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return req.ToRepositoryFilter(), nil
+}
+
+func parseActions(body string) (any, error) {
+	req, err := parseActionsExportRequest(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return req.ToRepositoryFilter(), nil
+}
+
+func parseActionsDaily(body string) (any, error) {
+	req, err := parseActionsDailyReportRequest(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return req.ToRepositoryFilter(), nil
+}
+
+func parsePayments(body string) (any, error) {
+	req, err := parsePaymentsExportRequest(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return req.ToRepositoryFilter(), nil
+}
@@ -2,7 +2,10 @@ package rest
 
 import (
 	"context"
+	"debtster-export/internal/reporting"
 	"debtster-export/internal/repository"
+	"debtster-export/internal/service"
+	"debtster-export/internal/transport/auth"
 	"fmt"
 	"net/http"
 	"time"
@@ -16,6 +19,15 @@ type DebtExporter interface {
 		rctx context.Context,
 		selected []string,
 		filter repository.DebtsFilter,
+		opts service.DebtsExportOptions,
+		userID int64,
+	) (string, error)
+	EstimateDebtsExport(ctx context.Context, selected []string, filter repository.DebtsFilter) (service.ExportEstimate, error)
+	StartAgingReport(
+		ctx context.Context,
+		filter repository.DebtsFilter,
+		groupBy repository.DebtsGroupBy,
+		opts service.ExportOptions,
 		userID int64,
 	) (string, error)
 }
@@ -25,6 +37,15 @@ type ActionExporter interface {
 		rctx context.Context,
 		selected []string,
 		filter repository.ActionsFilter,
+		opts service.ExportOptions,
+		userID int64,
+	) (string, error)
+	EstimateActionsExport(ctx context.Context, selected []string, filter repository.ActionsFilter) (service.ExportEstimate, error)
+	StartActionsDailyReport(
+		ctx context.Context,
+		filter repository.ActionsFilter,
+		groupBy repository.ActionsGroupBy,
+		opts service.ExportOptions,
 		userID int64,
 	) (string, error)
 }
@@ -33,12 +54,15 @@ type UserExporter interface {
 	StartUsersExport(
 		rctx context.Context,
 		selected []string,
+		opts service.ExportOptions,
 		userID int64,
 	) (string, error)
+	EstimateUsersExport(ctx context.Context, selected []string) (service.ExportEstimate, error)
 }
 
 type PaymentExporter interface {
-	StartPaymentsExport(ctx context.Context, selected []string, filter repository.PaymentsFilter, userID int64) (string, error)
+	StartPaymentsExport(ctx context.Context, selected []string, filter repository.PaymentsFilter, opts service.PaymentsExportOptions, userID int64) (string, error)
+	EstimatePaymentsExport(ctx context.Context, selected []string, filter repository.PaymentsFilter) (service.ExportEstimate, error)
 }
 
 type Handler struct {
@@ -47,6 +71,27 @@ type Handler struct {
 	actions    ActionExporter
 	payments   PaymentExporter
 	exportList ExportListService
+	batches    ExportBatches
+
+	limitByIP   RateLimiter
+	limitByUser RateLimiter
+	quota       QuotaChecker
+	disk        DiskSpaceChecker
+
+	notifySettings NotificationSettingsStore
+	messengers     map[string]bool
+
+	templates     TemplateAdmin
+	columnMasks   service.ColumnMasks
+	wsConnections WSConnections
+	wsHub         WSUpgrader
+	exportWatch   ExportWatcher
+	files         FileUploader
+	uploads       UploadRegistry
+	archive       ArchiveRestorer
+	exportAdmin   ExportAdmin
+	maintenance   MaintenanceSwitch
+	forwardedURLs bool
 }
 
 func NewHandler(debts DebtExporter, users UserExporter, actions ActionExporter, payments PaymentExporter, exportList ExportListService) *Handler {
@@ -59,6 +104,24 @@ func NewHandler(debts DebtExporter, users UserExporter, actions ActionExporter,
 	}
 }
 
+// SetColumnMasks hides the columns of masks from the tokens with their
+// abilities in every export they start or estimate.
+func (h *Handler) SetColumnMasks(masks service.ColumnMasks) {
+	h.columnMasks = masks
+}
+
+// maskColumns passes the columns masked for the token on to the services.
+func (h *Handler) maskColumns(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.columnMasks) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hidden := h.columnMasks.Hidden(auth.GetAbilities(r.Context()))
+		next.ServeHTTP(w, r.WithContext(service.WithHiddenColumns(r.Context(), hidden)))
+	})
+}
+
 func (h *Handler) InitRouter() *chi.Mux {
 	return h.InitRouterWithAuth(nil)
 }
@@ -68,9 +131,9 @@ func (h *Handler) InitRouterWithAuth(authMiddleware func(http.Handler) http.Hand
 
 	r.Use(
 		middleware.RequestID,
-		middleware.RealIP,
-		middleware.Logger,
+		accessLog,
 		middleware.Recoverer,
+		reporting.Middleware,
 		middleware.Timeout(60*time.Second),
 	)
 
@@ -83,12 +146,71 @@ func (h *Handler) InitRouterWithAuth(authMiddleware func(http.Handler) http.Hand
 	})
 
 	r.Route("/export", func(r chi.Router) {
+		r.Use(h.maskColumns)
 		r.Get("/", h.listExports)
+		r.Get("/batch/{batch_id}", h.getExportBatch)
 		r.Get("/{export_id}", h.getExport)
-		r.Post("/debts", h.exportDebts)
-		r.Post("/users", h.exportUsers)
-		r.Post("/actions", h.exportActions)
-		r.Post("/payments", h.exportPayments)
+		r.Patch("/{export_id}", h.updateExport)
+		r.Post("/{export_id}/cancel", h.cancelExport)
+		r.Post("/{export_id}/refresh-url", h.refreshExportURL)
+
+		// endpoints that start exports hit the DB hard, so they are rate limited;
+		// new files also count against the storage quota
+		r.Group(func(r chi.Router) {
+			r.Use(h.checkMaintenance, h.rateLimit, h.checkQuota)
+			r.Post("/{export_id}/retry", h.retryExport)
+			r.Post("/debts", h.exportDebts)
+			r.Post("/users", h.exportUsers)
+			r.Post("/actions", h.exportActions)
+			r.Post("/actions/daily", h.exportActionsDaily)
+			r.Post("/payments", h.exportPayments)
+			r.Post("/batch", h.exportBatch)
+			r.Post("/reports/aging", h.exportAgingReport)
+		})
+
+		r.Post("/debts/estimate", h.estimateDebts)
+		r.Post("/users/estimate", h.estimateUsers)
+		r.Post("/actions/estimate", h.estimateActions)
+		r.Post("/payments/estimate", h.estimatePayments)
+	})
+
+	r.Post("/files/upload", h.uploadFile)
+	r.Get("/files", h.listUploads)
+	r.Delete("/files/*", h.deleteUpload)
+
+	r.Route("/me", func(r chi.Router) {
+		r.Get("/notifications", h.getNotificationSettings)
+		r.Put("/notifications", h.putNotificationSettings)
+	})
+
+	r.Route("/admin/templates", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Get("/", h.listTemplates)
+		r.Put("/{name}", h.putTemplate)
+		r.Delete("/{name}", h.deleteTemplate)
+	})
+
+	r.Route("/admin/exports", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Delete("/{export_id}", h.deleteExportAdmin)
+	})
+
+	r.Route("/admin/maintenance", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Get("/", h.getMaintenance)
+		r.Put("/", h.putMaintenance)
+		r.Delete("/", h.deleteMaintenance)
+	})
+
+	r.Route("/admin/archive", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Post("/restore/*", h.restoreArchived)
+	})
+
+	r.Route("/admin/ws", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Get("/connections", h.listWSConnections)
+		r.Delete("/connections/{user_id}", h.disconnectWSUser)
 	})
 
 	return r
@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"debtster-export/internal/service"
+	"debtster-export/internal/tenant"
+	"debtster-export/internal/transport/auth"
+)
+
+// maxMaintenanceMessageRunes bounds the message shown to callers.
+const maxMaintenanceMessageRunes = 500
+
+// MaintenanceSwitch pauses the acceptance of new exports. Implemented by
+// *service.MaintenanceSwitch.
+type MaintenanceSwitch interface {
+	Get(ctx context.Context) (*service.Maintenance, error)
+	Enable(ctx context.Context, message string, by int64) (*service.Maintenance, error)
+	Disable(ctx context.Context) error
+}
+
+// SetMaintenanceSwitch enables /admin/maintenance and the check of new exports.
+func (h *Handler) SetMaintenanceSwitch(m MaintenanceSwitch) {
+	h.maintenance = m
+}
+
+// checkMaintenance writes 503 with the maintenance message while new exports
+// are paused. Switch errors let the request through, like rate limiter errors.
+func (h *Handler) checkMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.maintenance == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		state, err := h.maintenance.Get(r.Context())
+		if err != nil {
+			log.Printf("[HTTP] maintenance check error: %v", err)
+		}
+		if state != nil {
+			ErrorWithCode(w, state.Message, CodeMaintenance, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type maintenanceState struct {
+	Enabled bool `json:"enabled"`
+	*service.Maintenance
+}
+
+// getMaintenance serves GET /admin/maintenance.
+func (h *Handler) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	if h.maintenance == nil {
+		ErrorNotFound(w, "maintenance mode is not configured")
+		return
+	}
+	state, err := h.maintenance.Get(r.Context())
+	if err != nil {
+		log.Printf("[HTTP] getMaintenance error: %v", err)
+		ErrorInternal(w, "failed to get maintenance mode")
+		return
+	}
+	Success(w, "", maintenanceState{Enabled: state != nil, Maintenance: state})
+}
+
+// putMaintenance serves PUT /admin/maintenance {"message": "..."}: new exports
+// are answered with 503 and the message until the mode is turned off.
+func (h *Handler) putMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.canSwitchMaintenance(w, r) {
+		return
+	}
+
+	var req struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		ErrorWithCode(w, "invalid JSON", CodeInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Message) > maxMaintenanceMessageRunes {
+		ErrorBadRequest(w, "message is too long")
+		return
+	}
+
+	userID, _ := auth.GetUserID(r.Context())
+	state, err := h.maintenance.Enable(r.Context(), req.Message, userID)
+	if err != nil {
+		log.Printf("[HTTP] putMaintenance error: %v", err)
+		ErrorInternal(w, "failed to enable maintenance mode")
+		return
+	}
+	log.Printf("[ADMIN] maintenance mode enabled by user %d", userID)
+	Success(w, "Приём экспортов приостановлен", maintenanceState{Enabled: true, Maintenance: state})
+}
+
+// deleteMaintenance serves DELETE /admin/maintenance.
+func (h *Handler) deleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !h.canSwitchMaintenance(w, r) {
+		return
+	}
+	if err := h.maintenance.Disable(r.Context()); err != nil {
+		log.Printf("[HTTP] deleteMaintenance error: %v", err)
+		ErrorInternal(w, "failed to disable maintenance mode")
+		return
+	}
+	userID, _ := auth.GetUserID(r.Context())
+	log.Printf("[ADMIN] maintenance mode disabled by user %d", userID)
+	Success(w, "Приём экспортов возобновлён", maintenanceState{Enabled: false})
+}
+
+// canSwitchMaintenance reports whether the caller may toggle the mode; it is
+// shared by all tenants, so admins bound to a tenant may not.
+func (h *Handler) canSwitchMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	if h.maintenance == nil {
+		ErrorNotFound(w, "maintenance mode is not configured")
+		return false
+	}
+	if _, ok := tenant.FromContext(r.Context()); ok {
+		ErrorForbidden(w, "maintenance mode is shared by all tenants")
+		return false
+	}
+	return true
+}
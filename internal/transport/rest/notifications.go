@@ -0,0 +1,195 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/transport/auth"
+)
+
+// NotificationSettingsStore persists the messengers users get export results in.
+// Implemented by *repository.NotificationSettingsRepository.
+type NotificationSettingsStore interface {
+	Get(ctx context.Context, userID int64) (domain.NotificationSettings, error)
+	Save(ctx context.Context, s domain.NotificationSettings) error
+}
+
+// Messengers users may opt into.
+const (
+	MessengerTelegram = "telegram"
+	MessengerSlack    = "slack"
+)
+
+// SetNotificationSettings enables /me/notifications; available lists the
+// messengers (telegram, slack) and the optional channels (email) configured on
+// the server, the others are rejected.
+func (h *Handler) SetNotificationSettings(store NotificationSettingsStore, available ...string) {
+	h.notifySettings = store
+	h.messengers = map[string]bool{}
+	for _, m := range available {
+		h.messengers[m] = true
+	}
+}
+
+type notificationSettingsResponse struct {
+	Events            []string `json:"events"`
+	Channels          []string `json:"channels"`
+	TelegramChatID    string   `json:"telegram_chat_id"`
+	SlackWebhookURL   string   `json:"slack_webhook_url"`
+	AvailableChannels []string `json:"available_channels"`
+	Available         []string `json:"available_messengers"`
+}
+
+// notificationSettingsRequest replaces all settings; omitted events or
+// channels reset them to the defaults, an empty list turns them all off.
+type notificationSettingsRequest struct {
+	Events          *[]string `json:"events"`
+	Channels        *[]string `json:"channels"`
+	TelegramChatID  string    `json:"telegram_chat_id"`
+	SlackWebhookURL string    `json:"slack_webhook_url"`
+}
+
+func (h *Handler) getNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	if h.notifySettings == nil {
+		ErrorNotFound(w, "notifications are not configured")
+		return
+	}
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	settings, err := h.notifySettings.Get(r.Context(), userID)
+	if err != nil {
+		log.Printf("[HTTP] getNotificationSettings error: %v", err)
+		ErrorInternal(w, "failed to load notification settings")
+		return
+	}
+
+	Success(w, "", h.notificationSettingsResponse(settings))
+}
+
+func (h *Handler) putNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	if h.notifySettings == nil {
+		ErrorNotFound(w, "notifications are not configured")
+		return
+	}
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	var req notificationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		ErrorWithCode(w, "invalid JSON", CodeInvalidJSON, http.StatusBadRequest)
+		return
+	}
+	settings := domain.NotificationSettings{
+		UserID:          userID,
+		TelegramChatID:  strings.TrimSpace(req.TelegramChatID),
+		SlackWebhookURL: strings.TrimSpace(req.SlackWebhookURL),
+	}
+	if req.Events != nil {
+		settings.Events = uniqueStrings(*req.Events)
+	}
+	if req.Channels != nil {
+		settings.Channels = uniqueStrings(*req.Channels)
+	}
+	if err := h.validateNotificationSettings(settings); err != nil {
+		errorRequest(w, err)
+		return
+	}
+
+	if err := h.notifySettings.Save(r.Context(), settings); err != nil {
+		log.Printf("[HTTP] putNotificationSettings error: %v", err)
+		ErrorInternal(w, "failed to save notification settings")
+		return
+	}
+
+	Success(w, "Настройки уведомлений сохранены", h.notificationSettingsResponse(settings))
+}
+
+func (h *Handler) notificationSettingsResponse(s domain.NotificationSettings) notificationSettingsResponse {
+	available := []string{}
+	for _, m := range []string{MessengerTelegram, MessengerSlack} {
+		if h.messengers[m] {
+			available = append(available, m)
+		}
+	}
+	return notificationSettingsResponse{
+		Events:            s.EffectiveEvents(),
+		Channels:          s.EffectiveChannels(),
+		TelegramChatID:    s.TelegramChatID,
+		SlackWebhookURL:   s.SlackWebhookURL,
+		AvailableChannels: h.availableChannels(),
+		Available:         available,
+	}
+}
+
+func (h *Handler) availableChannels() []string {
+	out := []string{}
+	for _, c := range domain.NotifyChannels {
+		if c != domain.NotifyChannelEmail || h.messengers[domain.NotifyChannelEmail] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// uniqueStrings drops duplicates keeping the order; the result is never nil.
+func uniqueStrings(list []string) []string {
+	out := []string{}
+	for _, v := range list {
+		if !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// telegramChatID is a numeric chat id or a @channel username.
+var telegramChatID = regexp.MustCompile(`^(-?[0-9]{1,20}|@[A-Za-z0-9_]{5,32})$`)
+
+func (h *Handler) validateNotificationSettings(s domain.NotificationSettings) error {
+	var v ValidationErrors
+	for _, e := range s.Events {
+		if !slices.Contains(domain.NotifyEvents, e) {
+			v.addf("events", "events must be a list of %s", strings.Join(domain.NotifyEvents, ", "))
+			break
+		}
+	}
+	available := h.availableChannels()
+	for _, c := range s.Channels {
+		if !slices.Contains(available, c) {
+			v.addf("channels", "channels must be a list of %s", strings.Join(available, ", "))
+			break
+		}
+	}
+	if s.TelegramChatID != "" {
+		if !h.messengers[MessengerTelegram] {
+			v.addf("telegram_chat_id", "telegram notifications are not available")
+		} else if !telegramChatID.MatchString(s.TelegramChatID) {
+			v.addf("telegram_chat_id", "telegram_chat_id must be a chat id or @username")
+		}
+	}
+	if s.SlackWebhookURL != "" {
+		// only Slack itself: the service must not be usable to POST to arbitrary hosts
+		u, err := url.Parse(s.SlackWebhookURL)
+		if !h.messengers[MessengerSlack] {
+			v.addf("slack_webhook_url", "slack notifications are not available")
+		} else if err != nil || u.Scheme != "https" || u.Host != "hooks.slack.com" || !strings.HasPrefix(u.Path, "/services/") {
+			v.addf("slack_webhook_url", "slack_webhook_url must be a https://hooks.slack.com/services/... webhook")
+		}
+	}
+	return v.Err()
+}
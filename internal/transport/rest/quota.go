@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"debtster-export/internal/transport/auth"
+)
+
+// QuotaChecker reports whether a user has used up the storage quota.
+type QuotaChecker interface {
+	QuotaExceeded(ctx context.Context, userID int64) (bool, error)
+}
+
+// DiskSpaceChecker reports whether the export storage is short of disk space;
+// implemented by *clients.DiskWatchdog.
+type DiskSpaceChecker interface {
+	Low() bool
+}
+
+// SetDiskSpaceChecker rejects new exports while the storage is short of disk space.
+func (h *Handler) SetDiskSpaceChecker(d DiskSpaceChecker) {
+	h.disk = d
+}
+
+// SetQuotaChecker rejects new exports of users over their storage quota; nil disables the check.
+func (h *Handler) SetQuotaChecker(q QuotaChecker) {
+	h.quota = q
+}
+
+// checkQuota writes 507 when the storage disk or the user has no storage
+// left. Checker errors let the request through, like rate limiter errors.
+func (h *Handler) checkQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.disk != nil && h.disk.Low() {
+			ErrorWithCode(w, "export storage is out of disk space, try again later", CodeStorageUnavailable, http.StatusInsufficientStorage)
+			return
+		}
+		if h.quota == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := auth.GetUserID(r.Context())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		exceeded, err := h.quota.QuotaExceeded(r.Context(), userID)
+		if err != nil {
+			log.Printf("[HTTP] storage quota error: %v", err)
+		}
+		if exceeded {
+			ErrorWithCode(w, "storage quota exceeded, delete or download old exports first", CodeQuotaExceeded, http.StatusInsufficientStorage)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package rest
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"debtster-export/internal/transport/auth"
+)
+
+// RateLimiter takes one request token for key.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// SetRateLimiters limits export-start endpoints per client IP and per
+// authenticated user; either limiter may be nil.
+func (h *Handler) SetRateLimiters(byIP, byUser RateLimiter) {
+	h.limitByIP = byIP
+	h.limitByUser = byUser
+}
+
+func (h *Handler) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if h.limitByIP != nil {
+			if !h.takeToken(w, r, h.limitByIP, clientIP(r)) {
+				return
+			}
+		}
+
+		if h.limitByUser != nil {
+			if userID, err := auth.GetUserID(ctx); err == nil {
+				if !h.takeToken(w, r, h.limitByUser, strconv.FormatInt(userID, 10)) {
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeToken writes 429 and returns false when key is over the limit. Limiter
+// errors let the request through: Redis trouble must not block exports.
+func (h *Handler) takeToken(w http.ResponseWriter, r *http.Request, l RateLimiter, key string) bool {
+	ok, wait, err := l.Allow(r.Context(), key)
+	if err != nil {
+		log.Printf("[HTTP] rate limiter error: %v", err)
+		return true
+	}
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	ErrorTooManyRequests(w, "too many export requests, try again later")
+	return false
+}
+
+// clientIP relies on httpmw.TrustedProxies.RealIP having put the address of
+// the client behind a trusted proxy into RemoteAddr.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
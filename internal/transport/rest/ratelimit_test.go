@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"debtster-export/internal/transport/auth"
+)
+
+// bucketLimiter is the token bucket of clients.RateLimiter kept in memory,
+// with its own clock.
+type bucketLimiter struct {
+	mu        sync.Mutex
+	perMinute float64
+	burst     float64
+	now       time.Time
+	buckets   map[string]*bucket
+	keys      []string
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+func newBucketLimiter(perMinute, burst int) *bucketLimiter {
+	return &bucketLimiter{perMinute: float64(perMinute), burst: float64(burst), now: time.Unix(1_700_000_000, 0), buckets: map[string]*bucket{}}
+}
+
+func (l *bucketLimiter) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.keys = append(l.keys, key)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, at: l.now}
+		l.buckets[key] = b
+	}
+	rate := l.perMinute / time.Minute.Seconds()
+	b.tokens = math.Min(l.burst, b.tokens+l.now.Sub(b.at).Seconds()*rate)
+	b.at = l.now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+}
+
+func (l *bucketLimiter) advance(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = l.now.Add(d)
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (bool, time.Duration, error) {
+	return false, 0, errors.New("redis: connection refused")
+}
+
+func TestRateLimit(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil)
+	// 2 запроса в минуту на пользователя, без запаса сверх этого
+	byUser := newBucketLimiter(2, 2)
+	h.SetRateLimiters(nil, byUser)
+	handler := h.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	call := func(userID int64, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/export/debts", nil)
+		req.RemoteAddr = remote
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 2 {
+		if rec := call(7, "10.0.0.1:1000"); rec.Code != http.StatusAccepted {
+			t.Fatalf("request %d: %d", i, rec.Code)
+		}
+	}
+	// третий запрос того же пользователя с другого адреса — отказ
+	rec := call(7, "10.0.0.2:1000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: %d", rec.Code)
+	}
+	// токен восстанавливается за 30 секунд
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Fatalf("Retry-After = %q, want 30", got)
+	}
+
+	// лимит свой у каждого пользователя, анонимные запросы его не тратят
+	if rec := call(8, "10.0.0.1:1000"); rec.Code != http.StatusAccepted {
+		t.Fatalf("other user: %d", rec.Code)
+	}
+	if rec := call(0, "10.0.0.1:1000"); rec.Code != http.StatusAccepted {
+		t.Fatalf("anonymous: %d", rec.Code)
+	}
+
+	// Retry-After округляется вверх до целых секунд
+	byUser.advance(29500 * time.Millisecond)
+	rec = call(7, "10.0.0.1:1000")
+	if got := rec.Header().Get("Retry-After"); rec.Code != http.StatusTooManyRequests || got != "1" {
+		t.Fatalf("half a second before the refill: %d, Retry-After %q", rec.Code, got)
+	}
+
+	// через минуту окно восстанавливается полностью
+	byUser.advance(time.Minute)
+	for i := range 2 {
+		if rec := call(7, "10.0.0.1:1000"); rec.Code != http.StatusAccepted {
+			t.Fatalf("after the refill, request %d: %d", i, rec.Code)
+		}
+	}
+	if rec := call(7, "10.0.0.1:1000"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("the refill exceeded the burst: %d", rec.Code)
+	}
+
+	for _, key := range byUser.keys {
+		if key != "7" && key != "8" {
+			t.Fatalf("limiter keys = %q", byUser.keys)
+		}
+	}
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	h := NewHandler(nil, nil, nil, nil, nil)
+	byIP := newBucketLimiter(1, 1)
+	h.SetRateLimiters(byIP, failingLimiter{})
+	handler := h.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	call := func(remote string) int {
+		req := httptest.NewRequest(http.MethodPost, "/export/debts", nil)
+		req.RemoteAddr = remote
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, int64(7)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// ошибка лимитера пользователей пропускает запрос
+	if code := call("10.0.0.1:1000"); code != http.StatusAccepted {
+		t.Fatalf("first request: %d", code)
+	}
+	if code := call("10.0.0.1:2000"); code != http.StatusTooManyRequests {
+		t.Fatalf("same ip: %d", code)
+	}
+	if code := call("10.0.0.2:1000"); code != http.StatusAccepted {
+		t.Fatalf("other ip: %d", code)
+	}
+	if byIP.keys[0] != "10.0.0.1" || byIP.keys[1] != "10.0.0.1" {
+		t.Fatalf("limiter keys = %q", byIP.keys)
+	}
+}
@@ -7,10 +7,16 @@ import (
 )
 
 type APIResponse struct {
-	ErrorCode int         `json:"error_code"`
-	Status    string      `json:"status"`
-	Message   string      `json:"message"`
-	Data      interface{} `json:"data"`
+	ErrorCode int `json:"error_code"`
+	// Code is the reason of an error from the catalog in codes.go; ErrorCode
+	// stays the HTTP status for older clients
+	Code    string      `json:"code,omitempty"`
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+	// Errors are the messages of invalid fields keyed by field, as in Laravel
+	// validation responses; only set with 422
+	Errors map[string][]string `json:"errors,omitempty"`
 }
 
 func Response(w http.ResponseWriter, message string, data interface{}, errorCode int, status string, httpStatus int) {
@@ -38,7 +44,22 @@ func SuccessAccepted(w http.ResponseWriter, message string, data interface{}) {
 }
 
 func Error(w http.ResponseWriter, message string, errorCode int, httpStatus int) {
-	Response(w, message, nil, errorCode, "error", httpStatus)
+	ErrorWithCode(w, message, statusCode(httpStatus), httpStatus)
+}
+
+// ErrorWithCode answers an error with its code from the catalog; error_code
+// is the HTTP status.
+func ErrorWithCode(w http.ResponseWriter, message string, code string, httpStatus int) {
+	writeError(w, APIResponse{ErrorCode: httpStatus, Code: code, Status: "error", Message: message}, httpStatus)
+}
+
+func writeError(w http.ResponseWriter, response APIResponse, httpStatus int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("[HTTP] write response error: %v", err)
+	}
 }
 
 func ErrorBadRequest(w http.ResponseWriter, message string) {
@@ -49,10 +70,46 @@ func ErrorUnauthorized(w http.ResponseWriter, message string) {
 	Error(w, message, 401, http.StatusUnauthorized)
 }
 
+func ErrorForbidden(w http.ResponseWriter, message string) {
+	Error(w, message, 403, http.StatusForbidden)
+}
+
 func ErrorNotFound(w http.ResponseWriter, message string) {
 	Error(w, message, 404, http.StatusNotFound)
 }
 
+func ErrorConflict(w http.ResponseWriter, message string) {
+	Error(w, message, 409, http.StatusConflict)
+}
+
+func ErrorGone(w http.ResponseWriter, message string) {
+	Error(w, message, 410, http.StatusGone)
+}
+
+func ErrorTooManyRequests(w http.ResponseWriter, message string) {
+	Error(w, message, 429, http.StatusTooManyRequests)
+}
+
+func ErrorInsufficientStorage(w http.ResponseWriter, message string) {
+	Error(w, message, 507, http.StatusInsufficientStorage)
+}
+
+func ErrorServiceUnavailable(w http.ResponseWriter, message string) {
+	Error(w, message, 503, http.StatusServiceUnavailable)
+}
+
+// ErrorValidation answers 422 with every field error of a request; the
+// message is the first of them.
+func ErrorValidation(w http.ResponseWriter, errs *ValidationErrors) {
+	writeError(w, APIResponse{
+		ErrorCode: 422,
+		Code:      CodeInvalidField,
+		Status:    "error",
+		Message:   errs.Error(),
+		Errors:    errs.Fields(),
+	}, http.StatusUnprocessableEntity)
+}
+
 func ErrorInternal(w http.ResponseWriter, message string) {
 	Error(w, message, 500, http.StatusInternalServerError)
 }
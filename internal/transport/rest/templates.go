@@ -0,0 +1,135 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"debtster-export/internal/domain"
+	"debtster-export/internal/service"
+	"debtster-export/internal/transport/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AbilityExportAdmin is the token ability required to manage templates.
+const AbilityExportAdmin = "export:admin"
+
+// maxTemplateBytes limits an uploaded template.
+const maxTemplateBytes = 5 << 20
+
+// TemplateAdmin manages the XLSX templates exports can be rendered into.
+// Implemented by *clients.TemplateStorage.
+type TemplateAdmin interface {
+	List(ctx context.Context) ([]domain.ExportTemplate, error)
+	Put(ctx context.Context, name string, data []byte, uploadedBy int64) error
+	Delete(ctx context.Context, name string) error
+}
+
+// SetTemplateAdmin enables /admin/templates.
+func (h *Handler) SetTemplateAdmin(templates TemplateAdmin) {
+	h.templates = templates
+}
+
+// requireAdmin lets through tokens with AbilityExportAdmin only.
+func (h *Handler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := auth.GetUserID(r.Context()); err != nil {
+			ErrorUnauthorized(w, "Unauthorized")
+			return
+		}
+		if !auth.HasAbility(r.Context(), AbilityExportAdmin) {
+			ErrorForbidden(w, "token lacks the "+AbilityExportAdmin+" ability")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) listTemplates(w http.ResponseWriter, r *http.Request) {
+	if h.templates == nil {
+		ErrorNotFound(w, "templates are not configured")
+		return
+	}
+
+	list, err := h.templates.List(r.Context())
+	if err != nil {
+		log.Printf("[HTTP] listTemplates error: %v", err)
+		ErrorInternal(w, "failed to list templates")
+		return
+	}
+
+	Success(w, "", list)
+}
+
+// putTemplate stores the request body, an XLSX workbook, as the template name.
+func (h *Handler) putTemplate(w http.ResponseWriter, r *http.Request) {
+	if h.templates == nil {
+		ErrorNotFound(w, "templates are not configured")
+		return
+	}
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		ErrorUnauthorized(w, "Unauthorized")
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if name == "" {
+		ErrorBadRequest(w, "template name is required")
+		return
+	}
+	if err := validateTemplateName(name); err != nil {
+		ErrorBadRequest(w, err.Error())
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxTemplateBytes+1))
+	if err != nil {
+		ErrorBadRequest(w, "failed to read body")
+		return
+	}
+	if len(data) > maxTemplateBytes {
+		ErrorBadRequest(w, "template is larger than 5 MB")
+		return
+	}
+	if err := service.ValidateTemplate(data); err != nil {
+		ErrorBadRequest(w, err.Error())
+		return
+	}
+
+	if err := h.templates.Put(r.Context(), name, data, userID); err != nil {
+		log.Printf("[HTTP] putTemplate error: %v", err)
+		ErrorInternal(w, "failed to save template")
+		return
+	}
+
+	Success(w, "Шаблон сохранён", map[string]any{"name": name, "size": len(data)})
+}
+
+func (h *Handler) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if h.templates == nil {
+		ErrorNotFound(w, "templates are not configured")
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if err := validateTemplateName(name); err != nil || name == "" {
+		ErrorNotFound(w, "template not found")
+		return
+	}
+
+	if err := h.templates.Delete(r.Context(), name); err != nil {
+		if errors.Is(err, domain.ErrTemplateNotFound) {
+			ErrorNotFound(w, "template not found")
+			return
+		}
+		log.Printf("[HTTP] deleteTemplate error: %v", err)
+		ErrorInternal(w, "failed to delete template")
+		return
+	}
+
+	Success(w, "Шаблон удалён", nil)
+}
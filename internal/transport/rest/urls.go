@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"net/http"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/service"
+)
+
+// SetForwardedURLs makes the relative file links of responses absolute with
+// the scheme and host the client addressed, so clients behind a reverse
+// proxy get working links without EXTERNAL_URL.
+func (h *Handler) SetForwardedURLs(on bool) {
+	h.forwardedURLs = on
+}
+
+// fileURL returns link as the client of r should follow it.
+func (h *Handler) fileURL(r *http.Request, link string) string {
+	if !h.forwardedURLs {
+		return link
+	}
+	return clients.AbsoluteURL(r, link)
+}
+
+// exportFileURL rewrites the file_url of an export as returned by
+// ExportListService.
+func (h *Handler) exportFileURL(r *http.Request, export interface{}) {
+	m, ok := export.(map[string]interface{})
+	if !ok || !h.forwardedURLs {
+		return
+	}
+	if link, ok := m["file_url"].(*string); ok && link != nil {
+		url := h.fileURL(r, *link)
+		m["file_url"] = &url
+	}
+}
+
+// batchFileURLs returns batch with the file links of its exports rewritten;
+// the record itself is left as the service returned it.
+func (h *Handler) batchFileURLs(r *http.Request, batch *service.ExportBatchStatus) *service.ExportBatchStatus {
+	if !h.forwardedURLs {
+		return batch
+	}
+	out := *batch
+	out.Exports = append(out.Exports[:0:0], batch.Exports...)
+	for i := range out.Exports {
+		out.Exports[i].FileURL = h.fileURL(r, out.Exports[i].FileURL)
+	}
+	return &out
+}
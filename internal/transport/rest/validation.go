@@ -1,76 +1,229 @@
 package rest
 
 import (
+	"debtster-export/internal/expr"
+	"debtster-export/internal/filter"
 	"debtster-export/internal/repository"
+	"debtster-export/internal/service"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"regexp"
 	"strconv"
-	"time"
+	"strings"
+	"unicode/utf8"
 )
 
 type ExportRequest struct {
-	Fields         []string `json:"fields"`
-	RegistryID     *string  `json:"registry_id,omitempty"`
-	CounterpartyID *string  `json:"counterparty_id,omitempty"`
-	DepartmentID   *string  `json:"department_id,omitempty"`
-	StatusID       *int64   `json:"status_id,omitempty"`
-	UserID         *int64   `json:"user_id,omitempty"`
+	Fields []string               `json:"fields"`
+	Filter repository.DebtsFilter `json:"-"`
+
+	IncludeGuarantors bool                     `json:"include_guarantors,omitempty"`
+	SplitBy           string                   `json:"split_by,omitempty"`
+	Filename          string                   `json:"filename,omitempty"`
+	SingleUse         bool                     `json:"single_use,omitempty"`
+	Delivery          *service.DeliveryOptions `json:"delivery,omitempty"`
+	Comment           string                   `json:"comment,omitempty"`
+	Computed          []service.ComputedColumn `json:"computed,omitempty"`
+	Template          string                   `json:"template,omitempty"`
+	URLTTLHours       int                      `json:"url_ttl_hours,omitempty"`
+	OutputProfile     string                   `json:"output_profile,omitempty"`
+	Format            string                   `json:"format,omitempty"`
+	CSV               *service.CSVDialect      `json:"csv,omitempty"`
 }
 
+// rawExportRequest is the body of a debts export without the filters, which
+// repository.DebtsFilters parses.
 type rawExportRequest struct {
-	Fields         []string    `json:"fields"`
-	RegistryID     interface{} `json:"registry_id"`
-	CounterpartyID interface{} `json:"counterparty_id"`
-	DepartmentID   interface{} `json:"department_id"`
-	StatusID       interface{} `json:"status_id"`
-	UserID         interface{} `json:"user_id"`
+	Fields []string `json:"fields"`
+
+	IncludeGuarantors interface{} `json:"include_guarantors"`
+	SplitBy           interface{} `json:"split_by"`
+	Filename          interface{} `json:"filename"`
+	SingleUse         interface{} `json:"single_use"`
+	Delivery          interface{} `json:"delivery"`
+	Comment           interface{} `json:"comment"`
+	Computed          interface{} `json:"computed"`
+	Template          interface{} `json:"template"`
+	URLTTLHours       interface{} `json:"url_ttl_hours"`
+	OutputProfile     interface{} `json:"output_profile"`
+	Format            interface{} `json:"format"`
+	CSV               interface{} `json:"csv"`
+}
+
+// decodeRequest decodes a request body into raw and also returns its
+// top-level values, which the filters are parsed from. An empty body is an
+// empty request.
+func decodeRequest(body io.Reader, raw interface{}) (map[string]interface{}, error) {
+	var data json.RawMessage
+	if err := json.NewDecoder(body).Decode(&data); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, raw); err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
 }
 
 func ValidateExportRequest(r *http.Request) (*ExportRequest, error) {
-	var raw rawExportRequest
+	return parseExportRequest(r.Body)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil && err != io.EOF {
+func parseExportRequest(body io.Reader) (*ExportRequest, error) {
+	var raw rawExportRequest
+	values, err := decodeRequest(body, &raw)
+	if err != nil {
 		return nil, err
 	}
 
+	var v ValidationErrors
 	if len(raw.Fields) == 0 {
-		return nil, &ValidationError{Field: "fields", Message: "fields is required and must be an array"}
+		v.addf("fields", "fields is required and must be an array")
 	}
-
-	registryID, err := toStringPtr(raw.RegistryID)
-	if err != nil {
-		return nil, &ValidationError{Field: "registry_id", Message: "registry_id must be string or empty"}
+	v.add(checkFields(service.UnknownDebtColumns(raw.Fields)))
+	req, err := raw.toRequest(values)
+	v.add(err)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
+	return req, nil
+}
 
-	counterpartyID, err := toStringPtr(raw.CounterpartyID)
-	if err != nil {
-		return nil, &ValidationError{Field: "counterparty_id", Message: "counterparty_id must be string or empty"}
+// toRequest validates everything but fields, which reports do not have. The
+// request is returned along with the errors, so callers can add their own.
+func (raw *rawExportRequest) toRequest(values map[string]interface{}) (*ExportRequest, error) {
+	var v ValidationErrors
+
+	filters, errs := repository.DebtsFilters.Parse(values)
+	v.addFilters(errs)
+
+	includeGuarantors, err := toBool(raw.IncludeGuarantors)
+	v.check(err, "include_guarantors", "include_guarantors must be boolean or empty")
+
+	splitBy, ok := raw.SplitBy.(string)
+	if (raw.SplitBy != nil && !ok) || (splitBy != "" && splitBy != service.DebtsSplitByCounterparty) {
+		v.addf("split_by", "split_by must be counterparty or empty")
 	}
 
-	departmentID, err := toStringPtr(raw.DepartmentID)
+	filename, err := toFilename(raw.Filename)
+	v.add(err)
+
+	singleUse, err := toBool(raw.SingleUse)
+	v.check(err, "single_use", "single_use must be boolean or empty")
+
+	delivery, err := toDelivery(raw.Delivery)
+	v.add(err)
+
+	comment, err := toComment(raw.Comment)
+	v.add(err)
+
+	computed, err := toComputed(raw.Computed)
+	v.add(err)
+
+	template, err := toTemplate(raw.Template)
+	v.add(err)
+
+	urlTTL, err := toURLTTL(raw.URLTTLHours)
+	v.add(err)
+
+	outputProfile, err := toOutputProfile(raw.OutputProfile)
+	v.add(err)
+
+	format, csvDialect, err := toFormat(raw.Format, raw.CSV)
+	v.add(err)
+
+	return &ExportRequest{
+		Fields:            raw.Fields,
+		Filter:            filters,
+		IncludeGuarantors: includeGuarantors,
+		SplitBy:           splitBy,
+		Filename:          filename,
+		SingleUse:         singleUse,
+		Delivery:          delivery,
+		Comment:           comment,
+		Computed:          computed,
+		Template:          template,
+		URLTTLHours:       urlTTL,
+		OutputProfile:     outputProfile,
+		Format:            format,
+		CSV:               csvDialect,
+	}, v.Err()
+}
+
+// AgingReportRequest is the body of POST /export/reports/aging: the filters
+// of the debts export and the grouping of the report rows.
+type AgingReportRequest struct {
+	ExportRequest
+	GroupBy repository.DebtsGroupBy
+}
+
+type rawAgingReportRequest struct {
+	rawExportRequest
+	GroupBy interface{} `json:"group_by"`
+}
+
+func ValidateAgingReportRequest(r *http.Request) (*AgingReportRequest, error) {
+	return parseAgingReportRequest(r.Body)
+}
+
+func parseAgingReportRequest(body io.Reader) (*AgingReportRequest, error) {
+	var raw rawAgingReportRequest
+	values, err := decodeRequest(body, &raw)
 	if err != nil {
-		return nil, &ValidationError{Field: "department_id", Message: "department_id must be string/number or empty"}
+		return nil, err
 	}
 
-	statusID, err := toInt64Ptr(raw.StatusID)
-	if err != nil {
-		return nil, &ValidationError{Field: "status_id", Message: "status_id must be integer or empty"}
+	var v ValidationErrors
+	req, err := raw.toRequest(values)
+	v.add(err)
+	if req.IncludeGuarantors {
+		v.addf("include_guarantors", "include_guarantors is not supported by reports")
+	}
+	if req.SplitBy != "" {
+		v.addf("split_by", "split_by is not supported by reports")
+	}
+	if len(req.Computed) > 0 {
+		v.addf("computed", "computed is not supported by reports")
+	}
+	if req.Template != "" {
+		v.addf("template", "template is not supported by reports")
+	}
+	if req.OutputProfile != "" {
+		v.addf("output_profile", "output_profile is not supported by reports")
+	}
+	if req.Format != "" || req.CSV != nil {
+		v.addf("format", "format is not supported by reports")
 	}
 
-	userID, err := toInt64Ptr(raw.UserID)
-	if err != nil {
-		return nil, &ValidationError{Field: "user_id", Message: "user_id must be integer or empty"}
+	groupBy := repository.DebtsGroupByCounterparty
+	switch g := raw.GroupBy.(type) {
+	case nil:
+	case string:
+		switch repository.DebtsGroupBy(g) {
+		case repository.DebtsGroupByCounterparty, repository.DebtsGroupByDepartment:
+			groupBy = repository.DebtsGroupBy(g)
+		case "":
+		default:
+			v.addf("group_by", "group_by must be counterparty, department or empty")
+		}
+	default:
+		v.addf("group_by", "group_by must be counterparty, department or empty")
 	}
 
-	return &ExportRequest{
-		Fields:         raw.Fields,
-		RegistryID:     registryID,
-		CounterpartyID: counterpartyID,
-		DepartmentID:   departmentID,
-		StatusID:       statusID,
-		UserID:         userID,
-	}, nil
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return &AgingReportRequest{ExportRequest: *req, GroupBy: groupBy}, nil
 }
 
 type ValidationError struct {
@@ -82,52 +235,116 @@ func (e *ValidationError) Error() string {
 	return e.Message
 }
 
-type DebtsFilter struct {
-	RegistryID     string
-	CounterpartyID string
-	DepartmentID   string
-	StatusID       *int64
-	UserID         *int64
+// ValidationErrors collects every field error of a request, so a client sees
+// all of them in one response; the zero value is ready to use.
+type ValidationErrors struct {
+	list []*ValidationError
+}
+
+// add records err: a *ValidationError as it is, the errors of a nested
+// ValidationErrors one by one; nil is ignored.
+func (e *ValidationErrors) add(err error) {
+	var nested *ValidationErrors
+	var field *ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &nested):
+		e.list = append(e.list, nested.list...)
+	case errors.As(err, &field):
+		e.list = append(e.list, field)
+	default:
+		e.list = append(e.list, &ValidationError{Message: err.Error()})
+	}
+}
+
+// check records message for field when err is not nil, for parsers whose own
+// errors do not name the field.
+func (e *ValidationErrors) check(err error, field, message string) {
+	if err != nil {
+		e.addf(field, "%s", message)
+	}
 }
 
-func (r *ExportRequest) ToDebtsFilter() DebtsFilter {
-	f := DebtsFilter{}
+func (e *ValidationErrors) addf(field, format string, args ...interface{}) {
+	e.list = append(e.list, &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
 
-	if r.RegistryID != nil && *r.RegistryID != "" {
-		f.RegistryID = *r.RegistryID
+// addFilters records the invalid filter values of a request.
+func (e *ValidationErrors) addFilters(errs []*filter.Error) {
+	for _, err := range errs {
+		e.addf(err.Field, "%s", err.Message)
 	}
-	if r.CounterpartyID != nil && *r.CounterpartyID != "" {
-		f.CounterpartyID = *r.CounterpartyID
+}
+
+// prefixed returns the errors with prefix put before their fields, e.g.
+// "exports.1." for the second export of a batch.
+func (e *ValidationErrors) prefixed(prefix string) *ValidationErrors {
+	out := &ValidationErrors{list: make([]*ValidationError, len(e.list))}
+	for i, err := range e.list {
+		out.list[i] = &ValidationError{Field: prefix + err.Field, Message: err.Message}
 	}
-	if r.DepartmentID != nil && *r.DepartmentID != "" {
-		f.DepartmentID = *r.DepartmentID
+	return out
+}
+
+// Err returns e as an error, nil when nothing was recorded.
+func (e *ValidationErrors) Err() error {
+	if len(e.list) == 0 {
+		return nil
 	}
-	if r.StatusID != nil {
-		f.StatusID = r.StatusID
+	return e
+}
+
+// Error is the first message, with the count of the others as Laravel puts it.
+func (e *ValidationErrors) Error() string {
+	if len(e.list) == 0 {
+		return "invalid request"
 	}
-	if r.UserID != nil {
-		f.UserID = r.UserID
+	msg := e.list[0].Message
+	switch n := len(e.list) - 1; n {
+	case 0:
+		return msg
+	case 1:
+		return msg + " (and 1 more error)"
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", msg, n)
 	}
+}
 
-	return f
+// Fields returns the messages keyed by field in the order they were found.
+func (e *ValidationErrors) Fields() map[string][]string {
+	out := make(map[string][]string, len(e.list))
+	for _, err := range e.list {
+		out[err.Field] = append(out[err.Field], err.Message)
+	}
+	return out
 }
 
-func toStringPtr(v interface{}) (*string, error) {
-	switch t := v.(type) {
-	case nil:
-		return nil, nil
-	case string:
-		if t == "" {
-			return nil, nil
-		}
-		return &t, nil
-	case float64:
-		i := int64(t)
-		s := strconv.FormatInt(i, 10)
-		return &s, nil
-	default:
-		return nil, &ValidationError{Message: "invalid type for string field"}
+// asValidationErrors returns the field errors err carries, nil for other
+// errors such as malformed JSON.
+func asValidationErrors(err error) *ValidationErrors {
+	var list *ValidationErrors
+	if errors.As(err, &list) {
+		return list
+	}
+	var field *ValidationError
+	if errors.As(err, &field) {
+		return &ValidationErrors{list: []*ValidationError{field}}
+	}
+	return nil
+}
+
+// errorRequest answers a request body that failed to parse: 422 with all its
+// field errors, or 400 when it is not JSON of the expected shape.
+func errorRequest(w http.ResponseWriter, err error) {
+	if list := asValidationErrors(err); list != nil {
+		ErrorValidation(w, list)
+		return
 	}
+	ErrorWithCode(w, "invalid JSON", CodeInvalidJSON, http.StatusBadRequest)
+}
+
+func (r *ExportRequest) ToRepositoryFilter() repository.DebtsFilter {
+	return r.Filter
 }
 
 func toInt64Ptr(v interface{}) (*int64, error) {
@@ -135,6 +352,9 @@ func toInt64Ptr(v interface{}) (*int64, error) {
 	case nil:
 		return nil, nil
 	case float64:
+		if t != math.Trunc(t) {
+			return nil, &ValidationError{Message: "invalid value for int field"}
+		}
 		i := int64(t)
 		return &i, nil
 	case string:
@@ -151,149 +371,424 @@ func toInt64Ptr(v interface{}) (*int64, error) {
 	}
 }
 
-type ActionsExportRequest struct {
-	Fields []string `json:"fields"`
+func toBool(v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return t, nil
+	case float64:
+		return t != 0, nil
+	case string:
+		if t == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(t)
+	default:
+		return false, &ValidationError{Message: "invalid type for bool field"}
+	}
+}
 
-	CounterpartyID *string    `json:"-"`
-	StatusID       *int64     `json:"-"`
-	DebtStatusID   *int64     `json:"-"`
-	DepartmentID   *int64     `json:"-"`
-	TypeID         *string    `json:"-"`
-	UserID         *int64     `json:"-"`
-	CreateFrom     *time.Time `json:"-"`
-	CreateTo       *time.Time `json:"-"`
-	NextFrom       *time.Time `json:"-"`
-	NextTo         *time.Time `json:"-"`
+type ActionsExportRequest struct {
+	Fields []string                 `json:"fields"`
+	Filter repository.ActionsFilter `json:"-"`
+
+	Filename      string                   `json:"-"`
+	SingleUse     bool                     `json:"-"`
+	Delivery      *service.DeliveryOptions `json:"-"`
+	Comment       string                   `json:"-"`
+	Computed      []service.ComputedColumn `json:"-"`
+	Template      string                   `json:"-"`
+	URLTTLHours   int                      `json:"-"`
+	OutputProfile string                   `json:"-"`
+	Format        string                   `json:"-"`
+	CSV           *service.CSVDialect      `json:"-"`
 }
 
+// rawActionsExportRequest is the body of an actions export without the
+// filters, which repository.ActionsFilters parses.
 type rawActionsExportRequest struct {
 	Fields []string `json:"fields"`
 
-	CounterpartyID interface{} `json:"counterparty_id"`
-	StatusID       interface{} `json:"status_id"`
-	DebtStatusID   interface{} `json:"debt_status_id"`
-	DepartmentID   interface{} `json:"department_id"`
-	TypeID         interface{} `json:"type_id"`
-	UserID         interface{} `json:"user_id"`
-
-	CreateStartDate      interface{} `json:"create_start_date"`
-	CreateEndDate        interface{} `json:"create_end_date"`
-	NextContactStartDate interface{} `json:"next_contact_start_date"`
-	NextContactEndDate   interface{} `json:"next_contact_end_date"`
+	Filename      interface{} `json:"filename"`
+	SingleUse     interface{} `json:"single_use"`
+	Delivery      interface{} `json:"delivery"`
+	Comment       interface{} `json:"comment"`
+	Computed      interface{} `json:"computed"`
+	Template      interface{} `json:"template"`
+	URLTTLHours   interface{} `json:"url_ttl_hours"`
+	OutputProfile interface{} `json:"output_profile"`
+	Format        interface{} `json:"format"`
+	CSV           interface{} `json:"csv"`
 }
 
 func ValidateActionsExportRequest(r *http.Request) (*ActionsExportRequest, error) {
-	var raw rawActionsExportRequest
+	return parseActionsExportRequest(r.Body)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil && err != io.EOF {
+func parseActionsExportRequest(body io.Reader) (*ActionsExportRequest, error) {
+	var raw rawActionsExportRequest
+	values, err := decodeRequest(body, &raw)
+	if err != nil {
 		return nil, err
 	}
 
+	var v ValidationErrors
 	if len(raw.Fields) == 0 {
-		return nil, &ValidationError{Field: "fields", Message: "fields is required and must be an array"}
+		v.addf("fields", "fields is required and must be an array")
 	}
+	v.add(checkFields(service.UnknownActionColumns(raw.Fields)))
+	req, err := raw.toRequest(values)
+	v.add(err)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// toRequest validates everything but fields, which reports do not have. The
+// request is returned along with the errors, so callers can add their own.
+func (raw *rawActionsExportRequest) toRequest(values map[string]interface{}) (*ActionsExportRequest, error) {
+	var v ValidationErrors
+
+	filters, errs := repository.ActionsFilters.Parse(values)
+	v.addFilters(errs)
+
+	filename, err := toFilename(raw.Filename)
+	v.add(err)
+
+	singleUse, err := toBool(raw.SingleUse)
+	v.check(err, "single_use", "single_use must be boolean or empty")
 
-	counterpartyID, err := toStringPtr(raw.CounterpartyID)
+	delivery, err := toDelivery(raw.Delivery)
+	v.add(err)
+
+	comment, err := toComment(raw.Comment)
+	v.add(err)
+
+	computed, err := toComputed(raw.Computed)
+	v.add(err)
+
+	template, err := toTemplate(raw.Template)
+	v.add(err)
+
+	urlTTL, err := toURLTTL(raw.URLTTLHours)
+	v.add(err)
+
+	outputProfile, err := toOutputProfile(raw.OutputProfile)
+	v.add(err)
+
+	format, csvDialect, err := toFormat(raw.Format, raw.CSV)
+	v.add(err)
+
+	return &ActionsExportRequest{
+		Fields:        raw.Fields,
+		Filter:        filters,
+		Filename:      filename,
+		SingleUse:     singleUse,
+		Delivery:      delivery,
+		Comment:       comment,
+		Computed:      computed,
+		Template:      template,
+		URLTTLHours:   urlTTL,
+		OutputProfile: outputProfile,
+		Format:        format,
+		CSV:           csvDialect,
+	}, v.Err()
+}
+
+// ActionsDailyReportRequest is the body of POST /export/actions/daily: the
+// filters of the actions export and the grouping of the report columns.
+type ActionsDailyReportRequest struct {
+	ActionsExportRequest
+	GroupBy repository.ActionsGroupBy
+}
+
+type rawActionsDailyReportRequest struct {
+	rawActionsExportRequest
+	GroupBy interface{} `json:"group_by"`
+}
+
+func ValidateActionsDailyReportRequest(r *http.Request) (*ActionsDailyReportRequest, error) {
+	return parseActionsDailyReportRequest(r.Body)
+}
+
+func parseActionsDailyReportRequest(body io.Reader) (*ActionsDailyReportRequest, error) {
+	var raw rawActionsDailyReportRequest
+	values, err := decodeRequest(body, &raw)
 	if err != nil {
-		return nil, &ValidationError{Field: "counterparty_id", Message: "counterparty_id must be string or empty"}
+		return nil, err
 	}
 
-	statusID, err := toInt64Ptr(raw.StatusID)
-	if err != nil {
-		return nil, &ValidationError{Field: "status_id", Message: "status_id must be integer or empty"}
+	var v ValidationErrors
+	req, err := raw.toRequest(values)
+	v.add(err)
+	if req.Filter.LatestPerDebt {
+		v.addf("latest_per_debt", "latest_per_debt is not supported by reports")
+	}
+	if len(req.Computed) > 0 {
+		v.addf("computed", "computed is not supported by reports")
+	}
+	if req.Template != "" {
+		v.addf("template", "template is not supported by reports")
+	}
+	if req.OutputProfile != "" {
+		v.addf("output_profile", "output_profile is not supported by reports")
+	}
+	if req.Format != "" || req.CSV != nil {
+		v.addf("format", "format is not supported by reports")
 	}
 
-	debtStatusID, err := toInt64Ptr(raw.DebtStatusID)
-	if err != nil {
-		return nil, &ValidationError{Field: "debt_status_id", Message: "debt_status_id must be integer or empty"}
+	groupBy := repository.ActionsGroupByUser
+	switch g := raw.GroupBy.(type) {
+	case nil:
+	case string:
+		switch repository.ActionsGroupBy(g) {
+		case repository.ActionsGroupByUser, repository.ActionsGroupByType:
+			groupBy = repository.ActionsGroupBy(g)
+		case "":
+		default:
+			v.addf("group_by", "group_by must be user, type or empty")
+		}
+	default:
+		v.addf("group_by", "group_by must be user, type or empty")
 	}
 
-	departmentIDStr, err := toStringPtr(raw.DepartmentID)
-	if err != nil {
-		return nil, &ValidationError{Field: "department_id", Message: "department_id must be string/number or empty"}
-	}
-	var departmentID *int64
-	if departmentIDStr != nil && *departmentIDStr != "" {
-		if v, err := strconv.ParseInt(*departmentIDStr, 10, 64); err == nil {
-			departmentID = &v
-		} else {
-			return nil, &ValidationError{Field: "department_id", Message: "department_id must be integer or empty"}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return &ActionsDailyReportRequest{ActionsExportRequest: *req, GroupBy: groupBy}, nil
+}
+
+func (r *ActionsExportRequest) ToRepositoryFilter() repository.ActionsFilter {
+	return r.Filter
+}
+
+// checkFields reports the selected fields the export type does not have,
+// each with the known keys it was probably meant to be.
+func checkFields(unknown []service.UnknownColumn) error {
+	var v ValidationErrors
+	for _, c := range unknown {
+		if len(c.Suggestions) == 0 {
+			v.addf("fields", "unknown field %q", c.Key)
+			continue
 		}
+		quoted := make([]string, len(c.Suggestions))
+		for i, key := range c.Suggestions {
+			quoted[i] = strconv.Quote(key)
+		}
+		v.addf("fields", "unknown field %q, did you mean %s?", c.Key, strings.Join(quoted, " or "))
 	}
+	return v.Err()
+}
 
-	typeID, err := toStringPtr(raw.TypeID)
-	if err != nil {
-		return nil, &ValidationError{Field: "type_id", Message: "type_id must be string or empty"}
+// toFilename accepts an optional custom file name; sanitizing happens in the service.
+func toFilename(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if utf8.RuneCountInString(t) > 200 {
+			return "", &ValidationError{Field: "filename", Message: "filename must be at most 200 characters"}
+		}
+		return t, nil
+	default:
+		return "", &ValidationError{Field: "filename", Message: "filename must be string or empty"}
 	}
+}
 
-	userID, err := toInt64Ptr(raw.UserID)
-	if err != nil {
-		return nil, &ValidationError{Field: "user_id", Message: "user_id must be integer or empty"}
+// maxCommentRunes limits export comments; they are notes, not documents.
+const maxCommentRunes = 1000
+
+// toComment accepts an optional free-text comment of an export.
+func toComment(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		t = strings.TrimSpace(t)
+		if utf8.RuneCountInString(t) > maxCommentRunes {
+			return "", &ValidationError{Field: "comment", Message: fmt.Sprintf("comment must be at most %d characters", maxCommentRunes)}
+		}
+		return t, nil
+	default:
+		return "", &ValidationError{Field: "comment", Message: "comment must be string or empty"}
 	}
+}
 
-	createFrom, err := toDatePtr(raw.CreateStartDate)
-	if err != nil {
-		return nil, &ValidationError{Field: "create_start_date", Message: "create_start_date must be YYYY-MM-DD or empty"}
+// templateName matches the names templates are stored under.
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// toTemplate accepts an optional template name; whether it exists is checked by the service.
+func toTemplate(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, validateTemplateName(t)
+	default:
+		return "", &ValidationError{Field: "template", Message: "template must be string or empty"}
 	}
-	createTo, err := toDatePtr(raw.CreateEndDate)
-	if err != nil {
-		return nil, &ValidationError{Field: "create_end_date", Message: "create_end_date must be YYYY-MM-DD or empty"}
+}
+
+// toOutputProfile accepts an optional output profile name; whether it exists
+// is checked by the service.
+func toOutputProfile(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	default:
+		return "", &ValidationError{Field: "output_profile", Message: "output_profile must be string or empty"}
 	}
+}
 
-	nextFrom, err := toDatePtr(raw.NextContactStartDate)
-	if err != nil {
-		return nil, &ValidationError{Field: "next_contact_start_date", Message: "next_contact_start_date must be YYYY-MM-DD or empty"}
+// toFormat accepts an optional output format and the options of a csv
+// export; their values are checked by the service.
+func toFormat(format, dialect interface{}) (string, *service.CSVDialect, error) {
+	f, ok := format.(string)
+	if format != nil && !ok {
+		return "", nil, &ValidationError{Field: "format", Message: "format must be string or empty"}
 	}
-	nextTo, err := toDatePtr(raw.NextContactEndDate)
-	if err != nil {
-		return nil, &ValidationError{Field: "next_contact_end_date", Message: "next_contact_end_date must be YYYY-MM-DD or empty"}
+	if dialect == nil {
+		return f, nil, nil
+	}
+	obj, ok := dialect.(map[string]interface{})
+	if !ok {
+		return "", nil, &ValidationError{Field: "csv", Message: "csv must be an object or empty"}
 	}
 
-	return &ActionsExportRequest{
-		Fields:         raw.Fields,
-		CounterpartyID: counterpartyID,
-		StatusID:       statusID,
-		DebtStatusID:   debtStatusID,
-		DepartmentID:   departmentID,
-		TypeID:         typeID,
-		UserID:         userID,
-		CreateFrom:     createFrom,
-		CreateTo:       createTo,
-		NextFrom:       nextFrom,
-		NextTo:         nextTo,
-	}, nil
+	var d service.CSVDialect
+	for _, opt := range []struct {
+		field string
+		dst   *string
+	}{{"delimiter", &d.Delimiter}, {"quoting", &d.Quoting}, {"line_ending", &d.LineEnding}, {"null", &d.Null}} {
+		field, dst := opt.field, opt.dst
+		if v, ok := obj[field]; ok && v != nil {
+			s, ok := v.(string)
+			if !ok {
+				return "", nil, &ValidationError{Field: "csv." + field, Message: "csv." + field + " must be string"}
+			}
+			*dst = s
+		}
+	}
+	if v, ok := obj["bom"]; ok && v != nil {
+		bom, ok := v.(bool)
+		if !ok {
+			return "", nil, &ValidationError{Field: "csv.bom", Message: "csv.bom must be boolean"}
+		}
+		d.BOM = bom
+	}
+	return f, &d, nil
 }
 
-func (r *ActionsExportRequest) ToRepositoryFilter() repository.ActionsFilter {
-	f := repository.ActionsFilter{
-		CounterpartyID:  r.CounterpartyID,
-		DebtStatusID:    r.DebtStatusID,
-		DepartmentID:    r.DepartmentID,
-		TypeID:          r.TypeID,
-		UserID:          r.UserID,
-		CreatedFrom:     r.CreateFrom,
-		CreatedTo:       r.CreateTo,
-		NextContactFrom: r.NextFrom,
-		NextContactTo:   r.NextTo,
-	}
-	_ = r.StatusID
-	return f
-}
-
-func toDatePtr(v interface{}) (*time.Time, error) {
-	switch t := v.(type) {
-	case nil:
+func validateTemplateName(name string) error {
+	if name != "" && !templateName.MatchString(name) {
+		return &ValidationError{Field: "template", Message: "template must be a name of lowercase letters, digits, _ and - (up to 64 characters)"}
+	}
+	return nil
+}
+
+// maxURLTTLHours caps the lifetime of download links at a week, the limit of
+// S3 presigned URLs.
+const maxURLTTLHours = 7 * 24
+
+// toURLTTL accepts an optional lifetime of the download link in hours; 0
+// keeps the configured one.
+func toURLTTL(v interface{}) (int, error) {
+	hours, err := toInt64Ptr(v)
+	if err != nil || (hours != nil && (*hours < 0 || *hours > maxURLTTLHours)) {
+		return 0, urlTTLError()
+	}
+	if hours == nil {
+		return 0, nil
+	}
+	return int(*hours), nil
+}
+
+func validateURLTTL(hours int) error {
+	if hours < 0 || hours > maxURLTTLHours {
+		return urlTTLError()
+	}
+	return nil
+}
+
+func urlTTLError() error {
+	return &ValidationError{Field: "url_ttl_hours", Message: fmt.Sprintf("url_ttl_hours must be an integer from 0 to %d or empty", maxURLTTLHours)}
+}
+
+// maxComputedHeaderRunes limits the header of a computed column.
+const maxComputedHeaderRunes = 100
+
+// toComputed accepts an optional [{"header": "...", "expr": "..."}] list; whether
+// the referenced fields exist is checked by the service.
+func toComputed(v interface{}) ([]service.ComputedColumn, error) {
+	if v == nil {
 		return nil, nil
-	case string:
-		if t == "" {
-			return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, &ValidationError{Field: "computed", Message: "computed must be an array or empty"}
+	}
+	computed := make([]service.ComputedColumn, 0, len(list))
+	for i, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, &ValidationError{Field: "computed", Message: fmt.Sprintf("computed[%d] must be an object", i)}
 		}
-		parsed, err := time.Parse("2006-01-02", t)
-		if err != nil {
-			return nil, err
+		header, ok := obj["header"].(string)
+		if !ok && obj["header"] != nil {
+			return nil, &ValidationError{Field: "computed", Message: fmt.Sprintf("computed[%d].header must be string", i)}
 		}
-		return &parsed, nil
+		expression, _ := obj["expr"].(string)
+		computed = append(computed, service.ComputedColumn{Header: strings.TrimSpace(header), Expr: expression})
+	}
+	if err := validateComputed(computed); err != nil {
+		return nil, err
+	}
+	return computed, nil
+}
+
+func validateComputed(computed []service.ComputedColumn) error {
+	for i, c := range computed {
+		if utf8.RuneCountInString(c.Header) > maxComputedHeaderRunes {
+			return &ValidationError{Field: "computed", Message: fmt.Sprintf("computed[%d].header must be at most %d characters", i, maxComputedHeaderRunes)}
+		}
+		if _, err := expr.Parse(c.Expr); err != nil {
+			return &ValidationError{Field: "computed", Message: fmt.Sprintf("computed[%d].expr: %v", i, err)}
+		}
+	}
+	return nil
+}
+
+// toDelivery accepts an optional {"type": "sftp", "profile": "..."} object, the
+// type being sftp, clickhouse, bigquery or kafka; whether the profile exists is
+// checked by the service.
+func toDelivery(v interface{}) (*service.DeliveryOptions, error) {
+	if v == nil {
+		return nil, nil
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &ValidationError{Field: "delivery", Message: "delivery must be an object or empty"}
+	}
+	deliveryType, _ := obj["type"].(string)
+	profile, _ := obj["profile"].(string)
+	return validateDelivery(&service.DeliveryOptions{Type: deliveryType, Profile: profile})
+}
+
+func validateDelivery(d *service.DeliveryOptions) (*service.DeliveryOptions, error) {
+	if d == nil {
+		return nil, nil
+	}
+	switch d.Type {
+	case service.DeliveryTypeSFTP, service.DeliveryTypeClickHouse, service.DeliveryTypeBigQuery, service.DeliveryTypeKafka:
 	default:
-		return nil, &ValidationError{Message: "invalid type for date field"}
+		return nil, &ValidationError{Field: "delivery.type", Message: "delivery.type must be one of sftp, clickhouse, bigquery, kafka"}
+	}
+	if d.Profile == "" {
+		return nil, &ValidationError{Field: "delivery.profile", Message: "delivery.profile is required"}
 	}
+	return d, nil
 }
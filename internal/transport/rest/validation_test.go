@@ -0,0 +1,79 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseExportRequest_ReportsAllErrors(t *testing.T) {
+	body := `{"status_id": "x", "single_use": [], "url_ttl_hours": 1000, "template": "Bad Name", "split_by": "user"}`
+	_, err := parseExportRequest(strings.NewReader(body))
+	list := asValidationErrors(err)
+	if list == nil {
+		t.Fatalf("err = %v", err)
+	}
+
+	fields := list.Fields()
+	for _, field := range []string{"fields", "status_id", "single_use", "url_ttl_hours", "template", "split_by"} {
+		if len(fields[field]) == 0 {
+			t.Errorf("no error for %s: %v", field, fields)
+		}
+	}
+	if !strings.HasPrefix(list.Error(), "fields is required") || !strings.HasSuffix(list.Error(), "(and 5 more errors)") {
+		t.Fatalf("message = %q", list.Error())
+	}
+
+	// отчёт добавляет свои ошибки к ошибкам фильтров
+	_, err = parseAgingReportRequest(strings.NewReader(`{"status_id": "x", "include_guarantors": true, "group_by": "user"}`))
+	fields = asValidationErrors(err).Fields()
+	if len(fields) != 3 || fields["status_id"] == nil || fields["include_guarantors"] == nil || fields["group_by"] == nil {
+		t.Fatalf("aging errors: %v", fields)
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	router := NewHandler(nil, nil, nil, nil, nil).InitRouter()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post("/export/payments/estimate", `{"confirmed": "yes", "period_imported_start_date": "01.02.2024"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp APIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for field := range resp.Errors {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	if resp.ErrorCode != 422 || resp.Code != CodeInvalidField || !slices.Equal(fields, []string{"confirmed", "fields", "period_imported_start_date"}) {
+		t.Fatalf("response = %+v", resp)
+	}
+
+	// неизвестные поля — 422 с подсказками, до запуска выгрузки
+	rec = post("/export/debts/estimate", `{"fields": ["number", "debtor.ful_name", "nonsense_column_xyz"]}`)
+	resp = APIResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`unknown field "debtor.ful_name", did you mean "debtor.full_name"?`, `unknown field "nonsense_column_xyz"`}
+	if rec.Code != http.StatusUnprocessableEntity || !slices.Equal(resp.Errors["fields"], want) {
+		t.Fatalf("unknown fields: %d %+v", rec.Code, resp)
+	}
+
+	// битый JSON — по-прежнему 400
+	if rec := post("/export/debts/estimate", `{"fields":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status of malformed JSON = %d", rec.Code)
+	}
+}
@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"debtster-export/internal/transport/auth"
+)
+
+// WSUpgrader turns requests into WebSocket connections of a user.
+// Implemented by *websocket.Hub.
+type WSUpgrader interface {
+	HandleWebSocket(w http.ResponseWriter, r *http.Request, userID int64)
+}
+
+// SetWSUpgrader enables ConnectWS.
+func (h *Handler) SetWSUpgrader(hub WSUpgrader) {
+	h.wsHub = hub
+}
+
+// ConnectWS serves /ws for the user authenticated by auth.WSAuth. It is
+// mounted outside InitRouter because the endpoint has its own authentication.
+func (h *Handler) ConnectWS(w http.ResponseWriter, r *http.Request) {
+	if h.wsHub == nil {
+		http.NotFound(w, r)
+		return
+	}
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	log.Printf("WS connected: user_id=%d", userID)
+	h.wsHub.HandleWebSocket(w, r, userID)
+}
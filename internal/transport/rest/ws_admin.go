@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"debtster-export/internal/transport/websocket"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// WSConnections inspects and drops WebSocket connections. Implemented by *websocket.Hub.
+type WSConnections interface {
+	Connections() []websocket.ConnectionInfo
+	Disconnect(userID int64) int
+}
+
+// SetWSConnections enables /admin/ws.
+func (h *Handler) SetWSConnections(conns WSConnections) {
+	h.wsConnections = conns
+}
+
+type wsConnection struct {
+	ConnectedAt    time.Time `json:"connected_at"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+	RemoteAddr     string    `json:"remote_addr"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	EventVersion   int       `json:"event_version"`
+}
+
+type wsUserConnections struct {
+	UserID      int64          `json:"user_id"`
+	Count       int            `json:"count"`
+	Connections []wsConnection `json:"connections"`
+}
+
+// listWSConnections lists connected users with their connections; ?user_id= narrows it to one user.
+func (h *Handler) listWSConnections(w http.ResponseWriter, r *http.Request) {
+	if h.wsConnections == nil {
+		ErrorNotFound(w, "websocket hub is not configured")
+		return
+	}
+
+	var onlyUser *int64
+	if raw := r.URL.Query().Get("user_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			ErrorBadRequest(w, "invalid user_id")
+			return
+		}
+		onlyUser = &id
+	}
+
+	users := []wsUserConnections{}
+	total := 0
+	for _, c := range h.wsConnections.Connections() {
+		if onlyUser != nil && c.UserID != *onlyUser {
+			continue
+		}
+		// Connections are ordered by user
+		if len(users) == 0 || users[len(users)-1].UserID != c.UserID {
+			users = append(users, wsUserConnections{UserID: c.UserID})
+		}
+		u := &users[len(users)-1]
+		u.Count++
+		u.Connections = append(u.Connections, wsConnection{
+			ConnectedAt:    c.ConnectedAt,
+			LastActivityAt: c.LastActivityAt,
+			RemoteAddr:     c.RemoteAddr,
+			UserAgent:      c.UserAgent,
+			EventVersion:   c.EventVersion,
+		})
+		total++
+	}
+
+	Success(w, "", map[string]any{"total": total, "users": users})
+}
+
+// disconnectWSUser closes every connection of a user, e.g. to make a client resubscribe.
+func (h *Handler) disconnectWSUser(w http.ResponseWriter, r *http.Request) {
+	if h.wsConnections == nil {
+		ErrorNotFound(w, "websocket hub is not configured")
+		return
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil {
+		ErrorBadRequest(w, "invalid user_id")
+		return
+	}
+
+	n := h.wsConnections.Disconnect(userID)
+	if n == 0 {
+		ErrorNotFound(w, "user has no connections")
+		return
+	}
+	Success(w, "Соединения закрыты", map[string]any{"user_id": userID, "disconnected": n})
+}
@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"debtster-export/internal/transport/auth"
+)
+
+type fakeUpgrader struct {
+	users []int64
+}
+
+func (f *fakeUpgrader) HandleWebSocket(w http.ResponseWriter, _ *http.Request, userID int64) {
+	f.users = append(f.users, userID)
+	w.WriteHeader(http.StatusSwitchingProtocols)
+}
+
+func TestConnectWS(t *testing.T) {
+	hub := &fakeUpgrader{}
+	h := NewHandler(nil, nil, nil, nil, nil)
+	h.SetWSUpgrader(hub)
+
+	rec := httptest.NewRecorder()
+	h.ConnectWS(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rec.Code != http.StatusUnauthorized || len(hub.users) != 0 {
+		t.Fatalf("anonymous request: status %d, upgraded %v", rec.Code, hub.users)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, int64(7)))
+	rec = httptest.NewRecorder()
+	h.ConnectWS(rec, req)
+	if len(hub.users) != 1 || hub.users[0] != 7 {
+		t.Fatalf("upgraded users = %v", hub.users)
+	}
+}
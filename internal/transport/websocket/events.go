@@ -0,0 +1,67 @@
+package websocket
+
+import "debtster-export/internal/domain"
+
+// Event types sent to clients; their data is the payload struct of the same name.
+const (
+	TypeExportProgress      = "export_progress"
+	TypeExportComplete      = "export_complete"
+	TypeExportFailed        = "export_failed"
+	TypeExportBatchComplete = "export_batch_complete"
+)
+
+// EventVersion is the version of the event envelope. Version 1 is the legacy
+// format: the Message itself, with user_id and without version.
+const EventVersion = 2
+
+// Envelope is how version 2 clients receive a Message; the schemas are
+// documented in the README as TypeScript types.
+type Envelope struct {
+	Version   int    `json:"version"`
+	Type      string `json:"type"`
+	Channel   string `json:"channel,omitempty"`
+	Data      any    `json:"data"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (m *Message) envelope() Envelope {
+	return Envelope{
+		Version:   EventVersion,
+		Type:      m.Type,
+		Channel:   m.Channel,
+		Data:      m.Data,
+		RequestID: m.RequestID,
+	}
+}
+
+// ExportProgressData is the data of export_progress. Stage names the part of a
+// multi-part export (e.g. "batch"), empty for a plain export.
+type ExportProgressData struct {
+	ID       string  `json:"id"`
+	Progress float64 `json:"progress"`
+	Stage    string  `json:"stage,omitempty"`
+	// ETASeconds is the estimated time left while the rows are generated.
+	ETASeconds *int64 `json:"eta_seconds,omitempty"`
+}
+
+// ExportCompleteData is the data of export_complete.
+type ExportCompleteData struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+	UserID   int64  `json:"user_id"`
+}
+
+// ExportFailedData is the data of export_failed.
+type ExportFailedData struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	UserID  int64  `json:"user_id"`
+}
+
+// ExportBatchCompleteData is the data of export_batch_complete.
+type ExportBatchCompleteData struct {
+	ID      string                     `json:"id"`
+	Exports []domain.ExportBatchResult `json:"exports"`
+	UserID  int64                      `json:"user_id"`
+}
@@ -2,22 +2,84 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Разрешаем подключения с любого origin (в продакшене нужно настроить правильно)
-		return true
-	},
+// HubConfig tunes connection keepalive and buffering; zero fields keep the defaults.
+type HubConfig struct {
+	// PongWait — a connection without a pong (or message) for this long is dropped
+	PongWait time.Duration
+	// PingPeriod — how often pings are sent; must be shorter than PongWait
+	PingPeriod time.Duration
+	// WriteWait — time allowed to write a message
+	WriteWait time.Duration
+	// SendBuffer — messages queued per connection before a slow client is dropped
+	SendBuffer int
+	// BroadcastBuffer — messages queued in the hub before new ones are dropped
+	BroadcastBuffer int
+	// ReadBufferSize / WriteBufferSize — I/O buffer sizes of a connection in bytes
+	ReadBufferSize  int
+	WriteBufferSize int
+	// DefaultEventVersion — envelope version of clients that connect without
+	// ?version=; 1 (the default) keeps old frontends working
+	DefaultEventVersion int
+	// ReconnectJitter — on shutdown every client is told to reconnect after a
+	// random delay up to this long, so they do not all come back at once
+	ReconnectJitter time.Duration
+}
+
+const (
+	defaultWriteWait = 10 * time.Second
+
+	defaultPongWait = 60 * time.Second
+
+	defaultPingPeriod = (defaultPongWait * 9) / 10
+
+	defaultBuffer = 256
+
+	defaultReconnectJitter = 10 * time.Second
+)
+
+func (c HubConfig) withDefaults() HubConfig {
+	if c.PongWait <= 0 {
+		c.PongWait = defaultPongWait
+	}
+	if c.PingPeriod <= 0 || c.PingPeriod >= c.PongWait {
+		c.PingPeriod = (c.PongWait * 9) / 10
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = defaultWriteWait
+	}
+	if c.SendBuffer <= 0 {
+		c.SendBuffer = defaultBuffer
+	}
+	if c.BroadcastBuffer <= 0 {
+		c.BroadcastBuffer = defaultBuffer
+	}
+	if c.DefaultEventVersion < 1 || c.DefaultEventVersion > EventVersion {
+		c.DefaultEventVersion = 1
+	}
+	if c.ReconnectJitter <= 0 {
+		c.ReconnectJitter = defaultReconnectJitter
+	}
+	return c
 }
 
 type Hub struct {
+	cfg      HubConfig
+	upgrader websocket.Upgrader
+
 	connections map[int64]map[*Connection]bool
 
 	register   chan *Connection
@@ -33,6 +95,55 @@ type Connection struct {
 	userID int64
 	send   chan *Message
 	hub    *Hub
+
+	connectedAt time.Time
+	remoteAddr  string
+	userAgent   string
+	// version of the event envelope the client reads, see EventVersion
+	version int
+	// lastActivity — unix nanoseconds of the last message or pong in either direction
+	lastActivity atomic.Int64
+}
+
+// ConnectionInfo describes an open connection for diagnostics.
+type ConnectionInfo struct {
+	UserID         int64     `json:"user_id"`
+	ConnectedAt    time.Time `json:"connected_at"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+	RemoteAddr     string    `json:"remote_addr"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	EventVersion   int       `json:"event_version"`
+}
+
+func (c *Connection) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// CloseReason is the JSON reason of the close frames the hub sends; clients
+// should wait ReconnectAfterMs before reconnecting.
+type CloseReason struct {
+	Reason           string `json:"reason"`
+	ReconnectAfterMs int64  `json:"reconnect_after_ms,omitempty"`
+}
+
+// close sends a close frame with code and reason, then closes the connection;
+// the pumps fail and unregister it. Safe to call concurrently with them.
+func (c *Connection) close(code int, reason CloseReason) {
+	text, _ := json.Marshal(reason)
+	msg := websocket.FormatCloseMessage(code, string(text))
+	_ = c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.hub.cfg.WriteWait))
+	_ = c.ws.Close()
+}
+
+func (c *Connection) info() ConnectionInfo {
+	return ConnectionInfo{
+		UserID:         c.userID,
+		ConnectedAt:    c.connectedAt,
+		LastActivityAt: time.Unix(0, c.lastActivity.Load()),
+		RemoteAddr:     c.remoteAddr,
+		UserAgent:      c.userAgent,
+		EventVersion:   c.version,
+	}
 }
 
 type Message struct {
@@ -40,14 +151,34 @@ type Message struct {
 	Type    string      `json:"type"`
 	Channel string      `json:"channel,omitempty"`
 	Data    interface{} `json:"data"`
+	// RequestID correlates the event with the HTTP request that started the export
+	RequestID string `json:"request_id,omitempty"`
 }
 
 func NewHub() *Hub {
+	return NewHubWithConfig(HubConfig{})
+}
+
+// NewHubWithConfig creates a hub with tuned keepalive and buffer sizes.
+func NewHubWithConfig(cfg HubConfig) *Hub {
+	cfg = cfg.withDefaults()
 	return &Hub{
+		cfg: cfg,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  cfg.ReadBufferSize,
+			WriteBufferSize: cfg.WriteBufferSize,
+			// browsers send the token as the subprotocol after "bearer" (auth.WSProtocol);
+			// selecting "bearer" completes their handshake
+			Subprotocols: []string{"bearer"},
+			CheckOrigin: func(r *http.Request) bool {
+				// Разрешаем подключения с любого origin (в продакшене нужно настроить правильно)
+				return true
+			},
+		},
 		connections: make(map[int64]map[*Connection]bool),
 		register:    make(chan *Connection),
 		unregister:  make(chan *Connection),
-		broadcast:   make(chan *Message, 256),
+		broadcast:   make(chan *Message, cfg.BroadcastBuffer),
 	}
 }
 
@@ -55,8 +186,9 @@ func (h *Hub) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			// On shutdown: collect connections and close underlying websocket connections
-			// so read/write pumps receive errors and unregister themselves.
+			// On shutdown: collect connections and close them with a going-away
+			// frame so browsers see a clean close, each with its own reconnect
+			// delay so they do not all hit the load balancer at once.
 			h.mu.RLock()
 			var conns []*Connection
 			for _, m := range h.connections {
@@ -68,8 +200,10 @@ func (h *Hub) Run(ctx context.Context) {
 
 			// Close websockets outside lock so unregister logic can acquire mu.
 			for _, c := range conns {
-				// best-effort close; ignore errors
-				_ = c.ws.Close()
+				c.close(websocket.CloseGoingAway, CloseReason{
+					Reason:           "server shutdown",
+					ReconnectAfterMs: 1 + rand.Int64N(h.cfg.ReconnectJitter.Milliseconds()),
+				})
 			}
 
 			return
@@ -120,8 +254,56 @@ func (h *Hub) Broadcast(userID int64, message *Message) {
 	}
 }
 
+// Connections lists the open connections, ordered by user and connect time.
+func (h *Hub) Connections() []ConnectionInfo {
+	h.mu.RLock()
+	out := make([]ConnectionInfo, 0, len(h.connections))
+	for _, conns := range h.connections {
+		for c := range conns {
+			out = append(out, c.info())
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].UserID != out[j].UserID {
+			return out[i].UserID < out[j].UserID
+		}
+		return out[i].ConnectedAt.Before(out[j].ConnectedAt)
+	})
+	return out
+}
+
+// Disconnect closes every connection of userID and returns how many there
+// were; the pumps unregister them. Clients may reconnect right away.
+func (h *Hub) Disconnect(userID int64) int {
+	h.mu.RLock()
+	var conns []*Connection
+	for c := range h.connections[userID] {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+
+	for _, c := range conns {
+		c.close(websocket.CloseNormalClosure, CloseReason{Reason: "disconnected by admin"})
+	}
+	return len(conns)
+}
+
+// HandleWebSocket upgrades the request to a connection of userID. Clients pick
+// the event envelope with ?version=1 or ?version=2.
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request, userID int64) {
-	ws, err := upgrader.Upgrade(w, r, nil)
+	version := h.cfg.DefaultEventVersion
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 || v > EventVersion {
+			http.Error(w, fmt.Sprintf("unsupported version %q (want 1..%d)", raw, EventVersion), http.StatusBadRequest)
+			return
+		}
+		version = v
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
@@ -130,9 +312,15 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request, userID int
 	conn := &Connection{
 		ws:     ws,
 		userID: userID,
-		send:   make(chan *Message, 256),
+		send:   make(chan *Message, h.cfg.SendBuffer),
 		hub:    h,
+
+		connectedAt: time.Now(),
+		remoteAddr:  r.RemoteAddr,
+		userAgent:   r.UserAgent(),
+		version:     version,
 	}
+	conn.touch()
 
 	h.register <- conn
 
@@ -140,22 +328,16 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request, userID int
 	go conn.readPump()
 }
 
-const (
-	writeWait = 10 * time.Second
-
-	pongWait = 60 * time.Second
-
-	pingPeriod = (pongWait * 9) / 10
-)
-
 func (c *Connection) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.ws.Close()
 	}()
 
+	pongWait := c.hub.cfg.PongWait
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
 	c.ws.SetPongHandler(func(string) error {
+		c.touch()
 		c.ws.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
@@ -168,11 +350,13 @@ func (c *Connection) readPump() {
 			}
 			break
 		}
+		c.touch()
 	}
 }
 
 func (c *Connection) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	writeWait := c.hub.cfg.WriteWait
+	ticker := time.NewTicker(c.hub.cfg.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.ws.Close()
@@ -187,10 +371,15 @@ func (c *Connection) writePump() {
 				return
 			}
 
-			if err := c.ws.WriteJSON(message); err != nil {
+			var payload any = message
+			if c.version >= 2 {
+				payload = message.envelope()
+			}
+			if err := c.ws.WriteJSON(payload); err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
+			c.touch()
 
 		case <-ticker.C:
 			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
@@ -2,6 +2,8 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -316,3 +318,233 @@ func TestHub_ShutdownClosesConnections(t *testing.T) {
 
 	conn.Close()
 }
+
+func TestHub_SelectsBearerSubprotocol(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.HandleWebSocket(w, r, 1)
+	}))
+	defer server.Close()
+
+	// браузер передаёт токен вторым подпротоколом, сервер должен выбрать "bearer"
+	dialer := websocket.Dialer{Subprotocols: []string{"bearer", "1|token"}}
+	conn, _, err := dialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if got := conn.Subprotocol(); got != "bearer" {
+		t.Fatalf("Subprotocol = %q, want bearer", got)
+	}
+}
+
+func TestHub_ConnectionsAndDisconnect(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := int64(1)
+		if r.URL.Query().Get("user_id") == "2" {
+			userID = 2
+		}
+		hub.HandleWebSocket(w, r, userID)
+	}))
+	defer server.Close()
+
+	header := http.Header{"User-Agent": {"test-agent"}}
+	conn1, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?user_id=1", header)
+	if err != nil {
+		t.Fatalf("Failed to connect user 1: %v", err)
+	}
+	defer conn1.Close()
+	conn2, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?user_id=2", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect user 2: %v", err)
+	}
+	defer conn2.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	infos := hub.Connections()
+	if len(infos) != 2 || infos[0].UserID != 1 || infos[1].UserID != 2 {
+		t.Fatalf("Connections = %+v, want users 1 and 2", infos)
+	}
+	if infos[0].UserAgent != "test-agent" || infos[0].ConnectedAt.IsZero() || infos[0].LastActivityAt.Before(infos[0].ConnectedAt) {
+		t.Errorf("unexpected metadata: %+v", infos[0])
+	}
+
+	if n := hub.Disconnect(1); n != 1 {
+		t.Fatalf("Disconnect(1) = %d, want 1", n)
+	}
+	conn1.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn1.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected a normal close frame, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if infos := hub.Connections(); len(infos) != 1 || infos[0].UserID != 2 {
+		t.Fatalf("Connections after disconnect = %+v, want only user 2", infos)
+	}
+	if n := hub.Disconnect(1); n != 0 {
+		t.Errorf("Disconnect(1) again = %d, want 0", n)
+	}
+}
+
+func TestHubConfig_Defaults(t *testing.T) {
+	cfg := HubConfig{PongWait: 20 * time.Second, PingPeriod: 30 * time.Second}.withDefaults()
+	if cfg.PingPeriod != 18*time.Second {
+		t.Errorf("PingPeriod = %v, want 18s (ping must come before the pong deadline)", cfg.PingPeriod)
+	}
+	if cfg.WriteWait != defaultWriteWait || cfg.SendBuffer != defaultBuffer || cfg.BroadcastBuffer != defaultBuffer {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
+
+func TestHub_PingsWithConfiguredPeriod(t *testing.T) {
+	hub := NewHubWithConfig(HubConfig{PingPeriod: 50 * time.Millisecond, PongWait: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.HandleWebSocket(w, r, 1)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-pinged:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("no ping within 500ms with a 50ms ping period")
+	}
+}
+
+func TestHub_ShutdownSendsGoingAwayWithReconnectHint(t *testing.T) {
+	hub := NewHubWithConfig(HubConfig{ReconnectJitter: 5 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.HandleWebSocket(w, r, 1)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("expected a going-away close frame, got %v", err)
+	}
+	var reason CloseReason
+	if err := json.Unmarshal([]byte(closeErr.Text), &reason); err != nil {
+		t.Fatalf("close reason %q is not JSON: %v", closeErr.Text, err)
+	}
+	if reason.Reason != "server shutdown" || reason.ReconnectAfterMs < 1 || reason.ReconnectAfterMs > 5000 {
+		t.Errorf("unexpected close reason: %+v", reason)
+	}
+}
+
+func TestHub_EventVersions(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hub.Run(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.HandleWebSocket(w, r, 1)
+	}))
+	defer server.Close()
+
+	legacy, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer legacy.Close()
+	current, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?version=2", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer current.Close()
+	if _, resp, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?version=9", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("version=9 should be rejected with 400, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	hub.Broadcast(1, &Message{
+		Type:    TypeExportProgress,
+		Channel: "notify_user_of_progress_export#1",
+		Data:    ExportProgressData{ID: "exports:1", Progress: 50},
+	})
+
+	legacy.SetReadDeadline(time.Now().Add(time.Second))
+	var v1 map[string]any
+	if err := legacy.ReadJSON(&v1); err != nil {
+		t.Fatalf("legacy read: %v", err)
+	}
+	if _, ok := v1["version"]; ok || v1["user_id"] != float64(1) {
+		t.Errorf("legacy message = %v, want user_id and no version", v1)
+	}
+
+	current.SetReadDeadline(time.Now().Add(time.Second))
+	var v2 map[string]any
+	if err := current.ReadJSON(&v2); err != nil {
+		t.Fatalf("v2 read: %v", err)
+	}
+	if v2["version"] != float64(EventVersion) || v2["type"] != TypeExportProgress {
+		t.Errorf("v2 message = %v", v2)
+	}
+	if _, ok := v2["user_id"]; ok {
+		t.Errorf("v2 message has a top-level user_id: %v", v2)
+	}
+	data, _ := v2["data"].(map[string]any)
+	if data["id"] != "exports:1" || data["progress"] != float64(50) {
+		t.Errorf("v2 data = %v", data)
+	}
+	if _, ok := data["stage"]; ok {
+		t.Errorf("empty stage should be omitted: %v", data)
+	}
+}
@@ -0,0 +1,25 @@
+// Package version reports the version of the running build.
+package version
+
+import "runtime/debug"
+
+// Version is set at build time:
+//
+//	go build -ldflags "-X debtster-export/internal/version.Version=v1.2.3" ./cmd/main.go
+var Version string
+
+// String returns Version, or the VCS revision the binary was built from when
+// it is not set, or "dev".
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && s.Value != "" {
+				return s.Value[:min(len(s.Value), 12)]
+			}
+		}
+	}
+	return "dev"
+}
@@ -13,6 +13,8 @@ type ConnectionInfo struct {
 	DBName   string
 	SSLMode  string
 	Password string
+	// SearchPath, when set, is sent as the search_path runtime parameter
+	SearchPath string
 }
 
 func NewPostgresConnection(info ConnectionInfo) (*sql.DB, error) {
@@ -25,6 +27,9 @@ func NewPostgresConnection(info ConnectionInfo) (*sql.DB, error) {
 		info.SSLMode,
 		info.Password,
 	)
+	if info.SearchPath != "" {
+		dsn += fmt.Sprintf(" search_path='%s'", info.SearchPath)
+	}
 
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
@@ -0,0 +1,473 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/repository"
+	"debtster-export/internal/service"
+	"debtster-export/internal/transport/auth"
+	"debtster-export/internal/transport/rest"
+	ws "debtster-export/internal/transport/websocket"
+
+	"github.com/gorilla/websocket"
+	"github.com/xuri/excelize/v2"
+)
+
+// plain Sanctum token of user 1, see testdata/seed.sql
+const userToken = "1|integration-token"
+
+type app struct {
+	server  *httptest.Server
+	storage *clients.StorageClient
+}
+
+// newApp wires the export service the same way cmd/main.go does, on top of the
+// test containers.
+func newApp(t *testing.T) *app {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	redisClient, err := clients.NewRedisClient(clients.RedisConfig{
+		Addr:   env.redisAddr,
+		Prefix: "integration_" + strings.ReplaceAll(t.Name(), "/", "_") + ":",
+	})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(redisClient.Close)
+
+	storage, err := clients.NewLocalStorage(t.TempDir(), "/files", "")
+	if err != nil {
+		t.Fatalf("storage: %v", err)
+	}
+
+	recordings, err := clients.NewS3Client(clients.S3Config{
+		Endpoint:   env.minioAddr,
+		AccessKey:  minioAccessKey,
+		SecretKey:  minioSecretKey,
+		Bucket:     minioBucket,
+		Region:     "us-east-1",
+		PresignTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("s3: %v", err)
+	}
+
+	hub := ws.NewHub()
+	go hub.Run(ctx)
+	wsClient := clients.NewWebSocketClient(hub)
+
+	repoDB := repository.NewDB(env.db, nil)
+	debtSvc := service.NewDebtService(repository.NewDebtRepository(repoDB), redisClient, storage, wsClient)
+	userSvc := service.NewUserService(repository.NewUserRepository(repoDB), redisClient, storage, wsClient)
+	actionSvc := service.NewActionService(repository.NewActionRepository(repoDB), redisClient, storage, wsClient, recordings)
+	paymentSvc := service.NewPaymentService(repository.NewPaymentRepository(repoDB), redisClient, storage, wsClient)
+	filterNames := repository.NewNameRepository(repoDB)
+	debtSvc.SetFilterNames(filterNames)
+	actionSvc.SetFilterNames(filterNames)
+	paymentSvc.SetFilterNames(filterNames)
+
+	jobs := service.NewJobRunner(2)
+	t.Cleanup(func() {
+		shutdownCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+		jobs.Shutdown(shutdownCtx)
+	})
+	debtSvc.SetJobRunner(jobs)
+	userSvc.SetJobRunner(jobs)
+	actionSvc.SetJobRunner(jobs)
+	paymentSvc.SetJobRunner(jobs)
+
+	exportSvc := service.NewExportService(redisClient, "pkb_database_cache")
+	exportSvc.RegisterRetrier("debts", debtSvc)
+	exportSvc.RegisterRetrier("users", userSvc)
+	exportSvc.RegisterRetrier("actions", actionSvc)
+	exportSvc.RegisterRetrier("payments", paymentSvc)
+	exportSvc.SetJobRunner(jobs)
+
+	handler := rest.NewHandler(debtSvc, userSvc, actionSvc, paymentSvc, exportSvc)
+	handler.SetRateLimiters(
+		clients.NewRateLimiter(redisClient, "ip", 60, 10),
+		clients.NewRateLimiter(redisClient, "user", 60, 10),
+	)
+	router := handler.InitRouterWithAuth(auth.SanctumMiddleware(repository.NewPersonalAccessTokenRepository(repoDB), nil))
+	router.Get("/ws", func(w http.ResponseWriter, r *http.Request) {
+		userID, err := auth.GetUserID(r.Context())
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		hub.HandleWebSocket(w, r, userID)
+	})
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return &app{server: server, storage: storage}
+}
+
+// listen connects to /ws as the token owner and returns the channel of received events.
+func (a *app) listen(t *testing.T) <-chan ws.Message {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(a.server.URL, "http") + "/ws?token=" + url.QueryEscape(userToken)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("ws dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	events := make(chan ws.Message, 64)
+	go func() {
+		defer close(events)
+		for {
+			var msg ws.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			events <- msg
+		}
+	}()
+
+	// даём хабу зарегистрировать подключение до старта экспорта
+	time.Sleep(100 * time.Millisecond)
+	return events
+}
+
+// startExport posts body to /export/<kind> and returns the export id.
+func (a *app) startExport(t *testing.T, kind string, body any) string {
+	t.Helper()
+
+	raw, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, a.server.URL+"/export/"+kind, bytes.NewReader(raw))
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /export/%s: %v", kind, err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Message string `json:"message"`
+		Data    struct {
+			ExportID string `json:"export_id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusAccepted || out.Data.ExportID == "" {
+		t.Fatalf("POST /export/%s: status %d, message %q", kind, resp.StatusCode, out.Message)
+	}
+	return out.Data.ExportID
+}
+
+// awaitComplete waits for the export_complete event of exportID and returns the
+// local path of the generated file.
+func (a *app) awaitComplete(t *testing.T, events <-chan ws.Message, exportID string) string {
+	t.Helper()
+
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				t.Fatal("websocket closed before export completed")
+			}
+			data, _ := msg.Data.(map[string]any)
+			if data["id"] != exportID {
+				continue
+			}
+			switch msg.Type {
+			case "export_failed":
+				t.Fatalf("export failed: %v", data["message"])
+			case "export_complete":
+				fileURL, _ := data["url"].(string)
+				if fileURL == "" {
+					t.Fatal("export_complete without url")
+				}
+				return filepath.Join(a.storage.BaseDir, path.Base(fileURL))
+			}
+		case <-timeout:
+			t.Fatalf("export %s did not complete in time", exportID)
+		}
+	}
+}
+
+// exportStatus reads the export back through GET /export/{id}.
+func (a *app) exportStatus(t *testing.T, exportID string) service.ExportStatus {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, a.server.URL+"/export/"+strings.TrimPrefix(exportID, "exports:"), nil)
+	req.Header.Set("Authorization", "Bearer "+userToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET export: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET export: status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Data service.ExportStatus `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode export status: %v", err)
+	}
+	return out.Data
+}
+
+// readSheet returns all rows of the first sheet of the XLSX file at path.
+func readSheet(t *testing.T, file string) [][]string {
+	t.Helper()
+
+	f, err := excelize.OpenFile(file)
+	if err != nil {
+		t.Fatalf("open xlsx: %v", err)
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(f.GetSheetList()[0])
+	if err != nil {
+		t.Fatalf("read rows: %v", err)
+	}
+	return rows
+}
+
+// column returns the values of the column with the given header, without the header row.
+func column(t *testing.T, rows [][]string, header string) []string {
+	t.Helper()
+
+	if len(rows) == 0 {
+		t.Fatal("sheet is empty")
+	}
+	idx := -1
+	for i, h := range rows[0] {
+		if h == header {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		t.Fatalf("header %q not found in %v", header, rows[0])
+	}
+
+	var values []string
+	for _, row := range rows[1:] {
+		if idx < len(row) {
+			values = append(values, row[idx])
+		} else {
+			values = append(values, "")
+		}
+	}
+	return values
+}
+
+func assertContainsAll(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			if g == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("value %q not found in %v", w, got)
+		}
+	}
+}
+
+func TestDebtsExport(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	exportID := a.startExport(t, "debts", map[string]any{
+		"fields":      []string{"debtor.full_name", "debtor.iin", "registry.number", "counterparty.name"},
+		"registry_id": "11111111-1111-1111-1111-111111111111",
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 debts, got %d rows: %v", len(rows), rows)
+	}
+	assertContainsAll(t, column(t, rows, "ФИО"), "Иванов Иван", "Петров Пётр")
+	assertContainsAll(t, column(t, rows, "ИИН"), "900101300001", "910202300002")
+	assertContainsAll(t, column(t, rows, "Номер реестра"), "R-001")
+	assertContainsAll(t, column(t, rows, "Контрагент"), "Test Bank")
+
+	status := a.exportStatus(t, exportID)
+	if status.Progress != 100 || status.FileURL == nil {
+		t.Errorf("expected finished export, got progress=%v file_url=%v", status.Progress, status.FileURL)
+	}
+}
+
+func TestDebtsExport_FilterNames(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	exportID := a.startExport(t, "debts", map[string]any{
+		"fields":          []string{"number"},
+		"counterparty_id": "22222222-2222-2222-2222-222222222222",
+		"status_id":       1,
+	})
+	a.awaitComplete(t, events, exportID)
+
+	filters, _ := a.exportStatus(t, exportID).Filters.(map[string]any)
+	if filters["status_id"] != float64(1) || filters["status_name"] != "В работе" || filters["counterparty_name"] != "Test Bank" {
+		t.Errorf("filters = %v", filters)
+	}
+}
+
+func TestDebtsExport_PaymentHistory(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	exportID := a.startExport(t, "debts", map[string]any{
+		"fields":      []string{"number", "payments.last_date", "payments.total_paid"},
+		"registry_id": "11111111-1111-1111-1111-111111111111",
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 debts, got %d rows: %v", len(rows), rows)
+	}
+	// unconfirmed payment of D-0002 is not counted
+	assertContainsAll(t, column(t, rows, "Всего оплачено"), "5000", "0")
+	assertContainsAll(t, column(t, rows, "Дата последнего платежа"), "2025-02-01 00:00:00")
+}
+
+func TestDebtsExport_Contacts(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	exportID := a.startExport(t, "debts", map[string]any{
+		"fields":      []string{"number", "debtor.phones", "debtor.phone_2", "debtor.addresses"},
+		"registry_id": "11111111-1111-1111-1111-111111111111",
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 debts, got %d rows: %v", len(rows), rows)
+	}
+	// deleted phone is skipped
+	assertContainsAll(t, column(t, rows, "Телефоны"), "+77010000001, +77270000001")
+	assertContainsAll(t, column(t, rows, "Телефон 2"), "+77270000001")
+	assertContainsAll(t, column(t, rows, "Адреса"), "г. Алматы, ул. Абая, 1")
+}
+
+func TestDebtsExport_LegalStage(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	// D-0001 had a claim before, but its latest case is in enforcement
+	exportID := a.startExport(t, "debts", map[string]any{
+		"fields": []string{"number", "court.case_number", "court.name", "enforcement.agent"},
+		"stage":  "claim",
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 2 {
+		t.Fatalf("expected header and 1 debt, got %d rows: %v", len(rows), rows)
+	}
+	assertContainsAll(t, column(t, rows, "Номер договора"), "D-0002")
+	assertContainsAll(t, column(t, rows, "Номер судебного дела"), "2-777/2025")
+	assertContainsAll(t, column(t, rows, "Суд"), "Медеуский районный суд")
+
+	exportID = a.startExport(t, "debts", map[string]any{
+		"fields": []string{"number", "court.case_number", "enforcement.agent"},
+		"stage":  "enforcement",
+	})
+	rows = readSheet(t, a.awaitComplete(t, events, exportID))
+	if len(rows) != 2 {
+		t.Fatalf("expected header and 1 debt, got %d rows: %v", len(rows), rows)
+	}
+	assertContainsAll(t, column(t, rows, "Номер судебного дела"), "2-555/2025")
+	assertContainsAll(t, column(t, rows, "Судебный исполнитель"), "Касымов Н.")
+}
+
+func TestPaymentsExport(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	exportID := a.startExport(t, "payments", map[string]any{
+		"fields":    []string{"id", "debt_id", "amount", "confirmed"},
+		"confirmed": 1,
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 2 {
+		t.Fatalf("expected header and 1 confirmed payment, got %d rows: %v", len(rows), rows)
+	}
+	assertContainsAll(t, column(t, rows, "ID"), "55555555-5555-5555-5555-555555555551")
+	assertContainsAll(t, column(t, rows, "ID долга"), "44444444-4444-4444-4444-444444444441")
+	assertContainsAll(t, column(t, rows, "Сумма"), "5000")
+}
+
+func TestActionsExport_TypeFilters(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	// all calls except the auto-dialer
+	exportID := a.startExport(t, "actions", map[string]any{
+		"fields":           []string{"debt.number", "actionType.name", "comment"},
+		"type_ids":         []string{"call", "auto_dial"},
+		"exclude_type_ids": []string{"auto_dial"},
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 calls, got %d rows: %v", len(rows), rows)
+	}
+	assertContainsAll(t, column(t, rows, "Комментарий"), "Не дозвонились", "Обещал оплатить")
+	// названия типов берутся из справочника action_types
+	assertContainsAll(t, column(t, rows, "Тип действия"), "Звонок")
+}
+
+func TestActionsExport(t *testing.T) {
+	a := newApp(t)
+	events := a.listen(t)
+
+	exportID := a.startExport(t, "actions", map[string]any{
+		"fields": []string{"debt.number", "type", "comment", "payload.recording_url"},
+	})
+	rows := readSheet(t, a.awaitComplete(t, events, exportID))
+
+	if len(rows) != 4 {
+		t.Fatalf("expected header and 3 actions, got %d rows: %v", len(rows), rows)
+	}
+	assertContainsAll(t, column(t, rows, "Номер долга"), "D-0001", "D-0002")
+	assertContainsAll(t, column(t, rows, "Комментарий"), "Не дозвонились", "Обещал оплатить", "Автообзвон")
+
+	// запись звонка выгружается как presigned-ссылка на объект в MinIO
+	var presigned string
+	for _, v := range column(t, rows, "Запись звонка") {
+		if v != "" {
+			presigned = v
+		}
+	}
+	if !strings.Contains(presigned, "/"+minioBucket+"/calls/2025/01/rec-1.mp3") || !strings.Contains(presigned, "X-Amz-Signature") {
+		t.Errorf("expected presigned recording url, got %q", presigned)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"debtster-export/internal/clients"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// consumeTopic reads n messages of topic from the start.
+func consumeTopic(t *testing.T, topic string, n int) []*kgo.Record {
+	t.Helper()
+	c, err := kgo.NewClient(kgo.SeedBrokers(env.kafkaAddr), kgo.ConsumeTopics(topic), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var out []*kgo.Record
+	for len(out) < n {
+		fetches := c.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("consumed %d of %d messages: %v", len(out), n, err)
+		}
+		fetches.EachRecord(func(r *kgo.Record) { out = append(out, r) })
+	}
+	return out
+}
+
+func TestKafkaClient_Insert(t *testing.T) {
+	c, err := clients.NewKafkaClient([]clients.KafkaProfile{
+		{Name: "rows", Brokers: []string{env.kafkaAddr}, Topic: "integration.rows", KeyField: "number", Timeout: 30 * time.Second},
+		{Name: "chunks", Brokers: []string{env.kafkaAddr}, Topic: "integration.chunks", Mode: clients.KafkaModeNDJSON, ChunkRows: 2, Timeout: 30 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []map[string]any{{"number": "D-1", "amount": 10.5}, {"number": "D-2"}, {"number": "D-3"}}
+
+	// брокер подтверждает каждое сообщение, ключи и значения доходят как есть
+	topic, err := c.Insert(context.Background(), "rows", "exports:1", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic != "integration.rows" {
+		t.Fatalf("topic = %q", topic)
+	}
+	var keys []string
+	for _, r := range consumeTopic(t, topic, 3) {
+		keys = append(keys, string(r.Key))
+		if string(r.Key) == "D-1" && string(r.Value) != `{"amount":10.5,"number":"D-1"}` {
+			t.Fatalf("value = %s", r.Value)
+		}
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"D-1", "D-2", "D-3"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	if _, err := c.Insert(context.Background(), "chunks", "exports:1", rows); err != nil {
+		t.Fatal(err)
+	}
+	if got := consumeTopic(t, "integration.chunks", 2); string(got[1].Key) != "exports:1:1" || string(got[1].Value) != "{\"number\":\"D-3\"}\n" {
+		t.Fatalf("last chunk: %s=%q", got[1].Key, got[1].Value)
+	}
+}
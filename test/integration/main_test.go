@@ -0,0 +1,204 @@
+//go:build integration
+
+// Package integration runs full export flows against real Postgres, Redis,
+// MinIO and Redpanda (Kafka) containers started with dockertest. Requires a Docker daemon:
+//
+//	go test -tags integration ./test/integration/...
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	minioAccessKey = "integration"
+	minioSecretKey = "integration-secret"
+	minioBucket    = "recordings"
+	// kafkaAddr is bound on the host as is: the broker advertises it to clients
+	kafkaAddr = "127.0.0.1:19092"
+)
+
+// env holds connection settings of the containers shared by all tests.
+var env struct {
+	db        *sql.DB
+	redisAddr string
+	minioAddr string
+	kafkaAddr string
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		log.Printf("dockertest: %v", err)
+		return 1
+	}
+	pool.MaxWait = 2 * time.Minute
+	if err := pool.Client.Ping(); err != nil {
+		log.Printf("docker is not available: %v", err)
+		return 1
+	}
+
+	var resources []*dockertest.Resource
+	defer func() {
+		for _, r := range resources {
+			if err := pool.Purge(r); err != nil {
+				log.Printf("purge %s: %v", r.Container.Name, err)
+			}
+		}
+	}()
+
+	start := func(opts *dockertest.RunOptions) (*dockertest.Resource, error) {
+		r, err := pool.RunWithOptions(opts, func(hc *docker.HostConfig) {
+			hc.AutoRemove = true
+			hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("start %s: %w", opts.Repository, err)
+		}
+		_ = r.Expire(600)
+		resources = append(resources, r)
+		return r, nil
+	}
+
+	pg, err := start(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "16-alpine",
+		Env:        []string{"POSTGRES_USER=export", "POSTGRES_PASSWORD=export", "POSTGRES_DB=export"},
+	})
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	rd, err := start(&dockertest.RunOptions{Repository: "redis", Tag: "7-alpine"})
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	mn, err := start(&dockertest.RunOptions{
+		Repository: "minio/minio",
+		Tag:        "latest",
+		Cmd:        []string{"server", "/data"},
+		Env:        []string{"MINIO_ROOT_USER=" + minioAccessKey, "MINIO_ROOT_PASSWORD=" + minioSecretKey},
+	})
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if _, err := start(&dockertest.RunOptions{
+		Repository: "redpandadata/redpanda",
+		Tag:        "latest",
+		Cmd: []string{
+			"redpanda", "start", "--mode", "dev-container", "--smp", "1",
+			"--kafka-addr", "0.0.0.0:19092", "--advertise-kafka-addr", kafkaAddr,
+		},
+		ExposedPorts: []string{"19092/tcp"},
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			"19092/tcp": {{HostIP: "127.0.0.1", HostPort: "19092"}},
+		},
+	}); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	dsn := fmt.Sprintf("postgres://export:export@%s/export?sslmode=disable", pg.GetHostPort("5432/tcp"))
+	env.redisAddr = rd.GetHostPort("6379/tcp")
+	env.minioAddr = mn.GetHostPort("9000/tcp")
+	env.kafkaAddr = kafkaAddr
+
+	if err := pool.Retry(func() error {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			return err
+		}
+		if err := db.Ping(); err != nil {
+			_ = db.Close()
+			return err
+		}
+		env.db = db
+		return nil
+	}); err != nil {
+		log.Printf("postgres is not ready: %v", err)
+		return 1
+	}
+	defer env.db.Close()
+
+	if err := pool.Retry(func() error {
+		rdb := goredis.NewClient(&goredis.Options{Addr: env.redisAddr})
+		defer rdb.Close()
+		return rdb.Ping(context.Background()).Err()
+	}); err != nil {
+		log.Printf("redis is not ready: %v", err)
+		return 1
+	}
+
+	if err := pool.Retry(func() error {
+		kc, err := kgo.NewClient(kgo.SeedBrokers(env.kafkaAddr))
+		if err != nil {
+			return err
+		}
+		defer kc.Close()
+		return kc.Ping(context.Background())
+	}); err != nil {
+		log.Printf("redpanda is not ready: %v", err)
+		return 1
+	}
+
+	if err := pool.Retry(createBucket); err != nil {
+		log.Printf("minio is not ready: %v", err)
+		return 1
+	}
+
+	if err := seed(env.db); err != nil {
+		log.Printf("seed: %v", err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+func createBucket() error {
+	client, err := minio.New(env.minioAddr, &minio.Options{
+		Creds: credentials.NewStaticV4(minioAccessKey, minioSecretKey, ""),
+	})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, minioBucket)
+	if err != nil || exists {
+		return err
+	}
+	return client.MakeBucket(ctx, minioBucket, minio.MakeBucketOptions{})
+}
+
+func seed(db *sql.DB) error {
+	for _, file := range []string{"testdata/schema.sql", "testdata/seed.sql"} {
+		query, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(string(query)); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"debtster-export/internal/migrations"
+)
+
+// serviceTables are the tables the embedded migrations own.
+var serviceTables = []string{"export_jobs", "export_notification_settings", "export_token_usage", "export_outbox", "export_uploads"}
+
+func tableExists(t *testing.T, name string) bool {
+	t.Helper()
+	var exists bool
+	if err := env.db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func appliedMigrations(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := env.db.QueryRow(`SELECT count(*) FROM export_service_migrations WHERE version_id > 0 AND is_applied`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	if err := migrations.Up(ctx, env.db); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	for _, table := range serviceTables {
+		if !tableExists(t, table) {
+			t.Fatalf("table %s is not created", table)
+		}
+	}
+	applied := appliedMigrations(t)
+
+	// повторный запуск (auto-migrate при каждом старте) ничего не делает
+	if err := migrations.Up(ctx, env.db); err != nil {
+		t.Fatalf("second up: %v", err)
+	}
+	if n := appliedMigrations(t); n != applied {
+		t.Fatalf("second up applied %d migrations, had %d", n, applied)
+	}
+	if err := migrations.Status(ctx, env.db); err != nil {
+		t.Fatalf("status: %v", err)
+	}
+
+	// down откатывает ровно одну, последнюю миграцию
+	if err := migrations.Down(ctx, env.db); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if tableExists(t, "export_uploads") || !tableExists(t, "export_outbox") || appliedMigrations(t) != applied-1 {
+		t.Fatal("down must roll back only the latest migration")
+	}
+
+	if err := migrations.Up(ctx, env.db); err != nil {
+		t.Fatalf("up after down: %v", err)
+	}
+	if !tableExists(t, "export_uploads") {
+		t.Fatal("up after down did not restore the table")
+	}
+}
@@ -0,0 +1,476 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"debtster-export/internal/clients"
+	"debtster-export/internal/tenant"
+	ws "debtster-export/internal/transport/websocket"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRedisClient_Commands(t *testing.T) {
+	c, err := clients.NewRedisClient(clients.RedisConfig{Addr: env.redisAddr, Prefix: "integration_redis:", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(c.Close)
+	ctx := tenant.WithTenant(context.Background(), tenant.Tenant{ID: "acme"})
+
+	for _, key := range []string{"exports:1", "exports:2", "other"} {
+		if err := c.Set(ctx, key, "v", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ключи возвращаются без префикса и тенанта
+	keys, err := c.Scan(ctx, "exports:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"exports:1", "exports:2"}) {
+		t.Fatalf("scan: %v", keys)
+	}
+
+	if ok, err := c.Expire(ctx, "exports:1", time.Hour); err != nil || !ok {
+		t.Fatalf("expire: %v, %v", ok, err)
+	}
+	if ttl, err := c.TTL(ctx, "exports:1"); err != nil || ttl <= time.Minute {
+		t.Fatalf("ttl: %v, %v", ttl, err)
+	}
+	if ok, _ := c.Expire(ctx, "missing", time.Hour); ok {
+		t.Fatal("expire of a missing key reported success")
+	}
+
+	if err := c.Del(ctx, "exports:1", "exports:2", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := c.TTL(ctx, "exports:1"); ttl != -2 {
+		t.Fatalf("ttl of a deleted key: %v", ttl)
+	}
+	if _, err := c.Get(ctx, "exports:1"); err == nil {
+		t.Fatal("expected an error for a deleted key")
+	}
+
+	// отсутствующий ключ не считается ошибкой
+	if stats := c.Stats(); stats.Commands == 0 || stats.Errors != 0 {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
+
+func TestRedisClient_Lock(t *testing.T) {
+	c, err := clients.NewRedisClient(clients.RedisConfig{Addr: env.redisAddr, Prefix: "integration_lock:", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(c.Close)
+	ctx := context.Background()
+
+	lock, err := c.TryLock(ctx, "janitor", 300*time.Millisecond)
+	if err != nil || lock == nil {
+		t.Fatalf("lock: %v, %v", lock, err)
+	}
+	// блокировка продлевается, пока её держат
+	time.Sleep(time.Second)
+	if other, err := c.TryLock(ctx, "janitor", time.Second); err != nil || other != nil {
+		t.Fatalf("lock taken twice: %v, %v", other, err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other, err := c.TryLock(ctx, "janitor", time.Second)
+	if err != nil || other == nil {
+		t.Fatalf("lock not freed: %v", err)
+	}
+	_ = other.Release(ctx)
+
+	// из двух экземпляров лидером становится один
+	first := clients.NewLeaderElection(c, "janitor", 300*time.Millisecond)
+	second := clients.NewLeaderElection(c, "janitor", 300*time.Millisecond)
+	runCtx, stop := context.WithCancel(ctx)
+	go first.Run(runCtx)
+	go second.Run(runCtx)
+	time.Sleep(500 * time.Millisecond)
+	if first.IsLeader() == second.IsLeader() {
+		t.Fatalf("leaders: %v, %v", first.IsLeader(), second.IsLeader())
+	}
+	stop()
+}
+
+func TestRedisQueue(t *testing.T) {
+	c, err := clients.NewRedisClient(clients.RedisConfig{Addr: env.redisAddr, Prefix: "integration_queue:", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(c.Close)
+	ctx := context.Background()
+	q := clients.NewRedisQueue(c, "exports")
+
+	if err := q.Push(ctx, "later", "b", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(ctx, "now", "a", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// берётся только наступившая задача
+	id, payload, attempts, err := q.Claim(ctx, 100*time.Millisecond)
+	if err != nil || id != "now" || payload != "a" || attempts != 1 {
+		t.Fatalf("claim = %q %q %d %v", id, payload, attempts, err)
+	}
+	if id, _, _, _ := q.Claim(ctx, time.Minute); id != "" {
+		t.Fatalf("claimed %q before it was due", id)
+	}
+	if pending, scheduled, active, err := q.Stats(ctx); err != nil || pending != 0 || scheduled != 1 || active != 1 {
+		t.Fatalf("stats = %d %d %d %v", pending, scheduled, active, err)
+	}
+
+	// аренда истекла: задачу забирают и возвращают в очередь с тем же счётчиком попыток
+	time.Sleep(150 * time.Millisecond)
+	expired, err := q.Expired(ctx)
+	if err != nil || len(expired) != 1 || expired[0] != "now" {
+		t.Fatalf("expired = %v, %v", expired, err)
+	}
+	if held, _ := q.Extend(ctx, "now", 1, time.Minute); !held {
+		t.Fatal("an expired job not yet reclaimed can still be extended")
+	}
+	if _, _, _, ok, _ := q.Reclaim(ctx, "now", 3, 0, time.Minute); ok {
+		t.Fatal("reclaimed a job with a live lease")
+	}
+	if _, err := q.Extend(ctx, "now", 1, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	payload, attempts, requeued, ok, err := q.Reclaim(ctx, "now", 3, 0, time.Minute)
+	if err != nil || !ok || !requeued || payload != "a" || attempts != 1 {
+		t.Fatalf("reclaim = %q %d %t %t %v", payload, attempts, requeued, ok, err)
+	}
+	if _, _, attempts, _ := q.Claim(ctx, time.Minute); attempts != 2 {
+		t.Fatalf("attempts = %d", attempts)
+	}
+	if err := q.Ack(ctx, "now"); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := q.Remove(ctx, "later"); err != nil || !removed {
+		t.Fatalf("remove = %t, %v", removed, err)
+	}
+	if pending, scheduled, active, _ := q.Stats(ctx); pending+scheduled+active != 0 {
+		t.Fatalf("queue not empty: %d %d %d", pending, scheduled, active)
+	}
+}
+
+func TestWSRelay(t *testing.T) {
+	c, err := clients.NewRedisClient(clients.RedisConfig{Addr: env.redisAddr, Prefix: "integration_relay:", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(c.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// экземпляр с подключением пользователя
+	hub := ws.NewHub()
+	go hub.Run(ctx)
+	relay := clients.NewWSRelay(c)
+	go relay.Run(ctx, hub)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.HandleWebSocket(w, r, 7)
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// воркер без подключений публикует событие своего экспорта
+	worker := clients.NewWebSocketClient(nil)
+	worker.SetRelay(clients.NewWSRelay(c))
+	if err := worker.NotifyExportFailed(ctx, 7, "exports:1", "boom"); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got struct {
+		Type string              `json:"type"`
+		Data ws.ExportFailedData `json:"data"`
+	}
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got.Type != ws.TypeExportFailed || got.Data.ID != "exports:1" || got.Data.Message != "boom" {
+		t.Fatalf("relayed event: %+v", got)
+	}
+}
+
+func newTestQueue(t *testing.T) *clients.RedisQueue {
+	t.Helper()
+	c, err := clients.NewRedisClient(clients.RedisConfig{
+		Addr:    env.redisAddr,
+		Prefix:  "integration_" + strings.ReplaceAll(t.Name(), "/", "_") + ":",
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return clients.NewRedisQueue(c, "exports")
+}
+
+func TestRedisQueue_ConcurrentClaimsDeliverOnce(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	const jobs, consumers = 200, 8
+	for i := range jobs {
+		if err := q.Push(ctx, fmt.Sprintf("job-%d", i), "payload", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// несколько экземпляров разбирают очередь одновременно: каждая задача
+	// достаётся ровно одному
+	var (
+		mu      sync.Mutex
+		claimed = map[string]int{}
+		wg      sync.WaitGroup
+	)
+	for range consumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				id, payload, attempts, err := q.Claim(ctx, time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if id == "" {
+					return
+				}
+				if payload != "payload" || attempts != 1 {
+					t.Errorf("claimed %s: %q, attempt %d", id, payload, attempts)
+				}
+				mu.Lock()
+				claimed[id]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != jobs {
+		t.Fatalf("claimed %d jobs, want %d", len(claimed), jobs)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("%s delivered %d times", id, n)
+		}
+	}
+	if pending, scheduled, active, err := q.Stats(ctx); err != nil || pending != 0 || scheduled != 0 || active != jobs {
+		t.Fatalf("stats: %d pending, %d scheduled, %d active, %v", pending, scheduled, active, err)
+	}
+}
+
+func TestRedisQueue_RedeliveryAfterCrash(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	if err := q.Push(ctx, "job", "payload", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// первый владелец взял задачу и упал: аренда истекает
+	_, _, first, err := q.Claim(ctx, 50*time.Millisecond)
+	if err != nil || first != 1 {
+		t.Fatalf("claim: attempt %d, %v", first, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	expired, err := q.Expired(ctx)
+	if err != nil || len(expired) != 1 || expired[0] != "job" {
+		t.Fatalf("expired: %v, %v", expired, err)
+	}
+
+	// два janitor'а забирают задачу одновременно — удаётся одному
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners int
+	)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload, attempts, requeued, ok, err := q.Reclaim(ctx, "job", 3, 0, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				if payload != "payload" || attempts != 1 || !requeued {
+					t.Errorf("reclaimed %q, attempt %d", payload, attempts)
+				}
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Fatalf("reclaimed %d times", winners)
+	}
+
+	// задача снова в очереди и достаётся следующей попыткой
+	id, payload, second, err := q.Claim(ctx, time.Minute)
+	if err != nil || id != "job" || payload != "payload" || second != 2 {
+		t.Fatalf("claim again: %q %q attempt %d, %v", id, payload, second, err)
+	}
+
+	// очнувшийся первый владелец не продлевает и не завершает чужую попытку
+	if ok, err := q.Extend(ctx, "job", first, time.Minute); err != nil || ok {
+		t.Fatalf("stale extend = %v, %v", ok, err)
+	}
+	if ok, err := q.Complete(ctx, "job", first); err != nil || ok {
+		t.Fatalf("stale complete = %v, %v", ok, err)
+	}
+	if ok, err := q.Extend(ctx, "job", second, time.Minute); err != nil || !ok {
+		t.Fatalf("extend = %v, %v", ok, err)
+	}
+	if ok, err := q.Complete(ctx, "job", second); err != nil || !ok {
+		t.Fatalf("complete = %v, %v", ok, err)
+	}
+	if pending, scheduled, active, err := q.Stats(ctx); err != nil || pending+scheduled+active != 0 {
+		t.Fatalf("stats: %d pending, %d scheduled, %d active, %v", pending, scheduled, active, err)
+	}
+}
+
+func TestRedisQueue_CompleteRacesReclaim(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	// владелец завершает задачу в момент, когда её аренда истекла: либо он
+	// успевает её снять, либо janitor забирает её, но не оба
+	for i := range 50 {
+		id := fmt.Sprintf("job-%d", i)
+		if err := q.Push(ctx, id, "payload", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		_, _, attempt, err := q.Claim(ctx, -time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var completed, reclaimed bool
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			completed, _ = q.Complete(ctx, id, attempt)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _, _, reclaimed, _ = q.Reclaim(ctx, id, 3, 0, time.Minute)
+		}()
+		wg.Wait()
+		if completed == reclaimed {
+			t.Fatalf("%s: completed %v, reclaimed %v", id, completed, reclaimed)
+		}
+		if reclaimed {
+			_ = q.Ack(ctx, id)
+		}
+	}
+}
+
+func TestRedisQueue_ReclaimIsOneStep(t *testing.T) {
+	q := newTestQueue(t)
+	ctx := context.Background()
+
+	if err := q.Push(ctx, "job", "payload", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := q.Claim(ctx, -time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// janitor, упавший сразу после reclaim, не теряет задачу: она уже
+	// отложена на attempts*backoff без отдельного Push
+	_, attempts, requeued, ok, err := q.Reclaim(ctx, "job", 2, time.Hour, time.Minute)
+	if err != nil || !ok || !requeued || attempts != 1 {
+		t.Fatalf("reclaim = %d %t %t %v", attempts, requeued, ok, err)
+	}
+	if pending, scheduled, active, err := q.Stats(ctx); err != nil || pending != 0 || scheduled != 1 || active != 0 {
+		t.Fatalf("stats: %d pending, %d scheduled, %d active, %v", pending, scheduled, active, err)
+	}
+
+	// попытки исчерпаны: задача остаётся активной под своей арендой, и её
+	// прежний владелец уже не может её продлить или завершить
+	if err := q.Push(ctx, "job", "payload", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	_, _, second, err := q.Claim(ctx, -time.Millisecond)
+	if err != nil || second != 2 {
+		t.Fatalf("claim: attempt %d, %v", second, err)
+	}
+	_, attempts, requeued, ok, err = q.Reclaim(ctx, "job", 2, time.Hour, 50*time.Millisecond)
+	if err != nil || !ok || requeued || attempts != 2 {
+		t.Fatalf("give up = %d %t %t %v", attempts, requeued, ok, err)
+	}
+	if held, _ := q.Extend(ctx, "job", second, time.Minute); held {
+		t.Fatal("the lost holder extended a job given up")
+	}
+	if expired, _ := q.Expired(ctx); len(expired) != 0 {
+		t.Fatalf("expired while held: %v", expired)
+	}
+
+	// janitor упал до Ack: по истечении аренды задача снова истекшая
+	time.Sleep(100 * time.Millisecond)
+	if _, _, requeued, ok, _ := q.Reclaim(ctx, "job", 2, time.Hour, time.Minute); !ok || requeued {
+		t.Fatalf("reclaim after a janitor crash: ok %t, requeued %t", ok, requeued)
+	}
+	if err := q.Ack(ctx, "job"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	c, err := clients.NewRedisClient(clients.RedisConfig{Addr: env.redisAddr, Prefix: "integration_ratelimit:", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	t.Cleanup(c.Close)
+	ctx := context.Background()
+	key := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	// 120 в минуту — токен каждые 500 мс, запас на два запроса
+	l := clients.NewRateLimiter(c, "user", 120, 2)
+	for i := range 2 {
+		if ok, _, err := l.Allow(ctx, key); err != nil || !ok {
+			t.Fatalf("request %d: %v, %v", i, ok, err)
+		}
+	}
+	ok, wait, err := l.Allow(ctx, key)
+	if err != nil || ok || wait <= 0 || wait > 500*time.Millisecond {
+		t.Fatalf("over the limit: %v, %s, %v", ok, wait, err)
+	}
+	// у другого ключа своё ведро
+	if ok, _, err := l.Allow(ctx, key+"-other"); err != nil || !ok {
+		t.Fatalf("other key: %v, %v", ok, err)
+	}
+
+	time.Sleep(wait + 50*time.Millisecond)
+	if ok, _, err := l.Allow(ctx, key); err != nil || !ok {
+		t.Fatalf("after %s: %v, %v", wait, ok, err)
+	}
+}
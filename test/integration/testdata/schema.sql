@@ -0,0 +1,181 @@
+-- Minimal subset of the main application's schema used by the export queries.
+
+CREATE TABLE users (
+    id          bigserial PRIMARY KEY,
+    first_name  varchar(255),
+    last_name   varchar(255),
+    middle_name varchar(255),
+    username    varchar(255),
+    email       varchar(255),
+    phone       varchar(32),
+    deleted_at  timestamp
+);
+
+CREATE TABLE departments (
+    id           bigserial PRIMARY KEY,
+    display_name varchar(255) NOT NULL
+);
+
+CREATE TABLE department_user (
+    department_id bigint NOT NULL REFERENCES departments (id),
+    user_id       bigint NOT NULL REFERENCES users (id)
+);
+
+CREATE TABLE registries (
+    id     uuid PRIMARY KEY,
+    number varchar(255),
+    date   date
+);
+
+CREATE TABLE counterparties (
+    id   uuid PRIMARY KEY,
+    name varchar(255)
+);
+
+CREATE TABLE debt_statuses (
+    id   bigserial PRIMARY KEY,
+    name varchar(255)
+);
+
+CREATE TABLE debtors (
+    id          uuid PRIMARY KEY,
+    last_name   varchar(255),
+    first_name  varchar(255),
+    middle_name varchar(255),
+    iin         varchar(12)
+);
+
+CREATE TABLE debtor_phones (
+    id         bigserial PRIMARY KEY,
+    debtor_id  uuid NOT NULL REFERENCES debtors (id),
+    phone      varchar(32) NOT NULL,
+    deleted_at timestamp
+);
+
+CREATE TABLE debtor_addresses (
+    id         bigserial PRIMARY KEY,
+    debtor_id  uuid NOT NULL REFERENCES debtors (id),
+    address    text NOT NULL,
+    deleted_at timestamp
+);
+
+CREATE TABLE debts (
+    id                              uuid PRIMARY KEY,
+    number                          varchar(255) NOT NULL,
+    start_date                      date,
+    end_date                        date,
+    filial                          varchar(255),
+    product_name                    varchar(255),
+    amount_currency                 varchar(8),
+    amount_actual_debt              numeric(18,2) NOT NULL DEFAULT 0,
+    amount_purchased_loan           numeric(18,2) NOT NULL DEFAULT 0,
+    init_amount_actual_debt         numeric(18,2) NOT NULL DEFAULT 0,
+    amount_credit                   numeric(18,2) NOT NULL DEFAULT 0,
+    amount_main_debt                numeric(18,2),
+    amount_fine                     numeric(18,2) NOT NULL DEFAULT 0,
+    amount_accrual                  numeric(18,2) NOT NULL DEFAULT 0,
+    amount_government_duty          numeric(18,2) NOT NULL DEFAULT 0,
+    amount_representation_expenses  numeric(18,2) NOT NULL DEFAULT 0,
+    amount_notary_fees              numeric(18,2) NOT NULL DEFAULT 0,
+    amount_postage                  numeric(18,2) NOT NULL DEFAULT 0,
+    transfer_decision               varchar(255),
+    presence_solidarity             boolean NOT NULL DEFAULT false,
+    government_duty_paid            boolean NOT NULL DEFAULT false,
+    government_duty_refund          boolean NOT NULL DEFAULT false,
+    representation_expenses_paid    boolean NOT NULL DEFAULT false,
+    late_due_date                   date,
+    next_contact                    timestamp,
+    last_contact                    timestamp,
+    additional_data                 jsonb,
+    registry_id                     uuid REFERENCES registries (id),
+    counterparty_id                 uuid REFERENCES counterparties (id),
+    status_id                       bigint REFERENCES debt_statuses (id),
+    user_id                         bigint REFERENCES users (id),
+    debtor_id                       uuid REFERENCES debtors (id)
+);
+
+CREATE TABLE guarantors (
+    id          bigserial PRIMARY KEY,
+    debt_id     uuid NOT NULL REFERENCES debts (id),
+    type        varchar(32),
+    last_name   varchar(255),
+    first_name  varchar(255),
+    middle_name varchar(255),
+    iin         varchar(12),
+    phone       varchar(32),
+    deleted_at  timestamp
+);
+
+CREATE TABLE courts (
+    id   bigserial PRIMARY KEY,
+    name varchar(255) NOT NULL
+);
+
+CREATE TABLE court_cases (
+    id          bigserial PRIMARY KEY,
+    debt_id     uuid NOT NULL REFERENCES debts (id),
+    court_id    bigint REFERENCES courts (id),
+    case_number varchar(64),
+    stage       varchar(64),
+    created_at  timestamp DEFAULT now()
+);
+
+CREATE TABLE enforcement_proceedings (
+    id         bigserial PRIMARY KEY,
+    debt_id    uuid NOT NULL REFERENCES debts (id),
+    number     varchar(64),
+    agent_name varchar(255),
+    started_at date
+);
+
+CREATE TABLE action_types (
+    id         varchar(64) PRIMARY KEY,
+    name       varchar(255) NOT NULL DEFAULT '',
+    deleted_at timestamp
+);
+
+CREATE TABLE actions (
+    id             bigserial PRIMARY KEY,
+    debt_id        uuid REFERENCES debts (id),
+    user_id        bigint REFERENCES users (id),
+    debt_status_id bigint REFERENCES debt_statuses (id),
+    next_contact   timestamp,
+    type           varchar(64) NOT NULL,
+    comment        text NOT NULL DEFAULT '',
+    payload        jsonb,
+    created_at     timestamp DEFAULT now(),
+    updated_at     timestamp DEFAULT now(),
+    deleted_at     timestamp
+);
+
+CREATE TABLE payments (
+    id                              uuid PRIMARY KEY,
+    debt_id                         uuid REFERENCES debts (id),
+    user_id                         bigint REFERENCES users (id),
+    amount                          numeric(18,2) NOT NULL DEFAULT 0,
+    amount_after_subtraction        numeric(18,2) NOT NULL DEFAULT 0,
+    amount_government_duty          numeric(18,2) NOT NULL DEFAULT 0,
+    amount_representation_expenses  numeric(18,2) NOT NULL DEFAULT 0,
+    amount_notary_fees              numeric(18,2) NOT NULL DEFAULT 0,
+    amount_postage                  numeric(18,2) NOT NULL DEFAULT 0,
+    amount_accounts_receivable      numeric(18,2) NOT NULL DEFAULT 0,
+    amount_main_debt                numeric(18,2) NOT NULL DEFAULT 0,
+    amount_accrual                  numeric(18,2) NOT NULL DEFAULT 0,
+    amount_fine                     numeric(18,2) NOT NULL DEFAULT 0,
+    confirmed                       boolean NOT NULL DEFAULT false,
+    payment_date                    date,
+    created_at                      timestamp DEFAULT now(),
+    updated_at                      timestamp DEFAULT now(),
+    deleted_at                      timestamp
+);
+
+CREATE TABLE personal_access_tokens (
+    id             bigserial PRIMARY KEY,
+    tokenable_type varchar(255) NOT NULL,
+    tokenable_id   bigint NOT NULL,
+    name           varchar(255) NOT NULL DEFAULT 'test',
+    token          varchar(64) NOT NULL UNIQUE,
+    abilities      text,
+    expires_at     timestamp,
+    created_at     timestamp DEFAULT now()
+);
@@ -0,0 +1,56 @@
+INSERT INTO users (id, first_name, last_name, username, email) VALUES
+    (1, 'Айгуль', 'Сапарова', 'a.saparova', 'a.saparova@example.com'),
+    (2, 'Ерлан', 'Ахметов', 'e.akhmetov', 'e.akhmetov@example.com');
+
+INSERT INTO departments (id, display_name) VALUES (1, 'Soft collection');
+INSERT INTO department_user (department_id, user_id) VALUES (1, 1), (1, 2);
+
+INSERT INTO registries (id, number, date) VALUES
+    ('11111111-1111-1111-1111-111111111111', 'R-001', '2025-01-15');
+INSERT INTO counterparties (id, name) VALUES
+    ('22222222-2222-2222-2222-222222222222', 'Test Bank');
+INSERT INTO debt_statuses (id, name) VALUES (1, 'В работе'), (2, 'Обещание оплаты');
+INSERT INTO debtors (id, last_name, first_name, iin) VALUES
+    ('33333333-3333-3333-3333-333333333331', 'Иванов', 'Иван', '900101300001'),
+    ('33333333-3333-3333-3333-333333333332', 'Петров', 'Пётр', '910202300002');
+INSERT INTO debtor_phones (debtor_id, phone, deleted_at) VALUES
+    ('33333333-3333-3333-3333-333333333331', '+77010000001', NULL),
+    ('33333333-3333-3333-3333-333333333331', '+77000000000', '2025-01-01'),
+    ('33333333-3333-3333-3333-333333333331', '+77270000001', NULL);
+INSERT INTO debtor_addresses (debtor_id, address) VALUES
+    ('33333333-3333-3333-3333-333333333331', 'г. Алматы, ул. Абая, 1');
+
+INSERT INTO debts (id, number, amount_actual_debt, registry_id, counterparty_id, status_id, user_id, debtor_id) VALUES
+    ('44444444-4444-4444-4444-444444444441', 'D-0001', 150000.50, '11111111-1111-1111-1111-111111111111', '22222222-2222-2222-2222-222222222222', 1, 1, '33333333-3333-3333-3333-333333333331'),
+    ('44444444-4444-4444-4444-444444444442', 'D-0002', 99000.00, '11111111-1111-1111-1111-111111111111', '22222222-2222-2222-2222-222222222222', 2, 2, '33333333-3333-3333-3333-333333333332');
+
+INSERT INTO guarantors (debt_id, type, last_name, first_name, iin) VALUES
+    ('44444444-4444-4444-4444-444444444441', 'guarantor', 'Сидоров', 'Сидор', '800303300003');
+
+INSERT INTO courts (id, name) VALUES (1, 'Медеуский районный суд');
+INSERT INTO court_cases (debt_id, court_id, case_number, stage, created_at) VALUES
+    ('44444444-4444-4444-4444-444444444441', 1, '2-100/2024', 'claim', '2024-06-01'),
+    ('44444444-4444-4444-4444-444444444441', 1, '2-555/2025', 'enforcement', '2025-03-01'),
+    ('44444444-4444-4444-4444-444444444442', 1, '2-777/2025', 'claim', '2025-03-02');
+INSERT INTO enforcement_proceedings (debt_id, number, agent_name, started_at) VALUES
+    ('44444444-4444-4444-4444-444444444441', 'ИП-0042', 'Касымов Н.', '2025-04-01');
+
+INSERT INTO action_types (id, name) VALUES
+    ('call', 'Звонок'),
+    ('auto_dial', 'Автодозвон');
+
+INSERT INTO actions (debt_id, user_id, debt_status_id, type, comment, payload) VALUES
+    ('44444444-4444-4444-4444-444444444441', 1, 1, 'call', 'Не дозвонились', '{"recording_url": "calls/2025/01/rec-1.mp3"}'),
+    ('44444444-4444-4444-4444-444444444442', 2, 2, 'call', 'Обещал оплатить', '{}'),
+    ('44444444-4444-4444-4444-444444444442', 2, 2, 'auto_dial', 'Автообзвон', '{}');
+
+INSERT INTO payments (id, debt_id, user_id, amount, confirmed, payment_date) VALUES
+    ('55555555-5555-5555-5555-555555555551', '44444444-4444-4444-4444-444444444441', 1, 5000.00, true, '2025-02-01'),
+    ('55555555-5555-5555-5555-555555555552', '44444444-4444-4444-4444-444444444442', 2, 1200.00, false, '2025-02-03');
+
+-- plain token "1|integration-token" (sha256 of the part after "|")
+INSERT INTO personal_access_tokens (id, tokenable_type, tokenable_id, token, abilities) VALUES
+    (1, 'App\Infrastructure\Persistence\Models\User', 1,
+     encode(sha256('integration-token'::bytea), 'hex'), '["*"]');
+
+SELECT setval('users_id_seq', 10);